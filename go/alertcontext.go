@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AlertContext is the rule an alert was evaluated against, taken from the
+// tracker's parameters at evaluation time, so the message states the
+// thresholds actually in effect instead of a fixed text
+type AlertContext struct {
+	// Rule names the rule, e.g. "latency" or "outage_recovery"
+	Rule string `json:"rule"`
+	// Metric is the evaluated quantity, as shown to the user
+	Metric string `json:"metric"`
+	// Observed is the value that triggered the transition
+	Observed float64 `json:"observed"`
+	// Op compares Observed to Threshold: ">=", ">" or "<"
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	Unit      string  `json:"unit,omitempty"`
+	// Window is how long the condition had to hold, e.g. "連続5回"
+	Window string `json:"window,omitempty"`
+}
+
+// opSymbols renders AlertContext.Op
+var opSymbols = map[string]string{">=": "≥", ">": ">", "<": "<"}
+
+// String renders the context as one line, e.g.
+// "応答時間 187.0ms ≥ 150ms（連続5回）"
+func (c AlertContext) String() string {
+	s := fmt.Sprintf("%s %s%s %s %s%s", c.Metric, formatAlertValue(c.Observed), c.Unit, opSymbols[c.Op], formatAlertValue(c.Threshold), c.Unit)
+	if c.Window != "" {
+		s += fmt.Sprintf("（%s）", c.Window)
+	}
+	return s
+}
+
+// formatAlertValue drops the decimals of whole numbers, so counts read
+// "3回" and fractional values keep one decimal
+func formatAlertValue(v float64) string {
+	if v == float64(int64(v)) {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+// messageLine returns the "判定条件" line appended to alert messages
+func (c AlertContext) messageLine() string {
+	return "\n**判定条件**: " + c.String()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestAlertContextGolden drives each tracker over its threshold with fixed
+// parameters and compares the rendered rule, as appended to the alert, and
+// its JSON with testdata/alertcontext_<rule>.txt
+func TestAlertContextGolden(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+	contexts := map[string]AlertContext{}
+
+	latency := newLatencyTracker(100, 150, 5)
+	for i, ms := range []float64{187, 160, 152.5, 171.25, 187.04, 90, 80, 99.9, 70, 60.4} {
+		if tr := latency.observe(at(i), true, ms); tr != nil {
+			contexts[tr.Context.Rule] = tr.Context
+		}
+	}
+
+	measurement := newMeasurementTracker(25)
+	for i := 0; i < 20; i++ {
+		if tr := measurement.observe(at(i), i%3 == 0); tr != nil {
+			contexts[tr.Context.Rule] = tr.Context
+		}
+	}
+	for i := 20; i < 40; i++ {
+		if tr := measurement.observe(at(i), false); tr != nil {
+			contexts[tr.Context.Rule] = tr.Context
+		}
+	}
+
+	outages := newOutageTracker(3, 2, start)
+	for i, reason := range []failureReason{reasonTimeout, reasonTimeout, reasonTimeout, "", ""} {
+		if tr := outages.observe(at(i), reason, gatewayReachable); tr != nil {
+			contexts[tr.Context.Rule] = tr.Context
+		}
+	}
+
+	// Loss rising 5 points per 5-minute bucket over the last 30 minutes
+	now := start.Add(time.Hour)
+	var aggs []MinuteAggregate
+	for m := 30; m > 0; m-- {
+		bucket := (30 - m) / 5
+		aggs = append(aggs, MinuteAggregate{Minute: now.Add(-time.Duration(m) * time.Minute), Count: 60, Success: 60 - 3*bucket})
+	}
+	if tr := newTrendDetector(2).check(now, func() []MinuteAggregate { return aggs }, false); tr != nil {
+		contexts[tr.Context.Rule] = tr.Context
+	}
+
+	for _, rule := range []string{"latency", "latency_recovery", "measurement_degraded", "measurement_recovered", "outage", "outage_recovery", "loss_trend"} {
+		ctx, ok := contexts[rule]
+		if !ok {
+			t.Errorf("%s did not fire", rule)
+			continue
+		}
+		data, err := json.MarshalIndent(ctx, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "alertcontext_"+rule+".txt", ctx.messageLine()+"\n\n"+string(data)+"\n")
+	}
+}
+
+func TestFormatAlertValue(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{{3, "3"}, {0, "0"}, {187.04, "187.0"}, {33.333, "33.3"}, {-2.5, "-2.5"}, {150, "150"}}
+	for _, tt := range tests {
+		if got := formatAlertValue(tt.v); got != tt.want {
+			t.Errorf("formatAlertValue(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var (
+	at    = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	later = at.Add(90 * time.Second)
+)
+
+var delivery = &ReportDelivery{
+	Date:       "2026-03-09",
+	At:         at,
+	Sent:       true,
+	Confirmed:  true,
+	MessageIDs: []string{"1200000000000000001", "1200000000000000002"},
+	ChannelID:  "1100000000000000000",
+}
+
+var latency = LatencyStats{Count: 3598, Avg: 12.4, Min: 8.1, Max: 85.3, Median: 11.9, P95: 18.2, MAD: 1.3}
+
+// goldens are the outputs as a client sees them, one file each. Every
+// optional field is set, so that a renamed or dropped field changes a file.
+var goldens = map[string]interface{}{
+	"status": StatusResponse{
+		Schema:           SchemaStatus,
+		Site:             "home",
+		TargetIP:         "vpn.example.com",
+		TargetAddress:    "203.0.113.5",
+		Gateways:         []string{"192.168.1.1"},
+		LocalIP:          "192.168.1.10",
+		Internet:         "up",
+		Since:            at,
+		GatewayState:     "reachable",
+		SuccessCount:     3598,
+		UnreachableCount: 2,
+		Notifiers:        map[string]interface{}{"discord": true},
+		Commands:         CommandStats{Runs: 120, Killed: 1, Truncated: 2, Running: 1, MaxProcesses: 8, Skipped: 3, StartedLastMinute: 4},
+		Route:            "192.168.1.1 > 10.0.0.1",
+		Detection: DetectionStatus{
+			GatewaySource: "route",
+			Gateway:       []DetectionAttempt{{Provider: "route", Outcome: "ok", Value: "192.168.1.1"}},
+			LocalIPSource: "config",
+			LocalIP:       []DetectionAttempt{{Provider: "udp", Outcome: "skipped", Detail: "configured"}},
+		},
+		Vantages: []VantageStatus{{
+			Name: "office", Host: "office.example.com", Platform: "linux", Connected: true, Since: at,
+			Reconnects: 1, LastError: "EOF", LastProbe: later, LastRTTMs: 14.2, LastReason: "timeout",
+		}},
+		Targets: []TargetStatus{{
+			Name: "isp-dns", Address: "198.51.100.53",
+			Stats:     ProbeStats{Success: 3598, Failure: 2, Total: 3600, SuccessRate: 99.94, Latency: latency},
+			LastProbe: later, LastRTTMs: 9.8, LastReason: "unreachable",
+		}},
+		Today:   &StatusDay{Date: "2026-03-10", Total: 3600, SuccessRate: 99.94, AvgMs: 12.4, Sufficient: true, PeriodStart: at.Add(-12 * time.Hour), PeriodEnd: at},
+		LastDay: &StatusDay{Date: "2026-03-09", Total: 86400, SuccessRate: 100, AvgMs: 12.1, Sufficient: true, PeriodStart: at.Add(-36 * time.Hour), PeriodEnd: at.Add(-12 * time.Hour)},
+		Capabilities: []Capability{
+			{Name: "icmp", OK: true, Detail: "ping socket"},
+			{Name: "arp", OK: false, Detail: "not permitted", Effect: "no gateway MAC", Optional: true},
+		},
+		PingBackend:    "ICMPソケット",
+		Clock:          &ClockStatus{Waiting: false, Floor: at.Add(-24 * time.Hour), StepSeconds: 3600},
+		ReportDelivery: &ReportDeliveryStatus{Last: delivery, LastConfirmed: delivery},
+	},
+	"verdict":         VerdictResponse{Schema: SchemaVerdict, Internet: "down", Since: at},
+	"health":          HealthResponse{Schema: SchemaHealth, Status: "ok", LastCycle: &later},
+	"profiles":        ProfilesResponse{Schema: SchemaProfiles, Profiles: []string{"lte", "wired"}},
+	"profiles_health": ProfilesHealthResponse{Schema: SchemaProfilesHealth, Profiles: map[string]HealthResponse{"lte": {Status: "stale", LastCycle: &at}, "wired": {Status: "ok", LastCycle: &later}}},
+	"series": SeriesResponse{Schema: SchemaSeries, Target: "isp-dns", Vantage: "office", Minutes: []MinuteAggregate{
+		{Minute: at, Count: 60, Success: 58, Min: 8.1, Avg: 12.4, Max: 85.3, Loss: 3.33, Failures: map[string]int{"timeout": 2}},
+	}},
+	"results": ResultsResponse{Schema: SchemaResults, From: at, To: later, Results: []Result{
+		{Timestamp: at, CompletedAt: at.Add(12 * time.Millisecond), Target: "vpn.example.com", Success: true, ResponseTime: 12.4, Address: "203.0.113.5", GatewayResponseTime: 1.2},
+		{Timestamp: later, CompletedAt: later.Add(2 * time.Second), Target: "vpn.example.com", Simulated: true, Warmup: true, LoadTest: true, Reason: "timeout", Gateway: "reachable"},
+	}},
+	"daily": DailyResponse{Schema: SchemaDaily, Date: "2026-03-10", Hours: []HourlyAggregate{{
+		Hour: 12, Count: 3600, Failures: 2, Avg: 12.4, P95: 18.2, RxBps: 1.5e6, TxBps: 2e5, IfaceSamples: 3600,
+		Gateway:         &GatewayCounts{Reachable: 1, Unreachable: 1},
+		WorstMinuteLoss: 3.33, WorstMinuteAt: &at, Connection: "wired",
+	}}},
+	"failures": FailuresResponse{Schema: SchemaFailures, Target: "vpn.example.com", Failures: []FailureOutput{
+		{Time: at, Address: "203.0.113.5", Reason: "timeout", Output: "Request timeout for icmp_seq 0\n", Truncated: true},
+	}},
+	"audit": AuditResponse{Schema: SchemaAudit, Entries: []AuditEntry{
+		{Time: at, Action: "simulate_outage", Actor: "token:ops", Source: "127.0.0.1", Detail: map[string]string{"duration": "5m"}},
+		{Time: later, Action: "config", Actor: "signal", Old: "1s", New: "2s"},
+	}},
+	"simulate_outage": SimulateOutageResponse{Schema: SchemaSimulate, Target: "vpn.example.com", Until: later},
+	"report_delivery": ResendReportResponse{Schema: SchemaReportDelivery, ReportDelivery: &ReportDelivery{
+		Date: "2026-03-09", At: later, Sent: true, MessageIDs: []string{"1200000000000000003"}, Error: "no message ID returned", Resent: true,
+	}},
+	"schemas": SchemaResponse{Schema: SchemaSchemas, Schemas: []SchemaInfo{{ID: SchemaStatus, Where: "GET /status"}}},
+}
+
+// TestGolden compares each output with its file in testdata; go test
+// -update rewrites the files after an intended change
+func TestGolden(t *testing.T) {
+	for name, v := range goldens {
+		got, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got = append(got, '\n')
+		path := filepath.Join("testdata", name+".json")
+		if *update {
+			if err := os.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v (run go test -update)", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from %s:\n%s", name, path, got)
+		}
+	}
+}
+
+// TestGoldenDecode decodes each file as a client would, rejecting fields
+// the type does not know, and checks that nothing is lost on the way
+func TestGoldenDecode(t *testing.T) {
+	for name, v := range goldens {
+		data, err := os.ReadFile(filepath.Join("testdata", name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := reflect.New(reflect.TypeOf(v))
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(decoded.Interface()); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded.Elem().Interface(), v) {
+			t.Errorf("%s: decoded %+v, want %+v", name, decoded.Elem().Interface(), v)
+		}
+	}
+}
+
+// TestSchemaIDs checks the form "pingcheck.<name>/<version>" of every ID
+func TestSchemaIDs(t *testing.T) {
+	seen := map[string]bool{}
+	for _, v := range goldens {
+		id := reflect.ValueOf(v).FieldByName("Schema").String()
+		name, version, ok := bytes.Cut([]byte(id), []byte("/"))
+		if !ok || !bytes.HasPrefix(name, []byte("pingcheck.")) || len(version) == 0 {
+			t.Errorf("schema %q is not pingcheck.<name>/<version>", id)
+		}
+		if seen[id] {
+			t.Errorf("schema %q used twice", id)
+		}
+		seen[id] = true
+	}
+}
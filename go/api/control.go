@@ -0,0 +1,72 @@
+package api
+
+import "time"
+
+// AuditResponse represents the /audit response
+type AuditResponse struct {
+	Schema  string       `json:"schema"`
+	Entries []AuditEntry `json:"entries"`
+}
+
+// AuditEntry is one control action. Actor says who (an API token, the
+// control socket, a Discord user, a signal) and Source where from.
+type AuditEntry struct {
+	Time   time.Time         `json:"time"`
+	Action string            `json:"action"`
+	Actor  string            `json:"actor"`
+	Source string            `json:"source,omitempty"`
+	Detail map[string]string `json:"detail,omitempty"`
+	// Old and New are the values an action changed, when it changed one
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// FailuresResponse represents the /debug/failures response
+type FailuresResponse struct {
+	Schema   string          `json:"schema"`
+	Target   string          `json:"target"`
+	Failures []FailureOutput `json:"failures"`
+}
+
+// FailureOutput is the raw output of one failed probe
+type FailureOutput struct {
+	Time    time.Time `json:"time"`
+	Address string    `json:"address,omitempty"`
+	Reason  string    `json:"reason"`
+	Output  string    `json:"output"`
+	// Truncated is set when Output was cut to the size kept per failure
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// SimulateOutageResponse represents the /simulate/outage response
+type SimulateOutageResponse struct {
+	Schema string    `json:"schema"`
+	Target string    `json:"target"`
+	Until  time.Time `json:"until"`
+}
+
+// ResendReportResponse represents the /report/resend response, the new
+// delivery
+type ResendReportResponse struct {
+	Schema string `json:"schema"`
+	*ReportDelivery
+}
+
+// ReportDelivery records how a daily report was handed to Discord. With
+// ?wait=true the webhook answers with the created message; only its ID
+// confirms that the message exists, since a webhook whose thread was
+// deleted or whose channel lost permissions can still accept the post.
+type ReportDelivery struct {
+	Date string    `json:"date"`
+	At   time.Time `json:"at"`
+	// Sent is set when Discord accepted every part, Confirmed when it also
+	// returned the ID of every part
+	Sent       bool     `json:"sent"`
+	Confirmed  bool     `json:"confirmed"`
+	MessageIDs []string `json:"message_ids,omitempty"`
+	ChannelID  string   `json:"channel_id,omitempty"`
+	// Error is why the send failed or could not be confirmed
+	Error string `json:"error,omitempty"`
+	// Resent is set for a delivery made by the resend command
+	Resent bool `json:"resent,omitempty"`
+}
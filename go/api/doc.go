@@ -0,0 +1,6 @@
+// Package api holds the versioned JSON outputs of the monitor, the
+// responses of its HTTP API and the records of its results file, so that
+// clients can decode them with the same types. A type here changes only
+// as its schema allows: new fields keep the version, anything else needs
+// a new one.
+package api
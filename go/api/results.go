@@ -0,0 +1,96 @@
+package api
+
+import "time"
+
+// Result is one probe result, a record of the JSONL results file and an
+// event of /api/v1/stream
+type Result struct {
+	// Timestamp is when the probe was sent; CompletedAt when its answer
+	// or timeout came back
+	Timestamp    time.Time `json:"timestamp"`
+	CompletedAt  time.Time `json:"completed_at,omitempty"`
+	Target       string    `json:"target"`
+	Success      bool      `json:"success"`
+	ResponseTime float64   `json:"response_time_ms,omitempty"`
+	Simulated    bool      `json:"simulated,omitempty"`
+	Warmup       bool      `json:"warmup,omitempty"`
+	// LoadTest marks probes sent during the bufferbloat test's load
+	LoadTest bool `json:"load_test,omitempty"`
+	// Address is set when the probed address differs from the target
+	Address string `json:"address,omitempty"`
+	// Reason classifies failures (timeout, unreachable, dns, ...)
+	Reason string `json:"reason,omitempty"`
+	// GatewayResponseTime is the gateway's response time in the same cycle
+	// (paired_gateway_probe)
+	GatewayResponseTime float64 `json:"gateway_response_time_ms,omitempty"`
+	// Gateway is the gateway diagnostic taken with a failure
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// ResultsResponse represents the /api/v1/results response
+type ResultsResponse struct {
+	Schema  string    `json:"schema"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Results []Result  `json:"results"`
+}
+
+// DailyResponse represents the /api/v1/daily response
+type DailyResponse struct {
+	Schema string            `json:"schema"`
+	Date   string            `json:"date"`
+	Hours  []HourlyAggregate `json:"hours"`
+}
+
+// HourlyAggregate summarizes the probes of one hour of a day
+type HourlyAggregate struct {
+	Hour     int     `json:"hour"`
+	Count    int     `json:"count"`
+	Failures int     `json:"failures"`
+	Avg      float64 `json:"avg_ms"`
+	P95      float64 `json:"p95_ms"`
+	// Average interface throughput over samples that had counters
+	RxBps        float64 `json:"avg_rx_bps,omitempty"`
+	TxBps        float64 `json:"avg_tx_bps,omitempty"`
+	IfaceSamples int     `json:"iface_samples,omitempty"`
+	// Gateway splits the failures by the gateway diagnostic
+	Gateway *GatewayCounts `json:"gateway,omitempty"`
+	// WorstMinuteLoss is the highest loss of a one-minute window starting
+	// in the hour, at WorstMinuteAt
+	WorstMinuteLoss float64    `json:"worst_minute_loss_percent,omitempty"`
+	WorstMinuteAt   *time.Time `json:"worst_minute_at,omitempty"`
+	// Connection is the type of link in effect for most of the hour
+	Connection string `json:"connection,omitempty"`
+}
+
+// GatewayCounts splits failed samples by the gateway diagnostic taken with
+// them. Unchecked covers samples with no gateway to ping, e.g. before
+// detection succeeded; a gateway that is the target counts as unreachable,
+// since the failed probe was its check.
+type GatewayCounts struct {
+	Reachable   int `json:"reachable"`
+	Unreachable int `json:"unreachable"`
+	Unchecked   int `json:"unchecked"`
+}
+
+// SeriesResponse represents the /api/v1/series response
+type SeriesResponse struct {
+	Schema string `json:"schema"`
+	Target string `json:"target"`
+	// Vantage is the remote vantage point the series was measured from
+	Vantage string            `json:"vantage,omitempty"`
+	Minutes []MinuteAggregate `json:"minutes"`
+}
+
+// MinuteAggregate summarizes the samples of one minute
+type MinuteAggregate struct {
+	Minute  time.Time `json:"minute"`
+	Count   int       `json:"count"`
+	Success int       `json:"success"`
+	Min     float64   `json:"min_ms"`
+	Avg     float64   `json:"avg_ms"`
+	Max     float64   `json:"max_ms"`
+	Loss    float64   `json:"loss_percent"`
+	// Failures counts the minute's failures per reason, for stacked series
+	Failures map[string]int `json:"failures,omitempty"`
+}
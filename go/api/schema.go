@@ -0,0 +1,36 @@
+package api
+
+// Schema identifiers of the JSON responses, "pingcheck.<name>/<version>".
+// Each response carries its identifier in "schema". The version goes up
+// only when a field is removed or renamed or its meaning changes; new
+// fields keep it, so clients must ignore fields they do not know.
+const (
+	SchemaStatus         = "pingcheck.status/1"
+	SchemaVerdict        = "pingcheck.verdict/1"
+	SchemaHealth         = "pingcheck.health/1"
+	SchemaSeries         = "pingcheck.series/1"
+	SchemaResults        = "pingcheck.results/1"
+	SchemaDaily          = "pingcheck.daily/1"
+	SchemaFailures       = "pingcheck.failures/1"
+	SchemaAudit          = "pingcheck.audit/1"
+	SchemaSimulate       = "pingcheck.simulate_outage/1"
+	SchemaReportDelivery = "pingcheck.report_delivery/1"
+	SchemaProfiles       = "pingcheck.profiles/1"
+	SchemaProfilesHealth = "pingcheck.profiles_health/1"
+	SchemaSchemas        = "pingcheck.schemas/1"
+)
+
+// SchemaResponse represents the /schema response
+type SchemaResponse struct {
+	Schema  string       `json:"schema"`
+	Schemas []SchemaInfo `json:"schemas"`
+}
+
+// SchemaInfo is one output listed by /schema
+type SchemaInfo struct {
+	// ID is the value of the output's "schema", or of schema_version for
+	// the files that had one before, e.g. "pingcheck.report_file/1"
+	ID string `json:"id"`
+	// Where the output is served or written
+	Where string `json:"where"`
+}
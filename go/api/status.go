@@ -0,0 +1,187 @@
+package api
+
+import "time"
+
+// StatusResponse represents the /status response
+type StatusResponse struct {
+	Schema           string                 `json:"schema"`
+	Site             string                 `json:"site"`
+	TargetIP         string                 `json:"target_ip"`
+	TargetAddress    string                 `json:"target_address"`
+	Gateways         []string               `json:"gateways"`
+	LocalIP          string                 `json:"local_ip"`
+	Internet         string                 `json:"internet"`
+	Since            time.Time              `json:"since"`
+	GatewayState     string                 `json:"gateway_state"`
+	SuccessCount     int                    `json:"success_count"`
+	UnreachableCount int                    `json:"unreachable_count"`
+	Notifiers        map[string]interface{} `json:"notifiers"`
+	Commands         CommandStats           `json:"commands"`
+	// Route is the confirmed path of the first hops ("hop1 > hop2")
+	Route string `json:"route,omitempty"`
+	// Detection tells how the gateway and local IP were found at startup
+	Detection DetectionStatus `json:"detection"`
+	// Vantages are the remote vantage points probing the same target
+	Vantages []VantageStatus `json:"vantages,omitempty"`
+	// Targets are the additional targets of the targets section
+	Targets []TargetStatus `json:"targets,omitempty"`
+	// Today is the day so far and LastDay the last finished day, which a
+	// peer monitor compares with its own
+	Today   *StatusDay `json:"today,omitempty"`
+	LastDay *StatusDay `json:"last_day,omitempty"`
+	// Capabilities are the startup checks of commands and permissions
+	Capabilities []Capability `json:"capabilities,omitempty"`
+	// PingBackend tells how pings are sent, e.g. "pingコマンド"
+	PingBackend string `json:"ping_backend"`
+	// Clock tells whether reports wait for the clock to be set
+	Clock *ClockStatus `json:"clock,omitempty"`
+	// ReportDelivery is the last daily report sent to Discord and the last
+	// one Discord confirmed
+	ReportDelivery *ReportDeliveryStatus `json:"report_delivery,omitempty"`
+}
+
+// StatusDay summarizes one day of a monitor for /status
+type StatusDay struct {
+	Date        string  `json:"date"`
+	Total       int     `json:"total"`
+	SuccessRate float64 `json:"success_rate"`
+	AvgMs       float64 `json:"avg_ms"`
+	Sufficient  bool    `json:"sufficient"`
+	// PeriodStart and PeriodEnd bound the day, up to now for the current
+	// one; the end is exclusive
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+}
+
+// TargetStatus is one additional target in /status
+type TargetStatus struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Stats covers the current period
+	Stats      ProbeStats `json:"stats"`
+	LastProbe  time.Time  `json:"last_probe,omitempty"`
+	LastRTTMs  float64    `json:"last_rtt_ms,omitempty"`
+	LastReason string     `json:"last_reason,omitempty"`
+}
+
+// VantageStatus is one vantage point in /status
+type VantageStatus struct {
+	Name      string    `json:"name"`
+	Host      string    `json:"host"`
+	Platform  string    `json:"platform,omitempty"`
+	Connected bool      `json:"connected"`
+	Since     time.Time `json:"since,omitempty"`
+	// Reconnects counts sessions opened after the first one
+	Reconnects int       `json:"reconnects"`
+	LastError  string    `json:"last_error,omitempty"`
+	LastProbe  time.Time `json:"last_probe,omitempty"`
+	LastRTTMs  float64   `json:"last_rtt_ms,omitempty"`
+	LastReason string    `json:"last_reason,omitempty"`
+}
+
+// DetectionStatus tells how the gateway and local IP were found, for
+// /status. Source is the provider that answered, "config" for an explicit
+// value, "off" when disabled, or "" when nothing was found.
+type DetectionStatus struct {
+	GatewaySource string             `json:"gateway_source"`
+	Gateway       []DetectionAttempt `json:"gateway"`
+	LocalIPSource string             `json:"local_ip_source"`
+	LocalIP       []DetectionAttempt `json:"local_ip"`
+}
+
+// DetectionAttempt is the outcome of one provider at startup
+type DetectionAttempt struct {
+	Provider string `json:"provider"`
+	Outcome  string `json:"outcome"`
+	Value    string `json:"value,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// Capability is one startup check of what the host allows
+type Capability struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Detail explains the result
+	Detail string `json:"detail,omitempty"`
+	// Effect is what the monitor does without it
+	Effect string `json:"effect,omitempty"`
+	// Optional checks are informational; failing them disables nothing
+	Optional bool `json:"optional,omitempty"`
+}
+
+// ClockStatus is the "clock" section of /status, present while waiting for
+// the clock or after it was set
+type ClockStatus struct {
+	// Waiting is set while reports are held back
+	Waiting bool      `json:"waiting"`
+	Floor   time.Time `json:"floor"`
+	// StepSeconds is how far the clock was set forward
+	StepSeconds float64 `json:"step_seconds,omitempty"`
+}
+
+// CommandStats counts misbehaving external commands, for /status
+type CommandStats struct {
+	Runs      int64 `json:"runs"`
+	Killed    int64 `json:"killed"`
+	Truncated int64 `json:"truncated"`
+	// Running is the commands running now, at most MaxProcesses; Skipped
+	// counts those not started because the cap was reached
+	Running      int64 `json:"running"`
+	MaxProcesses int   `json:"max_processes"`
+	Skipped      int64 `json:"skipped"`
+	// StartedLastMinute is the commands started in the last 60 seconds
+	StartedLastMinute int64 `json:"started_last_minute"`
+}
+
+// ReportDeliveryStatus is the "report_delivery" section of /status
+type ReportDeliveryStatus struct {
+	Last          *ReportDelivery `json:"last,omitempty"`
+	LastConfirmed *ReportDelivery `json:"last_confirmed,omitempty"`
+}
+
+// ProbeStats summarizes reachability and latency of a series of probes
+type ProbeStats struct {
+	Success     int          `json:"success"`
+	Failure     int          `json:"failure"`
+	Total       int          `json:"total"`
+	SuccessRate float64      `json:"success_rate"`
+	Latency     LatencyStats `json:"latency"`
+}
+
+// LatencyStats summarizes a set of response times in milliseconds
+type LatencyStats struct {
+	Count  int     `json:"count"`
+	Avg    float64 `json:"avg_ms"`
+	Min    float64 `json:"min_ms"`
+	Max    float64 `json:"max_ms"`
+	Median float64 `json:"median_ms"`
+	P95    float64 `json:"p95_ms"`
+	MAD    float64 `json:"mad_ms"`
+}
+
+// VerdictResponse represents the /verdict response
+type VerdictResponse struct {
+	Schema   string    `json:"schema"`
+	Internet string    `json:"internet"`
+	Since    time.Time `json:"since"`
+}
+
+// HealthResponse represents the /healthz response
+type HealthResponse struct {
+	// Schema is left out of the monitors listed by the profiles' /healthz
+	Schema    string     `json:"schema,omitempty"`
+	Status    string     `json:"status"`
+	LastCycle *time.Time `json:"last_cycle,omitempty"`
+}
+
+// ProfilesResponse represents the /profiles response
+type ProfilesResponse struct {
+	Schema   string   `json:"schema"`
+	Profiles []string `json:"profiles"`
+}
+
+// ProfilesHealthResponse represents the /healthz response with profiles
+type ProfilesHealthResponse struct {
+	Schema   string                    `json:"schema"`
+	Profiles map[string]HealthResponse `json:"profiles"`
+}
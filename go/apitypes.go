@@ -0,0 +1,86 @@
+package main
+
+import "ping-monitor/api"
+
+// The JSON outputs are defined in package api, so that clients can decode
+// them with the same types; the aliases keep their names short here.
+type (
+	AuditEntry             = api.AuditEntry
+	AuditResponse          = api.AuditResponse
+	Capability             = api.Capability
+	ClockStatus            = api.ClockStatus
+	CommandStats           = api.CommandStats
+	DailyResponse          = api.DailyResponse
+	FailureOutput          = api.FailureOutput
+	FailuresResponse       = api.FailuresResponse
+	HealthResponse         = api.HealthResponse
+	LatencyStats           = api.LatencyStats
+	MinuteAggregate        = api.MinuteAggregate
+	ProbeStats             = api.ProbeStats
+	ProfilesHealthResponse = api.ProfilesHealthResponse
+	ProfilesResponse       = api.ProfilesResponse
+	ReportDelivery         = api.ReportDelivery
+	ReportDeliveryStatus   = api.ReportDeliveryStatus
+	ResendReportResponse   = api.ResendReportResponse
+	ResultsResponse        = api.ResultsResponse
+	SchemaInfo             = api.SchemaInfo
+	SchemaResponse         = api.SchemaResponse
+	SeriesResponse         = api.SeriesResponse
+	SimulateOutageResponse = api.SimulateOutageResponse
+	StatusDay              = api.StatusDay
+	StatusResponse         = api.StatusResponse
+	TargetStatus           = api.TargetStatus
+	VantageStatus          = api.VantageStatus
+	VerdictResponse        = api.VerdictResponse
+)
+
+// toAPIDetection copies the startup detection into its /status form
+func toAPIDetection(d DetectionStatus) api.DetectionStatus {
+	attempts := func(in []DetectionAttempt) []api.DetectionAttempt {
+		out := make([]api.DetectionAttempt, len(in))
+		for i, a := range in {
+			out[i] = api.DetectionAttempt(a)
+		}
+		return out
+	}
+	return api.DetectionStatus{
+		GatewaySource: d.GatewaySource,
+		Gateway:       attempts(d.Gateway),
+		LocalIPSource: d.LocalIPSource,
+		LocalIP:       attempts(d.LocalIP),
+	}
+}
+
+// toAPIResults copies stored records into their /api/v1/results form
+func toAPIResults(records []ResultRecord) []api.Result {
+	out := make([]api.Result, len(records))
+	for i, rec := range records {
+		out[i] = api.Result(rec)
+	}
+	return out
+}
+
+// toAPIHours copies hourly aggregates into their /api/v1/daily form
+func toAPIHours(hours []HourlyAggregate) []api.HourlyAggregate {
+	out := make([]api.HourlyAggregate, len(hours))
+	for i, h := range hours {
+		out[i] = api.HourlyAggregate{
+			Hour:            h.Hour,
+			Count:           h.Count,
+			Failures:        h.Failures,
+			Avg:             h.Avg,
+			P95:             h.P95,
+			RxBps:           h.RxBps,
+			TxBps:           h.TxBps,
+			IfaceSamples:    h.IfaceSamples,
+			WorstMinuteLoss: h.WorstMinuteLoss,
+			WorstMinuteAt:   h.WorstMinuteAt,
+			Connection:      string(h.Connection),
+		}
+		if h.Gateway != nil {
+			gw := api.GatewayCounts(*h.Gateway)
+			out[i].Gateway = &gw
+		}
+	}
+	return out
+}
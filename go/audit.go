@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"ping-monitor/api"
+)
+
+const (
+	// auditKeep is how many recent entries /audit returns
+	auditKeep = 200
+	// auditMaxSizeMB and auditFiles cap audit.jsonl and its rotations
+	auditMaxSizeMB = 1
+	auditFiles     = 3
+)
+
+// auditLog records control actions to state_dir/audit.jsonl, rotated by
+// size, and keeps the recent ones in memory for /audit. Without a
+// state_dir only the memory is kept.
+type auditLog struct {
+	mutex  sync.Mutex
+	file   *rotatingWriter
+	recent []AuditEntry
+	// count is the number of actions of the current period
+	count int
+}
+
+// auditLogPath returns the audit log of stateDir
+func auditLogPath(stateDir string) string {
+	return filepath.Join(stateDir, "audit.jsonl")
+}
+
+// newAuditLog opens the audit log of stateDir and loads its recent entries
+func newAuditLog(stateDir string) *auditLog {
+	a := &auditLog{}
+	if stateDir == "" {
+		return a
+	}
+	path := auditLogPath(stateDir)
+	a.recent = readAuditTail(path, auditKeep)
+	w, err := openRotatingWriter(&logFileOptions{path: path, maxSizeMB: auditMaxSizeMB, keep: auditFiles}, os.Stderr)
+	if err != nil {
+		fmt.Printf("❌ 監査ログ %s を開けません: %v\n", path, err)
+		return a
+	}
+	a.file = w
+	return a
+}
+
+// readAuditTail returns the last n entries of the file at path
+func readAuditTail(path string, n int) []AuditEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries
+}
+
+// record appends e, stamped with the current time if it has none
+func (a *auditLog) record(e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.recent = append(a.recent, e)
+	if len(a.recent) > auditKeep {
+		a.recent = a.recent[len(a.recent)-auditKeep:]
+	}
+	a.count++
+	if a.file != nil {
+		data, _ := json.Marshal(e)
+		a.file.Write(append(data, '\n'))
+	}
+}
+
+// entries returns the recent entries, newest first
+func (a *auditLog) entries() []AuditEntry {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	out := make([]AuditEntry, len(a.recent))
+	for i, e := range a.recent {
+		out[len(a.recent)-1-i] = e
+	}
+	return out
+}
+
+// periodCount returns the number of actions of the current period
+func (a *auditLog) periodCount() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.count
+}
+
+// resetCount starts a new period
+func (a *auditLog) resetCount() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.count = 0
+}
+
+// signalActor names a signal as an actor, by its usual name
+func signalActor(sig os.Signal) string {
+	switch sig {
+	case syscall.SIGHUP:
+		return "signal:SIGHUP"
+	case syscall.SIGINT:
+		return "signal:SIGINT"
+	case syscall.SIGTERM:
+		return "signal:SIGTERM"
+	}
+	return "signal:" + sig.String()
+}
+
+// close closes the file
+func (a *auditLog) close() {
+	if a.file != nil {
+		a.file.Close()
+	}
+}
+
+// recordAudit writes one entry straight to the audit log of stateDir, for
+// actions taken before the monitor exists
+func recordAudit(stateDir string, e AuditEntry) {
+	if stateDir == "" {
+		return
+	}
+	a := newAuditLog(stateDir)
+	a.record(e)
+	a.close()
+}
+
+// apiTokenKey marks a request with the name of the token it carried
+type apiTokenKey struct{}
+
+// withTokenName returns r carrying the name of its API token
+func withTokenName(r *http.Request, name string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, name))
+}
+
+// requestActor names who sent r: the API token, or the control socket
+func requestActor(r *http.Request) string {
+	if fromControl(r) {
+		return "control-socket"
+	}
+	if name, ok := r.Context().Value(apiTokenKey{}).(string); ok {
+		return "token:" + name
+	}
+	return "anonymous"
+}
+
+// auditRequest records an action taken through the API
+func (s *apiServer) auditRequest(r *http.Request, action string, detail map[string]string) {
+	s.pm.audit.record(AuditEntry{Action: action, Actor: requestActor(r), Source: r.RemoteAddr, Detail: detail})
+}
+
+// handleAudit returns the recent control actions, newest first
+func (s *apiServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, AuditResponse{Schema: api.SchemaAudit, Entries: s.pm.audit.entries()})
+}
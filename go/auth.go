@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiScope is what a token may do. Scopes are ordered: control includes
+// read, and admin includes both.
+type apiScope string
+
+const (
+	// scopeRead covers the status, results and reports
+	scopeRead apiScope = "read"
+	// scopeControl covers endpoints that change what the monitor does
+	scopeControl apiScope = "control"
+	// scopeAdmin covers the configuration and internal state
+	scopeAdmin apiScope = "admin"
+)
+
+// apiScopes lists the scopes from the narrowest
+var apiScopes = []apiScope{scopeRead, scopeControl, scopeAdmin}
+
+// rank returns the position of s in apiScopes, or -1 if it is unknown
+func (s apiScope) rank() int {
+	for i, scope := range apiScopes {
+		if scope == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// APITokenConfig is one token of api_tokens. The token is given inline or
+// read from TokenFile, so it can stay out of the config file.
+type APITokenConfig struct {
+	Name      string   `json:"name"`
+	Token     string   `json:"token" secret:"true"`
+	TokenFile string   `json:"token_file"`
+	Scopes    []string `json:"scopes"`
+}
+
+// grants reports whether the token holds scope or a broader one
+func (t APITokenConfig) grants(scope apiScope) bool {
+	for _, s := range t.Scopes {
+		if apiScope(s).rank() >= scope.rank() {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokens returns every token accepted by the API; api_token is an
+// admin token
+func (c Config) apiTokens() []APITokenConfig {
+	tokens := c.APITokens
+	if c.APIToken != "" {
+		tokens = append([]APITokenConfig{{Name: "api_token", Token: c.APIToken, Scopes: []string{string(scopeAdmin)}}}, tokens...)
+	}
+	return tokens
+}
+
+// clientToken returns the token the command line tools use: the one with
+// the broadest scope
+func (c Config) clientToken() string {
+	best, rank := "", -1
+	for _, t := range c.apiTokens() {
+		for _, s := range t.Scopes {
+			if r := apiScope(s).rank(); r > rank {
+				best, rank = t.Token, r
+			}
+		}
+	}
+	return best
+}
+
+// loadTokenFiles reads the tokens given by token_file
+func loadTokenFiles(tokens []APITokenConfig) error {
+	for i, t := range tokens {
+		if t.TokenFile == "" {
+			continue
+		}
+		data, err := os.ReadFile(t.TokenFile)
+		if err != nil {
+			return fmt.Errorf("api_tokens.%s のトークンファイルを読み込めません: %v", t.Name, err)
+		}
+		tokens[i].Token = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// validateAPITokens checks names, tokens and scopes of api_tokens
+func validateAPITokens(tokens []APITokenConfig) error {
+	names := make(map[string]bool)
+	for i, t := range tokens {
+		if t.Name == "" {
+			return fmt.Errorf("api_tokens[%d].name を指定してください", i)
+		}
+		if names[t.Name] || t.Name == "api_token" {
+			return fmt.Errorf("api_tokens の名前 %q が重複しています", t.Name)
+		}
+		names[t.Name] = true
+		if t.Token == "" {
+			return fmt.Errorf("api_tokens.%s の token または token_file を指定してください", t.Name)
+		}
+		if len(t.Scopes) == 0 {
+			return fmt.Errorf("api_tokens.%s の scopes を指定してください（read/control/admin）", t.Name)
+		}
+		for _, s := range t.Scopes {
+			if apiScope(s).rank() < 0 {
+				return fmt.Errorf("api_tokens.%s の scopes の値が正しくありません: %q (read/control/admin)", t.Name, s)
+			}
+		}
+	}
+	return nil
+}
+
+// requireScope rejects requests without a token holding scope, except
+// those over the control socket. An unknown token is 401; a known one
+// lacking the scope is 403 naming the scope needed.
+func (s *apiServer) requireScope(scope apiScope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if fromControl(r) {
+			next(w, r)
+			return
+		}
+		tokens := s.pm.config.apiTokens()
+		if len(tokens) == 0 {
+			http.Error(w, "api_token is not configured", http.StatusForbidden)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var token *APITokenConfig
+		for i, t := range tokens {
+			if ok && subtle.ConstantTimeCompare([]byte(given), []byte(t.Token)) == 1 {
+				token = &tokens[i]
+				break
+			}
+		}
+		if token == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !token.grants(scope) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			http.Error(w, fmt.Sprintf("insufficient scope: %s required", scope), http.StatusForbidden)
+			return
+		}
+		next(w, withTokenName(r, token.Name))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIScopeGrants(t *testing.T) {
+	if scopeRead.rank() >= scopeControl.rank() || scopeControl.rank() >= scopeAdmin.rank() || apiScope("root").rank() != -1 {
+		t.Fatal("scopes out of order")
+	}
+	tests := []struct {
+		scopes []string
+		want   []apiScope
+	}{
+		{[]string{"read"}, []apiScope{scopeRead}},
+		{[]string{"control"}, []apiScope{scopeRead, scopeControl}},
+		{[]string{"admin"}, []apiScope{scopeRead, scopeControl, scopeAdmin}},
+		{[]string{"read", "control"}, []apiScope{scopeRead, scopeControl}},
+		// Scope names are exact
+		{[]string{"Admin", "root"}, nil},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		token := APITokenConfig{Scopes: tt.scopes}
+		for _, scope := range apiScopes {
+			want := false
+			for _, s := range tt.want {
+				want = want || s == scope
+			}
+			if token.grants(scope) != want {
+				t.Errorf("%v grants %s: %v", tt.scopes, scope, !want)
+			}
+		}
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	cfg := Config{APITokens: []APITokenConfig{
+		{Name: "dash", Token: "r", Scopes: []string{"read"}},
+		{Name: "ops", Token: "c", Scopes: []string{"read", "control"}},
+	}}
+	if got := cfg.clientToken(); got != "c" {
+		t.Errorf("client token %q, want the control one", got)
+	}
+	// api_token is an admin token
+	cfg.APIToken = "a"
+	if got := cfg.clientToken(); got != "a" {
+		t.Errorf("client token %q, want api_token", got)
+	}
+	if got := (Config{}).clientToken(); got != "" {
+		t.Errorf("client token %q without tokens", got)
+	}
+}
+
+func TestValidateAPITokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []APITokenConfig
+		want   string
+	}{
+		{"valid", []APITokenConfig{{Name: "a", Token: "x", Scopes: []string{"read"}}, {Name: "b", Token: "y", Scopes: []string{"admin"}}}, ""},
+		{"no name", []APITokenConfig{{Token: "x", Scopes: []string{"read"}}}, "api_tokens[0].name"},
+		{"duplicate", []APITokenConfig{{Name: "a", Token: "x", Scopes: []string{"read"}}, {Name: "a", Token: "y", Scopes: []string{"read"}}}, "重複"},
+		{"reserved name", []APITokenConfig{{Name: "api_token", Token: "x", Scopes: []string{"read"}}}, "重複"},
+		{"no token", []APITokenConfig{{Name: "a", Scopes: []string{"read"}}}, "token または token_file"},
+		{"no scopes", []APITokenConfig{{Name: "a", Token: "x"}}, "scopes を指定"},
+		{"unknown scope", []APITokenConfig{{Name: "a", Token: "x", Scopes: []string{"write"}}}, `"write"`},
+	}
+	for _, tt := range tests {
+		err := validateAPITokens(tt.tokens)
+		if (tt.want == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestRequireScope serves one endpoint of each scope and tries every token
+// on it
+func TestRequireScope(t *testing.T) {
+	quietStdout(t)
+	pm, _ := newTestMonitor(t, map[string]interface{}{
+		"api_token": "legacy-secret",
+		"api_tokens": []map[string]interface{}{
+			{"name": "dash", "token": "read-secret", "scopes": []string{"read"}},
+			{"name": "ops", "token": "control-secret", "scopes": []string{"control"}},
+		},
+	}, time.Now())
+	s := newAPIServer(pm)
+	var actor string
+	ok := func(w http.ResponseWriter, r *http.Request) { actor = requestActor(r) }
+	endpoints := map[apiScope]http.HandlerFunc{
+		scopeRead:    s.requireScope(scopeRead, ok),
+		scopeControl: s.requireScope(scopeControl, ok),
+		scopeAdmin:   s.requireScope(scopeAdmin, ok),
+	}
+	tests := []struct {
+		auth  string
+		scope apiScope
+		want  int
+		actor string
+	}{
+		{"Bearer read-secret", scopeRead, http.StatusOK, "token:dash"},
+		{"Bearer read-secret", scopeControl, http.StatusForbidden, ""},
+		{"Bearer control-secret", scopeControl, http.StatusOK, "token:ops"},
+		{"Bearer control-secret", scopeAdmin, http.StatusForbidden, ""},
+		{"Bearer legacy-secret", scopeAdmin, http.StatusOK, "token:api_token"},
+		// Unknown tokens, including near misses of known ones
+		{"", scopeRead, http.StatusUnauthorized, ""},
+		{"Bearer ", scopeRead, http.StatusUnauthorized, ""},
+		{"Bearer read-secre", scopeRead, http.StatusUnauthorized, ""},
+		{"Bearer read-secrets", scopeRead, http.StatusUnauthorized, ""},
+		{"Bearer READ-SECRET", scopeRead, http.StatusUnauthorized, ""},
+		{"read-secret", scopeRead, http.StatusUnauthorized, ""},
+		{"Basic read-secret", scopeRead, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		actor = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		endpoints[tt.scope](rec, req)
+		if rec.Code != tt.want || actor != tt.actor {
+			t.Errorf("%q on %s: %d as %q, want %d as %q", tt.auth, tt.scope, rec.Code, actor, tt.want, tt.actor)
+		}
+		challenge := rec.Header().Get("WWW-Authenticate")
+		switch tt.want {
+		case http.StatusUnauthorized:
+			if challenge != "Bearer" {
+				t.Errorf("%q: challenge %q", tt.auth, challenge)
+			}
+		case http.StatusForbidden:
+			if !strings.Contains(challenge, `error="insufficient_scope"`) || !strings.Contains(challenge, string(tt.scope)) {
+				t.Errorf("%q: challenge %q", tt.auth, challenge)
+			}
+		}
+	}
+
+	// The control socket needs no token
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), controlConnKey{}, true))
+	rec := httptest.NewRecorder()
+	endpoints[scopeAdmin](rec, req)
+	if rec.Code != http.StatusOK || actor != "control-socket" {
+		t.Errorf("control socket: %d as %q", rec.Code, actor)
+	}
+}
+
+// TestRequireScopeWithoutTokens refuses every request when no token is
+// configured, rather than leaving the API open
+func TestRequireScopeWithoutTokens(t *testing.T) {
+	quietStdout(t)
+	pm, _ := newTestMonitor(t, nil, time.Now())
+	h := newAPIServer(pm).server.Handler
+	for _, path := range []string{"/status", "/config", "/metrics"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: %d", path, rec.Code)
+		}
+	}
+	// metrics_public opens /metrics alone
+	pm, _ = newTestMonitor(t, map[string]interface{}{"metrics_public": true}, time.Now())
+	h = newAPIServer(pm).server.Handler
+	for path, want := range map[string]int{"/metrics": http.StatusOK, "/status": http.StatusForbidden} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("metrics_public %s: %d, want %d", path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// barkGroup groups all notifications of this tool on the device
+const barkGroup = "ping-check"
+
+// BarkConfig configures the Bark (iOS) notifier
+type BarkConfig struct {
+	ServerURL          string `json:"server_url"`
+	DeviceKey          string `json:"device_key" secret:"true"`
+	Icon               string `json:"icon"`
+	Method             string `json:"method"`
+	IncludeDailyReport bool   `json:"include_daily_report"`
+	// NotifierRouting holds min_severity and events (see routing.go)
+	NotifierRouting
+}
+
+// barkPush is the JSON body of POST /push
+type barkPush struct {
+	DeviceKey string `json:"device_key"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	Group     string `json:"group"`
+	Level     string `json:"level"`
+	Icon      string `json:"icon,omitempty"`
+}
+
+// barkNotifier pushes events to an iOS device through a Bark server
+type barkNotifier struct {
+	cfg    BarkConfig
+	client *http.Client
+}
+
+// newBarkNotifier creates the notifier; the server defaults to api.day.app
+func newBarkNotifier(cfg BarkConfig) *barkNotifier {
+	if cfg.ServerURL == "" {
+		cfg.ServerURL = "https://api.day.app"
+	}
+	return &barkNotifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Name returns the notifier name used in logs
+func (n *barkNotifier) Name() string {
+	return "Bark"
+}
+
+// accepts limits Bark to alerts unless the daily report is enabled, since
+// reports are long for a push notification
+func (n *barkNotifier) accepts(ev Event) bool {
+	return ev.Kind != EventReport || n.cfg.IncludeDailyReport
+}
+
+// routing returns min_severity and events
+func (n *barkNotifier) routing() NotifierRouting {
+	return n.cfg.NotifierRouting
+}
+
+// buildPush converts an event; outages break through Focus modes
+func (n *barkNotifier) buildPush(ev Event) barkPush {
+	level := "active"
+	if ev.Kind == EventOutage {
+		level = "timeSensitive"
+	}
+	return barkPush{
+		DeviceKey: n.cfg.DeviceKey,
+		Title:     ev.DisplayTitle(),
+		Body:      plainText(ev.Message),
+		Group:     barkGroup,
+		Level:     level,
+		Icon:      n.cfg.Icon,
+	}
+}
+
+// pushURL builds the GET form /{key}/{title}/{body}?... with every path
+// segment escaped, so Japanese text and slashes in titles survive intact
+func (n *barkNotifier) pushURL(p barkPush) string {
+	q := url.Values{}
+	q.Set("group", p.Group)
+	q.Set("level", p.Level)
+	if p.Icon != "" {
+		q.Set("icon", p.Icon)
+	}
+	return strings.TrimRight(n.cfg.ServerURL, "/") + "/" +
+		url.PathEscape(p.DeviceKey) + "/" +
+		url.PathEscape(p.Title) + "/" +
+		url.PathEscape(p.Body) + "?" + q.Encode()
+}
+
+// Notify delivers ev via POST /push (default) or the GET URL form
+func (n *barkNotifier) Notify(ev Event) error {
+	push := n.buildPush(ev)
+	return retryTransient(func() error {
+		var req *http.Request
+		var err error
+		if strings.EqualFold(n.cfg.Method, "get") {
+			req, err = http.NewRequest(http.MethodGet, n.pushURL(push), nil)
+		} else {
+			var body []byte
+			if body, err = json.Marshal(push); err != nil {
+				return err
+			}
+			req, err = http.NewRequest(http.MethodPost, strings.TrimRight(n.cfg.ServerURL, "/")+"/push", bytes.NewReader(body))
+			if req != nil {
+				req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return doNotifierRequest(n.client, req)
+	})
+}
+
+// plainText strips the Discord markdown emphasis used in event messages
+func plainText(s string) string {
+	return strings.ReplaceAll(s, "**", "")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestBarkPushURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		server, key string
+		push        barkPush
+		want        string
+	}{
+		{"Japanese", "https://api.day.app", "key",
+			barkPush{Title: "🚨 到達不能", Body: "対象: 8.8.8.8", Group: barkGroup, Level: "timeSensitive"},
+			"https://api.day.app/key/%F0%9F%9A%A8%20%E5%88%B0%E9%81%94%E4%B8%8D%E8%83%BD/%E5%AF%BE%E8%B1%A1:%208.8.8.8?group=ping-check&level=timeSensitive"},
+		{"slash, question mark, hash and spaces", "https://bark.example.com/", "k/e y",
+			barkPush{Title: "a/b ?c #d", Body: "1/2 = 50%?#x", Group: barkGroup, Level: "active"},
+			"https://bark.example.com/k%2Fe%20y/a%2Fb%20%3Fc%20%23d/1%2F2%20=%2050%25%3F%23x?group=ping-check&level=active"},
+		{"icon in the query", "http://bark.lan:8080", "key",
+			barkPush{Title: "t", Body: "b", Group: barkGroup, Level: "active", Icon: "https://example.com/i.png?s=1&t=2"},
+			"http://bark.lan:8080/key/t/b?group=ping-check&icon=https%3A%2F%2Fexample.com%2Fi.png%3Fs%3D1%26t%3D2&level=active"},
+	}
+	for _, tt := range tests {
+		n := newBarkNotifier(BarkConfig{ServerURL: tt.server, DeviceKey: tt.key})
+		tt.push.DeviceKey = tt.key
+		if got := n.pushURL(tt.push); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestBarkNotify sends an event through both methods and checks what the
+// server receives decodes to the original text
+func TestBarkNotify(t *testing.T) {
+	fastRetries(t)
+	var requests []*http.Request
+	var bodies [][]byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests, bodies = append(requests, r), append(bodies, body)
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	ev := Event{Kind: EventOutage, Severity: SeverityCritical, Title: "🚨 到達不能 a/b?c#d", Message: "**対象**: 8.8.8.8 / 50%"}
+
+	n := newBarkNotifier(BarkConfig{ServerURL: ts.URL, DeviceKey: "key", Method: "GET"})
+	if err := n.Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("%d requests, want a retry after the 503", len(requests))
+	}
+	r := requests[1]
+	if want := "/key/" + url.PathEscape(ev.Title) + "/" + url.PathEscape("対象: 8.8.8.8 / 50%"); r.Method != http.MethodGet || r.URL.EscapedPath() != want {
+		t.Errorf("GET %s, want %s", r.URL.EscapedPath(), want)
+	}
+	if r.URL.Query().Get("level") != "timeSensitive" || r.URL.Query().Get("group") != barkGroup {
+		t.Errorf("query %v", r.URL.Query())
+	}
+
+	requests, bodies = nil, nil
+	n = newBarkNotifier(BarkConfig{ServerURL: ts.URL + "/", DeviceKey: "key", Icon: "https://example.com/i.png"})
+	ev.Kind = EventRecovery
+	if err := n.Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+	r = requests[len(requests)-1]
+	if r.Method != http.MethodPost || r.URL.Path != "/push" || r.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("%s %s %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+	}
+	var got barkPush
+	if err := json.Unmarshal(bodies[len(bodies)-1], &got); err != nil {
+		t.Fatal(err)
+	}
+	want := barkPush{DeviceKey: "key", Title: ev.Title, Body: "対象: 8.8.8.8 / 50%", Group: barkGroup, Level: "active", Icon: "https://example.com/i.png"}
+	if got != want {
+		t.Errorf("push %+v, want %+v", got, want)
+	}
+}
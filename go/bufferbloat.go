@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bufferbloat test defaults and limits
+const (
+	bloatDefaultConnections = 4
+	bloatMaxConnections     = 16
+	bloatDefaultMaxBytes    = 100 << 20
+	bloatDefaultDuration    = 10 * time.Second
+	bloatMinDuration        = 3 * time.Second
+	bloatMaxDuration        = 60 * time.Second
+	// bloatMaxTimes keeps the test to a few runs a day
+	bloatMaxTimes = 4
+)
+
+// Timing of a test
+const (
+	// bloatSampleInterval spaces the pings of both phases
+	bloatSampleInterval = 250 * time.Millisecond
+	// bloatIdleSamples are taken before the load; half must answer
+	bloatIdleSamples = 8
+	// bloatMinLoaded is the fewest answers under load that give a median
+	bloatMinLoaded = 3
+	// bloatCheckInterval is how often the schedule is checked
+	bloatCheckInterval = 30 * time.Second
+	// bloatWindow is how long after its time a run may still start, when
+	// an outage or a restart delayed it
+	bloatWindow = time.Hour
+	// bloatDrain keeps samples excluded after the load while the queues
+	// of the line empty
+	bloatDrain = 2 * time.Second
+)
+
+// BufferbloatConfig schedules the latency-under-load test. Downloading
+// url over a few connections fills the line, and the pings sent meanwhile
+// show how much the queues of the router and the modem delay them.
+type BufferbloatConfig struct {
+	// URL is downloaded to load the line, e.g. a large file on a CDN
+	URL string `json:"url"`
+	// Times are the times of day to run at, "HH:MM" (at most 4)
+	Times []string `json:"times"`
+	// Connections are the parallel downloads (default 4)
+	Connections int `json:"connections"`
+	// MaxBytes caps the bytes downloaded by one run (default 100MiB)
+	MaxBytes int64 `json:"max_bytes"`
+	// Duration is the length of the load (default 10s)
+	Duration Duration `json:"duration"`
+}
+
+// validate checks the URL, the times and the limits
+func (c BufferbloatConfig) validate() error {
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("bufferbloat.url の値が正しくありません: %q (例: https://example.com/100MB.bin)", c.URL)
+	}
+	if len(c.Times) == 0 || len(c.Times) > bloatMaxTimes {
+		return fmt.Errorf("bufferbloat.times は1〜%d件で指定してください（例: [\"21:00\"]）", bloatMaxTimes)
+	}
+	for _, t := range c.Times {
+		if _, err := parseTimeOfDay(t); err != nil {
+			return fmt.Errorf("bufferbloat.times の値が正しくありません: %q (HH:MM)", t)
+		}
+	}
+	if c.Connections < 0 || c.Connections > bloatMaxConnections {
+		return fmt.Errorf("bufferbloat.connections は0〜%dで指定してください（0で既定の%d）", bloatMaxConnections, bloatDefaultConnections)
+	}
+	if c.MaxBytes < 0 {
+		return fmt.Errorf("bufferbloat.max_bytes は0以上で指定してください（0で既定の100MiB）")
+	}
+	if !c.Duration.given() {
+		return nil
+	}
+	return c.Duration.check("bufferbloat.duration", bloatMinDuration, bloatMaxDuration, "10s")
+}
+
+// connections returns the parallel downloads
+func (c BufferbloatConfig) connections() int {
+	if c.Connections == 0 {
+		return bloatDefaultConnections
+	}
+	return c.Connections
+}
+
+// maxBytes returns the download cap of a run
+func (c BufferbloatConfig) maxBytes() int64 {
+	if c.MaxBytes == 0 {
+		return bloatDefaultMaxBytes
+	}
+	return c.MaxBytes
+}
+
+// BufferbloatResult is one run of the test, reported daily and kept in
+// the history for the monthly trend
+type BufferbloatResult struct {
+	Time time.Time `json:"time"`
+	// IdleMs and LoadedMs are the median RTTs before and under the load
+	IdleMs     float64 `json:"idle_ms"`
+	LoadedMs   float64 `json:"loaded_ms,omitempty"`
+	IncreaseMs float64 `json:"increase_ms,omitempty"`
+	// LoadedLoss is the percentage of pings lost under the load
+	LoadedLoss float64 `json:"loaded_loss_percent"`
+	Grade      string  `json:"grade,omitempty"`
+	// DownloadMbps is the throughput the load reached
+	DownloadMbps float64 `json:"download_mbps"`
+	Bytes        int64   `json:"bytes"`
+	// Error tells why the run has no grade
+	Error string `json:"error,omitempty"`
+}
+
+// bloatGrade grades the latency increase under load, on the scale of the
+// common bufferbloat tests
+func bloatGrade(increaseMs float64) string {
+	switch {
+	case increaseMs < 30:
+		return "A"
+	case increaseMs < 60:
+		return "B"
+	case increaseMs < 200:
+		return "C"
+	case increaseMs < 400:
+		return "D"
+	}
+	return "F"
+}
+
+// summary renders the run as one line, e.g. "21:00 B 12.3ms → 58.1ms
+// (+45.8ms)、負荷時の損失 0.0%、下り 85.2Mbps"
+func (r BufferbloatResult) summary(f numberFormat) string {
+	at := r.Time.Format("15:04")
+	if r.Error != "" {
+		return fmt.Sprintf("%s 計測できず（%s）", at, r.Error)
+	}
+	loaded := "応答なし"
+	if r.LoadedMs > 0 {
+		loaded = fmt.Sprintf("%s (+%s)", f.ms(r.LoadedMs), f.ms(r.IncreaseMs))
+	}
+	return fmt.Sprintf("%s **%s** %s → %s、負荷時の損失 %s、下り %s",
+		at, r.Grade, f.ms(r.IdleMs), loaded, f.percent(r.LoadedLoss, 1), formatBitrate(r.DownloadMbps*1e6))
+}
+
+// bloatTester runs the scheduled tests. While it loads the line, the
+// samples of the ping loop are excluded like those of the warm-up.
+type bloatTester struct {
+	cfg   BufferbloatConfig
+	times []time.Duration
+	// loadingFlag is set from the start of the load until bloatDrain
+	// after it
+	loadingFlag atomic.Bool
+	mutex       sync.Mutex
+	// ran holds the slots ("2006-01-02 21:00") already run
+	ran map[string]bool
+}
+
+// newBloatTester prepares the validated config
+func newBloatTester(cfg BufferbloatConfig) *bloatTester {
+	b := &bloatTester{cfg: cfg, ran: make(map[string]bool)}
+	for _, t := range cfg.Times {
+		tod, _ := parseTimeOfDay(t)
+		b.times = append(b.times, tod)
+	}
+	return b
+}
+
+// loading reports whether the test is loading the line; false without a
+// tester
+func (b *bloatTester) loading() bool {
+	return b != nil && b.loadingFlag.Load()
+}
+
+// due returns the slot to run at now, "" when none is
+func (b *bloatTester) due(now time.Time) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for i, tod := range b.times {
+		at := midnight.Add(tod)
+		key := now.Format(reportDateLayout) + " " + b.cfg.Times[i]
+		if !b.ran[key] && !now.Before(at) && now.Before(at.Add(bloatWindow)) {
+			return key
+		}
+	}
+	return ""
+}
+
+// markRan records that slot was run; slots of earlier days are dropped
+func (b *bloatTester) markRan(slot string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	day, _, _ := strings.Cut(slot, " ")
+	for k := range b.ran {
+		if !strings.HasPrefix(k, day) {
+			delete(b.ran, k)
+		}
+	}
+	b.ran[slot] = true
+}
+
+// run measures the idle RTT to addr, then the RTT while the download
+// loads the line
+func (b *bloatTester) run(prober Prober, addr string, now func() time.Time) BufferbloatResult {
+	res := BufferbloatResult{Time: now()}
+	idle, _ := bloatSamples(context.Background(), prober, addr, bloatIdleSamples)
+	if len(idle) < bloatIdleSamples/2 {
+		res.Error = "負荷をかける前の応答が不足しています"
+		return res
+	}
+	res.IdleMs = computeLatencyStats(idle).Median
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.cfg.Duration.or(bloatDefaultDuration))
+	defer cancel()
+	b.loadingFlag.Store(true)
+	defer time.AfterFunc(bloatDrain, func() { b.loadingFlag.Store(false) })
+	loaded := make(chan bloatLoad, 1)
+	go func() {
+		defer cancel()
+		loaded <- b.load(ctx)
+	}()
+	times, lost := bloatSamples(ctx, prober, addr, 0)
+	load := <-loaded
+
+	res.Bytes = load.bytes
+	if load.elapsed > 0 {
+		res.DownloadMbps = float64(load.bytes) * 8 / load.elapsed.Seconds() / 1e6
+	}
+	if load.bytes == 0 {
+		res.Error = fmt.Sprintf("負荷をかけられませんでした: %v", load.err)
+		return res
+	}
+	if sent := len(times) + lost; sent > 0 {
+		res.LoadedLoss = float64(lost) / float64(sent) * 100
+	}
+	if len(times) < bloatMinLoaded {
+		if lost < bloatMinLoaded {
+			// The cap was reached before enough pings were sent
+			res.Error = "負荷が短すぎました（max_bytes を大きくしてください）"
+			return res
+		}
+		// Pings drowned by the load are the worst case of bufferbloat
+		res.Grade = "F"
+		return res
+	}
+	res.LoadedMs = computeLatencyStats(times).Median
+	res.IncreaseMs = max(res.LoadedMs-res.IdleMs, 0)
+	res.Grade = bloatGrade(res.IncreaseMs)
+	return res
+}
+
+// bloatSamples pings addr every bloatSampleInterval, n times or, with n
+// 0, until ctx ends; it returns the RTTs and the pings lost
+func bloatSamples(ctx context.Context, prober Prober, addr string, n int) ([]float64, int) {
+	var times []float64
+	lost := 0
+	ticker := time.NewTicker(bloatSampleInterval)
+	defer ticker.Stop()
+	for i := 0; n == 0 || i < n; i++ {
+		if ms, err := prober.Probe(addr); err == nil {
+			times = append(times, ms)
+		} else {
+			lost++
+		}
+		select {
+		case <-ctx.Done():
+			return times, lost
+		case <-ticker.C:
+		}
+	}
+	return times, lost
+}
+
+// bloatLoad is what the download achieved
+type bloatLoad struct {
+	bytes   int64
+	elapsed time.Duration
+	err     error
+}
+
+// load downloads the URL over the configured connections until ctx ends
+// or the byte cap is reached, fetching it again when it ends early
+func (b *bloatTester) load(ctx context.Context) bloatLoad {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, DisableCompression: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	limit := b.cfg.maxBytes()
+	var total atomic.Int64
+	var errMutex sync.Mutex
+	var firstErr error
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < b.cfg.connections(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && total.Load() < limit {
+				if err := bloatDownload(ctx, client, b.cfg.URL, &total, limit); err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMutex.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return bloatLoad{bytes: total.Load(), elapsed: time.Since(start), err: firstErr}
+}
+
+// bloatDownload reads one response of rawURL into total; the end of ctx
+// or of the cap is no error
+func bloatDownload(ctx context.Context, client *http.Client, rawURL string, total *atomic.Int64, limit int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if total.Add(int64(n)) >= limit {
+			return nil
+		}
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// checkBufferbloat runs the test when a slot is due and the line is up;
+// during an outage it waits, up to bloatWindow after the slot
+func (pm *PingMonitor) checkBufferbloat(now time.Time) {
+	slot := pm.bloat.due(now)
+	if slot == "" {
+		return
+	}
+	if down, _ := pm.outages.state(); down {
+		return
+	}
+	pm.mutex.RLock()
+	addr, lastSuccess := pm.targetAddr, pm.lastSuccess
+	pm.mutex.RUnlock()
+	// The last probe must have answered: a failure may be an outage not
+	// yet confirmed
+	if addr == "" || now.Sub(lastSuccess) > 2*pm.pingInterval+pm.config.pingTimeout() {
+		return
+	}
+	pm.bloat.markRan(slot)
+
+	fmt.Printf("📶 負荷時の遅延を計測します（%s）...\n", pm.bloat.cfg.URL)
+	res := pm.bloat.run(pm.prober, addr, pm.now)
+	fmt.Printf("📶 負荷時の遅延: %s\n", strings.ReplaceAll(res.summary(pm.format), "**", ""))
+	pm.mutex.Lock()
+	pm.bufferbloat = append(pm.bufferbloat, res)
+	pm.mutex.Unlock()
+}
+
+// bufferbloatLoop checks the schedule every bloatCheckInterval
+func (pm *PingMonitor) bufferbloatLoop() {
+	ticker := time.NewTicker(bloatCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.stopChan:
+			return
+		case <-ticker.C:
+			pm.checkBufferbloat(pm.now())
+		}
+	}
+}
+
+// bufferbloatLines describe the period's runs, nil when there were none
+func (p *reportPeriod) bufferbloatLines() []string {
+	var lines []string
+	for _, r := range p.Bufferbloat {
+		lines = append(lines, r.summary(p.Format))
+	}
+	return lines
+}
+
+// bloatTrend summarizes the graded runs of a month
+type bloatTrend struct {
+	Runs        int
+	AvgIncrease float64
+	MaxIncrease float64
+	// Grades counts the runs per grade, "A" to "F"
+	Grades map[string]int
+	// Daily is the mean increase of each day, present where graded
+	Daily   []float64
+	HasData []bool
+}
+
+// trendBufferbloat collects the runs stored in the days of a month
+func trendBufferbloat(days []*DailyHistory) bloatTrend {
+	t := bloatTrend{Grades: make(map[string]int), Daily: make([]float64, len(days)), HasData: make([]bool, len(days))}
+	sum := 0.0
+	for d, day := range days {
+		if day == nil {
+			continue
+		}
+		n, daySum := 0, 0.0
+		for _, r := range day.Bufferbloat {
+			if r.Grade == "" {
+				continue
+			}
+			increase := r.IncreaseMs
+			if r.LoadedMs == 0 {
+				// Every ping drowned; the day counts the grade's floor
+				increase = 400
+			}
+			n++
+			daySum += increase
+			t.Grades[r.Grade]++
+			t.MaxIncrease = max(t.MaxIncrease, increase)
+		}
+		if n > 0 {
+			t.Runs += n
+			sum += daySum
+			t.Daily[d], t.HasData[d] = daySum/float64(n), true
+		}
+	}
+	if t.Runs > 0 {
+		t.AvgIncrease = sum / float64(t.Runs)
+	}
+	return t
+}
+
+// gradeCounts renders the grades seen, e.g. "A 12回 / B 3回"
+func (t bloatTrend) gradeCounts() string {
+	var parts []string
+	for _, g := range []string{"A", "B", "C", "D", "F"} {
+		if n := t.Grades[g]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d回", g, n))
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+// field is the monthly report's summary of the trend
+func (t bloatTrend) field(f numberFormat) string {
+	line, lo, hi := sparkline(t.Daily, t.HasData)
+	return fmt.Sprintf("**試験回数**: %d\n**平均増加**: +%s\n**最大増加**: +%s\n**グレード**: %s\n```\n%s 増加 %.0f–%.0fms\n```",
+		t.Runs, f.ms(t.AvgIncrease), f.ms(t.MaxIncrease), t.gradeCounts(), line, lo, hi)
+}
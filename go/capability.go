@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// capabilityCheckTarget is pinged to see whether ping works at all,
+// independent of the network
+const capabilityCheckTarget = "127.0.0.1"
+
+// capabilityReport is the result of the startup checks, with what they
+// turned off
+type capabilityReport struct {
+	Checks []Capability
+	// The features below are turned off in every config by restrict
+	noVantages   bool
+	noRouteProbe bool
+	noListen     bool
+	badStateDirs map[string]bool
+}
+
+// The checks reach the host through these, which tests replace
+var (
+	lookPath  = exec.LookPath
+	listenTCP = net.Listen
+	statFile  = os.Stat
+	// openICMPSocket opens and closes an IPv4 ICMP socket of the type
+	openICMPSocket = func(typ int) error {
+		fd, err := syscall.Socket(syscall.AF_INET, typ, 1) // IPPROTO_ICMP
+		if err == nil {
+			syscall.Close(fd)
+		}
+		return err
+	}
+)
+
+// startupCapabilities is set by main once the checks ran; nil in
+// subcommands, which use the configs as they are
+var startupCapabilities *capabilityReport
+
+// probeCapabilities checks the commands and permissions the configs need.
+// The HTTP port is not tried on takeover, since the instance taken over
+// still holds it.
+func probeCapabilities(configs []Config, takeover bool) *capabilityReport {
+	r := &capabilityReport{badStateDirs: make(map[string]bool)}
+	first := configs[0]
+
+	if ok, detail := checkPing(); ok {
+		r.add(Capability{Name: "ping", OK: true, Detail: detail})
+	} else if !slices.ContainsFunc(configs, func(c Config) bool { return !usesNativePing(c.PingBackend) }) {
+		// Route probes still need the command for its TTL
+		r.noRouteProbe = true
+		r.add(Capability{Name: "ping", Detail: detail, Effect: "経路の確認は行いません（計測はネイティブICMPで行います）"})
+	} else {
+		r.noRouteProbe = true
+		r.add(Capability{Name: "ping", Detail: detail, Effect: "pingによる計測はすべて計測エラーになります。経路の確認も行いません"})
+	}
+
+	detection := map[string]bool{}
+	for _, cfg := range configs {
+		if cfg.Gateway == "" {
+			for _, p := range cfg.gatewayDetection() {
+				detection[p] = true
+			}
+		}
+	}
+	if detection["ip"] && runtime.GOOS != "windows" {
+		r.add(checkCommand("ip", "ゲートウェイの検出は他の方法を使います", "route", "show", "default"))
+	}
+	if detection["route"] {
+		args := []string{"-n"}
+		if runtime.GOOS == "windows" {
+			args = []string{"print", "0.0.0.0"}
+		}
+		r.add(checkCommand("route", "ゲートウェイの検出は他の方法を使います", args...))
+	}
+
+	if slices.ContainsFunc(configs, func(c Config) bool { return len(c.Vantages) > 0 }) {
+		c := checkCommand("ssh", "観測点（vantages）を無効にします", "-V")
+		r.noVantages = !c.OK
+		r.add(c)
+	}
+	if sp := first.SelfProtection; sp != nil && sp.ProbeNice != 0 && runtime.GOOS != "windows" {
+		r.add(checkInstalled("nice", "外部コマンドの優先度は変更しません"))
+	}
+	for _, cfg := range configs {
+		if pc := cfg.ProbeCommand; pc != nil && !slices.ContainsFunc(r.Checks, func(c Capability) bool { return c.Name == pc.Command[0] }) {
+			r.add(checkInstalled(pc.Command[0], "probe_command による計測はすべて計測エラーになります"))
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		r.add(checkICMPSocket("ICMP（raw）", syscall.SOCK_RAW, "root権限かCAP_NET_RAWが必要です"))
+		r.add(checkICMPSocket("ICMP（dgram）", syscall.SOCK_DGRAM, "net.ipv4.ping_group_range で許可されていません"))
+	}
+
+	if first.HTTPListen != "" {
+		name := "HTTP待ち受け " + first.HTTPListen
+		var err error
+		if !takeover {
+			err = checkListen(first.HTTPListen)
+		}
+		switch {
+		case takeover:
+			r.add(Capability{Name: name, OK: true, Detail: "引き継ぎのため未確認"})
+		case err != nil:
+			r.noListen = true
+			r.add(Capability{Name: name, Detail: err.Error(), Effect: "HTTP APIを無効にします（制御用ソケットは使えます）"})
+		default:
+			r.add(Capability{Name: name, OK: true, Detail: "使用できます"})
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, cfg := range configs {
+		if cfg.StateDir == "" || seen[cfg.StateDir] {
+			continue
+		}
+		seen[cfg.StateDir] = true
+		name := "state_dir " + cfg.StateDir
+		if err := checkWritable(cfg.StateDir); err != nil {
+			r.badStateDirs[cfg.StateDir] = true
+			r.add(Capability{Name: name, Detail: err.Error(), Effect: "state_dir を使う機能（履歴・通知の再送・操作の記録・制御用ソケットなど）を無効にします"})
+		} else {
+			r.add(Capability{Name: name, OK: true, Detail: "書き込めます"})
+		}
+	}
+	return r
+}
+
+// add appends a check
+func (r *capabilityReport) add(c Capability) {
+	r.Checks = append(r.Checks, c)
+}
+
+// print writes the checks as a table, followed by what was turned off
+func (r *capabilityReport) print() {
+	width := 0
+	for _, c := range r.Checks {
+		width = max(width, displayWidth(c.Name))
+	}
+	fmt.Println("起動時の確認:")
+	for _, c := range r.Checks {
+		mark := "✅"
+		switch {
+		case c.OK:
+		case c.Optional:
+			mark = "➖"
+		default:
+			mark = "❌"
+		}
+		fmt.Printf("  %s %s  %s\n", mark, padRight(c.Name, width), c.Detail)
+	}
+	for _, c := range r.Checks {
+		if !c.OK && c.Effect != "" {
+			fmt.Printf("⚠️ %s: %s\n", c.Name, c.Effect)
+		}
+	}
+}
+
+// displayWidth approximates the columns s takes on a terminal, counting
+// East Asian characters as two
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x1100 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// padRight pads s with spaces to width terminal columns
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(width-displayWidth(s), 0))
+}
+
+// padLeft right-aligns s in width terminal columns
+func padLeft(s string, width int) string {
+	return strings.Repeat(" ", max(width-displayWidth(s), 0)) + s
+}
+
+// restrict turns off in cfg what the checks found unusable
+func (r *capabilityReport) restrict(cfg *Config) {
+	if r.noVantages {
+		cfg.Vantages = nil
+	}
+	if r.noRouteProbe {
+		cfg.RouteProbeInterval = makeDuration(0)
+	}
+	if r.noListen {
+		cfg.HTTPListen = ""
+	}
+	if r.badStateDirs[cfg.StateDir] {
+		cfg.StateDir = ""
+	}
+}
+
+// checkPing pings the loopback address once
+func checkPing() (bool, string) {
+	args := []string{"-c", "1", "-W", "1", capabilityCheckTarget}
+	if runtime.GOOS == "windows" {
+		args = []string{"-n", "1", "-w", "1000", capabilityCheckTarget}
+	}
+	if _, err := lookPath("ping"); err != nil {
+		return false, "見つかりません"
+	}
+	output, err := runCommand(commandTimeout, "ping", args...)
+	if err != nil {
+		switch reason := classifyPingFailure(string(output), err); {
+		case errors.Is(err, exec.ErrNotFound):
+			return false, "見つかりません"
+		case reason == reasonMeasurement, strings.Contains(string(output), "Operation not permitted"), strings.Contains(string(output), "Permission denied"):
+			return false, "実行する権限がありません"
+		default:
+			return false, fmt.Sprintf("%s に応答しません（%v）", capabilityCheckTarget, err)
+		}
+	}
+	return true, capabilityCheckTarget + " に応答しました"
+}
+
+// checkCommand runs name with args and expects it to succeed
+func checkCommand(name, effect string, args ...string) Capability {
+	c := Capability{Name: name, Effect: effect}
+	if _, err := lookPath(name); err != nil {
+		c.Detail = "見つかりません"
+		return c
+	}
+	switch _, err := runCommand(commandTimeout, name, args...); {
+	case errors.Is(err, exec.ErrNotFound):
+		c.Detail = "見つかりません"
+	case err != nil:
+		c.Detail = fmt.Sprintf("実行できません（%v）", err)
+	default:
+		c.OK, c.Detail = true, "使用できます"
+	}
+	return c
+}
+
+// checkInstalled looks name up without running it
+func checkInstalled(name, effect string) Capability {
+	path, err := lookPath(name)
+	if err != nil {
+		return Capability{Name: name, Detail: "見つかりません", Effect: effect}
+	}
+	return Capability{Name: name, OK: true, Detail: path}
+}
+
+// checkICMPSocket tries to open an ICMP socket of the type, which
+// ping_backend auto and native ping with (see icmp.go)
+func checkICMPSocket(name string, typ int, denied string) Capability {
+	if err := openICMPSocket(typ); err != nil {
+		return Capability{Name: name, Detail: denied, Optional: true}
+	}
+	return Capability{Name: name, OK: true, Detail: "使用できます", Optional: true}
+}
+
+// checkListen binds addr and releases it again
+func checkListen(addr string) error {
+	l, err := listenTCP("tcp", addr)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			err = opErr.Err
+		}
+		return err
+	}
+	return l.Close()
+}
+
+// checkWritable creates dir if needed and writes a file into it
+func checkWritable(dir string) error {
+	switch info, err := statFile(dir); {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return fmt.Errorf("ディレクトリではありません: %s", dir)
+	}
+	f, err := os.CreateTemp(dir, ".write-check*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
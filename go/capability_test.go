@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeHost replaces what the capability checks reach on the host. Only
+// the installed commands are found; ping and ssh never are, so no check
+// runs a command.
+type fakeHost struct {
+	installed map[string]bool
+	socketErr error
+	listenErr error
+	statErr   error
+}
+
+// install makes the checks use h until the test ends
+func (h fakeHost) install(t *testing.T) {
+	savedLookPath, savedListen, savedStat, savedSocket := lookPath, listenTCP, statFile, openICMPSocket
+	t.Cleanup(func() {
+		lookPath, listenTCP, statFile, openICMPSocket = savedLookPath, savedListen, savedStat, savedSocket
+	})
+	lookPath = func(name string) (string, error) {
+		if h.installed[name] {
+			return "/usr/bin/" + name, nil
+		}
+		return "", &os.PathError{Op: "exec", Path: name, Err: os.ErrNotExist}
+	}
+	listenTCP = func(network, addr string) (net.Listener, error) {
+		if h.listenErr != nil {
+			return nil, &net.OpError{Op: "listen", Net: network, Err: h.listenErr}
+		}
+		return net.Listen(network, "127.0.0.1:0")
+	}
+	statFile = func(name string) (os.FileInfo, error) {
+		if h.statErr != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: h.statErr}
+		}
+		return os.Stat(name)
+	}
+	openICMPSocket = func(int) error { return h.socketErr }
+}
+
+// TestProbeCapabilities injects each kind of failure and checks what the
+// startup table, /status and the restricted config show for it
+func TestProbeCapabilities(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the ICMP socket and nice checks are Unix only")
+	}
+	quietStdout(t)
+	t.Cleanup(func() { startupCapabilities = nil })
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := func(stateDir string) Config {
+		return Config{
+			PingBackend:    pingBackendExec,
+			Gateway:        "192.168.1.1",
+			HTTPListen:     "127.0.0.1:8080",
+			StateDir:       stateDir,
+			Vantages:       []VantageConfig{{Name: "vps"}},
+			SelfProtection: &SelfProtectionConfig{ProbeNice: 10},
+			ProbeCommand:   &ProbeCommandConfig{Command: []string{"my-probe"}},
+		}
+	}
+	installed := map[string]bool{"nice": true, "my-probe": true}
+	always := map[string]Capability{
+		"ping": {Name: "ping", Detail: "見つかりません", Effect: "pingによる計測はすべて計測エラーになります。経路の確認も行いません"},
+		"ssh":  {Name: "ssh", Detail: "見つかりません", Effect: "観測点（vantages）を無効にします"},
+	}
+	tests := []struct {
+		name     string
+		host     fakeHost
+		stateDir string
+		takeover bool
+		want     []Capability
+		// listen and state are whether restrict keeps http_listen and
+		// state_dir
+		listen, state bool
+	}{
+		{"everything usable", fakeHost{installed: installed}, stateDir, false, []Capability{
+			{Name: "nice", OK: true, Detail: "/usr/bin/nice"},
+			{Name: "my-probe", OK: true, Detail: "/usr/bin/my-probe"},
+			{Name: "ICMP（raw）", OK: true, Detail: "使用できます", Optional: true},
+			{Name: "ICMP（dgram）", OK: true, Detail: "使用できます", Optional: true},
+			{Name: "HTTP待ち受け 127.0.0.1:8080", OK: true, Detail: "使用できます"},
+			{Name: "state_dir " + stateDir, OK: true, Detail: "書き込めます"},
+		}, true, true},
+		{"lookPath fails", fakeHost{}, stateDir, false, []Capability{
+			{Name: "nice", Detail: "見つかりません", Effect: "外部コマンドの優先度は変更しません"},
+			{Name: "my-probe", Detail: "見つかりません", Effect: "probe_command による計測はすべて計測エラーになります"},
+		}, true, true},
+		{"socket fails", fakeHost{installed: installed, socketErr: os.ErrPermission}, stateDir, false, []Capability{
+			{Name: "ICMP（raw）", Detail: "root権限かCAP_NET_RAWが必要です", Optional: true},
+			{Name: "ICMP（dgram）", Detail: "net.ipv4.ping_group_range で許可されていません", Optional: true},
+		}, true, true},
+		{"bind fails", fakeHost{installed: installed, listenErr: errors.New("bind: address already in use")}, stateDir, false, []Capability{
+			{Name: "HTTP待ち受け 127.0.0.1:8080", Detail: "bind: address already in use", Effect: "HTTP APIを無効にします（制御用ソケットは使えます）"},
+		}, false, true},
+		{"bind not tried on takeover", fakeHost{installed: installed, listenErr: errors.New("bind: address already in use")}, stateDir, true, []Capability{
+			{Name: "HTTP待ち受け 127.0.0.1:8080", OK: true, Detail: "引き継ぎのため未確認"},
+		}, true, true},
+		{"stat fails", fakeHost{installed: installed, statErr: os.ErrPermission}, stateDir, false, []Capability{
+			{Name: "state_dir " + stateDir, Detail: "stat " + stateDir + ": permission denied",
+				Effect: "state_dir を使う機能（履歴・通知の再送・操作の記録・制御用ソケットなど）を無効にします"},
+		}, true, false},
+		{"state_dir is a file", fakeHost{installed: installed}, notDir, false, []Capability{
+			{Name: "state_dir " + notDir, Detail: "ディレクトリではありません: " + notDir,
+				Effect: "state_dir を使う機能（履歴・通知の再送・操作の記録・制御用ソケットなど）を無効にします"},
+		}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.host.install(t)
+			r := probeCapabilities([]Config{config(tt.stateDir)}, tt.takeover)
+			checks := map[string]Capability{}
+			for _, c := range r.Checks {
+				checks[c.Name] = c
+			}
+			for _, want := range append(tt.want, always["ping"], always["ssh"]) {
+				if got, ok := checks[want.Name]; !ok || got != want {
+					t.Errorf("%s: %+v, want %+v", want.Name, got, want)
+				}
+			}
+
+			cfg := config(tt.stateDir)
+			r.restrict(&cfg)
+			if cfg.Vantages != nil || cfg.RouteProbeInterval.Duration() != 0 {
+				t.Errorf("vantages %v and route probes %v kept without ssh and ping", cfg.Vantages, cfg.RouteProbeInterval)
+			}
+			if (cfg.HTTPListen != "") != tt.listen || (cfg.StateDir != "") != tt.state {
+				t.Errorf("restricted to http_listen %q state_dir %q", cfg.HTTPListen, cfg.StateDir)
+			}
+
+			// The startup table marks each check, and warns of what is off
+			out := captureStdout(t, r.print)
+			for _, c := range r.Checks {
+				mark := "✅"
+				if !c.OK {
+					mark = "❌"
+					if c.Optional {
+						mark = "➖"
+					}
+				}
+				if !strings.Contains(out, mark+" "+c.Name) || !strings.Contains(out, c.Detail) {
+					t.Errorf("table lacks %s %s %s:\n%s", mark, c.Name, c.Detail, out)
+				}
+				if warning := "⚠️ " + c.Name + ": " + c.Effect; strings.Contains(out, warning) != (!c.OK && c.Effect != "") {
+					t.Errorf("warning %q in the table: %v", warning, !c.OK)
+				}
+			}
+
+			startupCapabilities = r
+			pm, _ := newTestMonitor(t, nil, time.Now())
+			got := pm.status().Capabilities
+			if len(got) != len(r.Checks) {
+				t.Fatalf("/status has %d checks, want %d", len(got), len(r.Checks))
+			}
+			for i := range got {
+				if got[i] != r.Checks[i] {
+					t.Errorf("/status %+v, want %+v", got[i], r.Checks[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// subcommands maps CLI subcommand names to their entry points
+var subcommands = map[string]func(args []string) int{
+	"simulate-outage": runSimulateOutage,
+	"compare":         runCompare,
+	"incidents":       runIncidents,
+	"status":          runStatus,
+	"export":          runExport,
+	"tail":            runTail,
+	"migrate-config":  runMigrateConfig,
+	"resend":          runResend,
+}
+
+// apiClient talks to a running instance's HTTP API
+type apiClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+	// where names the instance's address in messages
+	where string
+}
+
+// errAPIDisabled is returned by newAPIClient when the config offers no way
+// to reach the instance
+var errAPIDisabled = errors.New("HTTP APIが無効です。config.jsonのhttp_listenかcontrol_socketを設定するか-urlを指定してください")
+
+// newAPIClient builds a client from the config file unless url/token are
+// given. Without -url the control socket is preferred when it exists.
+func newAPIClient(configPath, baseURL, token string) (*apiClient, error) {
+	if baseURL == "" || token == "" {
+		cfg, err := readConfig(configPath, ConfigOverrides{})
+		if err != nil {
+			return nil, err
+		}
+		if baseURL == "" {
+			// A missing socket still names the instance when there is no
+			// TCP listener to try instead
+			if t := newControlTransport(cfg); t != nil && (t.Available() || cfg.HTTPListen == "") {
+				return &apiClient{baseURL: "http://control", http: controlClient(t), where: t.String()}, nil
+			}
+			if cfg.HTTPListen == "" {
+				return nil, errAPIDisabled
+			}
+			baseURL = listenURL(cfg.HTTPListen)
+		}
+		if token == "" {
+			token = cfg.clientToken()
+		}
+	}
+	return &apiClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second},
+		where:   strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+// listenURL converts a listen address into a URL reachable from this host
+func listenURL(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "http://" + listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// do sends a request with the bearer token and decodes a JSON response into out
+func (c *apiClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error: %d - %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// runSimulateOutage asks a running instance to inject a synthetic outage
+func runSimulateOutage(args []string) int {
+	fs := flag.NewFlagSet("simulate-outage", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "設定ファイルのパス")
+	duration := fs.Duration("duration", 90*time.Second, "擬似障害の継続時間")
+	target := fs.String("target", "", "擬似障害を注入する対象（省略時は監視対象）")
+	baseURL := fs.String("url", "", "稼働中インスタンスのURL（省略時はconfigのhttp_listen）")
+	token := fs.String("token", "", "APIトークン（省略時はconfigのapi_token）")
+	profile := fs.String("profile", "", "対象のプロファイル（プロファイルを定義している場合）")
+	fs.Parse(args)
+
+	client, err := newAPIClient(*configPath, *baseURL, *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	path := "/simulate/outage"
+	if *profile != "" {
+		path = profilePathPrefix(*profile) + path
+	}
+
+	var resp SimulateOutageResponse
+	req := SimulateOutageRequest{Target: *target, Duration: duration.String()}
+	if err := client.do(http.MethodPost, path, req, &resp); err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("🧪 [SIMULATED] %s への擬似障害を %s まで注入しました\n", resp.Target, resp.Until.Local().Format("15:04:05"))
+	return 0
+}
+
+// runResend asks a running instance to send a stored daily report again
+func runResend(args []string) int {
+	fs := flag.NewFlagSet("resend", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "設定ファイルのパス")
+	date := fs.String("date", time.Now().AddDate(0, 0, -1).Format(reportDateLayout), "再送する日次レポートの日付（YYYY-MM-DD、省略時は昨日）")
+	baseURL := fs.String("url", "", "稼働中インスタンスのURL（省略時はconfigのhttp_listen）")
+	token := fs.String("token", "", "APIトークン（省略時はconfigのapi_token）")
+	profile := fs.String("profile", "", "対象のプロファイル（プロファイルを定義している場合）")
+	fs.Parse(args)
+
+	client, err := newAPIClient(*configPath, *baseURL, *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	path := "/report/resend"
+	if *profile != "" {
+		path = profilePathPrefix(*profile) + path
+	}
+
+	var d ReportDelivery
+	if err := client.do(http.MethodPost, path, ResendReportRequest{Date: *date}, &d); err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	if !d.Confirmed {
+		fmt.Printf("⚠️ %sの日次レポートを再送しましたが、配信を確認できませんでした: %s\n", d.Date, d.Error)
+		return 1
+	}
+	fmt.Printf("📨 %sの日次レポートを再送しました（メッセージID: %s）\n", d.Date, strings.Join(d.MessageIDs, ", "))
+	return 0
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// clockFloorDate is the earliest plausible date (YYYY-MM-DD), set at build
+// time with -ldflags "-X main.clockFloorDate=2026-10-01"; without it the
+// build's VCS commit time is used, and defaultClockFloor without that
+var clockFloorDate = ""
+
+// defaultClockFloor is the floor of builds without a date of their own
+const defaultClockFloor = "2025-01-01"
+
+// buildClockFloor returns the floor this binary was built with
+func buildClockFloor() time.Time {
+	if t, err := time.ParseInLocation(reportDateLayout, clockFloorDate, time.Local); err == nil {
+		return t
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.time" {
+				if t, err := time.Parse(time.RFC3339, s.Value); err == nil {
+					return t
+				}
+			}
+		}
+	}
+	t, _ := time.ParseInLocation(reportDateLayout, defaultClockFloor, time.Local)
+	return t
+}
+
+// clockFloor returns clock_floor, or the floor of the build without one
+func (c Config) clockFloor() time.Time {
+	if t, err := time.ParseInLocation(reportDateLayout, c.ClockFloor, time.Local); err == nil {
+		return t
+	}
+	return buildClockFloor()
+}
+
+// clockGuard holds reports back while the clock is implausibly early, as
+// on a board without a real-time clock that boots at 1970 or at its last
+// shutdown. Probing goes on; when the clock is set, the samples taken so
+// far are moved onto the corrected time.
+type clockGuard struct {
+	mutex sync.Mutex
+	floor time.Time
+	// start is when the guard began waiting, with its monotonic reading,
+	// which keeps counting across the clock being set
+	start time.Time
+	// waited is set when the clock was early at startup, and waiting
+	// until it was set
+	waited  bool
+	waiting bool
+	// step is how far the clock was set forward
+	step time.Duration
+}
+
+// newClockGuard starts waiting when now is before floor
+func newClockGuard(floor, now time.Time) *clockGuard {
+	early := now.Before(floor)
+	return &clockGuard{floor: floor, start: now, waited: early, waiting: early}
+}
+
+// waitingForClock tells whether reports are held back
+func (g *clockGuard) waitingForClock() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.waiting
+}
+
+// settle ends the wait once now has reached the floor. It returns how far
+// the clock was set forward, measured against the monotonic clock, and
+// false for anchored when that cannot be measured, such as with a clock
+// without monotonic readings; ok is false while still waiting or when the
+// wait ended before.
+func (g *clockGuard) settle(now time.Time) (step time.Duration, anchored, ok bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if !g.waiting || now.Before(g.floor) {
+		return 0, false, false
+	}
+	g.waiting = false
+	// Sub uses the monotonic readings when both times have them
+	g.step = now.Round(0).Sub(g.start.Round(0)) - now.Sub(g.start)
+	return g.step, g.step != 0, true
+}
+
+// status returns the guard's state, nil when the clock was fine throughout
+func (g *clockGuard) status() *ClockStatus {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if !g.waited {
+		return nil
+	}
+	return &ClockStatus{Waiting: g.waiting, Floor: g.floor, StepSeconds: g.step.Seconds()}
+}
+
+// shiftBefore returns a function moving times before floor forward by step
+func shiftBefore(floor time.Time, step time.Duration) func(time.Time) time.Time {
+	return func(t time.Time) time.Time {
+		if t.IsZero() || !t.Before(floor) {
+			return t
+		}
+		return t.Add(step)
+	}
+}
+
+// clockAnchor moves the period's samples from before the clock was set
+// onto the corrected time, or drops them as unanchored when the step is
+// unknown, and feeds those kept to the period's samples. It returns the
+// day of the earliest sample kept.
+func (pm *PingMonitor) clockAnchor(step time.Duration, anchored bool, now time.Time) string {
+	floor := pm.clock.floor
+	shift := shiftBefore(floor, step)
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	// Nothing reached the samples while waiting
+	defer func() {
+		replaySamples(pm.samples, pm.unanchoredResults, pm.unanchoredFailureTimesLocked())
+		pm.unanchoredResults = nil
+		pm.unanchoredFailures = 0
+	}()
+	earliest := now
+	if anchored {
+		for i := range pm.unanchoredResults {
+			r := &pm.unanchoredResults[i]
+			r.Timestamp, r.Completed = shift(r.Timestamp), shift(r.Completed)
+		}
+		for i, t := range pm.unreachableTimes {
+			pm.unreachableTimes[i] = shift(t)
+		}
+		for i := range pm.measurementSpans {
+			s := &pm.measurementSpans[i]
+			s.Start, s.End = shift(s.Start), shift(s.End)
+		}
+		for i := range pm.periodOutages {
+			o := &pm.periodOutages[i]
+			o.Start, o.End = shift(o.Start), shift(o.End)
+		}
+		pm.warmupUntil = shift(pm.warmupUntil)
+		pm.periodStart = shift(pm.periodStart)
+		if len(pm.unanchoredResults) > 0 && pm.unanchoredResults[0].Timestamp.Before(earliest) {
+			earliest = pm.unanchoredResults[0].Timestamp
+		}
+		if len(pm.unreachableTimes) > 0 && pm.unreachableTimes[0].Before(earliest) {
+			earliest = pm.unreachableTimes[0]
+		}
+		return earliest.Format(reportDateLayout)
+	}
+
+	kept := pm.unanchoredResults[:0]
+	for _, r := range pm.unanchoredResults {
+		if r.Timestamp.Before(floor) {
+			pm.unanchoredCount++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	pm.unanchoredResults = kept
+	// What came before the floor is gone with its unknown times
+	if pm.periodStart.Before(floor) {
+		pm.periodStart = floor
+	}
+	// Failures are kept aligned with their gateway diagnostics
+	times := []time.Time{}
+	var gateways []gatewayState
+	fed := len(pm.unreachableTimes) - pm.unanchoredFailures
+	pm.unanchoredFailures = 0
+	for i, t := range pm.unreachableTimes {
+		if t.Before(floor) {
+			pm.unanchoredCount++
+			continue
+		}
+		times = append(times, t)
+		if i >= fed {
+			pm.unanchoredFailures++
+		}
+		if i < len(pm.failureGateways) {
+			gateways = append(gateways, pm.failureGateways[i])
+		}
+	}
+	pm.unreachableTimes, pm.failureGateways = times, gateways
+	// and so are the outages and measurement errors in that time
+	pm.periodOutages = slices.DeleteFunc(pm.periodOutages, func(o OutageRecord) bool { return o.Start.Before(floor) })
+	pm.measurementSpans = slices.DeleteFunc(pm.measurementSpans, func(s measurementSpan) bool { return s.Start.Before(floor) })
+	return now.Format(reportDateLayout)
+}
+
+// checkClock ends the wait for the clock once it is set, and tells the
+// ping loop to restart its grid, since the step is no suspension
+func (pm *PingMonitor) checkClock(now time.Time) bool {
+	step, anchored, ok := pm.clock.settle(now)
+	if !ok {
+		return false
+	}
+	pm.clockSet(step, anchored, now)
+	return true
+}
+
+// clockSet moves what was measured while waiting onto the corrected time
+// and resumes the reports from the earliest day kept
+func (pm *PingMonitor) clockSet(step time.Duration, anchored bool, now time.Time) {
+	day := pm.clockAnchor(step, anchored, now)
+	if anchored {
+		pm.outages.shift(shiftBefore(pm.clock.floor, step))
+	}
+	pm.reports.restart(day)
+	if anchored {
+		fmt.Printf("🕒 時計が %v 進められました。それまでの計測の時刻を補正し、レポートの締めを再開します\n", step.Round(time.Second))
+	} else {
+		fmt.Println("🕒 時計が合いました。補正できないそれまでの計測を除外し、レポートの締めを再開します")
+	}
+}
+
+// clockNote returns "時刻不明で除外: n件" prefixed with sep, or "" when
+// every sample had a valid time
+func (p *reportPeriod) clockNote(sep string) string {
+	if p.UnanchoredCount == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s時刻不明で除外: %s件", sep, p.Format.count(p.UnanchoredCount))
+}
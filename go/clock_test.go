@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestClockStepForward boots with the clock at 2020-01-01 23:59, past a
+// midnight of the wrong year, until the clock is set forward to 2026-03-10
+// 23:58: nothing is closed while waiting, and the day then reported holds
+// the samples taken before the clock was set, on their corrected times
+// when the step is known and dropped when it is not
+func TestClockStepForward(t *testing.T) {
+	setLocal(t, "Asia/Tokyo")
+	quietStdout(t)
+	boot := time.Date(2020, 1, 1, 23, 59, 0, 0, time.Local)
+	set := time.Date(2026, 3, 10, 23, 58, 0, 0, time.Local)
+	for _, anchored := range []bool{true, false} {
+		pm, clock := newTestMonitor(t, map[string]interface{}{"ping_interval": "1s", "clock_floor": "2026-03-01", "state_dir": t.TempDir()}, boot)
+		pm.clock = newClockGuard(pm.config.clockFloor(), boot)
+		var mutex sync.Mutex
+		var periods []*reportPeriod
+		pm.reports.emit = func(p *reportPeriod) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			periods = append(periods, p)
+		}
+		pm.prober = probeFunc(func(host string) (float64, error) {
+			if s := clock.now().Sub(boot) / time.Second; host == pm.targetIP && s >= 10 && s < 20 {
+				return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+			}
+			return 10, nil
+		})
+		for s := 0; s < 120; s++ {
+			at := boot.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+		}
+		pm.reports.flush()
+		if len(periods) != 0 {
+			t.Fatalf("anchored %v: %d reports while waiting for the clock", anchored, len(periods))
+		}
+		if _, err := pm.history.loadDay("2020-01-01"); err == nil {
+			t.Errorf("anchored %v: the boot day was saved", anchored)
+		}
+		if c := pm.status().Clock; c == nil || !c.Waiting {
+			t.Errorf("anchored %v: clock status %+v, want waiting", anchored, c)
+		}
+
+		clock.set(set)
+		if anchored {
+			// The virtual clock has no monotonic readings, so the step is
+			// given as the loop would have measured it
+			if _, _, ok := pm.clock.settle(set); !ok {
+				t.Fatal("the clock set forward did not end the wait")
+			}
+			pm.clockSet(set.Sub(boot.Add(120*time.Second)), true, set)
+		} else if !pm.checkClock(set) {
+			t.Fatal("the clock set forward did not end the wait")
+		}
+		if c := pm.status().Clock; c == nil || c.Waiting {
+			t.Errorf("anchored %v: clock status %+v after the step", anchored, c)
+		}
+		for s := 0; s < 180; s++ {
+			at := set.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+		}
+		pm.reports.flush()
+
+		if len(periods) != 1 || periods[0].Date != "2026-03-10" {
+			t.Fatalf("anchored %v: reports %d, want the one of 2026-03-10", anchored, len(periods))
+		}
+		p := periods[0]
+		if _, err := pm.history.loadDay("2026-03-10"); err != nil {
+			t.Errorf("anchored %v: day not saved: %v", anchored, err)
+		}
+		stats := p.probeStats()
+		if !anchored {
+			if stats.Total != 120 || p.UnanchoredCount != 120 || len(p.UnreachableTimes) != 0 || len(p.Outages) != 0 || !p.Start.Equal(time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)) {
+				t.Errorf("unanchored: %d pings, %d dropped, %d failures, outages %+v, start %s",
+					stats.Total, p.UnanchoredCount, len(p.UnreachableTimes), p.Outages, p.Start)
+			}
+			if got := p.clockNote(" / "); got != " / 時刻不明で除外: 120件" {
+				t.Errorf("unanchored: note %q", got)
+			}
+			continue
+		}
+		// The first two minutes land at 23:56-23:58
+		moved := set.Add(-120 * time.Second)
+		if stats.Total != 240 || p.UnanchoredCount != 0 || !p.Start.Equal(moved) {
+			t.Errorf("anchored: %d pings, %d dropped, start %s; want 240, 0, %s", stats.Total, p.UnanchoredCount, p.Start, moved)
+		}
+		if len(p.UnreachableTimes) != 10 || !p.UnreachableTimes[0].Equal(moved.Add(10*time.Second)) {
+			t.Errorf("anchored: failures %v", p.UnreachableTimes)
+		}
+		if len(p.Outages) != 1 || !p.Outages[0].Start.Equal(moved.Add(10*time.Second)) || !p.Outages[0].End.Equal(moved.Add(20*time.Second)) {
+			t.Errorf("anchored: outages %+v", p.Outages)
+		}
+	}
+}
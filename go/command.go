@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// commandTimeout bounds auxiliary commands such as route lookups
+	commandTimeout = 5 * time.Second
+	// commandKillGrace is how long a command may overrun its own timeout
+	// before it is killed
+	commandKillGrace = 2 * time.Second
+	// commandWaitDelay bounds waiting for output pipes after the kill, so a
+	// grandchild holding them open cannot block us
+	commandWaitDelay = time.Second
+	// maxCommandOutput caps the captured output of a single command
+	maxCommandOutput = 64 * 1024
+	// defaultMaxProcesses is the cap on external commands running at once
+	// without self_protection.max_processes
+	defaultMaxProcesses = 32
+)
+
+// errOutputTooLarge is returned when a command exceeded maxCommandOutput
+var errOutputTooLarge = errors.New("command output too large")
+
+// errSaturated is returned instead of starting a command while the cap on
+// running commands is reached
+var errSaturated = errors.New("too many external commands running")
+
+// commandStats holds the process-wide command counters
+var commandStats struct {
+	runs, killed, truncated, running, skipped atomic.Int64
+	started                                   startWindow
+}
+
+// commandSlots holds one token per running command. It is replaced only by
+// setMaxProcesses, before any command runs.
+var commandSlots = make(chan struct{}, defaultMaxProcesses)
+
+// setMaxProcesses sets the cap on external commands running at once
+func setMaxProcesses(n int) {
+	commandSlots = make(chan struct{}, n)
+}
+
+// commandStatsSnapshot returns the current command counters
+func commandStatsSnapshot() CommandStats {
+	return CommandStats{
+		Runs:              commandStats.runs.Load(),
+		Killed:            commandStats.killed.Load(),
+		Truncated:         commandStats.truncated.Load(),
+		Running:           commandStats.running.Load(),
+		MaxProcesses:      cap(commandSlots),
+		Skipped:           commandStats.skipped.Load(),
+		StartedLastMinute: commandStats.started.lastMinute(time.Now()),
+	}
+}
+
+// startWindow counts the commands started in each second of the last
+// minute
+type startWindow struct {
+	mutex sync.Mutex
+	// counts[i] is the number started in the Unix second seconds[i]
+	counts  [60]int64
+	seconds [60]int64
+}
+
+// add counts one command started at now
+func (w *startWindow) add(now time.Time) {
+	s := now.Unix()
+	i := s % int64(len(w.counts))
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.seconds[i] != s {
+		w.seconds[i], w.counts[i] = s, 0
+	}
+	w.counts[i]++
+}
+
+// lastMinute returns the number started in the minute up to now
+func (w *startWindow) lastMinute(now time.Time) int64 {
+	s := now.Unix()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var n int64
+	for i, c := range w.counts {
+		if age := s - w.seconds[i]; age >= 0 && age < int64(len(w.counts)) {
+			n += c
+		}
+	}
+	return n
+}
+
+// cappedBuffer keeps the first max bytes written and discards the rest, so
+// a runaway command cannot exhaust memory
+type cappedBuffer struct {
+	buf      []byte
+	max      int
+	overflow bool
+}
+
+// Write stores what fits and reports success for the rest
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room < len(p) {
+		b.overflow = true
+		p = p[:max(room, 0)]
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// newCommand prepares an external command bound to ctx, at the niceness of
+// self_protection.probe_nice. On Unix the C locale is forced so that output
+// parsing does not depend on the host's language settings; Windows ignores
+// these variables and keeps its localized output.
+func newCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	name, args = niceArgs(name, args)
+	cmd := exec.CommandContext(ctx, name, args...)
+	if runtime.GOOS != "windows" {
+		cmd.Env = append(os.Environ(), "LANG=C", "LC_ALL=C")
+	}
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+// runCommand runs a command and returns its capped standard output. The
+// command is killed when it overruns timeout by commandKillGrace; Run always
+// waits for the process, so no zombie is left behind. While the cap on
+// running commands is reached it returns errSaturated without starting
+// one: a queued probe would measure late and the queue would only grow.
+func runCommand(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	slots := commandSlots
+	select {
+	case slots <- struct{}{}:
+	default:
+		commandStats.skipped.Add(1)
+		return nil, errSaturated
+	}
+	defer func() { <-slots }()
+	commandStats.running.Add(1)
+	defer commandStats.running.Add(-1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+commandKillGrace)
+	defer cancel()
+
+	out := &cappedBuffer{max: maxCommandOutput}
+	cmd := newCommand(ctx, name, args...)
+	// stderr is kept too, since ping reports resolution errors there
+	cmd.Stdout = out
+	cmd.Stderr = out
+	commandStats.runs.Add(1)
+	commandStats.started.add(time.Now())
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		commandStats.killed.Add(1)
+		return out.buf, ctx.Err()
+	}
+	if out.overflow {
+		commandStats.truncated.Add(1)
+		if err == nil {
+			err = errOutputTooLarge
+		}
+	}
+	return out.buf, err
+}
+
+// writeCommandMetrics writes the external command counters and gauges
+func writeCommandMetrics(w *strings.Builder) {
+	s := commandStatsSnapshot()
+	fmt.Fprintf(w, "# HELP ping_monitor_commands_started_total External commands started (ping, ip, route, ...).\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_commands_started_total counter\n")
+	fmt.Fprintf(w, "ping_monitor_commands_started_total %d\n", s.Runs)
+	fmt.Fprintf(w, "# HELP ping_monitor_commands_running External commands running now.\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_commands_running gauge\n")
+	fmt.Fprintf(w, "ping_monitor_commands_running %d\n", s.Running)
+	fmt.Fprintf(w, "# HELP ping_monitor_commands_max Cap on external commands running at once (self_protection.max_processes).\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_commands_max gauge\n")
+	fmt.Fprintf(w, "ping_monitor_commands_max %d\n", s.MaxProcesses)
+	fmt.Fprintf(w, "# HELP ping_monitor_commands_skipped_total External commands not started because the cap was reached.\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_commands_skipped_total counter\n")
+	fmt.Fprintf(w, "ping_monitor_commands_skipped_total %d\n", s.Skipped)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestRunCommandSaturated holds the only slot with a script that sleeps
+// until released: the next command is refused without starting, and the
+// slot is free again once the script exits
+func TestRunCommandSaturated(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	setMaxProcesses(1)
+	t.Cleanup(func() { setMaxProcesses(defaultMaxProcesses) })
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hold.sh")
+	release := filepath.Join(dir, "release")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nwhile [ ! -e \"$1\" ]; do sleep 0.05; done\necho done\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	runs, skipped, running := commandStats.runs.Load(), commandStats.skipped.Load(), commandStats.running.Load()
+
+	held := make(chan error, 1)
+	go func() {
+		_, err := runCommand(10*time.Second, script, release)
+		held <- err
+	}()
+	for deadline := time.Now().Add(5 * time.Second); commandStats.running.Load() != running+1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the script never started")
+		}
+	}
+
+	if out, err := runCommand(time.Second, "true"); !errors.Is(err, errSaturated) || out != nil {
+		t.Fatalf("second command: %q, %v; want errSaturated", out, err)
+	}
+	s := commandStatsSnapshot()
+	if s.Skipped != skipped+1 || s.Running != running+1 || s.Runs != runs+1 || s.MaxProcesses != 1 {
+		t.Errorf("while saturated: skipped %d running %d runs %d max %d, want %d %d %d 1",
+			s.Skipped, s.Running, s.Runs, s.MaxProcesses, skipped+1, running+1, runs+1)
+	}
+
+	if err := os.WriteFile(release, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-held:
+		if err != nil {
+			t.Fatalf("script: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the script did not exit")
+	}
+	if got := commandStats.running.Load(); got != running {
+		t.Errorf("running %d after the script exited, want %d", got, running)
+	}
+	if out, err := runCommand(time.Second, script, release); err != nil || string(out) != "done\n" {
+		t.Errorf("after the release: %q, %v", out, err)
+	}
+	if got := commandStats.skipped.Load(); got != skipped+1 {
+		t.Errorf("skipped %d, want %d", got, skipped+1)
+	}
+}
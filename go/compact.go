@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// CompactReportConfig decides which days get the short daily report. A
+// clean day, with no outage and every figure within the limits below, is
+// sent as a single summary field instead of the full report.
+type CompactReportConfig struct {
+	// Detailed always sends the full report
+	Detailed bool `json:"detailed"`
+	// MaxLossPercent is the most loss a clean day may have (default 0.1)
+	MaxLossPercent float64 `json:"max_loss_percent"`
+	// MaxP95Ms is the highest p95 of a clean day; 0 for latency_warn_ms
+	MaxP95Ms float64 `json:"max_p95_ms"`
+}
+
+// validate checks the limits
+func (c CompactReportConfig) validate() error {
+	if c.MaxLossPercent < 0 || c.MaxLossPercent > 100 {
+		return fmt.Errorf("compact_report.max_loss_percent は0〜100で指定してください: %v", c.MaxLossPercent)
+	}
+	if c.MaxP95Ms < 0 {
+		return fmt.Errorf("compact_report.max_p95_ms は0以上で指定してください（0で latency_warn_ms）")
+	}
+	return nil
+}
+
+// uncleanReason returns why p needs the full report, or "" for a clean
+// day. Anything the full report shows beyond the basic figures, such as
+// deferred notifications or route changes, also makes the day unclean, so
+// the short report never hides an event.
+func (pm *PingMonitor) uncleanReason(p *reportPeriod) string {
+	cfg := pm.config.CompactReport
+	maxP95 := cfg.MaxP95Ms
+	if maxP95 == 0 {
+		maxP95 = pm.config.LatencyWarnMs
+	}
+	stats := p.probeStats()
+	switch {
+	case cfg.Detailed:
+		return "compact_report.detailed"
+	case p.Interim:
+		return "途中経過"
+	case p.SimulatedCount > 0:
+		return "擬似障害"
+	case !p.Coverage.Sufficient:
+		return "データ不足"
+	case p.outageCount() > 0:
+		return "障害"
+	case 100-stats.SuccessRate > cfg.MaxLossPercent:
+		return fmt.Sprintf("損失率 %.3f%% > %v%%", 100-stats.SuccessRate, cfg.MaxLossPercent)
+	case stats.Latency.P95 > maxP95:
+		return fmt.Sprintf("p95 %.1fms > %vms", stats.Latency.P95, maxP95)
+	case p.Regression != nil:
+		return "昨日比で悪化"
+	case len(p.Deferred) > 0:
+		return "保留された通知"
+	case len(p.MeasurementSpans) > 0, p.ScheduleGaps > 0:
+		return "計測不良"
+	case len(p.AddressChanges) > 0, p.ResolveFailures > 0:
+		return "IPアドレスの変更"
+	case len(p.RouteChanges) > 0, p.RouteFlaps > 0:
+		return "経路の変化"
+	case len(p.WANErrors) > 0:
+		return "WAN側のエラー"
+	case len(p.ConnectionChanges) > 0:
+		return "接続の切り替え"
+	}
+	for _, t := range p.Targets {
+		if s := t.stats(); s.Failure > 0 && 100-s.SuccessRate > cfg.MaxLossPercent {
+			return "追加の監視対象の損失"
+		}
+	}
+	for _, r := range p.Bufferbloat {
+		if r.Error != "" || r.Grade == "D" || r.Grade == "F" {
+			return "負荷時の遅延"
+		}
+	}
+	return ""
+}
+
+// compactReportEmbed renders a clean day as a single summary field. The
+// one-off failures of a clean day are still counted, with their times.
+func (pm *PingMonitor) compactReportEmbed(p *reportPeriod) DiscordEmbed {
+	stats := p.probeStats()
+	f := p.Format
+	title := "🌐 Ping Monitor 日次レポート"
+	if quality, ok := pm.quality(p); ok {
+		title += "（" + quality.String() + "）"
+	}
+	value := fmt.Sprintf("**成功率**: %s（%s回中 失敗%s回）\n**平均**: %s / **p95**: %s / **最大**: %s",
+		f.percent(stats.SuccessRate, 2), f.count(stats.Total), f.count(stats.Failure),
+		f.ms(stats.Latency.Avg), f.ms(stats.Latency.P95), f.ms(stats.Latency.Max))
+	if stats.Failure > 0 {
+		value += "\n**失敗の時刻**: " + p.formatFailureWindow()
+	}
+	return DiscordEmbed{
+		Title:       title,
+		Description: fmt.Sprintf("**日付**: %s\n**対象期間**: %s\n**対象**: %s", p.Date, f.period(p.Start, p.End), pm.targetLabel()),
+		Color:       0x00ff00,
+		Fields: []EmbedField{{
+			Name:  "✅ 問題なし",
+			Value: value,
+		}},
+		Timestamp: pm.now().Format(time.RFC3339),
+		Footer: EmbedFooter{
+			Text: "Ping Monitor by Go（簡易版）" + p.controlActionsNote() + pm.deliveryNote(p.Date),
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestUncleanReason samples 1000 seconds and checks which days get the
+// short report, at the boundaries of each rule
+func TestUncleanReason(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	timeout := &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+	measurement := &probeError{reason: reasonMeasurement, err: errors.New("ping: not found")}
+	// extraTarget is an additional target with failures out of 1000
+	extraTarget := func(failures int) func(p *reportPeriod) {
+		return func(p *reportPeriod) {
+			tp := targetPeriod{Name: "1.1.1.1", Address: "1.1.1.1"}
+			for s := 0; s < 1000; s++ {
+				if s < failures {
+					tp.UnreachableTimes = append(tp.UnreachableTimes, start.Add(time.Duration(s)*time.Second))
+				} else {
+					tp.Latency.add(10)
+				}
+			}
+			p.Targets = append(p.Targets, tp)
+		}
+	}
+	tests := []struct {
+		name string
+		cfg  map[string]interface{}
+		// probe answers the sample at second s
+		probe  func(s int) (float64, error)
+		mutate func(p *reportPeriod)
+		want   string
+		// warmup ends the day before the warm-up did
+		warmup bool
+	}{
+		{"clean", nil, nil, nil, "", false},
+		{"one 1-second blip", nil, func(s int) (float64, error) {
+			if s == 500 {
+				return 0, timeout
+			}
+			return 10, nil
+		}, nil, "", false},
+		{"two blips", nil, func(s int) (float64, error) {
+			if s == 200 || s == 700 {
+				return 0, timeout
+			}
+			return 10, nil
+		}, nil, "損失率 0.200% > 0.1%", false},
+		{"blip reaching the failure threshold", nil, func(s int) (float64, error) {
+			if s >= 500 && s < 503 {
+				return 0, timeout
+			}
+			return 10, nil
+		}, nil, "障害", false},
+		{"p95 at the limit", nil, func(int) (float64, error) { return 100, nil }, nil, "", false},
+		{"p95 over the limit", nil, func(int) (float64, error) { return 101, nil }, nil, "p95 101.0ms > 100ms", false},
+		{"p95 over max_p95_ms", map[string]interface{}{"compact_report": map[string]interface{}{"max_loss_percent": 0.1, "max_p95_ms": 50}},
+			func(int) (float64, error) { return 60, nil }, nil, "p95 60.0ms > 50ms", false},
+		{"measurement errors without failures", nil, func(s int) (float64, error) {
+			if s >= 10 && s < 13 {
+				return 0, measurement
+			}
+			return 10, nil
+		}, nil, "計測不良", false},
+		{"warm-up only", nil, nil, nil, "データ不足", true},
+		{"only an extra target failing", nil, nil, extraTarget(2), "追加の監視対象の損失", false},
+		{"one blip on an extra target", nil, nil, extraTarget(1), "", false},
+		{"always detailed", map[string]interface{}{"compact_report": map[string]interface{}{"detailed": true}}, nil, nil, "compact_report.detailed", false},
+	}
+	for _, tt := range tests {
+		cfg := map[string]interface{}{"ping_interval": "1s", "min_report_coverage": "1m", "min_report_samples": 10}
+		for k, v := range tt.cfg {
+			cfg[k] = v
+		}
+		pm, clock := newTestMonitor(t, cfg, start)
+		if tt.warmup {
+			pm.warmupUntil = start.Add(time.Hour)
+		}
+		if tt.probe != nil {
+			pm.prober = probeFunc(func(string) (float64, error) {
+				return tt.probe(int(clock.now().Sub(start) / time.Second))
+			})
+		}
+		for s := 0; s < 1000; s++ {
+			at := start.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+		}
+		p := pm.takePeriod(start.Format(reportDateLayout))
+		if tt.mutate != nil {
+			tt.mutate(p)
+		}
+		if got := pm.uncleanReason(p); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// madScale converts a median absolute deviation into a standard deviation
+// estimate for normally distributed data
+const madScale = 1.4826
+
+// ComparisonResult represents the output of the compare subcommand
+type ComparisonResult struct {
+	Before       ProbeStats `json:"before"`
+	After        ProbeStats `json:"after"`
+	Delta        StatsDelta `json:"delta"`
+	Score        float64    `json:"score"`
+	Significance string     `json:"significance"`
+}
+
+// MarshalJSON writes an infinite score, which JSON cannot represent, as
+// "score": null with "significance_infinite": true
+func (r ComparisonResult) MarshalJSON() ([]byte, error) {
+	type plain ComparisonResult
+	out := struct {
+		plain
+		Score    *float64 `json:"score"`
+		Infinite bool     `json:"significance_infinite,omitempty"`
+	}{plain: plain(r)}
+	if math.IsInf(r.Score, 0) {
+		out.Infinite = true
+	} else {
+		out.Score = &r.Score
+	}
+	return json.Marshal(out)
+}
+
+// StatsDelta holds after-minus-before differences
+type StatsDelta struct {
+	SuccessRate float64 `json:"success_rate"`
+	Avg         float64 `json:"avg_ms"`
+	Median      float64 `json:"median_ms"`
+	P95         float64 `json:"p95_ms"`
+	Min         float64 `json:"min_ms"`
+	Max         float64 `json:"max_ms"`
+}
+
+// compareStats computes deltas and a robust significance indicator: the
+// difference in medians divided by the pooled (scaled) MAD
+func compareStats(before, after ProbeStats) ComparisonResult {
+	res := ComparisonResult{
+		Before: before,
+		After:  after,
+		Delta: StatsDelta{
+			SuccessRate: after.SuccessRate - before.SuccessRate,
+			Avg:         after.Latency.Avg - before.Latency.Avg,
+			Median:      after.Latency.Median - before.Latency.Median,
+			P95:         after.Latency.P95 - before.Latency.P95,
+			Min:         after.Latency.Min - before.Latency.Min,
+			Max:         after.Latency.Max - before.Latency.Max,
+		},
+	}
+
+	if before.Latency.Count == 0 || after.Latency.Count == 0 {
+		res.Significance = "判定不能"
+		return res
+	}
+
+	pooled := madScale * math.Sqrt((before.Latency.MAD*before.Latency.MAD+after.Latency.MAD*after.Latency.MAD)/2)
+	switch {
+	case pooled == 0 && res.Delta.Median == 0:
+		res.Score = 0
+	case pooled == 0:
+		res.Score = math.Inf(1)
+	default:
+		res.Score = math.Abs(res.Delta.Median) / pooled
+	}
+
+	switch {
+	case res.Score >= 2:
+		res.Significance = "有意な差あり"
+	case res.Score >= 1:
+		res.Significance = "差がある可能性"
+	default:
+		res.Significance = "有意な差なし"
+	}
+	return res
+}
+
+// runCompare compares statistics of two results files offline
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	beforePath := fs.String("before", "", "変更前の結果ファイル (JSONL)")
+	afterPath := fs.String("after", "", "変更後の結果ファイル (JSONL)")
+	jsonOutput := fs.Bool("json", false, "JSON形式で出力する")
+	fs.Parse(args)
+
+	if *beforePath == "" || *afterPath == "" {
+		fmt.Fprintln(os.Stderr, "エラー: --before と --after を指定してください")
+		fs.Usage()
+		return 2
+	}
+
+	before, err := readResults(*beforePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	after, err := readResults(*afterPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+
+	res := compareStats(statsFromRecords(before), statsFromRecords(after))
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+		return 0
+	}
+
+	printComparison(res)
+	return 0
+}
+
+// printComparison prints a comparison table to the console
+func printComparison(res ComparisonResult) {
+	b, a, d := res.Before, res.After, res.Delta
+	// The labels are padded by terminal columns, as Japanese takes two
+	row := func(label string, cells ...string) {
+		line := padRight(label, 12)
+		for _, c := range cells {
+			line += " " + padLeft(c, 12)
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	ms := func(v float64) string { return fmt.Sprintf("%.1fms", v) }
+	delta := func(v float64) string { return fmt.Sprintf("%+.1fms", v) }
+	row("", "変更前", "変更後", "差分")
+	row("総ping回数", fmt.Sprint(b.Total), fmt.Sprint(a.Total), fmt.Sprint(a.Total-b.Total))
+	row("成功率", fmt.Sprintf("%.2f%%", b.SuccessRate), fmt.Sprintf("%.2f%%", a.SuccessRate), fmt.Sprintf("%+.2f%%", d.SuccessRate))
+	row("平均", ms(b.Latency.Avg), ms(a.Latency.Avg), delta(d.Avg))
+	row("中央値", ms(b.Latency.Median), ms(a.Latency.Median), delta(d.Median))
+	row("p95", ms(b.Latency.P95), ms(a.Latency.P95), delta(d.P95))
+	row("最小", ms(b.Latency.Min), ms(a.Latency.Min), delta(d.Min))
+	row("最大", ms(b.Latency.Max), ms(a.Latency.Max), delta(d.Max))
+	row("MAD", ms(b.Latency.MAD), ms(a.Latency.MAD))
+	if math.IsInf(res.Score, 0) {
+		fmt.Printf("\n判定: %s（中央値差/MAD = ∞）\n", res.Significance)
+	} else {
+		fmt.Printf("\n判定: %s（中央値差/MAD = %.2f）\n", res.Significance, res.Score)
+	}
+}
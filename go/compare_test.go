@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeResults writes a results file of one success per value
+func writeResults(t *testing.T, values ...float64) string {
+	t.Helper()
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	var data []byte
+	for i, v := range values {
+		line, _ := json.Marshal(ResultRecord{Timestamp: start.Add(time.Duration(i) * time.Second), Target: "8.8.8.8", Success: true, ResponseTime: v})
+		data = append(append(data, line...), '\n')
+	}
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestCompareJSON writes an infinite score, from medians apart with no
+// spread at all, as null with significance_infinite
+func TestCompareJSON(t *testing.T) {
+	tests := []struct {
+		before, after []float64
+		score         interface{}
+		infinite      bool
+	}{
+		{[]float64{10, 10, 10}, []float64{20, 20, 20}, nil, true},
+		{[]float64{10, 10, 10}, []float64{10, 10, 10}, 0.0, false},
+		{[]float64{10, 11, 12, 13}, []float64{10, 11, 12, 13}, 0.0, false},
+	}
+	for _, tt := range tests {
+		code := -1
+		out := captureStdout(t, func() {
+			code = runCompare([]string{"--before", writeResults(t, tt.before...), "--after", writeResults(t, tt.after...), "--json"})
+		})
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(out), &got); err != nil || code != 0 {
+			t.Fatalf("%v/%v: exit %d, %v in %q", tt.before, tt.after, code, err, out)
+		}
+		score, ok := got["score"]
+		if !ok || score != tt.score {
+			t.Errorf("%v/%v: score %v, want %v", tt.before, tt.after, score, tt.score)
+		}
+		if infinite, _ := got["significance_infinite"].(bool); infinite != tt.infinite {
+			t.Errorf("%v/%v: significance_infinite %v", tt.before, tt.after, got["significance_infinite"])
+		}
+		if _, ok := got["significance_infinite"]; ok != tt.infinite {
+			t.Errorf("%v/%v: significance_infinite present %v", tt.before, tt.after, ok)
+		}
+	}
+}
+
+// TestPrintComparison lines up the columns on a terminal despite the
+// labels in Japanese
+func TestPrintComparison(t *testing.T) {
+	res := compareStats(statsFromRecords(nil), statsFromRecords(nil))
+	out := captureStdout(t, func() { printComparison(res) })
+	lines := strings.Split(out, "\n")
+	if len(lines) < 10 {
+		t.Fatalf("output %q", out)
+	}
+	for _, line := range lines[:8] {
+		if w := displayWidth(line); w != 12+3*13 {
+			t.Errorf("%q is %d columns wide, want %d", line, w, 12+3*13)
+		}
+	}
+	if !strings.HasPrefix(lines[1], "総ping回数   ") || !strings.HasPrefix(lines[2], "成功率       ") {
+		t.Errorf("labels not padded to 12 columns:\n%s", out)
+	}
+	if w := displayWidth(lines[8]); w != 12+2*13 {
+		t.Errorf("MAD line %q is %d columns wide", lines[8], w)
+	}
+}
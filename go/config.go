@@ -0,0 +1,590 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is prepended to the upper-cased json key of each Config field
+// to form its environment variable override (e.g. PING_MONITOR_API_TOKEN)
+const envPrefix = "PING_MONITOR_"
+
+// Config represents the configuration structure.
+// Fields tagged with secret:"true" are masked by redactConfig before being
+// shown anywhere outside the process.
+type Config struct {
+	Target                string            `json:"target"`
+	TargetResolveInterval Duration          `json:"target_resolve_interval"`
+	PingInterval          Duration          `json:"ping_interval"`
+	DiscordWebhookURL     string            `json:"discord_webhook_url" secret:"true"`
+	HTTPListen            string            `json:"http_listen"`
+	APIToken              string            `json:"api_token" secret:"true"`
+	Gateway               string            `json:"gateway"`
+	GatewayCandidates     []string          `json:"gateway_candidates"`
+	ResultsFile           string            `json:"results_file"`
+	StateDir              string            `json:"state_dir"`
+	ReportDir             string            `json:"report_dir"`
+	LatencyWarnMs         float64           `json:"latency_warn_ms"`
+	LatencyCriticalMs     float64           `json:"latency_critical_ms"`
+	HeatmapMetric         string            `json:"heatmap_metric"`
+	SiteName              string            `json:"site_name"`
+	ReportTo              string            `json:"report_to"`
+	ReportToToken         string            `json:"report_to_token" secret:"true"`
+	ReportToOnly          bool              `json:"report_to_only"`
+	Collector             CollectorConfig   `json:"collector"`
+	FailureThreshold      int               `json:"failure_threshold"`
+	RecoveryThreshold     int               `json:"recovery_threshold"`
+	QuietHours            QuietHoursConfig  `json:"quiet_hours"`
+	Gotify                *GotifyConfig     `json:"gotify"`
+	Bark                  *BarkConfig       `json:"bark"`
+	Twilio                *TwilioConfig     `json:"twilio"`
+	DiscordBot            *DiscordBotConfig `json:"discord_bot"`
+	SNMP                  *SNMPConfig       `json:"snmp"`
+	RouterSNMP            *RouterSNMPConfig `json:"router_snmp"`
+	Warmup                Duration          `json:"warmup"`
+	ShutdownTimeout       Duration          `json:"shutdown_timeout"`
+	MinReportCoverage     Duration          `json:"min_report_coverage"`
+	MinReportSamples      int               `json:"min_report_samples"`
+	LatencyAlertSamples   int               `json:"latency_alert_samples"`
+	HostLoadThreshold     float64           `json:"host_load_threshold"`
+	RouteProbeInterval    Duration          `json:"route_probe_interval"`
+	// Profiles defines independent monitors run by one process, each
+	// overriding the keys above (see profiles.go)
+	Profiles map[string]json.RawMessage `json:"profiles,omitempty" secret:"true"`
+	// LossTrendSlope is the loss increase per 5 minutes, in percentage
+	// points, that triggers the daily trend hint; 0 disables it
+	LossTrendSlope float64 `json:"loss_trend_slope"`
+	// WorstMinuteLoss marks minutes with at least this loss percentage in
+	// the daily report's worst-minute table (see worstminute.go)
+	WorstMinuteLoss float64 `json:"worst_minute_loss"`
+	// FailureOutputKeep is how many failed probe outputs are kept per
+	// target for /debug/failures; 0 disables it
+	FailureOutputKeep int `json:"failure_output_keep"`
+	// ControlSocket is the unix socket serving the API without a token;
+	// "" means control.sock under state_dir and "off" disables it
+	ControlSocket string `json:"control_socket"`
+	// Store selects where results and outages are kept ("memory", "file"
+	// or "sqlite"); StoreRetention is how long they are kept
+	Store          string   `json:"store"`
+	StoreRetention Duration `json:"store_retention"`
+	// GatewayDetection and LocalIPDetection are the detection providers
+	// tried in order; empty means the platform default
+	GatewayDetection []string `json:"gateway_detection"`
+	LocalIPDetection []string `json:"local_ip_detection"`
+	// PublicURL is the API's URL as seen from other devices, used for the
+	// links in alerts; empty derives it from http_listen
+	PublicURL string `json:"public_url"`
+	// NotifyOnStart sends a summary when monitoring starts, at most once
+	// per NotifyOnStartInterval
+	NotifyOnStart         bool     `json:"notify_on_start"`
+	NotifyOnStartInterval Duration `json:"notify_on_start_interval"`
+	// Vantages probe the target from other hosts (see remote.go)
+	Vantages []VantageConfig `json:"vantages"`
+	// Targets are pinged alongside target, each on its own schedule, and
+	// reported per target (see targets.go)
+	Targets []TargetConfig `json:"targets"`
+	// PlainOutput drops emoji and box drawing from notifications, reports
+	// and the console (see plain.go)
+	PlainOutput bool `json:"plain_output"`
+	// MeasurementErrorThreshold is the percentage of recent probes failing
+	// in the monitor itself that raises the 計測不良 warning; 0 disables it
+	MeasurementErrorThreshold float64 `json:"measurement_error_threshold"`
+	// APITokens are tokens with scopes, accepted beside api_token (see
+	// auth.go); MetricsPublic serves /metrics without a token
+	APITokens     []APITokenConfig `json:"api_tokens"`
+	MetricsPublic bool             `json:"metrics_public"`
+	// MetricsExemplars serves /metrics as OpenMetrics to scrapers asking
+	// for it, with exemplars linking response times to outage alerts
+	// (see metrics.go)
+	MetricsExemplars bool `json:"metrics_exemplars"`
+	// QualityWeights weigh the factors of the quality score (see stats.go)
+	QualityWeights QualityWeights `json:"quality_weights"`
+	// ReverseDNS names the target and route hops by PTR lookup (see rdns.go)
+	ReverseDNS ReverseDNSConfig `json:"reverse_dns"`
+	// Locale selects the number separators of reports and alerts, and
+	// DurationStyle how durations are written (see format.go)
+	Locale        string `json:"locale"`
+	DurationStyle string `json:"duration_style"`
+	// Lang is the language of the text report, "ja" or "en" (see
+	// english.go)
+	Lang string `json:"lang"`
+	// Watchdog checks that the ping loop keeps completing cycles (see
+	// watchdog.go)
+	Watchdog WatchdogConfig `json:"watchdog"`
+	// SelfProtection limits memory and lowers the chance of being killed
+	// on a small host (see protect.go)
+	SelfProtection *SelfProtectionConfig `json:"self_protection"`
+	// Peer is a sibling monitor compared in the daily report (see peer.go)
+	Peer *PeerConfig `json:"peer"`
+	// ProbeCommand probes the target with a command of its own instead of
+	// ping (see probecmd.go)
+	ProbeCommand *ProbeCommandConfig `json:"probe_command"`
+	// TCPProbe probes the target with a TCP connection instead of ping
+	// (see tcpprobe.go)
+	TCPProbe *TCPProbeConfig `json:"tcp_probe"`
+	// PingBackend is how pings are sent: auto, native (ICMP sockets of our
+	// own) or exec (the ping command) (see icmp.go)
+	PingBackend string `json:"ping_backend"`
+	// PingTimeout is how long a ping waits for the reply. It must not
+	// exceed ping_interval (-interval included); the default is 3s, or
+	// ping_interval when shorter.
+	PingTimeout Duration `json:"ping_timeout"`
+	// PairedGatewayProbe pings the gateway after every successful target
+	// probe, for the latency added beyond the LAN (see differential.go)
+	PairedGatewayProbe bool `json:"paired_gateway_probe"`
+	// DailyRegression flags a daily report clearly worse than the previous
+	// days (see regression.go)
+	DailyRegression *DailyRegressionConfig `json:"daily_regression"`
+	// ClockFloor is the earliest plausible date (YYYY-MM-DD); before it
+	// reports wait for the clock to be set (see clock.go). "" uses the
+	// date the binary was built with.
+	ClockFloor string `json:"clock_floor"`
+	// NextSteps replaces the "次にやること" advice of outage alerts per
+	// classification; "" omits it (see nextstep.go)
+	NextSteps map[string]string `json:"next_steps"`
+	// DiscordRouting selects the events sent to the Discord webhook, as
+	// min_severity and events do for the other notifiers (see routing.go)
+	DiscordRouting NotifierRouting `json:"discord_routing"`
+	// NotifyDebug prints where each event is routed
+	NotifyDebug bool `json:"notify_debug"`
+	// Bufferbloat measures the latency under load at set times of day
+	// (see bufferbloat.go)
+	Bufferbloat *BufferbloatConfig `json:"bufferbloat"`
+	// CompactReport sends the short daily report on clean days (see
+	// compact.go)
+	CompactReport CompactReportConfig `json:"compact_report"`
+}
+
+// ConfigOverrides holds values given on the command line which take
+// precedence over both the config file and the environment
+type ConfigOverrides struct {
+	HTTPListen   string
+	Target       string
+	PingInterval Duration
+}
+
+// defaultConfig returns the configuration used for keys missing from the file
+func defaultConfig() Config {
+	siteName, _ := os.Hostname()
+	return Config{
+		Target:                "8.8.8.8",
+		TargetResolveInterval: makeDuration(5 * time.Minute),
+		PingInterval:          makeDuration(time.Second),
+		LatencyWarnMs:         100,
+		LatencyCriticalMs:     200,
+		HeatmapMetric:         "p95",
+		SiteName:              siteName,
+		Collector: CollectorConfig{
+			Deadline: "00:30",
+		},
+		FailureThreshold:  3,
+		RecoveryThreshold: 3,
+		QuietHours: QuietHoursConfig{
+			AllowCritical: true,
+		},
+		Warmup:                    makeDuration(5 * time.Second),
+		ShutdownTimeout:           makeDuration(15 * time.Second),
+		MinReportCoverage:         makeDuration(time.Hour),
+		MinReportSamples:          60,
+		LatencyAlertSamples:       5,
+		HostLoadThreshold:         1.0,
+		RouteProbeInterval:        makeDuration(time.Minute),
+		PingBackend:               pingBackendAuto,
+		LossTrendSlope:            2,
+		WorstMinuteLoss:           20,
+		FailureOutputKeep:         20,
+		NotifyOnStartInterval:     makeDuration(30 * time.Minute),
+		MeasurementErrorThreshold: 20,
+		QualityWeights:            QualityWeights{Loss: 10, Latency: 20, Jitter: 20},
+		ReverseDNS:                ReverseDNSConfig{Enabled: true, CacheTTL: makeDuration(time.Hour), Timeout: makeDuration(500 * time.Millisecond)},
+		Locale:                    "ja",
+		DurationStyle:             "compact",
+		Lang:                      "ja",
+		Watchdog:                  WatchdogConfig{StallIntervals: 10, Action: watchdogRestart},
+		CompactReport:             CompactReportConfig{MaxLossPercent: 0.1},
+	}
+}
+
+// validate checks value ranges and combinations
+func (c Config) validate() error {
+	if c.LatencyWarnMs <= 0 || c.LatencyCriticalMs <= 0 {
+		return fmt.Errorf("latency_warn_ms と latency_critical_ms は正の値で指定してください")
+	}
+	if c.LatencyWarnMs > c.LatencyCriticalMs {
+		return fmt.Errorf("latency_warn_ms (%v) が latency_critical_ms (%v) より大きくなっています", c.LatencyWarnMs, c.LatencyCriticalMs)
+	}
+	if c.HeatmapMetric != "p95" && c.HeatmapMetric != "loss" {
+		return fmt.Errorf("heatmap_metric の値が正しくありません: %q (p95 または loss)", c.HeatmapMetric)
+	}
+	if c.FailureThreshold < 1 || c.RecoveryThreshold < 1 {
+		return fmt.Errorf("failure_threshold と recovery_threshold は1以上で指定してください")
+	}
+	if c.Target == "" {
+		return fmt.Errorf("target を指定してください")
+	}
+	if !validTarget(c.Target) {
+		return fmt.Errorf("target の値が正しくありません: %q（IPアドレスかホスト名で指定してください。例: 8.8.8.8、vpn.example.com）", c.Target)
+	}
+	if err := c.TargetResolveInterval.check("target_resolve_interval", positiveDuration, 24*time.Hour, "5m"); err != nil {
+		return err
+	}
+	if err := c.PingInterval.check("ping_interval", minPingInterval, maxPingInterval, "1s、30s"); err != nil {
+		return err
+	}
+	if err := c.Warmup.check("warmup", 0, 10*time.Minute, "5s、無効にする場合は 0s"); err != nil {
+		return err
+	}
+	if err := validateDetection("gateway_detection", c.GatewayDetection, gatewayProviders); err != nil {
+		return err
+	}
+	if err := validateDetection("local_ip_detection", c.LocalIPDetection, localIPProviders); err != nil {
+		return err
+	}
+	if err := validateVantages(c.Vantages); err != nil {
+		return err
+	}
+	if err := validateTargets(c.Targets, c.Target); err != nil {
+		return err
+	}
+	if err := validateAPITokens(c.APITokens); err != nil {
+		return err
+	}
+	if c.Store != "" && c.Store != storeMemory && c.Store != storeFile && c.Store != storeSQLite {
+		return fmt.Errorf("store の値が正しくありません: %q (memory / file / sqlite)", c.Store)
+	}
+	if c.Store == storeSQLite && c.StateDir == "" {
+		return fmt.Errorf("store が sqlite の場合は state_dir を指定してください（state_dir/%s に保存します）", sqliteStoreFile)
+	}
+	if err := c.StoreRetention.check("store_retention", 0, 0, "720h、0で無期限"); err != nil {
+		return err
+	}
+	if err := c.NotifyOnStartInterval.check("notify_on_start_interval", 0, 7*24*time.Hour, "30m"); err != nil {
+		return err
+	}
+	if err := c.ShutdownTimeout.check("shutdown_timeout", positiveDuration, 10*time.Minute, "15s"); err != nil {
+		return err
+	}
+	if err := c.MinReportCoverage.check("min_report_coverage", 0, 24*time.Hour, "1h"); err != nil {
+		return err
+	}
+	if err := c.RouteProbeInterval.check("route_probe_interval", 0, 24*time.Hour, "1m、無効にする場合は 0s"); err != nil {
+		return err
+	}
+	if c.LatencyAlertSamples < 0 {
+		return fmt.Errorf("latency_alert_samples は0以上で指定してください（0で無効）")
+	}
+	if c.FailureOutputKeep < 0 || c.FailureOutputKeep > 1000 {
+		return fmt.Errorf("failure_output_keep は0〜1000で指定してください（0で無効）")
+	}
+	if c.MeasurementErrorThreshold < 0 || c.MeasurementErrorThreshold > 100 {
+		return fmt.Errorf("measurement_error_threshold は0〜100で指定してください（0で無効）")
+	}
+	if w := c.QualityWeights; w.Loss < 0 || w.Latency < 0 || w.Jitter < 0 {
+		return fmt.Errorf("quality_weights の値は0以上で指定してください")
+	}
+	if err := c.ReverseDNS.validate(); err != nil {
+		return err
+	}
+	if err := c.Watchdog.validate(); err != nil {
+		return err
+	}
+	if err := c.CompactReport.validate(); err != nil {
+		return err
+	}
+	if c.Peer != nil {
+		if err := c.Peer.validate(); err != nil {
+			return err
+		}
+	}
+	if c.SelfProtection != nil {
+		if err := c.SelfProtection.validate(); err != nil {
+			return err
+		}
+	}
+	if c.ProbeCommand != nil {
+		if err := c.ProbeCommand.validate(); err != nil {
+			return err
+		}
+	}
+	if !slices.Contains(pingBackends, c.PingBackend) {
+		return fmt.Errorf("ping_backend の値が正しくありません: %q (auto / native / exec)", c.PingBackend)
+	}
+	if c.PingTimeout.given() {
+		if err := c.PingTimeout.check("ping_timeout", minPingInterval, probeCommandMaxTimeout, "3s、500ms"); err != nil {
+			return err
+		}
+		// A probe still waiting when the next one is due would overlap it
+		if c.PingTimeout.Duration() > c.PingInterval.Duration() {
+			return fmt.Errorf("ping_timeout (%s) は ping_interval (%s) 以下で指定してください（応答待ちの間に次の回が来ないよう ping_timeout ≤ ping_interval が必要です。ping_timeout を省略すると 3s と ping_interval の短いほうになります）", c.PingTimeout, c.PingInterval)
+		}
+	}
+	if c.TCPProbe != nil {
+		if c.ProbeCommand != nil {
+			return fmt.Errorf("probe_command と tcp_probe はどちらか一方だけ指定してください")
+		}
+		if err := c.TCPProbe.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Bufferbloat != nil {
+		if err := c.Bufferbloat.validate(); err != nil {
+			return err
+		}
+	}
+	if c.DailyRegression != nil {
+		if err := c.DailyRegression.validate(); err != nil {
+			return err
+		}
+	}
+	if c.ClockFloor != "" {
+		if _, err := time.ParseInLocation(reportDateLayout, c.ClockFloor, time.Local); err != nil {
+			return fmt.Errorf("clock_floor は YYYY-MM-DD で指定してください: %q", c.ClockFloor)
+		}
+	}
+	if err := validateNextSteps(c.NextSteps); err != nil {
+		return err
+	}
+	if err := c.DiscordRouting.validate("discord_routing"); err != nil {
+		return err
+	}
+	if _, ok := numberLocales[c.Locale]; !ok {
+		return fmt.Errorf("locale の値が正しくありません: %q (ja / en / de / fr)", c.Locale)
+	}
+	if c.Lang != "ja" && c.Lang != "en" {
+		return fmt.Errorf("lang の値が正しくありません: %q (ja または en)", c.Lang)
+	}
+	if c.DurationStyle != "compact" && c.DurationStyle != "spaced" {
+		return fmt.Errorf("duration_style の値が正しくありません: %q (compact または spaced)", c.DurationStyle)
+	}
+	if c.LossTrendSlope < 0 {
+		return fmt.Errorf("loss_trend_slope は0以上で指定してください（0で無効）")
+	}
+	if c.WorstMinuteLoss <= 0 || c.WorstMinuteLoss > 100 {
+		return fmt.Errorf("worst_minute_loss は0より大きく100以下で指定してください: %v", c.WorstMinuteLoss)
+	}
+	if c.HostLoadThreshold <= 0 {
+		return fmt.Errorf("host_load_threshold は正の値で指定してください")
+	}
+	if c.MinReportSamples < 0 {
+		return fmt.Errorf("min_report_samples は0以上で指定してください")
+	}
+	if _, err := newQuietHours(c.QuietHours); err != nil {
+		return err
+	}
+	if c.Gotify != nil && (c.Gotify.URL == "" || c.Gotify.Token == "") {
+		return fmt.Errorf("gotify.url と gotify.token を指定してください")
+	}
+	if c.Gotify != nil {
+		if err := c.Gotify.validate("gotify"); err != nil {
+			return err
+		}
+		for severity, p := range c.Gotify.Priorities {
+			if _, ok := defaultGotifyPriorities[severity]; !ok || p < 0 || p > 10 {
+				return fmt.Errorf("gotify.priorities の値が正しくありません: %s=%d (info/warn/critical に0〜10)", severity, p)
+			}
+		}
+	}
+	if c.Bark != nil && c.Bark.DeviceKey == "" {
+		return fmt.Errorf("bark.device_key を指定してください")
+	}
+	if c.Bark != nil {
+		if err := c.Bark.validate("bark"); err != nil {
+			return err
+		}
+	}
+	if c.Bark != nil && c.Bark.Method != "" && !strings.EqualFold(c.Bark.Method, "get") && !strings.EqualFold(c.Bark.Method, "post") {
+		return fmt.Errorf("bark.method の値が正しくありません: %q (get または post)", c.Bark.Method)
+	}
+	if t := c.Twilio; t != nil {
+		if t.AccountSID == "" || t.AuthToken == "" || t.From == "" || len(t.To) == 0 {
+			return fmt.Errorf("twilio.account_sid, auth_token, from, to を指定してください")
+		}
+		if err := t.validate("twilio"); err != nil {
+			return err
+		}
+		if t.DailyCap < 1 {
+			return fmt.Errorf("twilio.daily_cap は1以上で指定してください")
+		}
+	}
+	if b := c.DiscordBot; b != nil {
+		if b.Token == "" || b.ChannelID == "" {
+			return fmt.Errorf("discord_bot.token と discord_bot.channel_id を指定してください")
+		}
+		if _, _, err := b.botDurations(); err != nil {
+			return err
+		}
+	}
+	if c.SNMP != nil && (c.SNMP.Listen == "" || c.SNMP.Community == "") {
+		return fmt.Errorf("snmp.listen と snmp.community を指定してください")
+	}
+	if c.RouterSNMP != nil {
+		if err := c.RouterSNMP.validate(); err != nil {
+			return err
+		}
+	}
+	if (c.ReportTo != "" || c.Collector.Enabled) && c.SiteName == "" {
+		return fmt.Errorf("site_name を指定してください")
+	}
+	if c.Collector.Enabled {
+		if _, err := parseTimeOfDay(c.Collector.Deadline); err != nil {
+			return fmt.Errorf("collector.deadline の値が正しくありません: %q (HH:MM)", c.Collector.Deadline)
+		}
+		if c.Collector.IngestToken == "" {
+			return fmt.Errorf("collector.ingest_token を指定してください")
+		}
+	}
+	return nil
+}
+
+// setConfig installs the effective configuration
+func (pm *PingMonitor) setConfig(cfg Config) {
+	pm.config = cfg
+	pm.style = textStyle{plain: cfg.PlainOutput}
+	pm.format = newNumberFormat(cfg)
+	stateMonitorName = cfg.SiteName
+
+	if !pm.config.webhookConfigured() {
+		fmt.Println("警告: Discord Webhook URLが設定されていません。config.jsonを編集してください。")
+	}
+}
+
+// readConfig returns the effective configuration: defaults, then the file,
+// then environment variables, then command line overrides
+func readConfig(configFile string, overrides ConfigOverrides) (Config, error) {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return cfg, fmt.Errorf("設定ファイル %s が見つかりません: %v", configFile, err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("設定ファイル %s の形式が正しくありません: %v", configFile, err)
+	}
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(data, &keys) == nil && keys["preset"] != nil {
+		return cfg, fmt.Errorf("設定ファイル %s: preset は profiles の各プロファイルに指定してください", configFile)
+	}
+
+	if err := applyEnvOverrides(&cfg); err != nil {
+		return cfg, err
+	}
+	if overrides.HTTPListen != "" {
+		cfg.HTTPListen = overrides.HTTPListen
+	}
+	if overrides.Target != "" {
+		cfg.Target = overrides.Target
+	}
+	if overrides.PingInterval.given() {
+		cfg.PingInterval = overrides.PingInterval
+	}
+	if err := loadTokenFiles(cfg.APITokens); err != nil {
+		return cfg, fmt.Errorf("設定ファイル %s: %v", configFile, err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("設定ファイル %s: %v", configFile, err)
+	}
+
+	return cfg, nil
+}
+
+// pingTimeout returns ping_timeout, by default 3s or the ping interval
+// when that is shorter
+func (c Config) pingTimeout() time.Duration {
+	return c.PingTimeout.or(min(pingTimeout, c.PingInterval.Duration()))
+}
+
+// probeTimeout returns how long a probe of the target waits, by
+// probe_command, tcp_probe or ping
+func (c Config) probeTimeout() time.Duration {
+	switch {
+	case c.ProbeCommand != nil:
+		return c.ProbeCommand.Timeout.or(pingTimeout)
+	case c.TCPProbe != nil:
+		return c.TCPProbe.Timeout.or(pingTimeout)
+	}
+	return c.pingTimeout()
+}
+
+// webhookConfigured reports whether a real Discord webhook URL is set
+func (c Config) webhookConfigured() bool {
+	return c.DiscordWebhookURL != "" && !strings.Contains(c.DiscordWebhookURL, "YOUR_WEBHOOK")
+}
+
+// applyEnvOverrides overwrites config fields from PING_MONITOR_* variables
+func applyEnvOverrides(cfg *Config) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := jsonKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		envName := envPrefix + strings.ToUpper(key)
+		raw, ok := os.LookupEnv(envName)
+		if !ok {
+			continue
+		}
+		if err := setFromString(v.Field(i), raw); err != nil {
+			return fmt.Errorf("環境変数 %s の値が正しくありません: %v", envName, err)
+		}
+	}
+	return nil
+}
+
+// setFromString parses raw into the field according to its kind
+func setFromString(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		d, err := parseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(makeDuration(d)))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		// Structured values are only configurable through the file
+		return json.Unmarshal([]byte(raw), field.Addr().Interface())
+	}
+	return nil
+}
+
+// jsonKey returns the json name of a struct field, or "" if it is skipped
+func jsonKey(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
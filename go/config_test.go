@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readTestConfig reads the config file content through readConfig
+func readTestConfig(t *testing.T, content string, overrides ConfigOverrides) (Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return readConfig(path, overrides)
+}
+
+func TestPingIntervalBounds(t *testing.T) {
+	tests := []struct {
+		interval string
+		timeout  string
+		wantErr  string
+		// wantTimeout is the effective ping timeout of a valid config
+		wantTimeout time.Duration
+	}{
+		{interval: "99ms", wantErr: "ping_interval は100ms以上"},
+		{interval: "100ms", wantTimeout: 100 * time.Millisecond},
+		{interval: "1s", wantTimeout: time.Second},
+		{interval: "30s", wantTimeout: 3 * time.Second},
+		{interval: "1h", wantTimeout: 3 * time.Second},
+		{interval: "1h0m0.001s", wantErr: "ping_interval は1h以下"},
+		{interval: "2h", wantErr: "ping_interval は1h以下"},
+		{interval: "soon", wantErr: `"soon"`},
+		{interval: "1s", timeout: "1s", wantTimeout: time.Second},
+		{interval: "1s", timeout: "1.001s", wantErr: "ping_timeout (1.001s) は ping_interval (1s) 以下"},
+		{interval: "500ms", timeout: "3s", wantErr: "ping_timeout (3s) は ping_interval (500ms) 以下"},
+		{interval: "200ms", timeout: "1s", wantErr: "ping_timeout ≤ ping_interval が必要です。ping_timeout を省略すると 3s と ping_interval の短いほう"},
+		{interval: "30s", timeout: "10s", wantTimeout: 10 * time.Second},
+		{interval: "1s", timeout: "99ms", wantErr: "ping_timeout は100ms以上"},
+		{interval: "1h", timeout: "31s", wantErr: "ping_timeout は30s以下"},
+	}
+	for _, tt := range tests {
+		content := `{"ping_interval": "` + tt.interval + `"`
+		if tt.timeout != "" {
+			content += `, "ping_timeout": "` + tt.timeout + `"`
+		}
+		content += "}"
+		cfg, err := readTestConfig(t, content, ConfigOverrides{})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", content, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", content, err)
+			continue
+		}
+		if got := cfg.pingTimeout(); got != tt.wantTimeout {
+			t.Errorf("%s: ping timeout %v, want %v", content, got, tt.wantTimeout)
+		}
+	}
+}
+
+// TestIntervalFlagChecksTimeout checks the -interval flag against the
+// config's ping_timeout, since the flag wins over the file
+func TestIntervalFlagChecksTimeout(t *testing.T) {
+	content := `{"ping_interval": "30s", "ping_timeout": "5s"}`
+	if _, err := readTestConfig(t, content, ConfigOverrides{PingInterval: makeDuration(time.Second)}); err == nil {
+		t.Error("-interval 1s accepted with ping_timeout 5s")
+	}
+	cfg, err := readTestConfig(t, content, ConfigOverrides{PingInterval: makeDuration(10 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PingInterval.Duration() != 10*time.Second || cfg.pingTimeout() != 5*time.Second {
+		t.Errorf("interval %v timeout %v", cfg.PingInterval, cfg.pingTimeout())
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// connectionInterval is how often the connection type is checked
+const connectionInterval = 30 * time.Second
+
+// connectionCommandTimeout bounds netsh and networksetup
+const connectionCommandTimeout = 5 * time.Second
+
+// connType is the kind of link the default route goes through
+type connType string
+
+const (
+	connEthernet connType = "ethernet"
+	connWiFi     connType = "wifi"
+	connCellular connType = "cellular"
+	connOther    connType = "other"
+	// connUnknown: no default route, or a platform that cannot tell
+	connUnknown connType = ""
+)
+
+// label returns the Japanese name of the type
+func (t connType) label() string {
+	switch t {
+	case connEthernet:
+		return "有線LAN"
+	case connWiFi:
+		return "Wi-Fi"
+	case connCellular:
+		return "モバイル回線"
+	case connOther:
+		return "その他"
+	}
+	return "不明"
+}
+
+// cellularDrivers are Linux drivers of USB modems and tethered phones,
+// which often present an Ethernet device
+var cellularDrivers = []string{"qmi_wwan", "cdc_mbim", "huawei_cdc_ncm", "option", "sierra_net", "rndis_host", "ipheth"}
+
+// connection is the type and the interface of the default route
+type connection struct {
+	Type  connType
+	Iface string
+}
+
+// String renders the connection as "Wi-Fi (wlan0)"
+func (c connection) String() string {
+	if c.Iface == "" {
+		return c.Type.label()
+	}
+	return fmt.Sprintf("%s (%s)", c.Type.label(), c.Iface)
+}
+
+// connectionChange records a switch of the default route's connection
+type connectionChange struct {
+	At   time.Time
+	From connection
+	To   connection
+}
+
+// connectionDetectable reports whether detectConnection supports the
+// platform
+func connectionDetectable() bool {
+	return runtime.GOOS == "linux" || runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+}
+
+// detectConnection finds the connection of the default route
+func detectConnection() connection {
+	switch runtime.GOOS {
+	case "linux":
+		name := defaultRouteInterface()
+		if name == "" {
+			return connection{}
+		}
+		return connection{Type: linuxIfaceType(filepath.Join(sysClassNet, name)), Iface: name}
+	case "windows":
+		name := sourceInterface()
+		if name == "" {
+			return connection{}
+		}
+		return connection{Type: windowsIfaceType(name), Iface: name}
+	case "darwin":
+		name := sourceInterface()
+		if name == "" {
+			return connection{}
+		}
+		return connection{Type: darwinIfaceType(name), Iface: name}
+	}
+	return connection{}
+}
+
+// linuxIfaceType tells the type from the interface's sysfs directory
+func linuxIfaceType(dir string) connType {
+	if _, err := os.Stat(filepath.Join(dir, "wireless")); err == nil {
+		return connWiFi
+	}
+	if _, err := os.Stat(filepath.Join(dir, "phy80211")); err == nil {
+		return connWiFi
+	}
+	if uevent, err := os.ReadFile(filepath.Join(dir, "uevent")); err == nil {
+		switch {
+		case bytes.Contains(uevent, []byte("DEVTYPE=wlan")):
+			return connWiFi
+		case bytes.Contains(uevent, []byte("DEVTYPE=wwan")):
+			return connCellular
+		}
+	}
+	if driver, err := os.Readlink(filepath.Join(dir, "device", "driver")); err == nil && slices.Contains(cellularDrivers, filepath.Base(driver)) {
+		return connCellular
+	}
+	arphrd, err := readSysUint(filepath.Join(dir, "type"))
+	switch {
+	case err != nil:
+		return connUnknown
+	// ARPHRD_ETHER
+	case arphrd == 1:
+		return connEthernet
+	// ARPHRD_RAWIP, used by QMI modems in raw IP mode
+	case arphrd == 519:
+		return connCellular
+	}
+	return connOther
+}
+
+// sourceInterface returns the interface holding the address outgoing
+// packets are sent from, "" when it cannot be found
+func sourceInterface() string {
+	ip, err := localIPFromUDP()
+	if err != nil {
+		return ""
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.String() == ip {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+// listsInterface reports whether a line of netsh output has name as its
+// value ("Name : Wi-Fi", "名前 : Wi-Fi"), whatever the display language
+func listsInterface(output []byte, name string) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if _, value, ok := strings.Cut(scanner.Text(), ":"); ok && strings.TrimSpace(value) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// windowsIfaceType asks netsh whether the interface is a WLAN or a mobile
+// broadband one; anything else is taken as Ethernet
+func windowsIfaceType(name string) connType {
+	if out, err := runCommand(connectionCommandTimeout, "netsh", "wlan", "show", "interfaces"); err == nil && listsInterface(out, name) {
+		return connWiFi
+	}
+	if out, err := runCommand(connectionCommandTimeout, "netsh", "mbn", "show", "interfaces"); err == nil && listsInterface(out, name) {
+		return connCellular
+	}
+	return connEthernet
+}
+
+// darwinIfaceType reads the hardware port of the device from networksetup,
+// e.g. "Hardware Port: Wi-Fi" followed by "Device: en0"
+func darwinIfaceType(name string) connType {
+	out, err := runCommand(connectionCommandTimeout, "networksetup", "-listallhardwareports")
+	if err != nil {
+		return connUnknown
+	}
+	port := ""
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if v, ok := strings.CutPrefix(line, "Hardware Port: "); ok {
+			port = v
+		} else if v, ok := strings.CutPrefix(line, "Device: "); ok && strings.TrimSpace(v) == name {
+			return hardwarePortType(port)
+		}
+	}
+	return connOther
+}
+
+// hardwarePortType classifies a macOS hardware port name
+func hardwarePortType(port string) connType {
+	switch {
+	case strings.Contains(port, "Wi-Fi"), strings.Contains(port, "AirPort"):
+		return connWiFi
+	case strings.Contains(port, "iPhone"), strings.Contains(port, "iPad"), strings.Contains(port, "Modem"), strings.Contains(port, "WWAN"):
+		return connCellular
+	case strings.Contains(port, "Ethernet"), strings.Contains(port, "LAN"), strings.Contains(port, "Thunderbolt"):
+		return connEthernet
+	}
+	return connOther
+}
+
+// currentConnection returns the connection last detected
+func (pm *PingMonitor) currentConnection() connection {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	return pm.connection
+}
+
+// checkConnection detects the connection and records and announces a
+// change. A lost default route is no change: the outage says enough.
+func (pm *PingMonitor) checkConnection(now time.Time) {
+	c := detectConnection()
+	if c.Type == connUnknown {
+		return
+	}
+	pm.mutex.Lock()
+	prev := pm.connection
+	pm.connection = c
+	if prev == c || prev.Type == connUnknown {
+		if prev.Type == connUnknown {
+			pm.periodConnection = c
+		}
+		pm.mutex.Unlock()
+		return
+	}
+	change := connectionChange{At: now, From: prev, To: c}
+	pm.connectionChanges = append(pm.connectionChanges, change)
+	pm.mutex.Unlock()
+
+	fmt.Printf("🔌 接続方式が変わりました: %s → %s\n", prev, c)
+	pm.dispatcher.dispatch(Event{
+		Kind:     EventConnectionChange,
+		Severity: SeverityInfo,
+		Time:     now,
+		Title:    "🔌 接続方式の変更",
+		Message:  fmt.Sprintf("**変更**: %s → %s\n**時刻**: %s", prev, c, now.Format("2006-01-02 15:04:05")),
+		Data: map[string]interface{}{
+			"from":       string(prev.Type),
+			"to":         string(c.Type),
+			"from_iface": prev.Iface,
+			"to_iface":   c.Iface,
+		},
+	})
+}
+
+// connectionLoop checks the connection every connectionInterval
+func (pm *PingMonitor) connectionLoop() {
+	ticker := time.NewTicker(connectionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.stopChan:
+			return
+		case <-ticker.C:
+			pm.checkConnection(pm.now())
+		}
+	}
+}
+
+// connectionAt returns the connection in effect at t during the period
+func (p *reportPeriod) connectionAt(t time.Time) connection {
+	c := p.Connection
+	for _, change := range p.ConnectionChanges {
+		if change.At.After(t) {
+			break
+		}
+		c = change.To
+	}
+	return c
+}
+
+// hourConnection returns the type in effect for most of hour h of the
+// period
+func (p *reportPeriod) hourConnection(h int) connType {
+	day, err := time.ParseInLocation(reportDateLayout, p.Date, time.Local)
+	if err != nil {
+		return p.Connection.Type
+	}
+	start := time.Date(day.Year(), day.Month(), day.Day(), h, 0, 0, 0, time.Local)
+	end := start.Add(time.Hour)
+	spans := make(map[connType]time.Duration)
+	from, c := start, p.connectionAt(start)
+	for _, change := range p.ConnectionChanges {
+		if !change.At.After(start) {
+			continue
+		}
+		if !change.At.Before(end) {
+			break
+		}
+		spans[c.Type] += change.At.Sub(from)
+		from, c = change.At, change.To
+	}
+	spans[c.Type] += end.Sub(from)
+	best := c.Type
+	for t, d := range spans {
+		if d > spans[best] {
+			best = t
+		}
+	}
+	return best
+}
+
+// connectionNote is prefix and the connections of the period, e.g.
+// "Wi-Fi (wlan0) → 12:03〜 モバイル回線 (wwan0)"; "" when never detected
+func (p *reportPeriod) connectionNote(prefix string) string {
+	if p.Connection.Type == connUnknown {
+		return ""
+	}
+	parts := []string{p.Connection.String()}
+	for _, change := range p.ConnectionChanges {
+		parts = append(parts, fmt.Sprintf("%s〜 %s", change.At.Format("15:04"), change.To))
+	}
+	return prefix + strings.Join(parts, " → ")
+}
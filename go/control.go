@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// controlSocketOff disables the control socket
+const controlSocketOff = "off"
+
+// controlSocketPath returns the socket the API is served on besides TCP:
+// control_socket, or control.sock under state_dir by default. It returns
+// "" when the socket is disabled.
+func (c Config) controlSocketPath() string {
+	switch {
+	case c.ControlSocket == controlSocketOff:
+		return ""
+	case c.ControlSocket != "":
+		return c.ControlSocket
+	case c.StateDir != "":
+		return filepath.Join(c.StateDir, "control.sock")
+	}
+	return ""
+}
+
+// controlTransport is a local, non-TCP way to reach the API. Only unix
+// domain sockets exist today; a Windows named pipe would be another
+// implementation.
+type controlTransport interface {
+	// Listen opens the server side, accessible to the current user only
+	Listen() (net.Listener, error)
+	// Dial connects the client side
+	Dial(ctx context.Context) (net.Conn, error)
+	// Available reports whether a server may be listening
+	Available() bool
+	String() string
+}
+
+// unixTransport serves the API on a unix domain socket
+type unixTransport struct {
+	path string
+}
+
+// Listen creates the socket with mode 0600. It is bound in a directory
+// only the owner can enter and moved into place once restricted, so it is
+// never reachable with the umask's mode. A socket left by a crashed
+// instance is removed; one still answering belongs to a running instance.
+func (t unixTransport) Listen() (net.Listener, error) {
+	if t.Available() {
+		conn, err := net.DialTimeout("unix", t.path, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s は稼働中の別のインスタンスが使用しています", t.path)
+		}
+		os.Remove(t.path)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return nil, err
+	}
+	// Windows has no modes to restrict
+	if runtime.GOOS == "windows" {
+		return net.Listen("unix", t.path)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(t.path), ".control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	bound := filepath.Join(dir, filepath.Base(t.path))
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: bound, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The listener would remove the bound path, which is gone once moved
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(bound, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(bound, t.path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return unixListener{UnixListener: l, path: t.path}, nil
+}
+
+// unixListener removes the socket it was moved to when closed
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+// Close stops listening and removes the socket
+func (l unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// Dial connects to the socket
+func (t unixTransport) Dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", t.path)
+}
+
+// Available reports whether the socket file exists
+func (t unixTransport) Available() bool {
+	info, err := os.Stat(t.path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// String returns the socket for logs
+func (t unixTransport) String() string {
+	return "unix:" + t.path
+}
+
+// newControlTransport returns the transport of cfg, or nil when disabled
+func newControlTransport(cfg Config) controlTransport {
+	path := cfg.controlSocketPath()
+	if path == "" {
+		return nil
+	}
+	return unixTransport{path: path}
+}
+
+// controlConnKey marks requests that arrived over the control transport.
+// Only the owner can open it, so they need no bearer token.
+type controlConnKey struct{}
+
+// fromControl reports whether r arrived over the control transport
+func fromControl(r *http.Request) bool {
+	trusted, _ := r.Context().Value(controlConnKey{}).(bool)
+	return trusted
+}
+
+// startControl serves handler over t in the background
+func (s *apiServer) startControl(t controlTransport, handler http.Handler) {
+	l, err := t.Listen()
+	if err != nil {
+		fmt.Printf("❌ 制御用ソケットを開けません: %v\n", err)
+		return
+	}
+	s.control = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, controlConnKey{}, true)
+		},
+	}
+	fmt.Printf("APIを %s で待ち受けます\n", t)
+	go func() {
+		if err := s.control.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("❌ 制御用ソケットのエラー: %v\n", err)
+		}
+	}()
+}
+
+// controlClient returns an HTTP client that reaches the API over t
+func controlClient(t controlTransport) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return t.Dial(ctx)
+			},
+		},
+	}
+}
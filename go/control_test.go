@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestUnixTransportListen(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes on Windows")
+	}
+	dir := filepath.Join(t.TempDir(), "state")
+	tr := unixTransport{path: filepath.Join(dir, "control.sock")}
+
+	l, err := tr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(tr.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode %v, want 0600", info.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("left %v next to the socket", entries)
+	}
+
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	conn, err := tr.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("dialing the moved socket: %v", err)
+	}
+	conn.Close()
+
+	if _, err := tr.Listen(); err == nil {
+		t.Error("a second instance listened on a socket in use")
+	}
+	l.Close()
+	if _, err := os.Lstat(tr.path); !os.IsNotExist(err) {
+		t.Errorf("socket left after closing: %v", err)
+	}
+}
+
+// TestUnixTransportReplacesStaleSocket listens where a crashed instance
+// left its socket
+func TestUnixTransportReplacesStaleSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes on Windows")
+	}
+	tr := unixTransport{path: filepath.Join(t.TempDir(), "control.sock")}
+	stale, err := tr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing the listener without removing the file, as a crash would
+	stale.(unixListener).UnixListener.Close()
+	if !tr.Available() {
+		t.Fatal("no stale socket to replace")
+	}
+	l, err := tr.Listen()
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	defer l.Close()
+	if info, err := os.Lstat(tr.path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("replaced socket: %v, %v", info, err)
+	}
+}
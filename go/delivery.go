@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// deliveryLookback is how many days of history are searched at startup
+// for the last report deliveries shown in /status
+const deliveryLookback = 7
+
+// discordCreated is the part of the message returned with ?wait=true
+type discordCreated struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// waitURL returns the webhook URL asking Discord to return the message,
+// keeping a thread_id already in it
+func waitURL(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return webhook
+	}
+	q := u.Query()
+	q.Set("wait", "true")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// sendReport sends a daily report split like sendToDiscord, and records
+// the IDs Discord returns for the parts. A failed part ends the send;
+// during shutdown it goes to the outbox like other messages and the
+// error is nil, with the delivery left unconfirmed.
+func (pm *PingMonitor) sendReport(date string, message DiscordMessage) (*ReportDelivery, error) {
+	d := &ReportDelivery{Date: date, At: pm.now()}
+	for _, part := range splitDiscordMessage(pm.style.discord(message)) {
+		body, err := json.Marshal(part)
+		if err != nil {
+			d.Error = err.Error()
+			return d, err
+		}
+		resp, err := pm.postWebhook(waitURL(pm.config.DiscordWebhookURL), "application/json", body)
+		if err != nil {
+			d.Error = err.Error()
+			if pm.keepUnsent("application/json", body, err) == nil {
+				d.Error = "終了処理中に送信できず、未送信キューに保存しました"
+				return d, nil
+			}
+			return d, err
+		}
+		var created discordCreated
+		if json.Unmarshal(resp, &created) != nil || created.ID == "" {
+			d.Error = "Discordが作成したメッセージを返しませんでした"
+			continue
+		}
+		d.MessageIDs = append(d.MessageIDs, created.ID)
+		d.ChannelID = created.ChannelID
+	}
+	d.Sent = true
+	d.Confirmed = d.Error == ""
+	return d, nil
+}
+
+// recordDelivery keeps d for /status and stores it, with the message when
+// given, in the day's history
+func (pm *PingMonitor) recordDelivery(d *ReportDelivery, message *DiscordMessage) {
+	pm.reportDelivery.Store(d)
+	if d.Confirmed {
+		pm.confirmedDelivery.Store(d)
+	}
+	if pm.history == nil {
+		return
+	}
+	if err := pm.history.saveDelivery(d, message); err != nil {
+		fmt.Printf("❌ レポートの配信記録の保存エラー: %v\n", err)
+	}
+}
+
+// saveDelivery stores the delivery, and the message when given, with the
+// day of d
+func (hs *historyStore) saveDelivery(d *ReportDelivery, message *DiscordMessage) error {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	day, err := hs.loadDay(d.Date)
+	if err != nil {
+		day = &DailyHistory{Date: d.Date}
+	}
+	day.Delivery = d
+	if message != nil {
+		day.Report = message
+	}
+	return writeStateFile(hs.path(d.Date), "history", day)
+}
+
+// loadDeliveries finds the last delivery and the last confirmed one in
+// the recent history, so /status shows them after a restart
+func (pm *PingMonitor) loadDeliveries() {
+	if pm.history == nil {
+		return
+	}
+	today := pm.now()
+	for i := 0; i < deliveryLookback && pm.confirmedDelivery.Load() == nil; i++ {
+		day, err := pm.history.loadDay(today.AddDate(0, 0, -i).Format(reportDateLayout))
+		if err != nil || day.Delivery == nil {
+			continue
+		}
+		if pm.reportDelivery.Load() == nil {
+			pm.reportDelivery.Store(day.Delivery)
+		}
+		if day.Delivery.Confirmed {
+			pm.confirmedDelivery.Store(day.Delivery)
+		}
+	}
+}
+
+// deliveryStatus returns the "report_delivery" section, nil before any
+// report was sent
+func (pm *PingMonitor) deliveryStatus() *ReportDeliveryStatus {
+	last, confirmed := pm.reportDelivery.Load(), pm.confirmedDelivery.Load()
+	if last == nil && confirmed == nil {
+		return nil
+	}
+	return &ReportDeliveryStatus{Last: last, LastConfirmed: confirmed}
+}
+
+// deliveryNote returns the footer note of the report of date when the
+// previous day's report was not confirmed, "" otherwise
+func (pm *PingMonitor) deliveryNote(date string) string {
+	day, err := time.ParseInLocation(reportDateLayout, date, time.Local)
+	if err != nil {
+		return ""
+	}
+	prev := day.AddDate(0, 0, -1).Format(reportDateLayout)
+	var d *ReportDelivery
+	if pm.history != nil {
+		if h, err := pm.history.loadDay(prev); err == nil {
+			d = h.Delivery
+		}
+	} else if last := pm.reportDelivery.Load(); last != nil && last.Date == prev {
+		d = last
+	}
+	if d == nil || d.Confirmed {
+		return ""
+	}
+	return fmt.Sprintf(" / ⚠️ %sのレポートは配信を確認できていません（resend で再送できます）", prev)
+}
+
+// errReportNotStored is returned by resendReport for a day without a
+// stored report
+var errReportNotStored = errors.New("日次レポートは保存されていません")
+
+// resendReport sends the stored daily report of date again, marked as a
+// resend, and records the new delivery
+func (pm *PingMonitor) resendReport(date string) (*ReportDelivery, error) {
+	if pm.history == nil {
+		return nil, fmt.Errorf("%w（state_dir が設定されていないため、送信したレポートを保存していません）", errReportNotStored)
+	}
+	if !pm.config.webhookConfigured() {
+		return nil, fmt.Errorf("Discord Webhook URLが設定されていません")
+	}
+	day, err := pm.history.loadDay(date)
+	if err != nil || day.Report == nil {
+		return nil, fmt.Errorf("%s の%w", date, errReportNotStored)
+	}
+	message := *day.Report
+	message.Embeds = append([]DiscordEmbed(nil), message.Embeds...)
+	if len(message.Embeds) > 0 {
+		message.Embeds[0].Title = "[再送] " + message.Embeds[0].Title
+	}
+	d, err := pm.sendReport(date, message)
+	d.Resent = true
+	pm.recordDelivery(d, nil)
+	return d, err
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gatewayOff disables gateway detection and diagnostics
+const gatewayOff = "off"
+
+// Outcomes of a detection provider
+const (
+	detectOK      = "ok"
+	detectMissing = "missing"
+	detectEmpty   = "empty"
+	detectFailed  = "failed"
+	detectSkipped = "skipped"
+)
+
+// errDetectEmpty means the provider ran but found no default route
+var errDetectEmpty = errors.New("no default route")
+
+// DetectionAttempt is the outcome of one provider at startup
+type DetectionAttempt struct {
+	Provider string `json:"provider"`
+	Outcome  string `json:"outcome"`
+	Value    string `json:"value,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// String formats the attempt as "proc OK (192.168.1.1)" for the startup log
+func (a DetectionAttempt) String() string {
+	switch a.Outcome {
+	case detectOK:
+		return fmt.Sprintf("%s OK (%s)", a.Provider, a.Value)
+	case detectMissing:
+		return a.Provider + ": 利用不可"
+	case detectEmpty:
+		return a.Provider + ": 見つからず"
+	case detectSkipped:
+		return a.Provider + ": 未実行"
+	default:
+		return fmt.Sprintf("%s: 失敗 (%s)", a.Provider, a.Detail)
+	}
+}
+
+// DetectionStatus tells how the gateway and local IP were found, for
+// /status. Source is the provider that answered, "config" for an explicit
+// value, "off" when disabled, or "" when nothing was found.
+type DetectionStatus struct {
+	GatewaySource string             `json:"gateway_source"`
+	Gateway       []DetectionAttempt `json:"gateway"`
+	LocalIPSource string             `json:"local_ip_source"`
+	LocalIP       []DetectionAttempt `json:"local_ip"`
+}
+
+// detectionProvider finds one value with one method
+type detectionProvider struct {
+	name   string
+	detect func() (string, error)
+}
+
+// gatewayProviders are the gateway detection methods by name
+var gatewayProviders = map[string]func() (string, error){
+	"proc":  gatewayFromProc,
+	"ip":    gatewayFromIP,
+	"route": gatewayFromRoute,
+}
+
+// localIPProviders are the local IP detection methods by name
+var localIPProviders = map[string]func() (string, error){
+	"udp":        localIPFromUDP,
+	"interfaces": localIPFromInterfaces,
+}
+
+// defaultGatewayDetection is the order tried when gateway_detection is not
+// set; Windows only has route
+func defaultGatewayDetection() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"route"}
+	}
+	return []string{"proc", "ip", "route"}
+}
+
+// defaultLocalIPDetection is the order tried when local_ip_detection is
+// not set
+func defaultLocalIPDetection() []string {
+	return []string{"udp", "interfaces"}
+}
+
+// validateDetection checks that every name in order is a known provider
+func validateDetection(key string, order []string, known map[string]func() (string, error)) error {
+	for _, name := range order {
+		if _, ok := known[name]; !ok {
+			var names []string
+			for n := range known {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("%s の値が正しくありません: %q (%s のいずれか)", key, name, strings.Join(names, " / "))
+		}
+	}
+	return nil
+}
+
+// runDetection tries the providers of order until one answers and records
+// every attempt; the ones after it are marked skipped
+func runDetection(order []string, known map[string]func() (string, error)) (string, string, []DetectionAttempt) {
+	var value, source string
+	attempts := make([]DetectionAttempt, 0, len(order))
+	for _, name := range order {
+		a := DetectionAttempt{Provider: name}
+		if source != "" {
+			a.Outcome = detectSkipped
+			attempts = append(attempts, a)
+			continue
+		}
+		v, err := known[name]()
+		switch {
+		case err == nil:
+			a.Outcome, a.Value = detectOK, v
+			value, source = v, name
+		case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist), errors.Is(err, os.ErrPermission):
+			a.Outcome, a.Detail = detectMissing, err.Error()
+		case errors.Is(err, errDetectEmpty):
+			a.Outcome = detectEmpty
+		default:
+			a.Outcome, a.Detail = detectFailed, err.Error()
+		}
+		attempts = append(attempts, a)
+	}
+	return value, source, attempts
+}
+
+// formatAttempts joins attempts as "proc: 利用不可 / ip OK (192.168.1.1)"
+func formatAttempts(attempts []DetectionAttempt) string {
+	parts := make([]string, len(attempts))
+	for i, a := range attempts {
+		parts[i] = a.String()
+	}
+	return strings.Join(parts, " / ")
+}
+
+// gatewayFromProc reads the default route from /proc/net/route without
+// executing any command; the gateway is a little-endian hex address
+func gatewayFromProc() (string, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return parseProcRoute(f)
+}
+
+// parseProcRoute returns the gateway of the first default route that is up
+// in the /proc/net/route table
+func parseProcRoute(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[1] != "00000000" {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		// RTF_UP | RTF_GATEWAY
+		if err != nil || flags&0x3 != 0x3 {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+		return ip.String(), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errDetectEmpty
+}
+
+// ipRouteDefaultRe matches the gateway in C-locale `ip route show default`
+// output ("default via 192.168.1.1 dev eth0 proto dhcp ...")
+var ipRouteDefaultRe = regexp.MustCompile(`(?m)^default via (\d+\.\d+\.\d+\.\d+)`)
+
+// gatewayFromIP parses `ip route show default`
+func gatewayFromIP() (string, error) {
+	output, err := runCommand(commandTimeout, "ip", "route", "show", "default")
+	if err != nil {
+		return "", err
+	}
+	return parseIPRoute(string(output))
+}
+
+// parseIPRoute returns the gateway of the first default route
+func parseIPRoute(output string) (string, error) {
+	if match := ipRouteDefaultRe.FindStringSubmatch(output); len(match) > 1 {
+		return match[1], nil
+	}
+	return "", errDetectEmpty
+}
+
+// gatewayFromRoute parses `route print 0.0.0.0` on Windows and `route -n`
+// for older Linux systems
+func gatewayFromRoute() (string, error) {
+	args := []string{"-n"}
+	if runtime.GOOS == "windows" {
+		args = []string{"print", "0.0.0.0"}
+	}
+	output, err := runCommand(commandTimeout, "route", args...)
+	if err != nil {
+		return "", err
+	}
+	return parseRouteTable(string(output), runtime.GOOS == "windows")
+}
+
+// parseRouteTable returns the gateway of the first default route in the
+// output of route print (Windows, "0.0.0.0 0.0.0.0 <gateway> ...") or
+// route -n ("0.0.0.0 <gateway> 0.0.0.0 UG ..."). On-link routes, whose
+// gateway column is not an address, are skipped.
+func parseRouteTable(output string, windows bool) (string, error) {
+	column := 1
+	if windows {
+		column = 2
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) <= column || fields[0] != "0.0.0.0" {
+			continue
+		}
+		if windows && fields[1] != "0.0.0.0" {
+			continue
+		}
+		if ip := net.ParseIP(fields[column]); ip != nil && !ip.IsUnspecified() {
+			return fields[column], nil
+		}
+	}
+	return "", errDetectEmpty
+}
+
+// localIPFromUDP asks the kernel which address a UDP socket towards the
+// internet would use; no packet is sent
+func localIPFromUDP() (string, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// localIPFromInterfaces returns the first IPv4 address of an interface
+// that is up and not loopback
+func localIPFromInterfaces() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				return ipnet.IP.String(), nil
+			}
+		}
+	}
+	return "", errDetectEmpty
+}
+
+// gatewayDetection returns the provider order of the config
+func (c Config) gatewayDetection() []string {
+	if len(c.GatewayDetection) > 0 {
+		return c.GatewayDetection
+	}
+	return defaultGatewayDetection()
+}
+
+// localIPDetection returns the provider order of the config
+func (c Config) localIPDetection() []string {
+	if len(c.LocalIPDetection) > 0 {
+		return c.LocalIPDetection
+	}
+	return defaultLocalIPDetection()
+}
+
+// detectNetwork finds the gateway candidates and the local IP, logging how
+// each was found
+func (pm *PingMonitor) detectNetwork() {
+	pm.gateways = pm.resolveGateways()
+	switch {
+	case pm.detection.GatewaySource == gatewayOff:
+		fmt.Println("ゲートウェイ診断: 無効（gateway: off）")
+	case len(pm.gateways) == 0:
+		fmt.Printf("ゲートウェイ検出: %s\n", formatAttempts(pm.detection.Gateway))
+		fmt.Println("警告: ゲートウェイ不明のため、ゲートウェイ診断を無効にします。config.jsonのgatewayで指定できます。")
+	default:
+		if len(pm.detection.Gateway) > 0 {
+			fmt.Printf("ゲートウェイ検出: %s\n", formatAttempts(pm.detection.Gateway))
+		}
+		fmt.Printf("デフォルトゲートウェイ: %s\n", strings.Join(pm.gateways, ", "))
+	}
+
+	ip, source, attempts := runDetection(pm.config.localIPDetection(), localIPProviders)
+	pm.detection.LocalIP, pm.detection.LocalIPSource = attempts, source
+	if ip == "" {
+		ip = "不明"
+		fmt.Printf("送信元IPアドレスの検出: %s\n", formatAttempts(attempts))
+	}
+	pm.localIP = ip
+	fmt.Printf("送信元IPアドレス: %s\n", pm.localIP)
+}
+
+// resolveGateways returns the gateway candidates in the order they are tried.
+// An explicit gateway in the config replaces auto-detection; the configured
+// candidates follow. An empty result means the gateway is unknown.
+func (pm *PingMonitor) resolveGateways() []string {
+	if pm.config.Gateway == gatewayOff {
+		pm.detection.GatewaySource = gatewayOff
+		return nil
+	}
+	var gateways []string
+	primary := pm.config.Gateway
+	if primary != "" {
+		pm.detection.GatewaySource = "config"
+	} else {
+		primary, pm.detection.GatewaySource, pm.detection.Gateway = runDetection(pm.config.gatewayDetection(), gatewayProviders)
+	}
+	if primary != "" {
+		gateways = append(gateways, primary)
+	}
+	for _, gw := range pm.config.GatewayCandidates {
+		if gw != "" && gw != primary {
+			gateways = append(gateways, gw)
+		}
+	}
+	return gateways
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestGatewayParsers parses the output of each gateway provider under
+// testdata/detect
+func TestGatewayParsers(t *testing.T) {
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join("testdata", "detect", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	tests := []struct {
+		file  string
+		parse func(string) (string, error)
+		want  string
+	}{
+		// The first default route that is up and has a gateway
+		{"proc_net_route.txt", func(s string) (string, error) { return parseProcRoute(strings.NewReader(s)) }, "192.168.1.1"},
+		{"proc_net_route_no_default.txt", func(s string) (string, error) { return parseProcRoute(strings.NewReader(s)) }, ""},
+		{"ip_route.txt", parseIPRoute, "192.168.1.1"},
+		{"ip_route_onlink.txt", parseIPRoute, ""},
+		{"route_n.txt", func(s string) (string, error) { return parseRouteTable(s, false) }, "192.168.1.1"},
+		{"route_print_ja.txt", func(s string) (string, error) { return parseRouteTable(s, true) }, "192.168.1.1"},
+		{"route_print_en.txt", func(s string) (string, error) { return parseRouteTable(s, true) }, "192.168.1.1"},
+		{"ip_route.txt", func(s string) (string, error) { return parseRouteTable(s, false) }, ""},
+	}
+	for _, tt := range tests {
+		got, err := tt.parse(read(tt.file))
+		if got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.file, got, tt.want)
+		}
+		if tt.want == "" && !errors.Is(err, errDetectEmpty) {
+			t.Errorf("%s: error %v, want errDetectEmpty", tt.file, err)
+		}
+	}
+}
+
+func TestRunDetection(t *testing.T) {
+	providers := map[string]func() (string, error){
+		"missing": func() (string, error) { return "", &exec.Error{Name: "ip", Err: exec.ErrNotFound} },
+		"denied": func() (string, error) {
+			return "", &os.PathError{Op: "open", Path: "/proc/net/route", Err: os.ErrPermission}
+		},
+		"empty":  func() (string, error) { return "", errDetectEmpty },
+		"broken": func() (string, error) { return "", errors.New("exit status 2") },
+		"ok":     func() (string, error) { return "192.168.1.1", nil },
+		"other":  func() (string, error) { return "10.0.0.1", nil },
+	}
+	value, source, attempts := runDetection([]string{"missing", "denied", "empty", "broken", "ok", "other"}, providers)
+	if value != "192.168.1.1" || source != "ok" {
+		t.Errorf("found %q from %q", value, source)
+	}
+	var outcomes []string
+	for _, a := range attempts {
+		outcomes = append(outcomes, a.Outcome)
+	}
+	if want := []string{detectMissing, detectMissing, detectEmpty, detectFailed, detectOK, detectSkipped}; !slices.Equal(outcomes, want) {
+		t.Errorf("outcomes %v, want %v", outcomes, want)
+	}
+	want := "missing: 利用不可 / denied: 利用不可 / empty: 見つからず / broken: 失敗 (exit status 2) / ok OK (192.168.1.1) / other: 未実行"
+	if got := formatAttempts(attempts); got != want {
+		t.Errorf("log %q, want %q", got, want)
+	}
+
+	// The order is the config's: another order finds another value
+	if value, source, _ := runDetection([]string{"other", "ok"}, providers); value != "10.0.0.1" || source != "other" {
+		t.Errorf("reordered: %q from %q", value, source)
+	}
+	if value, source, attempts := runDetection([]string{"missing", "empty"}, providers); value != "" || source != "" || len(attempts) != 2 {
+		t.Errorf("none found: %q from %q, %v", value, source, attempts)
+	}
+
+	if err := validateDetection("gateway_detection", []string{"proc", "netlink"}, gatewayProviders); err == nil || !strings.Contains(err.Error(), "ip / proc / route") {
+		t.Errorf("unknown provider: %v", err)
+	}
+	if err := validateDetection("local_ip_detection", []string{"interfaces", "udp"}, localIPProviders); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestResolveGateways checks the sources of the gateway: off, the config
+// and each detection outcome, with the candidates appended
+func TestResolveGateways(t *testing.T) {
+	quietStdout(t)
+	saved := gatewayProviders
+	t.Cleanup(func() { gatewayProviders = saved })
+	gatewayProviders = map[string]func() (string, error){
+		"proc": func() (string, error) { return "", os.ErrNotExist },
+		"ip":   func() (string, error) { return "192.168.1.1", nil },
+	}
+	tests := []struct {
+		gateway    string
+		detection  []string
+		candidates []string
+		want       []string
+		source     string
+		attempts   int
+	}{
+		{gatewayOff, nil, []string{"10.0.0.1"}, nil, gatewayOff, 0},
+		{"192.168.0.254", nil, []string{"192.168.0.254", "10.0.0.1"}, []string{"192.168.0.254", "10.0.0.1"}, "config", 0},
+		{"", []string{"proc", "ip"}, nil, []string{"192.168.1.1"}, "ip", 2},
+		{"", []string{"proc"}, []string{"10.0.0.1"}, []string{"10.0.0.1"}, "", 1},
+	}
+	pm, _ := newTestMonitor(t, nil, time.Now())
+	for _, tt := range tests {
+		pm.config.Gateway, pm.config.GatewayDetection, pm.config.GatewayCandidates = tt.gateway, tt.detection, tt.candidates
+		pm.detection = DetectionStatus{}
+		got := pm.resolveGateways()
+		if !slices.Equal(got, tt.want) || pm.detection.GatewaySource != tt.source || len(pm.detection.Gateway) != tt.attempts {
+			t.Errorf("%q %v: %v from %q after %v, want %v from %q", tt.gateway, tt.detection, got, pm.detection.GatewaySource, pm.detection.Gateway, tt.want, tt.source)
+		}
+	}
+}
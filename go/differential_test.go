@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDifferential pairs each successful target probe with a gateway probe:
+// the target answers in 30-39ms, the gateway in 5ms except every fifth
+// cycle, and the target fails at 7, 27, 47, 67 and 87 seconds
+func TestDifferential(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	pm, clock := newTestMonitor(t, map[string]interface{}{"ping_interval": "1s", "paired_gateway_probe": true}, start)
+	var gatewayProbes []int
+	pm.prober = probeFunc(func(host string) (float64, error) {
+		s := int(clock.now().Sub(start) / time.Second)
+		if host == scenarioGateway {
+			gatewayProbes = append(gatewayProbes, s)
+			if s%5 == 0 {
+				return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+			}
+			return 5, nil
+		}
+		if s%20 == 7 {
+			return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+		}
+		return float64(30 + s%10), nil
+	})
+	for s := 0; s < 100; s++ {
+		at := start.Add(time.Duration(s) * time.Second)
+		clock.set(at)
+		pm.tick(at)
+	}
+
+	// One gateway probe per cycle: paired after a success, and after a
+	// failure the one that attributes it
+	for i, s := range gatewayProbes {
+		if s != i {
+			t.Fatalf("gateway probes at %v, want one per second", gatewayProbes)
+		}
+	}
+	if len(gatewayProbes) != 100 {
+		t.Errorf("%d gateway probes, want 100", len(gatewayProbes))
+	}
+
+	// 80 cycles with both answers, less the five failures, whose
+	// differential would have been 32ms
+	p := pm.currentPeriod()
+	d, ok := p.differential()
+	if !ok || d.Pairs != 75 || math.Abs(d.Avg-(80*30-5*32)/75.0) > 1e-9 {
+		t.Errorf("differential %+v, %v; want 75 pairs averaging %.3fms", d, ok, (80*30-5*32)/75.0)
+	}
+	if d.P95 < 33 || d.P95 > 35 {
+		t.Errorf("p95 %.2fms, want about 34ms", d.P95)
+	}
+	var buf bytes.Buffer
+	pm.writeDailyReport(&buf, p)
+	if !strings.Contains(buf.String(), "LAN外で増えた遅延（対象 − ゲートウェイ）: 平均 29.9ms") || !strings.Contains(buf.String(), "（75組）") {
+		t.Errorf("report lacks the differential:\n%s", buf.String())
+	}
+
+	// Only paired results carry the gateway's time
+	for _, rec := range pm.recent.list() {
+		s := int(rec.Timestamp.Sub(start) / time.Second)
+		paired := s%5 != 0 && s%20 != 7
+		if paired != (rec.GatewayResponseTime == 5) || !paired && rec.GatewayResponseTime != 0 {
+			t.Errorf("%ds: gateway time %v", s, rec.GatewayResponseTime)
+		}
+	}
+}
+
+// TestDifferentialOff leaves the gateway alone without paired_gateway_probe,
+// and keeps the warm-up out of the pairs
+func TestDifferentialOff(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	for _, tt := range []struct {
+		cfg   map[string]interface{}
+		pairs int
+	}{
+		{map[string]interface{}{"ping_interval": "1s"}, 0},
+		{map[string]interface{}{"ping_interval": "1s", "paired_gateway_probe": true, "warmup": "15s"}, 15},
+	} {
+		pm, clock := newTestMonitor(t, tt.cfg, start)
+		probes := 0
+		pm.prober = probeFunc(func(host string) (float64, error) {
+			if host == scenarioGateway {
+				probes++
+				return 2, nil
+			}
+			return 20, nil
+		})
+		for s := 0; s < 30; s++ {
+			at := start.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+		}
+		p := pm.currentPeriod()
+		d, ok := p.differential()
+		if tt.pairs == 0 {
+			var buf bytes.Buffer
+			pm.writeDailyReport(&buf, p)
+			if ok || probes != 0 || strings.Contains(buf.String(), "LAN外") {
+				t.Errorf("off: differential %+v, %v after %d gateway probes", d, ok, probes)
+			}
+			continue
+		}
+		if !ok || d.Pairs != tt.pairs || d.Avg != 18 {
+			t.Errorf("warm-up: differential %+v, %v; want %d pairs of 18ms", d, ok, tt.pairs)
+		}
+	}
+}
+
+// TestProbeGatewayPaired tries the gateways in order, skipping the target
+// itself, and uses the gateway prober when there is one
+func TestProbeGatewayPaired(t *testing.T) {
+	pm, _ := newTestMonitor(t, nil, time.Now())
+	var tried []string
+	answers := map[string]float64{"192.0.2.254": 4}
+	gateway := probeFunc(func(host string) (float64, error) {
+		tried = append(tried, host)
+		if rtt, ok := answers[host]; ok {
+			return rtt, nil
+		}
+		return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+	})
+	pm.gatewayProber = gateway
+	pm.prober = probeFunc(func(string) (float64, error) {
+		t.Error("the target prober pinged a gateway")
+		return 0, nil
+	})
+	pm.gateways = []string{"203.0.113.5", scenarioGateway, "192.0.2.254", "192.0.2.253"}
+
+	if rtt, ok := pm.probeGatewayPaired("203.0.113.5"); !ok || rtt != 4 {
+		t.Errorf("paired %v, %v; want 4ms", rtt, ok)
+	}
+	if want := []string{scenarioGateway, "192.0.2.254"}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("tried %v, want %v", tried, want)
+	}
+
+	tried = nil
+	delete(answers, "192.0.2.254")
+	if _, ok := pm.probeGatewayPaired("203.0.113.5"); ok {
+		t.Error("paired with no gateway answering")
+	}
+	if len(tried) != 3 {
+		t.Errorf("tried %v, want every gateway but the target", tried)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Default polling and reply intervals of the Discord bot
+const (
+	defaultBotPollInterval  = 10 * time.Second
+	defaultBotReplyInterval = 30 * time.Second
+)
+
+// DiscordBotConfig configures the optional command bot. The bot only reads
+// the one configured channel through the REST API; no gateway connection
+// is opened.
+type DiscordBotConfig struct {
+	Token     string `json:"token" secret:"true"`
+	ChannelID string `json:"channel_id"`
+	// PollInterval is how often the channel is read (default 10s)
+	PollInterval Duration `json:"poll_interval"`
+	// ReplyInterval is the minimum gap between two replies (default 30s)
+	ReplyInterval Duration `json:"reply_interval"`
+}
+
+// botDurations returns the intervals, applying defaults to empty values
+func (c DiscordBotConfig) botDurations() (poll, reply time.Duration, err error) {
+	if c.PollInterval.given() {
+		if err := c.PollInterval.check("discord_bot.poll_interval", 2*time.Second, 0, "10s"); err != nil {
+			return 0, 0, err
+		}
+	}
+	if c.ReplyInterval.given() {
+		if err := c.ReplyInterval.check("discord_bot.reply_interval", 0, 0, "30s"); err != nil {
+			return 0, 0, err
+		}
+	}
+	return c.PollInterval.or(defaultBotPollInterval), c.ReplyInterval.or(defaultBotReplyInterval), nil
+}
+
+// botMessage is the part of a Discord channel message the bot reads
+type botMessage struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+	Author  struct {
+		Username string `json:"username"`
+		Bot      bool   `json:"bot"`
+	} `json:"author"`
+}
+
+// botReply is the body of a message created by the bot
+type botReply struct {
+	Content          string         `json:"content,omitempty"`
+	Embeds           []DiscordEmbed `json:"embeds,omitempty"`
+	MessageReference *struct {
+		MessageID string `json:"message_id"`
+	} `json:"message_reference,omitempty"`
+}
+
+// discordBot answers "!ping-status" and "!ping-report" in a command channel
+type discordBot struct {
+	pm            *PingMonitor
+	cfg           DiscordBotConfig
+	client        *http.Client
+	apiBase       string
+	pollInterval  time.Duration
+	replyInterval time.Duration
+	lastID        string
+	lastReply     time.Time
+}
+
+// newDiscordBot creates the bot; config values are validated by
+// Config.validate
+func newDiscordBot(pm *PingMonitor, cfg DiscordBotConfig) *discordBot {
+	poll, reply, _ := cfg.botDurations()
+	return &discordBot{
+		pm:            pm,
+		cfg:           cfg,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		apiBase:       "https://discord.com/api/v10",
+		pollInterval:  poll,
+		replyInterval: reply,
+	}
+}
+
+// run polls the command channel until stop is closed. Messages posted
+// before the start are skipped, so old commands are not replayed.
+func (b *discordBot) run(stop <-chan struct{}) {
+	if latest, err := b.fetch("limit=1"); err != nil {
+		fmt.Printf("❌ Discordボット: チャンネルの読み込みエラー: %v\n", err)
+	} else if len(latest) > 0 {
+		b.lastID = latest[0].ID
+	}
+	fmt.Printf("🤖 Discordボット: チャンネル %s のコマンドを受け付けます\n", b.cfg.ChannelID)
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.poll()
+		}
+	}
+}
+
+// poll reads new messages and answers the commands among them
+func (b *discordBot) poll() {
+	query := "limit=50"
+	if b.lastID != "" {
+		query += "&after=" + url.QueryEscape(b.lastID)
+	}
+	messages, err := b.fetch(query)
+	if err != nil {
+		if te, ok := err.(transientError); ok && te.retryAfter > 0 {
+			time.Sleep(min(te.retryAfter, maxRetryAfter))
+		}
+		fmt.Printf("❌ Discordボット: チャンネルの読み込みエラー: %v\n", err)
+		return
+	}
+	// Discord returns the newest message first
+	for i := len(messages) - 1; i >= 0; i-- {
+		m := messages[i]
+		if snowflakeAfter(m.ID, b.lastID) {
+			b.lastID = m.ID
+		}
+		if m.Author.Bot {
+			continue
+		}
+		b.handle(m)
+	}
+}
+
+// handle answers one message if it is a command, subject to the reply rate
+// limit
+func (b *discordBot) handle(m botMessage) {
+	command := strings.TrimSpace(m.Content)
+	if command != "!ping-status" && command != "!ping-report" {
+		return
+	}
+	now := time.Now()
+	if !b.lastReply.IsZero() && now.Sub(b.lastReply) < b.replyInterval {
+		fmt.Printf("🤖 Discordボット: %s は応答間隔(%v)内のため無視しました\n", command, b.replyInterval)
+		return
+	}
+	b.lastReply = now
+
+	var embed DiscordEmbed
+	switch command {
+	case "!ping-status":
+		embed = statusEmbed(b.pm.status())
+	case "!ping-report":
+		b.pm.audit.record(AuditEntry{Action: "report", Actor: "discord:" + m.Author.Username, Detail: map[string]string{"message_id": m.ID}})
+		embed = b.pm.dailyReportEmbed(b.pm.currentPeriod())
+		embed.Title = "📊 Ping Monitor 途中経過"
+	}
+	reply := botReply{Embeds: []DiscordEmbed{fitEmbed(embed)}}
+	reply.MessageReference = &struct {
+		MessageID string `json:"message_id"`
+	}{MessageID: m.ID}
+	if err := retryTransient(func() error { return b.post(reply) }); err != nil {
+		fmt.Printf("❌ Discordボット: 返信エラー: %v\n", err)
+		return
+	}
+	fmt.Printf("🤖 Discordボット: %s に応答しました\n", command)
+}
+
+// fetch lists channel messages with the given query string
+func (b *discordBot) fetch(query string) ([]botMessage, error) {
+	endpoint := fmt.Sprintf("%s/channels/%s/messages?%s", b.apiBase, url.PathEscape(b.cfg.ChannelID), query)
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	var messages []botMessage
+	if err := b.do(req, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// post creates a message in the command channel
+func (b *discordBot) post(reply botReply) error {
+	reply.Content = b.pm.style.text(reply.Content)
+	reply.Embeds = b.pm.style.discord(DiscordMessage{Embeds: reply.Embeds}).Embeds
+	body, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/channels/%s/messages", b.apiBase, url.PathEscape(b.cfg.ChannelID))
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return b.do(req, nil)
+}
+
+// do sends an authorized request; 429 and 5xx are transient, and rate
+// limits carry Discord's retry_after
+func (b *discordBot) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", "Bot "+b.cfg.Token)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return transientError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil {
+			io.Copy(io.Discard, resp.Body)
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("Discord API error: %d - %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var rateLimit struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if json.Unmarshal(respBody, &rateLimit) == nil && rateLimit.RetryAfter > 0 {
+			retryAfter = time.Duration(rateLimit.RetryAfter * float64(time.Second))
+		}
+		return transientError{err: err, retryAfter: retryAfter}
+	}
+	if resp.StatusCode >= 500 {
+		return transientError{err: err}
+	}
+	return err
+}
+
+// snowflakeAfter reports whether Discord ID a is newer than b; IDs are
+// decimal numbers, so a longer ID is larger
+func snowflakeAfter(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
+
+// statusEmbed renders the current status for "!ping-status"
+func statusEmbed(s StatusResponse) DiscordEmbed {
+	color := 0x00ff00 // Green
+	internet := fmt.Sprintf("正常（%sから）", s.Since.Format("01-02 15:04:05"))
+	if s.Internet == "down" {
+		color = 0xff0000 // Red
+		internet = fmt.Sprintf("障害中（%sから）", s.Since.Format("01-02 15:04:05"))
+	}
+	target := s.TargetIP
+	if s.TargetAddress != "" && s.TargetAddress != s.TargetIP {
+		target += fmt.Sprintf(" (%s)", s.TargetAddress)
+	}
+	return DiscordEmbed{
+		Title:       "📡 Ping Monitor 現在の状態",
+		Description: fmt.Sprintf("**対象**: %s\n**送信元**: %s", target, s.LocalIP),
+		Color:       color,
+		Fields: []EmbedField{
+			{Name: "🌐 インターネット", Value: internet, Inline: false},
+			{Name: "🚪 ゲートウェイ", Value: gatewayState(s.GatewayState).label(), Inline: true},
+			{Name: "📈 本日の結果", Value: fmt.Sprintf("**成功回数**: %d\n**失敗回数**: %d", s.SuccessCount, s.UnreachableCount), Inline: true},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+		Footer: EmbedFooter{
+			Text: "Ping Monitor by Go",
+		},
+	}
+}
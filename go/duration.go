@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// positiveDuration as the minimum of check requires a span longer than 0
+const positiveDuration = time.Nanosecond
+
+// Duration is a time span in the config, written as a Go duration string
+// ("500ms", "2m"). A plain number, bare or quoted, is read as seconds, as
+// older configs and environment variables wrote them. A value that cannot
+// be read is kept for validate, which reports it with the field's name.
+type Duration struct {
+	d time.Duration
+	// set tells an explicit value, 0 included, from a missing one
+	set     bool
+	invalid string
+}
+
+// durationType identifies Duration fields for the environment overrides
+var durationType = reflect.TypeOf(Duration{})
+
+// makeDuration wraps d for defaults and code-built configs
+func makeDuration(d time.Duration) Duration {
+	return Duration{d: d, set: true}
+}
+
+// Duration returns the span; 0 for a missing or invalid value
+func (d Duration) Duration() time.Duration {
+	return d.d
+}
+
+// or returns the span, or def when none was given
+func (d Duration) or(def time.Duration) time.Duration {
+	if !d.set {
+		return def
+	}
+	return d.d
+}
+
+// String renders the span without trailing zero units, e.g. "5m" or
+// "1h30m" rather than "5m0s" or "1h30m0s"
+func (d Duration) String() string {
+	switch {
+	case d.invalid != "":
+		return d.invalid
+	case !d.set:
+		return ""
+	}
+	s := d.d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// MarshalJSON writes the span as a duration string, "" when missing
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads a duration string or a number of seconds; an empty
+// string or null leaves the span missing
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	raw := string(data)
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		raw = s
+	}
+	if raw == "null" || strings.TrimSpace(raw) == "" {
+		*d = Duration{}
+		return nil
+	}
+	parsed, err := parseDuration(raw)
+	if err != nil {
+		*d = Duration{invalid: raw}
+		return nil
+	}
+	*d = makeDuration(parsed)
+	return nil
+}
+
+// parseDuration reads a duration string or a number of seconds
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(secs) || math.Abs(secs) > float64(math.MaxInt64)/float64(time.Second) {
+			return 0, fmt.Errorf("out of range: %s", s)
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// check validates the span of the config key field: readable and within
+// min–max (no maximum when max is 0). example is a valid value to show.
+// A missing value is checked as 0; optional keys check only given ones.
+func (d Duration) check(field string, min, max time.Duration, example string) error {
+	value := d.String()
+	if value == "" {
+		value = "未指定"
+	}
+	switch {
+	case d.invalid != "":
+		return fmt.Errorf("%s の値が正しくありません: %q（\"500ms\" や \"2m\" のような期間か秒数で指定してください。例: %s）", field, d.invalid, example)
+	case d.d < min && min == 0:
+		return fmt.Errorf("%s は0以上で指定してください: %s (例: %s)", field, value, example)
+	case d.d < min && min == positiveDuration:
+		return fmt.Errorf("%s は0より長い期間で指定してください: %s (例: %s)", field, value, example)
+	case d.d < min:
+		return fmt.Errorf("%s は%v以上で指定してください: %s (例: %s)", field, makeDuration(min), value, example)
+	case max > 0 && d.d > max:
+		return fmt.Errorf("%s は%v以下で指定してください: %s (例: %s)", field, makeDuration(max), value, example)
+	}
+	return nil
+}
+
+// given tells whether the key was set, or held a value that could not be
+// read, which validate then reports
+func (d Duration) given() bool {
+	return d.set || d.invalid != ""
+}
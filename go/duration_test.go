@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshal(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    time.Duration
+		set     bool
+		invalid bool
+	}{
+		{`"500ms"`, 500 * time.Millisecond, true, false},
+		{`"2m"`, 2 * time.Minute, true, false},
+		{`"1h30m"`, 90 * time.Minute, true, false},
+		// Numbers are seconds, bare or quoted, as older configs wrote them
+		{`30`, 30 * time.Second, true, false},
+		{`"30"`, 30 * time.Second, true, false},
+		{`1.5`, 1500 * time.Millisecond, true, false},
+		{`" 2s "`, 2 * time.Second, true, false},
+		{`0`, 0, true, false},
+		{`"0s"`, 0, true, false},
+		{`"-5s"`, -5 * time.Second, true, false},
+		// Missing
+		{`""`, 0, false, false},
+		{`null`, 0, false, false},
+		// Kept for validate to report
+		{`"5 minutes"`, 0, false, true},
+		{`"1e300"`, 0, false, true},
+		{`"NaN"`, 0, false, true},
+		{`true`, 0, false, true},
+	}
+	for _, tt := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(tt.json), &d); err != nil {
+			t.Errorf("%s: %v", tt.json, err)
+			continue
+		}
+		if d.Duration() != tt.want || d.set != tt.set || (d.invalid != "") != tt.invalid || d.given() != (tt.set || tt.invalid) {
+			t.Errorf("%s: %+v", tt.json, d)
+		}
+	}
+}
+
+func TestDurationString(t *testing.T) {
+	tests := []struct {
+		d    Duration
+		want string
+	}{
+		{makeDuration(5 * time.Minute), "5m"},
+		{makeDuration(time.Hour), "1h"},
+		{makeDuration(90 * time.Minute), "1h30m"},
+		{makeDuration(90 * time.Second), "1m30s"},
+		{makeDuration(500 * time.Millisecond), "500ms"},
+		{makeDuration(0), "0s"},
+		{Duration{}, ""},
+		{Duration{invalid: "abc"}, "abc"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("%v: %q, want %q", tt.d.d, got, tt.want)
+		}
+		// Written values read back the same
+		data, _ := json.Marshal(tt.d)
+		var back Duration
+		json.Unmarshal(data, &back)
+		if back != tt.d {
+			t.Errorf("%q read back as %+v", data, back)
+		}
+	}
+	if makeDuration(0).or(time.Minute) != 0 || (Duration{}).or(time.Minute) != time.Minute {
+		t.Error("or does not tell 0 from missing")
+	}
+}
+
+func TestDurationCheck(t *testing.T) {
+	tests := []struct {
+		d        Duration
+		min, max time.Duration
+		want     string
+	}{
+		{makeDuration(5 * time.Second), 0, 10 * time.Minute, ""},
+		{makeDuration(10 * time.Minute), 0, 10 * time.Minute, ""},
+		{makeDuration(0), 0, 0, ""},
+		{makeDuration(10*time.Minute + time.Second), 0, 10 * time.Minute, "10m以下"},
+		{makeDuration(-time.Second), 0, 0, "0以上"},
+		{makeDuration(0), positiveDuration, 0, "0より長い"},
+		{Duration{}, positiveDuration, 0, "未指定"},
+		{makeDuration(5 * time.Second), 10 * time.Second, 0, "10s以上"},
+		{Duration{invalid: "5 minutes"}, 0, 0, `"5 minutes"`},
+	}
+	for _, tt := range tests {
+		err := tt.d.check("warmup", tt.min, tt.max, "5s")
+		if (tt.want == "") != (err == nil) || (err != nil && (!strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "warmup "))) {
+			t.Errorf("%+v in %v-%v: %v, want %q", tt.d, tt.min, tt.max, err, tt.want)
+		}
+	}
+}
+
+// TestDurationConfig reads a timing key from the file and the environment
+func TestDurationConfig(t *testing.T) {
+	tests := []struct {
+		content string
+		want    time.Duration
+		wantErr string
+	}{
+		{`{"warmup": "30s"}`, 30 * time.Second, ""},
+		{`{"warmup": 45}`, 45 * time.Second, ""},
+		{`{"warmup": "0s"}`, 0, ""},
+		{`{"warmup": "1h"}`, 0, "warmup は10m以下"},
+		{`{"warmup": "soon"}`, 0, `warmup の値が正しくありません: "soon"`},
+	}
+	for _, tt := range tests {
+		cfg, err := readTestConfig(t, tt.content, ConfigOverrides{})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: %v, want %q", tt.content, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || cfg.Warmup.Duration() != tt.want {
+			t.Errorf("%s: %v, %v", tt.content, cfg.Warmup, err)
+		}
+	}
+
+	t.Setenv(envPrefix+"WARMUP", "90")
+	if cfg, err := readTestConfig(t, `{"warmup": "5s"}`, ConfigOverrides{}); err != nil || cfg.Warmup.Duration() != 90*time.Second {
+		t.Errorf("from the environment: %v, %v", cfg.Warmup, err)
+	}
+	t.Setenv(envPrefix+"WARMUP", "later")
+	if _, err := readTestConfig(t, `{}`, ConfigOverrides{}); err == nil || !strings.Contains(err.Error(), envPrefix+"WARMUP") {
+		t.Errorf("bad value in the environment: %v", err)
+	}
+}
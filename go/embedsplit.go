@@ -0,0 +1,113 @@
+package main
+
+import "unicode/utf8"
+
+// Discord message limits (https://discord.com/developers/docs/resources/message#embed-object-embed-limits)
+const (
+	discordTitleLimit       = 256
+	discordDescriptionLimit = 4096
+	discordFieldNameLimit   = 256
+	discordFieldValueLimit  = 1024
+	discordFooterLimit      = 2048
+	discordFieldsPerEmbed   = 25
+	discordEmbedsPerMessage = 10
+	// discordMessageCharLimit is the total of all embed texts in a message
+	discordMessageCharLimit = 6000
+)
+
+// embedChars counts the characters Discord applies the 6000 limit to
+func embedChars(e DiscordEmbed) int {
+	n := utf8.RuneCountInString(e.Title) + utf8.RuneCountInString(e.Description) + utf8.RuneCountInString(e.Footer.Text)
+	for _, f := range e.Fields {
+		n += fieldChars(f)
+	}
+	return n
+}
+
+// fieldChars counts the characters of one field
+func fieldChars(f EmbedField) int {
+	return utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+}
+
+// fitEmbed truncates every part of an embed to its own limit
+func fitEmbed(e DiscordEmbed) DiscordEmbed {
+	e.Title = truncateRunes(e.Title, discordTitleLimit)
+	e.Description = truncateRunes(e.Description, discordDescriptionLimit)
+	e.Footer.Text = truncateRunes(e.Footer.Text, discordFooterLimit)
+	fields := make([]EmbedField, len(e.Fields))
+	for i, f := range e.Fields {
+		f.Name = truncateRunes(f.Name, discordFieldNameLimit)
+		f.Value = truncateRunes(f.Value, discordFieldValueLimit)
+		// Discord rejects empty field values
+		if f.Value == "" {
+			f.Value = "-"
+		}
+		fields[i] = f
+	}
+	e.Fields = fields
+	return e
+}
+
+// splitEmbed breaks an embed whose fields exceed the per-embed field count
+// or the message character budget into a head and continuation embeds.
+// The head keeps the title, description and leading fields, so the
+// headline statistics always go out first.
+func splitEmbed(e DiscordEmbed) []DiscordEmbed {
+	e = fitEmbed(e)
+	head := e
+	head.Fields = nil
+	// Title, description and footer alone may still exceed the budget
+	if over := embedChars(head) - discordMessageCharLimit; over > 0 {
+		head.Description = truncateRunes(head.Description, utf8.RuneCountInString(head.Description)-over)
+	}
+	out := []DiscordEmbed{}
+	cur := head
+	for _, f := range e.Fields {
+		if len(cur.Fields) >= discordFieldsPerEmbed || embedChars(cur)+fieldChars(f) > discordMessageCharLimit {
+			out = append(out, cur)
+			cur = DiscordEmbed{
+				Title:     truncateRunes(e.Title+"（続き）", discordTitleLimit),
+				Color:     e.Color,
+				Timestamp: e.Timestamp,
+				Footer:    e.Footer,
+			}
+		}
+		cur.Fields = append(cur.Fields, f)
+	}
+	return append(out, cur)
+}
+
+// flattenEmbeds concatenates the embeds of messages
+func flattenEmbeds(messages []DiscordMessage) []DiscordEmbed {
+	var out []DiscordEmbed
+	for _, m := range messages {
+		out = append(out, m.Embeds...)
+	}
+	return out
+}
+
+// splitDiscordMessage returns messages that each satisfy Discord's limits,
+// in the order they must be sent. An attached image stays with the embed
+// that references it in the first message.
+func splitDiscordMessage(m DiscordMessage) []DiscordMessage {
+	var embeds []DiscordEmbed
+	for _, e := range m.Embeds {
+		embeds = append(embeds, splitEmbed(e)...)
+	}
+	var out []DiscordMessage
+	var cur DiscordMessage
+	chars := 0
+	for _, e := range embeds {
+		n := embedChars(e)
+		if len(cur.Embeds) > 0 && (len(cur.Embeds) >= discordEmbedsPerMessage || chars+n > discordMessageCharLimit) {
+			out = append(out, cur)
+			cur, chars = DiscordMessage{}, 0
+		}
+		cur.Embeds = append(cur.Embeds, e)
+		chars += n
+	}
+	if len(cur.Embeds) > 0 || len(out) == 0 {
+		out = append(out, cur)
+	}
+	return out
+}
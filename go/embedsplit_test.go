@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// checkDiscordLimits fails the test for any limit a message breaks
+func checkDiscordLimits(t *testing.T, name string, messages []DiscordMessage) {
+	t.Helper()
+	for i, m := range messages {
+		if len(m.Embeds) > discordEmbedsPerMessage {
+			t.Errorf("%s: message %d has %d embeds", name, i, len(m.Embeds))
+		}
+		chars := 0
+		for j, e := range m.Embeds {
+			chars += embedChars(e)
+			if utf8.RuneCountInString(e.Title) > discordTitleLimit || utf8.RuneCountInString(e.Description) > discordDescriptionLimit ||
+				utf8.RuneCountInString(e.Footer.Text) > discordFooterLimit || len(e.Fields) > discordFieldsPerEmbed {
+				t.Errorf("%s: message %d embed %d over a limit", name, i, j)
+			}
+			for _, f := range e.Fields {
+				if utf8.RuneCountInString(f.Name) > discordFieldNameLimit || utf8.RuneCountInString(f.Value) > discordFieldValueLimit || f.Value == "" {
+					t.Errorf("%s: message %d embed %d field %q over a limit", name, i, j, f.Name)
+				}
+			}
+		}
+		if chars > discordMessageCharLimit {
+			t.Errorf("%s: message %d has %d characters", name, i, chars)
+		}
+	}
+}
+
+// layout describes each message as its embeds, "title: fields/characters"
+func layout(messages []DiscordMessage) []string {
+	var out []string
+	for _, m := range messages {
+		var embeds []string
+		for _, e := range m.Embeds {
+			embeds = append(embeds, fmt.Sprintf("%s: %d/%d", e.Title, len(e.Fields), embedChars(e)))
+		}
+		out = append(out, strings.Join(embeds, ", "))
+	}
+	return out
+}
+
+// fields returns n fields named f1..fn with values of size runes
+func fields(n, size int) []EmbedField {
+	out := make([]EmbedField, n)
+	for i := range out {
+		out[i] = EmbedField{Name: fmt.Sprintf("f%d", i+1), Value: strings.Repeat("x", size)}
+	}
+	return out
+}
+
+func TestSplitDiscordMessage(t *testing.T) {
+	footer := EmbedFooter{Text: "ping-monitor"}
+	tests := []struct {
+		name   string
+		embeds []DiscordEmbed
+		want   []string
+	}{
+		{"fits", []DiscordEmbed{{Title: "日次", Description: "ok", Fields: fields(3, 10), Footer: footer}},
+			[]string{"日次: 3/52"}},
+		{"over the field count", []DiscordEmbed{{Title: "日次", Fields: fields(60, 10), Footer: footer}},
+			[]string{"日次: 25/330, 日次（続き）: 25/343, 日次（続き）: 10/148"}},
+		// Ten long fields are over the 6000 characters of one message
+		{"over the character budget", []DiscordEmbed{{Title: "日次", Fields: fields(10, 1000), Footer: footer}},
+			[]string{"日次: 5/5024", "日次（続き）: 5/5029"}},
+		{"over the embed count", make([]DiscordEmbed, 12),
+			[]string{"t: 1/4, t: 1/4, t: 1/4, t: 1/4, t: 1/4, t: 1/4, t: 1/4, t: 1/4, t: 1/4, t: 1/4", "t: 1/4, t: 1/4"}},
+		// The second embed does not fit next to the first
+		{"embeds over the budget together", []DiscordEmbed{
+			{Title: "a", Description: strings.Repeat("x", 4000)},
+			{Title: "b", Description: strings.Repeat("x", 4000)},
+		}, []string{"a: 0/4001", "b: 0/4001"}},
+		// Title, description and footer alone are over the budget
+		{"head over the budget", []DiscordEmbed{{Title: "日次", Description: strings.Repeat("x", 5000), Footer: EmbedFooter{Text: strings.Repeat("y", 2000)}, Fields: fields(1, 5)}},
+			[]string{"日次: 0/6000", "日次（続き）: 1/2013"}},
+		{"empty", nil, []string{""}},
+	}
+	for i := range tests[3].embeds {
+		tests[3].embeds[i] = DiscordEmbed{Title: "t", Fields: fields(1, 1)}
+	}
+	for _, tt := range tests {
+		got := splitDiscordMessage(DiscordMessage{Embeds: tt.embeds})
+		checkDiscordLimits(t, tt.name, got)
+		if l := layout(got); !slices.Equal(l, tt.want) {
+			t.Errorf("%s:\n got  %q\n want %q", tt.name, l, tt.want)
+		}
+		// Every field goes out once, in order
+		var sent, want []string
+		for _, e := range flattenEmbeds(got) {
+			for _, f := range e.Fields {
+				sent = append(sent, f.Name)
+			}
+		}
+		for _, e := range tt.embeds {
+			for _, f := range e.Fields {
+				want = append(want, f.Name)
+			}
+		}
+		if !slices.Equal(sent, want) {
+			t.Errorf("%s: fields %v, want %v", tt.name, sent, want)
+		}
+	}
+}
+
+func TestFitEmbed(t *testing.T) {
+	e := fitEmbed(DiscordEmbed{
+		Title:  strings.Repeat("障", 300),
+		Fields: []EmbedField{{Name: "空", Value: ""}, {Name: "長い", Value: strings.Repeat("害", 1500)}},
+	})
+	// Limits count characters, not bytes
+	if n := utf8.RuneCountInString(e.Title); n != discordTitleLimit || !strings.HasSuffix(e.Title, "…") {
+		t.Errorf("title of %d characters", n)
+	}
+	if e.Fields[0].Value != "-" {
+		t.Errorf("empty value sent as %q", e.Fields[0].Value)
+	}
+	if n := utf8.RuneCountInString(e.Fields[1].Value); n != discordFieldValueLimit {
+		t.Errorf("value of %d characters", n)
+	}
+}
+
+// TestSplitKeepsImage checks that the image stays on the first embed, in
+// the first message, with the headline
+func TestSplitKeepsImage(t *testing.T) {
+	image := &EmbedImage{URL: "attachment://heatmap.png"}
+	got := splitDiscordMessage(DiscordMessage{Embeds: []DiscordEmbed{{Title: "月次", Description: "要約", Fields: fields(30, 300), Image: image}}})
+	checkDiscordLimits(t, "image", got)
+	if len(got) < 2 {
+		t.Fatalf("not split: %q", layout(got))
+	}
+	for i, e := range flattenEmbeds(got) {
+		if (e.Image != nil) != (i == 0) {
+			t.Errorf("embed %d image %v", i, e.Image)
+		}
+	}
+	if head := got[0].Embeds[0]; head.Description != "要約" || head.Fields[0].Name != "f1" {
+		t.Errorf("head %q, first field %q", head.Description, head.Fields[0].Name)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lang: "en" writes the text report (console, /report/today) in English.
+// It covers the headline sections: period, latency, reachability, failure
+// runs, outages and the additional targets. The Discord embed and the
+// alerts stay in Japanese.
+
+// englishLabel names the reason in the English report
+func (r failureReason) englishLabel() string {
+	switch r {
+	case reasonTimeout:
+		return "timeout"
+	case reasonUnreachable:
+		return "unreachable reply"
+	case reasonDNS:
+		return "DNS failure"
+	case reasonRefused:
+		return "connection refused"
+	case reasonReset:
+		return "connection reset"
+	case reasonOpen:
+		return "unexpected connection"
+	case reasonSimulated:
+		return "simulated"
+	case reasonMeasurement:
+		return "measurement error"
+	default:
+		return "other error"
+	}
+}
+
+// englishReasons formats the counts as "timeout 12 / unreachable reply 3"
+func englishReasons(c *reasonCounts) string {
+	type entry struct {
+		reason failureReason
+		n      int
+	}
+	var entries []entry
+	for i, n := range c {
+		if n > 0 {
+			entries = append(entries, entry{failureReasons[i], n})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].n > entries[j].n })
+	parts := make([]string, len(entries))
+	for i, e := range entries {
+		parts[i] = fmt.Sprintf("%s %d", e.reason.englishLabel(), e.n)
+	}
+	return strings.Join(parts, " / ")
+}
+
+// englishFailureWindow is formatFailureWindow in English
+func (p *reportPeriod) englishFailureWindow() string {
+	first, last := p.failureWindow()
+	if first == nil {
+		return "none"
+	}
+	s := "first " + first.Format("15:04:05")
+	if p.carriedOver(*first) {
+		s += " (continued from the previous day)"
+	}
+	s += " / last " + last.Format("15:04:05")
+	switch {
+	case p.OpenOutage.IsZero():
+	case p.Interim:
+		s += " (ongoing)"
+	default:
+		s += " (continues into the next day)"
+	}
+	return s
+}
+
+// englishOutages is formatPeriodOutages in English
+func (p *reportPeriod) englishOutages() []string {
+	var lines []string
+	for i, o := range p.Outages {
+		if i >= 10 {
+			lines = append(lines, fmt.Sprintf("... %d more", len(p.Outages)-10))
+			break
+		}
+		line := fmt.Sprintf("%s - %s (%s) cause: %s", p.outageStart(o.Start), o.End.Format("15:04:05"),
+			p.Format.seconds(o.DurationSeconds), failureReason(o.Reason).englishLabel())
+		if o.Cause == outageCauseGateway {
+			line += ", gateway"
+		}
+		if o.Acknowledged {
+			line += " [acknowledged]"
+		}
+		if o.Simulated {
+			line += " [SIMULATED]"
+		}
+		lines = append(lines, line)
+	}
+	if !p.OpenOutage.IsZero() {
+		line := p.outageStart(p.OpenOutage) + " - (ongoing)"
+		if p.OpenOutageSimulated {
+			line += " [SIMULATED]"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// englishGateways is gatewayLabel in English
+func (pm *PingMonitor) englishGateways() string {
+	if len(pm.gateways) == 0 {
+		return "unknown"
+	}
+	labels := make([]string, len(pm.gateways))
+	for i, gw := range pm.gateways {
+		labels[i] = gw
+		if gw == pm.targetIP || gw == pm.resolver.addr {
+			labels[i] += " (same as the target)"
+		}
+	}
+	return strings.Join(labels, ", ")
+}
+
+// writeEnglishReport writes the text report of p to w in English
+func (pm *PingMonitor) writeEnglishReport(w io.Writer, p *reportPeriod) {
+	f := p.Format
+	stats := p.probeStats()
+	title := "Ping Monitor daily report"
+	if p.Interim {
+		title = "Ping Monitor interim report"
+	}
+	fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 50))
+	if p.SimulatedCount > 0 {
+		fmt.Fprintf(w, "[SIMULATED] ")
+	}
+	fmt.Fprintf(w, "%s - %s\n", title, p.Date)
+	fmt.Fprintf(w, "%s\n", strings.Repeat("=", 50))
+	fmt.Fprintf(w, "Period: %s\n", f.period(p.Start, p.End))
+	fmt.Fprintf(w, "Target: %s\n", pm.targetLabel())
+	fmt.Fprintf(w, "Source: %s\n", pm.localIP)
+	fmt.Fprintf(w, "Gateway: %s\n", pm.englishGateways())
+	if quality, ok := pm.quality(p); ok && p.Coverage.Sufficient {
+		fmt.Fprintf(w, "Quality score: %.0f/100 (loss -%.0f / latency -%.0f / jitter -%.0f)\n",
+			quality.Score, quality.LossPenalty, quality.LatencyPenalty, quality.JitterPenalty)
+	}
+
+	if stats.Latency.Count > 0 {
+		fmt.Fprintf(w, "\nLatency:\n")
+		fmt.Fprintf(w, "  Average: %s\n", f.ms(stats.Latency.Avg))
+		fmt.Fprintf(w, "  Max: %s\n", f.ms(stats.Latency.Max))
+		fmt.Fprintf(w, "  Min: %s\n", f.ms(stats.Latency.Min))
+		if p.Coverage.Sufficient {
+			fmt.Fprintf(w, "  p95: %s\n", f.ms(stats.Latency.P95))
+		}
+		if d, ok := p.differential(); ok {
+			s := "average " + f.ms(d.Avg)
+			if p.Coverage.Sufficient {
+				s += " / p95 " + f.ms(d.P95)
+			}
+			fmt.Fprintf(w, "  Added beyond the LAN (target - gateway): %s (%s pairs)\n", s, f.count(d.Pairs))
+		}
+	}
+
+	fmt.Fprintf(w, "\nReachability:\n")
+	rate := f.percent(stats.SuccessRate, 2)
+	if !p.Coverage.Sufficient {
+		rate += " (insufficient data)"
+	}
+	fmt.Fprintf(w, "  Success rate: %s\n", rate)
+	fmt.Fprintf(w, "  Successes: %s\n", f.count(stats.Success))
+	fmt.Fprintf(w, "  Failures: %s\n", f.count(len(p.UnreachableTimes)))
+	fmt.Fprintf(w, "  Failure times: %s\n", p.englishFailureWindow())
+	if n := p.gatewayFailures(); n > 0 {
+		fmt.Fprintf(w, "  Caused by the gateway: %s (line side %s)\n", f.count(n), f.count(len(p.UnreachableTimes)-n))
+	}
+	fmt.Fprintf(w, "  Total pings: %s\n", f.count(stats.Total))
+	if p.WarmupCount > 0 {
+		fmt.Fprintf(w, "  Excluded as warm-up: %s\n", f.count(p.WarmupCount))
+	}
+	if p.LoadTestCount > 0 {
+		fmt.Fprintf(w, "  Excluded during the load test: %s\n", f.count(p.LoadTestCount))
+	}
+	if p.ScheduleOverruns > 0 || p.ScheduleGaps > 0 {
+		fmt.Fprintf(w, "  Slots lost to late cycles: %d, suspensions: %d (%s in total)\n",
+			p.ScheduleOverruns, p.ScheduleGaps, f.duration(p.ScheduleGapTime.Round(time.Second)))
+	}
+	if p.SimulatedCount > 0 {
+		fmt.Fprintf(w, "  SIMULATED: includes %d simulated failures\n", p.SimulatedCount)
+	}
+
+	for _, t := range p.Targets {
+		s := t.stats()
+		fmt.Fprintf(w, "\n%s (%s):\n", t.Name, t.Address)
+		fmt.Fprintf(w, "  Success rate: %s, failures %s, average %s\n", f.percent(s.SuccessRate, 2), f.count(len(t.UnreachableTimes)), f.ms(s.Latency.Avg))
+	}
+
+	if len(p.UnreachableTimes) > 0 {
+		spans := p.unreachableSpans()
+		interval := p.spanInterval()
+		var total time.Duration
+		for _, s := range spans {
+			total += s.duration(interval)
+		}
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].duration(interval) > spans[j].duration(interval) })
+		fmt.Fprintf(w, "\nUnreachable:\n")
+		fmt.Fprintf(w, "  Total %s (%d runs, %d failures)\n", f.duration(total), len(spans), len(p.UnreachableTimes))
+		for i, s := range spans {
+			if i >= 10 {
+				fmt.Fprintf(w, "  ... %d more runs\n", len(spans)-10)
+				break
+			}
+			fmt.Fprintf(w, "  %s - %s (%s)\n", p.outageStart(s.Start), p.outageStart(s.End), f.duration(s.duration(interval)))
+		}
+		fmt.Fprintf(w, "  Reasons: %s\n", englishReasons(&p.FailureReasons))
+	}
+
+	if p.outageCount() > 0 {
+		fmt.Fprintf(w, "\nOutages (%d):\n", p.outageCount())
+		for _, line := range p.englishOutages() {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	fmt.Fprintf(w, "%s\n\n", strings.Repeat("=", 50))
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// eventReplayMaxAge bounds how old an undelivered event may be and still be
+// replayed; older ones are dropped from the log when it is opened
+const eventReplayMaxAge = 24 * time.Hour
+
+// DeliveryState is the "delivery" section of state_dir/delivery.json
+type DeliveryState struct {
+	// LastID is the highest event ID ever assigned, kept here so IDs stay
+	// monotonic after the log is compacted
+	LastID uint64 `json:"last_id"`
+	// Delivered is the last event ID each notifier has delivered
+	Delivered map[string]uint64 `json:"delivered"`
+}
+
+// eventLog gives every dispatched event a sequence number and appends it to
+// state_dir/events.jsonl before delivery. Notifiers acknowledge the IDs
+// they delivered, so events lost to a crash or kill are replayed on the
+// next start: delivery is at least once.
+type eventLog struct {
+	mutex     sync.Mutex
+	path      string
+	statePath string
+	state     DeliveryState
+	events    []Event
+}
+
+// newEventLog opens the log under stateDir, dropping events too old to be
+// replayed
+func newEventLog(stateDir string) (*eventLog, error) {
+	l := &eventLog{
+		path:      filepath.Join(stateDir, "events.jsonl"),
+		statePath: filepath.Join(stateDir, "delivery.json"),
+	}
+	if err := readStateFile(l.statePath, "delivery", &l.state); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if l.state.Delivered == nil {
+		l.state.Delivered = make(map[string]uint64)
+	}
+	events, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-eventReplayMaxAge)
+	for _, ev := range events {
+		l.state.LastID = max(l.state.LastID, ev.ID)
+		if ev.Time.After(cutoff) {
+			l.events = append(l.events, ev)
+		}
+	}
+	if len(l.events) != len(events) {
+		if err := l.rewrite(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// load reads every event of the log; a torn last line from a crash is
+// skipped
+func (l *eventLog) load() ([]Event, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || ev.ID == 0 {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// rewrite replaces the log with the retained events and saves the state,
+// so LastID survives the compaction
+func (l *eventLog) rewrite() error {
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), "events.jsonl.tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	enc := json.NewEncoder(tmp)
+	for _, ev := range l.events {
+		if err := enc.Encode(ev); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+	return writeStateFile(l.statePath, "delivery", l.state)
+}
+
+// register starts tracking notifiers; one seen for the first time starts
+// at the current ID instead of receiving the whole log. The state is saved
+// right away, so a crash before the first acknowledgement still replays.
+func (l *eventLog) register(names []string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	added := false
+	for _, name := range names {
+		if _, ok := l.state.Delivered[name]; !ok {
+			l.state.Delivered[name] = l.state.LastID
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+	if err := writeStateFile(l.statePath, "delivery", l.state); err != nil {
+		fmt.Printf("❌ 配信状況の保存エラー: %v\n", err)
+	}
+}
+
+// append assigns ev the next ID and writes it durably
+func (l *eventLog) append(ev *Event) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ev.ID = l.state.LastID + 1
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(ev); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	l.state.LastID = ev.ID
+	// Memory holds only what could still be replayed; the file is
+	// compacted on the next start
+	cutoff := time.Now().Add(-eventReplayMaxAge)
+	for len(l.events) > 0 && !l.events[0].Time.After(cutoff) {
+		l.events = l.events[1:]
+	}
+	l.events = append(l.events, *ev)
+	return nil
+}
+
+// undelivered returns the events name has not delivered yet, oldest first
+func (l *eventLog) undelivered(name string) []Event {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var events []Event
+	for _, ev := range l.events {
+		if ev.ID > l.state.Delivered[name] {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// ack records that names delivered every event up to id
+func (l *eventLog) ack(id uint64, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, name := range names {
+		l.state.Delivered[name] = max(l.state.Delivered[name], id)
+	}
+	if err := writeStateFile(l.statePath, "delivery", l.state); err != nil {
+		fmt.Printf("❌ 配信状況の保存エラー: %v\n", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testEvent is an event raised now
+func testEvent(title string) Event {
+	return Event{Kind: EventOutage, Severity: SeverityCritical, Time: time.Now(), Title: title}
+}
+
+// runDispatcher dispatches titles through a dispatcher on the log of dir and closes
+// it, as one run of the monitor; replay resends what earlier runs left
+func runDispatcher(t *testing.T, dir string, replay bool, notifiers []Notifier, titles ...string) *eventLog {
+	t.Helper()
+	log, err := newEventLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDispatcher(notifiers, nil, log, textStyle{}, time.Now)
+	if replay {
+		d.replay()
+	}
+	for _, title := range titles {
+		d.dispatch(testEvent(title))
+	}
+	d.close()
+	return log
+}
+
+// TestEventLogReplay checks that what a notifier failed to deliver is
+// resent on the next start, before newer events, and nothing else is
+func TestEventLogReplay(t *testing.T) {
+	quietStdout(t)
+	dir := t.TempDir()
+	down := errors.New("unreachable")
+	failing := &fakeNotifier{name: "A", fail: func(ev Event) error {
+		if ev.ID == 2 {
+			return down
+		}
+		return nil
+	}}
+	working := &fakeNotifier{name: "B"}
+	log := runDispatcher(t, dir, false, []Notifier{failing, working}, "one", "two", "three")
+	if !slices.Equal(failing.ids(), []string{"1", "3"}) || !slices.Equal(working.ids(), []string{"1", "2", "3"}) {
+		t.Fatalf("first run: A %v, B %v", failing.ids(), working.ids())
+	}
+	// A stopped acknowledging at its failure, even past the event it
+	// delivered after it
+	if log.state.Delivered["A"] != 1 || log.state.Delivered["B"] != 3 {
+		t.Errorf("acknowledged %v", log.state.Delivered)
+	}
+
+	// The next start resends 2 and 3 to A only, then the new event: 3
+	// goes out twice, as at-least-once allows
+	failing, working = &fakeNotifier{name: "A"}, &fakeNotifier{name: "B"}
+	log, err := newEventLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDispatcher([]Notifier{failing, working}, nil, log, textStyle{}, time.Now)
+	if n := d.replayCount(); n != 2 {
+		t.Errorf("%d events to replay, want 2", n)
+	}
+	d.replay()
+	d.dispatch(testEvent("four"))
+	d.close()
+	if !slices.Equal(failing.ids(), []string{"2r", "3r", "4"}) || !slices.Equal(working.ids(), []string{"4"}) {
+		t.Errorf("second run: A %v, B %v", failing.ids(), working.ids())
+	}
+
+	// Everything is acknowledged now
+	log, err = newEventLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := log.undelivered("A"); len(u) != 0 || log.state.LastID != 4 {
+		t.Errorf("undelivered %v, last ID %d", u, log.state.LastID)
+	}
+}
+
+// TestEventLogNewNotifier starts a notifier added to the config at the
+// current ID rather than sending it the whole log
+func TestEventLogNewNotifier(t *testing.T) {
+	quietStdout(t)
+	dir := t.TempDir()
+	runDispatcher(t, dir, false, []Notifier{&fakeNotifier{name: "A"}}, "one", "two")
+	added := &fakeNotifier{name: "B"}
+	runDispatcher(t, dir, true, []Notifier{&fakeNotifier{name: "A"}, added}, "three")
+	if !slices.Equal(added.ids(), []string{"3"}) {
+		t.Errorf("new notifier got %v", added.ids())
+	}
+}
+
+// TestEventLogCompaction drops events too old to replay when the log is
+// opened, keeping IDs increasing
+func TestEventLogCompaction(t *testing.T) {
+	dir := t.TempDir()
+	log, err := newEventLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := testEvent("old")
+	old.Time = time.Now().Add(-eventReplayMaxAge - time.Minute)
+	recent := testEvent("recent")
+	for _, ev := range []*Event{&old, &recent} {
+		if err := log.append(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A line torn by a crash is skipped
+	f, _ := os.OpenFile(filepath.Join(dir, "events.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"id": 3, "kind": "out`)
+	f.Close()
+
+	log, err = newEventLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log.events) != 1 || log.events[0].ID != 2 || log.state.LastID != 2 {
+		t.Fatalf("kept %+v, last ID %d", log.events, log.state.LastID)
+	}
+	// The old event is gone from the file too, yet its ID is not reused
+	log, err = newEventLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := testEvent("next")
+	if err := log.append(&next); err != nil || next.ID != 3 {
+		t.Errorf("next ID %d, %v", next.ID, err)
+	}
+	events, _ := log.load()
+	if len(events) != 2 || events[0].ID != 2 || events[1].ID != 3 {
+		t.Errorf("file holds %+v", events)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Kinds of schedule marks, and the gap kinds of filled export rows
+const (
+	markStart   = "start"
+	markStop    = "stop"
+	markSuspend = "suspend"
+
+	gapStopped   = "stopped"
+	gapSuspended = "suspended"
+	gapMissed    = "missed"
+)
+
+// scheduleMark is one entry of state_dir/schedule.jsonl: when monitoring
+// started or stopped, and the slots a suspension skipped ([Time, End))
+type scheduleMark struct {
+	Time       time.Time  `json:"time"`
+	Kind       string     `json:"kind"`
+	End        *time.Time `json:"end,omitempty"`
+	IntervalMs int64      `json:"interval_ms,omitempty"`
+}
+
+// scheduleLogPath returns the schedule log of stateDir
+func scheduleLogPath(stateDir string) string {
+	return filepath.Join(stateDir, "schedule.jsonl")
+}
+
+// markSchedule appends m to the schedule log, when there is a state_dir
+func (pm *PingMonitor) markSchedule(m scheduleMark) {
+	if pm.config.StateDir == "" {
+		return
+	}
+	m.IntervalMs = pm.pingInterval.Milliseconds()
+	f, err := os.OpenFile(scheduleLogPath(pm.config.StateDir), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("❌ 計測スケジュールの記録エラー: %v\n", err)
+		return
+	}
+	defer f.Close()
+	json.NewEncoder(f).Encode(m)
+}
+
+// readScheduleMarks returns the marks of stateDir, oldest first; a missing
+// log has none
+func readScheduleMarks(stateDir string) ([]scheduleMark, error) {
+	f, err := os.Open(scheduleLogPath(stateDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var marks []scheduleMark
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m scheduleMark
+		if json.Unmarshal(scanner.Bytes(), &m) == nil {
+			marks = append(marks, m)
+		}
+	}
+	sort.SliceStable(marks, func(i, j int) bool { return marks[i].Time.Before(marks[j].Time) })
+	return marks, scanner.Err()
+}
+
+// exportRow is one row of the export. Without a sample Success and
+// ResponseTime are null and Gap says why the slot is empty.
+type exportRow struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Success      *bool     `json:"success"`
+	ResponseTime *float64  `json:"response_time_ms"`
+	Reason       string    `json:"reason,omitempty"`
+	Gap          string    `json:"gap,omitempty"`
+}
+
+// sampleRow converts a stored result; failures have no response time
+func sampleRow(at time.Time, rec ResultRecord) exportRow {
+	row := exportRow{Timestamp: at, Success: &rec.Success, Reason: rec.Reason}
+	if rec.Success {
+		rt := rec.ResponseTime
+		row.ResponseTime = &rt
+	}
+	return row
+}
+
+// exportInterval returns the ping interval of the latest start mark, or
+// the default one
+func exportInterval(marks []scheduleMark) time.Duration {
+	interval := time.Second
+	for _, m := range marks {
+		if m.Kind == markStart && m.IntervalMs > 0 {
+			interval = time.Duration(m.IntervalMs) * time.Millisecond
+		}
+	}
+	return interval
+}
+
+// fillSlots returns one row per schedule slot of [from, to). Samples are
+// placed in the slot they were sent in; a run of empty slots is "stopped"
+// when monitoring stopped or restarted around it, "suspended" when the
+// scheduler recorded a suspension over it and "missed" otherwise.
+func fillSlots(records []ResultRecord, marks []scheduleMark, from, to time.Time, interval time.Duration) []exportRow {
+	bySlot := make(map[int64]ResultRecord)
+	for _, rec := range records {
+		key := rec.Timestamp.Truncate(interval).UnixNano()
+		if _, ok := bySlot[key]; !ok {
+			bySlot[key] = rec
+		}
+	}
+	first := from.Truncate(interval)
+	if first.Before(from) {
+		first = first.Add(interval)
+	}
+
+	var rows []exportRow
+	runStart := -1
+	closeRun := func(end int) {
+		if runStart < 0 {
+			return
+		}
+		kind := gapKind(marks, rows[runStart].Timestamp, rows[end-1].Timestamp, interval)
+		for i := runStart; i < end; i++ {
+			rows[i].Gap = kind
+		}
+		runStart = -1
+	}
+	for t := first; t.Before(to); t = t.Add(interval) {
+		rec, ok := bySlot[t.UnixNano()]
+		if ok {
+			closeRun(len(rows))
+			rows = append(rows, sampleRow(t, rec))
+			continue
+		}
+		if runStart < 0 {
+			runStart = len(rows)
+		}
+		rows = append(rows, exportRow{Timestamp: t})
+	}
+	closeRun(len(rows))
+	return rows
+}
+
+// gapKind classifies the empty slots from start to end (inclusive)
+func gapKind(marks []scheduleMark, start, end time.Time, interval time.Duration) string {
+	suspended := false
+	for _, m := range marks {
+		switch m.Kind {
+		case markStart:
+			// The first slot of a run comes one interval after its start
+			if m.Time.After(start) && !m.Time.After(end.Add(interval)) {
+				return gapStopped
+			}
+		case markStop:
+			if !m.Time.Before(start.Add(-interval)) && !m.Time.After(end) {
+				return gapStopped
+			}
+		case markSuspend:
+			suspended = suspended || (m.End != nil && m.Time.Before(end.Add(interval)) && m.End.After(start))
+		}
+	}
+	if suspended {
+		return gapSuspended
+	}
+	return gapMissed
+}
+
+// writeExportCSV writes rows as CSV; missing values are empty cells, which
+// spreadsheet charts draw as gaps
+func writeExportCSV(w io.Writer, rows []exportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "success", "response_time_ms", "reason", "gap"})
+	for _, r := range rows {
+		success, rt := "", ""
+		if r.Success != nil {
+			success = strconv.FormatBool(*r.Success)
+		}
+		if r.ResponseTime != nil {
+			rt = strconv.FormatFloat(*r.ResponseTime, 'f', -1, 64)
+		}
+		cw.Write([]string{r.Timestamp.Format(time.RFC3339), success, rt, r.Reason, r.Gap})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseExportTime accepts a date (local midnight) or an RFC3339 time
+func parseExportTime(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// runExport writes stored results as CSV or JSON, optionally with a row
+// for every schedule slot
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "設定ファイルのパス")
+	date := fs.String("date", time.Now().Format("2006-01-02"), "対象日 (YYYY-MM-DD)")
+	fromFlag := fs.String("from", "", "開始 (YYYY-MM-DD またはRFC3339、-dateより優先)")
+	toFlag := fs.String("to", "", "終了 (YYYY-MM-DD またはRFC3339、含まない)")
+	format := fs.String("format", "csv", "出力形式 (csv または json)")
+	fillGaps := fs.Bool("fill-gaps", false, "予定されていたping間隔ごとに1行を出力し、欠測を空欄(null)で示す")
+	target := fs.String("target", "", "書き出す対象（省略時は target。targets の追加の対象は保存されないため指定できません）")
+	fs.Parse(args)
+
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "エラー: -format の値が正しくありません: %q (csv または json)\n", *format)
+		return 2
+	}
+	cfg, err := readConfig(*configPath, ConfigOverrides{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	if *target != "" && *target != cfg.Target {
+		if t, ok := cfg.additionalTarget(*target); ok {
+			fmt.Fprintf(os.Stderr, "エラー: 追加の対象 %s の計測は保存されないため書き出せません（直近24時間は /api/v1/series?target=%s で取得できます）\n", t.Name, t.Name)
+		} else {
+			fmt.Fprintf(os.Stderr, "エラー: -target が target（%s）とも targets とも一致しません: %q\n", cfg.Target, *target)
+		}
+		return 2
+	}
+	if cfg.storeKind() == storeMemory || (cfg.storeKind() == storeFile && cfg.ResultsFile == "") {
+		fmt.Fprintln(os.Stderr, "エラー: 書き出しにはconfig.jsonのresults_fileの設定（store が file のとき）か store の sqlite が必要です")
+		return 1
+	}
+
+	from, err := parseExportTime(*date)
+	if *fromFlag != "" {
+		from, err = parseExportTime(*fromFlag)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: 開始の値が正しくありません: %v\n", err)
+		return 2
+	}
+	to := from.AddDate(0, 0, 1)
+	if *toFlag != "" {
+		if to, err = parseExportTime(*toFlag); err != nil || !to.After(from) {
+			fmt.Fprintf(os.Stderr, "エラー: -to の値が正しくありません: %q\n", *toFlag)
+			return 2
+		}
+	}
+	// Slots in the future have not been missed yet
+	if now := time.Now(); *fillGaps && to.After(now) {
+		to = now
+	}
+
+	store, err := openStore(cfg, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	records, err := store.QueryRange(from, to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+
+	var rows []exportRow
+	if *fillGaps {
+		var marks []scheduleMark
+		if cfg.StateDir != "" {
+			if marks, err = readScheduleMarks(cfg.StateDir); err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+				return 1
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, "注意: state_dir が未設定のため、欠測はすべて missed として出力します")
+		}
+		rows = fillSlots(records, marks, from, to, exportInterval(marks))
+	} else {
+		for _, rec := range records {
+			rows = append(rows, sampleRow(rec.Timestamp, rec))
+		}
+	}
+
+	if *format == "json" {
+		if rows == nil {
+			rows = []exportRow{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rows)
+	} else {
+		err = writeExportCSV(os.Stdout, rows)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportLines renders rows as "15:04:05 success ms reason gap" for
+// comparing, "-" standing for a null or empty value
+func exportLines(rows []exportRow) []string {
+	lines := make([]string, len(rows))
+	for i, r := range rows {
+		success, rt, reason, gap := "-", "-", r.Reason, r.Gap
+		if r.Success != nil {
+			success = strconv.FormatBool(*r.Success)
+		}
+		if r.ResponseTime != nil {
+			rt = strconv.FormatFloat(*r.ResponseTime, 'f', -1, 64)
+		}
+		if reason == "" {
+			reason = "-"
+		}
+		if gap == "" {
+			gap = "-"
+		}
+		lines[i] = fmt.Sprintf("%s %s %s %s %s", r.Timestamp.Format("15:04:05"), success, rt, reason, gap)
+	}
+	return lines
+}
+
+// TestExportGaps fills the slots of the conformance results read back
+// from every backend, with the gaps a restart and a suspension leave, and
+// runs the export subcommand on the persistent ones
+func TestExportGaps(t *testing.T) {
+	loc := setLocal(t, "Asia/Tokyo")
+	results := storeResults(loc)
+	day := time.Date(storeDay.Year(), storeDay.Month(), storeDay.Day(), 0, 0, 0, 0, loc)
+	at := func(h, m, s, ms int) time.Time {
+		return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(ms)*time.Millisecond)
+	}
+	end := at(10, 0, 10, 0)
+	tests := []struct {
+		name     string
+		marks    []scheduleMark
+		from, to time.Time
+		want     []string
+	}{
+		{
+			name: "stopped and restarted",
+			marks: []scheduleMark{
+				{Time: at(9, 0, 0, 0), Kind: markStart, IntervalMs: 1000},
+				{Time: at(10, 0, 5, 500), Kind: markStop},
+				{Time: at(10, 0, 9, 500), Kind: markStart, IntervalMs: 1000},
+			},
+			from: at(10, 0, 0, 0), to: end,
+			want: []string{
+				"10:00:00 true 12.5 - -",
+				// equal times: the first appended fills the slot
+				"10:00:01 false - timeout -",
+				"10:00:02 true 800 - -",
+				"10:00:03 false - timeout -",
+				"10:00:04 true 900 - -",
+				"10:00:05 false - measurement -",
+				"10:00:06 - - - stopped",
+				"10:00:07 - - - stopped",
+				"10:00:08 - - - stopped",
+				"10:00:09 - - - stopped",
+			},
+		},
+		{
+			name: "suspended",
+			marks: []scheduleMark{
+				{Time: at(9, 0, 0, 0), Kind: markStart, IntervalMs: 1000},
+				{Time: at(10, 0, 6, 0), Kind: markSuspend, End: &end},
+			},
+			from: at(10, 0, 4, 0), to: end,
+			want: []string{
+				"10:00:04 true 900 - -",
+				"10:00:05 false - measurement -",
+				"10:00:06 - - - suspended",
+				"10:00:07 - - - suspended",
+				"10:00:08 - - - suspended",
+				"10:00:09 - - - suspended",
+			},
+		},
+		{
+			name: "restart before a sample, a slot missed after it",
+			marks: []scheduleMark{
+				{Time: at(11, 29, 59, 500), Kind: markStart, IntervalMs: 1000},
+			},
+			from: at(11, 29, 58, 0), to: at(11, 30, 3, 0),
+			want: []string{
+				"11:29:58 - - - stopped",
+				"11:29:59 - - - stopped",
+				"11:30:00 false - unreachable -",
+				"11:30:01 true 20 - -",
+				"11:30:02 - - - missed",
+			},
+		},
+		{
+			name: "no schedule log",
+			from: at(23, 59, 57, 0), to: at(24, 0, 1, 0),
+			want: []string{
+				"23:59:57 - - - missed",
+				"23:59:58 - - - missed",
+				"23:59:59 true 10 - -",
+				"00:00:00 true 11 - -",
+			},
+		},
+	}
+
+	for _, b := range storeBackends {
+		t.Run(b.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := b.open(t, dir)
+			for _, rec := range results {
+				if err := s.AppendResult(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, tt := range tests {
+				records, err := s.QueryRange(tt.from, tt.to)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows := fillSlots(records, tt.marks, tt.from, tt.to, exportInterval(tt.marks))
+				if got := exportLines(rows); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+					t.Errorf("%s:\n got  %s\n want %s", tt.name, strings.Join(got, "\n      "), strings.Join(tt.want, "\n      "))
+				}
+			}
+			s.Close()
+			if !b.persistent {
+				return
+			}
+
+			cfg := map[string]interface{}{"store": b.name, "state_dir": dir}
+			if b.name == storeFile {
+				cfg["results_file"] = filepath.Join(dir, "results.jsonl")
+			}
+			data, _ := json.Marshal(cfg)
+			configPath := filepath.Join(dir, "config.json")
+			if err := os.WriteFile(configPath, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			for _, tt := range tests {
+				var log []byte
+				for _, m := range tt.marks {
+					line, _ := json.Marshal(m)
+					log = append(log, append(line, '\n')...)
+				}
+				if err := os.WriteFile(scheduleLogPath(dir), log, 0644); err != nil {
+					t.Fatal(err)
+				}
+				code := 0
+				out := captureStdout(t, func() {
+					code = runExport([]string{"-config", configPath, "-format", "json", "-fill-gaps",
+						"-from", tt.from.Format(time.RFC3339), "-to", tt.to.Format(time.RFC3339)})
+				})
+				var rows []exportRow
+				if code != 0 {
+					t.Fatalf("%s: export exited %d", tt.name, code)
+				}
+				if err := json.Unmarshal([]byte(out), &rows); err != nil {
+					t.Fatalf("%s: %v in %q", tt.name, err, out)
+				}
+				for i := range rows {
+					rows[i].Timestamp = rows[i].Timestamp.In(loc)
+				}
+				if got := exportLines(rows); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+					t.Errorf("export %s:\n got  %s", tt.name, strings.Join(got, "\n      "))
+				}
+			}
+		})
+	}
+}
+
+// TestStoredTargetOnly rejects asking the Store for an additional target,
+// whose samples it does not hold
+func TestStoredTargetOnly(t *testing.T) {
+	quietStdout(t)
+	targets := []map[string]string{{"name": "isp-dns", "address": "203.0.113.53"}}
+	pm, _ := newTestMonitor(t, map[string]interface{}{"targets": targets, "api_token": "secret"}, time.Now())
+	handler := newAPIServer(pm).server.Handler
+	for _, tt := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/api/v1/results", http.StatusOK, ""},
+		{"/api/v1/results?target=8.8.8.8", http.StatusOK, ""},
+		{"/api/v1/results?target=isp-dns", http.StatusNotFound, "/api/v1/series?target=isp-dns"},
+		{"/api/v1/results?target=203.0.113.53", http.StatusNotFound, "/api/v1/series?target=isp-dns"},
+		{"/api/v1/results?target=192.0.2.99", http.StatusNotFound, "unknown target"},
+		{"/api/v1/daily?target=isp-dns", http.StatusNotFound, "not stored"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.code || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s: %d %q, want %d %q", tt.path, rec.Code, rec.Body, tt.code, tt.body)
+		}
+	}
+
+	dir := t.TempDir()
+	data, _ := json.Marshal(map[string]interface{}{"target": "8.8.8.8", "targets": targets, "results_file": filepath.Join(dir, "results.jsonl")})
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for target, want := range map[string]int{"": 0, "8.8.8.8": 0, "isp-dns": 2, "203.0.113.53": 2, "192.0.2.99": 2} {
+		code := -1
+		captureStdout(t, func() { code = runExport([]string{"-config", configPath, "-date", "2026-03-10", "-target", target}) })
+		if code != want {
+			t.Errorf("export -target %q: exit %d, want %d", target, code, want)
+		}
+	}
+}
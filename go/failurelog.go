@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+
+	"ping-monitor/api"
+)
+
+// failureOutputMaxBytes bounds the stored output of one failed probe
+const failureOutputMaxBytes = 2048
+
+// failureOutputAlertChars is how much of the latest output an outage alert
+// quotes
+const failureOutputAlertChars = 300
+
+// truncateBytes shortens s to at most n bytes without splitting a rune
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// failureLog keeps the last outputs of failed probes per target in fixed
+// rings, so memory is bounded by keep × failureOutputMaxBytes per target
+type failureLog struct {
+	mutex   sync.Mutex
+	keep    int
+	targets map[string]*failureRing
+}
+
+// failureRing is a circular buffer of outputs; next is the slot written
+// next and n the number of filled slots
+type failureRing struct {
+	entries []FailureOutput
+	next    int
+	n       int
+}
+
+// newFailureLog creates a log keeping keep outputs per target; keep == 0
+// disables it
+func newFailureLog(keep int) *failureLog {
+	return &failureLog{keep: keep, targets: make(map[string]*failureRing)}
+}
+
+// add stores one output, replacing the oldest when the ring is full
+func (l *failureLog) add(target string, entry FailureOutput) {
+	if l.keep == 0 {
+		return
+	}
+	if len(entry.Output) > failureOutputMaxBytes {
+		entry.Output = truncateBytes(entry.Output, failureOutputMaxBytes)
+		entry.Truncated = true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	r, ok := l.targets[target]
+	if !ok {
+		r = &failureRing{entries: make([]FailureOutput, l.keep)}
+		l.targets[target] = r
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	r.n = min(r.n+1, len(r.entries))
+}
+
+// recent returns the stored outputs of target, newest first
+func (l *failureLog) recent(target string) []FailureOutput {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	out := []FailureOutput{}
+	r, ok := l.targets[target]
+	if !ok {
+		return out
+	}
+	for i := 1; i <= r.n; i++ {
+		out = append(out, r.entries[(r.next-i+len(r.entries))%len(r.entries)])
+	}
+	return out
+}
+
+// latest returns the newest output of target
+func (l *failureLog) latest(target string) (FailureOutput, bool) {
+	recent := l.recent(target)
+	if len(recent) == 0 {
+		return FailureOutput{}, false
+	}
+	return recent[0], true
+}
+
+// probeOutput returns what a failed probe printed, or the error text when
+// no command ran (simulated failures, unresolved names)
+func probeOutput(err error) string {
+	var pe *probeError
+	if errors.As(err, &pe) && pe.output != "" {
+		return pe.output
+	}
+	return err.Error()
+}
+
+// handleDebugFailures returns the recent failure outputs, newest first
+func (s *apiServer) handleDebugFailures(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		target = s.pm.targetIP
+	}
+	if target != s.pm.targetIP {
+		http.Error(w, "unknown target: "+target, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, FailuresResponse{Schema: api.SchemaFailures, Target: target, Failures: s.pm.failureLog.recent(target)})
+}
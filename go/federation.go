@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotSchemaVersion is the version of SiteSnapshot accepted by /ingest
+const snapshotSchemaVersion = 1
+
+// missingSiteMarker is shown for expected sites whose snapshot never arrived
+const missingSiteMarker = "未受信"
+
+// CollectorConfig configures collector mode, in which this instance
+// receives daily snapshots from other monitors and sends one combined report
+type CollectorConfig struct {
+	Enabled     bool     `json:"enabled"`
+	IngestToken string   `json:"ingest_token" secret:"true"`
+	Sites       []string `json:"sites"`
+	Deadline    string   `json:"deadline"`
+	// Verbose lists healthy sites individually instead of one summary line
+	Verbose bool `json:"verbose"`
+}
+
+// SiteSnapshot is the daily snapshot a monitor posts to its collector
+type SiteSnapshot struct {
+	SchemaVersion  int        `json:"schema_version"`
+	Site           string     `json:"site"`
+	Date           string     `json:"date"`
+	Target         string     `json:"target"`
+	LocalIP        string     `json:"local_ip"`
+	Stats          ProbeStats `json:"stats"`
+	SimulatedCount int        `json:"simulated_count,omitempty"`
+	// Coverage is absent in snapshots from older monitors
+	Coverage    *ReportCoverage `json:"coverage,omitempty"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	// PeriodStart and PeriodEnd bound the day, the end exclusive; absent
+	// in snapshots from older monitors
+	PeriodStart *time.Time `json:"period_start,omitempty"`
+	PeriodEnd   *time.Time `json:"period_end,omitempty"`
+	// Outages counts real outages that ended during the day
+	Outages int `json:"outages,omitempty"`
+	// GatewayFailures are the failures in Stats that fell inside outages
+	// attributed to the site's gateway
+	GatewayFailures int `json:"gateway_failures,omitempty"`
+}
+
+// lineFailures returns the failures not attributed to the gateway
+func (s *SiteSnapshot) lineFailures() int {
+	return s.Stats.Failure - s.GatewayFailures
+}
+
+// lineLossPercent is the loss of the line alone, for summaries
+func (s *SiteSnapshot) lineLossPercent() float64 {
+	if s.Stats.Total == 0 {
+		return 0
+	}
+	return float64(s.lineFailures()) / float64(s.Stats.Total) * 100
+}
+
+// siteSnapshot builds the snapshot of a finalized period
+func (pm *PingMonitor) siteSnapshot(p *reportPeriod) SiteSnapshot {
+	outages := 0
+	for _, o := range p.Outages {
+		if !o.Simulated {
+			outages++
+		}
+	}
+	return SiteSnapshot{
+		SchemaVersion:   snapshotSchemaVersion,
+		Site:            pm.config.SiteName,
+		Date:            p.Date,
+		PeriodStart:     &p.Start,
+		PeriodEnd:       &p.End,
+		Target:          pm.targetIP,
+		LocalIP:         pm.localIP,
+		Stats:           p.probeStats(),
+		SimulatedCount:  p.SimulatedCount,
+		Coverage:        &p.Coverage,
+		GeneratedAt:     pm.now(),
+		Outages:         outages,
+		GatewayFailures: p.gatewayFailures(),
+	}
+}
+
+// publishDailyReport delivers a finalized period to Discord and, for
+// complete days, to the collector
+func (pm *PingMonitor) publishDailyReport(p *reportPeriod) {
+	// Before the slow sends, so a peer asking at midnight finds the day
+	if !p.Interim {
+		day := statusDay(p)
+		pm.lastDay.Store(&day)
+	}
+	p.Regression = pm.dailyRegression(p)
+	if pm.config.ReportTo == "" || !pm.config.ReportToOnly || p.Interim {
+		pm.sendDailyReport(p)
+	}
+	pm.dispatcher.dispatch(pm.dailyReportEvent(p))
+	pm.alertRegression(p)
+
+	// Interim periods are partial days and would overwrite the site's row
+	if p.Interim {
+		return
+	}
+	pm.saveReportFiles(p)
+	snap := pm.siteSnapshot(p)
+	if pm.collector != nil {
+		pm.collector.ingest(snap)
+	}
+	if pm.config.ReportTo != "" {
+		if err := pm.postSnapshot(snap); err != nil {
+			fmt.Printf("❌ 集約先への送信エラー: %v\n", err)
+		} else {
+			fmt.Printf("✅ %sのスナップショットを集約先に送信しました\n", p.Date)
+		}
+	}
+}
+
+// postSnapshot sends a snapshot to the configured collector
+func (pm *PingMonitor) postSnapshot(snap SiteSnapshot) error {
+	jsonData, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, pm.config.ReportTo, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+pm.config.ReportToToken)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("collector error: %d - %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// collector merges snapshots by site and sends a combined daily report once
+// the deadline for a date has passed
+type collector struct {
+	pm       *PingMonitor
+	cfg      CollectorConfig
+	deadline time.Duration
+	mutex    sync.Mutex
+	days     map[string]map[string]SiteSnapshot
+	sent     map[string]bool
+}
+
+// newCollector creates a collector; the deadline is parsed by Config.validate
+func newCollector(pm *PingMonitor) *collector {
+	deadline, _ := parseTimeOfDay(pm.config.Collector.Deadline)
+	c := &collector{
+		pm:       pm,
+		cfg:      pm.config.Collector,
+		deadline: deadline,
+		days:     make(map[string]map[string]SiteSnapshot),
+		sent:     make(map[string]bool),
+	}
+	c.load()
+	return c
+}
+
+// parseTimeOfDay parses "HH:MM" into an offset from midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ingest stores a snapshot; snapshots arriving after the combined report
+// was sent are forwarded as a late follow-up
+func (c *collector) ingest(snap SiteSnapshot) {
+	c.mutex.Lock()
+	if c.days[snap.Date] == nil {
+		c.days[snap.Date] = make(map[string]SiteSnapshot)
+	}
+	c.days[snap.Date][snap.Site] = snap
+	late := c.sent[snap.Date]
+	c.save(snap.Date)
+	c.mutex.Unlock()
+
+	fmt.Printf("📥 %s の %s スナップショットを受信しました\n", snap.Site, snap.Date)
+	if late {
+		c.sendLate(snap)
+	}
+}
+
+// run checks once a minute whether a date's deadline has passed
+func (c *collector) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			c.flushDue(now)
+		}
+	}
+}
+
+// flushDue sends the combined report of every unsent date whose deadline
+// (the configured time of day after the date ends) has passed
+func (c *collector) flushDue(now time.Time) {
+	c.mutex.Lock()
+	var due []string
+	for date := range c.days {
+		day, err := time.ParseInLocation(reportDateLayout, date, now.Location())
+		if err != nil || c.sent[date] {
+			continue
+		}
+		if now.After(day.AddDate(0, 0, 1).Add(c.deadline)) {
+			due = append(due, date)
+		}
+	}
+	sort.Strings(due)
+	c.mutex.Unlock()
+
+	for _, date := range due {
+		c.sendCombined(date)
+	}
+}
+
+// siteRows returns the snapshots of date ordered by the configured site
+// list, with nil entries for sites that have not reported
+func (c *collector) siteRows(date string) ([]string, []*SiteSnapshot) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	names := append([]string(nil), c.cfg.Sites...)
+	known := make(map[string]bool)
+	for _, n := range names {
+		known[n] = true
+	}
+	var extra []string
+	for site := range c.days[date] {
+		if !known[site] {
+			extra = append(extra, site)
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	rows := make([]*SiteSnapshot, len(names))
+	for i, n := range names {
+		if snap, ok := c.days[date][n]; ok {
+			rows[i] = &snap
+		}
+	}
+	return names, rows
+}
+
+// siteScores returns the health score of each row; sites that have not
+// reported score 0. The p95 baseline of a site is the median p95 of its
+// previous days still held by the collector.
+func (c *collector) siteScores(date string, names []string, rows []*SiteSnapshot) []float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	scores := make([]float64, len(rows))
+	for i, r := range rows {
+		if r == nil {
+			continue
+		}
+		var history []float64
+		for d, snaps := range c.days {
+			if d >= date {
+				continue
+			}
+			if prev, ok := snaps[names[i]]; ok && prev.Stats.Success > 0 && (prev.Coverage == nil || prev.Coverage.Sufficient) {
+				history = append(history, prev.Stats.Latency.P95)
+			}
+		}
+		scores[i] = healthScore(healthInput{
+			LossPercent: r.lineLossPercent(),
+			P95:         r.Stats.Latency.P95,
+			BaselineP95: medianP95(history),
+			Outages:     r.Outages,
+		})
+	}
+	return scores
+}
+
+// orderByHealth sorts the rows worst-first, by name among equal scores
+func orderByHealth(names []string, rows []*SiteSnapshot, scores []float64) {
+	idx := make([]int, len(names))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		if scores[idx[a]] != scores[idx[b]] {
+			return scores[idx[a]] < scores[idx[b]]
+		}
+		return names[idx[a]] < names[idx[b]]
+	})
+	n := append([]string(nil), names...)
+	r := append([]*SiteSnapshot(nil), rows...)
+	s := append([]float64(nil), scores...)
+	for to, from := range idx {
+		names[to], rows[to], scores[to] = n[from], r[from], s[from]
+	}
+}
+
+// formatSiteTable renders the per-site table as a code block. Unless
+// verbose, fully healthy sites are collapsed into one summary line.
+func formatSiteTable(names []string, rows []*SiteSnapshot, scores []float64, verbose bool) string {
+	var b strings.Builder
+	b.WriteString("```\n")
+	fmt.Fprintf(&b, "%-12s %6s %8s %9s %6s\n", "サイト", "健全度", "成功率", "平均", "失敗")
+	collapsed, gatewayFailures := 0, 0
+	for i, name := range names {
+		if rows[i] == nil {
+			fmt.Fprintf(&b, "%-12s %6s %s\n", name, "-", missingSiteMarker)
+			continue
+		}
+		if !verbose && healthy(scores[i]) {
+			collapsed++
+			continue
+		}
+		st := rows[i].Stats
+		marker := ""
+		if c := rows[i].Coverage; c != nil {
+			marker = c.Marker()
+		}
+		gateway := ""
+		if n := rows[i].GatewayFailures; n > 0 {
+			gateway = fmt.Sprintf(" (+GW %d)", n)
+			gatewayFailures += n
+		}
+		fmt.Fprintf(&b, "%-12s %6.0f %7.2f%% %7.1fms %6d%s%s\n", name, scores[i], st.SuccessRate, st.Latency.Avg, rows[i].lineFailures(), gateway, marker)
+	}
+	switch {
+	case collapsed > 0 && collapsed == len(names):
+		fmt.Fprintf(&b, "全 %d サイト: 異常なし\n", collapsed)
+	case collapsed > 0:
+		fmt.Fprintf(&b, "他 %d サイト: 異常なし\n", collapsed)
+	}
+	if gatewayFailures > 0 {
+		fmt.Fprintf(&b, "起因: ゲートウェイ %d回（失敗数・健全度には含めず）\n", gatewayFailures)
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+// sendCombined sends the combined report of date and marks it sent
+func (c *collector) sendCombined(date string) {
+	names, rows := c.siteRows(date)
+	scores := c.siteScores(date, names, rows)
+	orderByHealth(names, rows, scores)
+	table := formatSiteTable(names, rows, scores, c.cfg.Verbose)
+
+	worst := 100.0
+	received := 0
+	for _, r := range rows {
+		if r == nil {
+			continue
+		}
+		received++
+		if r.Stats.SuccessRate < worst {
+			worst = r.Stats.SuccessRate
+		}
+	}
+
+	color := 0x00ff00 // Green
+	if worst < 99 || received < len(rows) {
+		color = 0xff9900 // Orange
+	}
+	if worst < 95 {
+		color = 0xff0000 // Red
+	}
+
+	embed := DiscordEmbed{
+		Title:       "🌐 Ping Monitor 統合日次レポート",
+		Description: fmt.Sprintf("**日付**: %s\n**受信サイト**: %d/%d", date, received, len(rows)),
+		Color:       color,
+		Fields: []EmbedField{
+			{
+				Name:   "📊 サイト別統計（問題の大きい順）",
+				Value:  table,
+				Inline: false,
+			},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+		Footer: EmbedFooter{
+			Text: "Ping Monitor by Go",
+		},
+	}
+
+	c.mutex.Lock()
+	c.sent[date] = true
+	c.save(date)
+	c.mutex.Unlock()
+
+	if !c.pm.config.webhookConfigured() {
+		fmt.Printf("統合日次レポート %s (受信 %d/%d):\n%s\n", date, received, len(rows), table)
+		return
+	}
+	if err := c.pm.sendToDiscord(DiscordMessage{Embeds: []DiscordEmbed{embed}}); err != nil {
+		fmt.Printf("❌ Discord送信エラー: %v\n", err)
+	} else {
+		fmt.Printf("✅ %sの統合日次レポートをDiscordに送信しました\n", date)
+	}
+}
+
+// sendLate reports a snapshot that arrived after the combined report
+func (c *collector) sendLate(snap SiteSnapshot) {
+	names, rows := []string{snap.Site}, []*SiteSnapshot{&snap}
+	table := formatSiteTable(names, rows, c.siteScores(snap.Date, names, rows), true)
+	if !c.pm.config.webhookConfigured() {
+		fmt.Printf("遅延受信 %s (%s):\n%s\n", snap.Site, snap.Date, table)
+		return
+	}
+	description := fmt.Sprintf("**日付**: %s\n**サイト**: %s", snap.Date, snap.Site)
+	if snap.PeriodStart != nil && snap.PeriodEnd != nil {
+		description += "\n**対象期間**: " + c.pm.format.period(*snap.PeriodStart, *snap.PeriodEnd)
+	}
+	embed := DiscordEmbed{
+		Title:       "🌐 Ping Monitor 統合日次レポート（遅延受信）",
+		Description: description,
+		Color:       0x808080,
+		Fields:      []EmbedField{{Name: "📊 サイト別統計", Value: table}},
+		Timestamp:   time.Now().Format(time.RFC3339),
+		Footer:      EmbedFooter{Text: "Ping Monitor by Go"},
+	}
+	if err := c.pm.sendToDiscord(DiscordMessage{Embeds: []DiscordEmbed{embed}}); err != nil {
+		fmt.Printf("❌ Discord送信エラー: %v\n", err)
+	}
+}
+
+// collectorDay is the persisted state of one date
+type collectorDay struct {
+	Sent      bool                    `json:"sent"`
+	Snapshots map[string]SiteSnapshot `json:"snapshots"`
+}
+
+// save persists date when a state directory is configured; the caller
+// holds c.mutex
+func (c *collector) save(date string) {
+	if c.pm.config.StateDir == "" {
+		return
+	}
+	path := filepath.Join(c.pm.config.StateDir, "collector", date+".json")
+	day := collectorDay{Sent: c.sent[date], Snapshots: c.days[date]}
+	if err := writeStateFile(path, "collector", day); err != nil {
+		fmt.Printf("❌ 集約状態の保存エラー: %v\n", err)
+	}
+}
+
+// load restores the dates of the last week from the state directory
+func (c *collector) load() {
+	if c.pm.config.StateDir == "" {
+		return
+	}
+	for i := 0; i < 7; i++ {
+		date := time.Now().AddDate(0, 0, -i).Format(reportDateLayout)
+		var day collectorDay
+		if err := readStateFile(filepath.Join(c.pm.config.StateDir, "collector", date+".json"), "collector", &day); err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Printf("❌ 集約状態の読み込みエラー: %v\n", err)
+			}
+			continue
+		}
+		if day.Snapshots == nil {
+			day.Snapshots = make(map[string]SiteSnapshot)
+		}
+		c.days[date] = day.Snapshots
+		c.sent[date] = day.Sent
+	}
+}
+
+// maxSnapshotBytes bounds the size of an ingested snapshot
+const maxSnapshotBytes = 64 << 10
+
+// handleIngest accepts a SiteSnapshot from another monitor
+func (s *apiServer) handleIngest(w http.ResponseWriter, r *http.Request) {
+	c := s.pm.collector
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if c.cfg.IngestToken == "" || !ok || subtle.ConstantTimeCompare([]byte(given), []byte(c.cfg.IngestToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var snap SiteSnapshot
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSnapshotBytes)).Decode(&snap); err != nil {
+		http.Error(w, "invalid snapshot: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if snap.SchemaVersion != snapshotSchemaVersion {
+		http.Error(w, fmt.Sprintf("unsupported schema_version %d (expected %d)", snap.SchemaVersion, snapshotSchemaVersion), http.StatusBadRequest)
+		return
+	}
+	if snap.Site == "" {
+		http.Error(w, "site is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse(reportDateLayout, snap.Date); err != nil {
+		http.Error(w, "invalid date: "+snap.Date, http.StatusBadRequest)
+		return
+	}
+
+	c.ingest(snap)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// numberLocales are the separators of each locale: thousands, then decimal
+var numberLocales = map[string][2]string{
+	"ja": {",", "."},
+	"en": {",", "."},
+	"de": {".", ","},
+	"fr": {" ", ","},
+}
+
+// periodLocales are the date layout and range separator of each locale
+var periodLocales = map[string][2]string{
+	"ja": {"2006-01-02", " 〜 "},
+	"en": {"2006-01-02", " – "},
+	"de": {"02.01.2006", " – "},
+	"fr": {"02/01/2006", " – "},
+}
+
+// numberFormat renders counts, latencies, percentages and durations for
+// reports and alerts. The zero value is the ja locale with compact
+// durations.
+type numberFormat struct {
+	locale string
+	// spaced writes durations as "1h 12m 30s" instead of "1h12m30s"
+	spaced bool
+}
+
+// newNumberFormat returns the format configured by cfg
+func newNumberFormat(cfg Config) numberFormat {
+	return numberFormat{locale: cfg.Locale, spaced: cfg.DurationStyle == "spaced"}
+}
+
+// separators returns the thousands and decimal separators
+func (f numberFormat) separators() (string, string) {
+	if seps, ok := numberLocales[f.locale]; ok {
+		return seps[0], seps[1]
+	}
+	return ",", "."
+}
+
+// float renders v with prec decimals and grouped thousands
+func (f numberFormat) float(v float64, prec int) string {
+	group, decimal := f.separators()
+	s := strconv.FormatFloat(v, 'f', prec, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(group)
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString(decimal)
+		b.WriteString(frac)
+	}
+	return sign + b.String()
+}
+
+// count renders a whole number, e.g. "86,400"
+func (f numberFormat) count(n int) string {
+	return f.float(float64(n), 0)
+}
+
+// ms renders a latency, e.g. "12.3ms"
+func (f numberFormat) ms(v float64) string {
+	return f.float(v, 1) + "ms"
+}
+
+// percent renders a percentage with prec decimals, e.g. "99.95%"
+func (f numberFormat) percent(v float64, prec int) string {
+	return f.float(v, prec) + "%"
+}
+
+// duration renders d rounded to the second
+func (f numberFormat) duration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if !f.spaced {
+		return d.String()
+	}
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	h, m, s := int(d/time.Hour), int(d%time.Hour/time.Minute), int(d%time.Minute/time.Second)
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%s%dh %dm %ds", sign, h, m, s)
+	case m > 0:
+		return fmt.Sprintf("%s%dm %ds", sign, m, s)
+	}
+	return fmt.Sprintf("%s%ds", sign, s)
+}
+
+// period renders the bounds of a report period, e.g. "2024-05-01 00:00:00
+// JST 〜 23:59:59 JST". end is exclusive, so the last second is shown; its
+// date is left out when it falls on the day of start.
+func (f numberFormat) period(start, end time.Time) string {
+	l, ok := periodLocales[f.locale]
+	if !ok {
+		l = periodLocales["ja"]
+	}
+	last := end
+	if end.After(start) {
+		last = end.Add(-time.Nanosecond)
+	}
+	to := last.Format("15:04:05 MST")
+	if last.Format(reportDateLayout) != start.Format(reportDateLayout) {
+		to = last.Format(l[0]) + " " + to
+	}
+	return start.Format(l[0]+" 15:04:05 MST") + l[1] + to
+}
+
+// seconds renders a duration given in seconds
+func (f numberFormat) seconds(s float64) string {
+	return f.duration(time.Duration(s * float64(time.Second)))
+}
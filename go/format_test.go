@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNumberFormat renders the same values in every locale and style
+func TestNumberFormat(t *testing.T) {
+	start := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	render := func(f numberFormat) []string {
+		return []string{
+			f.count(7),
+			f.count(86400),
+			f.count(-1234567),
+			f.ms(12.34),
+			f.ms(1234.56),
+			f.percent(99.95, 2),
+			f.percent(0.5, 1),
+			f.float(999.96, 1),
+			f.duration(4530 * time.Second),
+			f.duration(90500 * time.Millisecond),
+			f.duration(-45 * time.Second),
+			f.seconds(0.4),
+			f.period(start, start.Add(24*time.Hour)),
+			f.period(start.Add(6*time.Hour), start.Add(30*time.Hour)),
+		}
+	}
+	// fr groups with a narrow no-break space
+	tests := []struct {
+		format numberFormat
+		want   []string
+	}{
+		{numberFormat{}, []string{
+			"7", "86,400", "-1,234,567", "12.3ms", "1,234.6ms", "99.95%", "0.5%", "1,000.0",
+			"1h15m30s", "1m31s", "-45s", "0s",
+			"2026-03-10 00:00:00 UTC 〜 23:59:59 UTC", "2026-03-10 06:00:00 UTC 〜 2026-03-11 05:59:59 UTC",
+		}},
+		{numberFormat{locale: "en", spaced: true}, []string{
+			"7", "86,400", "-1,234,567", "12.3ms", "1,234.6ms", "99.95%", "0.5%", "1,000.0",
+			"1h 15m 30s", "1m 31s", "-45s", "0s",
+			"2026-03-10 00:00:00 UTC – 23:59:59 UTC", "2026-03-10 06:00:00 UTC – 2026-03-11 05:59:59 UTC",
+		}},
+		{numberFormat{locale: "de"}, []string{
+			"7", "86.400", "-1.234.567", "12,3ms", "1.234,6ms", "99,95%", "0,5%", "1.000,0",
+			"1h15m30s", "1m31s", "-45s", "0s",
+			"10.03.2026 00:00:00 UTC – 23:59:59 UTC", "10.03.2026 06:00:00 UTC – 11.03.2026 05:59:59 UTC",
+		}},
+		{numberFormat{locale: "fr", spaced: true}, []string{
+			"7", "86\u202f400", "-1\u202f234\u202f567", "12,3ms", "1\u202f234,6ms", "99,95%", "0,5%", "1\u202f000,0",
+			"1h 15m 30s", "1m 31s", "-45s", "0s",
+			"10/03/2026 00:00:00 UTC – 23:59:59 UTC", "10/03/2026 06:00:00 UTC – 11/03/2026 05:59:59 UTC",
+		}},
+	}
+	for _, tt := range tests {
+		got := render(tt.format)
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%+v value %d: %q, want %q", tt.format, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+// TestReportLocale checks that the daily report follows locale
+func TestReportLocale(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		locale, latency, reach string
+	}{
+		{"ja", "**平均**: 1,234.5ms", "**成功率**: 100.00%\n**成功回数**: 1,200"},
+		{"de", "**平均**: 1.234,5ms", "**成功率**: 100,00%\n**成功回数**: 1.200"},
+		{"fr", "**平均**: 1\u202f234,5ms", "**成功率**: 100,00%\n**成功回数**: 1\u202f200"},
+	}
+	for _, tt := range tests {
+		pm, clock := newTestMonitor(t, map[string]interface{}{
+			"locale": tt.locale, "ping_interval": "1s", "min_report_coverage": "1m",
+			"compact_report": map[string]interface{}{"detailed": true},
+		}, start)
+		pm.prober = probeFunc(func(string) (float64, error) { return 1234.5, nil })
+		for s := 0; s < 1200; s++ {
+			at := start.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+		}
+		embed := pm.dailyReportEmbed(pm.takePeriod(start.Format(reportDateLayout)))
+		if len(embed.Fields) < 2 || !strings.HasPrefix(embed.Fields[0].Value, tt.latency) || !strings.HasPrefix(embed.Fields[1].Value, tt.reach) {
+			t.Errorf("%s: fields %+v", tt.locale, embed.Fields)
+		}
+	}
+}
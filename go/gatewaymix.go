@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GatewayCounts splits failed samples by the gateway diagnostic taken with
+// them. Unchecked covers samples with no gateway to ping, e.g. before
+// detection succeeded; a gateway that is the target counts as unreachable,
+// since the failed probe was its check.
+type GatewayCounts struct {
+	Reachable   int `json:"reachable"`
+	Unreachable int `json:"unreachable"`
+	Unchecked   int `json:"unchecked"`
+}
+
+// add counts one failed sample with diagnostic s
+func (c *GatewayCounts) add(s gatewayState) {
+	switch s {
+	case gatewayReachable:
+		c.Reachable++
+	case gatewayUnreachable, gatewayIsTarget:
+		c.Unreachable++
+	default:
+		c.Unchecked++
+	}
+}
+
+// merge adds the counts of o
+func (c *GatewayCounts) merge(o GatewayCounts) {
+	c.Reachable += o.Reachable
+	c.Unreachable += o.Unreachable
+	c.Unchecked += o.Unchecked
+}
+
+// total returns the number of failed samples counted
+func (c GatewayCounts) total() int {
+	return c.Reachable + c.Unreachable + c.Unchecked
+}
+
+// lines formats the buckets as "応答あり 12件 (60.0%)", one per line
+func (c GatewayCounts) lines() []string {
+	total := c.total()
+	if total == 0 {
+		return nil
+	}
+	var lines []string
+	for _, b := range []struct {
+		label string
+		n     int
+	}{{"応答あり（回線側の問題）", c.Reachable}, {"応答なし（宅内側の問題）", c.Unreachable}, {"未確認（ゲートウェイ不明）", c.Unchecked}} {
+		lines = append(lines, fmt.Sprintf("%s: %d件 (%.1f%%)", b.label, b.n, float64(b.n)/float64(total)*100))
+	}
+	return lines
+}
+
+// String joins lines with newlines
+func (c GatewayCounts) String() string {
+	return strings.Join(c.lines(), "\n")
+}
+
+// gatewayCounts tallies the gateway diagnostics of the period's failures
+func (p *reportPeriod) gatewayCounts() GatewayCounts {
+	var c GatewayCounts
+	for i := range p.UnreachableTimes {
+		c.add(p.failureGateway(i))
+	}
+	return c
+}
+
+// failureGateway returns the diagnostic of the i-th failure
+func (p *reportPeriod) failureGateway(i int) gatewayState {
+	if i < len(p.FailureGateways) {
+		return p.FailureGateways[i]
+	}
+	return gatewayUnknown
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GotifyConfig configures the Gotify notifier
+type GotifyConfig struct {
+	URL                string         `json:"url"`
+	Token              string         `json:"token" secret:"true"`
+	Priorities         map[string]int `json:"priorities"`
+	InsecureSkipVerify bool           `json:"insecure_skip_verify"`
+	CAFile             string         `json:"ca_file"`
+	// NotifierRouting holds min_severity and events (see routing.go)
+	NotifierRouting
+}
+
+// defaultGotifyPriorities maps severities onto Gotify's 0-10 scale
+var defaultGotifyPriorities = map[string]int{
+	"info":     2,
+	"warn":     5,
+	"critical": 8,
+}
+
+// gotifyMessage is the body of POST /message
+type gotifyMessage struct {
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority int                    `json:"priority"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+}
+
+// gotifyNotifier posts events to a self-hosted Gotify server
+type gotifyNotifier struct {
+	cfg    GotifyConfig
+	client *http.Client
+}
+
+// newGotifyNotifier builds the notifier with the configured TLS options
+func newGotifyNotifier(cfg GotifyConfig) (*gotifyNotifier, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("gotify.ca_file を読み込めません: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("gotify.ca_file に証明書が含まれていません: %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &gotifyNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}, nil
+}
+
+// Name returns the notifier name used in logs
+func (n *gotifyNotifier) Name() string {
+	return "Gotify"
+}
+
+// routing returns min_severity and events
+func (n *gotifyNotifier) routing() NotifierRouting {
+	return n.cfg.NotifierRouting
+}
+
+// priority maps a severity onto a Gotify priority
+func (n *gotifyNotifier) priority(s Severity) int {
+	if p, ok := n.cfg.Priorities[s.String()]; ok {
+		return p
+	}
+	return defaultGotifyPriorities[s.String()]
+}
+
+// buildMessage converts an event; markdown rendering is requested
+// since messages use Discord-style **bold** and structured stats go into
+// extras for clients that render them
+func (n *gotifyNotifier) buildMessage(ev Event) gotifyMessage {
+	extras := map[string]interface{}{
+		"client::display": map[string]string{"contentType": "text/markdown"},
+	}
+	data := map[string]interface{}{
+		"kind":      string(ev.Kind),
+		"severity":  ev.Severity.String(),
+		"time":      ev.Time.Format(time.RFC3339),
+		"simulated": ev.Simulated,
+	}
+	for k, v := range ev.Data {
+		data[k] = v
+	}
+	extras["ping-check::event"] = data
+
+	message := ev.Message
+	for _, f := range ev.Fields {
+		message += fmt.Sprintf("\n\n**%s**\n%s", f.Name, f.Value)
+	}
+
+	return gotifyMessage{
+		Title:    ev.DisplayTitle(),
+		Message:  message,
+		Priority: n.priority(ev.Severity),
+		Extras:   extras,
+	}
+}
+
+// Notify posts ev, retrying transient failures
+func (n *gotifyNotifier) Notify(ev Event) error {
+	body, err := json.Marshal(n.buildMessage(ev))
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(n.cfg.URL, "/") + "/message"
+
+	return retryTransient(func() error {
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", n.cfg.Token)
+		return doNotifierRequest(n.client, req)
+	})
+}
+
+// transientError marks a failure worth retrying; retryAfter is the wait
+// requested by the server, if any
+type transientError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e transientError) Error() string { return e.err.Error() }
+func (e transientError) Unwrap() error { return e.err }
+
+// notifierRetryDelays are the waits between delivery attempts
+var notifierRetryDelays = []time.Duration{1 * time.Second, 3 * time.Second}
+
+// maxRetryAfter caps server-requested waits so a notifier cannot stall the
+// dispatcher for long
+const maxRetryAfter = 30 * time.Second
+
+// retryTransient runs fn until it succeeds, fails permanently, or the
+// retries are exhausted
+func retryTransient(fn func() error) error {
+	err := fn()
+	for _, delay := range notifierRetryDelays {
+		te, ok := err.(transientError)
+		if !ok {
+			return err
+		}
+		if te.retryAfter > delay {
+			delay = min(te.retryAfter, maxRetryAfter)
+		}
+		time.Sleep(delay)
+		err = fn()
+	}
+	return err
+}
+
+// doNotifierRequest sends req and classifies the outcome: network errors,
+// 429 and 5xx are transient, other non-2xx statuses are permanent
+func doNotifierRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return transientError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("HTTP %d - %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return transientError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return err
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
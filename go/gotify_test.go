@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gotifyServer records the requests to POST /message and answers each
+// with the next status, then 200
+type gotifyServer struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []gotifyRequest
+}
+
+// gotifyRequest is one request received by gotifyServer
+type gotifyRequest struct {
+	method, path, key, contentType string
+	body                           []byte
+}
+
+func (s *gotifyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, gotifyRequest{r.Method, r.URL.Path, r.Header.Get("X-Gotify-Key"), r.Header.Get("Content-Type"), body})
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(`{"id": 1}`))
+}
+
+// received returns a copy of the requests so far
+func (s *gotifyServer) received() []gotifyRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]gotifyRequest(nil), s.requests...)
+}
+
+func TestGotifyNotify(t *testing.T) {
+	fastRetries(t)
+	ev := Event{
+		Kind:     EventOutage,
+		Severity: SeverityCritical,
+		Time:     time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC),
+		Title:    "🚨 到達不能",
+		Message:  "**対象**: 8.8.8.8",
+		Fields:   []EmbedField{{Name: "原因", Value: "タイムアウト"}},
+		Data:     map[string]interface{}{"target": "8.8.8.8"},
+	}
+	tests := []struct {
+		name       string
+		priorities map[string]int
+		severity   Severity
+		statuses   []int
+		wantErr    bool
+		// wantRequests is the number of attempts
+		wantRequests int
+		wantPriority int
+	}{
+		{"default priority", nil, SeverityCritical, nil, false, 1, 8},
+		{"info", nil, SeverityInfo, nil, false, 1, 2},
+		{"configured priority", map[string]int{"warn": 7}, SeverityWarn, nil, false, 1, 7},
+		{"unconfigured severity keeps the default", map[string]int{"warn": 7}, SeverityCritical, nil, false, 1, 8},
+		{"retry on 5xx", nil, SeverityCritical, []int{http.StatusBadGateway}, false, 2, 8},
+		{"retry on 429", nil, SeverityCritical, []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}, false, 3, 8},
+		{"5xx until the retries run out", nil, SeverityCritical, []int{500, 500, 500, 500}, true, 3, 8},
+		{"no retry on 4xx", nil, SeverityCritical, []int{http.StatusUnauthorized}, true, 1, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &gotifyServer{statuses: tt.statuses}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+			n, err := newGotifyNotifier(GotifyConfig{URL: ts.URL + "/", Token: "s3cret", Priorities: tt.priorities})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ev := ev
+			ev.Severity = tt.severity
+			if err := n.Notify(ev); (err != nil) != tt.wantErr {
+				t.Fatalf("Notify: %v, want error %v", err, tt.wantErr)
+			}
+			requests := server.received()
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("%d requests, want %d", len(requests), tt.wantRequests)
+			}
+			for _, r := range requests {
+				if r.method != http.MethodPost || r.path != "/message" || r.key != "s3cret" || r.contentType != "application/json" {
+					t.Errorf("request %s %s key %q type %q", r.method, r.path, r.key, r.contentType)
+				}
+				if string(r.body) != string(requests[0].body) {
+					t.Errorf("retry sent %s, want %s", r.body, requests[0].body)
+				}
+				if strings.Contains(r.path+string(r.body), "s3cret") {
+					t.Error("token outside the header")
+				}
+			}
+
+			var got struct {
+				Title    string `json:"title"`
+				Message  string `json:"message"`
+				Priority int    `json:"priority"`
+				Extras   struct {
+					Display struct {
+						ContentType string `json:"contentType"`
+					} `json:"client::display"`
+					Event map[string]interface{} `json:"ping-check::event"`
+				} `json:"extras"`
+			}
+			if err := json.Unmarshal(requests[0].body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != "🚨 到達不能" || got.Message != "**対象**: 8.8.8.8\n\n**原因**\nタイムアウト" || got.Priority != tt.wantPriority {
+				t.Errorf("body %+v, want priority %d", got, tt.wantPriority)
+			}
+			if got.Extras.Display.ContentType != "text/markdown" {
+				t.Errorf("content type %q", got.Extras.Display.ContentType)
+			}
+			want := map[string]interface{}{"kind": "outage", "severity": tt.severity.String(), "time": "2026-03-10T12:00:00Z", "simulated": false, "target": "8.8.8.8"}
+			if len(got.Extras.Event) != len(want) {
+				t.Errorf("event extras %v, want %v", got.Extras.Event, want)
+			}
+			for k, v := range want {
+				if got.Extras.Event[k] != v {
+					t.Errorf("event extras %s = %v, want %v", k, got.Extras.Event[k], v)
+				}
+			}
+		})
+	}
+}
+
+// TestGotifyTLS checks a self-signed server is refused unless ca_file names
+// its certificate or insecure_skip_verify is set
+func TestGotifyTLS(t *testing.T) {
+	fastRetries(t)
+	ts := httptest.NewTLSServer(&gotifyServer{})
+	defer ts.Close()
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ev := Event{Kind: EventOutage, Severity: SeverityCritical, Title: "t"}
+	tests := []struct {
+		cfg     GotifyConfig
+		wantErr bool
+	}{
+		{GotifyConfig{}, true},
+		{GotifyConfig{CAFile: ca}, false},
+		{GotifyConfig{InsecureSkipVerify: true}, false},
+	}
+	for _, tt := range tests {
+		tt.cfg.URL = ts.URL
+		n, err := newGotifyNotifier(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Notify(ev); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: %v, want error %v", tt.cfg, err, tt.wantErr)
+		}
+	}
+	for _, path := range []string{filepath.Join(dir, "missing.pem"), garbage} {
+		if _, err := newGotifyNotifier(GotifyConfig{URL: ts.URL, CAFile: path}); err == nil {
+			t.Errorf("ca_file %s accepted", path)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Health score weights: points lost per percent of loss, per confirmed
+// outage, and per 100% of p95 above the baseline
+const (
+	healthLossWeight    = 10
+	healthOutageWeight  = 5
+	healthLatencyWeight = 20
+	// healthLatencyTolerance is the p95/baseline ratio below which latency
+	// costs nothing, so day-to-day jitter does not count as a problem
+	healthLatencyTolerance = 1.5
+)
+
+// healthInput is what a health score is computed from
+type healthInput struct {
+	LossPercent float64
+	P95         float64
+	// BaselineP95 is the usual p95 of the target; 0 when unknown
+	BaselineP95 float64
+	Outages     int
+}
+
+// healthScore rates a target from 0 (worst) to 100 (no problems). The
+// result depends only on the input, so ordering by it is stable between
+// runs.
+func healthScore(in healthInput) float64 {
+	score := 100.0
+	score -= in.LossPercent * healthLossWeight
+	score -= float64(in.Outages) * healthOutageWeight
+	if in.BaselineP95 > 0 && in.P95 > in.BaselineP95*healthLatencyTolerance {
+		ratio := in.P95 / in.BaselineP95
+		score -= min((ratio-1)*healthLatencyWeight, 40)
+	}
+	return math.Round(max(score, 0))
+}
+
+// healthy reports whether a score counts as fully healthy
+func healthy(score float64) bool {
+	return score >= 100
+}
+
+// medianP95 returns the median of p95 values, or 0 when there are none
+func medianP95(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return percentile(sorted, 50)
+}
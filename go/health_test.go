@@ -0,0 +1,116 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHealthScore(t *testing.T) {
+	tests := []struct {
+		name string
+		in   healthInput
+		want float64
+	}{
+		{"no problems", healthInput{}, 100},
+		{"no problems without a baseline", healthInput{P95: 900}, 100},
+		{"0.5% loss", healthInput{LossPercent: 0.5}, 95},
+		{"loss rounds", healthInput{LossPercent: 0.123}, 99},
+		{"two outages", healthInput{Outages: 2}, 90},
+		{"p95 within the tolerance", healthInput{P95: 140, BaselineP95: 100}, 100},
+		{"p95 at the tolerance", healthInput{P95: 150, BaselineP95: 100}, 100},
+		{"p95 twice the baseline", healthInput{P95: 200, BaselineP95: 100}, 80},
+		{"latency penalty capped", healthInput{P95: 1000, BaselineP95: 100}, 60},
+		{"combined", healthInput{LossPercent: 3, Outages: 4, P95: 300, BaselineP95: 100}, 10},
+		{"floored at 0", healthInput{LossPercent: 20, Outages: 1}, 0},
+	}
+	for _, tt := range tests {
+		if got := healthScore(tt.in); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+		if got := healthScore(tt.in); got != tt.want {
+			t.Errorf("%s: second run %v", tt.name, got)
+		}
+	}
+}
+
+// healthSnapshot is a day of 1000 probes with the failures and p95
+func healthSnapshot(failures, gateway int, p95 float64, sufficient bool) SiteSnapshot {
+	s := SiteSnapshot{GatewayFailures: gateway, Coverage: &ReportCoverage{Sufficient: sufficient}}
+	s.Stats.Total, s.Stats.Failure, s.Stats.Success = 1000, failures, 1000-failures
+	s.Stats.SuccessRate = float64(s.Stats.Success) / 10
+	s.Stats.Latency.P95, s.Stats.Latency.Avg = p95, p95/2
+	return s
+}
+
+// TestSiteScores scores constructed snapshots against the baseline of
+// the previous days, then orders and collapses them
+func TestSiteScores(t *testing.T) {
+	c := &collector{days: map[string]map[string]SiteSnapshot{
+		"2026-03-07": {"slow": healthSnapshot(0, 0, 10, true)},
+		"2026-03-08": {"slow": healthSnapshot(0, 0, 12, true), "home": healthSnapshot(0, 0, 20, true)},
+		"2026-03-09": {"slow": healthSnapshot(0, 0, 14, true)},
+		// Days with too little data and later days are not the baseline
+		"2026-03-06": {"slow": healthSnapshot(0, 0, 1000, false)},
+		"2026-03-11": {"slow": healthSnapshot(0, 0, 1, true)},
+	}}
+	snaps := map[string]SiteSnapshot{
+		"home":   healthSnapshot(0, 0, 20, true),
+		"office": healthSnapshot(0, 0, 30, true),
+		// 1% line loss once the gateway's 20 failures are left out
+		"lossy": healthSnapshot(30, 20, 20, true),
+		// p95 3x the median of 10, 12 and 14
+		"slow": healthSnapshot(0, 0, 36, true),
+	}
+	names := []string{"home", "lossy", "missing", "office", "slow"}
+	rows := make([]*SiteSnapshot, len(names))
+	for i, name := range names {
+		if s, ok := snaps[name]; ok {
+			rows[i] = &s
+		}
+	}
+	scores := c.siteScores("2026-03-10", names, rows)
+	if want := []float64{100, 90, 0, 100, 60}; !slices.Equal(scores, want) {
+		t.Fatalf("scores %v, want %v", scores, want)
+	}
+
+	// Worst first, by name among equal scores, whatever the input order
+	wantOrder := []string{"missing", "slow", "lossy", "home", "office"}
+	for _, perm := range [][]int{{0, 1, 2, 3, 4}, {4, 3, 2, 1, 0}, {3, 0, 4, 2, 1}} {
+		n, r, s := make([]string, len(perm)), make([]*SiteSnapshot, len(perm)), make([]float64, len(perm))
+		for i, j := range perm {
+			n[i], r[i], s[i] = names[j], rows[j], scores[j]
+		}
+		orderByHealth(n, r, s)
+		if !slices.Equal(n, wantOrder) || !slices.Equal(s, []float64{0, 60, 90, 100, 100}) {
+			t.Errorf("from %v: %v %v", perm, n, s)
+		}
+		for i := range n {
+			if want, ok := snaps[n[i]]; ok != (r[i] != nil) || ok && r[i].Stats != want.Stats {
+				t.Errorf("from %v: row of %s moved apart from its name", perm, n[i])
+			}
+		}
+	}
+
+	orderByHealth(names, rows, scores)
+	table := formatSiteTable(names, rows, scores, false)
+	for _, want := range []string{"missing", missingSiteMarker, "slow", "lossy", "(+GW 20)", "他 2 サイト: 異常なし"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("table lacks %q:\n%s", want, table)
+		}
+	}
+	if strings.Contains(table, "home") || strings.Contains(table, "office") {
+		t.Errorf("healthy sites listed:\n%s", table)
+	}
+	if strings.Index(table, "slow") > strings.Index(table, "lossy") {
+		t.Errorf("table not worst-first:\n%s", table)
+	}
+	verbose := formatSiteTable(names, rows, scores, true)
+	if !strings.Contains(verbose, "home") || !strings.Contains(verbose, "office") || strings.Contains(verbose, "異常なし") {
+		t.Errorf("verbose table:\n%s", verbose)
+	}
+	healthyRows := []*SiteSnapshot{rows[3], rows[4]}
+	if table := formatSiteTable(names[3:], healthyRows, scores[3:], false); !strings.Contains(table, "全 2 サイト: 異常なし") {
+		t.Errorf("all healthy:\n%s", table)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a virtual clock the tests move by hand
+type testClock struct {
+	mutex sync.Mutex
+	t     time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.t
+}
+
+func (c *testClock) set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.t = t
+}
+
+// probeFunc adapts a function to the Prober interface
+type probeFunc func(host string) (float64, error)
+
+func (f probeFunc) Probe(host string) (float64, error) { return f(host) }
+
+// newTestMonitor creates a monitor from the config keys cfg on a virtual
+// clock starting at start. The target answers in 10ms unless the test
+// replaces pm.prober.
+func newTestMonitor(t testing.TB, cfg map[string]interface{}, start time.Time) (*PingMonitor, *testClock) {
+	t.Helper()
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+	if _, ok := cfg["warmup"]; !ok {
+		cfg["warmup"] = "0s"
+	}
+	cfg["gateway"] = scenarioGateway
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewPingMonitor(path, ConfigOverrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{t: start}
+	pm.prober = probeFunc(func(string) (float64, error) { return 10, nil })
+	pm.now = clock.now
+	pm.samples = newPeriodSamples(pm.pingInterval)
+	pm.iface = nil
+	pm.localIP = "192.0.2.2"
+	pm.reports = newReportCoordinator(pm, start)
+	pm.periodStart = start
+	pm.outages = newOutageTracker(pm.config.FailureThreshold, pm.config.RecoveryThreshold, start)
+	pm.warmupUntil = start.Add(pm.config.Warmup.Duration())
+	t.Cleanup(func() {
+		pm.dispatcher.close()
+		pm.audit.close()
+		pm.store.Close()
+	})
+	return pm, clock
+}
+
+// setLocal makes name the local time zone for the test
+func setLocal(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s: %v", name, err)
+	}
+	saved := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = saved })
+	return loc
+}
+
+// fakeNotifier records the events it delivers. fail, when set, decides
+// which deliveries fail.
+type fakeNotifier struct {
+	name  string
+	fail  func(ev Event) error
+	mutex sync.Mutex
+	got   []Event
+}
+
+func (n *fakeNotifier) Name() string { return n.name }
+
+func (n *fakeNotifier) Notify(ev Event) error {
+	if n.fail != nil {
+		if err := n.fail(ev); err != nil {
+			return err
+		}
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.got = append(n.got, ev)
+	return nil
+}
+
+// ids returns the IDs delivered, with an r suffix for replayed events
+func (n *fakeNotifier) ids() []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	var out []string
+	for _, ev := range n.got {
+		id := strconv.FormatUint(ev.ID, 10)
+		if ev.Replayed {
+			id += "r"
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// events returns a copy of the events delivered
+func (n *fakeNotifier) events() []Event {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return append([]Event(nil), n.got...)
+}
+
+// notifyTo makes n the only notifier of pm
+func notifyTo(pm *PingMonitor, n Notifier) {
+	pm.dispatcher.close()
+	pm.dispatcher = newDispatcher([]Notifier{n}, nil, nil, textStyle{}, pm.now)
+}
+
+// fastRetries shortens the notifier retry delays for the test
+func fastRetries(t *testing.T) {
+	saved := notifierRetryDelays
+	notifierRetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+	t.Cleanup(func() { notifierRetryDelays = saved })
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HourlyAggregate summarizes the probes of one hour of a day
+type HourlyAggregate struct {
+	Hour     int     `json:"hour"`
+	Count    int     `json:"count"`
+	Failures int     `json:"failures"`
+	Avg      float64 `json:"avg_ms"`
+	P95      float64 `json:"p95_ms"`
+	// Average interface throughput over samples that had counters
+	RxBps        float64 `json:"avg_rx_bps,omitempty"`
+	TxBps        float64 `json:"avg_tx_bps,omitempty"`
+	IfaceSamples int     `json:"iface_samples,omitempty"`
+	// Gateway splits the failures by the gateway diagnostic
+	Gateway *GatewayCounts `json:"gateway,omitempty"`
+	// WorstMinuteLoss is the highest loss of a one-minute window starting
+	// in the hour, at WorstMinuteAt (see worstminute.go)
+	WorstMinuteLoss float64    `json:"worst_minute_loss_percent,omitempty"`
+	WorstMinuteAt   *time.Time `json:"worst_minute_at,omitempty"`
+	// Connection is the type of link in effect for most of the hour
+	Connection connType `json:"connection,omitempty"`
+}
+
+// LossRate returns the failure percentage of the hour
+func (h HourlyAggregate) LossRate() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Failures) / float64(h.Count) * 100
+}
+
+// DailyHistory is the persisted per-day record under <state_dir>/history
+type DailyHistory struct {
+	Date  string            `json:"date"`
+	Hours []HourlyAggregate `json:"hours"`
+	// FirstFailure and LastFailure span the day's failed samples across
+	// restarts; absent on a clean day
+	FirstFailure *time.Time `json:"first_failure,omitempty"`
+	LastFailure  *time.Time `json:"last_failure,omitempty"`
+	// Report is the daily report as sent to Discord, kept for resend, and
+	// Delivery how its last send went (see delivery.go)
+	Report   *DiscordMessage `json:"report,omitempty"`
+	Delivery *ReportDelivery `json:"delivery,omitempty"`
+	// Bufferbloat are the day's runs of the bufferbloat test
+	Bufferbloat []BufferbloatResult `json:"bufferbloat,omitempty"`
+}
+
+// historyStore persists daily histories as one JSON file per day
+type historyStore struct {
+	dir string
+	// mutex orders the read-modify-write of a day between the report
+	// rollover and a resend
+	mutex sync.Mutex
+}
+
+// newHistoryStore returns a store rooted at <stateDir>/history
+func newHistoryStore(stateDir string) *historyStore {
+	return &historyStore{dir: filepath.Join(stateDir, "history")}
+}
+
+// hourlyAggregates groups the samples of a period by hour of day
+func hourlyAggregates(p *reportPeriod) []HourlyAggregate {
+	failures := make(map[int]int)
+	gateways := make(map[int]*GatewayCounts)
+	for i, t := range p.UnreachableTimes {
+		failures[t.Hour()]++
+		if gateways[t.Hour()] == nil {
+			gateways[t.Hour()] = &GatewayCounts{}
+		}
+		gateways[t.Hour()].add(p.failureGateway(i))
+	}
+
+	worst := make(map[int]worstMinute)
+	for _, w := range p.worstMinutes() {
+		worst[w.Hour] = w
+	}
+
+	var hours []HourlyAggregate
+	for h := 0; h < 24; h++ {
+		samples := &p.Samples.hours[h]
+		if samples.latency.count == 0 && failures[h] == 0 {
+			continue
+		}
+		stats := samples.latency.stats()
+		agg := HourlyAggregate{
+			Hour:         h,
+			Count:        samples.latency.count + failures[h],
+			Failures:     failures[h],
+			Avg:          stats.Avg,
+			P95:          stats.P95,
+			IfaceSamples: samples.ifaceSamples,
+			Gateway:      gateways[h],
+			Connection:   p.hourConnection(h),
+		}
+		if n := samples.ifaceSamples; n > 0 {
+			agg.RxBps = samples.rx / float64(n)
+			agg.TxBps = samples.tx / float64(n)
+		}
+		if w, ok := worst[h]; ok && !w.LossAt.IsZero() {
+			agg.WorstMinuteLoss, agg.WorstMinuteAt = w.Loss, &w.LossAt
+		}
+		hours = append(hours, agg)
+	}
+	return hours
+}
+
+// saveDay merges the hourly aggregates of p into the stored day. A restart
+// within a day produces several partial periods; for an hour present in
+// both, counts are summed, the average is count-weighted, and the p95 keeps
+// the larger value since percentiles cannot be merged exactly.
+func (hs *historyStore) saveDay(p *reportPeriod) error {
+	if p.empty() {
+		return nil
+	}
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	day, err := hs.loadDay(p.Date)
+	if err != nil {
+		day = &DailyHistory{Date: p.Date}
+	}
+
+	byHour := make(map[int]HourlyAggregate)
+	for _, h := range day.Hours {
+		byHour[h.Hour] = h
+	}
+	for _, h := range hourlyAggregates(p) {
+		old, ok := byHour[h.Hour]
+		if ok {
+			successOld, successNew := old.Count-old.Failures, h.Count-h.Failures
+			if successOld+successNew > 0 {
+				h.Avg = (old.Avg*float64(successOld) + h.Avg*float64(successNew)) / float64(successOld+successNew)
+			}
+			if old.P95 > h.P95 {
+				h.P95 = old.P95
+			}
+			if n := old.IfaceSamples + h.IfaceSamples; n > 0 {
+				h.RxBps = (old.RxBps*float64(old.IfaceSamples) + h.RxBps*float64(h.IfaceSamples)) / float64(n)
+				h.TxBps = (old.TxBps*float64(old.IfaceSamples) + h.TxBps*float64(h.IfaceSamples)) / float64(n)
+				h.IfaceSamples = n
+			}
+			if old.WorstMinuteLoss > h.WorstMinuteLoss {
+				h.WorstMinuteLoss, h.WorstMinuteAt = old.WorstMinuteLoss, old.WorstMinuteAt
+			}
+			if h.Connection == connUnknown {
+				h.Connection = old.Connection
+			}
+			h.Count += old.Count
+			h.Failures += old.Failures
+			if old.Gateway != nil {
+				if h.Gateway == nil {
+					h.Gateway = &GatewayCounts{}
+				}
+				h.Gateway.merge(*old.Gateway)
+			}
+		}
+		byHour[h.Hour] = h
+	}
+
+	if first, last := p.failureWindow(); first != nil {
+		if day.FirstFailure == nil || first.Before(*day.FirstFailure) {
+			day.FirstFailure = first
+		}
+		if day.LastFailure == nil || last.After(*day.LastFailure) {
+			day.LastFailure = last
+		}
+	}
+
+	day.Bufferbloat = append(day.Bufferbloat, p.Bufferbloat...)
+
+	day.Hours = day.Hours[:0]
+	for _, h := range byHour {
+		day.Hours = append(day.Hours, h)
+	}
+	sort.Slice(day.Hours, func(i, j int) bool { return day.Hours[i].Hour < day.Hours[j].Hour })
+
+	return writeStateFile(hs.path(p.Date), "history", day)
+}
+
+// loadDay reads the stored history of date (YYYY-MM-DD)
+func (hs *historyStore) loadDay(date string) (*DailyHistory, error) {
+	var day DailyHistory
+	if err := readStateFile(hs.path(date), "history", &day); err != nil {
+		return nil, err
+	}
+	return &day, nil
+}
+
+// loadMonth returns the stored histories of every day in month, with nil
+// entries for days without data
+func (hs *historyStore) loadMonth(month time.Time) []*DailyHistory {
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	var days []*DailyHistory
+	for d := first; d.Month() == first.Month(); d = d.AddDate(0, 0, 1) {
+		day, err := hs.loadDay(d.Format(reportDateLayout))
+		if err != nil {
+			day = nil
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// path returns the file of date
+func (hs *historyStore) path(date string) string {
+	return filepath.Join(hs.dir, date+".json")
+}
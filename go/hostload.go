@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// memPressureHighPercent is the memory PSI "some avg10" above which the
+// host counts as under memory pressure
+const memPressureHighPercent = 10
+
+// memAvailableLowPercent is the MemAvailable share below which the host
+// counts as under memory pressure when PSI is unavailable
+const memAvailableLowPercent = 10
+
+// hostLoad is a cheap snapshot of the monitoring host's own load
+type hostLoad struct {
+	Load1 float64
+	CPUs  int
+	// MemAvailablePercent is MemAvailable/MemTotal
+	MemAvailablePercent float64
+	// MemPressure is the memory PSI "some avg10", or -1 when unavailable
+	MemPressure float64
+}
+
+// String formats the load for alerts and console output
+func (l hostLoad) String() string {
+	s := fmt.Sprintf("ロードアベレージ %.2f (%dCPU) / 空きメモリ %.0f%%", l.Load1, l.CPUs, l.MemAvailablePercent)
+	if l.MemPressure >= 0 {
+		s += fmt.Sprintf(" / メモリ圧迫 %.1f%%", l.MemPressure)
+	}
+	return s
+}
+
+// high reports whether the host is busy enough to inflate measured RTTs:
+// the 1-minute load per CPU reaches loadPerCPU, or memory is under pressure
+func (l hostLoad) high(loadPerCPU float64) bool {
+	if l.CPUs > 0 && l.Load1/float64(l.CPUs) >= loadPerCPU {
+		return true
+	}
+	if l.MemPressure >= 0 {
+		return l.MemPressure >= memPressureHighPercent
+	}
+	return l.MemAvailablePercent > 0 && l.MemAvailablePercent < memAvailableLowPercent
+}
+
+// hostLoadSampler reads load from procfs. A nil *hostLoadSampler is valid
+// and never produces samples, which is what newHostLoadSampler returns
+// outside Linux.
+type hostLoadSampler struct{}
+
+// newHostLoadSampler returns a sampler, or nil when procfs is unavailable
+func newHostLoadSampler() *hostLoadSampler {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	if _, err := os.Stat("/proc/loadavg"); err != nil {
+		return nil
+	}
+	return &hostLoadSampler{}
+}
+
+// sample reads the current host load
+func (s *hostLoadSampler) sample() (hostLoad, bool) {
+	if s == nil {
+		return hostLoad{}, false
+	}
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return hostLoad{}, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return hostLoad{}, false
+	}
+	load1, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return hostLoad{}, false
+	}
+	l := hostLoad{Load1: load1, CPUs: runtime.NumCPU(), MemPressure: readMemPressure()}
+	l.MemAvailablePercent = readMemAvailablePercent()
+	return l, true
+}
+
+// readMemPressure returns the memory PSI "some avg10", or -1
+func readMemPressure() float64 {
+	data, err := os.ReadFile("/proc/pressure/memory")
+	if err != nil {
+		return -1
+	}
+	for _, field := range strings.Fields(string(data)) {
+		if v, ok := strings.CutPrefix(field, "avg10="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+			break
+		}
+	}
+	return -1
+}
+
+// readMemAvailablePercent returns MemAvailable/MemTotal from /proc/meminfo
+func readMemAvailablePercent() float64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var total, available float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, _ := strconv.ParseFloat(fields[1], 64)
+		switch fields[0] {
+		case "MemTotal:":
+			total = v
+		case "MemAvailable:":
+			available = v
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return available / total * 100
+}
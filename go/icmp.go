@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Values of ping_backend
+const (
+	// pingBackendAuto uses native ICMP where a socket can be opened, except
+	// on Windows, whose firewall may keep echo replies from raw sockets
+	pingBackendAuto   = "auto"
+	pingBackendNative = "native"
+	pingBackendExec   = "exec"
+)
+
+var pingBackends = []string{pingBackendAuto, pingBackendNative, pingBackendExec}
+
+// icmpMaxMessage is the largest message read from an ICMP socket
+const icmpMaxMessage = 1500
+
+var (
+	// errNoICMPSocket is returned by ping_backend native for an address
+	// family without a usable ICMP socket
+	errNoICMPSocket = errors.New("no ICMP socket available")
+	// errNoEchoReply is returned when no reply came within the timeout
+	errNoEchoReply = errors.New("no echo reply")
+)
+
+// icmpMode is the kind of socket native pings are sent with
+type icmpMode int
+
+const (
+	icmpNone icmpMode = iota
+	// icmpRaw sees every ICMP message of the host, including errors sent by
+	// routers (root or CAP_NET_RAW, administrator on Windows)
+	icmpRaw
+	// icmpDgram is the unprivileged ping socket of Linux and macOS. Linux
+	// replaces the identifier with the socket's own and passes only the
+	// replies to it.
+	icmpDgram
+)
+
+// String names the mode for the startup line
+func (m icmpMode) String() string {
+	switch m {
+	case icmpRaw:
+		return "raw"
+	case icmpDgram:
+		return "dgram"
+	}
+	return "なし"
+}
+
+// icmpID is the identifier of the echo requests; with raw sockets it and
+// icmpToken tell our replies from those of other pings on the host
+var icmpID = uint16(os.Getpid())
+
+// icmpToken is the payload of the echo requests
+var icmpToken = func() []byte {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return append([]byte("pingmon"), b...)
+}()
+
+// icmpSeq numbers the echo requests of the process, so concurrent probes
+// never wait for the same reply
+var icmpSeq atomic.Uint32
+
+// icmpModes are the socket kinds found usable, detected once per process
+var icmpModes struct {
+	once   sync.Once
+	v4, v6 icmpMode
+}
+
+// detectICMPModes returns the socket kind usable for IPv4 and IPv6
+func detectICMPModes() (v4, v6 icmpMode) {
+	icmpModes.once.Do(func() {
+		icmpModes.v4 = usableICMPMode(false)
+		icmpModes.v6 = usableICMPMode(true)
+	})
+	return icmpModes.v4, icmpModes.v6
+}
+
+// usableICMPMode opens a raw socket, then a ping socket, of the family
+func usableICMPMode(v6 bool) icmpMode {
+	for _, mode := range []icmpMode{icmpRaw, icmpDgram} {
+		if mode == icmpDgram && runtime.GOOS == "windows" {
+			break
+		}
+		if c, err := listenICMP(v6, mode); err == nil {
+			c.Close()
+			return mode
+		}
+	}
+	return icmpNone
+}
+
+// listenICMP opens an ICMP socket of the family and kind. A ping socket
+// is made by hand, since net only opens raw ICMP sockets.
+func listenICMP(v6 bool, mode icmpMode) (net.PacketConn, error) {
+	if mode == icmpRaw {
+		if v6 {
+			return net.ListenPacket("ip6:ipv6-icmp", "::")
+		}
+		return net.ListenPacket("ip4:icmp", "0.0.0.0")
+	}
+	family, proto := syscall.AF_INET, 1 // IPPROTO_ICMP
+	if v6 {
+		family, proto = syscall.AF_INET6, 58 // IPPROTO_ICMPV6
+	}
+	// Like net, keep commands started meanwhile from inheriting the socket
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// marshalEcho builds an echo request. The kernel fills in the ICMPv6
+// checksum, which covers the addresses of the IPv6 header.
+func marshalEcho(v6 bool, id, seq uint16, payload []byte) []byte {
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if v6 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	m := icmp.Message{Type: typ, Body: &icmp.Echo{ID: int(id), Seq: int(seq), Data: payload}}
+	b, _ := m.Marshal(nil)
+	return b
+}
+
+// icmpMessage is a received message reduced to what matching a probe
+// needs
+type icmpMessage struct {
+	code int
+	// timeExceeded tells a time exceeded error from an unreachable one
+	timeExceeded bool
+	// id, seq and payload are those of an echo reply, or of the echo
+	// request an error quotes, whose payload is not kept
+	id, seq uint16
+	payload []byte
+	// quotedDst is the destination of the packet an error quotes
+	quotedDst net.IP
+}
+
+// parseICMP reads an echo reply or an error quoting an echo request;
+// false for a message too short or of another kind
+func parseICMP(b []byte, v6 bool) (icmpMessage, bool) {
+	proto := ipv4.ICMPTypeEcho.Protocol()
+	if v6 {
+		proto = ipv6.ICMPTypeEchoRequest.Protocol()
+	}
+	msg, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return icmpMessage{}, false
+	}
+	m := icmpMessage{code: msg.Code}
+	var quoted []byte
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		if msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply {
+			return icmpMessage{}, false
+		}
+		m.id, m.seq, m.payload = uint16(body.ID), uint16(body.Seq), body.Data
+		return m, true
+	case *icmp.DstUnreach:
+		quoted = body.Data
+	case *icmp.TimeExceeded:
+		m.timeExceeded = true
+		quoted = body.Data
+	default:
+		return icmpMessage{}, false
+	}
+
+	// The error quotes the IP header of our request and its first 8 bytes
+	var request []byte
+	if v6 {
+		// Extension headers are not followed; pings carry none
+		h, err := ipv6.ParseHeader(quoted)
+		if err != nil || h.NextHeader != proto || len(quoted) < ipv6.HeaderLen+8 {
+			return icmpMessage{}, false
+		}
+		m.quotedDst, request = h.Dst, quoted[ipv6.HeaderLen:]
+	} else {
+		h, err := ipv4.ParseHeader(quoted)
+		if err != nil || h.Protocol != proto || len(quoted) < h.Len+8 {
+			return icmpMessage{}, false
+		}
+		m.quotedDst, request = h.Dst, quoted[h.Len:]
+	}
+	req, err := icmp.ParseMessage(proto, request[:8])
+	if err != nil || (req.Type != ipv4.ICMPTypeEcho && req.Type != ipv6.ICMPTypeEchoRequest) {
+		return icmpMessage{}, false
+	}
+	echo, ok := req.Body.(*icmp.Echo)
+	if !ok {
+		return icmpMessage{}, false
+	}
+	m.quotedDst = slices.Clone(m.quotedDst)
+	m.id, m.seq = uint16(echo.ID), uint16(echo.Seq)
+	return m, true
+}
+
+// echoAnswer is what a received message means to a probe
+type echoAnswer int
+
+const (
+	// answerNone: the message is for someone else, or a duplicate or stray
+	// reply from another host
+	answerNone echoAnswer = iota
+	answerReply
+	answerUnreachable
+	answerTimeExceeded
+)
+
+// echoQuery is a sent echo request that received messages are matched to
+type echoQuery struct {
+	dst     net.IP
+	id, seq uint16
+	// checkID is false for Linux ping sockets, which rewrite the identifier
+	checkID bool
+}
+
+// answer tells what m, received from from, means to the request q. A reply
+// must come from the destination and echo our payload; an error may come
+// from any router but must quote our request.
+func (q echoQuery) answer(m icmpMessage, from net.IP) echoAnswer {
+	if m.seq != q.seq || q.checkID && m.id != q.id {
+		return answerNone
+	}
+	if m.quotedDst == nil {
+		if !from.Equal(q.dst) || !bytes.Equal(m.payload, icmpToken) {
+			return answerNone
+		}
+		return answerReply
+	}
+	if !m.quotedDst.Equal(q.dst) {
+		return answerNone
+	}
+	if m.timeExceeded {
+		return answerTimeExceeded
+	}
+	return answerUnreachable
+}
+
+// stripIPv4Header removes the IPv4 header macOS ping sockets deliver with
+// the message
+func stripIPv4Header(b []byte) []byte {
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return b
+	}
+	if hlen := int(b[0]&0x0f) * 4; hlen >= 20 && len(b) >= hlen {
+		return b[hlen:]
+	}
+	return b
+}
+
+// addrIP returns the IP of a raw or ping socket's peer address
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// icmpProber pings with ICMP sockets of its own, without the ping command
+// and its localized output. Each probe opens a socket, so concurrent
+// probes never read each other's replies.
+type icmpProber struct {
+	timeout time.Duration
+	v4, v6  icmpMode
+	// fallback pings a family without a usable socket; nil with
+	// ping_backend native, where that is a measurement error
+	fallback Prober
+}
+
+// Probe pings host once and returns the time from the request to the reply
+func (p *icmpProber) Probe(host string) (float64, error) {
+	dst, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return 0, &probeError{reason: reasonDNS, err: err, output: err.Error()}
+	}
+	v6 := dst.IP.To4() == nil
+	mode := p.v4
+	if v6 {
+		mode = p.v6
+	}
+	if mode == icmpNone {
+		if p.fallback != nil {
+			return p.fallback.Probe(host)
+		}
+		return 0, &probeError{reason: reasonMeasurement, err: errNoICMPSocket, output: fmt.Sprintf("icmp %s: %v", host, errNoICMPSocket)}
+	}
+	rtt, err := p.echo(dst, v6, mode)
+	if err != nil {
+		return 0, err
+	}
+	return float64(rtt.Nanoseconds()) / 1000000, nil
+}
+
+// echo sends one echo request to dst and waits for the answer
+func (p *icmpProber) echo(dst *net.IPAddr, v6 bool, mode icmpMode) (time.Duration, error) {
+	fail := func(reason failureReason, err error) (time.Duration, error) {
+		return 0, &probeError{reason: reason, err: err, output: fmt.Sprintf("icmp %s (%s): %v", dst, mode, err)}
+	}
+	conn, err := listenICMP(v6, mode)
+	if err != nil {
+		return fail(reasonMeasurement, err)
+	}
+	defer conn.Close()
+
+	q := echoQuery{dst: dst.IP, id: icmpID, seq: uint16(icmpSeq.Add(1)), checkID: mode == icmpRaw || runtime.GOOS != "linux"}
+	var to net.Addr = dst
+	if mode == icmpDgram {
+		to = &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+	}
+	start := time.Now()
+	conn.SetDeadline(start.Add(p.timeout))
+	if _, err := conn.WriteTo(marshalEcho(v6, q.id, q.seq, icmpToken), to); err != nil {
+		return fail(socketErrorReason(err), err)
+	}
+	buf := make([]byte, icmpMaxMessage)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fail(reasonTimeout, fmt.Errorf("%w within %v", errNoEchoReply, p.timeout))
+		}
+		if err != nil {
+			// A ping socket reports the router's error this way
+			return fail(socketErrorReason(err), err)
+		}
+		b := buf[:n]
+		if mode == icmpDgram && !v6 && runtime.GOOS == "darwin" {
+			b = stripIPv4Header(b)
+		}
+		m, ok := parseICMP(b, v6)
+		if !ok {
+			continue
+		}
+		switch q.answer(m, addrIP(from)) {
+		case answerReply:
+			return time.Since(start), nil
+		case answerUnreachable:
+			return fail(reasonUnreachable, fmt.Errorf("destination unreachable (code %d) from %s", m.code, addrIP(from)))
+		case answerTimeExceeded:
+			return fail(reasonError, fmt.Errorf("time exceeded from %s", addrIP(from)))
+		}
+	}
+}
+
+// socketErrorReason classifies a failed send or receive like a TCP error
+func socketErrorReason(err error) failureReason {
+	switch classifyTCPError(err) {
+	case tcpUnreachable, tcpRefused:
+		return reasonUnreachable
+	case tcpLocal:
+		return reasonMeasurement
+	}
+	return reasonError
+}
+
+// newPingProber returns the prober of ping_backend and how it pings, for
+// the startup line and /status. With native, no usable socket at all is
+// an error.
+func newPingProber(backend string, timeout time.Duration) (Prober, string, error) {
+	exec := execProber{timeout: timeout}
+	if backend == pingBackendExec || backend == pingBackendAuto && runtime.GOOS == "windows" {
+		return exec, "pingコマンド", nil
+	}
+	v4, v6 := detectICMPModes()
+	p := &icmpProber{timeout: timeout, v4: v4, v6: v6}
+	if backend == pingBackendNative {
+		if v4 == icmpNone && v6 == icmpNone {
+			return nil, "", fmt.Errorf("ping_backend が native ですが、ICMPソケットを開けません（root権限・CAP_NET_RAW、または net.ipv4.ping_group_range での許可が必要です）")
+		}
+	} else {
+		if v4 == icmpNone && v6 == icmpNone {
+			return exec, "pingコマンド（ICMPソケットを開けないため）", nil
+		}
+		p.fallback = exec
+	}
+	return p, fmt.Sprintf("ネイティブICMP（IPv4: %s / IPv6: %s）", v4, v6), nil
+}
+
+// usesNativePing reports whether the backend pings without the ping
+// command for IPv4, as probeCapabilities needs to know before the
+// monitors start
+func usesNativePing(backend string) bool {
+	if backend == pingBackendExec || backend == pingBackendAuto && runtime.GOOS == "windows" {
+		return false
+	}
+	v4, _ := detectICMPModes()
+	return v4 != icmpNone
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	icmpTestDst    = net.ParseIP("192.0.2.10").To4()
+	icmpTestRouter = net.ParseIP("198.51.100.1").To4()
+	icmpTestSrc    = net.ParseIP("192.0.2.2").To4()
+	icmp6TestDst   = net.ParseIP("2001:db8::10")
+	icmp6TestRtr   = net.ParseIP("2001:db8:ffff::1")
+	icmp6TestSrc   = net.ParseIP("2001:db8::2")
+)
+
+// internetChecksum is the RFC 1071 checksum of b; 0 over a packet whose
+// checksum field is right
+func internetChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func marshalMessage(t *testing.T, m icmp.Message) []byte {
+	t.Helper()
+	b, err := m.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// echoReply builds the reply a host sends to an echo request
+func echoReply(t *testing.T, v6 bool, id, seq uint16, payload []byte) []byte {
+	var typ icmp.Type = ipv4.ICMPTypeEchoReply
+	if v6 {
+		typ = ipv6.ICMPTypeEchoReply
+	}
+	return marshalMessage(t, icmp.Message{Type: typ, Body: &icmp.Echo{ID: int(id), Seq: int(seq), Data: payload}})
+}
+
+// quoteRequest returns the IP header of an echo request to dst with the
+// first 8 bytes of the request, as an ICMP error quotes them
+func quoteRequest(t *testing.T, v6 bool, dst net.IP, id, seq uint16) []byte {
+	t.Helper()
+	request := marshalEcho(v6, id, seq, icmpToken)
+	if v6 {
+		h := make([]byte, ipv6.HeaderLen)
+		h[0] = 6 << 4
+		binary.BigEndian.PutUint16(h[4:], uint16(len(request)))
+		h[6], h[7] = 58, 64
+		copy(h[8:], icmp6TestSrc)
+		copy(h[24:], dst)
+		return append(h, request[:8]...)
+	}
+	h := &ipv4.Header{Version: 4, Len: ipv4.HeaderLen, TotalLen: ipv4.HeaderLen + len(request), TTL: 1, Protocol: 1, Src: icmpTestSrc, Dst: dst}
+	b, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(b, request[:8]...)
+}
+
+// icmpError builds an unreachable or time exceeded error quoting data
+func icmpError(t *testing.T, v6, exceeded bool, data []byte) []byte {
+	switch {
+	case v6 && exceeded:
+		return marshalMessage(t, icmp.Message{Type: ipv6.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: data}})
+	case v6:
+		return marshalMessage(t, icmp.Message{Type: ipv6.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: data}})
+	case exceeded:
+		return marshalMessage(t, icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: data}})
+	}
+	return marshalMessage(t, icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 1, Body: &icmp.DstUnreach{Data: data}})
+}
+
+func TestMarshalEcho(t *testing.T) {
+	b := marshalEcho(false, 0x1234, 0x0102, icmpToken)
+	if b[0] != 8 || b[1] != 0 {
+		t.Errorf("type/code %d/%d, want 8/0", b[0], b[1])
+	}
+	if got := internetChecksum(b); got != 0 {
+		t.Errorf("checksum does not verify: %#04x over % x", got, b)
+	}
+	if id, seq := binary.BigEndian.Uint16(b[4:]), binary.BigEndian.Uint16(b[6:]); id != 0x1234 || seq != 0x0102 {
+		t.Errorf("id/seq %#x/%#x", id, seq)
+	}
+	if !bytes.Equal(b[8:], icmpToken) {
+		t.Errorf("payload % x", b[8:])
+	}
+	// An odd payload length is padded in the checksum only
+	odd := marshalEcho(false, 1, 2, []byte("abc"))
+	if len(odd) != 11 || internetChecksum(odd) != 0 {
+		t.Errorf("odd payload: % x", odd)
+	}
+
+	// The kernel fills in the ICMPv6 checksum
+	b6 := marshalEcho(true, 0x1234, 0x0102, icmpToken)
+	if b6[0] != 128 || binary.BigEndian.Uint16(b6[2:]) != 0 {
+		t.Errorf("ICMPv6 request % x", b6[:4])
+	}
+}
+
+func TestEchoAnswer(t *testing.T) {
+	const id, seq = 0x4242, 7
+	tests := []struct {
+		name   string
+		v6     bool
+		packet []byte
+		from   net.IP
+		// linux is a Linux ping socket, which rewrites the identifier
+		linux bool
+		want  echoAnswer
+	}{
+		{"reply", false, echoReply(t, false, id, seq, icmpToken), icmpTestDst, false, answerReply},
+		{"foreign id", false, echoReply(t, false, id+1, seq, icmpToken), icmpTestDst, false, answerNone},
+		{"rewritten id on a ping socket", false, echoReply(t, false, 999, seq, icmpToken), icmpTestDst, true, answerReply},
+		{"stale seq", false, echoReply(t, false, id, seq-1, icmpToken), icmpTestDst, false, answerNone},
+		{"reply from another host", false, echoReply(t, false, id, seq, icmpToken), icmpTestRouter, false, answerNone},
+		{"another ping's payload", false, echoReply(t, false, id, seq, []byte("abcdefghijklmno")), icmpTestDst, false, answerNone},
+		{"our own request", false, marshalEcho(false, id, seq, icmpToken), icmpTestDst, false, answerNone},
+		{"unreachable", false, icmpError(t, false, false, quoteRequest(t, false, icmpTestDst, id, seq)), icmpTestRouter, false, answerUnreachable},
+		{"unreachable for another destination", false, icmpError(t, false, false, quoteRequest(t, false, icmpTestRouter, id, seq)), icmpTestRouter, false, answerNone},
+		{"unreachable for a foreign id", false, icmpError(t, false, false, quoteRequest(t, false, icmpTestDst, id+1, seq)), icmpTestRouter, false, answerNone},
+		{"unreachable for a stale seq", false, icmpError(t, false, false, quoteRequest(t, false, icmpTestDst, id, seq+1)), icmpTestRouter, false, answerNone},
+		{"time exceeded", false, icmpError(t, false, true, quoteRequest(t, false, icmpTestDst, id, seq)), icmpTestRouter, false, answerTimeExceeded},
+		{"v6 reply", true, echoReply(t, true, id, seq, icmpToken), icmp6TestDst, false, answerReply},
+		{"v6 foreign id", true, echoReply(t, true, id+1, seq, icmpToken), icmp6TestDst, false, answerNone},
+		{"v6 stale seq", true, echoReply(t, true, id, seq+1, icmpToken), icmp6TestDst, false, answerNone},
+		{"v6 unreachable", true, icmpError(t, true, false, quoteRequest(t, true, icmp6TestDst, id, seq)), icmp6TestRtr, false, answerUnreachable},
+		{"v6 time exceeded", true, icmpError(t, true, true, quoteRequest(t, true, icmp6TestDst, id, seq)), icmp6TestRtr, false, answerTimeExceeded},
+		{"v6 unreachable for another destination", true, icmpError(t, true, false, quoteRequest(t, true, icmp6TestRtr, id, seq)), icmp6TestRtr, false, answerNone},
+		// A v4 reply read from a v6 socket is not an echo reply there
+		{"v4 reply on a v6 socket", true, echoReply(t, false, id, seq, icmpToken), icmp6TestDst, false, answerNone},
+	}
+	for _, tt := range tests {
+		dst := icmpTestDst
+		if tt.v6 {
+			dst = icmp6TestDst
+		}
+		q := echoQuery{dst: dst, id: id, seq: seq, checkID: !tt.linux}
+		got := answerNone
+		if m, ok := parseICMP(tt.packet, tt.v6); ok {
+			got = q.answer(m, tt.from)
+		}
+		if got != tt.want {
+			t.Errorf("%s: answer %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestParseICMPTruncated cuts every kind of message short at each length:
+// none may panic or still count as the answer
+func TestParseICMPTruncated(t *testing.T) {
+	const id, seq = 0x4242, 7
+	packets := []struct {
+		v6     bool
+		packet []byte
+		from   net.IP
+	}{
+		{false, echoReply(t, false, id, seq, icmpToken), icmpTestDst},
+		{false, icmpError(t, false, false, quoteRequest(t, false, icmpTestDst, id, seq)), icmpTestRouter},
+		{false, icmpError(t, false, true, quoteRequest(t, false, icmpTestDst, id, seq)), icmpTestRouter},
+		{true, echoReply(t, true, id, seq, icmpToken), icmp6TestDst},
+		{true, icmpError(t, true, false, quoteRequest(t, true, icmp6TestDst, id, seq)), icmp6TestRtr},
+	}
+	for i, p := range packets {
+		dst := icmpTestDst
+		if p.v6 {
+			dst = icmp6TestDst
+		}
+		q := echoQuery{dst: dst, id: id, seq: seq, checkID: true}
+		for n := 0; n < len(p.packet); n++ {
+			if m, ok := parseICMP(p.packet[:n], p.v6); ok {
+				if got := q.answer(m, p.from); got != answerNone {
+					t.Errorf("packet %d cut to %d of %d bytes: answer %d", i, n, len(p.packet), got)
+				}
+			}
+		}
+		// An IPv4 header claiming more than it holds
+		if !p.v6 && i > 0 {
+			bad := bytes.Clone(p.packet)
+			bad[8] = 0x4f
+			if _, ok := parseICMP(bad, false); ok {
+				t.Errorf("packet %d: quoted header length past the end accepted", i)
+			}
+		}
+	}
+}
+
+func TestStripIPv4Header(t *testing.T) {
+	reply := echoReply(t, false, 1, 2, icmpToken)
+	h := &ipv4.Header{Version: 4, Len: 24, TotalLen: 24 + len(reply), TTL: 64, Protocol: 1, Src: icmpTestDst, Dst: icmpTestSrc, Options: []byte{1, 1, 1, 0}}
+	hb, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stripIPv4Header(append(hb, reply...)); !bytes.Equal(got, reply) {
+		t.Errorf("with options: % x", got)
+	}
+	if got := stripIPv4Header(reply); !bytes.Equal(got, reply) {
+		t.Errorf("without a header: % x", got)
+	}
+	if got := stripIPv4Header(hb[:10]); !bytes.Equal(got, hb[:10]) {
+		t.Errorf("short: % x", got)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sysClassNet is where Linux exposes per-interface statistics
+const sysClassNet = "/sys/class/net"
+
+// ifaceThroughput is the interface throughput since the previous sample
+type ifaceThroughput struct {
+	RxBps float64
+	TxBps float64
+	// Utilization is the busier direction as a percentage of the link
+	// speed, or -1 when the link speed is unknown (e.g. Wi-Fi)
+	Utilization float64
+}
+
+// String formats the throughput for console output and reports
+func (t ifaceThroughput) String() string {
+	s := fmt.Sprintf("受信 %s / 送信 %s", formatBitrate(t.RxBps), formatBitrate(t.TxBps))
+	if t.Utilization >= 0 {
+		s += fmt.Sprintf(" (使用率 %.0f%%)", t.Utilization)
+	}
+	return s
+}
+
+// ifaceSampler samples the byte counters of the default-route interface.
+// A nil *ifaceSampler is valid and never produces samples, which is what
+// newIfaceSampler returns on platforms without sysfs statistics.
+type ifaceSampler struct {
+	name      string
+	speedMbps int
+	lastRx    uint64
+	lastTx    uint64
+	lastAt    time.Time
+}
+
+// newIfaceSampler returns a sampler for the default-route interface, or
+// nil when counters are unavailable
+func newIfaceSampler() *ifaceSampler {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	name := defaultRouteInterface()
+	if name == "" {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(sysClassNet, name, "statistics")); err != nil {
+		return nil
+	}
+	s := &ifaceSampler{name: name}
+	if speed, err := readSysUint(filepath.Join(sysClassNet, name, "speed")); err == nil {
+		s.speedMbps = int(speed)
+	}
+	return s
+}
+
+// defaultRouteInterface reads the interface of the default route from
+// /proc/net/route without executing any command
+func defaultRouteInterface() string {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Iface Destination Gateway Flags ...
+		if len(fields) >= 2 && fields[1] == "00000000" {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// sample reads the counters and returns the throughput since the previous
+// call; ok is false for the first call and on read errors
+func (s *ifaceSampler) sample(now time.Time) (t ifaceThroughput, ok bool) {
+	if s == nil {
+		return t, false
+	}
+	dir := filepath.Join(sysClassNet, s.name, "statistics")
+	rx, err := readSysUint(filepath.Join(dir, "rx_bytes"))
+	if err != nil {
+		return t, false
+	}
+	tx, err := readSysUint(filepath.Join(dir, "tx_bytes"))
+	if err != nil {
+		return t, false
+	}
+
+	prevRx, prevTx, prevAt := s.lastRx, s.lastTx, s.lastAt
+	s.lastRx, s.lastTx, s.lastAt = rx, tx, now
+
+	elapsed := now.Sub(prevAt).Seconds()
+	// Counter resets (interface re-created) show up as decreasing values
+	if prevAt.IsZero() || elapsed <= 0 || rx < prevRx || tx < prevTx {
+		return t, false
+	}
+
+	t.RxBps = float64(rx-prevRx) * 8 / elapsed
+	t.TxBps = float64(tx-prevTx) * 8 / elapsed
+	t.Utilization = -1
+	if s.speedMbps > 0 {
+		busier := t.RxBps
+		if t.TxBps > busier {
+			busier = t.TxBps
+		}
+		t.Utilization = busier / (float64(s.speedMbps) * 1e6) * 100
+	}
+	return t, true
+}
+
+// readSysUint reads a single unsigned integer from a sysfs file
+func readSysUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// formatBitrate formats bits per second with an SI unit
+func formatBitrate(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.1fGbps", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.1fMbps", bps/1e6)
+	case bps >= 1e3:
+		return fmt.Sprintf("%.1fkbps", bps/1e3)
+	default:
+		return fmt.Sprintf("%.0fbps", bps)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutageRecord is one confirmed outage as stored in state_dir/outages.jsonl
+type OutageRecord struct {
+	Target          string    `json:"target"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Simulated       bool      `json:"simulated,omitempty"`
+	// Reason is the dominant failure reason during the outage
+	Reason string `json:"reason,omitempty"`
+	// Cause is "gateway" when the gateway was down as well for most of
+	// the outage
+	Cause string `json:"cause,omitempty"`
+	// Acknowledged is set when the outage was snoozed from its alert
+	Acknowledged bool `json:"acknowledged,omitempty"`
+	// Classes splits the duration by cause; records written before it
+	// existed fall back to Cause in classes()
+	Classes *OutageClasses `json:"classes,omitempty"`
+	// UnnotifiedSeconds is the part of the outage during which every
+	// notification delivery failed
+	UnnotifiedSeconds float64 `json:"unnotified_seconds,omitempty"`
+	// EventID is the event log ID of the outage alert, which the response
+	// time exemplars of /metrics carry as incident_id
+	EventID uint64 `json:"event_id,omitempty"`
+	// Connection is the type of link the outage began on
+	Connection connType `json:"connection,omitempty"`
+}
+
+// classes returns the split of the duration; an older record counts as
+// LAN when attributed to the gateway and as unclassified otherwise
+func (r OutageRecord) classes() OutageClasses {
+	if r.Classes != nil {
+		return *r.Classes
+	}
+	d := r.End.Sub(r.Start)
+	var c OutageClasses
+	if r.Cause == outageCauseGateway {
+		c.add(gatewayUnreachable, d)
+	} else {
+		c.add(gatewayUnknown, d)
+	}
+	return c
+}
+
+// incidentLog appends confirmed outages to a JSONL file
+type incidentLog struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// newIncidentLog returns the log kept under stateDir
+func newIncidentLog(stateDir string) *incidentLog {
+	return &incidentLog{path: filepath.Join(stateDir, "outages.jsonl")}
+}
+
+// append writes a single record
+func (l *incidentLog) append(rec OutageRecord) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(rec)
+}
+
+// load returns the outages that started in [from, to), oldest first. A
+// missing log means no outages have been recorded yet.
+func (l *incidentLog) load(from, to time.Time) ([]OutageRecord, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []OutageRecord
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec OutageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", l.path, line, err)
+		}
+		if !rec.Start.Before(from) && rec.Start.Before(to) {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Start.Before(records[j].Start) })
+	return records, scanner.Err()
+}
+
+// prune rewrites the log without the outages that started before before
+func (l *incidentLog) prune(before time.Time) error {
+	records, err := l.load(before, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := os.Stat(l.path); os.IsNotExist(err) {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), "outages.jsonl.tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	enc := json.NewEncoder(tmp)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// outageBuckets are the histogram classes of outage durations; each bucket
+// holds durations below its upper bound
+var outageBuckets = []struct {
+	Label string
+	Upper time.Duration
+}{
+	{"10秒未満", 10 * time.Second},
+	{"10〜60秒", time.Minute},
+	{"1〜10分", 10 * time.Minute},
+	{"10分以上", 0},
+}
+
+// IncidentStats summarizes the outages of a period
+type IncidentStats struct {
+	Count           int     `json:"count"`
+	DowntimeSeconds float64 `json:"downtime_seconds"`
+	// MTTRSeconds is the mean outage duration; 0 without outages
+	MTTRSeconds float64 `json:"mttr_seconds"`
+	// MTBFSeconds is the mean up time between the end of one outage and the
+	// start of the next; 0 when fewer than two outages make it undefined
+	MTBFSeconds float64          `json:"mtbf_seconds"`
+	Histogram   []HistogramCount `json:"histogram"`
+	// Classes is the downtime by cause
+	Classes OutageClasses `json:"classes"`
+}
+
+// HistogramCount is one outage duration class
+type HistogramCount struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// computeIncidentStats computes MTTR, MTBF and the duration histogram.
+//
+// Only outages confirmed by outageTracker are recorded, so a blip shorter
+// than failure_threshold consecutive failures never counts: with the
+// default of 3 a single failed sample is not an outage. With
+// failure_threshold 1 it is, and lasts one interval. An outage lasts from
+// its first failed sample to the first success of the streak that
+// confirmed recovery. Simulated outages are excluded. MTBF is measured
+// from the end of one outage to the start of the next, so it is the up
+// time alone and not "MTTR + up time" as some definitions use.
+func computeIncidentStats(records []OutageRecord) IncidentStats {
+	stats := IncidentStats{Histogram: make([]HistogramCount, len(outageBuckets))}
+	for i, b := range outageBuckets {
+		stats.Histogram[i].Label = b.Label
+	}
+
+	var real []OutageRecord
+	for _, rec := range records {
+		if !rec.Simulated {
+			real = append(real, rec)
+		}
+	}
+	sort.Slice(real, func(i, j int) bool { return real[i].Start.Before(real[j].Start) })
+
+	var upTotal time.Duration
+	for i, rec := range real {
+		d := rec.End.Sub(rec.Start)
+		stats.DowntimeSeconds += d.Seconds()
+		stats.Classes.merge(rec.classes())
+		for j, b := range outageBuckets {
+			if b.Upper == 0 || d < b.Upper {
+				stats.Histogram[j].Count++
+				break
+			}
+		}
+		if i > 0 {
+			upTotal += rec.Start.Sub(real[i-1].End)
+		}
+	}
+
+	stats.Count = len(real)
+	if stats.Count > 0 {
+		stats.MTTRSeconds = stats.DowntimeSeconds / float64(stats.Count)
+	}
+	if stats.Count > 1 {
+		stats.MTBFSeconds = upTotal.Seconds() / float64(stats.Count-1)
+	}
+	return stats
+}
+
+// formatIncidentStats renders stats as lines for reports and the CLI
+func formatIncidentStats(stats IncidentStats, f numberFormat) string {
+	if stats.Count == 0 {
+		return "障害なし"
+	}
+	text := fmt.Sprintf("**障害回数**: %s\n**合計停止時間**: %s\n**MTTR**: %s\n", f.count(stats.Count), f.seconds(stats.DowntimeSeconds), f.seconds(stats.MTTRSeconds))
+	if stats.Count > 1 {
+		text += fmt.Sprintf("**MTBF**: %s\n", f.seconds(stats.MTBFSeconds))
+	} else {
+		text += "**MTBF**: —（障害2回以上で算出）\n"
+	}
+	var buckets []string
+	for _, h := range stats.Histogram {
+		buckets = append(buckets, fmt.Sprintf("%s %s件", h.Label, f.count(h.Count)))
+	}
+	text += "**継続時間の分布**: " + strings.Join(buckets, " / ")
+	return text + "\n**原因別の停止時間**: " + stats.Classes.format(f)
+}
+
+// IncidentsOutput represents the JSON output of the incidents subcommand
+type IncidentsOutput struct {
+	Month     string         `json:"month"`
+	Stats     IncidentStats  `json:"stats"`
+	Incidents []OutageRecord `json:"incidents"`
+}
+
+// runIncidents lists the recorded outages of a month with MTTR/MTBF
+func runIncidents(args []string) int {
+	fs := flag.NewFlagSet("incidents", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "設定ファイルのパス")
+	monthFlag := fs.String("month", time.Now().Format("2006-01"), "対象月 (YYYY-MM)")
+	jsonOutput := fs.Bool("json", false, "JSON形式で出力する")
+	fs.Parse(args)
+
+	cfg, err := readConfig(*configPath, ConfigOverrides{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	if cfg.StateDir == "" || cfg.storeKind() == storeMemory {
+		fmt.Fprintln(os.Stderr, "エラー: 障害記録にはconfig.jsonのstate_dirの設定が必要です（store が file か sqlite のとき）")
+		return 1
+	}
+	month, err := time.ParseInLocation("2006-01", *monthFlag, time.Local)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: -month の値が正しくありません: %q (YYYY-MM)\n", *monthFlag)
+		return 2
+	}
+
+	store, err := openStore(cfg, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	records, err := store.Events(monthRange(month))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	stats := computeIncidentStats(records)
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(IncidentsOutput{Month: *monthFlag, Stats: stats, Incidents: records})
+		return 0
+	}
+
+	fmt.Printf("%sの障害一覧\n", *monthFlag)
+	for _, rec := range records {
+		marker := ""
+		if rec.Simulated {
+			marker = " [SIMULATED]"
+		}
+		if rec.Acknowledged {
+			marker += " 確認済み"
+		}
+		fmt.Printf("  %s 〜 %s (%v)%s\n", rec.Start.Local().Format("01/02 15:04:05"), rec.End.Local().Format("15:04:05"),
+			rec.End.Sub(rec.Start).Round(time.Second), marker)
+	}
+	fmt.Println()
+	fmt.Println(strings.ReplaceAll(formatIncidentStats(stats, newNumberFormat(cfg)), "**", ""))
+	return 0
+}
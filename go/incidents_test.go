@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeIncidentStats(t *testing.T) {
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	outage := func(startMinute int, d time.Duration) OutageRecord {
+		start := base.Add(time.Duration(startMinute) * time.Minute)
+		return OutageRecord{Start: start, End: start.Add(d)}
+	}
+	simulated := outage(30, time.Hour)
+	simulated.Simulated = true
+	tests := []struct {
+		name      string
+		records   []OutageRecord
+		count     int
+		downtime  float64
+		mttr      float64
+		mtbf      float64
+		histogram [4]int
+	}{
+		{"none", nil, 0, 0, 0, 0, [4]int{}},
+		// A single failed sample at failure_threshold 1 lasts one interval
+		{"single-sample blip", []OutageRecord{outage(0, 5*time.Second)}, 1, 5, 5, 0, [4]int{1, 0, 0, 0}},
+		// MTBF needs two outages: one gap of 10m - 30s
+		{"two", []OutageRecord{outage(0, 30*time.Second), outage(10, 90*time.Second)}, 2, 120, 60, 570, [4]int{0, 1, 1, 0}},
+		// Sorted by start the gaps are 60-9, 3600-119 and 7200-3900 seconds
+		{"unsorted, one per bucket", []OutageRecord{
+			outage(120, 20*time.Minute), // 7200-8400
+			outage(0, 9*time.Second),    // 0-9
+			outage(60, 5*time.Minute),   // 3600-3900
+			outage(1, 59*time.Second),   // 60-119
+		}, 4, 9 + 59 + 300 + 1200, 1568.0 / 4, (51.0 + 3481 + 3300) / 3, [4]int{1, 1, 1, 1}},
+		// The bounds belong to the next bucket
+		{"bounds", []OutageRecord{outage(0, 10*time.Second), outage(1, time.Minute), outage(3, 10*time.Minute)},
+			3, 670, 670.0 / 3, (50.0 + 60) / 2, [4]int{0, 1, 1, 1}},
+		{"simulated excluded", []OutageRecord{outage(0, 30*time.Second), simulated}, 1, 30, 30, 0, [4]int{0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		s := computeIncidentStats(tt.records)
+		if s.Count != tt.count || s.DowntimeSeconds != tt.downtime || s.MTTRSeconds != tt.mttr || s.MTBFSeconds != tt.mtbf {
+			t.Errorf("%s: count %d downtime %v MTTR %v MTBF %v, want %d %v %v %v",
+				tt.name, s.Count, s.DowntimeSeconds, s.MTTRSeconds, s.MTBFSeconds, tt.count, tt.downtime, tt.mttr, tt.mtbf)
+		}
+		if len(s.Histogram) != len(outageBuckets) {
+			t.Fatalf("%s: %d histogram buckets", tt.name, len(s.Histogram))
+		}
+		for i, h := range s.Histogram {
+			if h.Label != outageBuckets[i].Label || h.Count != tt.histogram[i] {
+				t.Errorf("%s: bucket %s has %d, want %d", tt.name, h.Label, h.Count, tt.histogram[i])
+			}
+		}
+		if s.Classes.UnclassifiedSeconds != tt.downtime {
+			t.Errorf("%s: unclassified %v, want the downtime", tt.name, s.Classes.UnclassifiedSeconds)
+		}
+	}
+}
+
+// TestSingleSampleBlip feeds one failed sample between successes: it is
+// not an outage at the default failure_threshold and is a one-interval
+// outage at failure_threshold 1
+func TestSingleSampleBlip(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, threshold := range []int{3, 1} {
+		tracker := newOutageTracker(threshold, 1, start)
+		var records []OutageRecord
+		for i, reason := range []failureReason{"", reasonTimeout, "", ""} {
+			if tr := tracker.observe(start.Add(time.Duration(i)*5*time.Second), reason, gatewayReachable); tr != nil && !tr.Down {
+				records = append(records, OutageRecord{Start: tr.Start, End: tr.End})
+			}
+		}
+		s := computeIncidentStats(records)
+		switch threshold {
+		case 3:
+			if s.Count != 0 {
+				t.Errorf("threshold 3: %d outages from a blip", s.Count)
+			}
+		case 1:
+			if s.Count != 1 || s.MTTRSeconds != 5 || s.Histogram[0].Count != 1 {
+				t.Errorf("threshold 1: %+v, want one 5s outage", s)
+			}
+		}
+	}
+}
+
+func TestFormatIncidentStats(t *testing.T) {
+	f := numberFormat{}
+	if got := formatIncidentStats(IncidentStats{}, f); got != "障害なし" {
+		t.Errorf("no outages: %q", got)
+	}
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	one := computeIncidentStats([]OutageRecord{{Start: base, End: base.Add(5 * time.Second)}})
+	want := "**障害回数**: 1\n**合計停止時間**: 5s\n**MTTR**: 5s\n**MTBF**: —（障害2回以上で算出）\n" +
+		"**継続時間の分布**: 10秒未満 1件 / 10〜60秒 0件 / 1〜10分 0件 / 10分以上 0件\n" +
+		"**原因別の停止時間**: 宅内・ゲートウェイ 0s (0.0%) / 回線・上流 0s (0.0%) / 未分類 5s (100.0%)"
+	if got := formatIncidentStats(one, f); got != want {
+		t.Errorf("one outage:\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// latencyTransition is reported by latencyTracker when a latency alert
+// starts or clears
+type latencyTransition struct {
+	Alerting bool
+	Start    time.Time
+	End      time.Time
+	RTT      float64
+	Context  AlertContext
+}
+
+// latencyTracker raises an alert after `samples` consecutive responses at
+// or above the critical threshold and clears it after as many below the
+// warning threshold. Failed probes belong to the outage tracker and leave
+// the streaks untouched.
+type latencyTracker struct {
+	criticalMs float64
+	warnMs     float64
+	samples    int
+	high       int
+	low        int
+	alerting   bool
+	streak     time.Time
+	start      time.Time
+}
+
+// newLatencyTracker creates a tracker; samples == 0 disables it
+func newLatencyTracker(warnMs, criticalMs float64, samples int) *latencyTracker {
+	return &latencyTracker{criticalMs: criticalMs, warnMs: warnMs, samples: samples}
+}
+
+// observe feeds one probe result and returns a transition, if any
+func (t *latencyTracker) observe(at time.Time, success bool, ms float64) *latencyTransition {
+	if t.samples == 0 || !success {
+		return nil
+	}
+	if !t.alerting {
+		if ms < t.criticalMs {
+			t.high = 0
+			return nil
+		}
+		if t.high == 0 {
+			t.streak = at
+		}
+		t.high++
+		if t.high < t.samples {
+			return nil
+		}
+		t.alerting, t.high, t.start = true, 0, t.streak
+		return &latencyTransition{Alerting: true, Start: t.start, RTT: ms, Context: t.context("latency", ms, ">=", t.criticalMs)}
+	}
+
+	if ms >= t.warnMs {
+		t.low = 0
+		return nil
+	}
+	t.low++
+	if t.low < t.samples {
+		return nil
+	}
+	t.alerting, t.low = false, 0
+	return &latencyTransition{Alerting: false, Start: t.start, End: at, RTT: ms, Context: t.context("latency_recovery", ms, "<", t.warnMs)}
+}
+
+// context describes the rule that fired, with the tracker's samples
+func (t *latencyTracker) context(rule string, ms float64, op string, threshold float64) AlertContext {
+	return AlertContext{Rule: rule, Metric: "応答時間", Observed: ms, Op: op, Threshold: threshold, Unit: "ms", Window: fmt.Sprintf("連続%d回", t.samples)}
+}
+
+// notifyLatencyTransition turns a transition into an alert event carrying
+// the line and host load, so a slow monitor host is not blamed on the ISP
+func (pm *PingMonitor) notifyLatencyTransition(tr *latencyTransition, throughput *ifaceThroughput, load *hostLoad) {
+	if !tr.Alerting {
+		duration := tr.End.Sub(tr.Start).Round(time.Second)
+		fmt.Printf("✅ 応答遅延が解消しました（継続時間 %v）\n", duration)
+		pm.dispatcher.dispatch(Event{
+			Kind:     EventLatencyRecovery,
+			Severity: SeverityInfo,
+			Time:     tr.End,
+			Title:    "✅ 応答遅延 解消",
+			Message: fmt.Sprintf("**対象**: %s\n**開始**: %s\n**解消**: %s\n**継続時間**: %s",
+				pm.targetLabel(), tr.Start.Format("2006-01-02 15:04:05"), tr.End.Format("2006-01-02 15:04:05"), pm.format.duration(duration)) + tr.Context.messageLine(),
+			Data: map[string]interface{}{
+				"target":           pm.targetIP,
+				"start":            tr.Start.Format(time.RFC3339),
+				"end":              tr.End.Format(time.RFC3339),
+				"duration_seconds": duration.Seconds(),
+				"rule":             tr.Context,
+			},
+		})
+		return
+	}
+
+	fmt.Printf("🐢 応答遅延を検知しました（%.1fms、%s開始）\n", tr.RTT, tr.Start.Format("15:04:05"))
+	message := fmt.Sprintf("**対象**: %s\n**応答時間**: %s\n**開始**: %s",
+		pm.targetLabel(), pm.format.ms(tr.RTT), tr.Start.Format("2006-01-02 15:04:05")) + tr.Context.messageLine()
+	data := map[string]interface{}{
+		"target":       pm.targetIP,
+		"rtt_ms":       tr.RTT,
+		"start":        tr.Start.Format(time.RFC3339),
+		"threshold_ms": tr.Context.Threshold,
+		"rule":         tr.Context,
+	}
+	if throughput != nil {
+		message += fmt.Sprintf("\n**回線**: %s", throughput)
+		data["rx_bps"], data["tx_bps"] = throughput.RxBps, throughput.TxBps
+	}
+	if load != nil {
+		message += fmt.Sprintf("\n**ホスト負荷**: %s", load)
+		if load.high(pm.config.HostLoadThreshold) {
+			message += "\n⚠️ 監視ホスト自体が高負荷のため、応答時間が実際より大きく計測されている可能性があります"
+		}
+		data["host_load1"] = load.Load1
+		data["host_mem_available_percent"] = load.MemAvailablePercent
+	}
+	pm.dispatcher.dispatch(Event{
+		Kind:     EventLatency,
+		Severity: SeverityWarn,
+		Time:     tr.Start,
+		Title:    "🐢 応答遅延",
+		Message:  message,
+		Data:     data,
+	})
+}
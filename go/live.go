@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ping-monitor/api"
+)
+
+const (
+	// liveBuffer is how many results a slow stream client may fall behind
+	// before results are dropped for it
+	liveBuffer = 64
+	// liveHeartbeat keeps idle streams from being cut by proxies
+	liveHeartbeat = 15 * time.Second
+	// tailMaxBackoff caps the wait between reconnection attempts
+	tailMaxBackoff = 30 * time.Second
+)
+
+// resultFeed fans results out to live stream clients. A client that does
+// not keep up loses results rather than slowing the ping loop down.
+type resultFeed struct {
+	mutex sync.Mutex
+	subs  map[chan ResultRecord]struct{}
+}
+
+// newResultFeed creates a feed without clients
+func newResultFeed() *resultFeed {
+	return &resultFeed{subs: make(map[chan ResultRecord]struct{})}
+}
+
+// subscribe returns a channel of results and the function that ends it
+func (f *resultFeed) subscribe() (<-chan ResultRecord, func()) {
+	ch := make(chan ResultRecord, liveBuffer)
+	f.mutex.Lock()
+	f.subs[ch] = struct{}{}
+	f.mutex.Unlock()
+	return ch, func() {
+		f.mutex.Lock()
+		delete(f.subs, ch)
+		f.mutex.Unlock()
+	}
+}
+
+// publish hands rec to every client with room for it
+func (f *resultFeed) publish(rec ResultRecord) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- rec:
+		default:
+		}
+	}
+}
+
+// handleStream streams results as Server-Sent Events ("event: result"
+// with an api.Result as data) until the client goes away
+func (s *apiServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	results, cancel := s.pm.feed.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.pm.stopChan:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case rec := <-results:
+			data, _ := json.Marshal(api.Result(rec))
+			fmt.Fprintf(w, "event: result\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
+
+// tailColors highlights tail lines on a terminal
+type tailColors struct {
+	enabled bool
+}
+
+// paint wraps s in the ANSI color code when colors are enabled
+func (c tailColors) paint(code, s string) string {
+	if !c.enabled {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+// formatTailLine renders a streamed result like the monitor's console
+func formatTailLine(rec ResultRecord, colors tailColors) string {
+	at := rec.Timestamp.Local().Format("15:04:05")
+	warmup := ""
+	if rec.Warmup {
+		warmup = " (ウォームアップ)"
+	} else if rec.LoadTest {
+		warmup = " (負荷試験中)"
+	}
+	switch {
+	case rec.Success:
+		return fmt.Sprintf("%s - %s ping: %.1fms%s", at, rec.Target, rec.ResponseTime, warmup)
+	case rec.excluded():
+		return colors.paint("33", fmt.Sprintf("%s - %s 到達不能%s", at, rec.Target, warmup))
+	case failureReason(rec.Reason) == reasonMeasurement:
+		return colors.paint("33", fmt.Sprintf("%s - 計測エラー", at))
+	case rec.Simulated:
+		return colors.paint("35", fmt.Sprintf("%s - %s 到達不能 [SIMULATED]", at, rec.Target))
+	}
+	line := colors.paint("1;31", fmt.Sprintf("%s - %s 到達不能（%s）", at, rec.Target, failureReason(rec.Reason).label()))
+	switch gatewayState(rec.Gateway) {
+	case gatewayReachable:
+		line += "\n  -> デフォルトゲートウェイ: 応答あり"
+	case gatewayUnreachable:
+		line += "\n" + colors.paint("31", "  -> デフォルトゲートウェイ: 到達不能")
+	}
+	return line
+}
+
+// streamResults reads one SSE connection and hands every result's data to
+// emit. It returns when the stream ends, with whether any event arrived.
+func streamResults(client *apiClient, path string, emit func(data []byte)) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, client.baseURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.token)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("API error: %d", resp.StatusCode)
+	}
+	received := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			received = true
+			emit([]byte(data))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return received, err
+	}
+	return received, fmt.Errorf("接続が閉じられました")
+}
+
+// runTail follows a running instance's results as they are measured,
+// reconnecting with backoff until interrupted
+func runTail(args []string) int {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "設定ファイルのパス")
+	baseURL := fs.String("url", "", "稼働中インスタンスのURL（省略時はconfigのhttp_listen）")
+	token := fs.String("token", "", "APIトークン（省略時はconfigのapi_token）")
+	profile := fs.String("profile", "", "対象のプロファイル（プロファイルを定義している場合）")
+	jsonOut := fs.Bool("json", false, "結果をそのまま1行1件のJSONで出力する（jq向け）")
+	noColor := fs.Bool("no-color", false, "色を付けない")
+	fs.Parse(args)
+
+	client, err := newAPIClient(*configPath, *baseURL, *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	// The stream stays open; heartbeats show it is alive
+	client.http.Timeout = 0
+	path := "/api/v1/stream"
+	if *profile != "" {
+		path = profilePathPrefix(*profile) + path
+	}
+
+	colors := tailColors{}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && !*noColor && os.Getenv("NO_COLOR") == "" {
+		colors.enabled = true
+	}
+	emit := func(data []byte) {
+		if *jsonOut {
+			fmt.Println(string(data))
+			return
+		}
+		var rec ResultRecord
+		if json.Unmarshal(data, &rec) == nil {
+			fmt.Println(formatTailLine(rec, colors))
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	backoff := time.Second
+	for {
+		fmt.Fprintf(os.Stderr, "%s に接続しています...\n", client.where)
+		received, err := streamResults(client, path, emit)
+		if received {
+			backoff = time.Second
+		}
+		fmt.Fprintf(os.Stderr, "⚠️ %v。%v後に再接続します\n", err, backoff)
+		select {
+		case <-sigChan:
+			return 0
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, tailMaxBackoff)
+	}
+}
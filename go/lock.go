@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// takeoverGrace is added to the shutdown timeout when waiting for a taken
+// over instance to exit
+const takeoverGrace = 10 * time.Second
+
+// InstanceLockInfo is the content of the instance lock file
+type InstanceLockInfo struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Target  string    `json:"target"`
+}
+
+// instanceLock keeps a second instance from monitoring the same target:
+// state_dir/instance.lock, or a file in the temporary directory keyed by
+// the target without a state_dir. The file holds the owner's PID, so a
+// lock left by a crashed process is recognized and recovered.
+type instanceLock struct {
+	path string
+	// shared is set when this process already held the lock, for profiles
+	// sharing a target
+	shared bool
+}
+
+// instanceLockPath returns the lock file of cfg
+func instanceLockPath(cfg Config) string {
+	if cfg.StateDir != "" {
+		return filepath.Join(cfg.StateDir, "instance.lock")
+	}
+	h := fnv.New32a()
+	h.Write([]byte(cfg.Target))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("ping-monitor-%08x.lock", h.Sum32()))
+}
+
+// acquireInstanceLock takes the lock of cfg. A live owner is an error
+// unless takeover is set, in which case it is asked to shut down and
+// awaited.
+func acquireInstanceLock(cfg Config, takeover bool) (*instanceLock, error) {
+	path := instanceLockPath(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	signalled, previous := false, 0
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			info := InstanceLockInfo{PID: os.Getpid(), Started: time.Now(), Target: cfg.Target}
+			werr := json.NewEncoder(f).Encode(info)
+			if cerr := f.Close(); werr == nil {
+				werr = cerr
+			}
+			if werr != nil {
+				os.Remove(path)
+				return nil, werr
+			}
+			if signalled {
+				recordAudit(cfg.StateDir, AuditEntry{Action: "takeover", Actor: fmt.Sprintf("pid:%d", info.PID),
+					Old: fmt.Sprintf("pid %d", previous), New: fmt.Sprintf("pid %d", info.PID)})
+			}
+			return &instanceLock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		info, err := readInstanceLock(path)
+		if err != nil {
+			// Another instance may be between creating and writing it
+			if stat, serr := os.Stat(path); serr == nil && time.Since(stat.ModTime()) < time.Second {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			fmt.Printf("⚠️ 読み取れないインスタンスのロックを削除します（%s）: %v\n", path, err)
+			os.Remove(path)
+			continue
+		}
+		switch {
+		case info.PID == os.Getpid():
+			return &instanceLock{path: path, shared: true}, nil
+		case !processAlive(info.PID):
+			fmt.Printf("⚠️ 停止済みのインスタンス（PID %d）のロックを回収します\n", info.PID)
+			removeLockOf(path, info.PID)
+			continue
+		case !takeover:
+			return nil, fmt.Errorf("別のインスタンス（PID %d、%sから稼働）が %s を監視しています。停止するか -takeover を指定してください（ロック: %s）",
+				info.PID, info.Started.Format("2006-01-02 15:04:05"), info.Target, path)
+		case signalled:
+			return nil, fmt.Errorf("インスタンス（PID %d）が終了しません（ロック: %s）", info.PID, path)
+		}
+
+		fmt.Printf("🔁 稼働中のインスタンス（PID %d）を停止して引き継ぎます\n", info.PID)
+		if err := terminateProcess(info.PID); err != nil {
+			return nil, fmt.Errorf("インスタンス（PID %d）を停止できません: %v", info.PID, err)
+		}
+		signalled, previous = true, info.PID
+		waitForRelease(path, info.PID, cfg.ShutdownTimeout.Duration()+takeoverGrace)
+	}
+}
+
+// readInstanceLock reads the lock file at path
+func readInstanceLock(path string) (InstanceLockInfo, error) {
+	var info InstanceLockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, err
+	}
+	if info.PID <= 0 {
+		return info, errors.New("no pid")
+	}
+	return info, nil
+}
+
+// removeLockOf removes the lock at path if pid still owns it, so a lock
+// just taken by a concurrent instance is left alone
+func removeLockOf(path string, pid int) {
+	if info, err := readInstanceLock(path); err == nil && info.PID == pid {
+		os.Remove(path)
+	}
+}
+
+// waitForRelease waits until pid exits or gives up the lock at path
+func waitForRelease(path string, pid int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		info, err := readInstanceLock(path)
+		if os.IsNotExist(err) || (err == nil && info.PID != pid) || !processAlive(pid) {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// processAlive reports whether a process with pid exists. On Unix signal 0
+// checks for it without delivering anything; Windows cannot open a handle
+// to a process that is gone.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		p.Release()
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// terminateProcess asks pid to shut down gracefully. Windows has no
+// SIGTERM, so the process is killed there.
+func terminateProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return p.Kill()
+	}
+	return p.Signal(syscall.SIGTERM)
+}
+
+// release removes the lock unless it was shared or taken over meanwhile
+func (l *instanceLock) release() {
+	if l == nil || l.shared {
+		return
+	}
+	removeLockOf(l.path, os.Getpid())
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// logFileOptions configures -log-file
+type logFileOptions struct {
+	path      string
+	maxSizeMB int
+	keep      int
+	compress  bool
+}
+
+// logFileFlags registers the -log-file flags on the default flag set
+func logFileFlags() *logFileOptions {
+	opts := &logFileOptions{}
+	flag.StringVar(&opts.path, "log-file", "", "ログの出力先ファイル（省略時は標準出力）")
+	flag.IntVar(&opts.maxSizeMB, "log-max-size", 10, "-log-file をローテーションするサイズ（MB、0でローテーションしない）")
+	flag.IntVar(&opts.keep, "log-keep", 5, "-log-file のローテーション後に残す世代数")
+	flag.BoolVar(&opts.compress, "log-compress", false, "ローテーションした -log-file をgzipで圧縮する")
+	return opts
+}
+
+// rotatingWriter appends to a log file, rotating it to path.1, path.2, ...
+// once it exceeds maxBytes. It is safe for concurrent use. A failed write
+// (disk full, file removed from under it) sends output to stderr with a
+// single warning until the file is reopened.
+type rotatingWriter struct {
+	mutex    sync.Mutex
+	path     string
+	maxBytes int64
+	keep     int
+	compress bool
+	file     *os.File
+	size     int64
+	degraded bool
+	fallback io.Writer
+}
+
+// openRotatingWriter opens path for appending
+func openRotatingWriter(opts *logFileOptions, fallback io.Writer) (*rotatingWriter, error) {
+	w := &rotatingWriter{
+		path:     opts.path,
+		maxBytes: int64(opts.maxSizeMB) << 20,
+		keep:     opts.keep,
+		compress: opts.compress,
+		fallback: fallback,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open (re)opens the file; the caller holds the mutex or owns w
+func (w *rotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first when p would overrun the size limit
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.degraded {
+		return w.fallback.Write(p)
+	}
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return w.degrade(p, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		return w.degrade(p[n:], err)
+	}
+	return n, nil
+}
+
+// degrade switches to stderr after a failure, warning once
+func (w *rotatingWriter) degrade(p []byte, cause error) (int, error) {
+	w.degraded = true
+	fmt.Fprintf(w.fallback, "⚠️ ログファイル %s に書き込めないため、標準エラーに出力します（SIGHUPで再試行）: %v\n", w.path, cause)
+	return w.fallback.Write(p)
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest beyond keep, and
+// starts a new file
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	suffix := ""
+	if w.compress {
+		suffix = ".gz"
+	}
+	os.Remove(fmt.Sprintf("%s.%d%s", w.path, w.keep, suffix))
+	for i := w.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d%s", w.path, i, suffix), fmt.Sprintf("%s.%d%s", w.path, i+1, suffix))
+	}
+	if w.keep == 0 {
+		os.Remove(w.path)
+	} else if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return err
+	}
+	if w.compress && w.keep > 0 {
+		if err := gzipFile(w.path + ".1"); err != nil {
+			fmt.Fprintf(w.fallback, "⚠️ ログの圧縮に失敗しました: %v\n", err)
+		}
+	}
+	return w.open()
+}
+
+// gzipFile replaces path with path.gz
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// reopen closes and reopens the file, for logrotate moving it away; it
+// also retries after a degraded write
+func (w *rotatingWriter) reopen() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file != nil {
+		w.file.Close()
+	}
+	if err := w.open(); err != nil {
+		if !w.degraded {
+			w.degraded = true
+			fmt.Fprintf(w.fallback, "⚠️ ログファイル %s を開けないため、標準エラーに出力します: %v\n", w.path, err)
+		}
+		return
+	}
+	w.degraded = false
+}
+
+// Close closes the file
+func (w *rotatingWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.file.Close()
+}
+
+// startLogFile sends stdout and the log package to the rotating file. The
+// monitor prints with fmt, so stdout is replaced by a pipe copied line by
+// line into the file. The returned function flushes and restores stdout.
+func startLogFile(opts *logFileOptions) (func(), error) {
+	w, err := openRotatingWriter(opts, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	restore, err := redirectStdout(func(line []byte) { w.Write(line) })
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	log.SetOutput(w)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for sig := range hup {
+			w.reopen()
+			logReopenHooks.run(sig)
+		}
+	}()
+
+	return func() {
+		signal.Stop(hup)
+		restore()
+		log.SetOutput(os.Stderr)
+		w.Close()
+	}, nil
+}
+
+// signalHooks are called when a signal handled elsewhere arrives, so
+// monitors can audit it
+type signalHooks struct {
+	mutex sync.Mutex
+	hooks []func(os.Signal)
+}
+
+// logReopenHooks run after SIGHUP reopened the log file
+var logReopenHooks signalHooks
+
+// add registers fn
+func (h *signalHooks) add(fn func(os.Signal)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.hooks = append(h.hooks, fn)
+}
+
+// run calls every hook with sig
+func (h *signalHooks) run(sig os.Signal) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, fn := range h.hooks {
+		fn(sig)
+	}
+}
+
+// redirectStdout replaces os.Stdout with a pipe whose output is passed to
+// write one line at a time. The returned function flushes the pipe and
+// puts the previous stdout back.
+func redirectStdout(write func(line []byte)) (func(), error) {
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout := os.Stdout
+	os.Stdout = pw
+
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				write(line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		os.Stdout = stdout
+		pw.Close()
+		<-copied
+		r.Close()
+	}, nil
+}
@@ -2,58 +2,203 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
-	"regexp"
-	"runtime"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-)
 
-// Config represents the configuration structure
-type Config struct {
-	DiscordWebhookURL string `json:"discord_webhook_url"`
-}
+	"ping-monitor/api"
+)
 
 // PingResult represents a single ping result
 type PingResult struct {
+	// Timestamp is when the probe was sent and Completed when it returned
 	Timestamp    time.Time
+	Completed    time.Time
 	ResponseTime float64
 	Success      bool
+	Throughput   *ifaceThroughput
+	// Address is the address actually probed, which differs from the
+	// target for hostnames
+	Address string
+	// HostBusy marks samples taken while the monitoring host was under load
+	HostBusy bool
+	// Warmup marks samples taken right after startup, which are excluded
+	// from the headline statistics
+	Warmup bool
+	// LoadTest marks samples taken while the bufferbloat test loaded the
+	// line, excluded the same way
+	LoadTest bool
+	// GatewayResponseTime is the gateway's response time in the same
+	// cycle; Paired is set when it was taken (paired_gateway_probe)
+	GatewayResponseTime float64
+	Paired              bool
+}
+
+// excluded reports whether the sample is kept out of the statistics
+func (r PingResult) excluded() bool {
+	return r.Warmup || r.LoadTest
 }
 
 // PingMonitor handles ping monitoring functionality
 type PingMonitor struct {
 	targetIP         string
 	pingInterval     time.Duration
-	pingResults      []PingResult
+	samples          *periodSamples
 	unreachableTimes []time.Time
+	failureGateways  []gatewayState
 	running          bool
 	stopChan         chan struct{}
 	mutex            sync.RWMutex
 	config           Config
-	defaultGateway   string
+	gateways         []string
 	localIP          string
+	api              *apiServer
+	prober           Prober
+	faults           *faultInjector
+	simulatedCount   int
+	warmupCount      int
+	warmupUntil      time.Time
+	store            Store
+	reports          *reportCoordinator
+	history          *historyStore
+	collector        *collector
+	iface            *ifaceSampler
+	outages          *outageTracker
+	outageSnooze     *outageSnooze
+	series           *seriesStore
+	resolver         *targetResolver
+	targetAddr       string
+	outbox           *outbox
+	// sendCtx bounds webhook sends; it is cancelled at the shutdown deadline
+	sendCtx      context.Context
+	cancelSends  context.CancelFunc
+	shuttingDown atomic.Bool
+	httpClient   *http.Client
+	hostLoad     *hostLoadSampler
+	latency      *latencyTracker
+	trend        *trendDetector
+	measurement  *measurementTracker
+	audit        *auditLog
+	// qualityBaseline caches the baseline of the quality score
+	qualityBaseline qualityBaseline
+	failureLog      *failureLog
+	// feed hands each result to live stream clients (tail)
+	feed *resultFeed
+	// gatewayProber pings the gateways when probe_command or tcp_probe
+	// replaces prober; nil uses prober
+	gatewayProber Prober
+	// clock holds reports back while the clock is implausibly early;
+	// unanchoredCount counts the samples its correction had to drop
+	clock           *clockGuard
+	unanchoredCount int
+	// outageEventID is the event log ID of the current outage's alert,
+	// outageConnection the connection it began on
+	outageEventID    uint64
+	outageConnection connection
+	// connection is the connection of the default route; periodConnection
+	// the one the period began on, and connectionChanges its switches
+	connection        connection
+	periodConnection  connection
+	connectionChanges []connectionChange
+	// reportDelivery and confirmedDelivery are the last daily report sent
+	// to Discord and the last one Discord confirmed (see delivery.go)
+	reportDelivery    atomic.Pointer[ReportDelivery]
+	confirmedDelivery atomic.Pointer[ReportDelivery]
+	// targetLegs ping the additional targets; legs waits for their loops
+	targetLegs []*targetLeg
+	legs       sync.WaitGroup
+	// pingBackend tells how pings are sent (see icmp.go)
+	pingBackend string
+	// lastSuccess and lastSuccessRTT are the last successful probe of the
+	// target, which the outage alert states
+	lastSuccess    time.Time
+	lastSuccessRTT float64
+	// unanchoredResults hold the successes taken while waiting for the
+	// clock, fed to samples once their time is known (see clock.go);
+	// recent keeps the latest results for /debug/state
+	unanchoredResults []PingResult
+	// unanchoredFailures is how many of the latest unreachableTimes were
+	// taken while waiting and are not in samples yet
+	unanchoredFailures int
+	recent             recentResults
+	// periodStart is when the current period began: the start of
+	// monitoring, then the end of the period taken last
+	periodStart time.Time
+	// bloat runs the bufferbloat test, nil when not configured; its runs
+	// and the samples it kept out of the statistics are the period's
+	// (see bufferbloat.go)
+	bloat         *bloatTester
+	bufferbloat   []BufferbloatResult
+	loadTestCount int
+	// hostBusyCount counts samples of the period taken under host load
+	hostBusyCount int
+	// failureReasons and periodOutages break the period's failures down
+	failureReasons reasonCounts
+	// measurementSpans are the period's probes that failed in the monitor
+	// itself, kept out of the failures above
+	measurementSpans []measurementSpan
+	periodOutages    []OutageRecord
+	metrics          *probeMetrics
+	startedAt        time.Time
+	snmp             *snmpAgent
+	style            textStyle
+	format           numberFormat
+	vantages         []*remoteVantage
+	route            *routeTracker
+	rdns             *reverseDNS
+	hopProbe         func(addr string, ttl int) string
+	routeChanges     []routeChange
+	// wanErrors are the router's WAN counter deltas of the period
+	wanErrors []wanErrorSample
+	// scheduleOverruns, scheduleGaps and scheduleGapTime count the
+	// period's slots lost to late cycles and to suspensions
+	scheduleOverruns int
+	scheduleGaps     int
+	scheduleGapTime  time.Duration
+	addressChanges   []addressChange
+	// lastCycle and cycles tell the watchdog the ping loop is progressing;
+	// loopStop ends the current loop, stalled is set while it is stuck
+	lastCycle         atomic.Pointer[time.Time]
+	cycles            atomic.Int64
+	loopStop          chan struct{}
+	stalled           atomic.Bool
+	stallCycles       int64
+	watchdogRestarted bool
+	// lastDay summarizes the last finished day for /status
+	lastDay         atomic.Pointer[StatusDay]
+	resolveFailures int
+	gatewayState    gatewayState
+	// detection records how the gateway and local IP were found
+	detection  DetectionStatus
+	dispatcher *dispatcher
+	now        func() time.Time
+	// profile names the instance when the config defines profiles
+	profile string
 }
 
 // DiscordEmbed represents Discord embed structure
 type DiscordEmbed struct {
-	Title       string        `json:"title"`
-	Description string        `json:"description"`
-	Color       int           `json:"color"`
-	Fields      []EmbedField  `json:"fields"`
-	Timestamp   string        `json:"timestamp"`
-	Footer      EmbedFooter   `json:"footer"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Color       int          `json:"color"`
+	Fields      []EmbedField `json:"fields"`
+	Timestamp   string       `json:"timestamp"`
+	Footer      EmbedFooter  `json:"footer"`
+	Image       *EmbedImage  `json:"image,omitempty"`
 }
 
 // EmbedField represents Discord embed field
@@ -63,6 +208,11 @@ type EmbedField struct {
 	Inline bool   `json:"inline"`
 }
 
+// EmbedImage represents Discord embed image
+type EmbedImage struct {
+	URL string `json:"url"`
+}
+
 // EmbedFooter represents Discord embed footer
 type EmbedFooter struct {
 	Text string `json:"text"`
@@ -74,249 +224,644 @@ type DiscordMessage struct {
 }
 
 // NewPingMonitor creates a new PingMonitor instance
-func NewPingMonitor(configFile string) (*PingMonitor, error) {
+func NewPingMonitor(configFile string, overrides ConfigOverrides) (*PingMonitor, error) {
+	cfg, err := readConfig(configFile, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return newPingMonitor(cfg, "")
+}
+
+// newPingMonitor creates a monitor for an effective configuration; profile
+// is "" for a config without profiles
+func newPingMonitor(cfg Config, profile string) (*PingMonitor, error) {
+	if startupCapabilities != nil {
+		startupCapabilities.restrict(&cfg)
+	}
 	pm := &PingMonitor{
-		targetIP:     "8.8.8.8",
-		pingInterval: 1 * time.Second,
+		profile:      profile,
+		pingInterval: cfg.PingInterval.Duration(),
 		running:      true,
 		stopChan:     make(chan struct{}),
+		gatewayState: gatewayUnknown,
 	}
-
-	// Load configuration
-	if err := pm.loadConfig(configFile); err != nil {
+	prober, backend, err := newPingProber(cfg.PingBackend, cfg.pingTimeout())
+	if err != nil {
 		return nil, err
 	}
+	pm.pingBackend = backend
+	pm.faults = newFaultInjector(prober)
+	pm.prober = pm.faults
+	pm.now = time.Now
+	pm.sendCtx, pm.cancelSends = context.WithCancel(context.Background())
+	pm.httpClient = &http.Client{Timeout: 30 * time.Second}
+	pm.series = newSeriesStore()
+	pm.reports = newReportCoordinator(pm, pm.now())
+	pm.periodStart = pm.now()
+
+	pm.setConfig(cfg)
+	if cfgJSON, err := json.Marshal(redactConfig(pm.config)); err == nil {
+		fmt.Printf("設定: %s\n", cfgJSON)
+	}
+	pm.clock = newClockGuard(pm.config.clockFloor(), pm.now())
+	if pm.clock.waitingForClock() {
+		fmt.Printf("⚠️ 時計が %s より前です（%s）。時計が合うまでレポートの締めを保留し、計測は続けます\n",
+			pm.clock.floor.Format(reportDateLayout), pm.now().Format("2006-01-02 15:04:05"))
+	}
+	pm.targetIP = pm.config.Target
+	if pm.config.ProbeCommand != nil {
+		// The command is meant for the target; gateways are still pinged
+		pm.prober = newCommandProber(*pm.config.ProbeCommand)
+		pm.gatewayProber = pm.faults
+	}
+	if pm.config.TCPProbe != nil {
+		pm.prober = newTCPProber(*pm.config.TCPProbe)
+		pm.gatewayProber = pm.faults
+	}
+	resolveInterval := pm.config.TargetResolveInterval.Duration()
+	pm.resolver = newTargetResolver(pm.targetIP, resolveInterval)
+	if !pm.resolver.static() {
+		// Shown at startup and in the first reports. A name DNS says does
+		// not exist is a mistake; otherwise DNS may not be up yet, and the
+		// loop keeps trying.
+		if err := pm.resolver.prime(pm.now()); nameNotFound(err) {
+			return nil, fmt.Errorf("監視対象 %s の名前が見つかりません: %v", pm.targetIP, err)
+		} else if err != nil {
+			fmt.Printf("⚠️ 監視対象 %s の名前解決に失敗しました（解決できるまで到達不能として扱います）: %v\n", pm.targetIP, err)
+		} else {
+			pm.targetAddr = pm.resolver.addr
+			fmt.Printf("監視対象: %s (%s)\n", pm.targetIP, pm.targetAddr)
+		}
+	}
 
-	// Get default gateway
-	pm.defaultGateway = pm.getDefaultGateway()
-	fmt.Printf("デフォルトゲートウェイ: %s\n", pm.defaultGateway)
+	if pm.config.StateDir != "" {
+		pm.history = newHistoryStore(pm.config.StateDir)
+		pm.loadDeliveries()
+		pm.outbox = newOutbox(pm.config.StateDir)
+	}
 
-	// Get local IP
-	pm.localIP = pm.getLocalIP()
-	fmt.Printf("送信元IPアドレス: %s\n", pm.localIP)
+	quiet, _ := newQuietHours(pm.config.QuietHours)
+	var notifiers []Notifier
+	if pm.config.webhookConfigured() {
+		notifiers = append(notifiers, &discordNotifier{pm: pm})
+	}
+	if pm.config.Gotify != nil {
+		n, err := newGotifyNotifier(*pm.config.Gotify)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	if pm.config.Bark != nil {
+		notifiers = append(notifiers, newBarkNotifier(*pm.config.Bark))
+	}
+	if pm.config.Twilio != nil {
+		notifiers = append(notifiers, newTwilioNotifier(*pm.config.Twilio))
+	}
+	var events *eventLog
+	if pm.config.StateDir != "" {
+		var err error
+		if events, err = newEventLog(pm.config.StateDir); err != nil {
+			fmt.Printf("❌ イベントログを開けません（再送は無効）: %v\n", err)
+		}
+	}
+	pm.dispatcher = newDispatcher(notifiers, quiet, events, pm.style, func() time.Time { return pm.now() })
+	pm.dispatcher.debug = pm.config.NotifyDebug
+	pm.outages = newOutageTracker(pm.config.FailureThreshold, pm.config.RecoveryThreshold, pm.now())
+	pm.latency = newLatencyTracker(pm.config.LatencyWarnMs, pm.config.LatencyCriticalMs, pm.config.LatencyAlertSamples)
+	pm.trend = newTrendDetector(pm.config.LossTrendSlope)
+	pm.measurement = newMeasurementTracker(pm.config.MeasurementErrorThreshold)
+	pm.outageSnooze = newOutageSnooze()
+	pm.audit = newAuditLog(pm.config.StateDir)
+	logReopenHooks.add(func(sig os.Signal) {
+		pm.audit.record(AuditEntry{Action: "log_reopen", Actor: signalActor(sig)})
+	})
+	pm.failureLog = newFailureLog(pm.config.FailureOutputKeep)
+	pm.feed = newResultFeed()
+	pm.hostLoad = newHostLoadSampler()
+	pm.metrics = newProbeMetrics()
+	pm.startedAt = time.Now()
+	pm.route = newRouteTracker()
+	pm.samples = newPeriodSamples(pm.pingInterval)
+	if pm.config.Bufferbloat != nil {
+		pm.bloat = newBloatTester(*pm.config.Bufferbloat)
+	}
+	pm.rdns = newReverseDNS(pm.config.ReverseDNS)
+	pm.hopProbe = probeHop
+	for _, v := range pm.config.Vantages {
+		pm.vantages = append(pm.vantages, newRemoteVantage(v, pm.config.StateDir))
+	}
+	for _, t := range pm.config.Targets {
+		pm.targetLegs = append(pm.targetLegs, newTargetLeg(t, resolveInterval))
+	}
 
-	return pm, nil
-}
+	if pm.config.Collector.Enabled {
+		if pm.config.HTTPListen == "" {
+			fmt.Println("警告: collectorが有効ですがhttp_listenが未設定のため、他サイトからの受信はできません。")
+		}
+		pm.collector = newCollector(pm)
+	}
 
-// loadConfig loads configuration from file
-func (pm *PingMonitor) loadConfig(configFile string) error {
-	data, err := os.ReadFile(configFile)
+	store, err := openStore(pm.config, true)
 	if err != nil {
-		return fmt.Errorf("設定ファイル %s が見つかりません: %v", configFile, err)
+		return nil, err
 	}
-
-	if err := json.Unmarshal(data, &pm.config); err != nil {
-		return fmt.Errorf("設定ファイル %s の形式が正しくありません: %v", configFile, err)
+	pm.store = store
+
+	// Determine gateway candidates and the local IP
+	pm.detectNetwork()
+	pm.warnTargetPlacement()
+	pm.connection = detectConnection()
+	pm.periodConnection = pm.connection
+	if pm.connection.Type != connUnknown {
+		fmt.Printf("接続方式: %s\n", pm.connection)
 	}
+	fmt.Printf("pingの方式: %s\n", pm.pingBackend)
 
-	if pm.config.DiscordWebhookURL == "" || strings.Contains(pm.config.DiscordWebhookURL, "YOUR_WEBHOOK") {
-		fmt.Println("警告: Discord Webhook URLが設定されていません。config.jsonを編集してください。")
+	pm.iface = newIfaceSampler()
+	if pm.iface != nil {
+		fmt.Printf("インターフェース: %s\n", pm.iface.name)
 	}
 
-	return nil
+	return pm, nil
 }
 
-// getDefaultGateway gets the default gateway IP address
-func (pm *PingMonitor) getDefaultGateway() string {
-	var cmd *exec.Cmd
-	
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("route", "print", "0.0.0.0")
-	} else {
-		// Try ip route first
-		cmd = exec.Command("ip", "route", "show", "default")
+// gatewayLabel returns the gateway candidates for reports
+func (pm *PingMonitor) gatewayLabel() string {
+	if len(pm.gateways) == 0 {
+		return "ゲートウェイ不明"
 	}
-
-	output, err := cmd.Output()
-	if err == nil {
-		if runtime.GOOS == "windows" {
-			// Parse Windows route output
-			lines := strings.Split(string(output), "\n")
-			for _, line := range lines {
-				if strings.Contains(line, "0.0.0.0") && !strings.Contains(line, "Gateway") {
-					fields := strings.Fields(line)
-					if len(fields) >= 3 {
-						return fields[2]
-					}
-				}
-			}
-		} else {
-			// Parse Linux ip route output
-			re := regexp.MustCompile(`default via (\d+\.\d+\.\d+\.\d+)`)
-			if match := re.FindStringSubmatch(string(output)); len(match) > 1 {
-				return match[1]
-			}
+	labels := make([]string, len(pm.gateways))
+	for i, gw := range pm.gateways {
+		labels[i] = gw
+		if gw == pm.targetIP || gw == pm.resolver.addr {
+			labels[i] += "（監視対象と同一）"
 		}
 	}
+	return strings.Join(labels, ", ")
+}
 
-	// Fallback to route command for older Linux systems
-	if runtime.GOOS != "windows" {
-		cmd = exec.Command("route", "-n")
-		if output, err := cmd.Output(); err == nil {
-			lines := strings.Split(string(output), "\n")
-			for _, line := range lines {
-				if strings.HasPrefix(line, "0.0.0.0") {
-					fields := strings.Fields(line)
-					if len(fields) >= 2 {
-						return fields[1]
-					}
-				}
-			}
+// warnTargetPlacement warns about targets that make the diagnostics or the
+// statistics meaningless: the gateway itself, or the monitoring host
+func (pm *PingMonitor) warnTargetPlacement() {
+	addr := pm.resolver.addr
+	for _, gw := range pm.gateways {
+		if gw == addr || gw == pm.targetIP {
+			fmt.Printf("注意: 監視対象 %s はゲートウェイと同じため、到達不能時のゲートウェイ診断では再pingしません。\n", pm.targetIP)
+			break
 		}
 	}
-
-	return "192.168.1.1" // Fallback
+	if isLocalTarget(pm.targetIP, addr, pm.localIP) {
+		fmt.Printf("警告: 監視対象 %s はこのホスト自身です。応答は常に即時となり、統計は回線の状態を表しません。\n", pm.targetIP)
+	}
 }
 
-// getLocalIP gets the local IP address
-func (pm *PingMonitor) getLocalIP() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// isLocalTarget reports whether target (or its resolved addr) is the
+// monitoring host: a loopback address, "localhost", or one of its own
+// interface addresses
+func isLocalTarget(target, addr, localIP string) bool {
+	if strings.EqualFold(target, "localhost") {
+		return true
+	}
+	if addr == "" {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() || addr == localIP {
+		return true
+	}
+	ifaceAddrs, err := net.InterfaceAddrs()
 	if err != nil {
-		return "不明"
+		return false
 	}
-	defer conn.Close()
-
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String()
+	for _, a := range ifaceAddrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
 
-// pingHost pings the specified host and returns response time in milliseconds
-func (pm *PingMonitor) pingHost(host string) (float64, error) {
-	var cmd *exec.Cmd
-	
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("ping", "-n", "1", "-w", "3000", host)
-	} else {
-		cmd = exec.Command("ping", "-c", "1", "-W", "3", host)
+// pingLoop runs the main ping monitoring loop until the monitor stops or
+// stop is closed by the watchdog
+func (pm *PingMonitor) pingLoop(stop chan struct{}) {
+	schedule := newProbeSchedule(time.Now(), pm.pingInterval)
+	pm.markSchedule(scheduleMark{Time: time.Now(), Kind: markStart})
+	timer := time.NewTimer(time.Until(schedule.deadline()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-pm.stopChan:
+			return
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+		now := time.Now()
+		step := schedule.take(now)
+		// Setting a clock that was wrong since boot is no suspension
+		if pm.checkClock(now) {
+			schedule.anchor(now)
+			step = scheduleStep{Slot: schedule.slot(0)}
+		}
+		pm.recordScheduleStep(step)
+		pm.tick(step.Slot)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		pm.completeCycle()
+		timer.Reset(time.Until(schedule.deadline()))
 	}
+}
 
-	start := time.Now()
-	output, err := cmd.Output()
-	duration := time.Since(start)
+// tick runs one monitoring cycle for the scheduled slot. The sample is
+// stamped with the time the probe was actually sent, which trails the slot
+// by the timer latency and the name resolution, and also keeps the time
+// the probe completed.
+func (pm *PingMonitor) tick(slot time.Time) {
+	// Resolve hostname targets; failures keep the cached address
+	addr, change, resolveErr := pm.resolver.resolve(slot)
+	sent := pm.now()
+
+	// Finalize the previous day if it changed
+	pm.reports.rollover(sent)
+	if resolveErr != nil {
+		fmt.Printf("%s - 名前解決エラー（%s）: %v\n", sent.Format("15:04:05"), pm.targetIP, resolveErr)
+	}
+	if change != nil {
+		pm.notifyAddressChange(change)
+	}
 
+	// Ping the target
+	var responseTime float64
+	var err error
+	switch {
+	case pm.faults.active(pm.targetIP):
+		err = errSimulatedFailure
+	case addr == "":
+		err = errNoAddress
+	default:
+		responseTime, err = pm.prober.Probe(addr)
+	}
+	completed := pm.now()
+	var reason failureReason
 	if err != nil {
-		return 0, err
+		reason = classifyFailure(err)
+		pm.failureLog.add(pm.targetIP, FailureOutput{Time: sent, Address: addr, Reason: string(reason), Output: probeOutput(err)})
+	}
+	// The gateway is probed right after the target, so that both answers
+	// describe the same moment of the line
+	var gatewayRTT float64
+	paired := false
+	if err == nil && pm.config.PairedGatewayProbe {
+		gatewayRTT, paired = pm.probeGatewayPaired(addr)
 	}
 
-	// Parse response time from output
-	if runtime.GOOS == "windows" {
-		re := regexp.MustCompile(`時間[<>=]*(\d+)ms`)
-		if match := re.FindStringSubmatch(string(output)); len(match) > 1 {
-			if ms, err := strconv.ParseFloat(match[1], 64); err == nil {
-				return ms, nil
-			}
+	var throughput *ifaceThroughput
+	if t, ok := pm.iface.sample(time.Now()); ok {
+		throughput = &t
+	}
+	var load *hostLoad
+	if l, ok := pm.hostLoad.sample(); ok {
+		load = &l
+	}
+	hostBusy := load != nil && load.high(pm.config.HostLoadThreshold)
+
+	inWarmup := sent.Before(pm.warmupUntil)
+	// Samples taken while the bufferbloat test loads the line are kept out
+	// like those of the warm-up
+	loadTest := !inWarmup && pm.bloat.loading()
+	// Until the clock is set the hour of a sample is unknown
+	unanchored := pm.clock != nil && pm.clock.waitingForClock()
+	warmupLabel := ""
+	if inWarmup {
+		warmupLabel = " (ウォームアップ)"
+	} else if loadTest {
+		warmupLabel = " (負荷試験中)"
+	}
+
+	pm.mutex.Lock()
+	if inWarmup {
+		pm.warmupCount++
+	} else if loadTest {
+		pm.loadTestCount++
+	} else if hostBusy {
+		pm.hostBusyCount++
+	}
+	pm.targetAddr = addr
+	if resolveErr != nil {
+		pm.resolveFailures++
+	}
+	if change != nil {
+		pm.addressChanges = append(pm.addressChanges, *change)
+	}
+	if err == nil {
+		pm.lastSuccess, pm.lastSuccessRTT = sent, responseTime
+		result := PingResult{
+			Timestamp:           sent,
+			Completed:           completed,
+			ResponseTime:        responseTime,
+			Success:             true,
+			Throughput:          throughput,
+			Warmup:              inWarmup,
+			LoadTest:            loadTest,
+			Address:             addr,
+			HostBusy:            hostBusy,
+			GatewayResponseTime: gatewayRTT,
+			Paired:              paired,
+		}
+		if unanchored {
+			pm.unanchoredResults = append(pm.unanchoredResults, result)
+		} else {
+			pm.samples.add(result)
+		}
+		if paired {
+			fmt.Printf("%s - %s ping: %.1fms（ゲートウェイ %.1fms）%s\n", sent.Format("15:04:05"), pm.targetIP, responseTime, gatewayRTT, warmupLabel)
+		} else {
+			fmt.Printf("%s - %s ping: %.1fms%s\n", sent.Format("15:04:05"), pm.targetIP, responseTime, warmupLabel)
+		}
+		if responseTime >= pm.config.LatencyWarnMs && throughput != nil {
+			fmt.Printf("  -> 応答遅延時の回線: %s\n", throughput)
 		}
+		if responseTime >= pm.config.LatencyWarnMs && load != nil {
+			fmt.Printf("  -> 応答遅延時のホスト負荷: %s\n", load)
+		}
+	} else if inWarmup || loadTest {
+		fmt.Printf("%s - %s 到達不能%s\n", sent.Format("15:04:05"), pm.targetIP, warmupLabel)
+	} else if reason == reasonMeasurement {
+		// The network was not measured, so this is no failure of the target
+		pm.measurementSpans = addMeasurementSpan(pm.measurementSpans, sent, pm.pingInterval)
+		fmt.Printf("%s - 計測エラー（%s）\n", sent.Format("15:04:05"), describeMeasurementError(err))
 	} else {
-		re := regexp.MustCompile(`time=(\d+\.?\d*).*ms`)
-		if match := re.FindStringSubmatch(string(output)); len(match) > 1 {
-			if ms, err := strconv.ParseFloat(match[1], 64); err == nil {
-				return ms, nil
+		// Target unreachable
+		pm.unreachableTimes = append(pm.unreachableTimes, sent)
+		if unanchored {
+			pm.unanchoredFailures++
+		} else {
+			pm.samples.addFailure(sent)
+		}
+		pm.failureReasons.add(reason)
+		if errors.Is(err, errSimulatedFailure) {
+			pm.simulatedCount++
+			fmt.Printf("%s - %s 到達不能 [SIMULATED]\n", sent.Format("15:04:05"), pm.targetIP)
+		} else {
+			fmt.Printf("%s - %s 到達不能（%s）\n", sent.Format("15:04:05"), pm.targetIP, reason.label())
+		}
+
+		// Ping gateway candidates in order until one responds. A gateway that
+		// is the target has just failed, so it is not pinged again.
+		pm.gatewayState = gatewayUnknown
+		gatewayProber := pm.gatewayProbe()
+		for _, gw := range pm.gateways {
+			if gw == addr {
+				pm.gatewayState = gatewayIsTarget
+				continue
 			}
+			if gwResponse, gwErr := gatewayProber.Probe(gw); gwErr == nil {
+				fmt.Printf("  -> デフォルトゲートウェイ(%s): %.1fms\n", gw, gwResponse)
+				pm.gatewayState = gatewayReachable
+				break
+			}
+			fmt.Printf("  -> デフォルトゲートウェイ(%s): 到達不能\n", gw)
+			pm.gatewayState = gatewayUnreachable
+		}
+		pm.failureGateways = append(pm.failureGateways, pm.gatewayState)
+		for _, line := range pm.legLines() {
+			fmt.Printf("  -> %s\n", line)
+		}
+		if throughput != nil {
+			fmt.Printf("  -> インターフェース(%s): %s\n", pm.iface.name, throughput)
 		}
 	}
+	gw := pm.gatewayState
+	pm.mutex.Unlock()
 
-	// If parsing failed, use measured duration
-	return float64(duration.Nanoseconds()) / 1000000, nil
-}
-
-// pingLoop runs the main ping monitoring loop
-func (pm *PingMonitor) pingLoop() {
-	fmt.Printf("Google(%s)へのpingモニタリングを開始します...\n", pm.targetIP)
-	fmt.Println("Ctrl+Cで停止できます")
-
-	lastDay := time.Now().Format("2006-01-02")
-	ticker := time.NewTicker(pm.pingInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-pm.stopChan:
-			return
-		case now := <-ticker.C:
-			currentDate := now.Format("2006-01-02")
-
-			// Check if day changed
-			if currentDate != lastDay && len(pm.pingResults) > 0 {
-				pm.sendDailyReport(lastDay)
-				pm.resetDailyData()
-				lastDay = currentDate
-			}
+	if !inWarmup && !loadTest {
+		pm.metrics.observe(sent, reason, responseTime)
+		pm.checkMeasurement(sent, reason, err)
+	}
+	if !inWarmup && !loadTest && reason != reasonMeasurement {
+		pm.series.add(pm.targetIP, sent, reason, responseTime)
+		if tr := pm.outages.observe(sent, reason, gw); tr != nil {
+			pm.notifyOutageTransition(tr)
+		}
+		if tr := pm.latency.observe(sent, err == nil, responseTime); tr != nil {
+			pm.notifyLatencyTransition(tr, throughput, load)
+		}
+		pm.checkLossTrend(sent)
+	}
 
-			// Ping Google
-			responseTime, err := pm.pingHost(pm.targetIP)
-			
-			pm.mutex.Lock()
-			if err == nil {
-				pm.pingResults = append(pm.pingResults, PingResult{
-					Timestamp:    now,
-					ResponseTime: responseTime,
-					Success:      true,
-				})
-				fmt.Printf("%s - Google ping: %.1fms\n", now.Format("15:04:05"), responseTime)
-			} else {
-				// Google unreachable
-				pm.unreachableTimes = append(pm.unreachableTimes, now)
-				fmt.Printf("%s - Google到達不能\n", now.Format("15:04:05"))
-
-				// Ping default gateway
-				if pm.defaultGateway != "" {
-					if gwResponse, gwErr := pm.pingHost(pm.defaultGateway); gwErr == nil {
-						fmt.Printf("  -> デフォルトゲートウェイ(%s): %.1fms\n", pm.defaultGateway, gwResponse)
-					} else {
-						fmt.Printf("  -> デフォルトゲートウェイ(%s): 到達不能\n", pm.defaultGateway)
-					}
-				}
-			}
-			pm.mutex.Unlock()
+	{
+		rec := ResultRecord{
+			Timestamp:    sent,
+			CompletedAt:  completed,
+			Target:       pm.targetIP,
+			Success:      err == nil,
+			ResponseTime: responseTime,
+			Simulated:    errors.Is(err, errSimulatedFailure),
+			Warmup:       inWarmup,
+			LoadTest:     loadTest,
+			Reason:       string(reason),
+		}
+		if paired {
+			rec.GatewayResponseTime = gatewayRTT
+		}
+		if err != nil && !inWarmup && !loadTest {
+			rec.Gateway = string(gw)
+		}
+		if addr != pm.targetIP {
+			rec.Address = addr
 		}
+		if werr := pm.store.AppendResult(rec); werr != nil {
+			fmt.Printf("❌ 結果ファイル書き込みエラー: %v\n", werr)
+		}
+		pm.feed.publish(rec)
+		pm.recent.add(rec)
 	}
 }
 
-// resetDailyData resets daily statistics
-func (pm *PingMonitor) resetDailyData() {
+// takePeriod atomically detaches the accumulated data as a reportPeriod
+// for date and resets the daily statistics
+func (pm *PingMonitor) takePeriod(date string) *reportPeriod {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
-	pm.pingResults = []PingResult{}
+	p := pm.periodLocked(date)
+	p.Targets = pm.targetPeriods(date, true)
+	p.Deferred = pm.dispatcher.takeDeferred()
+	pm.periodConnection = pm.connection
+	pm.connectionChanges = nil
+	p.RouteFlaps = pm.route.takeFlaps()
+	pm.periodStart = p.End
+	pm.samples = newPeriodSamples(pm.pingInterval)
+	pm.unanchoredResults = nil
+	pm.unanchoredFailures = 0
 	pm.unreachableTimes = []time.Time{}
+	pm.failureGateways = nil
+	pm.simulatedCount = 0
+	pm.warmupCount = 0
+	pm.unanchoredCount = 0
+	pm.hostBusyCount = 0
+	pm.loadTestCount = 0
+	pm.bufferbloat = nil
+	pm.failureReasons = reasonCounts{}
+	pm.measurementSpans = nil
+	pm.periodOutages = nil
+	pm.addressChanges = nil
+	pm.resolveFailures = 0
+	pm.routeChanges = nil
+	pm.wanErrors = nil
+	pm.scheduleOverruns, pm.scheduleGaps, pm.scheduleGapTime = 0, 0, 0
+	pm.audit.resetCount()
+	return p
 }
 
-// sendDailyReport sends daily statistics to Discord
-func (pm *PingMonitor) sendDailyReport(reportDate string) {
+// currentPeriod returns the data collected so far without ending the
+// period, for interim reports on request
+func (pm *PingMonitor) currentPeriod() *reportPeriod {
+	day := pm.now().Format(reportDateLayout)
 	pm.mutex.RLock()
 	defer pm.mutex.RUnlock()
+	p := pm.periodLocked(day)
+	p.Targets = pm.targetPeriods(day, false)
+	p.Interim = true
+	return p
+}
 
-	if pm.config.DiscordWebhookURL == "" || strings.Contains(pm.config.DiscordWebhookURL, "YOUR_WEBHOOK") {
+// samplesLocked returns a copy of the period's samples, with those still
+// waiting for the clock at the times they were taken; the caller holds
+// pm.mutex
+func (pm *PingMonitor) samplesLocked() *periodSamples {
+	s := pm.samples.clone()
+	if len(pm.unanchoredResults) > 0 || pm.unanchoredFailures > 0 {
+		replaySamples(s, pm.unanchoredResults, pm.unanchoredFailureTimesLocked())
+	}
+	return s
+}
+
+// unanchoredFailureTimesLocked returns the failures not in samples yet;
+// the caller holds pm.mutex
+func (pm *PingMonitor) unanchoredFailureTimesLocked() []time.Time {
+	return pm.unreachableTimes[len(pm.unreachableTimes)-pm.unanchoredFailures:]
+}
+
+// successCountLocked counts the period's successes as the report does,
+// without the warm-up and load test; the caller holds pm.mutex
+func (pm *PingMonitor) successCountLocked() int {
+	n := pm.samples.latency.count
+	for _, r := range pm.unanchoredResults {
+		if !r.excluded() {
+			n++
+		}
+	}
+	return n
+}
+
+// periodLocked builds a period from the current data; the caller holds
+// pm.mutex. The slices are only appended to, so sharing them is safe, and
+// the samples are copied.
+func (pm *PingMonitor) periodLocked(date string) *reportPeriod {
+	p := &reportPeriod{
+		Date:             date,
+		Samples:          pm.samplesLocked(),
+		UnreachableTimes: pm.unreachableTimes,
+		FailureGateways:  pm.failureGateways,
+		SimulatedCount:   pm.simulatedCount,
+		WarmupCount:      pm.warmupCount,
+		UnanchoredCount:  pm.unanchoredCount,
+		HostBusyCount:    pm.hostBusyCount,
+		LoadTestCount:    pm.loadTestCount,
+		Bufferbloat:      pm.bufferbloat,
+		MeasurementSpans: pm.measurementSpans,
+		FailureReasons:   pm.failureReasons,
+		Outages:          pm.periodOutages,
+		AddressChanges:   pm.addressChanges,
+		ResolveFailures:  pm.resolveFailures,
+		RouteChanges:     pm.routeChanges,
+		WANErrors:        pm.wanErrors,
+		ScheduleOverruns: pm.scheduleOverruns,
+		ScheduleGaps:     pm.scheduleGaps,
+		ScheduleGapTime:  pm.scheduleGapTime,
+		ControlActions:   pm.audit.periodCount(),
+		Format:           pm.format,
+		Interval:         pm.pingInterval,
+		ProbeTimeout:     pm.config.probeTimeout(),
+	}
+	p.Start, p.End = dayBounds(date)
+	if pm.periodStart.After(p.Start) {
+		p.Start = pm.periodStart
+	}
+	if now := pm.now(); now.Before(p.End) {
+		p.End = now
+	}
+	p.OpenOutage, p.OpenOutageSimulated = pm.outages.inProgress()
+	p.Connection, p.ConnectionChanges = pm.periodConnection, pm.connectionChanges
+	minCoverage := pm.config.MinReportCoverage.Duration()
+	p.Coverage = computeCoverage(p, pm.pingInterval, minCoverage, pm.config.MinReportSamples)
+	return p
+}
+
+// sendDailyReport sends daily statistics to Discord
+func (pm *PingMonitor) sendDailyReport(p *reportPeriod) {
+	if !pm.config.webhookConfigured() {
 		fmt.Println("Discord Webhook URLが設定されていないため、レポートをコンソールに出力します：")
-		pm.printDailyReport(reportDate)
+		pm.printDailyReport(p)
+		return
+	}
+	if ok, _ := pm.config.DiscordRouting.allows(Event{Kind: EventReport, Severity: SeverityInfo}); !ok {
+		fmt.Println("discord_routing の設定により、レポートをDiscordに送らずコンソールに出力します：")
+		pm.printDailyReport(p)
 		return
 	}
 
-	// Calculate statistics
-	totalPings := len(pm.pingResults) + len(pm.unreachableTimes)
-	successRate := 0.0
-	if totalPings > 0 {
-		successRate = float64(len(pm.pingResults)) / float64(totalPings) * 100
-	}
-
-	var avgTime, maxTime, minTime float64
-	if len(pm.pingResults) > 0 {
-		var sum float64
-		maxTime = pm.pingResults[0].ResponseTime
-		minTime = pm.pingResults[0].ResponseTime
-
-		for _, result := range pm.pingResults {
-			sum += result.ResponseTime
-			if result.ResponseTime > maxTime {
-				maxTime = result.ResponseTime
-			}
-			if result.ResponseTime < minTime {
-				minTime = result.ResponseTime
-			}
-		}
-		avgTime = sum / float64(len(pm.pingResults))
+	message := DiscordMessage{
+		Embeds: []DiscordEmbed{pm.dailyReportEmbed(p)},
+	}
+
+	// Send to Discord, asking for the message back as confirmation
+	d, err := pm.sendReport(p.Date, message)
+	switch {
+	case err != nil:
+		fmt.Printf("❌ Discord送信エラー: %v\n", err)
+		pm.printDailyReport(p)
+	case d.Confirmed:
+		fmt.Printf("✅ %sの日次レポートをDiscordに送信しました\n", p.Date)
+	default:
+		fmt.Printf("⚠️ %sの日次レポートを送信しましたが、配信を確認できませんでした: %s\n", p.Date, d.Error)
 	}
+	// Interim reports are partial days; the day's own report follows
+	if !p.Interim {
+		pm.recordDelivery(d, &message)
+	}
+}
+
+// dailyReportEmbed renders a period as the daily report embed: the short
+// one for a clean day, otherwise the full report
+func (pm *PingMonitor) dailyReportEmbed(p *reportPeriod) DiscordEmbed {
+	if pm.uncleanReason(p) == "" {
+		return pm.compactReportEmbed(p)
+	}
+	reportDate := p.Date
 
-	unreachableCount := len(pm.unreachableTimes)
+	// Calculate statistics
+	stats := p.probeStats()
+	totalPings := stats.Total
+	successRate := stats.SuccessRate
+	avgTime, maxTime, minTime := stats.Latency.Avg, stats.Latency.Max, stats.Latency.Min
+
+	unreachableCount := len(p.UnreachableTimes)
+
+	f := p.Format
+	latencyValue := fmt.Sprintf("**平均**: %s\n**最大**: %s\n**最小**: %s", f.ms(avgTime), f.ms(maxTime), f.ms(minTime))
+	// Percentiles are meaningless on a handful of samples
+	if p.Coverage.Sufficient {
+		latencyValue += fmt.Sprintf("\n**p95**: %s", f.ms(stats.Latency.P95))
+	}
 
 	// Determine color based on success rate
 	color := 0x00ff00 // Green
@@ -327,159 +872,560 @@ func (pm *PingMonitor) sendDailyReport(reportDate string) {
 		color = 0xff0000 // Red
 	}
 
+	// The score, like p95, needs enough samples to mean anything
+	title := "🌐 Ping Monitor 日次レポート"
+	quality, scored := pm.quality(p)
+	scored = scored && p.Coverage.Sufficient
+	if scored {
+		title += "（" + quality.String() + "）"
+	}
+
 	// Create Discord embed
 	embed := DiscordEmbed{
-		Title:       "🌐 Ping Monitor 日次レポート",
-		Description: fmt.Sprintf("**日付**: %s\n**対象**: Google (8.8.8.8)\n**送信元**: %s", reportDate, pm.localIP),
+		Title:       title,
+		Description: fmt.Sprintf("**日付**: %s\n**対象期間**: %s\n**対象**: %s\n**送信元**: %s\n**ゲートウェイ**: %s", reportDate, f.period(p.Start, p.End), pm.targetLabel(), pm.localIP, pm.gatewayLabel()) + p.connectionNote("\n**接続**: "),
 		Color:       color,
 		Fields: []EmbedField{
 			{
 				Name:   "📊 応答時間統計",
-				Value:  fmt.Sprintf("**平均**: %.1fms\n**最大**: %.1fms\n**最小**: %.1fms", avgTime, maxTime, minTime),
+				Value:  latencyValue,
 				Inline: true,
 			},
 			{
 				Name:   "📈 到達性統計",
-				Value:  fmt.Sprintf("**成功率**: %.2f%%\n**成功回数**: %d\n**失敗回数**: %d", successRate, len(pm.pingResults), unreachableCount),
+				Value:  fmt.Sprintf("**成功率**: %s%s\n**成功回数**: %s\n**失敗回数**: %s", f.percent(successRate, 2), p.Coverage.Marker(), f.count(stats.Success), f.count(unreachableCount)) + p.gatewayNote("\n"),
 				Inline: true,
 			},
 			{
 				Name:   "⏱️ 監視情報",
-				Value:  fmt.Sprintf("**総ping回数**: %d\n**監視間隔**: %v", totalPings, pm.pingInterval),
+				Value:  fmt.Sprintf("**総ping回数**: %s\n**監視間隔**: %v%s", f.count(totalPings), makeDuration(p.Interval), p.warmupNote("\n")+p.loadTestNote("\n")+p.clockNote("\n")+p.measurementNote("\n")+p.hostBusyNote("\n")+p.scheduleNote("\n")),
 				Inline: true,
 			},
 		},
-		Timestamp: time.Now().Format(time.RFC3339),
+		Timestamp: pm.now().Format(time.RFC3339),
 		Footer: EmbedFooter{
-			Text: "Ping Monitor by Go",
+			Text: "Ping Monitor by Go" + p.controlActionsNote() + pm.deliveryNote(p.Date),
 		},
 	}
 
+	embed.Fields = append(embed.Fields, EmbedField{
+		Name:   "🕒 失敗の時刻",
+		Value:  p.formatFailureWindow(),
+		Inline: false,
+	})
+
+	for _, t := range p.Targets {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   fmt.Sprintf("🎯 %s (%s)", t.Name, t.Address),
+			Value:  formatTargetPeriod(t, f),
+			Inline: true,
+		})
+	}
+
+	if diff, ok := p.differential(); ok {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🏠 LAN外で増えた遅延（対象 − ゲートウェイ）",
+			Value:  p.formatDifferential(diff),
+			Inline: false,
+		})
+	}
+
+	if scored {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "⭐ 品質スコア",
+			Value:  fmt.Sprintf("**%.0f/100**\n%s", quality.Score, quality.breakdown(p.Format)),
+			Inline: false,
+		})
+	}
+
+	if spark := p.sparklines(); spark != "" {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "〰️ 1時間ごとの推移",
+			Value:  spark,
+			Inline: false,
+		})
+	}
+
+	if table := pm.worstMinuteTable(p); table != "" {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🔎 時間帯別ワースト",
+			Value:  table,
+			Inline: false,
+		})
+	}
+
+	if lines := p.bufferbloatLines(); len(lines) > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "📶 負荷時の遅延（バッファブロート）",
+			Value:  strings.Join(lines, "\n"),
+			Inline: false,
+		})
+	}
+
 	if unreachableCount > 0 {
-		unreachablePeriods := pm.formatUnreachablePeriods()
+		unreachablePeriods := pm.formatUnreachablePeriods(p)
 		embed.Fields = append(embed.Fields, EmbedField{
 			Name:   "⚠️ 到達不能期間",
 			Value:  unreachablePeriods,
 			Inline: false,
 		})
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🔍 失敗の内訳",
+			Value:  p.FailureReasons.String(),
+			Inline: false,
+		})
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🛰️ 失敗時のゲートウェイ",
+			Value:  p.gatewayCounts().String(),
+			Inline: false,
+		})
 	}
 
-	message := DiscordMessage{
-		Embeds: []DiscordEmbed{embed},
+	if len(p.MeasurementSpans) > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🔧 計測不良",
+			Value:  "監視ホスト側の問題で計測できなかった期間です（損失率には含めません）\n" + formatMeasurementSpans(p.MeasurementSpans),
+			Inline: false,
+		})
 	}
 
-	// Send to Discord
-	if err := pm.sendToDiscord(message); err != nil {
-		fmt.Printf("❌ Discord送信エラー: %v\n", err)
-		pm.printDailyReport(reportDate)
-	} else {
-		fmt.Printf("✅ %sの日次レポートをDiscordに送信しました\n", reportDate)
+	if p.outageCount() > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   fmt.Sprintf("🚨 障害 (%d件)", p.outageCount()),
+			Value:  p.formatPeriodOutages(),
+			Inline: false,
+		})
+	}
+
+	if len(p.AddressChanges) > 0 || p.ResolveFailures > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🔁 IPアドレスの変更",
+			Value:  p.addressSummary(),
+			Inline: false,
+		})
+	}
+
+	if len(p.WANErrors) > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "📡 WAN側のエラー（ルーター）",
+			Value:  summarizeWANErrors(p.WANErrors, p.UnreachableTimes).String(),
+			Inline: false,
+		})
+	}
+
+	if len(p.RouteChanges) > 0 || p.RouteFlaps > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🛤️ 経路の変化（1〜2ホップ目）",
+			Value:  formatRouteChanges(p.RouteChanges, p.RouteFlaps, pm.routeLabels(p.RouteChanges)),
+			Inline: false,
+		})
+	}
+
+	if pm.config.Peer != nil {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🔀 回線比較",
+			Value:  pm.peerComparison(p),
+			Inline: false,
+		})
+	}
+
+	if len(p.Deferred) > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   fmt.Sprintf("🌙 静音時間帯に保留された通知 (%d件)", len(p.Deferred)),
+			Value:  formatDeferredEvents(p.Deferred),
+			Inline: false,
+		})
+	}
+
+	// The banner goes first, so the worse day shows without reading on
+	if r := p.Regression; r != nil {
+		embed.Fields = append([]EmbedField{{
+			Name:   "⚠️ 昨日比で悪化",
+			Value:  strings.Join(r.lines(f), "\n"),
+			Inline: false,
+		}}, embed.Fields...)
+	}
+
+	if p.SimulatedCount > 0 {
+		embed.Title = "[SIMULATED] " + embed.Title
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "🧪 SIMULATED",
+			Value:  fmt.Sprintf("擬似障害による失敗 %d件を含みます", p.SimulatedCount),
+			Inline: false,
+		})
 	}
+
+	return embed
 }
 
-// formatUnreachablePeriods formats unreachable periods
-func (pm *PingMonitor) formatUnreachablePeriods() string {
-	if len(pm.unreachableTimes) == 0 {
+// formatUnreachablePeriods renders the period's runs of failures for the
+// report embed, the longest first
+func (pm *PingMonitor) formatUnreachablePeriods(p *reportPeriod) string {
+	if len(p.UnreachableTimes) == 0 {
 		return "なし"
 	}
 
-	var periods []string
-	maxDisplay := 10
-	for i, t := range pm.unreachableTimes {
-		if i >= maxDisplay {
-			break
+	// Cut at whole lines, keeping room for the "…" marker
+	lines := p.unreachableLines(10)
+	result := lines[0]
+	for _, line := range lines[1:] {
+		if len([]rune(result))+1+len([]rune(line)) > discordFieldValueLimit-2 {
+			return result + "\n…"
 		}
-		periods = append(periods, t.Format("15:04:05"))
+		result += "\n" + line
 	}
+	return result
+}
 
-	result := strings.Join(periods, "\n")
-	if len(pm.unreachableTimes) > maxDisplay {
-		result += fmt.Sprintf("\n... 他%d件", len(pm.unreachableTimes)-maxDisplay)
+// sendToDiscord sends message to Discord webhook, split into as many
+// messages as Discord's size limits require
+func (pm *PingMonitor) sendToDiscord(message DiscordMessage) error {
+	for _, part := range splitDiscordMessage(pm.style.discord(message)) {
+		jsonData, err := json.Marshal(part)
+		if err != nil {
+			return err
+		}
+		if err := pm.deliverDiscord("application/json", jsonData); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// deliverDiscord posts a webhook body; during shutdown a failed send is
+// kept in the outbox for the next start instead of being lost. A message
+// saved there counts as delivered, so the event log does not resend it.
+func (pm *PingMonitor) deliverDiscord(contentType string, body []byte) error {
+	return pm.keepUnsent(contentType, body, pm.postDiscord(contentType, body))
+}
 
-	// Discord field value limit is 1024 characters
-	if len(result) > 1024 {
-		result = result[:1020] + "..."
+// keepUnsent saves body, whose send failed with err during shutdown, in
+// the outbox and returns nil; otherwise it returns err
+func (pm *PingMonitor) keepUnsent(contentType string, body []byte, err error) error {
+	if err != nil && pm.shuttingDown.Load() && pm.outbox != nil {
+		if qerr := pm.outbox.put(contentType, body); qerr != nil {
+			fmt.Printf("❌ 未送信キューへの保存エラー: %v\n", qerr)
+		} else {
+			fmt.Println("📮 送信できなかったメッセージを未送信キューに保存しました（次回起動時に再送）")
+			return nil
+		}
 	}
+	return err
+}
 
-	return result
+// postDiscord posts a webhook body, retrying connection errors and 5xx
+// responses, and waiting as told by retry_after on rate limits
+func (pm *PingMonitor) postDiscord(contentType string, body []byte) error {
+	_, err := pm.postWebhook(pm.config.DiscordWebhookURL, contentType, body)
+	return err
 }
 
-// sendToDiscord sends message to Discord webhook
-func (pm *PingMonitor) sendToDiscord(message DiscordMessage) error {
-	jsonData, err := json.Marshal(message)
+// postWebhook posts body to webhookURL like postDiscord and returns the
+// response body of the successful attempt
+func (pm *PingMonitor) postWebhook(webhookURL, contentType string, body []byte) ([]byte, error) {
+	var created []byte
+	err := retryTransient(func() error {
+		// Past the shutdown deadline there is no point in retrying
+		if err := pm.sendCtx.Err(); err != nil {
+			return fmt.Errorf("Discord送信を中止しました: 終了処理の締め切りを過ぎています")
+		}
+		req, err := http.NewRequestWithContext(pm.sendCtx, http.MethodPost, webhookURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := pm.httpClient.Do(req)
+		if err != nil {
+			if pm.sendCtx.Err() != nil {
+				return err
+			}
+			return transientError{err: err}
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK {
+			created, _ = io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+			return nil
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		err = fmt.Errorf("Discord API error: %d - %s", resp.StatusCode, string(respBody))
+		if resp.StatusCode == http.StatusTooManyRequests {
+			var rateLimit struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+			if json.Unmarshal(respBody, &rateLimit) == nil && rateLimit.RetryAfter > 0 {
+				retryAfter = time.Duration(rateLimit.RetryAfter * float64(time.Second))
+			}
+			return transientError{err: err, retryAfter: retryAfter}
+		}
+		if resp.StatusCode >= 500 {
+			return transientError{err: err}
+		}
+		return err
+	})
+	return created, err
+}
+
+// sendToDiscordWithFile sends message to Discord webhook with a single
+// file attachment, which embeds can reference as attachment://<filename>
+func (pm *PingMonitor) sendToDiscordWithFile(message DiscordMessage, filename string, data []byte) error {
+	parts := splitDiscordMessage(pm.style.discord(message))
+	jsonData, err := json.Marshal(parts[0])
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(pm.config.DiscordWebhookURL, "application/json", bytes.NewBuffer(jsonData))
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("payload_json", string(jsonData)); err != nil {
+		return err
+	}
+	fw, err := mw.CreateFormFile("files[0]", filename)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("Discord API error: %d - %s", resp.StatusCode, string(body))
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
 	}
 
+	if err := pm.deliverDiscord(mw.FormDataContentType(), body.Bytes()); err != nil {
+		return err
+	}
+	// The image belongs to the first message; the rest carry text only
+	if len(parts) > 1 {
+		return pm.sendToDiscord(DiscordMessage{Embeds: flattenEmbeds(parts[1:])})
+	}
 	return nil
 }
 
 // printDailyReport prints daily report to console
-func (pm *PingMonitor) printDailyReport(reportDate string) {
-	fmt.Printf("\n%s\n", strings.Repeat("=", 50))
-	fmt.Printf("📊 Ping Monitor 日次レポート - %s\n", reportDate)
-	fmt.Printf("%s\n", strings.Repeat("=", 50))
-	fmt.Printf("対象: Google (8.8.8.8)\n")
-	fmt.Printf("送信元: %s\n", pm.localIP)
-
-	totalPings := len(pm.pingResults) + len(pm.unreachableTimes)
-	successRate := 0.0
-	if totalPings > 0 {
-		successRate = float64(len(pm.pingResults)) / float64(totalPings) * 100
-	}
-
-	if len(pm.pingResults) > 0 {
-		var sum float64
-		maxTime := pm.pingResults[0].ResponseTime
-		minTime := pm.pingResults[0].ResponseTime
-
-		for _, result := range pm.pingResults {
-			sum += result.ResponseTime
-			if result.ResponseTime > maxTime {
-				maxTime = result.ResponseTime
-			}
-			if result.ResponseTime < minTime {
-				minTime = result.ResponseTime
-			}
+func (pm *PingMonitor) printDailyReport(p *reportPeriod) {
+	pm.writeDailyReport(os.Stdout, p)
+}
+
+// writeDailyReport writes the console report of p to w
+func (pm *PingMonitor) writeDailyReport(w io.Writer, p *reportPeriod) {
+	if pm.config.Lang == "en" {
+		pm.writeEnglishReport(w, p)
+		return
+	}
+	reportDate := p.Date
+	fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 50))
+	if p.SimulatedCount > 0 {
+		fmt.Fprintf(w, "[SIMULATED] ")
+	}
+	fmt.Fprintf(w, "📊 Ping Monitor 日次レポート - %s\n", reportDate)
+	fmt.Fprintf(w, "%s\n", strings.Repeat("=", 50))
+	fmt.Fprintf(w, "対象期間: %s\n", p.Format.period(p.Start, p.End))
+	fmt.Fprintf(w, "対象: %s\n", pm.targetLabel())
+	fmt.Fprintf(w, "送信元: %s\n", pm.localIP)
+	fmt.Fprintf(w, "ゲートウェイ: %s\n", pm.gatewayLabel())
+	if note := p.connectionNote("接続: "); note != "" {
+		fmt.Fprintf(w, "%s\n", note)
+	}
+	if quality, ok := pm.quality(p); ok && p.Coverage.Sufficient {
+		fmt.Fprintf(w, "%s（%s）\n", quality, quality.breakdown(p.Format))
+	}
+	if r := p.Regression; r != nil {
+		fmt.Fprintf(w, "\n⚠️ 昨日比で悪化:\n")
+		for _, line := range r.lines(p.Format) {
+			fmt.Fprintf(w, "  %s\n", strings.ReplaceAll(line, "**", ""))
 		}
-		avgTime := sum / float64(len(pm.pingResults))
+	}
 
-		fmt.Printf("\n📊 応答時間統計:\n")
-		fmt.Printf("  平均: %.1fms\n", avgTime)
-		fmt.Printf("  最大: %.1fms\n", maxTime)
-		fmt.Printf("  最小: %.1fms\n", minTime)
+	stats := p.probeStats()
+	totalPings := stats.Total
+	successRate := stats.SuccessRate
+	f := p.Format
+
+	if stats.Latency.Count > 0 {
+		fmt.Fprintf(w, "\n📊 応答時間統計:\n")
+		fmt.Fprintf(w, "  平均: %s\n", f.ms(stats.Latency.Avg))
+		fmt.Fprintf(w, "  最大: %s\n", f.ms(stats.Latency.Max))
+		fmt.Fprintf(w, "  最小: %s\n", f.ms(stats.Latency.Min))
+		if p.Coverage.Sufficient {
+			fmt.Fprintf(w, "  p95: %s\n", f.ms(stats.Latency.P95))
+		}
+		if diff, ok := p.differential(); ok {
+			fmt.Fprintf(w, "  LAN外で増えた遅延（対象 − ゲートウェイ）: %s\n", p.formatDifferential(diff))
+		}
 	}
 
-	fmt.Printf("\n📈 到達性統計:\n")
-	fmt.Printf("  成功率: %.2f%%\n", successRate)
-	fmt.Printf("  成功回数: %d\n", len(pm.pingResults))
-	fmt.Printf("  失敗回数: %d\n", len(pm.unreachableTimes))
-	fmt.Printf("  総ping回数: %d\n", totalPings)
+	if spark := p.sparklines(); spark != "" {
+		fmt.Fprintf(w, "\n〰️ 1時間ごとの推移:\n")
+		for _, line := range strings.Split(strings.Trim(spark, "`\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
 
-	if len(pm.unreachableTimes) > 0 {
-		fmt.Printf("\n⚠️ 到達不能時間:\n")
-		for i, t := range pm.unreachableTimes {
-			if i >= 10 {
-				fmt.Printf("  ... 他%d件\n", len(pm.unreachableTimes)-10)
-				break
-			}
-			fmt.Printf("  %s\n", t.Format("15:04:05"))
+	if table := pm.worstMinuteTable(p); table != "" {
+		fmt.Fprintf(w, "\n🔎 時間帯別ワースト:\n")
+		for _, line := range strings.Split(strings.Trim(table, "`\n"), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
 		}
 	}
 
-	fmt.Printf("%s\n\n", strings.Repeat("=", 50))
+	fmt.Fprintf(w, "\n📈 到達性統計:\n")
+	fmt.Fprintf(w, "  成功率: %s%s\n", f.percent(successRate, 2), p.Coverage.Marker())
+	fmt.Fprintf(w, "  成功回数: %s\n", f.count(stats.Success))
+	fmt.Fprintf(w, "  失敗回数: %s\n", f.count(len(p.UnreachableTimes)))
+	fmt.Fprintf(w, "  失敗の時刻: %s\n", p.formatFailureWindow())
+	if note := p.gatewayNote(""); note != "" {
+		fmt.Fprintf(w, "  %s\n", note)
+	}
+	fmt.Fprintf(w, "  総ping回数: %s\n", f.count(totalPings))
+	if note := p.warmupNote(""); note != "" {
+		fmt.Fprintf(w, "  %s\n", note)
+	}
+	if note := p.loadTestNote(""); note != "" {
+		fmt.Fprintf(w, "  %s\n", note)
+	}
+	if note := p.clockNote(""); note != "" {
+		fmt.Fprintf(w, "  %s\n", note)
+	}
+	if note := p.measurementNote(""); note != "" {
+		fmt.Fprintf(w, "  %s\n", note)
+	}
+	if note := p.hostBusyNote(""); note != "" {
+		fmt.Fprintf(w, "  %s\n", note)
+	}
+	if note := p.scheduleNote("\n  "); note != "" {
+		fmt.Fprintf(w, "%s\n", note)
+	}
+	if p.SimulatedCount > 0 {
+		fmt.Fprintf(w, "  🧪 SIMULATED: 擬似障害による失敗 %d件を含みます\n", p.SimulatedCount)
+	}
+
+	for _, t := range p.Targets {
+		fmt.Fprintf(w, "\n🎯 %s (%s):\n", t.Name, t.Address)
+		for _, line := range strings.Split(formatTargetPeriod(t, f), "\n") {
+			fmt.Fprintf(w, "  %s\n", strings.ReplaceAll(line, "**", ""))
+		}
+	}
+
+	if len(p.UnreachableTimes) > 0 {
+		fmt.Fprintf(w, "\n⚠️ 到達不能期間:\n")
+		for _, line := range p.unreachableLines(10) {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+		fmt.Fprintf(w, "  失敗の内訳: %s\n", p.FailureReasons.String())
+		fmt.Fprintf(w, "  失敗時のゲートウェイ:\n")
+		for _, line := range p.gatewayCounts().lines() {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+
+	if lines := p.bufferbloatLines(); len(lines) > 0 {
+		fmt.Fprintf(w, "\n📶 負荷時の遅延（バッファブロート）:\n")
+		for _, line := range lines {
+			fmt.Fprintf(w, "  %s\n", strings.ReplaceAll(line, "**", ""))
+		}
+	}
+
+	if len(p.MeasurementSpans) > 0 {
+		fmt.Fprintf(w, "\n🔧 計測不良（監視ホスト側の問題で計測できなかった期間、損失率には含めません）:\n")
+		for _, line := range strings.Split(formatMeasurementSpans(p.MeasurementSpans), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+
+	if p.outageCount() > 0 {
+		fmt.Fprintf(w, "\n🚨 障害 (%d件):\n", p.outageCount())
+		for _, line := range strings.Split(p.formatPeriodOutages(), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+
+	if len(p.AddressChanges) > 0 || p.ResolveFailures > 0 {
+		fmt.Fprintf(w, "\n🔁 IPアドレスの変更:\n")
+		for _, line := range strings.Split(p.addressSummary(), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+
+	if len(p.WANErrors) > 0 {
+		fmt.Fprintf(w, "\n📡 WAN側のエラー（ルーター）:\n")
+		for _, line := range strings.Split(summarizeWANErrors(p.WANErrors, p.UnreachableTimes).String(), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+
+	if len(p.RouteChanges) > 0 || p.RouteFlaps > 0 {
+		fmt.Fprintf(w, "\n🛤️ 経路の変化（1〜2ホップ目）:\n")
+		for _, line := range strings.Split(formatRouteChanges(p.RouteChanges, p.RouteFlaps, pm.routeLabels(p.RouteChanges)), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+
+	if len(p.Deferred) > 0 {
+		fmt.Fprintf(w, "\n🌙 静音時間帯に保留された通知 (%d件):\n", len(p.Deferred))
+		for _, line := range strings.Split(formatDeferredEvents(p.Deferred), "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+
+	fmt.Fprintf(w, "%s\n\n", strings.Repeat("=", 50))
+}
+
+// debugState returns a snapshot of the internal state for /debug/state
+func (pm *PingMonitor) debugState() DebugState {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	return DebugState{
+		Config:           redactConfig(pm.config),
+		TargetIP:         pm.targetIP,
+		Gateways:         pm.gateways,
+		LocalIP:          pm.localIP,
+		SuccessCount:     pm.successCountLocked(),
+		UnreachableCount: len(pm.unreachableTimes),
+		Running:          pm.running,
+		RecentResults:    pm.recent.list(),
+	}
+}
+
+// status returns the current monitor status for /status
+func (pm *PingMonitor) status() StatusResponse {
+	down, since := pm.outages.state()
+	internet := "up"
+	if down {
+		internet = "down"
+	}
+	today := statusDay(pm.currentPeriod())
+	var capabilities []Capability
+	if startupCapabilities != nil {
+		capabilities = startupCapabilities.Checks
+	}
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	return StatusResponse{
+		Schema:           api.SchemaStatus,
+		Site:             pm.config.SiteName,
+		TargetIP:         pm.targetIP,
+		TargetAddress:    pm.targetAddr,
+		Gateways:         pm.gateways,
+		LocalIP:          pm.localIP,
+		Internet:         internet,
+		Since:            since,
+		GatewayState:     string(pm.gatewayState),
+		SuccessCount:     pm.successCountLocked(),
+		UnreachableCount: len(pm.unreachableTimes),
+		Notifiers:        pm.dispatcher.notifierStatus(),
+		Commands:         commandStatsSnapshot(),
+		Route:            pm.route.path(),
+		Detection:        toAPIDetection(pm.detection),
+		Vantages:         pm.vantageStatus(),
+		Targets:          pm.targetStatus(),
+		Today:            &today,
+		LastDay:          pm.lastDay.Load(),
+		Capabilities:     capabilities,
+		PingBackend:      pm.pingBackend,
+		Clock:            pm.clock.status(),
+		ReportDelivery:   pm.deliveryStatus(),
+	}
 }
 
 // Stop stops the ping monitor
@@ -488,12 +1434,45 @@ func (pm *PingMonitor) Stop() {
 	pm.running = false
 	pm.mutex.Unlock()
 	close(pm.stopChan)
+	pm.shuttingDown.Store(true)
+	pm.markSchedule(scheduleMark{Time: time.Now(), Kind: markStop})
+	// The legs' last probes belong in the final report
+	pm.legs.Wait()
+
+	// Persist first, so a kill during the slow sends below loses nothing
+	p := pm.reports.finalize()
+
+	// Everything from here on must finish before the shutdown deadline
+	timeout := pm.config.ShutdownTimeout.Duration()
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, pm.cancelSends)
+	defer timer.Stop()
+
+	if pm.api != nil {
+		pm.api.shutdown()
+	}
+	if pm.snmp != nil {
+		pm.snmp.shutdown()
+	}
+	// The SSH masters would outlive the process
+	for _, v := range pm.vantages {
+		v.close()
+	}
 
+	// A day closed just before the stop is sent ahead of the interim one
+	pm.reports.flush()
 	// Send current statistics if any
-	if len(pm.pingResults) > 0 || len(pm.unreachableTimes) > 0 {
-		fmt.Println("現在の統計を送信中...")
-		pm.sendDailyReport(time.Now().Format("2006-01-02"))
+	if p != nil {
+		pm.reports.send(p)
+	}
+	// Sends aborted at the deadline still need a moment to reach the outbox
+	if !pm.dispatcher.closeWithin(time.Until(deadline) + time.Second) {
+		fmt.Printf("❌ 終了処理の締め切り(%v)までに配信できなかった通知があります\n", timeout)
 	}
+	if err := pm.store.Close(); err != nil {
+		fmt.Printf("❌ 結果ファイルのクローズエラー: %v\n", err)
+	}
+	pm.audit.close()
 }
 
 // Run starts the ping monitor
@@ -502,30 +1481,218 @@ func (pm *PingMonitor) Run() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// Start ping loop in goroutine
-	go pm.pingLoop()
+	pm.start()
 
 	// Wait for signal
 	sig := <-sigChan
 	fmt.Printf("\n終了シグナル(%v)を受信しました。停止中...\n", sig)
+	pm.audit.record(AuditEntry{Action: "shutdown", Actor: signalActor(sig)})
 	pm.Stop()
 }
 
+// start launches the monitor's background work. Profiles share one HTTP
+// server, so a profile's monitor does not open its own.
+func (pm *PingMonitor) start() {
+	// Start HTTP API if configured
+	if (pm.config.HTTPListen != "" || pm.config.controlSocketPath() != "") && pm.profile == "" {
+		pm.api = newAPIServer(pm)
+		pm.api.start()
+	}
+
+	if pm.config.SNMP != nil {
+		pm.snmp = newSNMPAgent(pm, *pm.config.SNMP)
+		if err := pm.snmp.start(); err != nil {
+			fmt.Printf("❌ SNMPエージェントを開始できません: %v\n", err)
+			pm.snmp = nil
+		}
+	}
+
+	if pm.collector != nil {
+		go pm.collector.run(pm.stopChan)
+	}
+
+	if interval := pm.config.RouteProbeInterval.Duration(); interval > 0 {
+		go pm.routeLoop(interval)
+	}
+
+	if pm.config.RouterSNMP != nil {
+		go pm.routerLoop(newRouterPoller(*pm.config.RouterSNMP))
+	}
+
+	if pm.config.DiscordBot != nil {
+		go newDiscordBot(pm, *pm.config.DiscordBot).run(pm.stopChan)
+	}
+
+	for _, v := range pm.vantages {
+		go v.loop(pm)
+	}
+	for _, l := range pm.targetLegs {
+		pm.legs.Add(1)
+		go l.loop(pm)
+	}
+	if connectionDetectable() {
+		go pm.connectionLoop()
+	}
+
+	// Summarize before the queues below are drained
+	pm.notifyStartup()
+
+	// Resend messages left undelivered by the previous shutdown
+	if pm.outbox != nil && pm.config.webhookConfigured() {
+		go pm.outbox.drain(pm.postDiscord)
+	}
+	// Resend events a crash or kill kept from being delivered
+	pm.dispatcher.replay()
+
+	fmt.Printf("%s へのpingモニタリングを開始します...\n", pm.targetLabel())
+	fmt.Println("Ctrl+Cで停止できます")
+
+	// Samples during warm-up reflect ARP resolution and wakeup delays
+	pm.warmupUntil = time.Now().Add(pm.config.Warmup.Duration())
+
+	// Start ping loop in goroutine
+	pm.startPingLoop()
+	go pm.watchdogLoop()
+	if pm.config.SelfProtection != nil && pm.config.SelfProtection.MemoryLimitMB > 0 {
+		go pm.memoryLoop()
+	}
+	go pm.saturationLoop()
+	if pm.bloat != nil {
+		go pm.bufferbloatLoop()
+	}
+}
+
+// exit ends the process; with -log-file it flushes the log first
+var exit = os.Exit
+
 func main() {
-	fmt.Println("🌐 Google Ping Monitor")
-	fmt.Println(strings.Repeat("=", 30))
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
+	configPath := flag.String("config", "config.json", "設定ファイルのパス")
+	listen := flag.String("listen", "", "HTTP APIの待ち受けアドレス (例: 127.0.0.1:8080)")
+	target := flag.String("target", "", "監視対象のIPアドレスかホスト名（設定ファイルの target より優先）")
+	interval := flag.String("interval", "", "pingの間隔（例: 500ms、30s。設定ファイルの ping_interval より優先）")
+	once, onceOpts := onceFlags()
+	takeover := flag.Bool("takeover", false, "同じ対象を監視中のインスタンスを停止して引き継ぐ")
+	validateOnly := flag.Bool("validate-config", false, "設定を検証し、プリセット展開後の実効設定を表示して終了する")
+	logOpts := logFileFlags()
+	simulate := flag.String("simulate", "", "シナリオを仮想時間で実行する（例: \"speed=3600x scenario=flaky.json\"）")
+	flag.Parse()
+	if logOpts.path != "" {
+		stopLog, err := startLogFile(logOpts)
+		if err != nil {
+			log.Fatalf("ログファイルを開けません: %v", err)
+		}
+		defer stopLog()
+		exit = func(code int) {
+			stopLog()
+			os.Exit(code)
+		}
+	}
+
+	// The options may also follow unquoted: -simulate speed=60x scenario=a.json
+	if *simulate != "" {
+		exit(runSimulation(*configPath, strings.Join(append([]string{*simulate}, flag.Args()...), " "), *listen))
+		return
+	}
+
+	if *once && onceOpts.count <= 0 {
+		log.Fatalf("-count は1以上を指定してください")
+	}
 
 	// Check if config file exists
-	configPath := "config.json"
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		log.Fatalf("設定ファイル %s が見つかりません。", configPath)
+	if _, err := os.Stat(*configPath); os.IsNotExist(err) {
+		log.Fatalf("設定ファイル %s が見つかりません。", *configPath)
+	}
+
+	overrides := ConfigOverrides{HTTPListen: *listen, Target: *target}
+	if *interval != "" {
+		d, err := parseDuration(*interval)
+		if err != nil {
+			log.Fatalf("-interval の値が正しくありません: %q（\"500ms\" や \"30s\" のような期間で指定してください）", *interval)
+		}
+		overrides.PingInterval = makeDuration(d)
+	}
+	profiles, err := readProfiles(*configPath, overrides)
+	if err != nil {
+		log.Fatalf("モニター初期化エラー: %v", err)
+	}
+	if len(profiles) > 0 && *once {
+		log.Fatalf("-once はプロファイルを使う設定では使えません")
+	}
+	configs := []Config{}
+	for _, p := range profiles {
+		configs = append(configs, p.Config)
+	}
+	if len(profiles) == 0 {
+		cfg, err := readConfig(*configPath, overrides)
+		if err != nil {
+			log.Fatalf("モニター初期化エラー: %v", err)
+		}
+		configs = append(configs, cfg)
+	}
+	if *validateOnly {
+		printEffectiveConfig(profiles, configs)
+		return
+	}
+
+	// The console is shared by all profiles, so the first one decides
+	if configs[0].PlainOutput {
+		stopPlain, err := startPlainConsole()
+		if err != nil {
+			log.Fatalf("標準出力を切り替えられません: %v", err)
+		}
+		defer stopPlain()
+		next := exit
+		exit = func(code int) {
+			stopPlain()
+			next(code)
+		}
+	}
+	fmt.Println("🌐 Ping Monitor")
+	fmt.Println(strings.Repeat("=", 30))
+	// Process-wide as well; profiles cannot set their own
+	applySelfProtection(configs[0].SelfProtection)
+	// Problems are reported now rather than found hours later; what cannot
+	// work is turned off before the locks are taken in state_dir
+	startupCapabilities = probeCapabilities(configs, *takeover)
+	startupCapabilities.print()
+	for i := range configs {
+		startupCapabilities.restrict(&configs[i])
+	}
+	for i := range profiles {
+		startupCapabilities.restrict(&profiles[i].Config)
+	}
+
+	// -once is a short benchmark and may run next to the monitor. The
+	// lock is taken before any state is loaded, so a taken over instance
+	// has saved its state by then.
+	if !*once {
+		for _, cfg := range configs {
+			lock, err := acquireInstanceLock(cfg, *takeover)
+			if err != nil {
+				log.Fatalf("❌ %v", err)
+			}
+			defer lock.release()
+		}
+	}
+	if len(profiles) > 0 {
+		runProfiles(profiles)
+		return
 	}
 
 	// Create and start monitor
-	monitor, err := NewPingMonitor(configPath)
+	monitor, err := NewPingMonitor(*configPath, overrides)
 	if err != nil {
 		log.Fatalf("モニター初期化エラー: %v", err)
 	}
+	if *once {
+		exit(monitor.runOnce(onceOpts))
+	}
 
 	monitor.Run()
 }
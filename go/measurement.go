@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// measurementWindow is the number of recent probes the measurement
+	// error rate is computed over
+	measurementWindow = 20
+	// measurementMinSamples is the number of probes needed before judging
+	measurementMinSamples = 10
+	// measurementSpanGap joins measurement errors into one span when they
+	// are at most this many intervals apart
+	measurementSpanGap = 2
+)
+
+// measurementSpan is a stretch of time whose probes failed in the monitor
+// itself, shown as 計測不良 in reports
+type measurementSpan struct {
+	Start time.Time
+	End   time.Time
+	Count int
+}
+
+// addMeasurementSpan records a measurement error at t, extending the
+// latest span when it is close enough
+func addMeasurementSpan(spans []measurementSpan, t time.Time, interval time.Duration) []measurementSpan {
+	if n := len(spans); n > 0 && t.Sub(spans[n-1].End) <= measurementSpanGap*interval {
+		spans[n-1].End = t
+		spans[n-1].Count++
+		return spans
+	}
+	return append(spans, measurementSpan{Start: t, End: t, Count: 1})
+}
+
+// formatMeasurementSpans lists spans for reports
+func formatMeasurementSpans(spans []measurementSpan) string {
+	var lines []string
+	for i, s := range spans {
+		if i >= 10 {
+			lines = append(lines, fmt.Sprintf("... 他%d件", len(spans)-10))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s〜%s（%d回）", s.Start.Format("15:04:05"), s.End.Format("15:04:05"), s.Count))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// measurementErrors returns the number of probes in spans
+func measurementErrors(spans []measurementSpan) int {
+	n := 0
+	for _, s := range spans {
+		n += s.Count
+	}
+	return n
+}
+
+// describeMeasurementError explains in a few words why the monitor could
+// not measure
+func describeMeasurementError(err error) string {
+	var pe *probeError
+	output := ""
+	if errors.As(err, &pe) {
+		output = pe.output
+	}
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return "pingコマンドが見つかりません"
+	case errors.Is(err, os.ErrPermission), strings.Contains(output, "Operation not permitted"), strings.Contains(output, "Permission denied"):
+		return "pingを実行する権限がありません"
+	case errors.Is(err, errOutputTooLarge):
+		return "pingの出力が大きすぎます"
+	case errors.Is(err, errUnparsedOutput):
+		return "pingの出力から応答時間を読み取れません"
+	case errors.Is(err, errNoICMPSocket):
+		return "ICMPソケットを開けません"
+	case errors.Is(err, errSaturated):
+		return "外部コマンドの同時実行数が上限に達しているため、pingを起動しませんでした"
+	}
+	return "pingを実行できません"
+}
+
+// measurementTransition is reported when the measurement error rate
+// crosses the threshold in either direction
+type measurementTransition struct {
+	Degraded bool
+	At       time.Time
+	Errors   int
+	Samples  int
+	Context  AlertContext
+}
+
+// measurementTracker watches the share of recent probes that failed in the
+// monitor itself rather than on the network. Crossing the threshold starts
+// an episode, which is reported once; it ends when the rate falls below
+// half the threshold.
+type measurementTracker struct {
+	threshold float64
+	recent    [measurementWindow]bool
+	count     int
+	next      int
+	errors    int
+	degraded  bool
+}
+
+// newMeasurementTracker creates a tracker; threshold == 0 disables it
+func newMeasurementTracker(threshold float64) *measurementTracker {
+	return &measurementTracker{threshold: threshold}
+}
+
+// observe feeds one probe and returns a transition, if any
+func (t *measurementTracker) observe(at time.Time, failed bool) *measurementTransition {
+	if t.threshold == 0 {
+		return nil
+	}
+	if t.count == measurementWindow && t.recent[t.next] {
+		t.errors--
+	}
+	t.recent[t.next] = failed
+	if failed {
+		t.errors++
+	}
+	t.next = (t.next + 1) % measurementWindow
+	t.count = min(t.count+1, measurementWindow)
+	if t.count < measurementMinSamples {
+		return nil
+	}
+
+	rate := float64(t.errors) / float64(t.count) * 100
+	switch {
+	case !t.degraded && rate >= t.threshold:
+		t.degraded = true
+		return t.transition(at, rate, ">=", t.threshold)
+	case t.degraded && rate < t.threshold/2:
+		t.degraded = false
+		return t.transition(at, rate, "<", t.threshold/2)
+	}
+	return nil
+}
+
+// transition describes the crossing with the rule that fired
+func (t *measurementTracker) transition(at time.Time, rate float64, op string, threshold float64) *measurementTransition {
+	rule := "measurement_degraded"
+	if !t.degraded {
+		rule = "measurement_recovered"
+	}
+	return &measurementTransition{
+		Degraded: t.degraded,
+		At:       at,
+		Errors:   t.errors,
+		Samples:  t.count,
+		Context: AlertContext{Rule: rule, Metric: "計測エラー率", Observed: rate, Op: op, Threshold: threshold, Unit: "%",
+			Window: fmt.Sprintf("直近%d回", t.count)},
+	}
+}
+
+// checkMeasurement feeds the tracker and raises the operational warning
+// when the probes stop measuring the network
+func (pm *PingMonitor) checkMeasurement(at time.Time, reason failureReason, err error) {
+	tr := pm.measurement.observe(at, reason == reasonMeasurement)
+	if tr == nil {
+		return
+	}
+	if !tr.Degraded {
+		fmt.Printf("✅ 計測不良が解消しました（%s）\n", tr.Context)
+		return
+	}
+	cause := describeMeasurementError(err)
+	fmt.Printf("🔧 計測不良: %s（%s）\n", cause, tr.Context)
+	message := fmt.Sprintf("**内容**: %s\n**エラー**: %v\n**直近の計測**: %d回中%d回が計測エラー",
+		cause, err, tr.Samples, tr.Errors) + tr.Context.messageLine() +
+		"\nネットワークの問題ではなく監視ホスト側の問題です。この間の結果は損失率に含めず、日次レポートに「計測不良」として表示します。"
+	pm.notifyOps(Event{
+		Time:    at,
+		Title:   "🔧 計測不良",
+		Message: message,
+		Data: map[string]interface{}{
+			"check":   "measurement",
+			"cause":   cause,
+			"error":   err.Error(),
+			"errors":  tr.Errors,
+			"samples": tr.Samples,
+			"rule":    tr.Context,
+		},
+	})
+}
+
+// notifyOps raises an operational warning: a problem with the monitor
+// itself rather than with the network it watches
+func (pm *PingMonitor) notifyOps(ev Event) {
+	ev.Kind = EventOps
+	ev.Severity = SeverityWarn
+	pm.dispatcher.dispatch(ev)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rttBuckets are the upper bounds in milliseconds of the response time
+// histogram; a last +Inf bucket follows
+var rttBuckets = [...]float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000}
+
+// incidentExemplarLinger is how long after a recovery the response times
+// still link to the outage, so the slow first minutes lead to it as well
+const incidentExemplarLinger = 10 * time.Minute
+
+// openMetricsType is the exposition format that carries exemplars
+const openMetricsType = "application/openmetrics-text"
+
+// rttExemplar is the latest observation of a bucket made during an
+// incident, shown beside the bucket in the OpenMetrics format
+type rttExemplar struct {
+	incident uint64
+	ms       float64
+	at       time.Time
+}
+
+// probeMetrics are the cumulative counters exposed at /metrics. Reasons
+// come from the fixed failureReasons set, so the label cardinality is the
+// number of reasons times the number of targets.
+type probeMetrics struct {
+	mutex    sync.Mutex
+	probes   uint64
+	failures [len(failureReasons)]uint64
+	lastRTT  float64
+	outages  uint64
+	// rtt counts successes per bucket of rttBuckets, not cumulated
+	rtt      [len(rttBuckets) + 1]uint64
+	rttSum   float64
+	rttCount uint64
+	// incident is the event log ID of the outage alert observations link
+	// to, until incidentUntil (zero while the outage lasts)
+	incident      uint64
+	incidentUntil time.Time
+	exemplars     [len(rttBuckets) + 1]*rttExemplar
+}
+
+// newProbeMetrics creates zeroed counters
+func newProbeMetrics() *probeMetrics {
+	return &probeMetrics{}
+}
+
+// observe counts one probe sent at at; reason is "" for a success
+func (m *probeMetrics) observe(at time.Time, reason failureReason, ms float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.probes++
+	if reason != "" {
+		m.failures[reasonIndex(reason)]++
+		return
+	}
+	m.lastRTT = ms
+	bucket := len(rttBuckets)
+	for i, le := range rttBuckets {
+		if ms <= le {
+			bucket = i
+			break
+		}
+	}
+	m.rtt[bucket]++
+	m.rttSum += ms
+	m.rttCount++
+	if m.incident != 0 && (m.incidentUntil.IsZero() || at.Before(m.incidentUntil)) {
+		m.exemplars[bucket] = &rttExemplar{incident: m.incident, ms: ms, at: at}
+	}
+}
+
+// linkIncident links the following observations to the outage alert id,
+// until until; a zero until keeps the link until the next call. Without
+// an id (no event log) nothing is linked.
+func (m *probeMetrics) linkIncident(id uint64, until time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.incident, m.incidentUntil = id, until
+}
+
+// outage counts one confirmed outage
+func (m *probeMetrics) outage() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.outages++
+}
+
+// promLabelEscaper escapes label values for the Prometheus text format
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeCounterHeader writes the HELP and TYPE lines of a counter; the
+// OpenMetrics format names the family without the _total of its sample
+func writeCounterHeader(w *strings.Builder, name, help string, openMetrics bool) {
+	if openMetrics {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+}
+
+// writePrometheus renders the metrics in the Prometheus text format, or
+// in the OpenMetrics format with the incident exemplars of the histogram
+func (m *probeMetrics) writePrometheus(w *strings.Builder, target string, up, openMetrics bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	t := promLabelEscaper.Replace(target)
+
+	writeCounterHeader(w, "ping_monitor_probes_total", "Probes sent to the target.", openMetrics)
+	fmt.Fprintf(w, "ping_monitor_probes_total{target=\"%s\"} %d\n", t, m.probes)
+
+	writeCounterHeader(w, "ping_monitor_probe_failures_total", "Failed probes by reason.", openMetrics)
+	for i, reason := range failureReasons {
+		fmt.Fprintf(w, "ping_monitor_probe_failures_total{target=\"%s\",reason=\"%s\"} %d\n", t, reason, m.failures[i])
+	}
+
+	fmt.Fprintf(w, "# HELP ping_monitor_rtt_milliseconds Response time of the latest successful probe.\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_rtt_milliseconds gauge\n")
+	fmt.Fprintf(w, "ping_monitor_rtt_milliseconds{target=\"%s\"} %g\n", t, m.lastRTT)
+
+	fmt.Fprintf(w, "# HELP ping_monitor_probe_rtt_milliseconds Response times of successful probes.\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_probe_rtt_milliseconds histogram\n")
+	var cumulative uint64
+	for i := range m.rtt {
+		cumulative += m.rtt[i]
+		le := "+Inf"
+		if i < len(rttBuckets) {
+			le = strconv.FormatFloat(rttBuckets[i], 'f', -1, 64)
+		}
+		fmt.Fprintf(w, "ping_monitor_probe_rtt_milliseconds_bucket{target=\"%s\",le=\"%s\"} %d", t, le, cumulative)
+		if e := m.exemplars[i]; openMetrics && e != nil {
+			fmt.Fprintf(w, " # {incident_id=\"%d\"} %g %.3f", e.incident, e.ms, float64(e.at.UnixMilli())/1000)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "ping_monitor_probe_rtt_milliseconds_sum{target=\"%s\"} %g\n", t, m.rttSum)
+	fmt.Fprintf(w, "ping_monitor_probe_rtt_milliseconds_count{target=\"%s\"} %d\n", t, m.rttCount)
+
+	writeCounterHeader(w, "ping_monitor_outages_total", "Confirmed outages since start.", openMetrics)
+	fmt.Fprintf(w, "ping_monitor_outages_total{target=\"%s\"} %d\n", t, m.outages)
+
+	upValue := 0
+	if up {
+		upValue = 1
+	}
+	fmt.Fprintf(w, "# HELP ping_monitor_up Whether the target is considered reachable (outage hysteresis applied).\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_up gauge\n")
+	fmt.Fprintf(w, "ping_monitor_up{target=\"%s\"} %d\n", t, upValue)
+
+	// The composite state has no target label, so dashboards and alert
+	// rules keep working when the target changes
+	fmt.Fprintf(w, "# HELP ping_monitor_internet_up Whether the internet is considered up (the state /verdict reports).\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_internet_up gauge\n")
+	fmt.Fprintf(w, "ping_monitor_internet_up %d\n", upValue)
+}
+
+// handleMetrics serves the counters for Prometheus. With metrics_exemplars
+// a scraper asking for OpenMetrics gets that format, whose histogram
+// buckets link to the outage alerts; others get the plain text format.
+func (s *apiServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	down, _ := s.pm.outages.state()
+	openMetrics := s.pm.config.MetricsExemplars && strings.Contains(r.Header.Get("Accept"), openMetricsType)
+	var b strings.Builder
+	s.pm.metrics.writePrometheus(&b, s.pm.targetIP, !down, openMetrics)
+	if q, ok := s.pm.quality(s.pm.currentPeriod()); ok {
+		fmt.Fprintf(&b, "# HELP ping_monitor_quality_score Connection quality score of the day so far (0-100).\n")
+		fmt.Fprintf(&b, "# TYPE ping_monitor_quality_score gauge\n")
+		fmt.Fprintf(&b, "ping_monitor_quality_score{target=\"%s\"} %g\n", promLabelEscaper.Replace(s.pm.targetIP), q.Score)
+	}
+	writeMemoryMetrics(&b)
+	writeCommandMetrics(&b)
+	if openMetrics {
+		b.WriteString("# EOF\n")
+		w.Header().Set("Content-Type", openMetricsType+"; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	fmt.Fprint(w, b.String())
+}
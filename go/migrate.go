@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profileKeys are the keys migrate-config moves into the profile: what
+// belongs to one target rather than to every monitor of the process.
+// state_dir is also kept at the top level for the control socket.
+var profileKeys = []string{"target", "target_resolve_interval", "gateway", "gateway_candidates", "results_file", "state_dir", "vantages", "route_probe_interval"}
+
+// usesProfiles reports whether parsed config keys are in the profiles
+// schema; anything else is the single-monitor schema of older releases.
+// Both are loaded as they are, so migrating is never required.
+func usesProfiles(keys map[string]json.RawMessage) bool {
+	_, ok := keys["profiles"]
+	return ok
+}
+
+// configKeys returns the top-level keys Config knows
+func configKeys() map[string]bool {
+	known := make(map[string]bool)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := jsonKey(t.Field(i)); key != "" {
+			known[key] = true
+		}
+	}
+	return known
+}
+
+// migrateConfig rewrites a single-monitor config as one profile named
+// name. The target, state_dir and results_file are written out even when
+// they were defaults, and state_dir is given to the profile explicitly so
+// it does not move to a subdirectory. Unknown keys stay at the top level
+// and are returned for the warning.
+func migrateConfig(keys map[string]json.RawMessage, effective Config, name string) (map[string]interface{}, []string) {
+	out := make(map[string]interface{})
+	profile := make(map[string]interface{})
+	known := configKeys()
+	var unknown []string
+	for k, v := range keys {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+		out[k] = v
+	}
+	for _, k := range profileKeys {
+		if v, ok := keys[k]; ok {
+			profile[k] = v
+			delete(out, k)
+		}
+	}
+	profile["target"] = effective.Target
+	if effective.StateDir != "" {
+		profile["state_dir"] = effective.StateDir
+		out["state_dir"] = effective.StateDir
+	}
+	if effective.ResultsFile != "" {
+		profile["results_file"] = effective.ResultsFile
+	}
+	out["profiles"] = map[string]interface{}{name: profile}
+	sort.Strings(unknown)
+	return out, unknown
+}
+
+// comparableConfig is a config as the monitor uses it, for checking that
+// a migration keeps the meaning: the control socket resolved, profiles
+// dropped
+func comparableConfig(c Config) string {
+	c.ControlSocket = c.controlSocketPath()
+	c.Profiles = nil
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+// diffLines returns a line diff of a and b, "-" for removed, "+" for
+// added and " " for kept lines
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	return out
+}
+
+// runMigrateConfig rewrites a single-monitor config.json in the profiles
+// schema after showing the difference, keeping the original as a backup
+func runMigrateConfig(args []string) int {
+	fs := flag.NewFlagSet("migrate-config", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "設定ファイルのパス")
+	name := fs.String("profile", "main", "移行先のプロファイル名")
+	dryRun := fs.Bool("dry-run", false, "変更内容を表示するだけで書き換えない")
+	fs.Parse(args)
+
+	if !profileNameRe.MatchString(*name) {
+		fmt.Fprintf(os.Stderr, "エラー: プロファイル名 %q に使えない文字があります（英数字・-・_）\n", *name)
+		return 2
+	}
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: 設定ファイル %s が見つかりません: %v\n", *configPath, err)
+		return 1
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: 設定ファイル %s の形式が正しくありません: %v\n", *configPath, err)
+		return 1
+	}
+	if usesProfiles(keys) {
+		fmt.Printf("✅ %s はすでにプロファイル形式です。変更はありません\n", *configPath)
+		return 0
+	}
+	before, err := readConfig(*configPath, ConfigOverrides{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+
+	migrated, unknown := migrateConfig(keys, before, *name)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(migrated)
+
+	// Load the result the way the monitor will and compare
+	tmp, err := os.CreateTemp(filepath.Dir(*configPath), ".migrate-*.json")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Write(buf.Bytes())
+	tmp.Close()
+	profiles, err := readProfiles(tmp.Name(), ConfigOverrides{})
+	if err != nil || len(profiles) != 1 {
+		fmt.Fprintf(os.Stderr, "エラー: 移行後の設定を読み込めません: %v\n", err)
+		return 1
+	}
+	if comparableConfig(before) != comparableConfig(profiles[0].Config) {
+		fmt.Fprintln(os.Stderr, "エラー: 移行後の設定の意味が変わるため中止しました")
+		return 1
+	}
+
+	var old bytes.Buffer
+	if json.Indent(&old, data, "", "  ") != nil {
+		old.Write(data)
+	}
+	fmt.Printf("--- %s\n+++ %s（移行後）\n", *configPath, *configPath)
+	oldLines := strings.Split(strings.TrimRight(old.String(), "\n"), "\n")
+	newLines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	for _, line := range diffLines(oldLines, newLines) {
+		fmt.Println(line)
+	}
+	fmt.Println()
+	for _, k := range unknown {
+		fmt.Printf("注意: 不明なキー %s はそのまま残します（読み込み時は無視されます）\n", k)
+	}
+	fmt.Printf("注意: HTTP APIのパスは /p/%s/ 以下に変わります（例: /p/%s/status）\n", *name, *name)
+	if *dryRun {
+		fmt.Println("（-dry-run のため書き換えていません）")
+		return 0
+	}
+
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(*configPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	backup := *configPath + ".bak-" + time.Now().Format("20060102-150405")
+	if err := os.WriteFile(backup, data, mode); err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: バックアップを保存できません: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*configPath, buf.Bytes(), mode); err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %s を書き換えられません: %v\n", *configPath, err)
+		return 1
+	}
+	fmt.Printf("✅ %s を移行しました（元のファイル: %s）\n", *configPath, backup)
+	return 0
+}
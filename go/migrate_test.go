@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs f and returns what it printed
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+	f()
+	data, _ := os.ReadFile(out.Name())
+	return string(data)
+}
+
+// legacyConfig is a single-monitor config of an older release
+const legacyConfig = `{
+  "discord_webhook_url": "https://discord.com/api/webhooks/1/x",
+  "target": "1.1.1.1",
+  "gateway": "192.168.1.1",
+  "ping_interval": "2s",
+  "route_probe_interval": "10m",
+  "old_option": true
+}`
+
+func TestMigrateConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(legacyConfig), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run only shows the difference
+	var code int
+	out := captureStdout(t, func() { code = runMigrateConfig([]string{"-config", path, "-profile", "home", "-dry-run"}) })
+	if code != 0 || !strings.Contains(out, `-   "target": "1.1.1.1",`) || !strings.Contains(out, "不明なキー old_option") || !strings.Contains(out, "/p/home/status") {
+		t.Errorf("dry run exited %d:\n%s", code, out)
+	}
+	if data, _ := os.ReadFile(path); string(data) != legacyConfig {
+		t.Fatal("dry run rewrote the config")
+	}
+
+	captureStdout(t, func() { code = runMigrateConfig([]string{"-config", path, "-profile", "home"}) })
+	if code != 0 {
+		t.Fatalf("exited %d", code)
+	}
+	var got map[string]interface{}
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"discord_webhook_url": "https://discord.com/api/webhooks/1/x",
+		"ping_interval":       "2s",
+		"old_option":          true,
+		"profiles": map[string]interface{}{"home": map[string]interface{}{
+			"target":               "1.1.1.1",
+			"gateway":              "192.168.1.1",
+			"route_probe_interval": "10m",
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("migrated:\n%s", data)
+	}
+	if info, err := os.Stat(path); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0640) {
+		t.Errorf("mode %v, %v", info.Mode(), err)
+	}
+	backups, _ := filepath.Glob(path + ".bak-*")
+	if len(backups) != 1 {
+		t.Fatalf("backups %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != legacyConfig {
+		t.Errorf("backup holds %s", data)
+	}
+
+	// Migrating again changes nothing
+	out = captureStdout(t, func() { code = runMigrateConfig([]string{"-config", path}) })
+	if code != 0 || !strings.Contains(out, "すでにプロファイル形式") {
+		t.Errorf("second run exited %d: %s", code, out)
+	}
+	stderr := os.Stderr
+	os.Stderr, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	code = runMigrateConfig([]string{"-config", path, "-profile", "a/b"})
+	os.Stderr.Close()
+	os.Stderr = stderr
+	if code != 2 {
+		t.Errorf("bad profile name exited %d", code)
+	}
+}
+
+// TestMigrateConfigKeepsMeaning loads migrated configs the way the monitor
+// does and compares them with the originals
+func TestMigrateConfigKeepsMeaning(t *testing.T) {
+	for _, cfg := range []string{
+		`{}`,
+		legacyConfig,
+		`{"target": "vpn.example.com", "state_dir": "/var/lib/ping", "results_file": "", "api_token": "secret", "vantages": [{"name": "office", "host": "office.example.com"}]}`,
+	} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.json")
+		os.WriteFile(path, []byte(cfg), 0600)
+		before, err := readConfig(path, ConfigOverrides{})
+		if err != nil {
+			t.Fatalf("%s: %v", cfg, err)
+		}
+		var keys map[string]json.RawMessage
+		json.Unmarshal([]byte(cfg), &keys)
+		migrated, _ := migrateConfig(keys, before, "main")
+		data, _ := json.Marshal(migrated)
+		os.WriteFile(path, data, 0600)
+		profiles, err := readProfiles(path, ConfigOverrides{})
+		if err != nil || len(profiles) != 1 {
+			t.Fatalf("%s: %d profiles, %v", cfg, len(profiles), err)
+		}
+		if comparableConfig(before) != comparableConfig(profiles[0].Config) {
+			t.Errorf("%s changed meaning:\n%s", cfg, data)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b, want []string
+	}{
+		{nil, nil, nil},
+		{[]string{"a", "b"}, []string{"a", "b"}, []string{"  a", "  b"}},
+		{[]string{"a", "b", "c"}, []string{"a", "c"}, []string{"  a", "- b", "  c"}},
+		{[]string{"a"}, []string{"x", "a", "y"}, []string{"+ x", "  a", "+ y"}},
+		{[]string{"a", "b"}, []string{"c"}, []string{"- a", "- b", "+ c"}},
+	}
+	for _, tt := range tests {
+		if got := diffLines(tt.a, tt.b); !slices.Equal(got, tt.want) {
+			t.Errorf("%v -> %v: %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
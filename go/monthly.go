@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// heatmapLevel classifies a heatmap cell for coloring
+type heatmapLevel int
+
+const (
+	levelMissing heatmapLevel = iota
+	levelGood
+	levelWarn
+	levelCritical
+)
+
+// heatmapColors maps levels to cell colors (GitHub contribution style)
+var heatmapColors = map[heatmapLevel]color.RGBA{
+	levelMissing:  {0xeb, 0xed, 0xf0, 0xff},
+	levelGood:     {0x40, 0xc4, 0x63, 0xff},
+	levelWarn:     {0xf0, 0xa0, 0x20, 0xff},
+	levelCritical: {0xe5, 0x53, 0x4b, 0xff},
+}
+
+// Loss thresholds in percent, matching the daily report color semantics
+// (success rate below 99% is orange, below 95% is red)
+const (
+	lossWarnPercent     = 1.0
+	lossCriticalPercent = 5.0
+)
+
+// heatmapCell is one day × hour cell
+type heatmapCell struct {
+	Level heatmapLevel
+	Value float64
+	// Interface throughput of the hour, if sampled
+	RxBps, TxBps float64
+	HasIface     bool
+}
+
+// heatmap is a day × hour grid for one month
+type heatmap struct {
+	Month  time.Time
+	Metric string
+	Days   [][24]heatmapCell
+}
+
+// buildHeatmap colors each hour by p95 latency or loss; thresholds come
+// from the latency alert configuration so colors match alerting semantics
+func buildHeatmap(month time.Time, days []*DailyHistory, cfg Config) heatmap {
+	hm := heatmap{Month: month, Metric: cfg.HeatmapMetric, Days: make([][24]heatmapCell, len(days))}
+	for d, day := range days {
+		if day == nil {
+			continue
+		}
+		for _, h := range day.Hours {
+			if h.Hour < 0 || h.Hour > 23 || h.Count == 0 {
+				continue
+			}
+			cell := heatmapCell{RxBps: h.RxBps, TxBps: h.TxBps, HasIface: h.IfaceSamples > 0}
+			if cfg.HeatmapMetric == "loss" {
+				cell.Value = h.LossRate()
+				cell.Level = classify(cell.Value, lossWarnPercent, lossCriticalPercent)
+			} else if h.Failures == h.Count {
+				// No latency data at all: the whole hour was lost
+				cell.Value = 0
+				cell.Level = levelCritical
+			} else {
+				cell.Value = h.P95
+				cell.Level = classify(cell.Value, cfg.LatencyWarnMs, cfg.LatencyCriticalMs)
+			}
+			hm.Days[d][h.Hour] = cell
+		}
+	}
+	return hm
+}
+
+// classify maps a value onto good/warn/critical
+func classify(v, warn, critical float64) heatmapLevel {
+	switch {
+	case v >= critical:
+		return levelCritical
+	case v >= warn:
+		return levelWarn
+	default:
+		return levelGood
+	}
+}
+
+// Heatmap geometry shared by the SVG and PNG renderers
+const (
+	heatmapCellSize = 14
+	heatmapGap      = 2
+	heatmapLabelW   = 28
+	heatmapLabelH   = 16
+)
+
+// cellLabel returns the tooltip text of a cell
+func (hm heatmap) cellLabel(day, hour int) string {
+	date := time.Date(hm.Month.Year(), hm.Month.Month(), day+1, hour, 0, 0, 0, hm.Month.Location())
+	cell := hm.Days[day][hour]
+	prefix := date.Format("01/02 15時")
+	switch {
+	case cell.Level == levelMissing:
+		return prefix + ": データなし"
+	case hm.Metric == "loss":
+		return fmt.Sprintf("%s: 損失 %.2f%%", prefix, cell.Value)
+	case cell.Value == 0 && cell.Level == levelCritical:
+		return prefix + ": 全損失"
+	default:
+		return fmt.Sprintf("%s: p95 %.1fms", prefix, cell.Value)
+	}
+}
+
+// cellTooltip returns the label of a cell with the interface throughput
+func (hm heatmap) cellTooltip(day, hour int) string {
+	label := hm.cellLabel(day, hour)
+	if cell := hm.Days[day][hour]; cell.HasIface {
+		label += fmt.Sprintf(" / 回線 受信 %s 送信 %s", formatBitrate(cell.RxBps), formatBitrate(cell.TxBps))
+	}
+	return label
+}
+
+// svg renders the heatmap as an inline SVG with day and hour labels
+func (hm heatmap) svg() string {
+	step := heatmapCellSize + heatmapGap
+	width := heatmapLabelW + 24*step
+	height := heatmapLabelH + len(hm.Days)*step
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="9">`, width, height)
+	for h := 0; h < 24; h += 3 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%d</text>`, heatmapLabelW+h*step, heatmapLabelH-4, h)
+	}
+	for d := range hm.Days {
+		y := heatmapLabelH + d*step
+		fmt.Fprintf(&b, `<text x="0" y="%d">%d日</text>`, y+heatmapCellSize-3, d+1)
+		for h := 0; h < 24; h++ {
+			c := heatmapColors[hm.Days[d][h].Level]
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="#%02x%02x%02x"><title>%s</title></rect>`,
+				heatmapLabelW+h*step, y, heatmapCellSize, heatmapCellSize, c.R, c.G, c.B, template.HTMLEscapeString(hm.cellTooltip(d, h)))
+		}
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// png renders the heatmap as a PNG image; labels are omitted since the
+// standard library has no font rendering, rows are days and columns hours
+func (hm heatmap) png() ([]byte, error) {
+	step := heatmapCellSize + heatmapGap
+	img := image.NewRGBA(image.Rect(0, 0, 24*step+heatmapGap, len(hm.Days)*step+heatmapGap))
+	for d := range hm.Days {
+		for h := 0; h < 24; h++ {
+			c := heatmapColors[hm.Days[d][h].Level]
+			x0, y0 := heatmapGap+h*step, heatmapGap+d*step
+			for y := y0; y < y0+heatmapCellSize; y++ {
+				for x := x0; x < x0+heatmapCellSize; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// monthlySummary totals the hourly aggregates of a month
+type monthlySummary struct {
+	Total        int
+	Failures     int
+	SuccessRate  float64
+	DaysWithData int
+}
+
+// summarizeMonth totals the stored days of a month
+func summarizeMonth(days []*DailyHistory) monthlySummary {
+	var s monthlySummary
+	for _, day := range days {
+		if day == nil {
+			continue
+		}
+		s.DaysWithData++
+		for _, h := range day.Hours {
+			s.Total += h.Count
+			s.Failures += h.Failures
+		}
+	}
+	if s.Total > 0 {
+		s.SuccessRate = float64(s.Total-s.Failures) / float64(s.Total) * 100
+	}
+	return s
+}
+
+// monthlyHTMLTemplate is the standalone monthly HTML report
+var monthlyHTMLTemplate = template.Must(template.New("monthly").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>Ping Monitor 月次レポート {{.Month}}</title>
+<style>body{font-family:sans-serif;margin:2em}td{padding:2px 12px}</style>
+</head>
+<body>
+<h1>🌐 Ping Monitor 月次レポート {{.Month}}</h1>
+<p>対象期間: {{.Period}}</p>
+<p>対象: {{.Target}} / 送信元: {{.LocalIP}}</p>
+<table>
+<tr><td>総ping回数</td><td>{{.Summary.Total}}</td></tr>
+<tr><td>失敗回数</td><td>{{.Summary.Failures}}</td></tr>
+<tr><td>成功率</td><td>{{printf "%.2f" .Summary.SuccessRate}}%</td></tr>
+<tr><td>記録のある日数</td><td>{{.Summary.DaysWithData}}</td></tr>
+</table>
+<h2>障害</h2>
+<table>
+<tr><td>障害回数</td><td>{{.Incidents.Count}}</td></tr>
+{{if .Incidents.Count}}<tr><td>合計停止時間</td><td>{{call .Seconds .Incidents.DowntimeSeconds}}</td></tr>
+<tr><td>MTTR（平均復旧時間）</td><td>{{call .Seconds .Incidents.MTTRSeconds}}</td></tr>
+<tr><td>MTBF（平均障害間隔）</td><td>{{if gt .Incidents.Count 1}}{{call .Seconds .Incidents.MTBFSeconds}}{{else}}—{{end}}</td></tr>
+{{range .Incidents.Histogram}}<tr><td>{{.Label}}</td><td>{{.Count}}件</td></tr>
+{{end}}{{end}}</table>
+{{if .Incidents.Count}}<h2>原因別の停止時間</h2>
+<table>
+<tr><th>原因</th><th>停止時間</th><th>割合</th></tr>
+{{range .Classes}}<tr><td>{{.Label}}</td><td>{{call $.Seconds .Seconds}}</td><td>{{printf "%.1f" .Percent}}%</td></tr>
+{{end}}</table>{{end}}
+{{if .Bloat.Runs}}<h2>負荷時の遅延（バッファブロート）</h2>
+<table>
+<tr><td>試験回数</td><td>{{.Bloat.Runs}}</td></tr>
+<tr><td>平均増加</td><td>+{{printf "%.1f" .Bloat.AvgIncrease}}ms</td></tr>
+<tr><td>最大増加</td><td>+{{printf "%.1f" .Bloat.MaxIncrease}}ms</td></tr>
+<tr><td>グレード</td><td>{{.BloatGrades}}</td></tr>
+</table>{{end}}
+<h2>時間帯別ヒートマップ（{{.MetricLabel}}）</h2>
+<p>{{.Legend}}</p>
+{{.SVG}}
+</body>
+</html>
+`))
+
+// heatmapLegend describes the color thresholds in use
+func heatmapLegend(cfg Config) (metricLabel, legend string) {
+	if cfg.HeatmapMetric == "loss" {
+		return "損失率", fmt.Sprintf("緑: %.0f%%未満 / 橙: %.0f%%以上 / 赤: %.0f%%以上 / 灰: データなし", lossWarnPercent, lossWarnPercent, lossCriticalPercent)
+	}
+	return "p95応答時間", fmt.Sprintf("緑: %.0fms未満 / 橙: %.0fms以上 / 赤: %.0fms以上または全損失 / 灰: データなし", cfg.LatencyWarnMs, cfg.LatencyWarnMs, cfg.LatencyCriticalMs)
+}
+
+// sendMonthlyReport writes the HTML monthly report for month and sends the
+// rollup with a PNG heatmap to Discord
+func (pm *PingMonitor) sendMonthlyReport(month time.Time) {
+	if pm.history == nil {
+		return
+	}
+
+	monthLabel := month.Format("2006-01")
+	period := pm.format.period(monthRange(month))
+	days := pm.history.loadMonth(month)
+	summary := summarizeMonth(days)
+	if summary.DaysWithData == 0 {
+		return
+	}
+	hm := buildHeatmap(month, days, pm.config)
+	var incidents IncidentStats
+	if records, err := pm.store.Events(monthRange(month)); err != nil {
+		fmt.Printf("❌ 障害記録の読み込みエラー: %v\n", err)
+	} else {
+		incidents = computeIncidentStats(records)
+	}
+	metricLabel, legend := heatmapLegend(pm.config)
+	bloat := trendBufferbloat(days)
+
+	var page bytes.Buffer
+	err := monthlyHTMLTemplate.Execute(&page, map[string]interface{}{
+		"Month":       monthLabel,
+		"Period":      period,
+		"Target":      pm.targetIP,
+		"LocalIP":     pm.localIP,
+		"Summary":     summary,
+		"Incidents":   incidents,
+		"Classes":     incidents.Classes.rows(),
+		"Bloat":       bloat,
+		"BloatGrades": bloat.gradeCounts(),
+		"Seconds":     pm.format.seconds,
+		"MetricLabel": metricLabel,
+		"Legend":      legend,
+		"SVG":         template.HTML(hm.svg()),
+	})
+	if err == nil {
+		path := filepath.Join(pm.config.StateDir, "reports", "monthly-"+monthLabel+".html")
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = os.WriteFile(path, page.Bytes(), 0644)
+		}
+		if err == nil {
+			fmt.Printf("📄 %sの月次レポートを %s に保存しました\n", monthLabel, path)
+		}
+	}
+	if err != nil {
+		fmt.Printf("❌ 月次レポート保存エラー: %v\n", err)
+	}
+
+	if !pm.config.webhookConfigured() {
+		return
+	}
+
+	pngData, err := hm.png()
+	if err != nil {
+		fmt.Printf("❌ ヒートマップ生成エラー: %v\n", err)
+		return
+	}
+
+	embed := DiscordEmbed{
+		Title:       "📅 Ping Monitor 月次レポート",
+		Description: fmt.Sprintf("**対象月**: %s\n**対象期間**: %s\n**対象**: %s\n**送信元**: %s", monthLabel, period, pm.targetLabel(), pm.localIP),
+		Color:       0x3498db,
+		Fields: []EmbedField{
+			{
+				Name:   "📈 到達性統計",
+				Value:  fmt.Sprintf("**成功率**: %.2f%%\n**総ping回数**: %d\n**失敗回数**: %d", summary.SuccessRate, summary.Total, summary.Failures),
+				Inline: true,
+			},
+			{
+				Name:   "🚨 障害",
+				Value:  formatIncidentStats(incidents, pm.format),
+				Inline: true,
+			},
+			{
+				Name:   "🗓️ 時間帯別ヒートマップ",
+				Value:  fmt.Sprintf("行: 日 / 列: 時（0〜23時）\n%s: %s", metricLabel, legend),
+				Inline: false,
+			},
+		},
+		Image:     &EmbedImage{URL: "attachment://heatmap.png"},
+		Timestamp: pm.now().Format(time.RFC3339),
+		Footer: EmbedFooter{
+			Text: "Ping Monitor by Go",
+		},
+	}
+
+	if bloat.Runs > 0 {
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:   "📶 負荷時の遅延（バッファブロート）",
+			Value:  bloat.field(pm.format),
+			Inline: false,
+		})
+	}
+
+	message := DiscordMessage{Embeds: []DiscordEmbed{embed}}
+	if err := pm.sendToDiscordWithFile(message, "heatmap.png", pngData); err != nil {
+		fmt.Printf("❌ Discord送信エラー: %v\n", err)
+	} else {
+		fmt.Printf("✅ %sの月次レポートをDiscordに送信しました\n", monthLabel)
+	}
+}
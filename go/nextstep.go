@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nextStepClass is how an outage alert is classified for its "次にやること"
+// line; the values are the keys of next_steps
+type nextStepClass string
+
+const (
+	// nextStepGateway: the gateway stopped answering too, or is the target
+	nextStepGateway nextStepClass = "gateway"
+	// nextStepISP: the gateway answers but nothing beyond it does
+	nextStepISP nextStepClass = "isp"
+	// nextStepRoute: the router answers for the target with unreachable,
+	// as when the WAN session (PPPoE and the like) dropped
+	nextStepRoute nextStepClass = "route"
+	// nextStepDNS: the target's name cannot be resolved
+	nextStepDNS nextStepClass = "dns"
+	// nextStepService: with tcp_probe, the host answers but the port does
+	// not behave as expected
+	nextStepService nextStepClass = "service"
+	// nextStepUnknown: no gateway was found to tell LAN from line
+	nextStepUnknown nextStepClass = "unknown"
+)
+
+// nextStepClasses lists the classes in the order of the README
+var nextStepClasses = [...]nextStepClass{nextStepGateway, nextStepISP, nextStepRoute, nextStepDNS, nextStepService, nextStepUnknown}
+
+// nextStepCatalog is the default advice per class, written for whoever
+// receives the alert rather than whoever runs the monitor. {gateway} and
+// {target} are replaced by the addresses, so the commands can be pasted.
+var nextStepCatalog = map[nextStepClass]string{
+	nextStepGateway: "ルーターの電源とLANケーブルを確認してください。電源が入っている場合は再起動すると直ることがあります。PCから `ping {gateway}` で応答を確認できます",
+	nextStepISP:     "ONUのランプ状態（PON・LOS・AUTHなど）を確認してください。消灯・赤点灯なら回線側の障害の可能性があり、プロバイダの障害情報も確認してください。PCから `tracert {target}`（Mac・Linuxは `traceroute {target}`）で止まる場所を確認できます",
+	nextStepRoute:   "ルーターのインターネット側の接続（PPPoEなど）が切れていないか、ルーターの管理画面で確認してください。PCから `tracert {target}`（Mac・Linuxは `traceroute {target}`）で確認できます",
+	nextStepDNS:     "名前解決に失敗しています。ルーターまたはPCのDNS設定を確認してください。PCから `nslookup {target}` で確認できます",
+	nextStepService: "{target} は応答していますが、ポートの状態が想定と異なります。サービスが停止・再起動していないか、ファイアウォールの設定が変わっていないかを確認してください",
+	nextStepUnknown: "ルーターとONUの電源・ランプ状態を確認してください",
+}
+
+// validNextStepClass reports whether s names a class
+func validNextStepClass(s string) bool {
+	for _, c := range nextStepClasses {
+		if string(c) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNextSteps checks the keys of next_steps
+func validateNextSteps(steps map[string]string) error {
+	for key := range steps {
+		if !validNextStepClass(key) {
+			return fmt.Errorf("next_steps のキーが正しくありません: %q (gateway / isp / route / dns / service / unknown)", key)
+		}
+	}
+	return nil
+}
+
+// nextStepFor classifies an outage from its failures and the gateway's
+// latest state; "" for outages without advice, such as simulated ones
+func nextStepFor(tr *outageTransition, gw gatewayState) nextStepClass {
+	if tr.Simulated {
+		return ""
+	}
+	if tr.causedByGateway() || gw == gatewayIsTarget || gw == gatewayUnreachable {
+		return nextStepGateway
+	}
+	switch tr.Reasons.dominant() {
+	case reasonDNS:
+		return nextStepDNS
+	case reasonUnreachable:
+		return nextStepRoute
+	case reasonRefused, reasonReset, reasonOpen:
+		return nextStepService
+	case reasonSimulated:
+		return ""
+	}
+	if gw == gatewayReachable {
+		return nextStepISP
+	}
+	return nextStepUnknown
+}
+
+// nextStep returns the advice of class with the addresses filled in; the
+// next_steps key of the class replaces the catalog's, and "" omits it
+func (pm *PingMonitor) nextStep(class nextStepClass) string {
+	text, ok := pm.config.NextSteps[string(class)]
+	if !ok {
+		text = nextStepCatalog[class]
+	}
+	if text == "" {
+		return ""
+	}
+	gateway := "ルーターのアドレス"
+	if len(pm.gateways) > 0 {
+		gateway = pm.gateways[0]
+	}
+	return strings.NewReplacer("{gateway}", gateway, "{target}", pm.config.Target).Replace(text)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNextStepAlerts runs one outage per classification and checks the
+// "次にやること" line of its alert
+func TestNextStepAlerts(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name      string
+		reason    failureReason
+		gateways  []string // nil keeps scenarioGateway, "target" the target
+		gatewayUp bool
+		steps     map[string]interface{}
+		class     nextStepClass
+		text      string
+	}{
+		{name: "gateway down too", reason: reasonTimeout, class: nextStepGateway, text: "ルーターの電源とLANケーブルを確認してください。電源が入っている場合は再起動すると直ることがあります。PCから `ping 192.0.2.1` で応答を確認できます"},
+		{name: "gateway is the target", reason: reasonTimeout, gateways: []string{"target"}, class: nextStepGateway, text: "ルーターの電源とLANケーブルを確認してください。電源が入っている場合は再起動すると直ることがあります。PCから `ping 8.8.8.8` で応答を確認できます"},
+		{name: "beyond the gateway", reason: reasonTimeout, gatewayUp: true, class: nextStepISP, text: "ONUのランプ状態（PON・LOS・AUTHなど）を確認してください。消灯・赤点灯なら回線側の障害の可能性があり、プロバイダの障害情報も確認してください。PCから `tracert 8.8.8.8`（Mac・Linuxは `traceroute 8.8.8.8`）で止まる場所を確認できます"},
+		{name: "router answers unreachable", reason: reasonUnreachable, gatewayUp: true, class: nextStepRoute, text: "ルーターのインターネット側の接続（PPPoEなど）が切れていないか"},
+		{name: "name not resolved", reason: reasonDNS, gatewayUp: true, class: nextStepDNS, text: "名前解決に失敗しています。ルーターまたはPCのDNS設定を確認してください。PCから `nslookup 8.8.8.8` で確認できます"},
+		{name: "port refused", reason: reasonRefused, gatewayUp: true, class: nextStepService, text: "8.8.8.8 は応答していますが、ポートの状態が想定と異なります"},
+		{name: "port reset", reason: reasonReset, gatewayUp: true, class: nextStepService, text: "8.8.8.8 は応答していますが"},
+		{name: "no gateway", reason: reasonTimeout, gateways: []string{}, class: nextStepUnknown, text: "ルーターとONUの電源・ランプ状態を確認してください"},
+		{name: "replaced", reason: reasonTimeout, gatewayUp: true, steps: map[string]interface{}{"isp": "{target} 越しに {gateway} を確認"}, class: nextStepISP, text: "8.8.8.8 越しに 192.0.2.1 を確認"},
+		{name: "omitted", reason: reasonTimeout, gatewayUp: true, steps: map[string]interface{}{"isp": ""}},
+	}
+	for _, tt := range tests {
+		cfg := map[string]interface{}{"ping_interval": "1s"}
+		if tt.steps != nil {
+			cfg["next_steps"] = tt.steps
+		}
+		pm, clock := newTestMonitor(t, cfg, start)
+		if tt.gateways != nil {
+			pm.gateways = nil
+			for _, gw := range tt.gateways {
+				if gw == "target" {
+					gw = pm.targetIP
+				}
+				pm.gateways = append(pm.gateways, gw)
+			}
+		}
+		n := &fakeNotifier{name: "test"}
+		notifyTo(pm, n)
+		pm.prober = probeFunc(func(host string) (float64, error) {
+			if host != pm.targetIP && tt.gatewayUp {
+				return 1, nil
+			}
+			if s := clock.now().Sub(start) / time.Second; s >= 5 {
+				return 0, &probeError{reason: tt.reason, err: errors.New(string(tt.reason))}
+			}
+			return 10, nil
+		})
+		for s := 0; s < 10; s++ {
+			at := start.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+		}
+		pm.dispatcher.flush()
+		events := n.events()
+		if len(events) != 1 || events[0].Kind != EventOutage {
+			t.Fatalf("%s: events %+v, want the outage alert", tt.name, events)
+		}
+		message, data := events[0].Message, events[0].Data
+		if tt.class == "" {
+			if strings.Contains(message, "次にやること") || data["next_step"] != nil {
+				t.Errorf("%s: advice in %q", tt.name, message)
+			}
+			continue
+		}
+		if !strings.Contains(message, "\n**次にやること**: "+tt.text) {
+			t.Errorf("%s: message %q, want the advice %q", tt.name, message, tt.text)
+		}
+		if data["next_step"] != string(tt.class) || !strings.Contains(data["next_step_text"].(string), tt.text) {
+			t.Errorf("%s: data %v / %v, want %s", tt.name, data["next_step"], data["next_step_text"], tt.class)
+		}
+	}
+}
+
+func TestNextStepFor(t *testing.T) {
+	var reasons reasonCounts
+	reasons.add(reasonTimeout)
+	if got := nextStepFor(&outageTransition{Simulated: true, Reasons: reasons}, gatewayUnreachable); got != "" {
+		t.Errorf("simulated outage classified %q", got)
+	}
+	var simulated reasonCounts
+	simulated.add(reasonSimulated)
+	if got := nextStepFor(&outageTransition{Reasons: simulated}, gatewayReachable); got != "" {
+		t.Errorf("simulated failures classified %q", got)
+	}
+	// A gateway that stopped answering outweighs the target's reason
+	var dns reasonCounts
+	dns.add(reasonDNS)
+	if got := nextStepFor(&outageTransition{Reasons: dns}, gatewayUnreachable); got != nextStepGateway {
+		t.Errorf("DNS failures with the gateway down classified %q", got)
+	}
+	if err := validateNextSteps(map[string]string{"lan": "x"}); err == nil || !strings.Contains(err.Error(), `"lan"`) {
+		t.Errorf("unknown key: %v", err)
+	}
+	for _, c := range nextStepClasses {
+		if nextStepCatalog[c] == "" {
+			t.Errorf("%s has no default advice", c)
+		}
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity ranks notification events
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarn
+	SeverityCritical
+)
+
+// String returns the config name of the severity
+func (s Severity) String() string {
+	switch s {
+	case SeverityCritical:
+		return "critical"
+	case SeverityWarn:
+		return "warn"
+	default:
+		return "info"
+	}
+}
+
+// parseSeverity parses a config severity name; "" means info
+func parseSeverity(s string) (Severity, error) {
+	switch s {
+	case "", "info":
+		return SeverityInfo, nil
+	case "warn":
+		return SeverityWarn, nil
+	case "critical":
+		return SeverityCritical, nil
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q", s)
+}
+
+// EventKind identifies what an Event is about
+type EventKind string
+
+const (
+	EventOutage   EventKind = "outage"
+	EventRecovery EventKind = "recovery"
+	EventReport   EventKind = "report"
+	// EventAddressChange is sent when a hostname target resolves elsewhere
+	EventAddressChange EventKind = "address_change"
+	// EventLatency and EventLatencyRecovery bracket a sustained slowdown
+	EventLatency         EventKind = "latency"
+	EventLatencyRecovery EventKind = "latency_recovery"
+	// EventLossTrend hints at a steady loss increase below the outage level
+	EventLossTrend EventKind = "loss_trend"
+	// EventRegression is a daily report clearly worse than the previous days
+	EventRegression EventKind = "daily_regression"
+	// EventStartup summarizes the configuration when monitoring starts
+	EventStartup EventKind = "startup"
+	// EventOps warns about the monitor itself, such as probes that can no
+	// longer measure
+	EventOps EventKind = "ops"
+	// EventConnectionChange tells that the default route moved to another
+	// kind of link, such as from Wi-Fi to a USB LTE modem
+	EventConnectionChange EventKind = "connection_change"
+)
+
+// Event is a single notification, rendered by each Notifier in its own format
+type Event struct {
+	// ID is the sequence number assigned by the event log, 0 without one
+	ID        uint64       `json:"id"`
+	Kind      EventKind    `json:"kind"`
+	Severity  Severity     `json:"severity"`
+	Time      time.Time    `json:"time"`
+	Title     string       `json:"title"`
+	Message   string       `json:"message"`
+	Fields    []EmbedField `json:"fields,omitempty"`
+	Simulated bool         `json:"simulated,omitempty"`
+	// Data carries structured values for notifiers that can render them
+	Data map[string]interface{} `json:"data,omitempty"`
+	// Replayed is set when the event is resent after a restart
+	Replayed bool `json:"-"`
+}
+
+// DisplayTitle returns the title with the SIMULATED and resend markers when
+// applicable
+func (e Event) DisplayTitle() string {
+	title := e.Title
+	if e.Simulated {
+		title = "[SIMULATED] " + title
+	}
+	if e.Replayed {
+		title = "[再送] " + title
+	}
+	return title
+}
+
+// Notifier delivers events to one destination
+type Notifier interface {
+	Name() string
+	Notify(ev Event) error
+}
+
+// eventFilter is implemented by notifiers that only want some events
+type eventFilter interface {
+	accepts(ev Event) bool
+}
+
+// statusReporter is implemented by notifiers exposing state in /status
+type statusReporter interface {
+	status() interface{}
+}
+
+// dispatcher delivers events to all notifiers, deferring low-severity
+// events during quiet hours. Each notifier and target has its own lane: an
+// event waits until the lane's earlier events were delivered or failed,
+// while a notifier that is retrying holds up no other lane. With an event
+// log, every queued event is persisted first and acknowledged per notifier.
+type dispatcher struct {
+	notifiers []Notifier
+	quiet     *quietHours
+	queue     chan Event
+	mutex     sync.Mutex
+	deferred  []Event
+	done      chan struct{}
+	pending   sync.WaitGroup
+	log       *eventLog
+	// replayUpTo is the last ID logged by previous runs
+	replayUpTo uint64
+	// delivering holds the lanes back while the replay runs; stalled holds
+	// the notifiers that failed since start, whose acknowledgements stop
+	// so the next start resends from the failure on
+	delivering sync.RWMutex
+	stalled    map[string]bool
+	lanes      map[laneKey]*deliveryLane
+	lanesDone  sync.WaitGroup
+	// inflight and delivered hold, per notifier, the logged IDs still in a
+	// lane and the highest one finished, so an acknowledgement never
+	// passes an event another lane has not finished
+	inflight  map[string]map[uint64]bool
+	delivered map[string]uint64
+	// style renders events on delivery, so the log keeps them as raised
+	style textStyle
+	// blackout tracks when no notification could get out
+	blackout *deliveryBlackout
+	// debug prints the routing of each event (notify_debug)
+	debug bool
+}
+
+// dispatchQueueSize bounds events waiting for delivery
+const dispatchQueueSize = 64
+
+// newDispatcher creates a dispatcher and starts its worker; log may be nil.
+// now times the delivery attempts.
+func newDispatcher(notifiers []Notifier, quiet *quietHours, log *eventLog, style textStyle, now func() time.Time) *dispatcher {
+	d := &dispatcher{
+		style:     style,
+		blackout:  newDeliveryBlackout(now),
+		notifiers: notifiers,
+		quiet:     quiet,
+		queue:     make(chan Event, dispatchQueueSize),
+		done:      make(chan struct{}),
+		log:       log,
+		stalled:   make(map[string]bool),
+		lanes:     make(map[laneKey]*deliveryLane),
+		inflight:  make(map[string]map[uint64]bool),
+		delivered: make(map[string]uint64),
+	}
+	if log != nil {
+		d.log.register(d.notifierNames())
+		d.replayUpTo = log.state.LastID
+	}
+	go d.run()
+	return d
+}
+
+// notifierNames returns the names acknowledgements are kept under
+func (d *dispatcher) notifierNames() []string {
+	names := make([]string, len(d.notifiers))
+	for i, n := range d.notifiers {
+		names[i] = n.Name()
+	}
+	return names
+}
+
+// dispatch records ev and queues it for delivery unless quiet hours defer
+// it. It returns the ID the event log assigned, 0 when it was not logged.
+func (d *dispatcher) dispatch(ev Event) uint64 {
+	if d.quiet.suppresses(ev) {
+		d.mutex.Lock()
+		d.deferred = append(d.deferred, ev)
+		d.mutex.Unlock()
+		fmt.Printf("🌙 静音時間帯のため通知を保留しました: %s\n", ev.DisplayTitle())
+		return 0
+	}
+	if len(d.notifiers) == 0 {
+		return 0
+	}
+	// Logged even if the queue is full, so it is resent on the next start
+	if d.log != nil {
+		if err := d.log.append(&ev); err != nil {
+			fmt.Printf("❌ イベントログへの書き込みエラー: %v\n", err)
+		}
+	}
+	d.pending.Add(1)
+	select {
+	case d.queue <- ev:
+	default:
+		d.pending.Done()
+		fmt.Printf("❌ 通知キューが満杯のため破棄しました: %s\n", ev.DisplayTitle())
+	}
+	return ev.ID
+}
+
+// laneKey identifies a delivery lane
+type laneKey struct {
+	notifier string
+	target   string
+}
+
+// eventTarget returns the target an event is about, "" for none
+func eventTarget(ev Event) string {
+	target, _ := ev.Data["target"].(string)
+	return target
+}
+
+// deliveryLane is the FIFO of one notifier and target. It is unbounded, so
+// a stalled lane never blocks the queue feeding the others.
+type deliveryLane struct {
+	mutex   sync.Mutex
+	events  []Event
+	closing bool
+	// ready is signalled when events are added or the lane is closed
+	ready chan struct{}
+}
+
+// push appends ev to the lane
+func (l *deliveryLane) push(ev Event) {
+	l.mutex.Lock()
+	l.events = append(l.events, ev)
+	l.mutex.Unlock()
+	l.signal()
+}
+
+// close lets the lane's worker exit once the lane is empty
+func (l *deliveryLane) close() {
+	l.mutex.Lock()
+	l.closing = true
+	l.mutex.Unlock()
+	l.signal()
+}
+
+// signal wakes the worker without blocking
+func (l *deliveryLane) signal() {
+	select {
+	case l.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for the oldest event; false means the lane is closed and empty
+func (l *deliveryLane) next() (Event, bool) {
+	for {
+		l.mutex.Lock()
+		if len(l.events) > 0 {
+			ev := l.events[0]
+			l.events = l.events[1:]
+			l.mutex.Unlock()
+			return ev, true
+		}
+		closing := l.closing
+		l.mutex.Unlock()
+		if closing {
+			return Event{}, false
+		}
+		<-l.ready
+	}
+}
+
+// run hands queued events to the lanes until the queue is closed, then
+// waits for the lanes to drain
+func (d *dispatcher) run() {
+	defer close(d.done)
+	for ev := range d.queue {
+		target := eventTarget(ev)
+		if d.debug {
+			d.logRoute(ev)
+		}
+		for _, n := range d.notifiers {
+			d.pending.Add(1)
+			d.track(n.Name(), ev.ID)
+			d.lane(n, target).push(ev)
+		}
+		d.pending.Done()
+	}
+	for _, l := range d.lanes {
+		l.close()
+	}
+	d.lanesDone.Wait()
+}
+
+// lane returns the lane of n and target, starting its worker on first use
+func (d *dispatcher) lane(n Notifier, target string) *deliveryLane {
+	key := laneKey{notifier: n.Name(), target: target}
+	if l, ok := d.lanes[key]; ok {
+		return l
+	}
+	l := &deliveryLane{ready: make(chan struct{}, 1)}
+	d.lanes[key] = l
+	d.lanesDone.Add(1)
+	go d.runLane(n, l)
+	return l
+}
+
+// runLane delivers the events of one lane in order
+func (d *dispatcher) runLane(n Notifier, l *deliveryLane) {
+	defer d.lanesDone.Done()
+	for {
+		ev, ok := l.next()
+		if !ok {
+			return
+		}
+		d.delivering.RLock()
+		delivered := d.deliver(n, ev)
+		d.delivering.RUnlock()
+		d.finish(n.Name(), ev.ID, delivered)
+		d.pending.Done()
+	}
+}
+
+// track notes that a logged event entered a lane of name
+func (d *dispatcher) track(name string, id uint64) {
+	if d.log == nil || id == 0 {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.inflight[name] == nil {
+		d.inflight[name] = make(map[uint64]bool)
+	}
+	d.inflight[name][id] = true
+}
+
+// finish acknowledges what name has delivered so far: up to the highest
+// finished ID, but short of any ID still in one of its lanes
+func (d *dispatcher) finish(name string, id uint64, delivered bool) {
+	if d.log == nil || id == 0 {
+		return
+	}
+	d.mutex.Lock()
+	delete(d.inflight[name], id)
+	if delivered {
+		d.delivered[name] = max(d.delivered[name], id)
+	}
+	upTo := d.delivered[name]
+	for other := range d.inflight[name] {
+		upTo = min(upTo, other-1)
+	}
+	stalled := d.stalled[name]
+	d.mutex.Unlock()
+	if !stalled && upTo > 0 {
+		d.log.ack(upTo, []string{name})
+	}
+}
+
+// deliver sends ev to n unless its routing or filter leaves it out, and
+// reports whether it is done with; a failure stalls n
+func (d *dispatcher) deliver(n Notifier, ev Event) bool {
+	if ok, _ := route(n, ev); !ok {
+		return true
+	}
+	start := d.blackout.now()
+	err := n.Notify(d.style.event(ev))
+	d.blackout.record(start, err == nil)
+	if err != nil {
+		fmt.Printf("❌ %s通知エラー: %v\n", n.Name(), err)
+		d.mutex.Lock()
+		d.stalled[n.Name()] = true
+		d.mutex.Unlock()
+		return false
+	}
+	return true
+}
+
+// replay resends, in the background, the events previous runs logged but
+// did not deliver. It holds the worker until done, so newer events follow
+// the replayed ones.
+func (d *dispatcher) replay() {
+	if d.log == nil {
+		return
+	}
+	d.delivering.Lock()
+	go func() {
+		defer d.delivering.Unlock()
+		for _, n := range d.notifiers {
+			for _, ev := range d.log.undelivered(n.Name()) {
+				if ev.ID > d.replayUpTo {
+					break
+				}
+				ev.Replayed = true
+				if !d.deliver(n, ev) {
+					break
+				}
+				d.log.ack(ev.ID, []string{n.Name()})
+				fmt.Printf("📮 未配信の通知を%sに再送しました: %s\n", n.Name(), ev.DisplayTitle())
+			}
+		}
+	}()
+}
+
+// replayCount returns how many events of previous runs some notifier has
+// not delivered yet
+func (d *dispatcher) replayCount() int {
+	if d.log == nil {
+		return 0
+	}
+	ids := make(map[uint64]bool)
+	for _, n := range d.notifiers {
+		for _, ev := range d.log.undelivered(n.Name()) {
+			if ev.ID <= d.replayUpTo {
+				ids[ev.ID] = true
+			}
+		}
+	}
+	return len(ids)
+}
+
+// flush waits until every queued event has been delivered
+func (d *dispatcher) flush() {
+	d.pending.Wait()
+}
+
+// close stops accepting events and waits until queued ones are delivered
+func (d *dispatcher) close() {
+	close(d.queue)
+	<-d.done
+}
+
+// closeWithin is close bounded by timeout; it reports whether every queued
+// event was delivered in time
+func (d *dispatcher) closeWithin(timeout time.Duration) bool {
+	close(d.queue)
+	select {
+	case <-d.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// notifierStatus collects the status of notifiers that report one
+func (d *dispatcher) notifierStatus() map[string]interface{} {
+	statuses := make(map[string]interface{})
+	for _, n := range d.notifiers {
+		if r, ok := n.(statusReporter); ok {
+			statuses[n.Name()] = r.status()
+		}
+	}
+	return statuses
+}
+
+// takeDeferred returns and clears the events held back by quiet hours
+func (d *dispatcher) takeDeferred() []Event {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	deferred := d.deferred
+	d.deferred = nil
+	return deferred
+}
+
+// formatDeferredEvents summarizes deferred events for the daily report
+func formatDeferredEvents(events []Event) string {
+	var lines []string
+	maxDisplay := 10
+	for i, ev := range events {
+		if i >= maxDisplay {
+			lines = append(lines, fmt.Sprintf("... 他%d件", len(events)-maxDisplay))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", ev.Time.Format("15:04:05"), ev.DisplayTitle()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// discordNotifier posts events as embeds to the Discord webhook
+type discordNotifier struct {
+	pm *PingMonitor
+}
+
+// Name returns the notifier name used in logs
+func (n *discordNotifier) Name() string {
+	return "Discord"
+}
+
+// eventColors maps event kinds to embed colors
+var eventColors = map[EventKind]int{
+	EventOutage:           0xff0000, // Red
+	EventRecovery:         0x00ff00, // Green
+	EventAddressChange:    0xff9900, // Orange
+	EventLatency:          0xff9900, // Orange
+	EventLatencyRecovery:  0x00ff00, // Green
+	EventLossTrend:        0xffcc00, // Yellow
+	EventRegression:       0xff9900, // Orange
+	EventStartup:          0x3399ff, // Blue
+	EventOps:              0x9966ff, // Purple
+	EventConnectionChange: 0x3399ff, // Blue
+}
+
+// accepts skips report events, since the daily report is sent to Discord
+// as its own detailed embed
+func (n *discordNotifier) accepts(ev Event) bool {
+	return ev.Kind != EventReport
+}
+
+// routing returns discord_routing
+func (n *discordNotifier) routing() NotifierRouting {
+	return n.pm.config.DiscordRouting
+}
+
+// Notify sends ev as a single embed
+func (n *discordNotifier) Notify(ev Event) error {
+	fields := ev.Fields
+	if fields == nil {
+		fields = []EmbedField{}
+	}
+	embed := DiscordEmbed{
+		Title:       ev.DisplayTitle(),
+		Description: ev.Message,
+		Color:       eventColors[ev.Kind],
+		Fields:      fields,
+		Timestamp:   ev.Time.Format(time.RFC3339),
+		Footer: EmbedFooter{
+			Text: "Ping Monitor by Go",
+		},
+	}
+	return n.pm.sendToDiscord(DiscordMessage{Embeds: []DiscordEmbed{embed}})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// targetEvent is an event about target
+func targetEvent(title, target string) Event {
+	ev := testEvent(title)
+	ev.Data = map[string]interface{}{"target": target}
+	return ev
+}
+
+// titles returns the titles n delivered for target
+func (n *fakeNotifier) titles(target string) []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	var out []string
+	for _, ev := range n.got {
+		if eventTarget(ev) == target {
+			out = append(out, ev.Title)
+		}
+	}
+	return out
+}
+
+// TestDispatcherLaneOrder delivers the events of each target in the order
+// raised, whatever each delivery takes
+func TestDispatcherLaneOrder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	// Within the queue, so none is dropped
+	delays := make([]time.Duration, dispatchQueueSize)
+	for i := range delays {
+		delays[i] = time.Duration(r.Intn(300)) * time.Microsecond
+	}
+	n := &fakeNotifier{name: "A", fail: func(ev Event) error {
+		i, _ := strconv.Atoi(ev.Title)
+		time.Sleep(delays[i])
+		return nil
+	}}
+	d := newDispatcher([]Notifier{n}, nil, nil, textStyle{}, time.Now)
+	want := map[string][]string{}
+	for i := range delays {
+		target := []string{"8.8.8.8", "1.1.1.1", ""}[i%3]
+		d.dispatch(targetEvent(strconv.Itoa(i), target))
+		want[target] = append(want[target], strconv.Itoa(i))
+	}
+	d.close()
+	for target, titles := range want {
+		if got := n.titles(target); !slices.Equal(got, titles) {
+			t.Errorf("%q: %v, want %v", target, got, titles)
+		}
+	}
+}
+
+// TestDispatcherLaneIsolation holds one notifier's delivery for one target
+// and checks that no other lane waits for it
+func TestDispatcherLaneIsolation(t *testing.T) {
+	quietStdout(t)
+	release := make(chan struct{})
+	held := make(chan struct{})
+	slow := &fakeNotifier{name: "A", fail: func(ev Event) error {
+		if ev.Title == "x1" {
+			close(held)
+			<-release
+		}
+		return nil
+	}}
+	fast := &fakeNotifier{name: "B"}
+	log, err := newEventLog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDispatcher([]Notifier{slow, fast}, nil, log, textStyle{}, time.Now)
+	d.dispatch(targetEvent("x1", "x"))
+	<-held
+	d.dispatch(targetEvent("x2", "x"))
+	d.dispatch(targetEvent("y1", "y"))
+
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !done(); {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: A %v, B %v", what, slow.ids(), fast.ids())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor("other lanes", func() bool { return len(fast.ids()) == 3 && len(slow.titles("y")) == 1 })
+	if got := slow.titles("x"); len(got) != 0 {
+		t.Errorf("x lane passed its held event: %v", got)
+	}
+	// A delivered event 3 but not 1: its acknowledgement waits, so a crash
+	// now would resend 1
+	if u := log.undelivered("A"); len(u) != 3 {
+		t.Errorf("A acknowledged past a held event: %d undelivered", len(u))
+	}
+	if u := log.undelivered("B"); len(u) != 0 {
+		t.Errorf("B has %d undelivered", len(u))
+	}
+
+	close(release)
+	d.close()
+	if got := slow.titles("x"); !slices.Equal(got, []string{"x1", "x2"}) {
+		t.Errorf("x lane %v", got)
+	}
+	if u := log.undelivered("A"); len(u) != 0 {
+		t.Errorf("A has %d undelivered after the lane drained", len(u))
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Exit codes of -once
+const (
+	onceExitPass        = 0
+	onceExitThreshold   = 1
+	onceExitInterrupted = 130
+)
+
+// onceOptions configures a -once benchmark run
+type onceOptions struct {
+	count int
+	// maxLoss (percent) and maxAvgMs fail the run when exceeded; negative
+	// values disable the check
+	maxLoss  float64
+	maxAvgMs float64
+}
+
+// onceFlags registers the -once flags on the default flag set
+func onceFlags() (*bool, *onceOptions) {
+	opts := &onceOptions{}
+	once := flag.Bool("once", false, "指定回数だけpingして結果を表示し、終了する（ベンチマーク）")
+	flag.IntVar(&opts.count, "count", 100, "-once でpingする回数")
+	flag.Float64Var(&opts.maxLoss, "max-loss", -1, "-once の損失率の上限（%、超えると終了コード1、負の値で無効）")
+	flag.Float64Var(&opts.maxAvgMs, "max-avg-ms", -1, "-once の平均応答時間の上限（ms、超えると終了コード1、負の値で無効）")
+	return once, opts
+}
+
+// onceRun accumulates the samples of a benchmark run
+type onceRun struct {
+	total     int
+	times     []float64
+	failures  int
+	reasons   reasonCounts
+	started   time.Time
+	interval  time.Duration
+	lastPrint time.Time
+}
+
+// done returns the number of samples taken so far
+func (r *onceRun) done() int {
+	return len(r.times) + r.failures
+}
+
+// progress formats "123/500  損失 1.6%  平均 14.2ms  残り 6m17s"
+func (r *onceRun) progress() string {
+	stats := computeProbeStats(r.times, r.failures)
+	avg := "-"
+	if stats.Success > 0 {
+		avg = fmt.Sprintf("%.1fms", stats.Latency.Avg)
+	}
+	eta := time.Duration(r.total-r.done()) * r.interval
+	return fmt.Sprintf("%d/%d  損失 %.1f%%  平均 %s  残り %v",
+		r.done(), r.total, 100-stats.SuccessRate, avg, eta.Round(time.Second))
+}
+
+// progressWriter shows the progress in place on a terminal, and as a plain
+// line every tenth of the run (at most every 10 seconds) when redirected
+type progressWriter struct {
+	w        io.Writer
+	terminal bool
+}
+
+// newProgressWriter writes progress to stderr, keeping stdout for the report
+func newProgressWriter() *progressWriter {
+	info, err := os.Stderr.Stat()
+	return &progressWriter{w: os.Stderr, terminal: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
+
+// update reports the progress of r after a sample
+func (p *progressWriter) update(r *onceRun, now time.Time) {
+	if p.terminal {
+		// Pad so a shorter line fully overwrites the previous one
+		fmt.Fprintf(p.w, "\r%-60s", r.progress())
+		return
+	}
+	step := max(1, r.total/10)
+	if r.done()%step != 0 && r.done() != r.total && now.Sub(r.lastPrint) < 10*time.Second {
+		return
+	}
+	r.lastPrint = now
+	fmt.Fprintln(p.w, r.progress())
+}
+
+// finish ends the in-place line
+func (p *progressWriter) finish() {
+	if p.terminal {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// runOnce pings the target opts.count times at the configured interval,
+// prints the statistics and returns the exit code. Ctrl+C stops early and
+// reports the samples taken so far; the thresholds then apply to those.
+func (pm *PingMonitor) runOnce(opts *onceOptions) int {
+	run := &onceRun{total: opts.count, started: time.Now(), interval: pm.pingInterval}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	fmt.Printf("🏁 %s に %d回 pingします（間隔 %v、Ctrl+Cで中断）\n", pm.targetIP, opts.count, pm.pingInterval)
+	progress := newProgressWriter()
+	interrupted := false
+	ticker := time.NewTicker(pm.pingInterval)
+	defer ticker.Stop()
+	for run.done() < run.total && !interrupted {
+		responseTime, err := pm.prober.Probe(pm.targetIP)
+		if err != nil {
+			run.failures++
+			run.reasons.add(classifyFailure(err))
+		} else {
+			run.times = append(run.times, responseTime)
+		}
+		progress.update(run, time.Now())
+		if run.done() == run.total {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-sigChan:
+			interrupted = true
+		}
+	}
+	progress.finish()
+	return run.report(os.Stdout, opts, interrupted)
+}
+
+// report prints the statistics of the run and evaluates the thresholds.
+// A threshold failure wins over the interruption, whose code tells that
+// the numbers are partial.
+func (r *onceRun) report(w io.Writer, opts *onceOptions, interrupted bool) int {
+	stats := computeProbeStats(r.times, r.failures)
+	loss := 100 - stats.SuccessRate
+	fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 50))
+	fmt.Fprintf(w, "📊 ベンチマーク結果（%v）\n", time.Since(r.started).Round(time.Second))
+	fmt.Fprintf(w, "%s\n", strings.Repeat("=", 50))
+	if interrupted {
+		fmt.Fprintf(w, "  ⚠️ 中断しました: %d/%d回の途中結果です\n", r.done(), r.total)
+	}
+	fmt.Fprintf(w, "  ping回数: %d\n", stats.Total)
+	fmt.Fprintf(w, "  成功回数: %d\n", stats.Success)
+	fmt.Fprintf(w, "  失敗回数: %d\n", stats.Failure)
+	if stats.Total > 0 {
+		fmt.Fprintf(w, "  損失率: %.2f%%\n", loss)
+	}
+	if stats.Success > 0 {
+		fmt.Fprintf(w, "  平均: %.1fms  最小: %.1fms  最大: %.1fms  p95: %.1fms\n",
+			stats.Latency.Avg, stats.Latency.Min, stats.Latency.Max, stats.Latency.P95)
+	}
+	if stats.Failure > 0 {
+		fmt.Fprintf(w, "  失敗の内訳: %s\n", r.reasons.String())
+	}
+
+	code := onceExitPass
+	if interrupted {
+		code = onceExitInterrupted
+	}
+	if stats.Total == 0 {
+		fmt.Fprintf(w, "%s\n", strings.Repeat("=", 50))
+		return code
+	}
+	if opts.maxLoss >= 0 && loss > opts.maxLoss {
+		fmt.Fprintf(w, "  ❌ 損失率 %.2f%% が上限 %.2f%% を超えました\n", loss, opts.maxLoss)
+		code = onceExitThreshold
+	}
+	if opts.maxAvgMs >= 0 && (stats.Success == 0 || stats.Latency.Avg > opts.maxAvgMs) {
+		fmt.Fprintf(w, "  ❌ 平均応答時間 %.1fms が上限 %.1fms を超えました\n", stats.Latency.Avg, opts.maxAvgMs)
+		code = onceExitThreshold
+	}
+	fmt.Fprintf(w, "%s\n", strings.Repeat("=", 50))
+	return code
+}
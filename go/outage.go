@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gatewayState is the result of the latest gateway diagnostic
+type gatewayState string
+
+const (
+	gatewayUnknown     gatewayState = "unknown"
+	gatewayReachable   gatewayState = "reachable"
+	gatewayUnreachable gatewayState = "unreachable"
+	// gatewayIsTarget means the only gateway is the target itself, so the
+	// failed probe already answers the diagnostic
+	gatewayIsTarget gatewayState = "target"
+)
+
+// label returns the state for alert messages
+func (s gatewayState) label() string {
+	switch s {
+	case gatewayReachable:
+		return "応答あり"
+	case gatewayUnreachable:
+		return "応答なし"
+	case gatewayIsTarget:
+		return "監視対象と同一"
+	default:
+		return "ゲートウェイ不明"
+	}
+}
+
+// outageTransition is reported by outageTracker when the confirmed state
+// changes. Start is the first failed sample of the outage and End the first
+// successful sample of the streak that confirmed recovery.
+type outageTransition struct {
+	Down  bool
+	Start time.Time
+	End   time.Time
+	// Simulated is set when the failures confirming the outage were injected
+	Simulated bool
+	// Reasons counts the failures from the first failed sample on
+	Reasons reasonCounts
+	// GatewayFailures counts those failures during which the gateway did
+	// not answer either
+	GatewayFailures int
+	// Classes splits the outage's duration by cause
+	Classes OutageClasses
+	// Context is the threshold that confirmed the transition
+	Context AlertContext
+}
+
+// OutageClasses splits downtime by cause. Each failed sample's class lasts
+// until the next sample, so an outage whose gateway dies midway is split
+// at that point.
+type OutageClasses struct {
+	// LANSeconds is time the gateway did not answer either
+	LANSeconds float64 `json:"lan_seconds"`
+	// ISPSeconds is time the gateway answered, so the problem was upstream
+	ISPSeconds float64 `json:"isp_seconds"`
+	// UnclassifiedSeconds is time without a gateway diagnostic
+	UnclassifiedSeconds float64 `json:"unclassified_seconds"`
+}
+
+// add attributes d to the class of gateway diagnostic gw; a gateway that is
+// the target counts as LAN, as in GatewayCounts
+func (c *OutageClasses) add(gw gatewayState, d time.Duration) {
+	switch gw {
+	case gatewayReachable:
+		c.ISPSeconds += d.Seconds()
+	case gatewayUnreachable, gatewayIsTarget:
+		c.LANSeconds += d.Seconds()
+	default:
+		c.UnclassifiedSeconds += d.Seconds()
+	}
+}
+
+// merge adds the durations of o
+func (c *OutageClasses) merge(o OutageClasses) {
+	c.LANSeconds += o.LANSeconds
+	c.ISPSeconds += o.ISPSeconds
+	c.UnclassifiedSeconds += o.UnclassifiedSeconds
+}
+
+// total returns the classified and unclassified time together
+func (c OutageClasses) total() float64 {
+	return c.LANSeconds + c.ISPSeconds + c.UnclassifiedSeconds
+}
+
+// outageClassRow is one cause of the monthly downtime table
+type outageClassRow struct {
+	Label   string
+	Seconds float64
+	Percent float64
+}
+
+// rows returns the classes in display order with their share of the total
+func (c OutageClasses) rows() []outageClassRow {
+	rows := []outageClassRow{
+		{Label: "宅内・ゲートウェイ", Seconds: c.LANSeconds},
+		{Label: "回線・上流", Seconds: c.ISPSeconds},
+		{Label: "未分類", Seconds: c.UnclassifiedSeconds},
+	}
+	if total := c.total(); total > 0 {
+		for i := range rows {
+			rows[i].Percent = rows[i].Seconds / total * 100
+		}
+	}
+	return rows
+}
+
+// String formats the classes as "宅内・ゲートウェイ 5m0s (40.0%) / ..."
+func (c OutageClasses) String() string {
+	return c.format(numberFormat{})
+}
+
+// format is String with the numbers rendered by f
+func (c OutageClasses) format(f numberFormat) string {
+	var parts []string
+	for _, row := range c.rows() {
+		parts = append(parts, fmt.Sprintf("%s %s (%s)", row.Label, f.seconds(row.Seconds), f.percent(row.Percent, 1)))
+	}
+	return strings.Join(parts, " / ")
+}
+
+// causedByGateway reports whether the gateway was down for most of the
+// outage's failures, so the outage is the gateway's rather than the line's
+func (tr *outageTransition) causedByGateway() bool {
+	total := 0
+	for _, n := range tr.Reasons {
+		total += n
+	}
+	return total > 0 && tr.GatewayFailures*2 > total
+}
+
+// outageCauseGateway is OutageRecord.Cause for outages attributed to the
+// gateway
+const outageCauseGateway = "gateway"
+
+// outageTracker confirms outages with hysteresis: failureThreshold
+// consecutive failures are needed to go down and recoveryThreshold
+// consecutive successes to come back up, so a single flapping packet never
+// produces an alert. Each outage yields exactly one down and one up
+// transition.
+type outageTracker struct {
+	mutex             sync.Mutex
+	failureThreshold  int
+	recoveryThreshold int
+	failures          int
+	successes         int
+	down              bool
+	streakStart       time.Time
+	streakSimulated   bool
+	outageStart       time.Time
+	outageSimulated   bool
+	recoveryStart     time.Time
+	since             time.Time
+	reasons           reasonCounts
+	gatewayFailures   int
+	// classAt and classGateway are the latest failure, whose class runs
+	// until the next sample
+	classAt      time.Time
+	classGateway gatewayState
+	classes      OutageClasses
+}
+
+// newOutageTracker creates a tracker in the up state starting at now
+func newOutageTracker(failureThreshold, recoveryThreshold int, now time.Time) *outageTracker {
+	return &outageTracker{failureThreshold: failureThreshold, recoveryThreshold: recoveryThreshold, since: now}
+}
+
+// state returns the confirmed state and when it began
+func (t *outageTracker) state() (down bool, since time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.down, t.since
+}
+
+// shift moves the tracker's times with fn, after the clock was set
+func (t *outageTracker) shift(fn func(time.Time) time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.streakStart, t.outageStart, t.recoveryStart = fn(t.streakStart), fn(t.outageStart), fn(t.recoveryStart)
+	t.since, t.classAt = fn(t.since), fn(t.classAt)
+}
+
+// inProgress returns the start of the current outage, or zero while up,
+// and whether it was simulated
+func (t *outageTracker) inProgress() (time.Time, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.down {
+		return time.Time{}, false
+	}
+	return t.outageStart, t.outageSimulated
+}
+
+// observe feeds one probe result and returns a transition, if any. reason
+// is "" for a success; gw is the gateway diagnostic of a failure.
+func (t *outageTracker) observe(at time.Time, reason failureReason, gw gatewayState) *outageTransition {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if reason == "" {
+		t.failures = 0
+		if !t.down {
+			return nil
+		}
+		if t.successes == 0 {
+			t.recoveryStart = at
+		}
+		t.successes++
+		if t.successes < t.recoveryThreshold {
+			return nil
+		}
+		t.down = false
+		t.successes = 0
+		t.since = t.recoveryStart
+		t.classes.add(t.classGateway, t.recoveryStart.Sub(t.classAt))
+		ctx := AlertContext{Rule: "outage_recovery", Metric: "連続成功", Observed: float64(t.recoveryThreshold), Op: ">=", Threshold: float64(t.recoveryThreshold), Unit: "回"}
+		return &outageTransition{Down: false, Start: t.outageStart, End: t.recoveryStart, Simulated: t.outageSimulated, Reasons: t.reasons, GatewayFailures: t.gatewayFailures, Classes: t.classes, Context: ctx}
+	}
+
+	t.successes = 0
+	if t.down {
+		t.addFailure(at, reason, gw)
+		return nil
+	}
+	if t.failures == 0 {
+		t.streakStart = at
+		t.streakSimulated = true
+		t.reasons = reasonCounts{}
+		t.gatewayFailures = 0
+		t.classAt, t.classes = time.Time{}, OutageClasses{}
+	}
+	t.failures++
+	t.addFailure(at, reason, gw)
+	t.streakSimulated = t.streakSimulated && reason == reasonSimulated
+	if t.failures < t.failureThreshold {
+		return nil
+	}
+	t.down = true
+	t.failures = 0
+	t.outageStart = t.streakStart
+	t.outageSimulated = t.streakSimulated
+	t.since = t.outageStart
+	ctx := AlertContext{Rule: "outage", Metric: "連続失敗", Observed: float64(t.failureThreshold), Op: ">=", Threshold: float64(t.failureThreshold), Unit: "回"}
+	return &outageTransition{Down: true, Start: t.outageStart, Simulated: t.outageSimulated, Reasons: t.reasons, GatewayFailures: t.gatewayFailures, Context: ctx}
+}
+
+// addFailure counts one failure of the current streak or outage
+func (t *outageTracker) addFailure(at time.Time, reason failureReason, gw gatewayState) {
+	t.reasons.add(reason)
+	if gw == gatewayUnreachable {
+		t.gatewayFailures++
+	}
+	if !t.classAt.IsZero() {
+		t.classes.add(t.classGateway, at.Sub(t.classAt))
+	}
+	t.classAt, t.classGateway = at, gw
+}
+
+// notifyOutageTransition turns a transition into an alert event
+func (pm *PingMonitor) notifyOutageTransition(tr *outageTransition) {
+	// The tracker counts samples; the interval turns them into time
+	tr.Context.Window = fmt.Sprintf("ping間隔 %v", pm.pingInterval)
+	if tr.Down {
+		fmt.Printf("🚨 障害を検知しました（%s開始）\n", tr.Start.Format("15:04:05"))
+		pm.metrics.outage()
+		pm.mutex.RLock()
+		gw := pm.gatewayState
+		lastSuccess, lastRTT := pm.lastSuccess, pm.lastSuccessRTT
+		pm.mutex.RUnlock()
+		last := "起動後の成功なし"
+		if !lastSuccess.IsZero() {
+			last = fmt.Sprintf("%s（%s）", lastSuccess.Format("2006-01-02 15:04:05"), pm.format.ms(lastRTT))
+		}
+		message := fmt.Sprintf("**対象**: %s\n**開始**: %s\n**最後の成功**: %s\n**ゲートウェイ**: %s",
+			pm.targetLabel(), tr.Start.Format("2006-01-02 15:04:05"), last, gw.label()) + tr.Context.messageLine()
+		data := map[string]interface{}{
+			"target":  pm.targetIP,
+			"start":   tr.Start.Format(time.RFC3339),
+			"gateway": string(gw),
+			"rule":    tr.Context,
+		}
+		if !lastSuccess.IsZero() {
+			data["last_success"] = lastSuccess.Format(time.RFC3339)
+			data["last_rtt_ms"] = lastRTT
+		}
+		pm.outageConnection = pm.currentConnection()
+		if c := pm.outageConnection; c.Type != connUnknown {
+			message += "\n**接続**: " + c.String()
+			data["connection"] = string(c.Type)
+		}
+		if class := nextStepFor(tr, gw); class != "" {
+			if step := pm.nextStep(class); step != "" {
+				message += "\n**次にやること**: " + step
+				data["next_step"] = string(class)
+				data["next_step_text"] = step
+			}
+		}
+		// The latest raw output helps tell a local problem from a remote one
+		if last, ok := pm.failureLog.latest(pm.targetIP); ok {
+			output := truncateRunes(strings.TrimSpace(last.Output), failureOutputAlertChars)
+			message += fmt.Sprintf("\n**直近の失敗出力**:\n```\n%s\n```", strings.ReplaceAll(output, "```", "'''"))
+			data["last_output"] = output
+		}
+		if link := pm.snoozeURL(pm.outageSnooze.arm(tr.Start)); link != "" {
+			message += fmt.Sprintf("\n**通知の停止**: [確認済みにして復旧まで通知しない](%s)", link)
+			data["snooze_url"] = link
+		}
+		pm.outageEventID = pm.dispatcher.dispatch(Event{
+			Kind:      EventOutage,
+			Severity:  SeverityCritical,
+			Time:      tr.Start,
+			Title:     fmt.Sprintf("🚨 %s 到達不能", pm.targetIP),
+			Message:   message,
+			Simulated: tr.Simulated,
+			Data:      data,
+		})
+		pm.metrics.linkIncident(pm.outageEventID, time.Time{})
+		return
+	}
+
+	duration := tr.End.Sub(tr.Start).Round(time.Second)
+	fmt.Printf("✅ 障害から復旧しました（継続時間 %v）\n", duration)
+	unnotified := pm.dispatcher.blackout.during(tr.Start, tr.End).Round(time.Second)
+	if unnotified > 0 {
+		fmt.Printf("  -> 通知不能時間: %v\n", unnotified)
+	}
+	dominant := tr.Reasons.dominant()
+	rec := OutageRecord{Target: pm.targetIP, Start: tr.Start, End: tr.End, DurationSeconds: tr.End.Sub(tr.Start).Seconds(), Simulated: tr.Simulated, Reason: string(dominant)}
+	if tr.causedByGateway() {
+		rec.Cause = outageCauseGateway
+	}
+	classes := tr.Classes
+	rec.Classes = &classes
+	rec.UnnotifiedSeconds = unnotified.Seconds()
+	rec.Acknowledged = pm.outageSnooze.release(tr.Start)
+	rec.EventID = pm.outageEventID
+	rec.Connection = pm.outageConnection.Type
+	pm.metrics.linkIncident(pm.outageEventID, tr.End.Add(incidentExemplarLinger))
+	if err := pm.store.AppendEvent(rec); err != nil {
+		fmt.Printf("❌ 障害記録の保存エラー: %v\n", err)
+	}
+	pm.mutex.Lock()
+	pm.periodOutages = append(pm.periodOutages, rec)
+	pm.mutex.Unlock()
+	if rec.Acknowledged {
+		fmt.Println("🔕 確認済みの障害のため、復旧通知は送りません")
+		return
+	}
+	message := fmt.Sprintf("**対象**: %s\n**開始**: %s\n**復旧**: %s\n**継続時間**: %s\n**主な原因**: %s",
+		pm.targetLabel(), tr.Start.Format("2006-01-02 15:04:05"), tr.End.Format("2006-01-02 15:04:05"), pm.format.duration(duration), dominant.label())
+	if unnotified > 0 {
+		// The alert of this outage most likely never arrived either
+		message += fmt.Sprintf("\n**通知不能時間**: %s（すべての通知先への送信が失敗していた時間）", pm.format.duration(unnotified))
+	}
+	if before, after := pm.outageConnection, pm.currentConnection(); before.Type != connUnknown && after.Type != connUnknown && before != after {
+		message += fmt.Sprintf("\n**接続**: %s → %s（障害中に切り替わりました）", before, after)
+	}
+	pm.dispatcher.dispatch(Event{
+		Kind:      EventRecovery,
+		Severity:  SeverityInfo,
+		Time:      tr.End,
+		Title:     fmt.Sprintf("✅ %s 到達性 復旧", pm.targetIP),
+		Message:   message + tr.Context.messageLine(),
+		Simulated: tr.Simulated,
+		Data: map[string]interface{}{
+			"target":             pm.targetIP,
+			"start":              tr.Start.Format(time.RFC3339),
+			"end":                tr.End.Format(time.RFC3339),
+			"duration_seconds":   duration.Seconds(),
+			"reason":             string(dominant),
+			"failures":           tr.Reasons.toMap(),
+			"rule":               tr.Context,
+			"unnotified_seconds": unnotified.Seconds(),
+		},
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestOutageTracker feeds one sample per second, written as x for a
+// failure, s for a simulated failure and . for a success, and checks the
+// transitions as "step: down start" and "step: up start-end"
+func TestOutageTracker(t *testing.T) {
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name               string
+		failure, recovery  int
+		samples            string
+		want               []string
+		wantDown           bool
+		wantSinceStep      int
+		wantSimulatedSteps []int
+	}{
+		{"flaps below the threshold", 3, 2, "xx.xx.x.xx.", nil, false, -1, nil},
+		{"exactly at the threshold", 3, 2, "xxx", []string{"2: down 0"}, true, 0, nil},
+		{"one short, then at the threshold", 3, 2, "xx.xxx", []string{"5: down 3"}, true, 3, nil},
+		{"failures during the outage", 3, 2, "xxxxxxxx", []string{"2: down 0"}, true, 0, nil},
+		{"recovery at the threshold", 3, 2, "xxx..", []string{"2: down 0", "4: up 0-3"}, false, 3, nil},
+		{"recovery debounce", 3, 2, "xxx.x.x..", []string{"2: down 0", "8: up 0-7"}, false, 7, nil},
+		{"recovery one short", 3, 3, "xxx..x..", []string{"2: down 0"}, true, 0, nil},
+		{"two outages", 2, 2, "xx..xx..", []string{"1: down 0", "3: up 0-2", "5: down 4", "7: up 4-6"}, false, 6, nil},
+		{"thresholds of one", 1, 1, "x.x.", []string{"0: down 0", "1: up 0-1", "2: down 2", "3: up 2-3"}, false, 3, nil},
+		{"successes while up", 3, 2, "......", nil, false, -1, nil},
+		{"simulated outage", 2, 1, "ss.", []string{"1: down 0", "2: up 0-2"}, false, 2, []int{1, 2}},
+		{"partly simulated", 2, 1, "sx.", []string{"1: down 0", "2: up 0-2"}, false, 2, nil},
+		{"simulated flap before a real outage", 2, 1, "s.xs", []string{"3: down 2"}, true, 2, nil},
+	}
+	for _, tt := range tests {
+		tr := newOutageTracker(tt.failure, tt.recovery, base.Add(-time.Hour))
+		step := func(i int) time.Time { return base.Add(time.Duration(i) * time.Second) }
+		index := func(at time.Time) int { return int(at.Sub(base) / time.Second) }
+		var got []string
+		var simulated []int
+		for i, c := range tt.samples {
+			reason := failureReason("")
+			switch c {
+			case 'x':
+				reason = reasonTimeout
+			case 's':
+				reason = reasonSimulated
+			}
+			transition := tr.observe(step(i), reason, gatewayUnknown)
+			if transition == nil {
+				continue
+			}
+			if transition.Down {
+				got = append(got, fmt.Sprintf("%d: down %d", i, index(transition.Start)))
+			} else {
+				got = append(got, fmt.Sprintf("%d: up %d-%d", i, index(transition.Start), index(transition.End)))
+			}
+			if transition.Simulated {
+				simulated = append(simulated, i)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: transitions %q, want %q", tt.name, got, tt.want)
+		}
+		if !slices.Equal(simulated, tt.wantSimulatedSteps) {
+			t.Errorf("%s: simulated transitions at %v, want %v", tt.name, simulated, tt.wantSimulatedSteps)
+		}
+		down, since := tr.state()
+		wantSince := base.Add(-time.Hour)
+		if tt.wantSinceStep >= 0 {
+			wantSince = step(tt.wantSinceStep)
+		}
+		if down != tt.wantDown || !since.Equal(wantSince) {
+			t.Errorf("%s: state %v since %s, want %v since %s", tt.name, down, since.Format("15:04:05"), tt.wantDown, wantSince.Format("15:04:05"))
+		}
+		if start, _ := tr.inProgress(); down != !start.IsZero() {
+			t.Errorf("%s: inProgress %s while down=%v", tt.name, start, down)
+		}
+	}
+}
+
+// TestOutageTrackerCounts checks the reasons, gateway failures and classes
+// carried by the transitions of one outage
+func TestOutageTrackerCounts(t *testing.T) {
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+	tr := newOutageTracker(2, 2, base)
+
+	// A flap whose failure must not leak into the outage's counts
+	tr.observe(at(0), reasonUnreachable, gatewayUnreachable)
+	tr.observe(at(1), "", "")
+
+	tr.observe(at(2), reasonTimeout, gatewayUnreachable)
+	down := tr.observe(at(3), reasonTimeout, gatewayUnreachable)
+	if down == nil || !down.Down {
+		t.Fatalf("no down transition: %+v", down)
+	}
+	if down.Reasons[reasonIndex(reasonTimeout)] != 2 || down.GatewayFailures != 2 {
+		t.Errorf("down: reasons %v, gateway failures %d", down.Reasons, down.GatewayFailures)
+	}
+	tr.observe(at(4), reasonUnreachable, gatewayReachable)
+	tr.observe(at(5), reasonUnreachable, gatewayReachable)
+	tr.observe(at(6), "", "")
+	// A failure between the successes restarts the recovery streak
+	tr.observe(at(7), reasonUnreachable, gatewayReachable)
+	tr.observe(at(8), "", "")
+	up := tr.observe(at(9), "", "")
+	if up == nil || up.Down {
+		t.Fatalf("no up transition: %+v", up)
+	}
+	if !up.Start.Equal(at(2)) || !up.End.Equal(at(8)) {
+		t.Errorf("outage %s-%s, want 12:00:02-12:00:08", up.Start.Format("15:04:05"), up.End.Format("15:04:05"))
+	}
+	if up.Reasons[reasonIndex(reasonTimeout)] != 2 || up.Reasons[reasonIndex(reasonUnreachable)] != 3 || up.GatewayFailures != 2 {
+		t.Errorf("up: reasons %v, gateway failures %d", up.Reasons, up.GatewayFailures)
+	}
+	if up.causedByGateway() {
+		t.Error("2 of 5 failures without the gateway attributed to the gateway")
+	}
+	// 2s and 3s without the gateway, from 4s on upstream until the recovery
+	want := OutageClasses{LANSeconds: 2, ISPSeconds: 4}
+	if up.Classes != want {
+		t.Errorf("classes %+v, want %+v", up.Classes, want)
+	}
+	if up.Context.Rule != "outage_recovery" || down.Context.Rule != "outage" {
+		t.Errorf("rules %q/%q", down.Context.Rule, up.Context.Rule)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboxMessage is a Discord webhook message that could not be delivered
+// before shutdown, stored under state_dir/outbox until the next start
+type OutboxMessage struct {
+	Created     time.Time `json:"created"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+}
+
+// outbox is the persistent queue of undelivered webhook messages
+type outbox struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+// newOutbox returns the queue kept under stateDir
+func newOutbox(stateDir string) *outbox {
+	return &outbox{dir: filepath.Join(stateDir, "outbox")}
+}
+
+// put stores one message; file names sort in creation order
+func (o *outbox) put(contentType string, body []byte) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	now := time.Now()
+	msg := OutboxMessage{Created: now, ContentType: contentType, Body: body}
+	name := strconv.FormatInt(now.UnixNano(), 10) + ".json"
+	return writeStateFile(filepath.Join(o.dir, name), "outbox", msg)
+}
+
+// pending lists the stored messages, oldest first
+func (o *outbox) pending() ([]string, error) {
+	entries, err := os.ReadDir(o.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			paths = append(paths, filepath.Join(o.dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// drain resends stored messages in order with send, removing each one that
+// was delivered. It stops at the first failure so the order is kept.
+func (o *outbox) drain(send func(contentType string, body []byte) error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	paths, err := o.pending()
+	if err != nil {
+		fmt.Printf("❌ 未送信キューの読み込みエラー: %v\n", err)
+		return
+	}
+	for _, path := range paths {
+		var msg OutboxMessage
+		if err := readStateFile(path, "outbox", &msg); err != nil {
+			if os.IsNotExist(err) {
+				fmt.Printf("❌ 未送信キューの読み込みエラー: %v\n", err)
+				return
+			}
+			fmt.Printf("❌ 未送信キュー %s の形式が正しくありません: %v\n", path, err)
+			continue
+		}
+		if err := send(msg.ContentType, msg.Body); err != nil {
+			fmt.Printf("❌ 未送信メッセージの再送エラー: %v\n", err)
+			return
+		}
+		os.Remove(path)
+		fmt.Printf("📮 %sに保存された未送信メッセージを送信しました\n", msg.Created.Format("2006-01-02 15:04:05"))
+	}
+}
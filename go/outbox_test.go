@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hangingDiscord is a webhook that, while hang is set, holds every POST
+// until the client gives up, and otherwise answers as Discord does with
+// ?wait=true
+type hangingDiscord struct {
+	mutex     sync.Mutex
+	hang      bool
+	inflight  chan []byte
+	delivered [][]byte
+}
+
+func (d *hangingDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	d.mutex.Lock()
+	hang := d.hang
+	d.mutex.Unlock()
+	if hang {
+		d.inflight <- body
+		<-r.Context().Done()
+		return
+	}
+	d.mutex.Lock()
+	d.delivered = append(d.delivered, body)
+	d.mutex.Unlock()
+	w.Write([]byte(`{"id": "1", "channel_id": "2"}`))
+}
+
+// TestShutdownKillsSendMidFlight stops the monitor while Discord holds the
+// interim report: the day must be on disk before the send starts, the
+// send aborted at the shutdown deadline must land in the outbox, and the
+// next start must send it exactly once
+func TestShutdownKillsSendMidFlight(t *testing.T) {
+	quietStdout(t)
+	discord := &hangingDiscord{hang: true, inflight: make(chan []byte, 1)}
+	server := httptest.NewServer(discord)
+	defer server.Close()
+
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	state := filepath.Join(t.TempDir(), "state")
+	cfg := func() map[string]interface{} {
+		return map[string]interface{}{
+			"ping_interval":       "1s",
+			"discord_webhook_url": server.URL,
+			"state_dir":           state,
+			"shutdown_timeout":    "300ms",
+		}
+	}
+	pm, clock := newTestMonitor(t, cfg(), start)
+	pm.prober = probeFunc(func(string) (float64, error) {
+		if s := clock.now().Sub(start) / time.Second; s%25 == 0 {
+			return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+		}
+		return 10, nil
+	})
+	for s := 0; s < 100; s++ {
+		at := start.Add(time.Duration(s) * time.Second)
+		clock.set(at)
+		pm.tick(at)
+	}
+	date := start.Format(reportDateLayout)
+
+	stopped := make(chan struct{})
+	go func() {
+		pm.Stop()
+		close(stopped)
+	}()
+	var sent []byte
+	select {
+	case sent = <-discord.inflight:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the interim report was never sent")
+	}
+	// A kill now, with the POST in flight, would keep the day
+	day, err := pm.history.loadDay(date)
+	if err != nil {
+		t.Fatalf("day not persisted before the send: %v", err)
+	}
+	count, failures := 0, 0
+	for _, h := range day.Hours {
+		count += h.Count
+		failures += h.Failures
+	}
+	if count != 100 || failures != 4 {
+		t.Errorf("persisted %d samples and %d failures, want 100 and 4", count, failures)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return after the shutdown deadline")
+	}
+	// Stop closed the dispatcher; the cleanup closes this one instead
+	pm.dispatcher = newDispatcher(nil, nil, nil, textStyle{}, pm.now)
+
+	paths, err := pm.outbox.pending()
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("outbox %v, %v; want the aborted report", paths, err)
+	}
+	var queued OutboxMessage
+	if err := readStateFile(paths[0], "outbox", &queued); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(queued.Body, sent) || queued.ContentType != "application/json" {
+		t.Errorf("queued %s %q, want the body in flight %q", queued.ContentType, queued.Body, sent)
+	}
+	discord.mutex.Lock()
+	if len(discord.delivered) != 0 {
+		t.Errorf("%d sends delivered while Discord hung", len(discord.delivered))
+	}
+	discord.hang = false
+	discord.mutex.Unlock()
+
+	// The next start finds the same day and sends the report once
+	next, _ := newTestMonitor(t, cfg(), start.Add(time.Hour))
+	if again, err := next.history.loadDay(date); err != nil || !reflect.DeepEqual(again.Hours, day.Hours) {
+		t.Errorf("day after the restart: %+v, %v", again, err)
+	}
+	next.dispatcher.flush()
+	next.outbox.drain(next.postDiscord)
+	next.outbox.drain(next.postDiscord)
+	discord.mutex.Lock()
+	if len(discord.delivered) != 1 || !bytes.Equal(discord.delivered[0], sent) {
+		t.Errorf("delivered %q, want the queued report once", discord.delivered)
+	}
+	discord.mutex.Unlock()
+	if paths, _ := next.outbox.pending(); len(paths) != 0 {
+		t.Errorf("outbox left %v", paths)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// peerDefaultTimeout is used when peer.timeout is not set
+	peerDefaultTimeout = 2 * time.Second
+	// peerMaxTimeout caps peer.timeout, so a dead peer never holds up the
+	// daily report for long
+	peerMaxTimeout = 10 * time.Second
+)
+
+// PeerConfig names a sibling monitor, typically on another line, whose day
+// is shown next to this one in the daily report
+type PeerConfig struct {
+	// URL is the peer's API base, e.g. http://10.0.0.2:8080 or
+	// http://10.0.0.2:8080/p/main for a profile
+	URL   string `json:"url"`
+	Token string `json:"token" secret:"true"`
+	// Name labels the peer; defaults to its site_name
+	Name string `json:"name"`
+	// Timeout bounds the whole request (default 2s)
+	Timeout Duration `json:"timeout"`
+}
+
+// validate checks the URL and the timeout
+func (c PeerConfig) validate() error {
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("peer.url の値が正しくありません: %q (例: http://10.0.0.2:8080)", c.URL)
+	}
+	if !c.Timeout.given() {
+		return nil
+	}
+	return c.Timeout.check("peer.timeout", positiveDuration, peerMaxTimeout, "2s")
+}
+
+// timeout returns the request timeout
+func (c PeerConfig) timeout() time.Duration {
+	return c.Timeout.or(peerDefaultTimeout)
+}
+
+// statusDay summarizes period p
+func statusDay(p *reportPeriod) StatusDay {
+	stats := p.probeStats()
+	return StatusDay{
+		Date:        p.Date,
+		Total:       stats.Total,
+		SuccessRate: stats.SuccessRate,
+		AvgMs:       stats.Latency.Avg,
+		Sufficient:  p.Coverage.Sufficient,
+		PeriodStart: p.Start,
+		PeriodEnd:   p.End,
+	}
+}
+
+// fetchPeerDay asks the peer's /status for the day date: its current day,
+// or the day it finished last when it already rolled over. It also returns
+// the peer's site name.
+func fetchPeerDay(c PeerConfig, date string) (StatusDay, string, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.URL, "/")+"/status", nil)
+	if err != nil {
+		return StatusDay{}, "", err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := &http.Client{Timeout: c.timeout()}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL is in the note's context already
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return StatusDay{}, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return StatusDay{}, "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var status StatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return StatusDay{}, "", err
+	}
+	for _, day := range []*StatusDay{status.Today, status.LastDay} {
+		if day != nil && day.Date == date {
+			return *day, status.Site, nil
+		}
+	}
+	return StatusDay{}, status.Site, fmt.Errorf("%sのデータがありません", date)
+}
+
+// peerComparison renders this monitor's day next to the peer's, or a note
+// when the peer could not be asked
+func (pm *PingMonitor) peerComparison(p *reportPeriod) string {
+	c := pm.config.Peer
+	peer, site, err := fetchPeerDay(*c, p.Date)
+	name := c.Name
+	if name == "" {
+		name = site
+	}
+	if err != nil {
+		if name == "" {
+			return fmt.Sprintf("比較先に到達できず（%v）", err)
+		}
+		return fmt.Sprintf("比較先（%s）に到達できず（%v）", name, err)
+	}
+	if name == "" {
+		name = "比較先"
+	}
+	self := statusDay(p)
+	f := p.Format
+	line := func(label string, d StatusDay) string {
+		marker := ""
+		if !d.Sufficient {
+			marker = insufficientMarker
+		}
+		return fmt.Sprintf("**%s**: 成功率 %s%s / 平均 %s", label, f.percent(d.SuccessRate, 2), marker, f.ms(d.AvgMs))
+	}
+	return line(pm.config.SiteName, self) + "\n" + line(name, peer)
+}
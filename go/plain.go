@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// plainReplacer maps symbols with an ASCII equivalent; everything else
+// that asciiText drops is decoration
+var plainReplacer = strings.NewReplacer(
+	"→", "->", "←", "<-", "↑", "^", "↓", "v", "⇒", "=>",
+	"≥", ">=", "≤", "<=", "≠", "!=", "×", "x", "±", "+/-", "÷", "/",
+	"…", "...", "–", "-", "—", "-", "‘", "'", "’", "'", "“", "\"", "”", "\"", "•", "*", "·", "-",
+	"─", "-", "━", "-", "═", "=", "│", "|", "┃", "|", "║", "|",
+	"┌", "+", "┐", "+", "└", "+", "┘", "+", "├", "+", "┤", "+", "┬", "+", "┴", "+", "┼", "+",
+	"▁", ".", "▂", ":", "▃", "-", "▄", "=", "▅", "+", "▆", "*", "▇", "#", "█", "@",
+	"░", ".", "▒", ":", "▓", "#",
+)
+
+// isEmoji reports whether r is a pictograph, or one of the invisible
+// joiners and selectors that build emoji sequences
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, // pictographs, flags, emoticons
+		r >= 0x2600 && r <= 0x27BF, // symbols and dingbats (✅ ❌ ⚠)
+		r >= 0x2300 && r <= 0x23FF, // technical (⏰ ⏱ ⌛)
+		r >= 0x2B00 && r <= 0x2BFF, // arrows and stars (⬆ ⭐)
+		r == 0x200D, r == 0x20E3,   // joiner, keycap
+		r >= 0xFE00 && r <= 0xFE0F,   // variation selectors
+		r >= 0xE0020 && r <= 0xE007F, // tag characters
+		r == 0x2139, r == 0x3030, r == 0x303D:
+		return true
+	}
+	return false
+}
+
+// asciiText rewrites s for receivers that mangle emoji and box drawing:
+// symbols with an ASCII form are replaced and pictographs are dropped with
+// the space that separated them from the text. The Japanese text itself
+// is kept.
+func asciiText(s string) string {
+	s = plainReplacer.Replace(s)
+	var b strings.Builder
+	b.Grow(len(s))
+	// skipSpace drops the space after a pictograph at the start of a
+	// line or after another space, so "🚨 障害" becomes "障害"
+	skipSpace := false
+	prev := '\n'
+	for _, r := range s {
+		if isEmoji(r) {
+			skipSpace = prev == '\n' || prev == ' ' || prev == '(' || skipSpace
+			continue
+		}
+		if skipSpace && r == ' ' {
+			skipSpace = false
+			continue
+		}
+		skipSpace = false
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
+
+// textStyle renders what leaves the process: notifications, the report
+// text and the console. The zero value passes everything through.
+type textStyle struct {
+	plain bool
+}
+
+// text renders s
+func (st textStyle) text(s string) string {
+	if !st.plain {
+		return s
+	}
+	return asciiText(s)
+}
+
+// event renders the user-visible parts of ev
+func (st textStyle) event(ev Event) Event {
+	if !st.plain {
+		return ev
+	}
+	ev.Title = asciiText(ev.Title)
+	ev.Message = asciiText(ev.Message)
+	ev.Fields = st.fields(ev.Fields)
+	return ev
+}
+
+// fields renders embed fields into a new slice
+func (st textStyle) fields(fields []EmbedField) []EmbedField {
+	if !st.plain || fields == nil {
+		return fields
+	}
+	out := make([]EmbedField, len(fields))
+	for i, f := range fields {
+		out[i] = EmbedField{Name: asciiText(f.Name), Value: asciiText(f.Value), Inline: f.Inline}
+	}
+	return out
+}
+
+// discord renders every embed of message
+func (st textStyle) discord(message DiscordMessage) DiscordMessage {
+	if !st.plain {
+		return message
+	}
+	embeds := make([]DiscordEmbed, len(message.Embeds))
+	for i, e := range message.Embeds {
+		e.Title = asciiText(e.Title)
+		e.Description = asciiText(e.Description)
+		e.Fields = st.fields(e.Fields)
+		e.Footer.Text = asciiText(e.Footer.Text)
+		embeds[i] = e
+	}
+	message.Embeds = embeds
+	return message
+}
+
+// asciiWriter passes writes through asciiText; writes of the log package
+// are whole lines
+type asciiWriter struct {
+	next io.Writer
+}
+
+func (w asciiWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.next, asciiText(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startPlainConsole filters stdout and the log package through asciiText.
+// Stdout is filtered line by line, so multi-byte characters are never
+// split.
+func startPlainConsole() (func(), error) {
+	out := os.Stdout
+	restore, err := redirectStdout(func(line []byte) {
+		if utf8.Valid(line) {
+			line = []byte(asciiText(string(line)))
+		}
+		out.Write(line)
+	})
+	if err != nil {
+		return nil, err
+	}
+	logOut := log.Writer()
+	log.SetOutput(asciiWriter{next: logOut})
+	return func() {
+		restore()
+		log.SetOutput(logOut)
+	}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// updateGolden rewrites the golden files under testdata
+var updateGolden = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// checkGolden compares got with testdata/name, or rewrites it with -update
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file:\n got:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestASCIIText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"🚨 Google到達不能", "Google到達不能"},
+		{"✅ 復旧 → 正常", "復旧 -> 正常"},
+		{"p95 ≥ 150ms…", "p95 >= 150ms..."},
+		{"(🧪 test)", "(test)"},
+		{"👩‍💻 keycap 1️⃣", "keycap 1"},
+		{"┌─┐\n│x│\n└─┘", "+-+\n|x|\n+-+"},
+		{"▁▂▃█", ".:-@"},
+		{"08:00 – 09:00", "08:00 - 09:00"},
+	}
+	for _, tt := range tests {
+		if got := asciiText(tt.in); got != tt.want {
+			t.Errorf("asciiText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestPlainEnglishReport renders a day with failures, an outage and an
+// additional target as /report/today does with plain_output and lang en,
+// and checks it byte for byte and that every byte is ASCII
+func TestPlainEnglishReport(t *testing.T) {
+	setLocal(t, "Asia/Tokyo")
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	pm, clock := newTestMonitor(t, map[string]interface{}{
+		"plain_output":        true,
+		"lang":                "en",
+		"locale":              "en",
+		"ping_interval":       "1s",
+		"min_report_coverage": "1m",
+		"min_report_samples":  10,
+		"reverse_dns":         map[string]interface{}{"enabled": false},
+	}, start)
+	pm.prober = probeFunc(func(host string) (float64, error) {
+		s := int(clock.now().Sub(start) / time.Second)
+		if host == pm.targetIP && (s == 100 || (s >= 600 && s < 640)) {
+			return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+		}
+		return float64(10 + s%7), nil
+	})
+	for s := 0; s < 1200; s++ {
+		at := start.Add(time.Duration(s) * time.Second)
+		clock.set(at)
+		pm.tick(at)
+	}
+	p := pm.takePeriod(start.Format(reportDateLayout))
+	p.Targets = []targetPeriod{{Name: "dns", Address: "1.1.1.1", UnreachableTimes: []time.Time{start}}}
+	for i := 0; i < 999; i++ {
+		p.Targets[0].Latency.add(20)
+	}
+
+	var b strings.Builder
+	pm.writeDailyReport(&b, p)
+	got := pm.style.text(b.String())
+	for i := 0; i < len(got); i++ {
+		if got[i] >= 0x80 {
+			t.Fatalf("byte %#x at %d in %q", got[i], i, got[max(0, i-20):min(len(got), i+20)])
+		}
+	}
+	checkGolden(t, "plain_report_en.txt", got)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// presetTarget is one monitor a preset expands to: config keys applied
+// under the profile's own, and a suffix appended to the profile name when
+// the preset has several
+type presetTarget struct {
+	suffix string
+	keys   map[string]interface{}
+	// finish sets the target from what is only known after the profile's
+	// keys are applied; it is skipped when the profile gives a target
+	finish func(cfg *Config) error
+}
+
+// targetPresets are the curated targets a profile can name with "preset"
+var targetPresets = map[string][]presetTarget{
+	// Two anycast resolvers run by different operators, so one provider's
+	// trouble is not taken for the internet's
+	"internet": {
+		{suffix: "google", keys: map[string]interface{}{"target": "8.8.8.8"}},
+		{suffix: "cloudflare", keys: map[string]interface{}{"target": "1.1.1.1"}},
+	},
+	// The same resolvers by name, re-resolved every minute so a failing
+	// resolver shows up as dns failures
+	"dns": {
+		{suffix: "google", keys: map[string]interface{}{"target": "dns.google", "target_resolve_interval": "1m"}},
+		{suffix: "cloudflare", keys: map[string]interface{}{"target": "one.one.one.one", "target_resolve_interval": "1m"}},
+	},
+	// The default gateway itself; diagnosing it through itself tells
+	// nothing, so gateway diagnosis is off
+	"gateway": {
+		{keys: map[string]interface{}{"gateway": gatewayOff}, finish: presetGatewayTarget},
+	},
+}
+
+// presetNames lists the presets for messages
+func presetNames() string {
+	names := make([]string, 0, len(targetPresets))
+	for name := range targetPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " / ")
+}
+
+// presetGatewayTarget sets the target to the detected default gateway
+func presetGatewayTarget(cfg *Config) error {
+	gw, _, attempts := runDetection(cfg.gatewayDetection(), gatewayProviders)
+	if gw == "" {
+		return fmt.Errorf("preset gateway: ゲートウェイを検出できません（%s）。target を指定してください", formatAttempts(attempts))
+	}
+	cfg.Target = gw
+	return nil
+}
+
+// printEffectiveConfig prints the configs after preset expansion, by
+// profile name when there are profiles, with secrets masked
+func printEffectiveConfig(profiles []profileConfig, configs []Config) {
+	var out interface{} = redactConfig(configs[0])
+	if len(profiles) > 0 {
+		byName := make(map[string]Config)
+		for _, p := range profiles {
+			byName[p.Name] = redactConfig(p.Config)
+		}
+		out = map[string]interface{}{"profiles": byName}
+	}
+	data, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(data))
+	fmt.Fprintln(os.Stderr, "✅ 設定は正しく読み込めました")
+}
+
+// presetProfile is a profile after preset expansion
+type presetProfile struct {
+	name   string
+	keys   map[string]json.RawMessage
+	finish func(cfg *Config) error
+}
+
+// expandPreset returns the profiles that the profile name with keys stands
+// for: itself without a preset, or one per target of its preset. The
+// profile's own keys override the preset's.
+func expandPreset(name string, keys map[string]json.RawMessage) ([]presetProfile, error) {
+	raw, ok := keys["preset"]
+	if !ok {
+		return []presetProfile{{name: name, keys: keys}}, nil
+	}
+	var preset string
+	if err := json.Unmarshal(raw, &preset); err != nil {
+		return nil, fmt.Errorf("profiles.%s.preset の形式が正しくありません: %v", name, err)
+	}
+	targets, ok := targetPresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("profiles.%s.preset の値が正しくありません: %q (%s)", name, preset, presetNames())
+	}
+
+	var out []presetProfile
+	for _, t := range targets {
+		merged := make(map[string]json.RawMessage)
+		for k, v := range t.keys {
+			data, _ := json.Marshal(v)
+			merged[k] = data
+		}
+		for k, v := range keys {
+			if k != "preset" {
+				merged[k] = v
+			}
+		}
+		p := presetProfile{name: name, keys: merged, finish: t.finish}
+		if _, ok := keys["target"]; ok {
+			p.finish = nil
+		}
+		if len(targets) > 1 {
+			p.name = name + "-" + t.suffix
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeCommandMaxTimeout caps probe_command.timeout, leaving room for the
+// ping interval's other work
+const probeCommandMaxTimeout = 30 * time.Second
+
+// probeCommandRTTGroup names the capture group of rtt_pattern holding the
+// response time in milliseconds
+const probeCommandRTTGroup = "rtt"
+
+// ProbeCommandConfig replaces ping for the target with another command,
+// e.g. ping inside a VRF or hping3 for TCP SYN timing. The command is run
+// directly, never through a shell.
+type ProbeCommandConfig struct {
+	// Command is the argv; {target} and {timeout_ms} in the arguments are
+	// replaced by the address and the timeout
+	Command []string `json:"command"`
+	// RTTPattern finds the response time in the output through its (?P<rtt>)
+	// group; empty uses the time the command took
+	RTTPattern string `json:"rtt_pattern"`
+	// SuccessExitCodes are the exit codes of a successful probe (default [0])
+	SuccessExitCodes []int `json:"success_exit_codes"`
+	// Timeout is how long a probe may take (default 3s)
+	Timeout Duration `json:"timeout"`
+}
+
+// validate checks the command, the pattern, the exit codes and the timeout
+func (c ProbeCommandConfig) validate() error {
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return fmt.Errorf("probe_command.command にコマンドと引数を配列で指定してください（例: [\"ip\", \"vrf\", \"exec\", \"blue\", \"ping\", \"-c\", \"1\", \"{target}\"]）")
+	}
+	if strings.Contains(c.Command[0], "{") {
+		return fmt.Errorf("probe_command.command の1つ目（実行するコマンド）にはプレースホルダーを使えません: %q", c.Command[0])
+	}
+	if c.RTTPattern != "" {
+		re, err := regexp.Compile(c.RTTPattern)
+		if err != nil {
+			return fmt.Errorf("probe_command.rtt_pattern の正規表現が正しくありません: %v", err)
+		}
+		if re.SubexpIndex(probeCommandRTTGroup) < 0 {
+			return fmt.Errorf("probe_command.rtt_pattern には応答時間（ミリ秒）を取り出す (?P<rtt>...) を含めてください")
+		}
+	}
+	for _, code := range c.SuccessExitCodes {
+		if code < 0 || code > 255 {
+			return fmt.Errorf("probe_command.success_exit_codes は0〜255で指定してください: %d", code)
+		}
+	}
+	if c.Timeout.given() {
+		return c.Timeout.check("probe_command.timeout", positiveDuration, probeCommandMaxTimeout, "3s")
+	}
+	return nil
+}
+
+// commandProber probes the target with probe_command
+type commandProber struct {
+	argv    []string
+	rtt     *regexp.Regexp
+	success []int
+	timeout time.Duration
+}
+
+// newCommandProber prepares a validated probe_command
+func newCommandProber(c ProbeCommandConfig) *commandProber {
+	p := &commandProber{argv: c.Command, success: c.SuccessExitCodes, timeout: c.Timeout.or(pingTimeout)}
+	if c.RTTPattern != "" {
+		p.rtt = regexp.MustCompile(c.RTTPattern)
+	}
+	if len(p.success) == 0 {
+		p.success = []int{0}
+	}
+	return p
+}
+
+// args returns the command's arguments for host
+func (p *commandProber) args(host string) []string {
+	replacer := strings.NewReplacer("{target}", host, "{timeout_ms}", strconv.FormatInt(p.timeout.Milliseconds(), 10))
+	args := make([]string, len(p.argv)-1)
+	for i, arg := range p.argv[1:] {
+		args[i] = replacer.Replace(arg)
+	}
+	return args
+}
+
+// Probe runs the command for host. Failures are classified like ping's,
+// so commands that print ping's messages get the same reasons.
+func (p *commandProber) Probe(host string) (float64, error) {
+	start := time.Now()
+	output, err := runCommand(p.timeout, p.argv[0], p.args(host)...)
+	duration := time.Since(start)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && slices.Contains(p.success, exitErr.ExitCode()) {
+		err = nil
+	} else if err == nil && !slices.Contains(p.success, 0) {
+		err = fmt.Errorf("exit status 0")
+	}
+	if err != nil {
+		reason := classifyPingFailure(string(output), err)
+		// A command given by path is not looked up, so it is missing this way
+		if errors.Is(err, os.ErrNotExist) {
+			reason = reasonMeasurement
+		}
+		return 0, &probeError{reason: reason, err: err, output: string(output)}
+	}
+
+	if p.rtt == nil {
+		return float64(duration.Nanoseconds()) / 1000000, nil
+	}
+	if match := p.rtt.FindStringSubmatch(string(output)); match != nil {
+		if ms, err := strconv.ParseFloat(match[p.rtt.SubexpIndex(probeCommandRTTGroup)], 64); err == nil {
+			return ms, nil
+		}
+	}
+	return 0, &probeError{reason: reasonMeasurement, err: errUnparsedOutput, output: string(output)}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeScript writes an executable shell script into dir
+func fakeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestCommandProber runs probe_command with small fake scripts
+func TestCommandProber(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts")
+	}
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	// record writes each argument on its own line, as received
+	record := fakeScript(t, dir, "record.sh", `for a in "$@"; do printf '%s\n' "$a"; done > `+argsFile+`
+echo "reply from $1: rtt=12.5 ms"
+`)
+	unreachable := fakeScript(t, dir, "unreachable.sh", "echo 'From 10.0.0.2 icmp_seq=1 Destination Host Unreachable'\nexit 1\n")
+	exit2 := fakeScript(t, dir, "exit2.sh", "echo 'rtt=3 ms'\nexit 2\n")
+	garbage := fakeScript(t, dir, "garbage.sh", "echo 'no time here'\n")
+	hang := fakeScript(t, dir, "hang.sh", "sleep 10\n")
+
+	tests := []struct {
+		name   string
+		cfg    ProbeCommandConfig
+		ms     float64
+		reason failureReason
+	}{
+		{"rtt from the output", ProbeCommandConfig{Command: []string{record, "{target}", "-w", "{timeout_ms}"}, RTTPattern: `rtt=(?P<rtt>[\d.]+) ms`}, 12.5, ""},
+		{"failure classified like ping", ProbeCommandConfig{Command: []string{unreachable}}, 0, reasonUnreachable},
+		{"other exit code as success", ProbeCommandConfig{Command: []string{exit2}, RTTPattern: `rtt=(?P<rtt>\d+)`, SuccessExitCodes: []int{0, 2}}, 3, ""},
+		{"exit 0 not a success", ProbeCommandConfig{Command: []string{record, "x"}, SuccessExitCodes: []int{1}}, 0, reasonError},
+		{"rtt not found", ProbeCommandConfig{Command: []string{garbage}, RTTPattern: `rtt=(?P<rtt>\d+)`}, 0, reasonMeasurement},
+		{"missing command", ProbeCommandConfig{Command: []string{filepath.Join(dir, "missing.sh")}}, 0, reasonMeasurement},
+		{"killed after the timeout", ProbeCommandConfig{Command: []string{hang}, Timeout: makeDuration(50 * time.Millisecond)}, 0, reasonTimeout},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		ms, err := newCommandProber(tt.cfg).Probe("192.0.2.1")
+		if tt.reason == "" {
+			if err != nil || ms != tt.ms {
+				t.Errorf("%s: %v, %v; want %v", tt.name, ms, err, tt.ms)
+			}
+			continue
+		}
+		var pe *probeError
+		if !errors.As(err, &pe) || pe.reason != tt.reason {
+			t.Errorf("%s: %v, want reason %s", tt.name, err, tt.reason)
+		}
+	}
+
+	// Without rtt_pattern the time the command took counts
+	if ms, err := newCommandProber(ProbeCommandConfig{Command: []string{garbage}}).Probe("192.0.2.1"); err != nil || ms <= 0 || ms > 2000 {
+		t.Errorf("elapsed time: %v, %v", ms, err)
+	}
+
+	// argv only: shell syntax in the target reaches the command as one
+	// argument and is not run
+	marker := filepath.Join(dir, "injected")
+	host := "192.0.2.1; touch " + marker + " $(touch " + marker + ")"
+	p := newCommandProber(ProbeCommandConfig{Command: []string{record, "--host={target}", "{timeout_ms}"}, Timeout: makeDuration(1500 * time.Millisecond)})
+	if _, err := p.Probe(host); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "--host="+host+"\n1500\n"; got != want {
+		t.Errorf("arguments %q, want %q", got, want)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("the target was interpreted by a shell")
+	}
+}
+
+func TestProbeCommandValidate(t *testing.T) {
+	tests := []struct {
+		cfg  ProbeCommandConfig
+		want string
+	}{
+		{ProbeCommandConfig{}, "配列で指定してください"},
+		{ProbeCommandConfig{Command: []string{"{target}"}}, "プレースホルダーを使えません"},
+		{ProbeCommandConfig{Command: []string{"ping"}, RTTPattern: "time=("}, "正規表現が正しくありません"},
+		{ProbeCommandConfig{Command: []string{"ping"}, RTTPattern: `time=(\d+)`}, "(?P<rtt>...)"},
+		{ProbeCommandConfig{Command: []string{"ping"}, SuccessExitCodes: []int{256}}, "0〜255"},
+		{ProbeCommandConfig{Command: []string{"ping"}, Timeout: makeDuration(time.Minute)}, "probe_command.timeout"},
+		{ProbeCommandConfig{Command: []string{"ping", "{target}"}, RTTPattern: `time=(?P<rtt>\d+)`, SuccessExitCodes: []int{0, 1}, Timeout: makeDuration(time.Second)}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", tt.cfg, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: %v, want %q", tt.cfg, err, tt.want)
+		}
+	}
+}
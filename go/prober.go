@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errSimulatedFailure is returned by faultInjector for injected failures
+var errSimulatedFailure = errors.New("simulated failure")
+
+// errUnparsedOutput is returned when a probe's output has no response time
+// and the elapsed time cannot stand in for it
+var errUnparsedOutput = errors.New("response time not found in output")
+
+// Prober sends a single probe to a host and returns the response time in
+// milliseconds
+type Prober interface {
+	Probe(host string) (float64, error)
+}
+
+// unixPingTimeRe matches the response time in C-locale ping output of
+// iputils, BSD/macOS and busybox ("time=12.3 ms", "time<1 ms")
+var unixPingTimeRe = regexp.MustCompile(`time[=<](\d+(?:\.\d+)?) ?ms`)
+
+// windowsPingTimeRe matches the response time in Japanese Windows output
+// ("時間 =12ms", "時間 <1ms")
+var windowsPingTimeRe = regexp.MustCompile(`時間 ?[<>=] ?(\d+) ?ms`)
+
+// parsePingTime extracts the response time in milliseconds from ping output
+func parsePingTime(output string, windows bool) (float64, bool) {
+	re := unixPingTimeRe
+	if windows {
+		re = windowsPingTimeRe
+	}
+	if match := re.FindStringSubmatch(output); len(match) > 1 {
+		if ms, err := strconv.ParseFloat(match[1], 64); err == nil {
+			return ms, true
+		}
+	}
+	return 0, false
+}
+
+// pingTimeout is the default of ping_timeout up to the ping interval, and
+// the reply timeout of the other pings (route probes, vantages, startup
+// checks)
+const pingTimeout = 3 * time.Second
+
+const (
+	// minPingInterval and maxPingInterval bound ping_interval
+	minPingInterval = 100 * time.Millisecond
+	maxPingInterval = time.Hour
+)
+
+// execProber probes using the system ping command
+type execProber struct {
+	timeout time.Duration
+}
+
+// Probe pings the specified host with the system ping command and returns
+// response time in milliseconds
+func (p execProber) Probe(host string) (float64, error) {
+	var args []string
+
+	if runtime.GOOS == "windows" {
+		args = []string{"-n", "1", "-w", strconv.Itoa(int(p.timeout / time.Millisecond)), host}
+	} else {
+		args = []string{"-c", "1", "-W", pingWaitSeconds(p.timeout), host}
+	}
+
+	start := time.Now()
+	output, err := runCommand(p.timeout, "ping", args...)
+	duration := time.Since(start)
+
+	if err != nil {
+		return 0, &probeError{reason: classifyPingFailure(string(output), err), err: err, output: string(output)}
+	}
+
+	// Parse response time from output
+	if ms, ok := parsePingTime(string(output), runtime.GOOS == "windows"); ok {
+		return ms, nil
+	}
+
+	// Windows ping exits 0 on an unreachable reply from a router
+	if reason := classifyPingFailure(string(output), nil); reason != reasonError {
+		return 0, &probeError{reason: reason, err: errUnparsedOutput, output: string(output)}
+	}
+
+	// If parsing failed, use measured duration
+	return float64(duration.Nanoseconds()) / 1000000, nil
+}
+
+// pingWaitSeconds is timeout in whole seconds, at least 1, for ping -W,
+// which busybox only takes as an integer
+func pingWaitSeconds(timeout time.Duration) string {
+	return strconv.Itoa(max(int((timeout+time.Second-1)/time.Second), 1))
+}
+
+// faultInjector wraps a Prober and overrides results for selected hosts as
+// failures until a deadline, so the whole downstream pipeline can be
+// exercised without a real outage
+type faultInjector struct {
+	next  Prober
+	mutex sync.Mutex
+	until map[string]time.Time
+}
+
+// newFaultInjector wraps next with an initially inactive fault injector
+func newFaultInjector(next Prober) *faultInjector {
+	return &faultInjector{next: next, until: make(map[string]time.Time)}
+}
+
+// Probe fails with errSimulatedFailure while a simulation is active for host
+func (f *faultInjector) Probe(host string) (float64, error) {
+	if f.active(host) {
+		return 0, errSimulatedFailure
+	}
+	return f.next.Probe(host)
+}
+
+// inject makes probes to host fail for the given duration
+func (f *faultInjector) inject(host string, duration time.Duration) time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	end := time.Now().Add(duration)
+	f.until[host] = end
+	return end
+}
+
+// active reports whether a simulated outage is in effect for host
+func (f *faultInjector) active(host string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	end, ok := f.until[host]
+	if !ok {
+		return false
+	}
+	if time.Now().After(end) {
+		delete(f.until, host)
+		return false
+	}
+	return true
+}
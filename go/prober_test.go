@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPingOutput parses the output of each ping implementation under
+// testdata/ping: the response time of a reply, the reason of a failure and
+// the responder of a TTL-limited hop
+func TestPingOutput(t *testing.T) {
+	exitStatus := errors.New("exit status 1")
+	tests := []struct {
+		file    string
+		windows bool
+		// ms is the response time, or -1 when none is found
+		ms float64
+		// reason is the classification of a failed ping, or "" when not
+		// checked. Windows ping exits 0 on any reply, so its failures are
+		// classified from the output alone.
+		reason failureReason
+		hop    string
+	}{
+		{"iputils_reply.txt", false, 12.3, "", "8.8.8.8"},
+		{"iputils_reply_lan.txt", false, 0.045, "", "192.168.1.1"},
+		{"iputils_timeout.txt", false, -1, reasonTimeout, ""},
+		{"iputils_unreachable.txt", false, -1, reasonUnreachable, "10.0.0.2"},
+		{"iputils_ttl_exceeded.txt", false, -1, reasonTimeout, "192.168.1.1"},
+		{"iputils_unknown_host.txt", false, -1, reasonDNS, ""},
+		{"iputils_resolver_down.txt", false, -1, reasonDNS, ""},
+		{"iputils_no_route.txt", false, -1, reasonUnreachable, ""},
+		{"iputils_no_permission.txt", false, -1, reasonMeasurement, ""},
+		{"busybox_reply.txt", false, 12.345, "", "8.8.8.8"},
+		{"busybox_timeout.txt", false, -1, reasonTimeout, ""},
+		{"busybox_unknown_host.txt", false, -1, reasonDNS, ""},
+		{"bsd_reply.txt", false, 12.345, "", "8.8.8.8"},
+		{"bsd_timeout.txt", false, -1, reasonTimeout, ""},
+		{"bsd_unknown_host.txt", false, -1, reasonDNS, ""},
+		{"macos_reply.txt", false, 9.871, "", "8.8.8.8"},
+		{"macos_timeout.txt", false, -1, reasonTimeout, ""},
+		{"macos_ttl_exceeded.txt", false, -1, reasonTimeout, "192.168.1.1"},
+		{"macos_unknown_host.txt", false, -1, reasonDNS, ""},
+		{"windows_ja_reply.txt", true, 12, "", "8.8.8.8"},
+		{"windows_ja_reply_lan.txt", true, 1, "", "192.168.1.1"},
+		{"windows_ja_timeout.txt", true, -1, reasonTimeout, ""},
+		{"windows_ja_unreachable.txt", true, -1, reasonUnreachable, "10.0.0.2"},
+		{"windows_ja_ttl_exceeded.txt", true, -1, "", "192.168.1.1"},
+		{"windows_ja_unknown_host.txt", true, -1, reasonDNS, ""},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", "ping", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := string(data)
+		ms, ok := parsePingTime(output, tt.windows)
+		if !ok {
+			ms = -1
+		}
+		if ms != tt.ms {
+			t.Errorf("%s: time %v, want %v", tt.file, ms, tt.ms)
+		}
+		if tt.reason != "" {
+			var runErr error = exitStatus
+			if tt.windows {
+				runErr = nil
+			}
+			if got := classifyPingFailure(output, runErr); got != tt.reason {
+				t.Errorf("%s: reason %s, want %s", tt.file, got, tt.reason)
+			}
+		}
+		if got := parseHopResponder(output, tt.windows); got != tt.hop {
+			t.Errorf("%s: hop %q, want %q", tt.file, got, tt.hop)
+		}
+	}
+}
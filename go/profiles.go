@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ping-monitor/api"
+)
+
+// profileNameRe restricts profile names to what is safe in URL paths and
+// directory names
+var profileNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// profileConfig is the effective configuration of one named profile
+type profileConfig struct {
+	Name   string
+	Config Config
+}
+
+// readProfiles returns the profiles of configFile in name order, or nil
+// when it defines none. Each profile starts from the top-level keys and
+// overrides them with its own; environment variables apply to every
+// profile. http_listen, api_token, api_tokens, metrics_public and
+// control_socket are process-wide, since the profiles share one HTTP
+// server. A state_dir inherited from the top level gets a subdirectory per
+// profile so the state stays isolated.
+func readProfiles(configFile string, overrides ConfigOverrides) ([]profileConfig, error) {
+	base, err := readConfig(configFile, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if len(base.Profiles) == 0 {
+		return nil, nil
+	}
+	if overrides.Target != "" {
+		return nil, fmt.Errorf("設定ファイル %s: -target は profiles を使う設定では指定できません（各プロファイルの target を指定してください）", configFile)
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(base.Profiles))
+	for name := range base.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var expanded []presetProfile
+	for _, name := range names {
+		if !profileNameRe.MatchString(name) {
+			return nil, fmt.Errorf("設定ファイル %s: プロファイル名 %q に使えない文字があります（英数字・-・_）", configFile, name)
+		}
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal(base.Profiles[name], &keys); err != nil {
+			return nil, fmt.Errorf("設定ファイル %s: profiles.%s の形式が正しくありません: %v", configFile, name, err)
+		}
+		ps, err := expandPreset(name, keys)
+		if err != nil {
+			return nil, fmt.Errorf("設定ファイル %s: %v", configFile, err)
+		}
+		expanded = append(expanded, ps...)
+	}
+
+	var profiles []profileConfig
+	seen := make(map[string]bool)
+	stateDirs := make(map[string]string)
+	resultsFiles := make(map[string]string)
+	for _, p := range expanded {
+		name, keys := p.name, p.keys
+		if seen[name] {
+			return nil, fmt.Errorf("設定ファイル %s: プロファイル名 %s が重複しています（プリセットの展開後）", configFile, name)
+		}
+		seen[name] = true
+		raw, _ := json.Marshal(keys)
+		for _, key := range []string{"profiles", "http_listen", "api_token", "api_tokens", "metrics_public", "control_socket", "self_protection"} {
+			if _, ok := keys[key]; ok {
+				return nil, fmt.Errorf("設定ファイル %s: profiles.%s に %s は指定できません（全プロファイル共通です）", configFile, name, key)
+			}
+		}
+
+		cfg := defaultConfig()
+		json.Unmarshal(data, &cfg)
+		cfg.Profiles = nil
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("設定ファイル %s: profiles.%s の形式が正しくありません: %v", configFile, name, err)
+		}
+		if err := applyEnvOverrides(&cfg); err != nil {
+			return nil, err
+		}
+		if overrides.PingInterval.given() {
+			cfg.PingInterval = overrides.PingInterval
+		}
+		if p.finish != nil {
+			if err := p.finish(&cfg); err != nil {
+				return nil, fmt.Errorf("設定ファイル %s: profiles.%s: %v", configFile, name, err)
+			}
+		}
+		cfg.Profiles = nil
+		cfg.HTTPListen, cfg.APIToken = base.HTTPListen, base.APIToken
+		cfg.APITokens, cfg.MetricsPublic = base.APITokens, base.MetricsPublic
+		// The socket follows the top-level state_dir, not the profile's
+		cfg.ControlSocket = base.controlSocketPath()
+		if cfg.ControlSocket == "" {
+			cfg.ControlSocket = controlSocketOff
+		}
+		if _, ok := keys["state_dir"]; !ok && cfg.StateDir != "" {
+			cfg.StateDir = filepath.Join(cfg.StateDir, name)
+		}
+		if _, ok := keys["report_dir"]; !ok && cfg.ReportDir != "" {
+			cfg.ReportDir = filepath.Join(cfg.ReportDir, name)
+		}
+		if err := cfg.validate(); err != nil {
+			return nil, fmt.Errorf("設定ファイル %s: profiles.%s: %v", configFile, name, err)
+		}
+		if other, ok := stateDirs[cfg.StateDir]; ok && cfg.StateDir != "" {
+			return nil, fmt.Errorf("設定ファイル %s: profiles.%s と profiles.%s の state_dir が同じです", configFile, other, name)
+		}
+		if other, ok := resultsFiles[cfg.ResultsFile]; ok && cfg.ResultsFile != "" {
+			return nil, fmt.Errorf("設定ファイル %s: profiles.%s と profiles.%s の results_file が同じです", configFile, other, name)
+		}
+		stateDirs[cfg.StateDir], resultsFiles[cfg.ResultsFile] = name, name
+		profiles = append(profiles, profileConfig{Name: name, Config: cfg})
+	}
+	return profiles, nil
+}
+
+// profilePathPrefix is the URL prefix of a profile's endpoints
+func profilePathPrefix(name string) string {
+	return "/p/" + name
+}
+
+// newProfilesServer serves the endpoints of every monitor under
+// /p/<profile>/, plus GET /profiles listing the names and GET /healthz,
+// unhealthy when any monitor's loop is stalled
+func newProfilesServer(listen string, transport controlTransport, monitors []*PingMonitor) *apiServer {
+	mux := http.NewServeMux()
+	var names []string
+	for _, pm := range monitors {
+		prefix := profilePathPrefix(pm.profile)
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, newAPIServer(pm).server.Handler))
+		names = append(names, pm.profile)
+	}
+	mux.HandleFunc("GET /profiles", versioned(api.SchemaProfiles, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, ProfilesResponse{Schema: api.SchemaProfiles, Profiles: names})
+	}))
+	mux.HandleFunc("GET /schema", handleSchema)
+	mux.HandleFunc("GET /healthz", versioned(api.SchemaProfilesHealth, func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		health := make(map[string]HealthResponse)
+		for _, pm := range monitors {
+			code, resp := pm.health()
+			status = max(status, code)
+			health[pm.profile] = resp
+		}
+		writeJSON(w, status, ProfilesHealthResponse{Schema: api.SchemaProfilesHealth, Profiles: health})
+	}))
+	return &apiServer{transport: transport, server: &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}}
+}
+
+// runProfiles starts one monitor per profile and stops them all on
+// SIGINT/SIGTERM
+func runProfiles(profiles []profileConfig) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	var monitors []*PingMonitor
+	for _, p := range profiles {
+		fmt.Printf("\n▶️ プロファイル %s\n", p.Name)
+		pm, err := newPingMonitor(p.Config, p.Name)
+		if err != nil {
+			log.Fatalf("プロファイル %s の初期化エラー: %v", p.Name, err)
+		}
+		monitors = append(monitors, pm)
+	}
+
+	var api *apiServer
+	transport := newControlTransport(profiles[0].Config)
+	if listen := profiles[0].Config.HTTPListen; listen != "" || transport != nil {
+		api = newProfilesServer(listen, transport, monitors)
+		api.start()
+	}
+	names := make([]string, len(monitors))
+	for i, pm := range monitors {
+		pm.start()
+		names[i] = pm.profile
+	}
+	fmt.Printf("%d個のプロファイルで監視を開始しました: %s\n", len(monitors), strings.Join(names, ", "))
+
+	sig := <-sigChan
+	fmt.Printf("\n終了シグナル(%v)を受信しました。全プロファイルを停止中...\n", sig)
+	if api != nil {
+		api.shutdown()
+	}
+	// Each monitor has its own shutdown deadline, so they stop in parallel
+	var wg sync.WaitGroup
+	for _, pm := range monitors {
+		wg.Add(1)
+		go func(pm *PingMonitor) {
+			defer wg.Done()
+			pm.audit.record(AuditEntry{Action: "shutdown", Actor: signalActor(sig)})
+			pm.Stop()
+		}(pm)
+	}
+	wg.Wait()
+}
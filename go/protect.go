@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// memoryCheckInterval is how often memory use is compared to the limit
+	memoryCheckInterval = 30 * time.Second
+	// memoryWarnPercent of the limit raises the warning, which clears
+	// again below memoryClearPercent
+	memoryWarnPercent  = 90
+	memoryClearPercent = 80
+	// saturationCheckInterval is how often skipped commands are looked
+	// for; skips in saturationWarnChecks checks in a row raise the warning
+	saturationCheckInterval = 30 * time.Second
+	saturationWarnChecks    = 2
+	// maxMaxProcesses bounds self_protection.max_processes
+	maxMaxProcesses = 1024
+)
+
+// SelfProtectionConfig keeps the monitor alive on a small host. It applies
+// to the whole process, so profiles share the top-level one.
+type SelfProtectionConfig struct {
+	// MemoryLimitMB is a soft limit for the Go runtime, which collects
+	// garbage harder as it nears it (0 for none)
+	MemoryLimitMB int `json:"memory_limit_mb"`
+	// ProbeNice is the niceness of ping and other external commands (Unix,
+	// 0 leaves it unchanged, negative values need root)
+	ProbeNice int `json:"probe_nice"`
+	// OOMScoreAdj is written to /proc/self/oom_score_adj (Linux); null
+	// leaves it unchanged
+	OOMScoreAdj *int `json:"oom_score_adj"`
+	// MaxProcesses caps the external commands running at once; a probe
+	// beyond it is skipped rather than started (0 for the default of 32)
+	MaxProcesses int `json:"max_processes"`
+}
+
+// validate checks the ranges
+func (c SelfProtectionConfig) validate() error {
+	if c.MemoryLimitMB < 0 {
+		return fmt.Errorf("self_protection.memory_limit_mb は0以上で指定してください（0で無効）")
+	}
+	if c.ProbeNice < -20 || c.ProbeNice > 19 {
+		return fmt.Errorf("self_protection.probe_nice は-20〜19で指定してください")
+	}
+	if c.OOMScoreAdj != nil && (*c.OOMScoreAdj < -1000 || *c.OOMScoreAdj > 1000) {
+		return fmt.Errorf("self_protection.oom_score_adj は-1000〜1000で指定してください")
+	}
+	if c.MaxProcesses < 0 || c.MaxProcesses > maxMaxProcesses {
+		return fmt.Errorf("self_protection.max_processes は0〜%dで指定してください（0で既定の%d）", maxMaxProcesses, defaultMaxProcesses)
+	}
+	return nil
+}
+
+// commandNice is the niceness external commands are started with; 0 runs
+// them unchanged
+var commandNice int
+
+// applySelfProtection sets the memory limit, the niceness of external
+// commands and the OOM score adjustment. What the host refuses is reported
+// and skipped.
+func applySelfProtection(c *SelfProtectionConfig) {
+	if c == nil {
+		return
+	}
+	if c.MemoryLimitMB > 0 {
+		debug.SetMemoryLimit(int64(c.MemoryLimitMB) << 20)
+		fmt.Printf("メモリの上限: %dMB\n", c.MemoryLimitMB)
+	}
+	if c.ProbeNice != 0 {
+		switch {
+		case runtime.GOOS == "windows":
+			fmt.Println("注意: self_protection.probe_nice はWindowsでは使えません")
+		case c.ProbeNice < 0 && os.Geteuid() != 0:
+			fmt.Printf("注意: self_protection.probe_nice の負の値(%d)にはroot権限が必要です。優先度は変更しません\n", c.ProbeNice)
+		default:
+			if _, err := exec.LookPath("nice"); err != nil {
+				fmt.Println("注意: niceコマンドが見つからないため、外部コマンドの優先度は変更しません")
+				break
+			}
+			commandNice = c.ProbeNice
+			fmt.Printf("外部コマンドの優先度: nice %d\n", c.ProbeNice)
+		}
+	}
+	if c.OOMScoreAdj != nil {
+		if runtime.GOOS != "linux" {
+			fmt.Println("注意: self_protection.oom_score_adj はLinuxでのみ使えます")
+		} else if err := os.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(*c.OOMScoreAdj)), 0); err != nil {
+			// Lowering the score needs CAP_SYS_RESOURCE
+			fmt.Printf("❌ oom_score_adj を %d に設定できません: %v\n", *c.OOMScoreAdj, err)
+		} else {
+			fmt.Printf("OOM killerの優先度(oom_score_adj): %d\n", *c.OOMScoreAdj)
+		}
+	}
+	if c.MaxProcesses > 0 {
+		setMaxProcesses(c.MaxProcesses)
+		fmt.Printf("外部コマンドの同時実行数の上限: %d\n", c.MaxProcesses)
+	}
+}
+
+// niceArgs prefixes a command line with nice when commandNice is set. A
+// command that is not installed is left alone, so it fails the way it
+// would without nice and is still classified as a measurement error.
+func niceArgs(name string, args []string) (string, []string) {
+	if commandNice == 0 {
+		return name, args
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return name, args
+	}
+	return "nice", append([]string{"-n", strconv.Itoa(commandNice), path}, args...)
+}
+
+// memoryUsage returns the memory the Go runtime holds from the OS, as
+// counted against the limit, and the limit (0 for none)
+func memoryUsage() (used, limit int64) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	used = int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+	if limit = debug.SetMemoryLimit(-1); limit == math.MaxInt64 {
+		limit = 0
+	}
+	return used, limit
+}
+
+// memoryWarned is set while the process is near its memory limit; it is
+// process-wide, so only one profile sends the warning
+var memoryWarned atomic.Bool
+
+// memoryLoop warns when memory use nears the limit, until the monitor
+// stops
+func (pm *PingMonitor) memoryLoop() {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.stopChan:
+			return
+		case <-ticker.C:
+		}
+		pm.checkMemory()
+	}
+}
+
+// checkMemory raises the warning once per approach to the limit
+func (pm *PingMonitor) checkMemory() {
+	used, limit := memoryUsage()
+	if limit == 0 {
+		return
+	}
+	percent := float64(used) / float64(limit) * 100
+	if percent < memoryClearPercent {
+		if memoryWarned.CompareAndSwap(true, false) {
+			fmt.Printf("✅ メモリ使用量が上限から離れました（%s / %dMB）\n", formatMB(used), limit>>20)
+		}
+		return
+	}
+	if percent < memoryWarnPercent || !memoryWarned.CompareAndSwap(false, true) {
+		return
+	}
+	fmt.Printf("⚠️ メモリ使用量が上限に近づいています: %s / %dMB（%.0f%%）\n", formatMB(used), limit>>20, percent)
+	pm.notifyOps(Event{
+		Time:  time.Now(),
+		Title: "⚠️ メモリ使用量",
+		Message: fmt.Sprintf("**使用量**: %s / 上限 %dMB（%.0f%%）\n上限に近づくとガベージコレクションが増え、計測が遅れることがあります。",
+			formatMB(used), limit>>20, percent),
+		Data: map[string]interface{}{
+			"check":       "memory",
+			"used_bytes":  used,
+			"limit_bytes": limit,
+		},
+	})
+}
+
+// saturationWarned is set while commands are being skipped at the cap;
+// process-wide like memoryWarned
+var saturationWarned atomic.Bool
+
+// saturationLoop warns when external commands keep hitting the cap, until
+// the monitor stops
+func (pm *PingMonitor) saturationLoop() {
+	ticker := time.NewTicker(saturationCheckInterval)
+	defer ticker.Stop()
+	skipped, checks := commandStats.skipped.Load(), 0
+	for {
+		select {
+		case <-pm.stopChan:
+			return
+		case <-ticker.C:
+		}
+		now := commandStats.skipped.Load()
+		if now == skipped {
+			checks = 0
+			if saturationWarned.CompareAndSwap(true, false) {
+				fmt.Println("✅ 外部コマンドの同時実行数が上限を下回りました")
+			}
+			continue
+		}
+		checks++
+		if checks >= saturationWarnChecks {
+			pm.checkSaturation(now - skipped)
+		}
+		skipped = now
+	}
+}
+
+// checkSaturation raises the warning once per episode of skipped commands
+func (pm *PingMonitor) checkSaturation(skipped int64) {
+	if !saturationWarned.CompareAndSwap(false, true) {
+		return
+	}
+	s := commandStatsSnapshot()
+	fmt.Printf("⚠️ 外部コマンドの同時実行数が上限(%d)に達し、計測を省略しています（直近%d秒で%d回）\n",
+		s.MaxProcesses, int(saturationCheckInterval.Seconds()), skipped)
+	pm.notifyOps(Event{
+		Time:  time.Now(),
+		Title: "⚠️ 外部コマンドの上限",
+		Message: fmt.Sprintf("**上限**: %d（実行中 %d）\n**省略**: 直近%d秒で%d回（累計 %d回）\n**直近1分の起動数**: %d\n"+
+			"実行中のpingなどが上限に達しているため、新しい計測を起動せずに省略しています。"+
+			"省略した計測は計測エラーとして扱い、損失率には含めません。対象を減らすか、ping_interval を長くしてください。",
+			s.MaxProcesses, s.Running, int(saturationCheckInterval.Seconds()), skipped, s.Skipped, s.StartedLastMinute),
+		Data: map[string]interface{}{
+			"check":               "saturation",
+			"max_processes":       s.MaxProcesses,
+			"running":             s.Running,
+			"skipped":             skipped,
+			"skipped_total":       s.Skipped,
+			"started_last_minute": s.StartedLastMinute,
+		},
+	})
+}
+
+// formatMB renders bytes as megabytes, e.g. "12.3MB"
+func formatMB(b int64) string {
+	return fmt.Sprintf("%.1fMB", float64(b)/(1<<20))
+}
+
+// writeMemoryMetrics writes the memory use, and the limit when there is one
+func writeMemoryMetrics(w *strings.Builder) {
+	used, limit := memoryUsage()
+	fmt.Fprintf(w, "# HELP ping_monitor_memory_bytes Memory the monitor holds from the OS, as counted against the limit.\n")
+	fmt.Fprintf(w, "# TYPE ping_monitor_memory_bytes gauge\n")
+	fmt.Fprintf(w, "ping_monitor_memory_bytes %d\n", used)
+	if limit > 0 {
+		fmt.Fprintf(w, "# HELP ping_monitor_memory_limit_bytes Soft memory limit (self_protection.memory_limit_mb).\n")
+		fmt.Fprintf(w, "# TYPE ping_monitor_memory_limit_bytes gauge\n")
+		fmt.Fprintf(w, "ping_monitor_memory_limit_bytes %d\n", limit)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// qualityBaselineDays is how many previous days of history the baseline
+// p95 is taken from
+const qualityBaselineDays = 7
+
+// qualityBaseline caches the baseline p95 of one day: the median hourly
+// p95 of the previous days' history, or latency_warn_ms without one
+type qualityBaseline struct {
+	mutex sync.Mutex
+	date  string
+	p95   float64
+}
+
+// baselineP95 returns the baseline for the day date (YYYY-MM-DD)
+func (pm *PingMonitor) baselineP95(date string) float64 {
+	b := &pm.qualityBaseline
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.date == date {
+		return b.p95
+	}
+	b.date, b.p95 = date, pm.config.LatencyWarnMs
+	day, err := time.ParseInLocation(reportDateLayout, date, time.Local)
+	if pm.history == nil || err != nil {
+		return b.p95
+	}
+	var p95s []float64
+	for i := 1; i <= qualityBaselineDays; i++ {
+		h, err := pm.history.loadDay(day.AddDate(0, 0, -i).Format(reportDateLayout))
+		if err != nil {
+			continue
+		}
+		for _, hour := range h.Hours {
+			if hour.Count > hour.Failures && hour.P95 > 0 {
+				p95s = append(p95s, hour.P95)
+			}
+		}
+	}
+	if len(p95s) > 0 {
+		b.p95 = medianP95(p95s)
+	}
+	return b.p95
+}
+
+// quality scores period p; ok is false when it has no samples
+func (pm *PingMonitor) quality(p *reportPeriod) (QualityScore, bool) {
+	stats := p.probeStats()
+	if stats.Total == 0 {
+		return QualityScore{}, false
+	}
+	return qualityScore(qualityInput{
+		LossPercent: 100 - stats.SuccessRate,
+		P95:         stats.Latency.P95,
+		JitterMs:    p.Samples.latency.jitter(),
+		BaselineP95: pm.baselineP95(p.Date),
+	}, pm.config.QualityWeights), true
+}
+
+// String formats the score as "品質スコア 94/100"
+func (q QualityScore) String() string {
+	return fmt.Sprintf("品質スコア %.0f/100", q.Score)
+}
+
+// breakdown lists what the score lost to each factor
+func (q QualityScore) breakdown(f numberFormat) string {
+	return fmt.Sprintf("損失 -%.0f / 遅延 -%.0f / ゆらぎ -%.0f（基準p95 %s、ゆらぎ %s）",
+		q.LossPenalty, q.LatencyPenalty, q.JitterPenalty, f.ms(q.BaselineP95), f.ms(q.JitterMs))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestQualityScore(t *testing.T) {
+	w := QualityWeights{Loss: 10, Latency: 20, Jitter: 20}
+	tests := []struct {
+		name                     string
+		in                       qualityInput
+		score, loss, lat, jitter float64
+	}{
+		{"perfect", qualityInput{P95: 20, BaselineP95: 100}, 100, 0, 0, 0},
+		{"2% loss", qualityInput{LossPercent: 2, P95: 20, BaselineP95: 100}, 80, 20, 0, 0},
+		{"p95 2.5x the baseline", qualityInput{P95: 250, BaselineP95: 100}, 70, 0, 30, 0},
+		{"latency capped", qualityInput{P95: 1000, BaselineP95: 100}, 60, 0, 40, 0},
+		{"jitter", qualityInput{P95: 50, JitterMs: 40, BaselineP95: 100}, 92, 0, 0, 8},
+		{"jitter capped", qualityInput{P95: 50, JitterMs: 500, BaselineP95: 100}, 70, 0, 0, 30},
+		{"without a baseline only loss counts", qualityInput{LossPercent: 1, P95: 900, JitterMs: 300}, 90, 10, 0, 0},
+		{"clamped to 0", qualityInput{LossPercent: 50, P95: 20, BaselineP95: 100}, 0, 500, 0, 0},
+	}
+	for _, tt := range tests {
+		q := qualityScore(tt.in, w)
+		if q.Score != tt.score || q.LossPenalty != tt.loss || q.LatencyPenalty != tt.lat || q.JitterPenalty != tt.jitter {
+			t.Errorf("%s: %+v, want %v (-%v/-%v/-%v)", tt.name, q, tt.score, tt.loss, tt.lat, tt.jitter)
+		}
+	}
+	if q := qualityScore(qualityInput{LossPercent: 2, P95: 250, BaselineP95: 100}, QualityWeights{Loss: 5}); q.Score != 90 {
+		t.Errorf("custom weights: %+v", q)
+	}
+}
+
+// TestQualityDays scores whole days of probes against the default
+// baseline of latency_warn_ms (100ms)
+func TestQualityDays(t *testing.T) {
+	tests := []struct {
+		name string
+		// rtt returns the result of probe i; 0 is a failure
+		rtt                 func(i int) float64
+		wantMin, wantMax    float64
+		wantLoss, wantDelay bool
+	}{
+		{"perfect day", func(int) float64 { return 20 }, 100, 100, false, false},
+		{"lossy day", func(i int) float64 {
+			if i%50 == 0 {
+				return 0
+			}
+			return 20
+		}, 80, 80, true, false},
+		{"high latency without loss", func(int) float64 { return 250 }, 60, 80, false, true},
+	}
+	start := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+	for _, tt := range tests {
+		pm, clock := newTestMonitor(t, map[string]interface{}{"ping_interval": "1s"}, start)
+		var i int
+		pm.prober = probeFunc(func(host string) (float64, error) {
+			if host != pm.targetIP {
+				return 1, nil
+			}
+			if ms := tt.rtt(i); ms > 0 {
+				return ms, nil
+			}
+			return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+		})
+		for i = 0; i < 2000; i++ {
+			at := start.Add(time.Duration(i) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+		}
+		p := pm.currentPeriod()
+		q, ok := pm.quality(p)
+		if !ok {
+			t.Fatalf("%s: no score", tt.name)
+		}
+		if q.Score < tt.wantMin || q.Score > tt.wantMax || q.BaselineP95 != 100 || q.JitterPenalty != 0 {
+			t.Errorf("%s: %+v, want %v-%v against a 100ms baseline", tt.name, q, tt.wantMin, tt.wantMax)
+		}
+		if (q.LossPenalty > 0) != tt.wantLoss || (q.LatencyPenalty > 0) != tt.wantDelay {
+			t.Errorf("%s: penalties %+v", tt.name, q)
+		}
+		// The score follows the formula from the period's own stats
+		stats := p.probeStats()
+		want := math.Round(100 - (100-stats.SuccessRate)*10 - min(max(stats.Latency.P95/100-1, 0)*20, 40))
+		if q.Score != want {
+			t.Errorf("%s: score %v, want %v from loss %v%% and p95 %v", tt.name, q.Score, want, 100-stats.SuccessRate, stats.Latency.P95)
+		}
+	}
+
+	// No samples, no score
+	pm, _ := newTestMonitor(t, nil, start)
+	if _, ok := pm.quality(pm.currentPeriod()); ok {
+		t.Error("scored an empty day")
+	}
+}
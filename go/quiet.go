@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHoursConfig configures do-not-disturb time ranges
+type QuietHoursConfig struct {
+	Ranges        []string `json:"ranges"`
+	Timezone      string   `json:"timezone"`
+	AllowCritical bool     `json:"allow_critical"`
+}
+
+// quietRange is a time-of-day range; end <= start means it crosses midnight
+type quietRange struct {
+	start, end time.Duration
+}
+
+// quietHours decides which events are held back. A nil *quietHours
+// suppresses nothing.
+type quietHours struct {
+	ranges        []quietRange
+	location      *time.Location
+	allowCritical bool
+}
+
+// newQuietHours parses the config; it returns nil when no ranges are set
+func newQuietHours(cfg QuietHoursConfig) (*quietHours, error) {
+	if len(cfg.Ranges) == 0 {
+		return nil, nil
+	}
+	q := &quietHours{location: time.Local, allowCritical: cfg.AllowCritical}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("quiet_hours.timezone の値が正しくありません: %q", cfg.Timezone)
+		}
+		q.location = loc
+	}
+	for _, r := range cfg.Ranges {
+		startStr, endStr, ok := strings.Cut(r, "-")
+		if !ok {
+			return nil, fmt.Errorf("quiet_hours.ranges の値が正しくありません: %q (HH:MM-HH:MM)", r)
+		}
+		start, err1 := parseTimeOfDay(strings.TrimSpace(startStr))
+		end, err2 := parseTimeOfDay(strings.TrimSpace(endStr))
+		if err1 != nil || err2 != nil || start == end {
+			return nil, fmt.Errorf("quiet_hours.ranges の値が正しくありません: %q (HH:MM-HH:MM)", r)
+		}
+		q.ranges = append(q.ranges, quietRange{start: start, end: end})
+	}
+	return q, nil
+}
+
+// active reports whether t falls into any quiet range
+func (q *quietHours) active(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	local := t.In(q.location)
+	tod := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+	for _, r := range q.ranges {
+		if r.start < r.end {
+			if tod >= r.start && tod < r.end {
+				return true
+			}
+		} else if tod >= r.start || tod < r.end {
+			// Crosses midnight, e.g. 23:00-07:00
+			return true
+		}
+	}
+	return false
+}
+
+// suppresses reports whether ev should be deferred to the daily report
+func (q *quietHours) suppresses(ev Event) bool {
+	// The daily report is where deferred events end up, so it is never held
+	if ev.Kind == EventReport || !q.active(ev.Time) {
+		return false
+	}
+	if ev.Severity >= SeverityCritical && q.allowCritical {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rdnsLookupLimit caps one PTR lookup left running in the background after
+// the caller stopped waiting for it
+const rdnsLookupLimit = 10 * time.Second
+
+// ReverseDNSConfig controls the PTR lookups used to name the target and the
+// route hops in reports and alerts
+type ReverseDNSConfig struct {
+	Enabled bool `json:"enabled"`
+	// CacheTTL is how long a name, or the lack of one, is kept
+	CacheTTL Duration `json:"cache_ttl"`
+	// Timeout is the longest a report or alert waits for lookups
+	Timeout Duration `json:"timeout"`
+}
+
+// validate checks the durations
+func (c ReverseDNSConfig) validate() error {
+	if err := c.CacheTTL.check("reverse_dns.cache_ttl", positiveDuration, 0, "1h"); err != nil {
+		return err
+	}
+	return c.Timeout.check("reverse_dns.timeout", positiveDuration, rdnsLookupLimit, "500ms")
+}
+
+// reverseName is a cached lookup; name is "" when the address has none
+type reverseName struct {
+	name    string
+	expires time.Time
+}
+
+// reverseDNS looks up and caches the names of addresses. Lookups run in
+// the background, so a slow resolver delays a rendering by at most the
+// timeout and the name shows up the next time. A nil *reverseDNS names
+// nothing.
+type reverseDNS struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	timeout time.Duration
+	lookup  func(ctx context.Context, addr string) ([]string, error)
+	cache   map[string]reverseName
+	pending map[string]chan struct{}
+}
+
+// newReverseDNS creates the lookups for c, or nil when they are disabled
+func newReverseDNS(c ReverseDNSConfig) *reverseDNS {
+	if !c.Enabled {
+		return nil
+	}
+	return &reverseDNS{
+		ttl:     c.CacheTTL.Duration(),
+		timeout: c.Timeout.Duration(),
+		lookup:  net.DefaultResolver.LookupAddr,
+		cache:   make(map[string]reverseName),
+		pending: make(map[string]chan struct{}),
+	}
+}
+
+// resolve looks up the addresses not cached yet and waits for them until
+// the timeout. Entries that are not addresses (hostnames, "*") are skipped.
+func (r *reverseDNS) resolve(addrs ...string) {
+	if r == nil {
+		return
+	}
+	now := time.Now()
+	var waits []chan struct{}
+	r.mutex.Lock()
+	for _, addr := range addrs {
+		if net.ParseIP(addr) == nil {
+			continue
+		}
+		if c, ok := r.cache[addr]; ok && now.Before(c.expires) {
+			continue
+		}
+		done, ok := r.pending[addr]
+		if !ok {
+			done = make(chan struct{})
+			r.pending[addr] = done
+			go r.fetch(addr, done)
+		}
+		waits = append(waits, done)
+	}
+	r.mutex.Unlock()
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	for _, done := range waits {
+		select {
+		case <-done:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// fetch looks up one address and caches the result
+func (r *reverseDNS) fetch(addr string, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), rdnsLookupLimit)
+	defer cancel()
+	names, err := r.lookup(ctx, addr)
+	name := ""
+	if err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	r.mutex.Lock()
+	r.cache[addr] = reverseName{name: name, expires: time.Now().Add(r.ttl)}
+	delete(r.pending, addr)
+	r.mutex.Unlock()
+	close(done)
+}
+
+// label returns "name (addr)" when the cache has a name for addr, and addr
+// itself otherwise
+func (r *reverseDNS) label(addr string) string {
+	if r == nil {
+		return addr
+	}
+	r.mutex.Lock()
+	c, ok := r.cache[addr]
+	r.mutex.Unlock()
+	if !ok || c.name == "" {
+		return addr
+	}
+	return fmt.Sprintf("%s (%s)", c.name, addr)
+}
+
+// targetLabel names the target for reports and alerts: a hostname target
+// with the address it resolved to, an address target with its PTR name
+func (pm *PingMonitor) targetLabel() string {
+	pm.mutex.RLock()
+	addr := pm.targetAddr
+	pm.mutex.RUnlock()
+	if !pm.resolver.static() {
+		if addr == "" {
+			return pm.targetIP
+		}
+		return fmt.Sprintf("%s (%s)", pm.targetIP, addr)
+	}
+	pm.rdns.resolve(pm.targetIP)
+	return pm.rdns.label(pm.targetIP)
+}
+
+// routeLabels returns a function naming the hops of formatRoute paths,
+// after looking up every hop of changes at once
+func (pm *PingMonitor) routeLabels(changes []routeChange) func(path string) string {
+	var hops []string
+	for _, c := range changes {
+		hops = append(hops, strings.Split(c.From, " > ")...)
+		hops = append(hops, strings.Split(c.To, " > ")...)
+	}
+	pm.rdns.resolve(hops...)
+	return func(path string) string {
+		parts := strings.Split(path, " > ")
+		for i, h := range parts {
+			parts[i] = pm.rdns.label(h)
+		}
+		return strings.Join(parts, " > ")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// failureReason classifies a failed probe. The set is fixed so metric
+// labels and per-minute counters stay bounded.
+type failureReason string
+
+const (
+	reasonTimeout     failureReason = "timeout"
+	reasonUnreachable failureReason = "unreachable"
+	reasonDNS         failureReason = "dns"
+	// reasonRefused, reasonReset and reasonOpen are the TCP outcomes not
+	// counted as success by tcp_probe (see tcpprobe.go)
+	reasonRefused   failureReason = "refused"
+	reasonReset     failureReason = "reset"
+	reasonOpen      failureReason = "open"
+	reasonSimulated failureReason = "simulated"
+	// reasonMeasurement is a failure of the monitor itself (ping missing,
+	// no permission, unreadable output), not of the network
+	reasonMeasurement failureReason = "measurement"
+	reasonError       failureReason = "error"
+)
+
+// failureReasons lists every reason in display order; reasonError stays
+// last, as the fallback of reasonIndex
+var failureReasons = [...]failureReason{reasonTimeout, reasonUnreachable, reasonDNS, reasonRefused, reasonReset, reasonOpen, reasonSimulated, reasonMeasurement, reasonError}
+
+// reasonIndex returns the position of r in failureReasons
+func reasonIndex(r failureReason) int {
+	for i, reason := range failureReasons {
+		if reason == r {
+			return i
+		}
+	}
+	return len(failureReasons) - 1
+}
+
+// validFailureReason reports whether s names a reason
+func validFailureReason(s string) bool {
+	for _, reason := range failureReasons {
+		if string(reason) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// label returns the reason for reports
+func (r failureReason) label() string {
+	switch r {
+	case reasonTimeout:
+		return "タイムアウト"
+	case reasonUnreachable:
+		return "到達不能応答"
+	case reasonDNS:
+		return "名前解決失敗"
+	case reasonRefused:
+		return "接続拒否"
+	case reasonReset:
+		return "接続リセット"
+	case reasonOpen:
+		return "想定外の接続成功"
+	case reasonSimulated:
+		return "擬似障害"
+	case reasonMeasurement:
+		return "計測エラー"
+	default:
+		return "その他のエラー"
+	}
+}
+
+// probeError is a probe failure with its classified reason and the
+// command output, kept for /debug/failures
+type probeError struct {
+	reason failureReason
+	err    error
+	output string
+}
+
+func (e *probeError) Error() string { return fmt.Sprintf("%s: %v", e.reason, e.err) }
+func (e *probeError) Unwrap() error { return e.err }
+
+// pingOutputReasons maps ping output fragments (C locale and Japanese
+// Windows) to reasons; the first match wins
+var pingOutputReasons = []struct {
+	fragment string
+	reason   failureReason
+}{
+	{"unknown host", reasonDNS},
+	{"Name or service not known", reasonDNS},
+	{"Temporary failure in name resolution", reasonDNS},
+	{"cannot resolve", reasonDNS},
+	{"bad address", reasonDNS},
+	{"が見つかりませんでした", reasonDNS},
+	{"Destination Host Unreachable", reasonUnreachable},
+	{"Destination Net Unreachable", reasonUnreachable},
+	{"Network is unreachable", reasonUnreachable},
+	{"No route to host", reasonUnreachable},
+	{"宛先ホストに到達できません", reasonUnreachable},
+	{"宛先ネットワークに到達できません", reasonUnreachable},
+	{"100% packet loss", reasonTimeout},
+	{"100.0% packet loss", reasonTimeout},
+	{"socket: Operation not permitted", reasonMeasurement},
+	{"socket: Permission denied", reasonMeasurement},
+	{"Usage: ping", reasonMeasurement},
+	{"usage: ping", reasonMeasurement},
+	{"要求がタイムアウトしました", reasonTimeout},
+}
+
+// classifyPingFailure derives the reason of a failed ping command from its
+// error and output
+func classifyPingFailure(output string, err error) failureReason {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return reasonTimeout
+	}
+	// The monitor could not run or read ping at all
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrPermission) || errors.Is(err, errOutputTooLarge) || errors.Is(err, errSaturated) {
+		return reasonMeasurement
+	}
+	for _, m := range pingOutputReasons {
+		if strings.Contains(output, m.fragment) {
+			return m.reason
+		}
+	}
+	return reasonError
+}
+
+// classifyFailure returns the reason of a probe error
+func classifyFailure(err error) failureReason {
+	var pe *probeError
+	switch {
+	case errors.Is(err, errSimulatedFailure):
+		return reasonSimulated
+	case errors.Is(err, errNoAddress):
+		return reasonDNS
+	case errors.As(err, &pe):
+		return pe.reason
+	default:
+		return reasonError
+	}
+}
+
+// reasonCounts counts failures per reason, indexed like failureReasons
+type reasonCounts [len(failureReasons)]int
+
+// add counts one failure
+func (c *reasonCounts) add(r failureReason) {
+	c[reasonIndex(r)]++
+}
+
+// dominant returns the most frequent reason, or "" when there is none
+func (c *reasonCounts) dominant() failureReason {
+	best, bestCount := failureReason(""), 0
+	for i, n := range c {
+		if n > bestCount {
+			best, bestCount = failureReasons[i], n
+		}
+	}
+	return best
+}
+
+// toMap returns the non-zero counts keyed by reason
+func (c *reasonCounts) toMap() map[string]int {
+	var m map[string]int
+	for i, n := range c {
+		if n == 0 {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]int)
+		}
+		m[string(failureReasons[i])] = n
+	}
+	return m
+}
+
+// String formats the counts as "タイムアウト 12 / 到達不能応答 3"
+func (c *reasonCounts) String() string {
+	type entry struct {
+		reason failureReason
+		n      int
+	}
+	var entries []entry
+	for i, n := range c {
+		if n > 0 {
+			entries = append(entries, entry{failureReasons[i], n})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].n > entries[j].n })
+	parts := make([]string, len(entries))
+	for i, e := range entries {
+		parts[i] = fmt.Sprintf("%s %d", e.reason.label(), e.n)
+	}
+	return strings.Join(parts, " / ")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+)
+
+// redactedMask replaces the value of secret fields
+const redactedMask = "***"
+
+// redactConfig returns a copy of cfg with every secret-tagged field masked.
+// All paths that expose configuration (startup log, /config, /debug/state)
+// must go through this function.
+func redactConfig(cfg Config) Config {
+	v := reflect.ValueOf(&cfg).Elem()
+	redactValue(v)
+	return cfg
+}
+
+// redactValue walks v recursively and masks secret-tagged string fields
+func redactValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := v.Field(i)
+			if !f.CanSet() {
+				continue
+			}
+			if t.Field(i).Tag.Get("secret") == "true" {
+				maskField(f)
+				continue
+			}
+			redactValue(f)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		// Copy so the caller's backing array is never modified
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(cp, v)
+		for i := 0; i < cp.Len(); i++ {
+			redactValue(cp.Index(i))
+		}
+		v.Set(cp)
+	case reflect.Map:
+		if v.IsNil() {
+			return
+		}
+		// Map values cannot be set in place; masked copies go into a new map
+		cp := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(iter.Value())
+			redactValue(e)
+			cp.SetMapIndex(iter.Key(), e)
+		}
+		v.Set(cp)
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		e := reflect.New(v.Elem().Type()).Elem()
+		e.Set(v.Elem())
+		redactValue(e)
+		v.Set(e)
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		cp := reflect.New(v.Elem().Type())
+		cp.Elem().Set(v.Elem())
+		redactValue(cp.Elem())
+		v.Set(cp)
+	}
+}
+
+// maskField masks a secret field; empty values stay empty so that
+// "not configured" remains distinguishable from "configured"
+func maskField(f reflect.Value) {
+	switch f.Kind() {
+	case reflect.String:
+		if f.String() != "" {
+			f.SetString(maskSecret(f.String()))
+		}
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			f.Set(reflect.Zero(f.Type()))
+			return
+		}
+		cp := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+		for i := 0; i < f.Len(); i++ {
+			cp.Index(i).SetString(maskSecret(f.Index(i).String()))
+		}
+		f.Set(cp)
+	default:
+		f.Set(reflect.Zero(f.Type()))
+	}
+}
+
+// maskSecret masks s, keeping only the scheme and host of URLs so that the
+// destination service stays visible for debugging
+func maskSecret(s string) string {
+	if u, err := url.Parse(s); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host + "/" + redactedMask
+	}
+	return redactedMask
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type redactInner struct {
+	Name  string `json:"name"`
+	Token string `json:"token" secret:"true"`
+}
+
+type redactSample struct {
+	Visible  string                  `json:"visible"`
+	Password string                  `json:"password" secret:"true"`
+	URL      string                  `json:"url" secret:"true"`
+	Empty    string                  `json:"empty" secret:"true"`
+	Keys     []string                `json:"keys" secret:"true"`
+	Number   int                     `json:"number" secret:"true"`
+	Inner    redactInner             `json:"inner"`
+	Ptr      *redactInner            `json:"ptr"`
+	List     []redactInner           `json:"list"`
+	ByName   map[string]redactInner  `json:"by_name"`
+	ByPtr    map[string]*redactInner `json:"by_ptr"`
+	Nil      *redactInner            `json:"nil"`
+}
+
+func TestRedactValue(t *testing.T) {
+	orig := redactSample{
+		Visible:  "keep-me",
+		Password: "hunter2",
+		URL:      "https://discord.com/api/webhooks/1/abc",
+		Keys:     []string{"k1", "k2"},
+		Number:   42,
+		Inner:    redactInner{Name: "inner", Token: "t-inner"},
+		Ptr:      &redactInner{Name: "ptr", Token: "t-ptr"},
+		List:     []redactInner{{Name: "list", Token: "t-list"}},
+		ByName:   map[string]redactInner{"a": {Name: "map", Token: "t-map"}},
+		ByPtr:    map[string]*redactInner{"b": {Name: "mapptr", Token: "t-mapptr"}},
+	}
+	got := orig
+	redactValue(reflect.ValueOf(&got).Elem())
+
+	want := redactSample{
+		Visible:  "keep-me",
+		Password: redactedMask,
+		URL:      "https://discord.com/" + redactedMask,
+		Keys:     []string{redactedMask, redactedMask},
+		Inner:    redactInner{Name: "inner", Token: redactedMask},
+		Ptr:      &redactInner{Name: "ptr", Token: redactedMask},
+		List:     []redactInner{{Name: "list", Token: redactedMask}},
+		ByName:   map[string]redactInner{"a": {Name: "map", Token: redactedMask}},
+		ByPtr:    map[string]*redactInner{"b": {Name: "mapptr", Token: redactedMask}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redacted:\n got  %+v\n want %+v", got, want)
+	}
+
+	// The caller's values, shared through pointers, slices and maps, stay
+	// untouched
+	if orig.Ptr.Token != "t-ptr" || orig.List[0].Token != "t-list" || orig.ByName["a"].Token != "t-map" ||
+		orig.ByPtr["b"].Token != "t-mapptr" || orig.Keys[0] != "k1" {
+		t.Errorf("original modified: %+v", orig)
+	}
+}
+
+// fillConfig sets every string reachable from v to a marker naming its
+// path, "SECRET:" for secret-tagged fields and "visible:" for the others,
+// allocating pointers and giving slices and maps one element
+func fillConfig(v reflect.Value, path string, secret bool) {
+	switch v.Kind() {
+	case reflect.String:
+		prefix := "visible:"
+		if secret {
+			prefix = "SECRET:"
+		}
+		v.SetString(prefix + path)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+			f := t.Field(i)
+			fillConfig(v.Field(i), path+"."+f.Name, secret || f.Tag.Get("secret") == "true")
+		}
+	case reflect.Pointer:
+		p := reflect.New(v.Type().Elem())
+		fillConfig(p.Elem(), path, secret)
+		v.Set(p)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// json.RawMessage
+			if secret {
+				v.SetBytes([]byte(fmt.Sprintf("%q", "SECRET:"+path)))
+			}
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		fillConfig(s.Index(0), path+"[0]", secret)
+		v.Set(s)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		m := reflect.MakeMap(v.Type())
+		e := reflect.New(v.Type().Elem()).Elem()
+		fillConfig(e, path+"[k]", secret)
+		m.SetMapIndex(reflect.ValueOf("k").Convert(v.Type().Key()), e)
+		v.Set(m)
+	}
+}
+
+// TestRedactConfigEverySecret fills every field of Config, so a secret
+// added anywhere later is covered without touching this test
+func TestRedactConfigEverySecret(t *testing.T) {
+	var cfg Config
+	fillConfig(reflect.ValueOf(&cfg).Elem(), "Config", false)
+	before, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := strings.Count(string(before), "SECRET:")
+	if secrets < 10 {
+		t.Fatalf("only %d secret values were filled; is fillConfig reaching every section?", secrets)
+	}
+
+	out, err := json.Marshal(redactConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := strings.Index(string(out), "SECRET:"); i >= 0 {
+		end := min(len(out), i+80)
+		t.Errorf("secret left unmasked: %s", out[i:end])
+	}
+	// Untagged fields stay visible: a masked webhook must not take the
+	// target or the site name with it
+	for _, field := range []string{"visible:Config.Target", "visible:Config.SiteName", "visible:Config.HTTPListen"} {
+		if !strings.Contains(string(out), field) {
+			t.Errorf("%s was masked", field)
+		}
+	}
+	after, _ := json.Marshal(cfg)
+	if string(after) != string(before) {
+		t.Error("redactConfig modified its argument")
+	}
+}
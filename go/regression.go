@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// DailyRegressionConfig flags a day that was clearly worse than the days
+// before it. Both values are compared on the stored history: the loss of
+// all samples and the median of the hourly p95s (see regression.go).
+type DailyRegressionConfig struct {
+	// LossFactor flags a loss at least this many times the baseline
+	// (default 2)
+	LossFactor float64 `json:"loss_factor"`
+	// MinLossIncrease is the rise in percentage points the loss also needs,
+	// so 0.01% after 0.00% does not count (default 0.5)
+	MinLossIncrease float64 `json:"min_loss_increase"`
+	// P95Factor flags a p95 at least this many times the baseline
+	// (default 1.5)
+	P95Factor float64 `json:"p95_factor"`
+	// Days is how many previous days form the baseline (default 14), of
+	// which MinDays need enough samples (default 7)
+	Days    int `json:"days"`
+	MinDays int `json:"min_days"`
+	// Alert also sends the regression to the notifiers as a warning, not
+	// only as a banner of the daily report
+	Alert bool `json:"alert"`
+}
+
+// validate checks the factors and the window
+func (c DailyRegressionConfig) validate() error {
+	if c.LossFactor != 0 && c.LossFactor < 1 {
+		return fmt.Errorf("daily_regression.loss_factor は1以上で指定してください: %v", c.LossFactor)
+	}
+	if c.P95Factor != 0 && c.P95Factor < 1 {
+		return fmt.Errorf("daily_regression.p95_factor は1以上で指定してください: %v", c.P95Factor)
+	}
+	if c.MinLossIncrease < 0 {
+		return fmt.Errorf("daily_regression.min_loss_increase は0以上で指定してください")
+	}
+	if c.Days < 0 || c.Days > 90 {
+		return fmt.Errorf("daily_regression.days は1〜90で指定してください: %d", c.Days)
+	}
+	if c.MinDays < 0 || c.MinDays > c.days() {
+		return fmt.Errorf("daily_regression.min_days は1〜%dで指定してください: %d", c.days(), c.MinDays)
+	}
+	return nil
+}
+
+// lossFactor returns the loss factor, defaulting to 2
+func (c DailyRegressionConfig) lossFactor() float64 {
+	if c.LossFactor == 0 {
+		return 2
+	}
+	return c.LossFactor
+}
+
+// minLossIncrease returns the least rise of the loss, defaulting to 0.5
+func (c DailyRegressionConfig) minLossIncrease() float64 {
+	if c.MinLossIncrease == 0 {
+		return 0.5
+	}
+	return c.MinLossIncrease
+}
+
+// p95Factor returns the p95 factor, defaulting to 1.5
+func (c DailyRegressionConfig) p95Factor() float64 {
+	if c.P95Factor == 0 {
+		return 1.5
+	}
+	return c.P95Factor
+}
+
+// days returns the baseline window, defaulting to 14
+func (c DailyRegressionConfig) days() int {
+	if c.Days == 0 {
+		return 14
+	}
+	return c.Days
+}
+
+// minDays returns the days the baseline needs, defaulting to 7 or the
+// whole window when it is shorter
+func (c DailyRegressionConfig) minDays() int {
+	if c.MinDays == 0 {
+		return min(7, c.days())
+	}
+	return c.MinDays
+}
+
+// DailyRegression is a day found worse than its baseline
+type DailyRegression struct {
+	// BaselineDays is how many previous days the baseline was taken from
+	BaselineDays int     `json:"baseline_days"`
+	Loss         float64 `json:"loss_percent"`
+	BaselineLoss float64 `json:"baseline_loss_percent"`
+	LossDegraded bool    `json:"loss_degraded"`
+	P95          float64 `json:"p95_ms"`
+	BaselineP95  float64 `json:"baseline_p95_ms"`
+	P95Degraded  bool    `json:"p95_degraded"`
+}
+
+// historySummary is what the comparison takes from stored days
+type historySummary struct {
+	count    int
+	failures int
+	p95s     []float64
+}
+
+// add adds a stored day
+func (s *historySummary) add(day *DailyHistory) {
+	for _, h := range day.Hours {
+		s.count += h.Count
+		s.failures += h.Failures
+		if h.Count > h.Failures && h.P95 > 0 {
+			s.p95s = append(s.p95s, h.P95)
+		}
+	}
+}
+
+// loss returns the failure percentage
+func (s historySummary) loss() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return float64(s.failures) / float64(s.count) * 100
+}
+
+// dailyRegression compares the day of p with the previous days; nil when
+// it is not worse, or when there is too little history to tell
+func (pm *PingMonitor) dailyRegression(p *reportPeriod) *DailyRegression {
+	cfg := pm.config.DailyRegression
+	if cfg == nil || pm.history == nil || p.Interim || !p.Coverage.Sufficient {
+		return nil
+	}
+	day, err := time.ParseInLocation(reportDateLayout, p.Date, time.Local)
+	if err != nil {
+		return nil
+	}
+	// The stored day includes the periods of earlier runs that day
+	stored, err := pm.history.loadDay(p.Date)
+	if err != nil {
+		return nil
+	}
+	var today historySummary
+	today.add(stored)
+
+	var baseline historySummary
+	days := 0
+	for i := 1; i <= cfg.days(); i++ {
+		h, err := pm.history.loadDay(day.AddDate(0, 0, -i).Format(reportDateLayout))
+		if err != nil {
+			continue
+		}
+		var s historySummary
+		s.add(h)
+		if s.count < pm.config.MinReportSamples {
+			continue
+		}
+		baseline.add(h)
+		days++
+	}
+	if days < cfg.minDays() {
+		return nil
+	}
+
+	r := &DailyRegression{
+		BaselineDays: days,
+		Loss:         today.loss(),
+		BaselineLoss: baseline.loss(),
+		P95:          medianP95(today.p95s),
+		BaselineP95:  medianP95(baseline.p95s),
+	}
+	r.LossDegraded = r.Loss >= r.BaselineLoss*cfg.lossFactor() && r.Loss-r.BaselineLoss >= cfg.minLossIncrease()
+	r.P95Degraded = r.BaselineP95 > 0 && r.P95 >= r.BaselineP95*cfg.p95Factor()
+	if !r.LossDegraded && !r.P95Degraded {
+		return nil
+	}
+	return r
+}
+
+// lines renders the degraded values, e.g.
+// "**パケットロス**: 2.50%（直近14日 0.30%）"
+func (r *DailyRegression) lines(f numberFormat) []string {
+	var lines []string
+	if r.LossDegraded {
+		lines = append(lines, fmt.Sprintf("**パケットロス**: %s（直近%d日 %s）", f.percent(r.Loss, 2), r.BaselineDays, f.percent(r.BaselineLoss, 2)))
+	}
+	if r.P95Degraded {
+		lines = append(lines, fmt.Sprintf("**p95（時間帯の中央値）**: %s（直近%d日 %s、%.1f倍）", f.ms(r.P95), r.BaselineDays, f.ms(r.BaselineP95), r.P95/r.BaselineP95))
+	}
+	return lines
+}
+
+// alertRegression sends the regression to the notifiers when
+// daily_regression.alert is set
+func (pm *PingMonitor) alertRegression(p *reportPeriod) {
+	r := p.Regression
+	if r == nil || !pm.config.DailyRegression.Alert {
+		return
+	}
+	message := fmt.Sprintf("**日付**: %s\n**対象**: %s", p.Date, pm.targetLabel())
+	for _, line := range r.lines(p.Format) {
+		message += "\n" + line
+	}
+	pm.dispatcher.dispatch(Event{
+		Kind:      EventRegression,
+		Severity:  SeverityWarn,
+		Time:      pm.now(),
+		Title:     "⚠️ 昨日比で悪化",
+		Message:   message,
+		Simulated: p.SimulatedCount > 0,
+		Data: map[string]interface{}{
+			"date":       p.Date,
+			"target":     pm.targetIP,
+			"regression": r,
+		},
+	})
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vantageRemoteExec runs the ping on another host over SSH
+const vantageRemoteExec = "remote-exec"
+
+const (
+	// defaultVantageInterval is the probe interval of a vantage point
+	defaultVantageInterval = 10 * time.Second
+	// defaultVantageCommandTimeout bounds one remote command, SSH included
+	defaultVantageCommandTimeout = 10 * time.Second
+	// vantageMaxBackoff caps the wait between reconnection attempts
+	vantageMaxBackoff = 5 * time.Minute
+)
+
+// vantageNameRe restricts names to what is safe in paths and series keys
+var vantageNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// VantageConfig is a second vantage point probing the same target from
+// another host
+type VantageConfig struct {
+	Name string `json:"name"`
+	// Type is the probe type; only "remote-exec" exists
+	Type string `json:"type"`
+	Host string `json:"host"`
+	// Port is the SSH port (default 22)
+	Port int    `json:"port"`
+	User string `json:"user"`
+	// KeyPath is the private key; empty uses the SSH client's defaults
+	KeyPath string `json:"key_path"`
+	// Interval is the probe interval (default 10s)
+	Interval Duration `json:"interval"`
+	// CommandTimeout bounds each remote command (default 10s)
+	CommandTimeout Duration `json:"command_timeout"`
+}
+
+// durations returns the interval and command timeout, applying the defaults
+func (c VantageConfig) durations() (interval, timeout time.Duration, err error) {
+	if c.Interval.given() {
+		if err := c.Interval.check(fmt.Sprintf("vantages[%s].interval", c.Name), time.Second, 0, "10s"); err != nil {
+			return 0, 0, err
+		}
+	}
+	if c.CommandTimeout.given() {
+		field := fmt.Sprintf("vantages[%s].command_timeout", c.Name)
+		if err := c.CommandTimeout.check(field, positiveDuration, 0, "10s"); err != nil {
+			return 0, 0, err
+		}
+		if c.CommandTimeout.Duration() <= pingTimeout {
+			return 0, 0, fmt.Errorf("%s は%vより長い期間で指定してください: %v (例: 10s)", field, pingTimeout, c.CommandTimeout)
+		}
+	}
+	return c.Interval.or(defaultVantageInterval), c.CommandTimeout.or(defaultVantageCommandTimeout), nil
+}
+
+// validateVantages checks the vantages section
+func validateVantages(vantages []VantageConfig) error {
+	seen := make(map[string]bool)
+	for _, v := range vantages {
+		if !vantageNameRe.MatchString(v.Name) {
+			return fmt.Errorf("vantages の name の値が正しくありません: %q (英数字・_・-)", v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("vantages の name が重複しています: %s", v.Name)
+		}
+		seen[v.Name] = true
+		switch v.Type {
+		case "", vantageRemoteExec:
+		case "socks5":
+			return fmt.Errorf("vantages[%s].type: SOCKS5はICMPを中継できないため使用できません（remote-exec を指定してください）", v.Name)
+		default:
+			return fmt.Errorf("vantages[%s].type の値が正しくありません: %q (remote-exec)", v.Name, v.Type)
+		}
+		if v.Host == "" {
+			return fmt.Errorf("vantages[%s].host を指定してください", v.Name)
+		}
+		if v.Port < 0 || v.Port > 65535 {
+			return fmt.Errorf("vantages[%s].port の値が正しくありません: %d", v.Name, v.Port)
+		}
+		if _, _, err := v.durations(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vantageSeriesKey is the series of a vantage point, kept apart from the
+// local probes of the target
+func vantageSeriesKey(name string) string {
+	return "vantage:" + name
+}
+
+// errVantageDisconnected is returned while the SSH session is down; such
+// probes are not samples of the target
+var errVantageDisconnected = errors.New("vantage point is not connected")
+
+// remoteVantage probes from a remote host over one multiplexed SSH
+// session: a ControlMaster process holds the connection and every probe
+// is a short ssh client riding on it, so a probe costs no handshake.
+type remoteVantage struct {
+	cfg         VantageConfig
+	interval    time.Duration
+	timeout     time.Duration
+	controlPath string
+
+	mutex    sync.Mutex
+	master   *exec.Cmd
+	exited   chan struct{}
+	sessions int
+	status   VantageStatus
+}
+
+// newRemoteVantage prepares a vantage point; the control socket lives in
+// stateDir, or the temporary directory without one
+func newRemoteVantage(cfg VantageConfig, stateDir string) *remoteVantage {
+	interval, timeout, _ := cfg.durations()
+	dir := stateDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &remoteVantage{
+		cfg:         cfg,
+		interval:    interval,
+		timeout:     timeout,
+		controlPath: filepath.Join(dir, "ssh-"+cfg.Name+".sock"),
+		status:      VantageStatus{Name: cfg.Name, Host: cfg.Host},
+	}
+}
+
+// sshArgs returns the client options shared by the master and the probes,
+// followed by the destination
+func (v *remoteVantage) sshArgs(extra ...string) []string {
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=" + strconv.Itoa(int(v.timeout/time.Second)),
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+		"-o", "ControlPath=" + v.controlPath,
+	}
+	if v.cfg.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(v.cfg.Port))
+	}
+	if v.cfg.User != "" {
+		args = append(args, "-l", v.cfg.User)
+	}
+	if v.cfg.KeyPath != "" {
+		args = append(args, "-i", v.cfg.KeyPath, "-o", "IdentitiesOnly=yes")
+	}
+	args = append(args, extra...)
+	return append(args, v.cfg.Host)
+}
+
+// connect starts the master and waits until it accepts sessions, then
+// detects the remote platform for the ping flags
+func (v *remoteVantage) connect() error {
+	os.Remove(v.controlPath)
+	cmd := exec.Command("ssh", v.sshArgs("-M", "-N", "-o", "ControlPersist=no")...)
+	stderr := &cappedBuffer{max: 4096}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.Now().Add(v.timeout)
+	for {
+		if _, err := runCommand(v.timeout, "ssh", v.sshArgs("-O", "check")...); err == nil {
+			break
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("ssh: %s", strings.TrimSpace(string(stderr.buf)))
+		case <-time.After(200 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			<-exited
+			return fmt.Errorf("ssh: %v以内に接続できません", v.timeout)
+		}
+	}
+
+	v.mutex.Lock()
+	v.master, v.exited = cmd, exited
+	v.sessions++
+	v.mutex.Unlock()
+
+	platform, err := v.detectPlatform()
+	if err != nil {
+		v.close()
+		return fmt.Errorf("プラットフォームを判定できません: %v", err)
+	}
+	v.mutex.Lock()
+	if v.sessions > 1 {
+		v.status.Reconnects++
+	}
+	v.status.Platform = platform
+	v.status.Connected, v.status.Since, v.status.LastError = true, time.Now(), ""
+	v.mutex.Unlock()
+	return nil
+}
+
+// detectPlatform asks the remote host what it is: uname on Unix, ver on
+// Windows, whose OpenSSH runs commands in cmd.exe
+func (v *remoteVantage) detectPlatform() (string, error) {
+	if out, err := v.run("uname -s"); err == nil {
+		switch name := strings.ToLower(strings.TrimSpace(string(out))); {
+		case name == "linux", name == "darwin", strings.HasSuffix(name, "bsd"):
+			return name, nil
+		case strings.HasPrefix(name, "cygwin"), strings.HasPrefix(name, "mingw"), strings.HasPrefix(name, "msys"):
+			// Their ping is the Windows one
+			return "windows", nil
+		}
+	}
+	out, err := v.run("ver")
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(string(out), "Windows") {
+		return "windows", nil
+	}
+	return "", fmt.Errorf("不明な応答: %q", strings.TrimSpace(string(out)))
+}
+
+// run executes command on the remote host over the master, bounded by the
+// command timeout
+func (v *remoteVantage) run(command string) ([]byte, error) {
+	return runCommand(v.timeout, "ssh", append(v.sshArgs("-o", "ControlMaster=no", "-T"), command)...)
+}
+
+// remotePingCommand returns the ping command line for platform. Unix hosts
+// run it in the C locale, so the usual parser reads the output.
+func remotePingCommand(platform, host string) string {
+	secs := strconv.Itoa(int(pingTimeout / time.Second))
+	switch platform {
+	case "windows":
+		return fmt.Sprintf("ping -n 1 -w %d %s", pingTimeout/time.Millisecond, host)
+	case "darwin", "freebsd":
+		// -W is in milliseconds there; -t bounds the whole run in seconds
+		return fmt.Sprintf("LC_ALL=C ping -c 1 -t %s %s", secs, host)
+	case "openbsd":
+		return fmt.Sprintf("LC_ALL=C ping -c 1 -w %s %s", secs, host)
+	default:
+		return fmt.Sprintf("LC_ALL=C ping -c 1 -W %s %s", secs, host)
+	}
+}
+
+// sshFailureExit is the exit status of ssh itself failing, as opposed to
+// the remote command
+const sshFailureExit = 255
+
+// Probe pings host from the remote side. A lost session returns
+// errVantageDisconnected-wrapped errors, which are not samples.
+func (v *remoteVantage) Probe(host string) (float64, error) {
+	// The address is put on a remote command line
+	if net.ParseIP(host) == nil {
+		return 0, &probeError{reason: reasonError, err: fmt.Errorf("invalid address %q", host)}
+	}
+	v.mutex.Lock()
+	connected, platform := v.status.Connected, v.status.Platform
+	v.mutex.Unlock()
+	if !connected {
+		return 0, errVantageDisconnected
+	}
+
+	output, err := v.run(remotePingCommand(platform, host))
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &exitErr) && exitErr.ExitCode() == sshFailureExit:
+		// ping bounds itself well within the timeout, so a stall is SSH's
+		v.disconnect(fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output))))
+		return 0, fmt.Errorf("%w: %v", errVantageDisconnected, err)
+	case err != nil:
+		return 0, &probeError{reason: classifyPingFailure(string(output), err), err: err, output: string(output)}
+	}
+	// Windows output is localized, English or Japanese
+	if ms, ok := parsePingTime(string(output), platform == "windows"); ok {
+		return ms, nil
+	}
+	if ms, ok := parsePingTime(string(output), false); ok {
+		return ms, nil
+	}
+	// The elapsed time would include SSH, so it is no substitute
+	return 0, &probeError{reason: reasonMeasurement, err: errUnparsedOutput, output: string(output)}
+}
+
+// disconnect marks the session lost and stops the master
+func (v *remoteVantage) disconnect(cause error) {
+	v.mutex.Lock()
+	if v.status.Connected {
+		fmt.Printf("❌ 観測点 %s との接続が切れました: %v\n", v.cfg.Name, cause)
+	}
+	v.status.Connected, v.status.LastError = false, cause.Error()
+	v.mutex.Unlock()
+	v.close()
+}
+
+// close stops the master, if any, and removes its socket
+func (v *remoteVantage) close() {
+	v.mutex.Lock()
+	master, exited := v.master, v.exited
+	v.master = nil
+	v.status.Connected = false
+	v.mutex.Unlock()
+	if master != nil {
+		master.Process.Kill()
+		<-exited
+	}
+	os.Remove(v.controlPath)
+}
+
+// alive reports whether the master is still running
+func (v *remoteVantage) alive() bool {
+	v.mutex.Lock()
+	exited := v.exited
+	master := v.master
+	v.mutex.Unlock()
+	if master == nil {
+		return false
+	}
+	select {
+	case <-exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// snapshot returns the vantage point for /status
+func (v *remoteVantage) snapshot() VantageStatus {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.status
+}
+
+// loop probes the current target address every interval until stop,
+// reconnecting with exponential backoff while the session is down
+func (v *remoteVantage) loop(pm *PingMonitor) {
+	defer v.close()
+	backoff := time.Second
+	next := time.Now()
+	for {
+		select {
+		case <-pm.stopChan:
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		if !v.alive() {
+			if v.snapshot().Connected {
+				v.disconnect(errors.New("ssh master exited"))
+			}
+			if err := v.connect(); err != nil {
+				v.mutex.Lock()
+				v.status.LastError = err.Error()
+				v.mutex.Unlock()
+				fmt.Printf("❌ 観測点 %s に接続できません（%v後に再試行）: %v\n", v.cfg.Name, backoff, err)
+				next = time.Now().Add(backoff)
+				backoff = min(backoff*2, vantageMaxBackoff)
+				continue
+			}
+			backoff = time.Second
+			fmt.Printf("🔭 観測点 %s (%s) に接続しました（%s）\n", v.cfg.Name, v.cfg.Host, v.snapshot().Platform)
+		}
+
+		next = time.Now().Add(v.interval)
+		pm.mutex.RLock()
+		addr := pm.targetAddr
+		pm.mutex.RUnlock()
+		if addr == "" {
+			continue
+		}
+		now := pm.now()
+		ms, err := v.Probe(addr)
+		if errors.Is(err, errVantageDisconnected) {
+			next = time.Now()
+			continue
+		}
+		var reason failureReason
+		if err != nil {
+			reason = classifyFailure(err)
+			fmt.Printf("%s - [%s] %s 到達不能（%s）\n", now.Format("15:04:05"), v.cfg.Name, pm.targetIP, reason.label())
+		}
+		pm.series.add(vantageSeriesKey(v.cfg.Name), now, reason, ms)
+		v.mutex.Lock()
+		v.status.LastProbe, v.status.LastRTTMs, v.status.LastReason = now, ms, string(reason)
+		v.mutex.Unlock()
+	}
+}
+
+// vantage returns the vantage point named name, or nil
+func (pm *PingMonitor) vantage(name string) *remoteVantage {
+	for _, v := range pm.vantages {
+		if v.cfg.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// vantageStatus returns every vantage point for /status
+func (pm *PingMonitor) vantageStatus() []VantageStatus {
+	var out []VantageStatus
+	for _, v := range pm.vantages {
+		out = append(out, v.snapshot())
+	}
+	return out
+}
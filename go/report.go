@@ -0,0 +1,598 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportDateLayout is the layout of report period dates
+const reportDateLayout = "2006-01-02"
+
+// dayBounds returns the local midnights starting and ending date, which
+// are 23 or 25 hours apart on a DST change; both are zero for a malformed
+// date
+func dayBounds(date string) (time.Time, time.Time) {
+	day, err := time.ParseInLocation(reportDateLayout, date, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}
+	}
+	return day, time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, time.Local)
+}
+
+// reportPeriod holds the data of one finalized reporting period
+type reportPeriod struct {
+	Date string
+	// Start and End bound the period. It starts at midnight, or when
+	// monitoring started if later, and ends at the next midnight, or when
+	// it was taken for an interim report. End is exclusive.
+	Start, End time.Time
+	// Samples summarizes the successes (see samples.go)
+	Samples          *periodSamples
+	UnreachableTimes []time.Time
+	// FailureGateways is the gateway diagnostic of each failure, indexed
+	// like UnreachableTimes
+	FailureGateways []gatewayState
+	SimulatedCount  int
+	// WarmupCount is the number of samples excluded as startup warm-up
+	WarmupCount int
+	// UnanchoredCount is the number of samples dropped because their time
+	// could not be corrected after the clock was set
+	UnanchoredCount int
+	// HostBusyCount is the number of samples taken under host load
+	HostBusyCount int
+	// LoadTestCount is the number of samples excluded during the load of
+	// the bufferbloat test, whose runs are Bufferbloat
+	LoadTestCount int
+	Bufferbloat   []BufferbloatResult
+	// MeasurementSpans are the stretches whose probes failed in the
+	// monitor itself, excluded from the statistics
+	MeasurementSpans []measurementSpan
+	// FailureReasons counts the period's failures per reason
+	FailureReasons reasonCounts
+	// Outages are the outages that ended during the period
+	Outages []OutageRecord
+	// OpenOutage is the start of the outage still in progress when the
+	// period was taken, carried on into the next period; zero if none
+	OpenOutage          time.Time
+	OpenOutageSimulated bool
+	// RouteChanges are confirmed changes of the first hops; RouteFlaps
+	// counts suppressed load-balancer switches
+	RouteChanges []routeChange
+	RouteFlaps   int
+	// WANErrors are the router's interface counter deltas
+	WANErrors []wanErrorSample
+	// ScheduleOverruns counts slots skipped after late cycles;
+	// ScheduleGaps counts suspensions, lasting ScheduleGapTime in total
+	ScheduleOverruns int
+	ScheduleGaps     int
+	ScheduleGapTime  time.Duration
+	// ControlActions counts the period's entries in the audit log
+	ControlActions int
+	// AddressChanges and ResolveFailures track hostname re-resolution
+	AddressChanges  []addressChange
+	ResolveFailures int
+	Coverage        ReportCoverage
+	Interim         bool
+	// Regression is set when the day was worse than the previous days
+	Regression *DailyRegression
+	// Connection is the connection in effect when the period began, and
+	// ConnectionChanges its switches during the period (see conntype.go)
+	Connection        connection
+	ConnectionChanges []connectionChange
+	// Targets are the additional targets' shares of the period
+	Targets []targetPeriod
+	// Deferred holds notifications held back by quiet hours
+	Deferred []Event
+	// Format renders the period's numbers and durations
+	Format numberFormat
+	// Interval is the ping interval the samples were taken at
+	Interval time.Duration
+	// ProbeTimeout is how long a probe of the target waited for the reply
+	ProbeTimeout time.Duration
+}
+
+// empty reports whether the period contains no samples
+func (p *reportPeriod) empty() bool {
+	return p.Samples.successes == 0 && len(p.UnreachableTimes) == 0
+}
+
+// probeStats computes the period's statistics
+func (p *reportPeriod) probeStats() ProbeStats {
+	return p.Samples.latency.probeStats(len(p.UnreachableTimes))
+}
+
+// insufficientMarker is appended to success rates of low-coverage reports
+const insufficientMarker = "（データ不足）"
+
+// ReportCoverage tells how much data a report is based on, so that every
+// renderer can qualify the numbers the same way
+type ReportCoverage struct {
+	Samples         int     `json:"samples"`
+	CoverageSeconds float64 `json:"coverage_seconds"`
+	Sufficient      bool    `json:"sufficient"`
+}
+
+// Marker returns insufficientMarker for reports with too little data
+func (c ReportCoverage) Marker() string {
+	if c.Sufficient {
+		return ""
+	}
+	return insufficientMarker
+}
+
+// computeCoverage measures the samples of p: the span from the first to
+// the last sample plus one interval, and the number of samples counted in
+// the statistics. A report is sufficient only when both thresholds are met.
+func computeCoverage(p *reportPeriod, interval, minDuration time.Duration, minSamples int) ReportCoverage {
+	first, last := p.Samples.first, p.Samples.last
+	samples := p.Samples.latency.count
+	observe := func(t time.Time) {
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if t.After(last) {
+			last = t
+		}
+		samples++
+	}
+	for _, t := range p.UnreachableTimes {
+		observe(t)
+	}
+
+	c := ReportCoverage{Samples: samples}
+	if samples > 0 {
+		c.CoverageSeconds = (last.Sub(first) + interval).Seconds()
+	}
+	c.Sufficient = samples >= minSamples && c.CoverageSeconds >= minDuration.Seconds()
+	return c
+}
+
+// warmupNote returns "ウォームアップ除外: n件" prefixed with sep, or "" when
+// no samples were excluded
+func (p *reportPeriod) warmupNote(sep string) string {
+	if p.WarmupCount == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%sウォームアップ除外: %s件", sep, p.Format.count(p.WarmupCount))
+}
+
+// loadTestNote returns "負荷試験中の除外: n件" prefixed with sep, or ""
+// when no bufferbloat test ran
+func (p *reportPeriod) loadTestNote(sep string) string {
+	if p.LoadTestCount == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s負荷試験中の除外: %s件", sep, p.Format.count(p.LoadTestCount))
+}
+
+// measurementNote returns "計測不良で除外: n件" prefixed with sep, or ""
+// when every probe could measure
+func (p *reportPeriod) measurementNote(sep string) string {
+	if len(p.MeasurementSpans) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s計測不良で除外: %s件", sep, p.Format.count(measurementErrors(p.MeasurementSpans)))
+}
+
+// addressSummary lists address changes and the resolution failure count
+func (p *reportPeriod) addressSummary() string {
+	var parts []string
+	if len(p.AddressChanges) > 0 {
+		parts = append(parts, formatAddressChanges(p.AddressChanges))
+	}
+	if p.ResolveFailures > 0 {
+		parts = append(parts, fmt.Sprintf("名前解決の失敗: %s回（直前のアドレスで継続）", p.Format.count(p.ResolveFailures)))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// hostBusyNote returns "ホスト高負荷中の計測: n%" prefixed with sep, or ""
+// when no sample was taken under host load
+func (p *reportPeriod) hostBusyNote(sep string) string {
+	if p.HostBusyCount == 0 || p.Coverage.Samples == 0 {
+		return ""
+	}
+	percent := float64(p.HostBusyCount) / float64(p.Coverage.Samples) * 100
+	return fmt.Sprintf("%sホスト高負荷中の計測: %s", sep, p.Format.percent(math.Max(percent, 1), 0))
+}
+
+// controlActionsNote returns "・操作 n件" for the report footer, or "" when
+// nothing was done to the monitor during the period
+func (p *reportPeriod) controlActionsNote() string {
+	if p.ControlActions == 0 {
+		return ""
+	}
+	return fmt.Sprintf("・操作 %d件", p.ControlActions)
+}
+
+// gatewayFailures counts the period's failures that fall inside outages
+// attributed to the gateway. They are real failures of the target, but
+// summaries show them under the gateway instead of the line.
+func (p *reportPeriod) gatewayFailures() int {
+	n := 0
+	for _, t := range p.UnreachableTimes {
+		for _, o := range p.Outages {
+			if o.Cause == outageCauseGateway && !t.Before(o.Start) && t.Before(o.End) {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// gatewayNote returns the failures attributed to the gateway, prefixed by
+// sep, or "" when there are none
+func (p *reportPeriod) gatewayNote(sep string) string {
+	n := p.gatewayFailures()
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%sうち起因: ゲートウェイ %s回（回線側 %s回）", sep, p.Format.count(n), p.Format.count(len(p.UnreachableTimes)-n))
+}
+
+// outageCount returns the period's outages including one in progress
+func (p *reportPeriod) outageCount() int {
+	if p.OpenOutage.IsZero() {
+		return len(p.Outages)
+	}
+	return len(p.Outages) + 1
+}
+
+// outageStart formats the start of an outage, with the date when it began
+// before the period's day
+func (p *reportPeriod) outageStart(t time.Time) string {
+	if t.Format(reportDateLayout) != p.Date {
+		return t.Format("01/02 15:04:05")
+	}
+	return t.Format("15:04:05")
+}
+
+// unreachableSpan is a run of consecutive failures, taken as one outage
+type unreachableSpan struct {
+	Start time.Time
+	// End is the last failure of the run
+	End      time.Time
+	Failures int
+}
+
+// duration counts each failure as one ping interval, so a single failure
+// lasts the interval rather than nothing
+func (s unreachableSpan) duration(interval time.Duration) time.Duration {
+	return s.End.Sub(s.Start) + interval
+}
+
+// spanInterval is the ping interval of the period, 1s for periods from
+// before the interval was recorded
+func (p *reportPeriod) spanInterval() time.Duration {
+	if p.Interval <= 0 {
+		return time.Second
+	}
+	return p.Interval
+}
+
+// unreachableSpans collapses the period's failures into runs: a failure
+// less than two ping intervals after the previous one continues the run.
+// A probe timeout longer than the interval pushes the next probe back by
+// up to the timeout, which the gap allows for on top.
+func (p *reportPeriod) unreachableSpans() []unreachableSpan {
+	gap := 2 * p.spanInterval()
+	if p.ProbeTimeout > p.spanInterval() {
+		gap += p.ProbeTimeout
+	}
+	var spans []unreachableSpan
+	for _, t := range p.UnreachableTimes {
+		if n := len(spans); n > 0 && t.Sub(spans[n-1].End) < gap {
+			spans[n-1].End = t
+			spans[n-1].Failures++
+			continue
+		}
+		spans = append(spans, unreachableSpan{Start: t, End: t, Failures: 1})
+	}
+	return spans
+}
+
+// unreachableLines describes up to limit of the longest runs, longest
+// first, followed by the number left out and the total downtime. The
+// total line comes first, so truncation drops runs, never the total.
+func (p *reportPeriod) unreachableLines(limit int) []string {
+	spans := p.unreachableSpans()
+	interval := p.spanInterval()
+	var total time.Duration
+	for _, s := range spans {
+		total += s.duration(interval)
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].duration(interval) > spans[j].duration(interval)
+	})
+	lines := []string{fmt.Sprintf("合計 %s（%d期間・%d回）", p.Format.duration(total), len(spans), len(p.UnreachableTimes))}
+	for i, s := range spans {
+		if i >= limit {
+			lines = append(lines, fmt.Sprintf("... 他%d期間", len(spans)-limit))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s – %s (%s)", p.outageStart(s.Start), p.outageStart(s.End), p.Format.duration(s.duration(interval))))
+	}
+	return lines
+}
+
+// failureWindow returns the first and last failures of the period, nil
+// when it had none
+func (p *reportPeriod) failureWindow() (first, last *time.Time) {
+	if len(p.UnreachableTimes) == 0 {
+		return nil, nil
+	}
+	f, l := p.UnreachableTimes[0], p.UnreachableTimes[len(p.UnreachableTimes)-1]
+	return &f, &l
+}
+
+// carriedOver reports whether failure t belongs to an outage that began
+// before the period's day
+func (p *reportPeriod) carriedOver(t time.Time) bool {
+	for _, o := range p.Outages {
+		if o.Start.Format(reportDateLayout) != p.Date && !t.Before(o.Start) && !t.After(o.End) {
+			return true
+		}
+	}
+	return !p.OpenOutage.IsZero() && p.OpenOutage.Format(reportDateLayout) != p.Date && !t.Before(p.OpenOutage)
+}
+
+// formatFailureWindow renders "初回失敗 06:12:03 / 最終失敗 06:14:47", or
+// "なし" for a clean period. An outage crossing midnight is split like the
+// periods: each day shows its own failures, marked as continuing.
+func (p *reportPeriod) formatFailureWindow() string {
+	first, last := p.failureWindow()
+	if first == nil {
+		return "なし"
+	}
+	s := "初回失敗 " + first.Format("15:04:05")
+	if p.carriedOver(*first) {
+		s += "（前日から継続）"
+	}
+	s += " / 最終失敗 " + last.Format("15:04:05")
+	switch {
+	case p.OpenOutage.IsZero():
+	case p.Interim:
+		s += "（継続中）"
+	default:
+		s += "（翌日へ継続）"
+	}
+	return s
+}
+
+// formatPeriodOutages lists the period's outages with their dominant
+// failure reason, followed by the one still in progress
+func (p *reportPeriod) formatPeriodOutages() string {
+	var lines []string
+	for i, o := range p.Outages {
+		if i >= 10 {
+			lines = append(lines, fmt.Sprintf("... 他%d件", len(p.Outages)-10))
+			break
+		}
+		line := fmt.Sprintf("%s〜%s (%s) 主な原因: %s", p.outageStart(o.Start), o.End.Format("15:04:05"),
+			p.Format.seconds(o.DurationSeconds), failureReason(o.Reason).label())
+		if o.Cause == outageCauseGateway {
+			line += " 起因: ゲートウェイ"
+		}
+		if o.Connection != connUnknown && len(p.ConnectionChanges) > 0 {
+			line += " 接続: " + o.Connection.label()
+		}
+		if o.UnnotifiedSeconds > 0 {
+			line += " 通知不能時間: " + p.Format.seconds(o.UnnotifiedSeconds)
+		}
+		if o.Acknowledged {
+			line += " 🔕確認済み"
+		}
+		if o.Simulated {
+			line += " [SIMULATED]"
+		}
+		lines = append(lines, line)
+	}
+	if !p.OpenOutage.IsZero() {
+		line := fmt.Sprintf("%s〜（継続中）", p.outageStart(p.OpenOutage))
+		if p.OpenOutageSimulated {
+			line += " [SIMULATED]"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// saveHistory persists the hourly aggregates of p when a state directory
+// is configured
+func (pm *PingMonitor) saveHistory(p *reportPeriod) {
+	if pm.history == nil {
+		return
+	}
+	if err := pm.history.saveDay(p); err != nil {
+		fmt.Printf("❌ 履歴保存エラー: %v\n", err)
+	}
+}
+
+// dailyReportEvent summarizes a period for notifiers other than the
+// detailed Discord embed
+func (pm *PingMonitor) dailyReportEvent(p *reportPeriod) Event {
+	stats := p.probeStats()
+	title := "📊 Ping Monitor 日次レポート " + p.Date
+	if p.Interim {
+		title = "📊 Ping Monitor 途中経過 " + p.Date
+	}
+	data := map[string]interface{}{
+		"date":         p.Date,
+		"period_start": p.Start,
+		"period_end":   p.End,
+		"stats":        stats,
+		"coverage":     p.Coverage,
+	}
+	if p.Regression != nil {
+		data["regression"] = p.Regression
+	}
+	return Event{
+		Kind:     EventReport,
+		Severity: SeverityInfo,
+		Time:     pm.now(),
+		Title:    title,
+		Message: fmt.Sprintf("**対象期間**: %s\n**成功率**: %s%s\n**平均**: %s\n**最大**: %s\n**失敗回数**: %s",
+			p.Format.period(p.Start, p.End), p.Format.percent(stats.SuccessRate, 2), p.Coverage.Marker(), p.Format.ms(stats.Latency.Avg), p.Format.ms(stats.Latency.Max), p.Format.count(stats.Failure)),
+		Simulated: p.SimulatedCount > 0,
+		Data:      data,
+	}
+}
+
+// reportCoordinator finalizes reporting periods and emits each of them
+// exactly once. Both the day rollover in pingLoop and Stop() go through it,
+// so a shutdown racing the midnight rollover can neither double-send nor
+// send a period whose data was already reset. The closed days are
+// published in order from a goroutine of their own, so the slow sends do
+// not hold up the probes.
+type reportCoordinator struct {
+	pm     *PingMonitor
+	mutex  sync.Mutex
+	day    string
+	closed bool
+	emit   func(p *reportPeriod)
+	// queue holds the closed days not yet published; publishing is set
+	// while a goroutine drains it
+	queue      []reportJob
+	publishing bool
+	pending    sync.WaitGroup
+}
+
+// reportJob is the publishing of one closed day
+type reportJob struct {
+	period *reportPeriod
+	// month is the month the day closed, zero if it did not
+	month time.Time
+	// closedAt is when the day closed, for pruning the Store
+	closedAt time.Time
+}
+
+// newReportCoordinator starts a coordinator whose current period is the
+// day of now
+func newReportCoordinator(pm *PingMonitor, now time.Time) *reportCoordinator {
+	return &reportCoordinator{
+		pm:   pm,
+		day:  now.Format(reportDateLayout),
+		emit: pm.publishDailyReport,
+	}
+}
+
+// rollover finalizes the current period if now falls on a different day
+func (rc *reportCoordinator) rollover(now time.Time) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	currentDay := now.Format(reportDateLayout)
+	if rc.closed || currentDay == rc.day {
+		return
+	}
+
+	// A clock still at its boot value would close made-up days
+	if rc.pm.clock != nil && rc.pm.clock.waitingForClock() {
+		return
+	}
+
+	// Only the snapshot is taken here; saving it, pruning and the sends
+	// happen in the publishing goroutine, off the ping loop
+	previousDay := rc.day
+	job := reportJob{period: rc.pm.takePeriod(rc.day), closedAt: now}
+	rc.day = currentDay
+
+	// The first rollover into a new month also closes the previous month
+	if prev, err := time.ParseInLocation(reportDateLayout, previousDay, now.Location()); err == nil && prev.Month() != now.Month() {
+		job.month = prev
+	}
+	rc.enqueue(job)
+}
+
+// enqueue adds a closed day to the queue, starting the publishing
+// goroutine if none is running. Called with rc.mutex held.
+func (rc *reportCoordinator) enqueue(job reportJob) {
+	rc.pending.Add(1)
+	rc.queue = append(rc.queue, job)
+	if !rc.publishing {
+		rc.publishing = true
+		go rc.publish()
+	}
+}
+
+// publish saves and sends the queued days in order until the queue is
+// empty. A day is on disk before its sends start, so a kill mid-send
+// keeps it.
+func (rc *reportCoordinator) publish() {
+	for {
+		rc.mutex.Lock()
+		if len(rc.queue) == 0 {
+			rc.publishing = false
+			rc.mutex.Unlock()
+			return
+		}
+		job := rc.queue[0]
+		rc.queue = rc.queue[1:]
+		rc.mutex.Unlock()
+
+		rc.pm.saveHistory(job.period)
+		rc.pm.pruneStore(job.closedAt)
+		if !job.period.empty() {
+			rc.emit(job.period)
+		}
+		if !job.month.IsZero() {
+			rc.pm.sendMonthlyReport(job.month)
+		}
+		rc.pending.Done()
+	}
+}
+
+// flush waits until the closed days queued so far are published
+func (rc *reportCoordinator) flush() {
+	rc.pending.Wait()
+}
+
+// restart makes day the current period, after the clock was set
+func (rc *reportCoordinator) restart(day string) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.day = day
+}
+
+// shutdown finalizes the current period as an interim report, after the
+// closed days still being published; afterwards the coordinator ignores
+// all further calls
+func (rc *reportCoordinator) shutdown() {
+	p := rc.finalize()
+	rc.flush()
+	if p != nil {
+		rc.send(p)
+	}
+}
+
+// finalize closes the coordinator and persists the current period without
+// any network sends, returning it for send unless it is empty or the
+// coordinator was already closed
+func (rc *reportCoordinator) finalize() *reportPeriod {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if rc.closed {
+		return nil
+	}
+	rc.closed = true
+
+	p := rc.pm.takePeriod(rc.day)
+	p.Interim = true
+	rc.pm.saveHistory(p)
+	if p.empty() {
+		return nil
+	}
+	return p
+}
+
+// send emits a period returned by finalize
+func (rc *reportCoordinator) send(p *reportPeriod) {
+	fmt.Println("現在の統計を送信中...")
+	rc.emit(p)
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lateEvening is shortly before a local midnight
+var lateEvening = time.Date(2026, 3, 10, 23, 59, 50, 0, time.Local)
+
+// tickWithin runs pm.tick and reports whether it returned within d
+func tickWithin(pm *PingMonitor, slot time.Time, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		pm.tick(slot)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func TestRolloverDoesNotBlockProbes(t *testing.T) {
+	pm, clock := newTestMonitor(t, nil, lateEvening)
+	var probes atomic.Int32
+	pm.prober = probeFunc(func(host string) (float64, error) {
+		if host == pm.targetIP {
+			probes.Add(1)
+		}
+		return 10, nil
+	})
+	started := make(chan string, 1)
+	release := make(chan struct{})
+	pm.reports.emit = func(p *reportPeriod) {
+		started <- p.Date
+		<-release
+	}
+
+	at := lateEvening
+	for i := 0; i < 20; i++ {
+		clock.set(at)
+		if !tickWithin(pm, at, 5*time.Second) {
+			close(release)
+			t.Fatalf("tick at %s blocked while the report was being sent", at.Format("15:04:05"))
+		}
+		at = at.Add(time.Second)
+	}
+	select {
+	case date := <-started:
+		if date != "2026-03-10" {
+			t.Errorf("sent %s, want 2026-03-10", date)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the closed day was never sent")
+	}
+	if got := probes.Load(); got != 20 {
+		t.Errorf("%d probes during the blocked send, want 20", got)
+	}
+
+	close(release)
+	pm.reports.flush()
+	if p := pm.reports.finalize(); p == nil || p.probeStats().Total != 10 {
+		t.Errorf("the new day lost samples: %+v", p)
+	}
+}
+
+// slowPruneStore is a Store whose Prune waits for release, as a large
+// results file being rewritten
+type slowPruneStore struct {
+	Store
+	pruning chan time.Time
+	release chan struct{}
+}
+
+func (s *slowPruneStore) Prune(before time.Time) error {
+	s.pruning <- before
+	<-s.release
+	return s.Store.Prune(before)
+}
+
+// TestRolloverDoesNotBlockOnDisk checks that saving the closed day and
+// pruning the Store happen off the ping loop, before the day is sent
+func TestRolloverDoesNotBlockOnDisk(t *testing.T) {
+	quietStdout(t)
+	pm, clock := newTestMonitor(t, map[string]interface{}{"state_dir": t.TempDir(), "store_retention": "24h"}, lateEvening)
+	store := &slowPruneStore{Store: pm.store, pruning: make(chan time.Time, 1), release: make(chan struct{})}
+	pm.store = store
+	var sent atomic.Value
+	pm.reports.emit = func(p *reportPeriod) {
+		_, err := pm.history.loadDay(p.Date)
+		sent.Store(err == nil)
+	}
+
+	at := lateEvening
+	for i := 0; i < 20; i++ {
+		clock.set(at)
+		if !tickWithin(pm, at, 5*time.Second) {
+			close(store.release)
+			t.Fatalf("tick at %s blocked while the Store was pruned", at.Format("15:04:05"))
+		}
+		at = at.Add(time.Second)
+	}
+	select {
+	case before := <-store.pruning:
+		if want := lateEvening.Add(10 * time.Second).Add(-24 * time.Hour); !before.Equal(want) {
+			t.Errorf("pruned before %s, want %s", before, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the Store was never pruned")
+	}
+	if sent.Load() != nil {
+		t.Error("the day was sent before the Store was pruned")
+	}
+	close(store.release)
+	pm.reports.flush()
+	if saved, _ := sent.Load().(bool); !saved {
+		t.Error("the day was sent before it was saved")
+	}
+}
+
+// TestReportExactlyOnce races the midnight rollover against the shutdown
+// and checks that every sample is reported once and no day twice
+func TestReportExactlyOnce(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		pm, clock := newTestMonitor(t, nil, lateEvening)
+		var mutex sync.Mutex
+		sent := map[string]int{}
+		total := 0
+		pm.reports.emit = func(p *reportPeriod) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !p.Interim {
+				sent[p.Date]++
+			}
+			total += p.probeStats().Total
+		}
+		for s := 0; s < 5; s++ {
+			at := lateEvening.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+		}
+
+		midnight := lateEvening.Add(10 * time.Second)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			pm.reports.rollover(midnight)
+		}()
+		go func() {
+			defer wg.Done()
+			pm.reports.shutdown()
+		}()
+		wg.Wait()
+		pm.reports.flush()
+
+		mutex.Lock()
+		if total != 5 {
+			t.Errorf("run %d: %d samples reported, want 5", i, total)
+		}
+		for date, n := range sent {
+			if n > 1 {
+				t.Errorf("run %d: %s sent %d times", i, date, n)
+			}
+		}
+		mutex.Unlock()
+	}
+}
+
+func TestShutdownSendsClosedDayFirst(t *testing.T) {
+	pm, clock := newTestMonitor(t, nil, lateEvening)
+	var order []string
+	release := make(chan struct{})
+	pm.reports.emit = func(p *reportPeriod) {
+		if !p.Interim {
+			<-release
+		}
+		order = append(order, p.Date)
+	}
+	for _, at := range []time.Time{lateEvening, lateEvening.Add(15 * time.Second)} {
+		clock.set(at)
+		pm.tick(at)
+	}
+	done := make(chan struct{})
+	go func() {
+		pm.reports.shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("shutdown did not wait for the closed day")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	if len(order) != 2 || order[0] != "2026-03-10" || order[1] != "2026-03-11" {
+		t.Errorf("sent %v, want the closed day before the interim one", order)
+	}
+}
+
+func TestUnreachableSpans(t *testing.T) {
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	at := func(secs ...float64) []time.Time {
+		var times []time.Time
+		for _, s := range secs {
+			times = append(times, base.Add(time.Duration(s*float64(time.Second))))
+		}
+		return times
+	}
+	tests := []struct {
+		name     string
+		interval time.Duration
+		timeout  time.Duration
+		failures []time.Time
+		// want is the failure count of each span
+		want     []int
+		wantDown time.Duration
+	}{
+		{"consecutive", time.Second, time.Second, at(0, 1, 2, 3), []int{4}, 4 * time.Second},
+		{"one missed slot", time.Second, time.Second, at(0, 1, 2.9), []int{3}, 3900 * time.Millisecond},
+		{"two outages", time.Second, time.Second, at(0, 1, 5, 6), []int{2, 2}, 4 * time.Second},
+		// A 3s probe timeout at a 1s interval lands the failures 4s apart
+		{"timeouts longer than the interval", time.Second, 3 * time.Second, at(0, 4, 8, 12), []int{4}, 13 * time.Second},
+		{"after the timeout", time.Second, 3 * time.Second, at(0, 4, 9.5), []int{2, 1}, 6 * time.Second},
+		{"recorded before the timeout", time.Second, 0, at(0, 1, 4), []int{2, 1}, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		p := &reportPeriod{Interval: tt.interval, ProbeTimeout: tt.timeout, UnreachableTimes: tt.failures}
+		var got []int
+		var down time.Duration
+		for _, s := range p.unreachableSpans() {
+			got = append(got, s.Failures)
+			down += s.duration(p.spanInterval())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: spans %v, want %v", tt.name, got, tt.want)
+		}
+		if down != tt.wantDown {
+			t.Errorf("%s: downtime %v, want %v", tt.name, down, tt.wantDown)
+		}
+	}
+}
+
+func TestDayBoundsAcrossDST(t *testing.T) {
+	loc := setLocal(t, "America/New_York")
+	tests := []struct {
+		date  string
+		hours time.Duration
+		want  string
+	}{
+		{"2026-03-07", 24, "2026-03-07 00:00:00 EST 〜 23:59:59 EST"},
+		{"2026-03-08", 23, "2026-03-08 00:00:00 EST 〜 23:59:59 EDT"},
+		{"2026-11-01", 25, "2026-11-01 00:00:00 EDT 〜 23:59:59 EST"},
+	}
+	for _, tt := range tests {
+		start, end := dayBounds(tt.date)
+		if end.Sub(start) != tt.hours*time.Hour || start.Location() != loc {
+			t.Errorf("%s: %s to %s", tt.date, start, end)
+		}
+		if got := (numberFormat{}).period(start, end); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.date, got, tt.want)
+		}
+	}
+	if got := (numberFormat{locale: "de"}).period(dayBounds("2026-11-01")); got != "01.11.2026 00:00:00 EDT – 23:59:59 EST" {
+		t.Errorf("de: %q", got)
+	}
+	from, to := monthRange(time.Date(2026, 11, 15, 0, 0, 0, 0, loc))
+	if to.Sub(from) != 30*24*time.Hour+time.Hour {
+		t.Errorf("November: %s to %s", from, to)
+	}
+}
+
+// TestReportPeriodAcrossDST samples across the offset changes and checks
+// the bounds and the hours of the reported days
+func TestReportPeriodAcrossDST(t *testing.T) {
+	loc := setLocal(t, "America/New_York")
+	tests := []struct {
+		name string
+		// start is when monitoring started; the samples run from first
+		// for a minute, across the change
+		start, first time.Time
+		period       string
+		length       time.Duration
+		// hours are the samples of each local hour
+		hours map[int]int
+	}{
+		{
+			name:   "spring forward after a restart",
+			start:  time.Date(2026, 3, 8, 1, 59, 30, 0, loc),
+			first:  time.Date(2026, 3, 8, 1, 59, 30, 0, loc),
+			period: "2026-03-08 01:59:30 EST 〜 23:59:59 EDT",
+			length: 21*time.Hour + 30*time.Second,
+			hours:  map[int]int{1: 30, 3: 30},
+		},
+		{
+			name:   "fall back",
+			start:  time.Date(2026, 11, 1, 0, 0, 0, 0, loc),
+			first:  time.Date(2026, 11, 1, 1, 59, 30, 0, loc),
+			period: "2026-11-01 00:00:00 EDT 〜 23:59:59 EST",
+			length: 25 * time.Hour,
+			// 01:59:30 EDT is followed by 01:00:00 EST
+			hours: map[int]int{0: 1, 1: 60},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quietStdout(t)
+			pm, clock := newTestMonitor(t, nil, tt.start)
+			var sent []*reportPeriod
+			pm.reports.emit = func(p *reportPeriod) { sent = append(sent, p) }
+			tick := func(at time.Time) {
+				clock.set(at)
+				pm.tick(at)
+			}
+			if tt.first.After(tt.start) {
+				tick(tt.start)
+			}
+			for s := 0; s < 60; s++ {
+				tick(tt.first.Add(time.Duration(s) * time.Second))
+			}
+			_, end := dayBounds(tt.start.Format(reportDateLayout))
+			tick(end)
+			pm.reports.flush()
+
+			if len(sent) != 1 {
+				t.Fatalf("%d reports, want 1", len(sent))
+			}
+			p := sent[0]
+			if got := p.Format.period(p.Start, p.End); got != tt.period {
+				t.Errorf("period %q, want %q", got, tt.period)
+			}
+			if p.End.Sub(p.Start) != tt.length {
+				t.Errorf("period of %v, want %v", p.End.Sub(p.Start), tt.length)
+			}
+			for h := range p.Samples.hours {
+				if got := p.Samples.hours[h].latency.count; got != tt.hours[h] {
+					t.Errorf("hour %d: %d samples, want %d", h, got, tt.hours[h])
+				}
+			}
+		})
+	}
+}
+
+// TestWarmupSuccessCount checks that /status counts the successes as the
+// report does, without the warm-up, also for samples taken while waiting
+// for the clock
+func TestWarmupSuccessCount(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	for _, wait := range []bool{false, true} {
+		pm, clock := newTestMonitor(t, map[string]interface{}{"ping_interval": "1s", "warmup": "1m", "min_report_coverage": "1m", "min_report_samples": 10}, start)
+		pm.prober = probeFunc(func(string) (float64, error) {
+			if s := clock.now().Sub(start) / time.Second; s%10 == 5 {
+				return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+			}
+			return 10, nil
+		})
+		for s := 0; s < 120; s++ {
+			at := start.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			if wait && s == 30 {
+				pm.clock = newClockGuard(start.AddDate(1, 0, 0), at)
+			}
+			if wait && s == 90 {
+				pm.clock.floor = start
+				pm.checkClock(at)
+			}
+			pm.tick(at)
+			if got, want := pm.status().SuccessCount, pm.currentPeriod().probeStats().Success; got != want {
+				t.Fatalf("wait %v, %ds: /status counts %d successes, the report %d", wait, s, got, want)
+			}
+		}
+		// 54 of the 60 samples after the warm-up succeeded
+		if got := pm.status().SuccessCount; got != 54 {
+			t.Errorf("wait %v: %d successes, want 54", wait, got)
+		}
+		embed := pm.dailyReportEmbed(pm.takePeriod(start.Format(reportDateLayout)))
+		if len(embed.Fields) < 2 || !strings.Contains(embed.Fields[1].Value, "**成功回数**: 54\n") {
+			t.Errorf("wait %v: fields %+v", wait, embed.Fields)
+		}
+	}
+}
+
+// TestOutageAcrossTwoMidnights runs an outage from 23:50 until 00:10 two
+// days later: each day's report holds its own share of the failures, the
+// days in between show the outage as continuing from its true start, and
+// the shares add up to the outage's duration
+func TestOutageAcrossTwoMidnights(t *testing.T) {
+	setLocal(t, "Asia/Tokyo")
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 23, 40, 0, 0, time.Local)
+	down := time.Date(2026, 3, 10, 23, 50, 0, 0, time.Local)
+	up := time.Date(2026, 3, 12, 0, 10, 0, 0, time.Local)
+	pm, clock := newTestMonitor(t, map[string]interface{}{"ping_interval": "10s", "failure_threshold": 3, "recovery_threshold": 3}, start)
+	n := &fakeNotifier{name: "test"}
+	notifyTo(pm, n)
+	pm.prober = probeFunc(func(host string) (float64, error) {
+		if now := clock.now(); host == pm.targetIP && !now.Before(down) && now.Before(up) {
+			return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+		}
+		return 10, nil
+	})
+	var mutex sync.Mutex
+	var periods []*reportPeriod
+	pm.reports.emit = func(p *reportPeriod) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		periods = append(periods, p)
+	}
+	for at := start; at.Before(up.Add(10 * time.Minute)); at = at.Add(10 * time.Second) {
+		clock.set(at)
+		pm.tick(at)
+	}
+	pm.reports.shutdown()
+	pm.dispatcher.flush()
+
+	if len(periods) != 3 {
+		t.Fatalf("%d reports, want 3", len(periods))
+	}
+	tests := []struct {
+		date     string
+		failures int
+		window   string
+		outages  string
+	}{
+		{"2026-03-10", 60, "初回失敗 23:50:00 / 最終失敗 23:59:50（翌日へ継続）", "23:50:00〜（継続中）"},
+		{"2026-03-11", 8640, "初回失敗 00:00:00（前日から継続） / 最終失敗 23:59:50（翌日へ継続）", "03/10 23:50:00〜（継続中）"},
+		{"2026-03-12", 60, "初回失敗 00:00:00（前日から継続） / 最終失敗 00:09:50", "03/10 23:50:00〜00:10:00 (24h20m0s) 主な原因: タイムアウト"},
+	}
+	var shares time.Duration
+	for i, tt := range tests {
+		p := periods[i]
+		if p.Date != tt.date || len(p.UnreachableTimes) != tt.failures {
+			t.Errorf("report %d: %s with %d failures, want %s with %d", i, p.Date, len(p.UnreachableTimes), tt.date, tt.failures)
+		}
+		if got := p.formatFailureWindow(); got != tt.window {
+			t.Errorf("%s: failure window %q, want %q", tt.date, got, tt.window)
+		}
+		if got := p.formatPeriodOutages(); got != tt.outages {
+			t.Errorf("%s: outages %q, want %q", tt.date, got, tt.outages)
+		}
+		for _, s := range p.unreachableSpans() {
+			shares += s.duration(p.spanInterval())
+		}
+	}
+	last := periods[2]
+	if len(last.Outages) != 1 || !last.Outages[0].Start.Equal(down) || !last.Outages[0].End.Equal(up) {
+		t.Fatalf("outages %+v, want one from %s to %s", last.Outages, down, up)
+	}
+	if total := time.Duration(last.Outages[0].DurationSeconds * float64(time.Second)); shares != total || total != up.Sub(down) {
+		t.Errorf("daily shares add up to %v, outage lasted %v", shares, total)
+	}
+
+	// The recovery alert names the start two days before
+	var recovery *Event
+	for _, ev := range n.events() {
+		if ev.Kind == EventRecovery {
+			recovery = &ev
+		}
+	}
+	if recovery == nil || !strings.Contains(recovery.Message, "**開始**: 2026-03-10 23:50:00") || !strings.Contains(recovery.Message, "**復旧**: 2026-03-12 00:10:00") {
+		t.Errorf("recovery alert %+v", recovery)
+	}
+}
+
+// TestGatewayFailures attributes to the gateway exactly the failures
+// inside outages whose cause is the gateway, the start inclusive and the
+// end exclusive
+func TestGatewayFailures(t *testing.T) {
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+	p := &reportPeriod{
+		UnreachableTimes: []time.Time{at(5), at(10), at(15), at(19), at(20), at(30), at(35), at(50)},
+		Outages: []OutageRecord{
+			{Start: at(10), End: at(20), Cause: outageCauseGateway},
+			{Start: at(30), End: at(40)},
+			{Start: at(50), End: at(51), Cause: outageCauseGateway},
+		},
+	}
+	if got := p.gatewayFailures(); got != 4 {
+		t.Errorf("gateway failures %d, want 4 (10, 15, 19 and 50)", got)
+	}
+	if got, want := p.gatewayNote(" / "), " / うち起因: ゲートウェイ 4回（回線側 4回）"; got != want {
+		t.Errorf("note %q, want %q", got, want)
+	}
+	p.Outages[0].Cause, p.Outages[2].Cause = "", ""
+	if got := p.gatewayFailures(); got != 0 || p.gatewayNote("\n") != "" {
+		t.Errorf("line outages: %d failures attributed, note %q", got, p.gatewayNote("\n"))
+	}
+}
+
+// TestGatewayOutageAttribution runs an outage with the gateway down too,
+// a blip and an outage of the line alone: the summary puts only the first
+// outage's failures under the gateway and the raw counts keep them all
+func TestGatewayOutageAttribution(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	pm, clock := newTestMonitor(t, map[string]interface{}{"ping_interval": "1s", "failure_threshold": 3, "recovery_threshold": 3}, start)
+	pm.prober = probeFunc(func(host string) (float64, error) {
+		s := clock.now().Sub(start) / time.Second
+		gatewayDown := s >= 10 && s < 30
+		lineDown := s == 40 || s >= 60 && s < 70
+		if gatewayDown || host == pm.targetIP && lineDown {
+			return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+		}
+		return 10, nil
+	})
+	for s := 0; s < 90; s++ {
+		at := start.Add(time.Duration(s) * time.Second)
+		clock.set(at)
+		pm.tick(at)
+	}
+	p := pm.currentPeriod()
+	if len(p.UnreachableTimes) != 31 || len(p.Outages) != 2 {
+		t.Fatalf("%d failures and %d outages, want 31 and 2", len(p.UnreachableTimes), len(p.Outages))
+	}
+	if p.Outages[0].Cause != outageCauseGateway || p.Outages[1].Cause != "" {
+		t.Errorf("causes %q and %q, want the gateway and the line", p.Outages[0].Cause, p.Outages[1].Cause)
+	}
+	if got := p.gatewayFailures(); got != 20 {
+		t.Errorf("gateway failures %d, want 20", got)
+	}
+	snap := pm.siteSnapshot(p)
+	if snap.Stats.Failure != 31 || snap.GatewayFailures != 20 || snap.lineFailures() != 11 {
+		t.Errorf("snapshot failures %d, gateway %d, line %d; want 31, 20, 11", snap.Stats.Failure, snap.GatewayFailures, snap.lineFailures())
+	}
+	if got, want := snap.lineLossPercent(), 11.0/90*100; got != want {
+		t.Errorf("line loss %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// reportFileSchemaVersion is the version of ReportFile. Readers should
+// check it; any change of shape other than a new optional field bumps it.
+const reportFileSchemaVersion = 1
+
+// latestReportFile is the name of the copy of the newest day in report_dir
+const latestReportFile = "latest-report.json"
+
+// ReportFile is the snapshot of one finished day, written to report_dir
+// as report-YYYY-MM-DD.json and latest-report.json for tools that read a
+// file (Home Assistant command-line sensors, Node-RED):
+//
+//	{
+//	  "schema_version": 1,
+//	  "site": "home",
+//	  "date": "2026-10-13",
+//	  "target": "8.8.8.8",
+//	  "local_ip": "192.168.1.10",
+//	  "generated_at": "2026-10-14T00:00:01+09:00",
+//	  "period_start": "2026-10-13T00:00:00+09:00",
+//	  "period_end": "2026-10-14T00:00:00+09:00",
+//	  "stats": {"success": 86390, "failure": 10, "total": 86400, "success_rate": 99.99, "latency": {...}},
+//	  "coverage": {"samples": 86400, "coverage_seconds": 86400, "sufficient": true},
+//	  "quality": {"score": 94, ...},
+//	  "differential": {"pairs": 86380, "avg_ms": 11.2, "p95_ms": 18.4},
+//	  "failure_reasons": {"timeout": 10},
+//	  "first_failure": "2026-10-13T06:12:03+09:00",
+//	  "last_failure": "2026-10-13T06:14:47+09:00",
+//	  "outages": [{"target": "8.8.8.8", "start": "...", "end": "...", "duration_seconds": 12}],
+//	  "gateway_failures": 0,
+//	  "excluded": {"warmup": 5, "simulated": 0, "host_busy": 0},
+//	  "schedule_gaps": 0,
+//	  "schedule_gap_seconds": 0
+//	}
+//
+// Quality, differential, regression, first_failure and last_failure are
+// absent when there is nothing to put in them; failure_reasons is always an
+// object and outages always a list.
+type ReportFile struct {
+	SchemaVersion int       `json:"schema_version"`
+	Site          string    `json:"site"`
+	Date          string    `json:"date"`
+	Target        string    `json:"target"`
+	LocalIP       string    `json:"local_ip"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	// PeriodStart and PeriodEnd bound the day's samples; the end is
+	// exclusive
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+
+	Stats    ProbeStats     `json:"stats"`
+	Coverage ReportCoverage `json:"coverage"`
+	Quality  *QualityScore  `json:"quality,omitempty"`
+	// Differential is the latency added beyond the LAN, with
+	// paired_gateway_probe
+	Differential *LatencyDifferential `json:"differential,omitempty"`
+	// Regression is set when the day was worse than the previous days,
+	// with daily_regression
+	Regression *DailyRegression `json:"regression,omitempty"`
+	// FailureReasons counts the day's failures per reason
+	FailureReasons map[string]int `json:"failure_reasons"`
+	// FirstFailure and LastFailure are the day's first and last failed
+	// samples
+	FirstFailure *time.Time `json:"first_failure,omitempty"`
+	LastFailure  *time.Time `json:"last_failure,omitempty"`
+	// Outages are the outages that ended during the day, simulated ones
+	// included and marked
+	Outages []OutageRecord `json:"outages"`
+	// GatewayFailures are the failures in Stats that fell inside outages
+	// attributed to the gateway
+	GatewayFailures int `json:"gateway_failures"`
+	// Excluded counts the samples left out of Stats
+	Excluded ReportExclusions `json:"excluded"`
+	// ScheduleGaps counts suspensions of the host, lasting
+	// ScheduleGapSeconds in total
+	ScheduleGaps       int     `json:"schedule_gaps"`
+	ScheduleGapSeconds float64 `json:"schedule_gap_seconds"`
+	// Targets are the additional targets, with targets
+	Targets []TargetReport `json:"targets,omitempty"`
+	// Bufferbloat are the day's runs of the bufferbloat test
+	Bufferbloat []BufferbloatResult `json:"bufferbloat,omitempty"`
+}
+
+// ReportExclusions counts the samples a report leaves out
+type ReportExclusions struct {
+	Warmup    int `json:"warmup"`
+	Simulated int `json:"simulated"`
+	HostBusy  int `json:"host_busy"`
+	LoadTest  int `json:"load_test"`
+}
+
+// reportFile builds the file snapshot of a finalized period
+func (pm *PingMonitor) reportFile(p *reportPeriod) ReportFile {
+	f := ReportFile{
+		SchemaVersion:      reportFileSchemaVersion,
+		Site:               pm.config.SiteName,
+		Date:               p.Date,
+		PeriodStart:        p.Start,
+		PeriodEnd:          p.End,
+		Target:             pm.targetIP,
+		LocalIP:            pm.localIP,
+		GeneratedAt:        pm.now(),
+		Stats:              p.probeStats(),
+		Coverage:           p.Coverage,
+		FailureReasons:     p.FailureReasons.toMap(),
+		Outages:            p.Outages,
+		GatewayFailures:    p.gatewayFailures(),
+		Excluded:           ReportExclusions{Warmup: p.WarmupCount, Simulated: p.SimulatedCount, HostBusy: p.HostBusyCount, LoadTest: p.LoadTestCount},
+		ScheduleGaps:       p.ScheduleGaps,
+		ScheduleGapSeconds: p.ScheduleGapTime.Seconds(),
+		Targets:            targetReports(p),
+		Bufferbloat:        p.Bufferbloat,
+	}
+	if q, ok := pm.quality(p); ok {
+		f.Quality = &q
+	}
+	if d, ok := p.differential(); ok {
+		f.Differential = &d
+	}
+	f.Regression = p.Regression
+	f.FirstFailure, f.LastFailure = p.failureWindow()
+	if f.FailureReasons == nil {
+		f.FailureReasons = map[string]int{}
+	}
+	if f.Outages == nil {
+		f.Outages = []OutageRecord{}
+	}
+	return f
+}
+
+// writeReportFiles writes the day's snapshot and replaces latest-report.json,
+// each atomically, so a reader never sees a partial file
+func (pm *PingMonitor) writeReportFiles(p *reportPeriod) error {
+	dir := pm.config.ReportDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pm.reportFile(p), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := writeFileAtomic(filepath.Join(dir, "report-"+p.Date+".json"), data); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, latestReportFile), data)
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// saveReportFiles writes the report files when report_dir is set
+func (pm *PingMonitor) saveReportFiles(p *reportPeriod) {
+	if pm.config.ReportDir == "" {
+		return
+	}
+	if err := pm.writeReportFiles(p); err != nil {
+		fmt.Printf("❌ レポートファイルの書き出しエラー: %v\n", err)
+	}
+}
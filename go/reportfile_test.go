@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReportFileRoundTrip runs two days across midnights with report_dir:
+// each day's file matches the golden file byte for byte, latest-report.json
+// is the newest day, and decoding and encoding a file gives it back
+// unchanged, so the shape cannot change unnoticed
+func TestReportFileRoundTrip(t *testing.T) {
+	setLocal(t, "Asia/Tokyo")
+	quietStdout(t)
+	dir := filepath.Join(t.TempDir(), "reports")
+	start := time.Date(2026, 3, 10, 23, 55, 0, 0, time.Local)
+	pm, clock := newTestMonitor(t, map[string]interface{}{"ping_interval": "10s", "report_dir": dir, "failure_threshold": 3, "recovery_threshold": 3}, start)
+	pm.prober = probeFunc(func(host string) (float64, error) {
+		if s := clock.now().Sub(start) / time.Second; host == pm.targetIP && (s >= 60 && s < 120 || s == 400) {
+			return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+		}
+		return 12.5, nil
+	})
+	end := time.Date(2026, 3, 12, 0, 5, 0, 0, time.Local)
+	for at := start; at.Before(end); at = at.Add(10 * time.Second) {
+		clock.set(at)
+		pm.tick(at)
+		// Hold the clock at midnight until the day is written, so its
+		// generated_at is the rollover
+		if at.Hour() == 0 && at.Minute() == 0 && at.Second() == 0 {
+			path := filepath.Join(dir, "report-"+at.AddDate(0, 0, -1).Format(reportDateLayout)+".json")
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+				if _, err := os.Stat(path); err == nil {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%s not written", path)
+				}
+			}
+		}
+	}
+	pm.reports.shutdown()
+	pm.dispatcher.flush()
+
+	read := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	first, second := read("report-2026-03-10.json"), read("report-2026-03-11.json")
+	checkGolden(t, "report_file.json", string(first))
+	if latest := read(latestReportFile); !bytes.Equal(latest, second) {
+		t.Errorf("%s is not the newest day:\n%s", latestReportFile, latest)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp*")); len(matches) > 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+
+	for _, data := range [][]byte{first, second} {
+		var f ReportFile
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		again, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again = append(again, '\n'); !bytes.Equal(again, data) {
+			t.Errorf("round trip changed %s:\n%s\nwant\n%s", f.Date, again, data)
+		}
+		if f.SchemaVersion != reportFileSchemaVersion || f.Outages == nil || f.FailureReasons == nil {
+			t.Errorf("%s: version %d, outages %v, reasons %v", f.Date, f.SchemaVersion, f.Outages, f.FailureReasons)
+		}
+	}
+}
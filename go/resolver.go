@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoAddress is returned when a hostname target has never resolved
+var errNoAddress = errors.New("target address unknown")
+
+// lookupHost resolves hostname targets; tests replace it
+var lookupHost = net.LookupHost
+
+// addressChange records a change of the address a hostname resolves to
+type addressChange struct {
+	At   time.Time
+	From string
+	To   string
+}
+
+// targetResolver resolves a hostname target periodically instead of once,
+// so a dynamic DNS name keeps being followed. Go's resolver does not expose
+// record TTLs, so the re-resolution interval is configured instead.
+type targetResolver struct {
+	host     string
+	interval time.Duration
+	lookup   func(host string) ([]string, error)
+	addr     string
+	next     time.Time
+}
+
+// newTargetResolver creates a resolver; IP address targets never re-resolve
+func newTargetResolver(host string, interval time.Duration) *targetResolver {
+	r := &targetResolver{host: host, interval: interval, lookup: lookupHost}
+	if literalAddress(host) {
+		r.addr = host
+	}
+	return r
+}
+
+// literalAddress tells whether target is an IP address, IPv6 ones with an
+// optional zone
+func literalAddress(target string) bool {
+	_, err := netip.ParseAddr(target)
+	return err == nil
+}
+
+// nameNotFound tells whether err is DNS answering that a name does not
+// exist, as opposed to DNS not answering
+func nameNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// validTarget tells whether target is an IP address, with an optional IPv6
+// zone, or a syntactically valid hostname. Whether the name resolves is
+// checked at startup (see newPingMonitor).
+func validTarget(target string) bool {
+	if literalAddress(target) {
+		return true
+	}
+	name := strings.TrimSuffix(target, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	// "8.8.8" and the like are mistyped addresses rather than names
+	_, err := strconv.Atoi(labels[len(labels)-1])
+	return err != nil
+}
+
+// prime resolves a hostname target once ahead of the first probe. On a
+// failure the next resolve tries again instead of waiting for the interval.
+func (r *targetResolver) prime(now time.Time) error {
+	if _, _, err := r.resolve(now); err != nil {
+		r.next = time.Time{}
+		return err
+	}
+	return nil
+}
+
+// static reports whether the target is a literal address
+func (r *targetResolver) static() bool {
+	return literalAddress(r.host)
+}
+
+// resolve returns the address to probe at now, re-resolving when due. On a
+// lookup failure the cached address is kept and the error returned so the
+// caller can record it separately from probe failures.
+func (r *targetResolver) resolve(now time.Time) (string, *addressChange, error) {
+	if r.static() || now.Before(r.next) {
+		return r.addr, nil, nil
+	}
+	r.next = now.Add(r.interval)
+
+	addrs, err := r.lookup(r.host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("%s: no addresses", r.host)
+	}
+	if err != nil {
+		return r.addr, nil, err
+	}
+	// Keep the current address while it is still published, so round-robin
+	// records do not look like changes
+	for _, a := range addrs {
+		if a == r.addr {
+			return r.addr, nil, nil
+		}
+	}
+	chosen := preferIPv4(addrs)
+	var change *addressChange
+	if r.addr != "" {
+		change = &addressChange{At: now, From: r.addr, To: chosen}
+	}
+	r.addr = chosen
+	return r.addr, change, nil
+}
+
+// preferIPv4 returns the first IPv4 address, or the first address
+func preferIPv4(addrs []string) string {
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+			return a
+		}
+	}
+	return addrs[0]
+}
+
+// formatAddressChanges lists address changes for reports
+func formatAddressChanges(changes []addressChange) string {
+	var lines []string
+	for i, c := range changes {
+		if i >= 10 {
+			lines = append(lines, fmt.Sprintf("... 他%d件", len(changes)-10))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s %s → %s", c.At.Format("15:04:05"), c.From, c.To))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// notifyAddressChange alerts that the target now resolves elsewhere
+func (pm *PingMonitor) notifyAddressChange(c *addressChange) {
+	fmt.Printf("🔁 %s のIPアドレスが変わりました: %s → %s\n", pm.targetIP, c.From, c.To)
+	pm.dispatcher.dispatch(Event{
+		Kind:     EventAddressChange,
+		Severity: SeverityWarn,
+		Time:     c.At,
+		Title:    "🔁 監視対象のIPアドレス変更",
+		Message:  fmt.Sprintf("**対象**: %s\n**変更**: %s → %s\n**時刻**: %s", pm.targetIP, c.From, c.To, c.At.Format("2006-01-02 15:04:05")),
+		Data: map[string]interface{}{
+			"target": pm.targetIP,
+			"from":   c.From,
+			"to":     c.To,
+		},
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"fe80::1%eth0", true},
+		{"fe80::1%25", true},
+		{"vpn.example.com", true},
+		{"vpn.example.com.", true},
+		{"my_host.lan", true},
+		{"localhost", true},
+		{"", false},
+		{"8.8.8", false},
+		{"8.8.8.8%eth0", false},
+		{"fe80::1%", false},
+		{"8.8.8.8:53", false},
+		{"[2001:db8::1]", false},
+		{"http://example.com", false},
+		{"-vpn.example.com", false},
+		{"vpn..example.com", false},
+		{strings.Repeat("a", 64) + ".example.com", false},
+		{strings.Repeat("a.", 127) + "com", false},
+	}
+	for _, tt := range tests {
+		if got := validTarget(tt.target); got != tt.want {
+			t.Errorf("validTarget(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestTargetResolver(t *testing.T) {
+	// Zoned addresses are literals as validTarget takes them
+	for _, host := range []string{"8.8.8.8", "fe80::1%eth0"} {
+		r := newTargetResolver(host, time.Minute)
+		r.lookup = func(string) ([]string, error) { t.Fatalf("%s looked up", host); return nil, nil }
+		if addr, change, err := r.resolve(time.Now()); !r.static() || addr != host || change != nil || err != nil {
+			t.Errorf("%s: %q, %v, %v", host, addr, change, err)
+		}
+	}
+
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	var answer []string
+	var answerErr error
+	lookups := 0
+	r := newTargetResolver("vpn.example.com", time.Minute)
+	r.lookup = func(string) ([]string, error) {
+		lookups++
+		return answer, answerErr
+	}
+	steps := []struct {
+		at     time.Duration
+		answer []string
+		err    error
+		want   string
+		change string
+		fails  bool
+	}{
+		{0, []string{"2001:db8::5", "203.0.113.5"}, nil, "203.0.113.5", "", false},
+		// Not due yet
+		{30 * time.Second, []string{"203.0.113.9"}, nil, "203.0.113.5", "", false},
+		// Still published among others
+		{time.Minute, []string{"203.0.113.7", "203.0.113.5"}, nil, "203.0.113.5", "", false},
+		{2 * time.Minute, []string{"203.0.113.9"}, nil, "203.0.113.9", "203.0.113.5 -> 203.0.113.9", false},
+		// A failed lookup keeps the address
+		{3 * time.Minute, nil, errors.New("timeout"), "203.0.113.9", "", true},
+		{4 * time.Minute, nil, nil, "203.0.113.9", "", true},
+	}
+	for i, s := range steps {
+		answer, answerErr = s.answer, s.err
+		addr, change, err := r.resolve(base.Add(s.at))
+		got := ""
+		if change != nil {
+			got = change.From + " -> " + change.To
+		}
+		if addr != s.want || got != s.change || (err != nil) != s.fails {
+			t.Errorf("step %d: %q, change %q, %v", i, addr, got, err)
+		}
+	}
+	if lookups != 5 {
+		t.Errorf("%d lookups, want 5", lookups)
+	}
+}
+
+// TestStartupResolution checks that a name DNS says does not exist stops
+// the monitor from starting, while DNS not answering yet does not
+func TestStartupResolution(t *testing.T) {
+	quietStdout(t)
+	saved := lookupHost
+	t.Cleanup(func() { lookupHost = saved })
+	cfg := `{"target": "vpn.example.com", "gateway": "` + scenarioGateway + `"}`
+
+	lookupHost = func(host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	c, err := readTestConfig(t, cfg, ConfigOverrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newPingMonitor(c, ""); err == nil || !strings.Contains(err.Error(), "vpn.example.com") {
+		t.Errorf("unknown name: %v", err)
+	}
+
+	lookupHost = func(host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+	}
+	pm, _ := newTestMonitor(t, map[string]interface{}{"target": "vpn.example.com"}, time.Now())
+	if pm.targetAddr != "" {
+		t.Errorf("address %q without an answer", pm.targetAddr)
+	}
+
+	lookupHost = func(string) ([]string, error) { return []string{"203.0.113.5"}, nil }
+	pm, _ = newTestMonitor(t, map[string]interface{}{"target": "vpn.example.com"}, time.Now())
+	if pm.targetAddr != "203.0.113.5" {
+		t.Errorf("address %q, want 203.0.113.5", pm.targetAddr)
+	}
+}
+
+// TestZonedTarget probes a zoned IPv6 target as it was configured
+func TestZonedTarget(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	pm, clock := newTestMonitor(t, map[string]interface{}{"target": "fe80::1%eth0"}, start)
+	var probed []string
+	pm.prober = probeFunc(func(host string) (float64, error) {
+		probed = append(probed, host)
+		return 1, nil
+	})
+	clock.set(start)
+	pm.tick(start)
+	if len(probed) == 0 || probed[0] != "fe80::1%eth0" {
+		t.Errorf("probed %v", probed)
+	}
+}
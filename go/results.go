@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ResultRecord is one probe result as stored in the JSONL results file
+type ResultRecord struct {
+	// Timestamp is when the probe was sent; CompletedAt when its answer
+	// or timeout came back
+	Timestamp    time.Time `json:"timestamp"`
+	CompletedAt  time.Time `json:"completed_at,omitempty"`
+	Target       string    `json:"target"`
+	Success      bool      `json:"success"`
+	ResponseTime float64   `json:"response_time_ms,omitempty"`
+	Simulated    bool      `json:"simulated,omitempty"`
+	Warmup       bool      `json:"warmup,omitempty"`
+	// LoadTest marks probes sent during the bufferbloat test's load
+	LoadTest bool `json:"load_test,omitempty"`
+	// Address is set when the probed address differs from the target
+	Address string `json:"address,omitempty"`
+	// Reason classifies failures (timeout, unreachable, dns, ...)
+	Reason string `json:"reason,omitempty"`
+	// GatewayResponseTime is the gateway's response time in the same cycle
+	// (paired_gateway_probe)
+	GatewayResponseTime float64 `json:"gateway_response_time_ms,omitempty"`
+	// Gateway is the gateway diagnostic taken with a failure
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// excluded reports whether the probe is kept out of the statistics, as
+// taken in the warm-up or under the bufferbloat test's load
+func (rec ResultRecord) excluded() bool {
+	return rec.Warmup || rec.LoadTest
+}
+
+// resultsWriter appends probe results to a JSONL file
+type resultsWriter struct {
+	mutex sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+}
+
+// openResultsWriter opens path for appending, creating it if needed
+func openResultsWriter(path string) (*resultsWriter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("結果ファイル %s を開けません: %v", path, err)
+	}
+	return &resultsWriter{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// write appends a single record
+func (w *resultsWriter) write(rec ResultRecord) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.enc.Encode(rec)
+}
+
+// readResults loads all records from a JSONL results file
+func readResults(path string) ([]ResultRecord, error) {
+	var records []ResultRecord
+	err := scanResults(path, func(rec ResultRecord) {
+		records = append(records, rec)
+	})
+	return records, err
+}
+
+// statsFromRecords computes ProbeStats over records, skipping simulated,
+// warm-up and load-test ones
+func statsFromRecords(records []ResultRecord) ProbeStats {
+	var times []float64
+	failures := 0
+	for _, rec := range records {
+		if rec.Simulated || rec.excluded() || rec.Reason == string(reasonMeasurement) {
+			continue
+		}
+		if rec.Success {
+			times = append(times, rec.ResponseTime)
+		} else {
+			failures++
+		}
+	}
+	return computeProbeStats(times, failures)
+}
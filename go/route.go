@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// routeHops is the number of leading hops watched (TTL 1 and 2)
+const routeHops = 2
+
+// routeConfirmSamples is how many identical observations in a row a new
+// path needs before it replaces the current one
+const routeConfirmSamples = 3
+
+// routeFlapWindow is how long a previously confirmed path is remembered;
+// switching back to one of them within the window counts as load-balancer
+// flapping instead of a route change
+const routeFlapWindow = time.Hour
+
+// hopTimeout is the reply timeout of one TTL-limited probe
+const hopTimeout = time.Second
+
+// unixHopFromRe matches the responder in C-locale ping output, both
+// "From 10.0.0.1 icmp_seq=1 Time to live exceeded" and
+// "64 bytes from 8.8.8.8: icmp_seq=1"
+var unixHopFromRe = regexp.MustCompile(`[Ff]rom ([0-9A-Fa-f.:]+)`)
+
+// windowsHopFromRe matches the responder in Japanese Windows output
+// ("10.0.0.1 からの応答: 転送中に TTL が期限切れになりました。")
+var windowsHopFromRe = regexp.MustCompile(`([0-9A-Fa-f.:]+) からの応答`)
+
+// parseHopResponder extracts the responding address from ping output
+func parseHopResponder(output string, windows bool) string {
+	re := unixHopFromRe
+	if windows {
+		re = windowsHopFromRe
+	}
+	if match := re.FindStringSubmatch(output); len(match) > 1 {
+		return strings.TrimSuffix(match[1], ":")
+	}
+	return ""
+}
+
+// probeHop sends one ping with the given TTL and returns the address that
+// answered (a router reporting "time to live exceeded", or the target), or
+// "" when nothing answered
+func probeHop(addr string, ttl int) string {
+	var args []string
+	switch runtime.GOOS {
+	case "windows":
+		args = []string{"-n", "1", "-i", strconv.Itoa(ttl), "-w", strconv.Itoa(int(hopTimeout / time.Millisecond)), addr}
+	case "darwin", "freebsd":
+		args = []string{"-n", "-c", "1", "-m", strconv.Itoa(ttl), "-W", strconv.Itoa(int(hopTimeout / time.Millisecond)), addr}
+	default:
+		args = []string{"-n", "-c", "1", "-t", strconv.Itoa(ttl), "-W", strconv.Itoa(int(hopTimeout / time.Second)), addr}
+	}
+	// TTL expiry makes ping exit non-zero, so the output is read regardless
+	output, _ := runCommand(hopTimeout, "ping", args...)
+	return parseHopResponder(string(output), runtime.GOOS == "windows")
+}
+
+// routeChange records a confirmed change of the leading hops
+type routeChange struct {
+	At   time.Time
+	From string
+	To   string
+}
+
+// routeTracker confirms changes of the first hops with flap suppression.
+// Paths are "hop1 > hop2" with "*" for a silent hop.
+type routeTracker struct {
+	mutex     sync.Mutex
+	current   string
+	candidate string
+	seen      int
+	// known maps previously confirmed paths to when they were last current
+	known map[string]time.Time
+	flaps int
+}
+
+// newRouteTracker creates a tracker without a confirmed path
+func newRouteTracker() *routeTracker {
+	return &routeTracker{known: make(map[string]time.Time)}
+}
+
+// formatRoute joins hop responders into a path
+func formatRoute(hops []string) string {
+	parts := make([]string, len(hops))
+	for i, h := range hops {
+		parts[i] = h
+		if h == "" {
+			parts[i] = "*"
+		}
+	}
+	return strings.Join(parts, " > ")
+}
+
+// observe feeds one path observation and returns a confirmed change, if
+// any. Observations where no hop answered carry no information and are
+// ignored.
+func (t *routeTracker) observe(at time.Time, hops []string) *routeChange {
+	answered := false
+	for _, h := range hops {
+		answered = answered || h != ""
+	}
+	if !answered {
+		return nil
+	}
+	path := formatRoute(hops)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if path == t.current {
+		t.candidate, t.seen = "", 0
+		t.known[path] = at
+		return nil
+	}
+	if path != t.candidate {
+		t.candidate, t.seen = path, 0
+	}
+	t.seen++
+	// The first path is confirmed at once; later ones need a stable streak
+	if t.current != "" && t.seen < routeConfirmSamples {
+		return nil
+	}
+
+	previous := t.current
+	t.current, t.candidate, t.seen = path, "", 0
+	if previous != "" {
+		t.known[previous] = at
+	}
+	for p, last := range t.known {
+		if at.Sub(last) > routeFlapWindow {
+			delete(t.known, p)
+		}
+	}
+	_, flapping := t.known[path]
+	t.known[path] = at
+	if previous == "" {
+		return nil
+	}
+	if flapping {
+		t.flaps++
+		return nil
+	}
+	return &routeChange{At: at, From: previous, To: path}
+}
+
+// path returns the confirmed path, or "" before the first observation
+func (t *routeTracker) path() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.current
+}
+
+// takeFlaps returns and resets the number of suppressed switches
+func (t *routeTracker) takeFlaps() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	n := t.flaps
+	t.flaps = 0
+	return n
+}
+
+// routeLoop probes the leading hops every route_probe_interval
+func (pm *PingMonitor) routeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pm.probeRoute(time.Now())
+		select {
+		case <-pm.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeRoute probes TTL 1 and 2 towards the current target address and
+// records a confirmed change
+func (pm *PingMonitor) probeRoute(now time.Time) {
+	pm.mutex.RLock()
+	addr := pm.targetAddr
+	pm.mutex.RUnlock()
+	if addr == "" {
+		return
+	}
+	hops := make([]string, routeHops)
+	for i := range hops {
+		hops[i] = pm.hopProbe(addr, i+1)
+	}
+	change := pm.route.observe(now, hops)
+	if change == nil {
+		return
+	}
+	fmt.Printf("🛤️ 経路の先頭が変わりました: %s → %s\n", change.From, change.To)
+	pm.mutex.Lock()
+	pm.routeChanges = append(pm.routeChanges, *change)
+	pm.mutex.Unlock()
+}
+
+// formatRouteChanges lists route changes for reports, naming paths with
+// label
+func formatRouteChanges(changes []routeChange, flaps int, label func(path string) string) string {
+	var lines []string
+	for i, c := range changes {
+		if i >= 10 {
+			lines = append(lines, fmt.Sprintf("... 他%d件", len(changes)-10))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s %s → %s", c.At.Format("15:04:05"), label(c.From), label(c.To)))
+	}
+	if flaps > 0 {
+		lines = append(lines, fmt.Sprintf("負荷分散と思われる切り替え: %d回", flaps))
+	}
+	return strings.Join(lines, "\n")
+}
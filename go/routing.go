@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// eventKinds lists every EventKind, in the order of the README
+var eventKinds = [...]EventKind{
+	EventOutage, EventRecovery, EventReport, EventAddressChange, EventLatency,
+	EventLatencyRecovery, EventLossTrend, EventRegression, EventStartup, EventOps,
+	EventConnectionChange,
+}
+
+// NotifierRouting selects the events one notifier receives. It is embedded
+// in the config of each notifier, and given as discord_routing for Discord.
+type NotifierRouting struct {
+	// MinSeverity drops events below it (info/warn/critical; "" is info)
+	MinSeverity string `json:"min_severity"`
+	// Events lists the kinds passed on; empty passes every kind
+	Events []string `json:"events"`
+}
+
+// validate checks the routing of the config section name
+func (r NotifierRouting) validate(section string) error {
+	if _, err := parseSeverity(r.MinSeverity); err != nil {
+		return fmt.Errorf("%s.min_severity の値が正しくありません: %q (info/warn/critical)", section, r.MinSeverity)
+	}
+	for _, kind := range r.Events {
+		if !validEventKind(kind) {
+			names := make([]string, len(eventKinds))
+			for i, k := range eventKinds {
+				names[i] = string(k)
+			}
+			return fmt.Errorf("%s.events の値が正しくありません: %q (%s)", section, kind, strings.Join(names, " / "))
+		}
+	}
+	return nil
+}
+
+// validEventKind reports whether s names an event kind
+func validEventKind(s string) bool {
+	for _, k := range eventKinds {
+		if string(k) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// allows tells whether ev passes the routing, and if not, why
+func (r NotifierRouting) allows(ev Event) (bool, string) {
+	if min, _ := parseSeverity(r.MinSeverity); ev.Severity < min {
+		return false, "min_severity=" + min.String()
+	}
+	if len(r.Events) == 0 {
+		return true, ""
+	}
+	for _, kind := range r.Events {
+		if EventKind(kind) == ev.Kind {
+			return true, ""
+		}
+	}
+	return false, "events にない"
+}
+
+// routedNotifier is implemented by notifiers with a configured routing
+type routedNotifier interface {
+	routing() NotifierRouting
+}
+
+// route decides whether n receives ev: its routing first, then its own
+// eventFilter. The reason is for the notify_debug line.
+func route(n Notifier, ev Event) (bool, string) {
+	if r, ok := n.(routedNotifier); ok {
+		if ok, why := r.routing().allows(ev); !ok {
+			return false, why
+		}
+	}
+	if f, ok := n.(eventFilter); ok && !f.accepts(ev) {
+		return false, "通知先の既定"
+	}
+	return true, ""
+}
+
+// logRoute prints, for notify_debug, where ev goes, e.g.
+// "🔀 通知 #12 outage/critical → Discord: 送信, Twilio: 対象外（min_severity=critical）"
+func (d *dispatcher) logRoute(ev Event) {
+	parts := make([]string, 0, len(d.notifiers))
+	for _, n := range d.notifiers {
+		if ok, why := route(n, ev); ok {
+			parts = append(parts, n.Name()+": 送信")
+		} else {
+			parts = append(parts, fmt.Sprintf("%s: 対象外（%s）", n.Name(), why))
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "通知先なし")
+	}
+	fmt.Printf("🔀 通知 #%d %s/%s → %s\n", ev.ID, ev.Kind, ev.Severity, strings.Join(parts, ", "))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var severities = [...]Severity{SeverityInfo, SeverityWarn, SeverityCritical}
+
+// TestRoute walks every severity and event kind through each notifier type
+// with the routings of the README's example: Discord gets everything but the
+// report it posts itself, Twilio only critical outages, Gotify only the
+// daily report, and Bark its alerts from warn up
+func TestRoute(t *testing.T) {
+	pm, _ := newTestMonitor(t, nil, time.Now())
+	gotify, err := newGotifyNotifier(GotifyConfig{URL: "http://gotify.invalid", NotifierRouting: NotifierRouting{Events: []string{"report"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		notifier Notifier
+		want     func(Severity, EventKind) bool
+	}{
+		{&discordNotifier{pm: pm}, func(s Severity, k EventKind) bool { return k != EventReport }},
+		{newTwilioNotifier(TwilioConfig{NotifierRouting: NotifierRouting{MinSeverity: "critical", Events: []string{"outage"}}}),
+			func(s Severity, k EventKind) bool { return s == SeverityCritical && k == EventOutage }},
+		{gotify, func(s Severity, k EventKind) bool { return k == EventReport }},
+		{newBarkNotifier(BarkConfig{NotifierRouting: NotifierRouting{MinSeverity: "warn"}}),
+			func(s Severity, k EventKind) bool { return s >= SeverityWarn && k != EventReport }},
+		// The report is opt-in for Bark, and the routing still applies
+		{newBarkNotifier(BarkConfig{IncludeDailyReport: true, NotifierRouting: NotifierRouting{Events: []string{"report", "recovery"}}}),
+			func(s Severity, k EventKind) bool { return k == EventReport || k == EventRecovery }},
+		// Twilio without routing keeps its default of everything but reports
+		{newTwilioNotifier(TwilioConfig{}), func(s Severity, k EventKind) bool { return k != EventReport }},
+	}
+	for i, tt := range tests {
+		for _, s := range severities {
+			for _, k := range eventKinds {
+				ok, why := route(tt.notifier, Event{Kind: k, Severity: s})
+				if want := tt.want(s, k); ok != want {
+					t.Errorf("%d %s: %s/%s routed %v (%s), want %v", i, tt.notifier.Name(), k, s, ok, why, want)
+				}
+				if ok != (why == "") {
+					t.Errorf("%d %s: %s/%s routed %v with reason %q", i, tt.notifier.Name(), k, s, ok, why)
+				}
+			}
+		}
+	}
+}
+
+func TestNotifierRoutingAllows(t *testing.T) {
+	tests := []struct {
+		routing NotifierRouting
+		ev      Event
+		ok      bool
+		why     string
+	}{
+		{NotifierRouting{}, Event{Kind: EventOps, Severity: SeverityInfo}, true, ""},
+		{NotifierRouting{MinSeverity: "warn"}, Event{Kind: EventOutage, Severity: SeverityInfo}, false, "min_severity=warn"},
+		{NotifierRouting{MinSeverity: "warn"}, Event{Kind: EventOutage, Severity: SeverityWarn}, true, ""},
+		{NotifierRouting{Events: []string{"outage"}}, Event{Kind: EventRecovery, Severity: SeverityCritical}, false, "events にない"},
+		// The severity is checked first
+		{NotifierRouting{MinSeverity: "critical", Events: []string{"outage"}}, Event{Kind: EventRecovery, Severity: SeverityInfo}, false, "min_severity=critical"},
+	}
+	for _, tt := range tests {
+		if ok, why := tt.routing.allows(tt.ev); ok != tt.ok || why != tt.why {
+			t.Errorf("%+v %s/%s: %v %q, want %v %q", tt.routing, tt.ev.Kind, tt.ev.Severity, ok, why, tt.ok, tt.why)
+		}
+	}
+	if err := (NotifierRouting{MinSeverity: "loud"}).validate("gotify"); err == nil {
+		t.Error("unknown min_severity accepted")
+	}
+	if err := (NotifierRouting{Events: []string{"outages"}}).validate("gotify"); err == nil {
+		t.Error("unknown event kind accepted")
+	}
+}
+
+// routedFake is a fake notifier with a routing
+type routedFake struct {
+	fakeNotifier
+	r NotifierRouting
+}
+
+func (n *routedFake) routing() NotifierRouting { return n.r }
+
+// TestDispatcherRouting dispatches the whole matrix and checks what each
+// notifier received, in order
+func TestDispatcherRouting(t *testing.T) {
+	quietStdout(t)
+	all := &routedFake{fakeNotifier: fakeNotifier{name: "all"}}
+	critical := &routedFake{fakeNotifier: fakeNotifier{name: "critical"}, r: NotifierRouting{MinSeverity: "critical"}}
+	reports := &routedFake{fakeNotifier: fakeNotifier{name: "reports"}, r: NotifierRouting{Events: []string{"report", "daily_regression"}}}
+	d := newDispatcher([]Notifier{all, critical, reports}, nil, nil, textStyle{}, time.Now)
+	d.debug = true
+	var wantAll, wantCritical, wantReports []string
+	for _, s := range severities {
+		for _, k := range eventKinds {
+			title := string(k) + "/" + s.String()
+			d.dispatch(Event{Kind: k, Severity: s, Time: time.Now(), Title: title})
+			wantAll = append(wantAll, title)
+			if s == SeverityCritical {
+				wantCritical = append(wantCritical, title)
+			}
+			if k == EventReport || k == EventRegression {
+				wantReports = append(wantReports, title)
+			}
+		}
+	}
+	d.close()
+	for _, tt := range []struct {
+		n    *routedFake
+		want []string
+	}{{all, wantAll}, {critical, wantCritical}, {reports, wantReports}} {
+		var got []string
+		for _, ev := range tt.n.events() {
+			got = append(got, ev.Title)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s received %v, want %v", tt.n.name, got, tt.want)
+		}
+	}
+}
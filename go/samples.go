@@ -0,0 +1,319 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Latency histogram geometry: bucket i holds the values up to
+// histMinMs×histGrowth^i, so a percentile read from it is within 1% of the
+// exact one. Values below histMinMs, including negative differentials,
+// share the first bucket.
+const (
+	histMinMs   = 0.01
+	histGrowth  = 1.02
+	histBuckets = 860
+)
+
+// recentSampleCount is how many raw results /debug/state keeps
+const recentSampleCount = 600
+
+// histBucket returns the bucket of ms
+func histBucket(ms float64) int {
+	if ms <= histMinMs {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(ms/histMinMs) / math.Log(histGrowth)))
+	return min(i, histBuckets-1)
+}
+
+// histMid returns the geometric middle of bucket i
+func histMid(i int) float64 {
+	if i == 0 {
+		return histMinMs
+	}
+	return histMinMs * math.Pow(histGrowth, float64(i)-0.5)
+}
+
+// latencyAccumulator summarizes response times as they arrive, in place of
+// keeping them: the count, sum, minimum, maximum and jitter exactly, the
+// median, p95 and MAD from a fixed-size histogram
+type latencyAccumulator struct {
+	count    int
+	sum      float64
+	min, max float64
+	// buckets is allocated with the first value
+	buckets []uint32
+	// first and last are the first and latest values, for the jitter and
+	// for merging
+	first, last float64
+	jitterSum   float64
+}
+
+// add records one response time
+func (a *latencyAccumulator) add(ms float64) {
+	if a.buckets == nil {
+		a.buckets = make([]uint32, histBuckets)
+	}
+	if a.count == 0 {
+		a.min, a.max, a.first = ms, ms, ms
+	} else {
+		a.min, a.max = min(a.min, ms), max(a.max, ms)
+		a.jitterSum += math.Abs(ms - a.last)
+	}
+	a.last = ms
+	a.count++
+	a.sum += ms
+	a.buckets[histBucket(ms)]++
+}
+
+// merge adds b, whose values followed those of a
+func (a *latencyAccumulator) merge(b *latencyAccumulator) {
+	if b.count == 0 {
+		return
+	}
+	if a.count == 0 {
+		*a = b.clone()
+		return
+	}
+	for i, n := range b.buckets {
+		a.buckets[i] += n
+	}
+	a.jitterSum += b.jitterSum + math.Abs(b.first-a.last)
+	a.min, a.max = min(a.min, b.min), max(a.max, b.max)
+	a.count += b.count
+	a.sum += b.sum
+	a.last = b.last
+}
+
+// clone returns a copy that does not share the histogram
+func (a *latencyAccumulator) clone() latencyAccumulator {
+	c := *a
+	c.buckets = slices.Clone(a.buckets)
+	return c
+}
+
+// valueAt returns the k-th smallest value: exact at both ends, otherwise
+// the middle of its bucket within the range seen
+func (a *latencyAccumulator) valueAt(k int) float64 {
+	switch k {
+	case 0:
+		return a.min
+	case a.count - 1:
+		return a.max
+	}
+	seen := 0
+	for i, n := range a.buckets {
+		seen += int(n)
+		if k < seen {
+			return min(max(histMid(i), a.min), a.max)
+		}
+	}
+	return a.max
+}
+
+// percentile returns the p-th percentile, interpolating between closest
+// ranks like percentile does over sorted values
+func (a *latencyAccumulator) percentile(p float64) float64 {
+	if a.count == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(a.count-1)
+	lo, hi := int(math.Floor(rank)), int(math.Ceil(rank))
+	v := a.valueAt(lo)
+	if lo == hi {
+		return v
+	}
+	return v + (a.valueAt(hi)-v)*(rank-float64(lo))
+}
+
+// mad returns the median absolute deviation around median, each value
+// standing at the middle of its bucket
+func (a *latencyAccumulator) mad(median float64) float64 {
+	type deviation struct {
+		d float64
+		n int
+	}
+	var devs []deviation
+	for i, n := range a.buckets {
+		if n > 0 {
+			v := min(max(histMid(i), a.min), a.max)
+			devs = append(devs, deviation{math.Abs(v - median), int(n)})
+		}
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i].d < devs[j].d })
+	at := func(k int) float64 {
+		seen := 0
+		for _, dv := range devs {
+			seen += dv.n
+			if k < seen {
+				return dv.d
+			}
+		}
+		return 0
+	}
+	rank := 0.5 * float64(a.count-1)
+	lo, hi := int(math.Floor(rank)), int(math.Ceil(rank))
+	return at(lo) + (at(hi)-at(lo))*(rank-float64(lo))
+}
+
+// stats returns the summary statistics; all fields are zero without
+// values
+func (a *latencyAccumulator) stats() LatencyStats {
+	if a.count == 0 {
+		return LatencyStats{}
+	}
+	median := a.percentile(50)
+	return LatencyStats{
+		Count:  a.count,
+		Avg:    a.sum / float64(a.count),
+		Min:    a.min,
+		Max:    a.max,
+		Median: median,
+		P95:    a.percentile(95),
+		MAD:    a.mad(median),
+	}
+}
+
+// probeStats builds ProbeStats from the accumulated successes and a
+// failure count
+func (a *latencyAccumulator) probeStats(failures int) ProbeStats {
+	s := ProbeStats{
+		Success: a.count,
+		Failure: failures,
+		Total:   a.count + failures,
+		Latency: a.stats(),
+	}
+	if s.Total > 0 {
+		s.SuccessRate = float64(s.Success) / float64(s.Total) * 100
+	}
+	return s
+}
+
+// jitter returns the mean absolute difference between consecutive values
+func (a *latencyAccumulator) jitter() float64 {
+	if a.count < 2 {
+		return 0
+	}
+	return a.jitterSum / float64(a.count-1)
+}
+
+// hourSamples is one hour of a period's successes
+type hourSamples struct {
+	latency latencyAccumulator
+	// rx and tx sum the interface throughput over ifaceSamples
+	rx, tx       float64
+	ifaceSamples int
+}
+
+// periodSamples summarizes the successes of a reporting period as they
+// arrive, so memory does not grow with the interval. Excluded samples
+// (warm-up, load test) only count as successes; the others feed the
+// period's and each hour's latency, the LAN differential, the span for the
+// coverage and, with the failures, the worst minutes.
+type periodSamples struct {
+	// successes counts every success, excluded ones included
+	successes    int
+	latency      latencyAccumulator
+	hours        [24]hourSamples
+	differential latencyAccumulator
+	// first and last are the earliest and latest included successes
+	first, last time.Time
+	worst       worstTracker
+}
+
+// newPeriodSamples starts a period sampled every interval; 0 for unknown
+func newPeriodSamples(interval time.Duration) *periodSamples {
+	need := 1
+	if interval > 0 {
+		need = max(1, int(worstWindow/interval)/2)
+	}
+	return &periodSamples{worst: worstTracker{need: need}}
+}
+
+// add records a success
+func (s *periodSamples) add(r PingResult) {
+	s.successes++
+	if r.excluded() {
+		return
+	}
+	s.latency.add(r.ResponseTime)
+	h := &s.hours[r.Timestamp.Hour()]
+	h.latency.add(r.ResponseTime)
+	if r.Throughput != nil {
+		h.rx += r.Throughput.RxBps
+		h.tx += r.Throughput.TxBps
+		h.ifaceSamples++
+	}
+	if r.Paired {
+		s.differential.add(r.ResponseTime - r.GatewayResponseTime)
+	}
+	if s.first.IsZero() || r.Timestamp.Before(s.first) {
+		s.first = r.Timestamp
+	}
+	if r.Timestamp.After(s.last) {
+		s.last = r.Timestamp
+	}
+	s.worst.add(windowSample{at: r.Timestamp, ok: true, ms: r.ResponseTime})
+}
+
+// addFailure records a failure counted in the statistics
+func (s *periodSamples) addFailure(t time.Time) {
+	s.worst.add(windowSample{at: t})
+}
+
+// clone returns a copy sharing nothing with s
+func (s *periodSamples) clone() *periodSamples {
+	c := *s
+	c.latency = s.latency.clone()
+	c.differential = s.differential.clone()
+	for i := range c.hours {
+		c.hours[i].latency = s.hours[i].latency.clone()
+	}
+	c.worst.pending = slices.Clone(s.worst.pending)
+	c.worst.times = nil
+	return &c
+}
+
+// replaySamples feeds successes and failures to s in time order
+func replaySamples(s *periodSamples, results []PingResult, failures []time.Time) {
+	i, j := 0, 0
+	for i < len(results) || j < len(failures) {
+		if j == len(failures) || (i < len(results) && !failures[j].Before(results[i].Timestamp)) {
+			s.add(results[i])
+			i++
+		} else {
+			s.addFailure(failures[j])
+			j++
+		}
+	}
+}
+
+// recentResults keeps the latest results in a ring for /debug/state
+type recentResults struct {
+	mutex sync.Mutex
+	ring  []ResultRecord
+	next  int
+}
+
+// add keeps rec, dropping the oldest once full
+func (r *recentResults) add(rec ResultRecord) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.ring) < recentSampleCount {
+		r.ring = append(r.ring, rec)
+		return
+	}
+	r.ring[r.next] = rec
+	r.next = (r.next + 1) % recentSampleCount
+}
+
+// list returns the results kept, oldest first
+func (r *recentResults) list() []ResultRecord {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append(slices.Clone(r.ring[r.next:]), r.ring[:r.next]...)
+}
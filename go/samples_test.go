@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// exactStats returns what the accumulator estimates, computed over the
+// kept values
+func exactStats(values []float64) (LatencyStats, float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	sum, jitter := 0.0, 0.0
+	for i, v := range values {
+		sum += v
+		if i > 0 {
+			jitter += math.Abs(v - values[i-1])
+		}
+	}
+	if len(values) > 1 {
+		jitter /= float64(len(values) - 1)
+	}
+	return LatencyStats{
+		Count:  len(values),
+		Avg:    sum / float64(len(values)),
+		Min:    sorted[0],
+		Max:    sorted[len(sorted)-1],
+		Median: percentile(sorted, 50),
+		P95:    percentile(sorted, 95),
+	}, jitter
+}
+
+// within reports whether got is within 2% of want
+func within(got, want float64) bool {
+	return math.Abs(got-want) <= math.Abs(want)*0.02
+}
+
+func TestLatencyAccumulator(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	uniform := make([]float64, 10000)
+	for i := range uniform {
+		uniform[i] = 5 + r.Float64()*45
+	}
+	// A long tail, as on a line with bufferbloat
+	tail := make([]float64, 10000)
+	for i := range tail {
+		tail[i] = 8 * math.Exp(r.NormFloat64())
+	}
+	tests := []struct {
+		name   string
+		values []float64
+	}{
+		{"uniform", uniform},
+		{"long tail", tail},
+		{"single sample", []float64{12.5}},
+		{"two samples", []float64{10, 30}},
+		{"zero", []float64{0, 0, 0}},
+		{"zero and more", []float64{0, 0.001, 1, 2, 3}},
+		{"huge RTT", []float64{10, 11, 12, 3600000}},
+		// The longest probe timeout
+		{"timeout-long RTTs", []float64{20000, 25000, 29000, 30000}},
+	}
+	for _, tt := range tests {
+		var a latencyAccumulator
+		for _, v := range tt.values {
+			a.add(v)
+		}
+		got := a.stats()
+		want, jitter := exactStats(tt.values)
+		// Count, sum, minimum, maximum and jitter are kept exactly
+		if got.Count != want.Count || got.Min != want.Min || got.Max != want.Max {
+			t.Errorf("%s: count/min/max %d/%v/%v, want %d/%v/%v", tt.name, got.Count, got.Min, got.Max, want.Count, want.Min, want.Max)
+		}
+		if math.Abs(got.Avg-want.Avg) > 1e-9*math.Max(1, math.Abs(want.Avg)) {
+			t.Errorf("%s: avg %v, want %v", tt.name, got.Avg, want.Avg)
+		}
+		if math.Abs(a.jitter()-jitter) > 1e-9*math.Max(1, jitter) {
+			t.Errorf("%s: jitter %v, want %v", tt.name, a.jitter(), jitter)
+		}
+		// Percentiles come from the histogram
+		if !within(got.Median, want.Median) || !within(got.P95, want.P95) {
+			t.Errorf("%s: median/p95 %v/%v, want %v/%v within 2%%", tt.name, got.Median, got.P95, want.Median, want.P95)
+		}
+		if got.Median < got.Min || got.P95 > got.Max || got.Median > got.P95 {
+			t.Errorf("%s: %+v out of order", tt.name, got)
+		}
+		if len(tt.values) == 1 && (got.Median != tt.values[0] || got.P95 != tt.values[0] || got.MAD != 0) {
+			t.Errorf("%s: %+v", tt.name, got)
+		}
+	}
+
+	// Past the last bucket, and below the first as negative differentials
+	// are, the values only keep their order within the range seen
+	for _, values := range [][]float64{{1e9, 2e9, 3e9}, {-3, -1, 0.5, 2}} {
+		var a latencyAccumulator
+		for _, v := range values {
+			a.add(v)
+		}
+		s := a.stats()
+		if s.Min != values[0] || s.Max != values[len(values)-1] || s.Median < s.Min || s.P95 > s.Max || s.Median > s.P95 {
+			t.Errorf("%v: %+v", values, s)
+		}
+	}
+
+	var empty latencyAccumulator
+	if s := empty.stats(); s != (LatencyStats{}) || empty.percentile(50) != 0 || empty.jitter() != 0 {
+		t.Errorf("empty: %+v", s)
+	}
+	if s := empty.probeStats(3); s.Total != 3 || s.SuccessRate != 0 || s.Latency.Count != 0 {
+		t.Errorf("only failures: %+v", s)
+	}
+}
+
+// TestLatencyAccumulatorMerge checks that merging two halves is the same
+// as adding every value to one accumulator
+func TestLatencyAccumulatorMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	var whole, first, second latencyAccumulator
+	for i := 0; i < 1000; i++ {
+		v := 1 + r.Float64()*100
+		whole.add(v)
+		if i < 400 {
+			first.add(v)
+		} else {
+			second.add(v)
+		}
+	}
+	var empty latencyAccumulator
+	first.merge(&empty)
+	merged := first.clone()
+	merged.merge(&second)
+	// The sums add up in another order
+	sameStats := func(a, b LatencyStats) bool {
+		avg := math.Abs(a.Avg-b.Avg) < 1e-9
+		a.Avg, b.Avg = 0, 0
+		return avg && a == b
+	}
+	if !sameStats(merged.stats(), whole.stats()) || math.Abs(merged.jitter()-whole.jitter()) > 1e-9 {
+		t.Errorf("merged %+v, want %+v", merged.stats(), whole.stats())
+	}
+	if first.count != 400 {
+		t.Errorf("merging into a clone changed the original: %d values", first.count)
+	}
+	empty.merge(&whole)
+	if !sameStats(empty.stats(), whole.stats()) {
+		t.Errorf("merged into empty %+v, want %+v", empty.stats(), whole.stats())
+	}
+}
+
+// TestReplayDoesNotRepeatFailures samples a period partly while waiting for
+// the clock: the snapshot taken while waiting, and the samples once the
+// clock is set, must equal those of a monitor that never waited
+func TestReplayDoesNotRepeatFailures(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	failing := func(s int) bool { return (s >= 10 && s < 20) || (s >= 40 && s < 50) }
+	run := func(wait bool) (*PingMonitor, *periodSamples) {
+		pm, clock := newTestMonitor(t, nil, start)
+		pm.prober = probeFunc(func(string) (float64, error) {
+			if failing(int(clock.now().Sub(start) / time.Second)) {
+				return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+			}
+			return 10, nil
+		})
+		var snapshot *periodSamples
+		for s := 0; s < 90; s++ {
+			at := start.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			if wait && s == 30 {
+				// The clock is taken for early from here on
+				pm.clock = newClockGuard(start.AddDate(1, 0, 0), at)
+			}
+			if wait && s == 60 {
+				pm.mutex.Lock()
+				snapshot = pm.samplesLocked()
+				pm.mutex.Unlock()
+				pm.clock.floor = start
+				pm.checkClock(at)
+			}
+			pm.tick(at)
+		}
+		if !wait {
+			pm.mutex.Lock()
+			snapshot = pm.samplesLocked()
+			pm.mutex.Unlock()
+		}
+		return pm, snapshot
+	}
+
+	pm, _ := run(false)
+	waited, snapshot := run(true)
+	if waited.unanchoredFailures != 0 || len(waited.unanchoredResults) != 0 {
+		t.Errorf("%d failures and %d results still waiting", waited.unanchoredFailures, len(waited.unanchoredResults))
+	}
+	if got, want := waited.samples.worst.result(), pm.samples.worst.result(); !reflect.DeepEqual(got, want) {
+		t.Errorf("worst minutes after the clock was set:\n got  %+v\n want %+v", got, want)
+	}
+	if got, want := waited.samples.latency.stats(), pm.samples.latency.stats(); got != want {
+		t.Errorf("latency after the clock was set %+v, want %+v", got, want)
+	}
+	if len(waited.unreachableTimes) != 20 {
+		t.Errorf("%d failures, want 20", len(waited.unreachableTimes))
+	}
+
+	// The snapshot while waiting holds the first 60 samples
+	ref, clock := newTestMonitor(t, nil, start)
+	for s := 0; s < 60; s++ {
+		at := start.Add(time.Duration(s) * time.Second)
+		clock.set(at)
+		if failing(s) {
+			ref.samples.addFailure(at)
+		} else {
+			ref.samples.add(PingResult{Timestamp: at, ResponseTime: 10, Success: true})
+		}
+	}
+	if got, want := snapshot.worst.result(), ref.samples.worst.result(); !reflect.DeepEqual(got, want) {
+		t.Errorf("worst minutes while waiting:\n got  %+v\n want %+v", got, want)
+	}
+	if snapshot.successes != 40 {
+		t.Errorf("%d successes while waiting, want 40", snapshot.successes)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// scenarioGateway is the gateway address used by scenario runs
+const scenarioGateway = "192.0.2.1"
+
+// Scenario is a scripted run of the monitor on a virtual clock, replayed
+// by -simulate and by the scenario tests
+type Scenario struct {
+	// Start is the time of the first sample (RFC3339)
+	Start    string          `json:"start"`
+	Interval string          `json:"interval"`
+	Config   json.RawMessage `json:"config"`
+	Steps    []ScenarioStep  `json:"steps"`
+	// Repeat runs the steps this many times in all (default once), so a
+	// week of nightly blips needs one day of steps
+	Repeat int `json:"repeat,omitempty"`
+	// Seed makes the loss_percent and jitter_ms draws of a run repeatable
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// ScenarioStep repeats one probe outcome for a duration
+type ScenarioStep struct {
+	For    string  `json:"for"`
+	Result string  `json:"result"`
+	RTTMs  float64 `json:"rtt_ms"`
+	// Gateway is the gateway outcome while the target fails ("ok" or "fail")
+	Gateway string `json:"gateway"`
+	// Reason is the failure reason of failing samples (default "timeout")
+	Reason string `json:"reason,omitempty"`
+	// LossPercent fails this share of an "ok" step's samples at random,
+	// and JitterMs adds up to this much to each response time
+	LossPercent float64 `json:"loss_percent,omitempty"`
+	JitterMs    float64 `json:"jitter_ms,omitempty"`
+}
+
+// errScriptedFailure is returned by scriptedProber for failing steps
+var errScriptedFailure = errors.New("scripted failure")
+
+// scriptedProber replays scenario steps; each probe of the target advances
+// the script by one sample
+type scriptedProber struct {
+	target string
+	steps  []ScenarioStep
+	// counts is the number of samples of each step, total their sum
+	counts []int
+	total  int
+	// step is the current step, left its samples not yet probed
+	step    int
+	left    int
+	rng     *rand.Rand
+	current ScenarioStep
+}
+
+// newScriptedProber checks the steps of sc and counts their samples
+func newScriptedProber(target string, sc *Scenario, interval time.Duration) (*scriptedProber, error) {
+	if sc.Repeat < 0 {
+		return nil, fmt.Errorf("repeat は0以上で指定してください: %d", sc.Repeat)
+	}
+	p := &scriptedProber{target: target, step: -1, rng: rand.New(rand.NewSource(sc.Seed))}
+	var counts []int
+	for i, step := range sc.Steps {
+		d, err := time.ParseDuration(step.For)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("steps[%d].for の値が正しくありません: %q", i, step.For)
+		}
+		if step.Result != "ok" && step.Result != "fail" {
+			return nil, fmt.Errorf("steps[%d].result の値が正しくありません: %q (ok または fail)", i, step.Result)
+		}
+		if step.Reason == "" {
+			step.Reason = string(reasonTimeout)
+		}
+		if !validFailureReason(step.Reason) {
+			return nil, fmt.Errorf("steps[%d].reason の値が正しくありません: %q", i, step.Reason)
+		}
+		if step.LossPercent < 0 || step.LossPercent > 100 {
+			return nil, fmt.Errorf("steps[%d].loss_percent は0〜100で指定してください: %v", i, step.LossPercent)
+		}
+		if step.JitterMs < 0 {
+			return nil, fmt.Errorf("steps[%d].jitter_ms は0以上で指定してください: %v", i, step.JitterMs)
+		}
+		sc.Steps[i] = step
+		counts = append(counts, int(d/interval))
+	}
+	for n := max(sc.Repeat, 1); n > 0; n-- {
+		p.steps = append(p.steps, sc.Steps...)
+		p.counts = append(p.counts, counts...)
+	}
+	for _, n := range p.counts {
+		p.total += n
+	}
+	return p, nil
+}
+
+// Probe returns the next scripted target result; gateways follow the
+// current step
+func (p *scriptedProber) Probe(host string) (float64, error) {
+	if host != p.target {
+		if p.current.Gateway == "fail" {
+			return 0, errScriptedFailure
+		}
+		return 1, nil
+	}
+	for p.left == 0 {
+		if p.step+1 >= len(p.steps) {
+			return 0, &probeError{reason: reasonMeasurement, err: errors.New("scenario ended")}
+		}
+		p.step++
+		p.left = p.counts[p.step]
+	}
+	p.current = p.steps[p.step]
+	p.left--
+	if p.current.Result == "fail" || (p.current.LossPercent > 0 && p.rng.Float64()*100 < p.current.LossPercent) {
+		return 0, &probeError{reason: failureReason(p.current.Reason), err: errScriptedFailure}
+	}
+	return p.current.RTTMs + p.rng.Float64()*p.current.JitterMs, nil
+}
+
+// loadScenario reads a scenario file with its start and sample interval
+func loadScenario(path string) (*Scenario, time.Time, time.Duration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, 0, err
+	}
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("シナリオ %s の形式が正しくありません: %v", path, err)
+	}
+	start, err := time.Parse(time.RFC3339, sc.Start)
+	if err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("start の値が正しくありません: %q (RFC3339)", sc.Start)
+	}
+	interval, err := time.ParseDuration(sc.Interval)
+	if err != nil || interval <= 0 {
+		return nil, time.Time{}, 0, fmt.Errorf("interval の値が正しくありません: %q", sc.Interval)
+	}
+	return &sc, start.Local(), interval, nil
+}
+
+// newScenarioMonitor creates a monitor from the config keys cfg, written
+// to a config file in dir, that probes sc's script on the clock now
+func newScenarioMonitor(sc *Scenario, start time.Time, interval time.Duration, cfg map[string]interface{}, dir string, now func() time.Time) (*PingMonitor, *scriptedProber, error) {
+	cfg["gateway"] = scenarioGateway
+	cfgPath := filepath.Join(dir, "config.json")
+	cfgData, _ := json.Marshal(cfg)
+	if err := os.WriteFile(cfgPath, cfgData, 0600); err != nil {
+		return nil, nil, err
+	}
+
+	pm, err := NewPingMonitor(cfgPath, ConfigOverrides{})
+	if err != nil {
+		return nil, nil, err
+	}
+	prober, err := newScriptedProber(pm.targetIP, sc, interval)
+	if err != nil {
+		return nil, nil, err
+	}
+	pm.prober = prober
+	pm.now = now
+	pm.pingInterval = interval
+	pm.samples = newPeriodSamples(interval)
+	pm.iface = nil
+	pm.localIP = "192.0.2.2"
+	pm.reports = newReportCoordinator(pm, start)
+	pm.periodStart = start
+	pm.outages = newOutageTracker(pm.config.FailureThreshold, pm.config.RecoveryThreshold, start)
+	pm.warmupUntil = start.Add(pm.config.Warmup.Duration())
+	return pm, prober, nil
+}
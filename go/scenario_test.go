@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DiscordCall is one request received by the fake Discord webhook
+type DiscordCall struct {
+	Seq        int             `json:"seq"`
+	Status     string          `json:"status"`
+	Payload    json.RawMessage `json:"payload"`
+	Attachment string          `json:"attachment,omitempty"`
+}
+
+// fakeDiscord is a webhook server answering with scripted responses
+type fakeDiscord struct {
+	mutex     sync.Mutex
+	responses []string
+	calls     []DiscordCall
+	server    *httptest.Server
+}
+
+// newFakeDiscord starts a fake webhook server
+func newFakeDiscord(responses []string) *fakeDiscord {
+	f := &fakeDiscord{responses: responses}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+// handle records the request and answers with the next scripted response
+func (f *fakeDiscord) handle(w http.ResponseWriter, r *http.Request) {
+	payload, attachment := readWebhookBody(r)
+
+	f.mutex.Lock()
+	status := "204"
+	if len(f.responses) > 0 {
+		status = f.responses[0]
+		f.responses = f.responses[1:]
+	}
+	f.calls = append(f.calls, DiscordCall{Seq: len(f.calls) + 1, Status: status, Payload: payload, Attachment: attachment})
+	f.mutex.Unlock()
+
+	code, arg, _ := strings.Cut(status, ":")
+	switch code {
+	case "reset":
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	case "429":
+		retryAfter, _ := strconv.ParseFloat(arg, 64)
+		writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+			"message":     "You are being rate limited.",
+			"retry_after": retryAfter,
+			"global":      false,
+		})
+	default:
+		n, err := strconv.Atoi(code)
+		if err != nil {
+			n = http.StatusNoContent
+		}
+		w.WriteHeader(n)
+	}
+}
+
+// readWebhookBody extracts the JSON payload and attachment name from a JSON
+// or multipart webhook request
+func readWebhookBody(r *http.Request) (json.RawMessage, string) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		body, _ := io.ReadAll(r.Body)
+		return json.RawMessage(body), ""
+	}
+	var payload json.RawMessage
+	var attachment string
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FormName() == "payload_json" {
+			data, _ := io.ReadAll(part)
+			payload = json.RawMessage(data)
+		} else if part.FileName() != "" {
+			attachment = part.FileName()
+		}
+		part.Close()
+	}
+	return payload, attachment
+}
+
+// executeScenario runs the monitor through the scenario's samples against
+// a fake webhook answering with responses and returns the requests it
+// received
+func executeScenario(t *testing.T, sc Scenario, responses []string) []DiscordCall {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scenario.json")
+	data, _ := json.Marshal(sc)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, start, interval, err := loadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discord := newFakeDiscord(responses)
+	defer discord.server.Close()
+
+	cfg := map[string]interface{}{"warmup": "0s"}
+	if len(loaded.Config) > 0 {
+		if err := json.Unmarshal(loaded.Config, &cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg["discord_webhook_url"] = discord.server.URL
+	clock := &testClock{t: start}
+	pm, prober, err := newScenarioMonitor(loaded, start, interval, cfg, dir, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < prober.total; i++ {
+		at := start.Add(time.Duration(i) * interval)
+		clock.set(at)
+		pm.tick(at)
+		pm.reports.flush()
+		pm.dispatcher.flush()
+	}
+	pm.reports.shutdown()
+	pm.dispatcher.close()
+	pm.store.Close()
+
+	discord.mutex.Lock()
+	defer discord.mutex.Unlock()
+	return discord.calls
+}
+
+// callSummary renders a webhook request as its status and embed titles
+func callSummary(c DiscordCall) string {
+	var msg DiscordMessage
+	json.Unmarshal(c.Payload, &msg)
+	var titles []string
+	for _, e := range msg.Embeds {
+		titles = append(titles, e.Title)
+	}
+	return fmt.Sprintf("%s %s", c.Status, strings.Join(titles, " | "))
+}
+
+func TestScenarios(t *testing.T) {
+	setLocal(t, "Asia/Tokyo")
+	tests := []struct {
+		name     string
+		scenario Scenario
+		// discord lists the webhook responses in order: "204", "400",
+		// "429:<retry_after seconds>" or "reset"; 204 is used afterwards
+		discord []string
+		want    []string
+		// contains are texts some payload must hold
+		contains []string
+	}{
+		{
+			name: "outage across midnight with a rate limit and a reset",
+			scenario: Scenario{
+				Start:    "2026-01-31T23:59:30+09:00",
+				Interval: "1s",
+				Config:   json.RawMessage(`{"failure_threshold": 3, "recovery_threshold": 3}`),
+				Steps: []ScenarioStep{
+					{For: "15s", Result: "ok", RTTMs: 12.5},
+					{For: "30s", Result: "fail", Gateway: "ok"},
+					{For: "20s", Result: "ok", RTTMs: 14},
+				},
+			},
+			discord: []string{"204", "429:0.5", "reset"},
+			// The closed day is retried through the rate limit and the
+			// reset while the outage goes on
+			want: []string{
+				"204 🚨 8.8.8.8 到達不能",
+				"429:0.5 🌐 Ping Monitor 日次レポート",
+				"reset 🌐 Ping Monitor 日次レポート",
+				"204 🌐 Ping Monitor 日次レポート",
+				"204 ✅ 8.8.8.8 到達性 復旧",
+				"204 🌐 Ping Monitor 日次レポート",
+			},
+			contains: []string{"**ゲートウェイ**: 応答あり", "**日付**: 2026-01-31", "**日付**: 2026-02-01"},
+		},
+		{
+			name: "blips below the failure threshold",
+			scenario: Scenario{
+				Start:    "2026-02-01T12:00:00+09:00",
+				Interval: "1s",
+				Config:   json.RawMessage(`{"failure_threshold": 3}`),
+				Steps: []ScenarioStep{
+					{For: "10s", Result: "ok", RTTMs: 10},
+					{For: "2s", Result: "fail", Gateway: "ok"},
+					{For: "10s", Result: "ok", RTTMs: 10},
+				},
+			},
+			want: []string{"204 🌐 Ping Monitor 日次レポート"},
+		},
+		{
+			name: "gateway down with the target",
+			scenario: Scenario{
+				Start:    "2026-02-01T12:00:00+09:00",
+				Interval: "1s",
+				Config:   json.RawMessage(`{"failure_threshold": 3, "recovery_threshold": 2}`),
+				Steps: []ScenarioStep{
+					{For: "5s", Result: "ok", RTTMs: 10},
+					{For: "10s", Result: "fail", Gateway: "fail"},
+					{For: "5s", Result: "ok", RTTMs: 10},
+				},
+			},
+			want: []string{
+				"204 🚨 8.8.8.8 到達不能",
+				"204 ✅ 8.8.8.8 到達性 復旧",
+				"204 🌐 Ping Monitor 日次レポート",
+			},
+			contains: []string{"**ゲートウェイ**: 応答なし"},
+		},
+		{
+			name: "clean day closed at midnight",
+			scenario: Scenario{
+				Start:    "2026-02-01T23:00:00+09:00",
+				Interval: "10s",
+				Config:   json.RawMessage(`{"min_report_coverage": "30m", "min_report_samples": 10}`),
+				Steps: []ScenarioStep{
+					{For: "1h1m", Result: "ok", RTTMs: 10},
+				},
+			},
+			// The closed day is the short report, the interim one is not
+			want: []string{
+				"204 🌐 Ping Monitor 日次レポート（品質スコア 100/100）",
+				"204 🌐 Ping Monitor 日次レポート",
+			},
+			contains: []string{"✅ 問題なし"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var payloads strings.Builder
+			for _, c := range executeScenario(t, tt.scenario, tt.discord) {
+				got = append(got, callSummary(c))
+				payloads.Write(c.Payload)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(payloads.String(), s) {
+					t.Errorf("no payload contains %q", s)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("webhook requests:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// scheduleGapMin is the shortest lateness treated as a suspension gap
+// rather than an overrun; with long intervals ten intervals apply instead
+const scheduleGapMin = 30 * time.Second
+
+// probeSchedule places probes on a fixed grid of epoch + n×interval.
+// Unlike time.Ticker it never delivers a burst of catch-up ticks: a late
+// wakeup runs the latest due slot and accounts for the skipped ones, as
+// overruns when the previous cycle ran long and as a gap when the process
+// was suspended or the clock jumped forward.
+type probeSchedule struct {
+	epoch    time.Time
+	interval time.Duration
+	next     int64
+}
+
+// newProbeSchedule creates a grid aligned to multiples of interval; the
+// first slot is the first one after now
+func newProbeSchedule(now time.Time, interval time.Duration) *probeSchedule {
+	s := &probeSchedule{interval: interval}
+	s.anchor(now)
+	return s
+}
+
+// anchor restarts the grid at now
+func (s *probeSchedule) anchor(now time.Time) {
+	// Round(0) drops the monotonic reading, so the grid follows the wall
+	// clock and a suspension shows up as elapsed time
+	s.epoch = now.Round(0).Truncate(s.interval)
+	s.next = 1
+}
+
+// slot returns the time of slot n
+func (s *probeSchedule) slot(n int64) time.Time {
+	return s.epoch.Add(time.Duration(n) * s.interval)
+}
+
+// deadline returns when the next slot is due
+func (s *probeSchedule) deadline() time.Time {
+	return s.slot(s.next)
+}
+
+// gapThreshold returns the lateness from which skipped slots are a gap
+func (s *probeSchedule) gapThreshold() time.Duration {
+	return max(scheduleGapMin, 10*s.interval)
+}
+
+// scheduleStep is the outcome of one wakeup
+type scheduleStep struct {
+	// Slot is the grid time of the cycle to run
+	Slot time.Time
+	// Missed is the number of slots skipped before Slot
+	Missed int64
+	// Gap is set when the skipped slots are a suspension, not an overrun
+	Gap bool
+	// Reanchored is set when the clock went backwards and the grid was
+	// restarted
+	Reanchored bool
+}
+
+// take consumes the latest slot due at now
+func (s *probeSchedule) take(now time.Time) scheduleStep {
+	now = now.Round(0)
+	expected := s.deadline()
+	// The clock went backwards by more than an interval: restart the grid
+	if expected.Sub(now) > s.interval {
+		s.anchor(now)
+		return scheduleStep{Slot: s.slot(0), Reanchored: true}
+	}
+	k := max(int64(now.Sub(s.epoch)/s.interval), s.next)
+	step := scheduleStep{Slot: s.slot(k), Missed: k - s.next}
+	if step.Missed > 0 {
+		step.Gap = now.Sub(expected) >= s.gapThreshold()
+	}
+	s.next = k + 1
+	return step
+}
+
+// recordScheduleStep reports and counts the irregularities of a step
+func (pm *PingMonitor) recordScheduleStep(step scheduleStep) {
+	switch {
+	case step.Reanchored:
+		fmt.Printf("⚠️ 時計が戻ったため、計測の時刻を %s から取り直しました\n", step.Slot.Format("15:04:05"))
+	case step.Gap:
+		missing := time.Duration(step.Missed) * pm.pingInterval
+		fmt.Printf("⚠️ 計測が %v 中断していました（スリープまたは時計の変更）。%d回分を欠測として扱います\n", missing, step.Missed)
+		pm.mutex.Lock()
+		pm.scheduleGaps++
+		pm.scheduleGapTime += missing
+		pm.mutex.Unlock()
+		pm.markSchedule(scheduleMark{Time: step.Slot.Add(-missing), Kind: markSuspend, End: &step.Slot})
+	case step.Missed > 0:
+		pm.mutex.Lock()
+		pm.scheduleOverruns += int(step.Missed)
+		pm.mutex.Unlock()
+	}
+}
+
+// scheduleNote returns the period's measurement irregularities prefixed
+// by sep, or "" when the grid was kept
+func (p *reportPeriod) scheduleNote(sep string) string {
+	note := ""
+	if p.ScheduleOverruns > 0 {
+		note += fmt.Sprintf("%s計測の遅れによる欠測: %s回", sep, p.Format.count(p.ScheduleOverruns))
+	}
+	if p.ScheduleGaps > 0 {
+		note += fmt.Sprintf("%s計測の中断: %s回（計 %s）", sep, p.Format.count(p.ScheduleGaps), p.Format.duration(p.ScheduleGapTime))
+	}
+	return note
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeSchedule(t *testing.T) {
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return base.Add(d) }
+	s := newProbeSchedule(at(300*time.Millisecond), time.Second)
+	if !s.deadline().Equal(at(time.Second)) {
+		t.Fatalf("first deadline %s", s.deadline().Format("15:04:05.000"))
+	}
+	steps := []struct {
+		name string
+		wake time.Duration
+		// slot is the grid time run, relative to base unless reanchored
+		slot       time.Duration
+		missed     int64
+		gap        bool
+		reanchored bool
+	}{
+		{"on time", 1002 * time.Millisecond, time.Second, 0, false, false},
+		{"late within the slot", 2500 * time.Millisecond, 2 * time.Second, 0, false, false},
+		// The previous cycle ran long: three slots are overruns
+		{"overrun", 6100 * time.Millisecond, 6 * time.Second, 3, false, false},
+		{"back on the grid", 7 * time.Second, 7 * time.Second, 0, false, false},
+		// 52 slots late is past the 30s gap threshold
+		{"suspended", 60 * time.Second, 60 * time.Second, 52, true, false},
+		// An early wakeup runs the next slot, never the same one twice
+		{"early", 60500 * time.Millisecond, 61 * time.Second, 0, false, false},
+		{"just under the gap threshold", 91900 * time.Millisecond, 91 * time.Second, 29, false, false},
+		{"clock set back", 10 * time.Second, 10 * time.Second, 0, false, true},
+		{"after the reanchor", 11 * time.Second, 11 * time.Second, 0, false, false},
+	}
+	for _, tt := range steps {
+		got := s.take(at(tt.wake))
+		want := scheduleStep{Slot: at(tt.slot), Missed: tt.missed, Gap: tt.gap, Reanchored: tt.reanchored}
+		if got != want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, want)
+		}
+	}
+}
+
+func TestProbeScheduleGapThreshold(t *testing.T) {
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	// Ten intervals apply once they exceed 30 seconds
+	s := newProbeSchedule(base, 10*time.Second)
+	if s.gapThreshold() != 100*time.Second {
+		t.Errorf("threshold %v, want 100s", s.gapThreshold())
+	}
+	if step := s.take(base.Add(70 * time.Second)); step.Missed != 6 || step.Gap {
+		t.Errorf("60s late at 10s: %+v", step)
+	}
+	if step := s.take(base.Add(190 * time.Second)); step.Missed != 11 || !step.Gap {
+		t.Errorf("110s late at 10s: %+v", step)
+	}
+
+	// The grid follows the wall clock, not the monotonic reading
+	now := time.Now()
+	s = newProbeSchedule(now, time.Second)
+	if step := s.take(now.Add(2 * time.Second)); step.Slot.Round(0) != step.Slot || step.Missed != 1 {
+		t.Errorf("from a monotonic reading: %+v", step)
+	}
+}
+
+// TestRecordScheduleStep checks the counts and notes a period gets from
+// overruns and gaps
+func TestRecordScheduleStep(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	pm, _ := newTestMonitor(t, map[string]interface{}{"state_dir": t.TempDir()}, start)
+	pm.recordScheduleStep(scheduleStep{Slot: start.Add(5 * time.Second), Missed: 3})
+	pm.recordScheduleStep(scheduleStep{Slot: start.Add(time.Minute), Missed: 2})
+	pm.recordScheduleStep(scheduleStep{Slot: start.Add(time.Hour), Missed: 600, Gap: true})
+	pm.recordScheduleStep(scheduleStep{Slot: start, Reanchored: true})
+
+	p := pm.takePeriod(start.Format(reportDateLayout))
+	if p.ScheduleOverruns != 5 || p.ScheduleGaps != 1 || p.ScheduleGapTime != 10*time.Minute {
+		t.Errorf("overruns %d, gaps %d for %v", p.ScheduleOverruns, p.ScheduleGaps, p.ScheduleGapTime)
+	}
+	if got, want := p.scheduleNote("\n"), "\n計測の遅れによる欠測: 5回\n計測の中断: 1回（計 10m0s）"; got != want {
+		t.Errorf("note %q, want %q", got, want)
+	}
+	if pm.scheduleOverruns != 0 || pm.scheduleGaps != 0 {
+		t.Error("counts not reset with the period")
+	}
+	if (&reportPeriod{Format: p.Format}).scheduleNote("\n") != "" {
+		t.Error("note without irregularities")
+	}
+
+	// The gap is kept for export, ending at the slot that ran
+	marks, err := readScheduleMarks(pm.config.StateDir)
+	if err != nil || len(marks) != 1 || marks[0].Kind != markSuspend || !marks[0].Time.Equal(start.Add(50*time.Minute)) || !marks[0].End.Equal(start.Add(time.Hour)) {
+		t.Errorf("marks %+v, %v", marks, err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ping-monitor/api"
+)
+
+// publicSchemas lists every versioned output. /config and /debug/state
+// show internal state and have no fixed shape.
+var publicSchemas = []SchemaInfo{
+	{ID: api.SchemaStatus, Where: "GET /status"},
+	{ID: api.SchemaVerdict, Where: "GET /verdict"},
+	{ID: api.SchemaHealth, Where: "GET /healthz"},
+	{ID: api.SchemaSeries, Where: "GET /api/v1/series"},
+	{ID: api.SchemaResults, Where: "GET /api/v1/results"},
+	{ID: api.SchemaDaily, Where: "GET /api/v1/daily"},
+	{ID: api.SchemaFailures, Where: "GET /debug/failures"},
+	{ID: api.SchemaAudit, Where: "GET /audit"},
+	{ID: api.SchemaSimulate, Where: "POST /simulate/outage"},
+	{ID: api.SchemaReportDelivery, Where: "POST /report/resend"},
+	{ID: api.SchemaProfiles, Where: "GET /profiles（profiles）"},
+	{ID: api.SchemaProfilesHealth, Where: "GET /healthz（profiles）"},
+	{ID: api.SchemaSchemas, Where: "GET /schema"},
+	// The records of results_file are the entries of /api/v1/results and
+	// the events of /api/v1/stream; they carry no schema of their own
+	{ID: "pingcheck.result/1", Where: "results_file、GET /api/v1/stream"},
+	{ID: fmt.Sprintf("pingcheck.report_file/%d", reportFileSchemaVersion), Where: "report_dir（schema_version）"},
+	{ID: fmt.Sprintf("pingcheck.site_snapshot/%d", snapshotSchemaVersion), Where: "report_to、POST /ingest（schema_version）"},
+}
+
+// handleSchema lists the versioned outputs, so clients can check before
+// parsing that the monitor still serves the version they know
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, SchemaResponse{Schema: api.SchemaSchemas, Schemas: publicSchemas})
+}
+
+// versioned serves h, unless the request asks with ?schema= for another
+// version of the output than id, which is answered 406 with the version
+// served. The name must match, so a typo is not taken for an old version.
+func versioned(id string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want := r.URL.Query().Get("schema")
+		if want == "" || want == id {
+			h(w, r)
+			return
+		}
+		name, _, _ := strings.Cut(id, "/")
+		if wantName, _, _ := strings.Cut(want, "/"); wantName != name {
+			http.Error(w, fmt.Sprintf("schema %q is not served here (this is %s)", want, id), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("schema %s is not supported (supported: %s)", want, id), http.StatusNotAcceptable)
+	}
+}
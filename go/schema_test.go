@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"ping-monitor/api"
+)
+
+// TestServedSchemas decodes what the handlers serve into the types of
+// package api, rejecting fields the types do not know
+func TestServedSchemas(t *testing.T) {
+	quietStdout(t)
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	pm, clock := newTestMonitor(t, map[string]interface{}{
+		"api_tokens": []map[string]interface{}{{"name": "ops", "token": "secret", "scopes": []string{"admin"}}},
+	}, start)
+	pm.prober = probeFunc(func(string) (float64, error) {
+		if clock.now().Sub(start) < 3*time.Second {
+			return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+		}
+		return 10, nil
+	})
+	for s := 0; s < 10; s++ {
+		at := start.Add(time.Duration(s) * time.Second)
+		clock.set(at)
+		pm.tick(at)
+	}
+	handler := newAPIServer(pm).server.Handler
+
+	tests := []struct {
+		path   string
+		schema string
+		into   interface{}
+	}{
+		{"/status", api.SchemaStatus, &api.StatusResponse{}},
+		{"/verdict", api.SchemaVerdict, &api.VerdictResponse{}},
+		{"/healthz", api.SchemaHealth, &api.HealthResponse{}},
+		{"/schema", api.SchemaSchemas, &api.SchemaResponse{}},
+		{"/api/v1/series", api.SchemaSeries, &api.SeriesResponse{}},
+		{"/api/v1/results?from=" + start.Format(time.RFC3339) + "&to=" + start.Add(time.Hour).Format(time.RFC3339), api.SchemaResults, &api.ResultsResponse{}},
+		{"/api/v1/daily?date=" + start.Format(reportDateLayout), api.SchemaDaily, &api.DailyResponse{}},
+		{"/debug/failures", api.SchemaFailures, &api.FailuresResponse{}},
+		{"/audit", api.SchemaAudit, &api.AuditResponse{}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: %d %s", tt.path, rec.Code, rec.Body)
+			continue
+		}
+		dec := json.NewDecoder(rec.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(tt.into); err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		if got := reflect.ValueOf(tt.into).Elem().FieldByName("Schema").String(); got != tt.schema {
+			t.Errorf("%s: schema %q, want %q", tt.path, got, tt.schema)
+		}
+	}
+
+	// The results and the failures are those sampled
+	req := httptest.NewRequest(http.MethodGet, tests[5].path, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var results api.ResultsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil || len(results.Results) != 10 {
+		t.Fatalf("results: %d, %v", len(results.Results), err)
+	}
+	if r := results.Results[0]; r.Success || r.Reason != string(reasonTimeout) || !r.Timestamp.Equal(start) {
+		t.Errorf("first result %+v", r)
+	}
+	if r := results.Results[9]; !r.Success || r.ResponseTime != 10 {
+		t.Errorf("last result %+v", r)
+	}
+}
+
+// TestVersioned checks the answers to ?schema= for the served version,
+// another version and another output
+func TestVersioned(t *testing.T) {
+	h := versioned(api.SchemaStatus, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusOK},
+		{"?schema=pingcheck.status/1", http.StatusOK},
+		{"?schema=pingcheck.status/2", http.StatusNotAcceptable},
+		{"?schema=pingcheck.verdict/1", http.StatusBadRequest},
+		{"?schema=status", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/status"+tt.query, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%q: %d, want %d", tt.query, rec.Code, tt.want)
+		}
+	}
+}
+
+// TestPublicSchemas checks that /schema lists every response ID once
+func TestPublicSchemas(t *testing.T) {
+	listed := map[string]int{}
+	for _, s := range publicSchemas {
+		listed[s.ID]++
+	}
+	for _, id := range []string{
+		api.SchemaStatus, api.SchemaVerdict, api.SchemaHealth, api.SchemaSeries, api.SchemaResults,
+		api.SchemaDaily, api.SchemaFailures, api.SchemaAudit, api.SchemaSimulate, api.SchemaReportDelivery,
+		api.SchemaProfiles, api.SchemaProfilesHealth, api.SchemaSchemas,
+	} {
+		if listed[id] != 1 {
+			t.Errorf("%s listed %d times", id, listed[id])
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// seriesMinutes is the number of per-minute slots kept per target (24h)
+const seriesMinutes = 24 * 60
+
+// minuteSlot accumulates one minute; sum is kept so the average can be
+// updated incrementally
+type minuteSlot struct {
+	minute  int64
+	count   int
+	success int
+	min     float64
+	max     float64
+	sum     float64
+	reasons reasonCounts
+}
+
+// minuteRing is a fixed-size circular buffer of per-minute slots indexed
+// by minute since the epoch, so memory stays bounded regardless of uptime
+// and a slot is reset when the minute it held falls out of the window
+type minuteRing struct {
+	slots [seriesMinutes]minuteSlot
+}
+
+// add records one sample; reason is "" for a success
+func (r *minuteRing) add(at time.Time, reason failureReason, ms float64) {
+	minute := at.Unix() / 60
+	slot := &r.slots[minute%seriesMinutes]
+	if slot.minute != minute || slot.count == 0 {
+		*slot = minuteSlot{minute: minute, min: math.Inf(1), max: math.Inf(-1)}
+	}
+	slot.count++
+	if reason != "" {
+		slot.reasons.add(reason)
+	} else {
+		slot.success++
+		slot.sum += ms
+		slot.min = math.Min(slot.min, ms)
+		slot.max = math.Max(slot.max, ms)
+	}
+}
+
+// snapshot returns the aggregates of the 24 hours up to now, oldest first,
+// skipping minutes without samples
+func (r *minuteRing) snapshot(now time.Time) []MinuteAggregate {
+	current := now.Unix() / 60
+	var out []MinuteAggregate
+	// A clock near the epoch, as on a board without a real-time clock, has
+	// fewer minutes behind it than the window
+	for minute := max(current-seriesMinutes+1, 0); minute <= current; minute++ {
+		slot := r.slots[minute%seriesMinutes]
+		if slot.minute != minute || slot.count == 0 {
+			continue
+		}
+		agg := MinuteAggregate{
+			Minute:   time.Unix(minute*60, 0),
+			Count:    slot.count,
+			Success:  slot.success,
+			Loss:     float64(slot.count-slot.success) / float64(slot.count) * 100,
+			Failures: slot.reasons.toMap(),
+		}
+		if slot.success > 0 {
+			agg.Min = slot.min
+			agg.Max = slot.max
+			agg.Avg = slot.sum / float64(slot.success)
+		}
+		out = append(out, agg)
+	}
+	return out
+}
+
+// seriesStore keeps a minute ring per target. It is independent of the
+// daily reset, so recent history survives report rollovers.
+type seriesStore struct {
+	mutex   sync.Mutex
+	targets map[string]*minuteRing
+}
+
+// newSeriesStore creates an empty store
+func newSeriesStore() *seriesStore {
+	return &seriesStore{targets: make(map[string]*minuteRing)}
+}
+
+// add records one sample for target
+func (s *seriesStore) add(target string, at time.Time, reason failureReason, ms float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, ok := s.targets[target]
+	if !ok {
+		r = &minuteRing{}
+		s.targets[target] = r
+	}
+	r.add(at, reason, ms)
+}
+
+// snapshot returns a copy of the last 24 hours for target
+func (s *seriesStore) snapshot(target string, now time.Time) []MinuteAggregate {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, ok := s.targets[target]
+	if !ok {
+		return []MinuteAggregate{}
+	}
+	out := r.snapshot(now)
+	if out == nil {
+		out = []MinuteAggregate{}
+	}
+	return out
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ping-monitor/api"
+)
+
+// apiServer serves the monitor's HTTP endpoints over TCP and, when
+// configured, over the control transport
+type apiServer struct {
+	pm        *PingMonitor
+	server    *http.Server
+	transport controlTransport
+	control   *http.Server
+}
+
+// DebugState represents the /debug/state response
+type DebugState struct {
+	Config           Config   `json:"config"`
+	TargetIP         string   `json:"target_ip"`
+	Gateways         []string `json:"gateways"`
+	LocalIP          string   `json:"local_ip"`
+	SuccessCount     int      `json:"success_count"`
+	UnreachableCount int      `json:"unreachable_count"`
+	Running          bool     `json:"running"`
+	// RecentResults are the latest results of the target, oldest first
+	RecentResults []ResultRecord `json:"recent_results"`
+}
+
+// newAPIServer creates the HTTP server for the monitor
+func newAPIServer(pm *PingMonitor) *apiServer {
+	s := &apiServer{pm: pm, transport: newControlTransport(pm.config)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config", s.requireScope(scopeAdmin, s.handleConfig))
+	mux.HandleFunc("GET /debug/state", s.requireScope(scopeAdmin, s.handleDebugState))
+	mux.HandleFunc("GET /debug/failures", s.requireScope(scopeAdmin, versioned(api.SchemaFailures, s.handleDebugFailures)))
+	mux.HandleFunc("GET /audit", s.requireScope(scopeAdmin, versioned(api.SchemaAudit, s.handleAudit)))
+	mux.HandleFunc("GET /report/today", s.requireScope(scopeRead, s.handleReportToday))
+	mux.HandleFunc("GET /status", s.requireScope(scopeRead, versioned(api.SchemaStatus, s.handleStatus)))
+	mux.HandleFunc("GET /api/v1/series", s.requireScope(scopeRead, versioned(api.SchemaSeries, s.handleSeries)))
+	mux.HandleFunc("GET /api/v1/results", s.requireScope(scopeRead, versioned(api.SchemaResults, s.handleResults)))
+	mux.HandleFunc("GET /api/v1/daily", s.requireScope(scopeRead, versioned(api.SchemaDaily, s.handleDaily)))
+	mux.HandleFunc("GET /api/v1/stream", s.requireScope(scopeRead, s.handleStream))
+	// Lists the versions of the responses; it needs no token, as it tells
+	// nothing about the monitor
+	mux.HandleFunc("GET /schema", handleSchema)
+	if pm.config.MetricsPublic {
+		mux.HandleFunc("GET /metrics", s.handleMetrics)
+	} else {
+		mux.HandleFunc("GET /metrics", s.requireScope(scopeRead, s.handleMetrics))
+	}
+	mux.HandleFunc("POST /simulate/outage", s.requireScope(scopeControl, versioned(api.SchemaSimulate, s.handleSimulateOutage)))
+	mux.HandleFunc("POST /report/resend", s.requireScope(scopeControl, versioned(api.SchemaReportDelivery, s.handleResendReport)))
+	mux.HandleFunc("POST /ingest", s.handleIngest)
+	mux.HandleFunc("GET /verdict", versioned(api.SchemaVerdict, s.handleVerdict))
+	mux.HandleFunc("GET /healthz", versioned(api.SchemaHealth, s.handleHealthz))
+	// The token in the query authenticates these, so a phone can use them
+	mux.HandleFunc("GET /snooze", s.handleSnoozePage)
+	mux.HandleFunc("POST /snooze", s.handleSnooze)
+
+	s.server = &http.Server{
+		Addr:              pm.config.HTTPListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// start begins serving in the background; the TCP listener only when an
+// address is configured
+func (s *apiServer) start() {
+	if s.server.Addr != "" {
+		fmt.Printf("HTTP APIを %s で待ち受けます\n", s.server.Addr)
+		go func() {
+			if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("❌ HTTP APIエラー: %v\n", err)
+			}
+		}()
+	}
+	if s.transport != nil {
+		s.startControl(s.transport, s.server.Handler)
+	}
+}
+
+// shutdown stops the servers, waiting briefly for in-flight requests
+func (s *apiServer) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+	if s.control != nil {
+		s.control.Shutdown(ctx)
+	}
+}
+
+// handleConfig returns the effective configuration with secrets masked
+func (s *apiServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, redactConfig(s.pm.config))
+}
+
+// handleDebugState returns the internal monitor state
+func (s *apiServer) handleDebugState(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pm.debugState())
+}
+
+// handleStatus returns the confirmed state and notifier delivery status
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pm.status())
+}
+
+// handleVerdict reports the confirmed internet state for external uptime
+// checkers: 200 while up, 503 while down. It needs no token since most
+// checkers cannot send one, and it exposes nothing beyond the state.
+func (s *apiServer) handleVerdict(w http.ResponseWriter, r *http.Request) {
+	down, since := s.pm.outages.state()
+	if down {
+		writeJSON(w, http.StatusServiceUnavailable, VerdictResponse{Schema: api.SchemaVerdict, Internet: "down", Since: since})
+		return
+	}
+	writeJSON(w, http.StatusOK, VerdictResponse{Schema: api.SchemaVerdict, Internet: "up", Since: since})
+}
+
+// handleSeries returns the per-minute aggregates of the last 24 hours
+func (s *apiServer) handleSeries(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		target = s.pm.targetIP
+	}
+	key := target
+	if target != s.pm.targetIP {
+		if s.pm.targetLeg(target) == nil {
+			http.Error(w, "unknown target: "+target, http.StatusNotFound)
+			return
+		}
+		key = targetSeriesKey(target)
+	}
+	vantage := r.URL.Query().Get("vantage")
+	if vantage != "" {
+		if s.pm.vantage(vantage) == nil {
+			http.Error(w, "unknown vantage: "+vantage, http.StatusNotFound)
+			return
+		}
+		key = vantageSeriesKey(vantage)
+	}
+	writeJSON(w, http.StatusOK, SeriesResponse{Schema: api.SchemaSeries, Target: target, Vantage: vantage, Minutes: s.pm.series.snapshot(key, s.pm.now())})
+}
+
+// SimulateOutageRequest represents the /simulate/outage request body
+type SimulateOutageRequest struct {
+	Target   string `json:"target"`
+	Duration string `json:"duration"`
+}
+
+// maxSimulatedOutage bounds how long a single simulation may run
+const maxSimulatedOutage = 24 * time.Hour
+
+// handleSimulateOutage overrides probe results for a target as failures
+func (s *apiServer) handleSimulateOutage(w http.ResponseWriter, r *http.Request) {
+	var req SimulateOutageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 || duration > maxSimulatedOutage {
+		http.Error(w, fmt.Sprintf("duration must be between 0 and %v: %q", maxSimulatedOutage, req.Duration), http.StatusBadRequest)
+		return
+	}
+	if req.Target == "" {
+		req.Target = s.pm.targetIP
+	}
+	if req.Target != s.pm.targetIP {
+		http.Error(w, "unknown target: "+req.Target, http.StatusNotFound)
+		return
+	}
+
+	until := s.pm.faults.inject(req.Target, duration)
+	s.auditRequest(r, "simulate_outage", map[string]string{"target": req.Target, "duration": duration.String()})
+	fmt.Printf("🧪 [SIMULATED] %s への擬似障害を %v 間注入します\n", req.Target, duration)
+	writeJSON(w, http.StatusOK, SimulateOutageResponse{Schema: api.SchemaSimulate, Target: req.Target, Until: until})
+}
+
+// ResendReportRequest represents the /report/resend request body
+type ResendReportRequest struct {
+	Date string `json:"date"`
+}
+
+// handleResendReport sends a stored daily report to Discord again
+func (s *apiServer) handleResendReport(w http.ResponseWriter, r *http.Request) {
+	var req ResendReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := time.ParseInLocation(reportDateLayout, req.Date, time.Local); err != nil {
+		http.Error(w, "date must be YYYY-MM-DD: "+req.Date, http.StatusBadRequest)
+		return
+	}
+	s.auditRequest(r, "resend_report", map[string]string{"date": req.Date})
+	d, err := s.pm.resendReport(req.Date)
+	switch {
+	case errors.Is(err, errReportNotStored):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case d == nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	fmt.Printf("📨 %sの日次レポートを再送しました\n", req.Date)
+	writeJSON(w, http.StatusOK, ResendReportResponse{Schema: api.SchemaReportDelivery, ReportDelivery: d})
+}
+
+// writeJSON writes v as an indented JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// simulateDroppedKeys are config keys a simulation never takes from the
+// config file: nothing leaves the host, and only the script is probed
+var simulateDroppedKeys = []string{
+	"discord_webhook_url", "gotify", "bark", "twilio", "discord_bot", "snmp",
+	"router_snmp", "vantages", "probe_command", "peer", "report_to",
+	"collector", "profiles", "self_protection", "http_listen", "report_dir",
+	"targets",
+}
+
+// simulateOptions is the value of -simulate, e.g.
+// "speed=3600x scenario=flaky.json record=events.jsonl"
+type simulateOptions struct {
+	// speed is virtual seconds per real second; 0 runs as fast as possible
+	speed    float64
+	scenario string
+	// record is the JSONL file the notifications are appended to
+	record string
+}
+
+// parseSimulateOptions reads key=value pairs separated by spaces or commas
+func parseSimulateOptions(s string) (simulateOptions, error) {
+	var opts simulateOptions
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return opts, fmt.Errorf("-simulate の値が正しくありません: %q (key=value の形式)", field)
+		}
+		switch key {
+		case "speed":
+			if value == "max" {
+				opts.speed = 0
+				continue
+			}
+			speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+			if err != nil || speed <= 0 {
+				return opts, fmt.Errorf("-simulate の speed の値が正しくありません: %q (例: 3600x、max)", value)
+			}
+			opts.speed = speed
+		case "scenario":
+			opts.scenario = value
+		case "record":
+			opts.record = value
+		default:
+			return opts, fmt.Errorf("-simulate のキーが正しくありません: %q (speed / scenario / record)", key)
+		}
+	}
+	if opts.scenario == "" {
+		return opts, fmt.Errorf("-simulate に scenario=<シナリオファイル> を指定してください")
+	}
+	return opts, nil
+}
+
+// recordNotifier stands in for every notifier of a simulation: events are
+// printed and, with record=, appended to a JSONL file
+type recordNotifier struct {
+	mutex sync.Mutex
+	path  string
+	count int
+}
+
+func (r *recordNotifier) Name() string { return "recorder" }
+
+// Notify prints ev and appends it to the record file
+func (r *recordNotifier) Notify(ev Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.count++
+	fmt.Printf("📼 %s [%s] %s\n", ev.Time.Format("2006-01-02 15:04:05"), ev.Severity, ev.DisplayTitle())
+	if r.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(ev)
+}
+
+// simulationConfig returns the keys of the config file, if there is one,
+// with the scenario's config on top. State, results and reports go to dir
+// unless the scenario names its own.
+func simulationConfig(configPath string, sc *Scenario, dir string) (map[string]interface{}, error) {
+	cfg := map[string]interface{}{}
+	if data, err := os.ReadFile(configPath); err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("設定ファイル %s の形式が正しくありません: %v", configPath, err)
+		}
+	}
+	for _, key := range simulateDroppedKeys {
+		delete(cfg, key)
+	}
+	cfg["state_dir"] = filepath.Join(dir, "state")
+	cfg["results_file"] = filepath.Join(dir, "results.jsonl")
+	cfg["report_dir"] = filepath.Join(dir, "reports")
+	cfg["control_socket"] = controlSocketOff
+	if len(sc.Config) > 0 {
+		if err := json.Unmarshal(sc.Config, &cfg); err != nil {
+			return nil, fmt.Errorf("config の形式が正しくありません: %v", err)
+		}
+	}
+	return cfg, nil
+}
+
+// runSimulation runs the monitor through a scenario on a virtual clock
+// going speed times faster than the real one. Rollovers, alerts and
+// reports all follow the virtual clock; the notifiers are replaced by a
+// recorder, and the HTTP API answers on listen if given.
+func runSimulation(configPath, spec, listen string) int {
+	opts, err := parseSimulateOptions(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 2
+	}
+	sc, start, interval, err := loadScenario(opts.scenario)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	dir, err := os.MkdirTemp("", "ping-monitor-simulate")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	cfg, err := simulationConfig(configPath, sc, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	if listen != "" {
+		cfg["http_listen"] = listen
+	}
+
+	// The API reads the clock from its own goroutines
+	var clock atomic.Int64
+	clock.Store(start.UnixNano())
+	now := func() time.Time { return time.Unix(0, clock.Load()).In(start.Location()) }
+	pm, prober, err := newScenarioMonitor(sc, start, interval, cfg, dir, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	recorder := &recordNotifier{path: opts.record}
+	quiet := pm.dispatcher.quiet
+	pm.dispatcher.close()
+	pm.dispatcher = newDispatcher([]Notifier{recorder}, quiet, nil, pm.style, now)
+	if pm.config.HTTPListen != "" {
+		pm.api = newAPIServer(pm)
+		pm.api.start()
+		defer pm.api.shutdown()
+	}
+
+	speed := "最大"
+	if opts.speed > 0 {
+		speed = fmt.Sprintf("%gx", opts.speed)
+	}
+	end := start.Add(time.Duration(prober.total) * interval)
+	fmt.Printf("⏩ シミュレーション: %s 〜 %s（%d回、速度 %s）\n", start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"), prober.total, speed)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	realStart := time.Now()
+	done := 0
+run:
+	for ; done < prober.total; done++ {
+		select {
+		case <-interrupt:
+			fmt.Println("⏹ 中断しました。ここまでの期間でレポートを締めます")
+			break run
+		default:
+		}
+		at := start.Add(time.Duration(done) * interval)
+		if opts.speed > 0 {
+			// Paced against the start, so a slow tick is caught up on
+			due := realStart.Add(time.Duration(float64(at.Sub(start)) / opts.speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		clock.Store(at.UnixNano())
+		pm.tick(at)
+		pm.reports.flush()
+		pm.dispatcher.flush()
+	}
+	pm.reports.shutdown()
+	pm.dispatcher.close()
+
+	fmt.Printf("🏁 シミュレーション終了: 仮想時間 %s を実時間 %s で実行（%d回のping、通知 %d件）\n",
+		pm.format.duration(time.Duration(done)*interval), time.Since(realStart).Round(time.Millisecond), done, recorder.count)
+	if opts.record != "" {
+		fmt.Printf("  通知の記録: %s\n", opts.record)
+	}
+	fmt.Printf("  状態・結果・レポート: %s\n", dir)
+	return 0
+}
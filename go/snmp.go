@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SNMPConfig configures the read-only SNMP v2c agent
+type SNMPConfig struct {
+	Listen    string `json:"listen"`
+	Community string `json:"community" secret:"true"`
+}
+
+// snmpBaseOID is the root of the exported subtree. It lives under
+// net-snmp's experimental "netSnmpPlaypen" arc, since this tool has no
+// enterprise number of its own.
+var snmpBaseOID = snmpOID{1, 3, 6, 1, 4, 1, 8072, 9999, 9999, 1}
+
+// BER tags used by SNMP (RFC 1157, RFC 3416)
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	berCounter32   = 0x41
+	berGauge32     = 0x42
+	berTimeTicks   = 0x43
+
+	snmpNoSuchObject   = 0x80
+	snmpNoSuchInstance = 0x81
+	snmpEndOfMibView   = 0x82
+
+	pduGetRequest     = 0xa0
+	pduGetNextRequest = 0xa1
+	pduResponse       = 0xa2
+	pduSetRequest     = 0xa3
+	pduGetBulkRequest = 0xa5
+)
+
+// SNMP error-status values
+const (
+	snmpErrTooBig      = 1
+	snmpErrNotWritable = 17
+)
+
+// snmpVersion2c is the version field of a v2c message
+const snmpVersion2c = 1
+
+// Limits keeping responses within a single unfragmented UDP datagram
+const (
+	snmpMaxResponse       = 1400
+	snmpMaxRepetitions    = 50
+	snmpMaxBulkVarBinds   = 100
+	snmpMaxRequestVarBind = 64
+)
+
+// errBER is returned for malformed packets
+var errBER = errors.New("malformed BER")
+
+// snmpOID is an object identifier
+type snmpOID []uint32
+
+// String formats the OID in dotted notation
+func (o snmpOID) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// compare orders OIDs lexicographically, as GETNEXT requires
+func (o snmpOID) compare(other snmpOID) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		if o[i] != other[i] {
+			if o[i] < other[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(o) - len(other)
+}
+
+// child returns o extended by arcs
+func (o snmpOID) child(arcs ...uint32) snmpOID {
+	out := make(snmpOID, 0, len(o)+len(arcs))
+	return append(append(out, o...), arcs...)
+}
+
+// snmpValue is an encoded-ready variable binding value
+type snmpValue struct {
+	tag byte
+	// content is the BER content octets
+	content []byte
+}
+
+// snmpVarBind is one name/value pair
+type snmpVarBind struct {
+	name  snmpOID
+	value snmpValue
+}
+
+// snmpPDU is a decoded request or a response to encode
+type snmpPDU struct {
+	typ         byte
+	requestID   int64
+	errorStatus int64 // non-repeaters for GetBulk
+	errorIndex  int64 // max-repetitions for GetBulk
+	varBinds    []snmpVarBind
+}
+
+// snmpMessage is a decoded v2c message
+type snmpMessage struct {
+	version   int64
+	community string
+	pdu       snmpPDU
+}
+
+// berLength encodes a definite length
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var digits []byte
+	for ; n > 0; n >>= 8 {
+		digits = append([]byte{byte(n)}, digits...)
+	}
+	return append([]byte{0x80 | byte(len(digits))}, digits...)
+}
+
+// berTLV encodes one tag-length-value
+func berTLV(tag byte, content []byte) []byte {
+	out := append([]byte{tag}, berLength(len(content))...)
+	return append(out, content...)
+}
+
+// berIntContent encodes a signed integer in minimal two's complement
+func berIntContent(v int64) []byte {
+	var out []byte
+	for {
+		out = append([]byte{byte(v)}, out...)
+		// Stop once the remaining value is the sign extension of the top bit
+		if v < 128 && v >= -128 {
+			return out
+		}
+		v >>= 8
+	}
+}
+
+// berUintContent encodes an unsigned value (Counter32, Gauge32, TimeTicks)
+func berUintContent(v uint32) []byte {
+	return berIntContent(int64(v))
+}
+
+// berOIDContent encodes OID arcs in base 128
+func berOIDContent(o snmpOID) []byte {
+	if len(o) < 2 {
+		return []byte{0}
+	}
+	var out []byte
+	appendArc := func(n uint32) {
+		var arc []byte
+		arc = append(arc, byte(n&0x7f))
+		for n >>= 7; n > 0; n >>= 7 {
+			arc = append([]byte{byte(n&0x7f) | 0x80}, arc...)
+		}
+		out = append(out, arc...)
+	}
+	appendArc(o[0]*40 + o[1])
+	for _, n := range o[2:] {
+		appendArc(n)
+	}
+	return out
+}
+
+// berReadTLV splits the first TLV off b
+func berReadTLV(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBER
+	}
+	tag = b[0]
+	length := int(b[1])
+	b = b[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 || len(b) < n {
+			return 0, nil, nil, errBER
+		}
+		length = 0
+		for _, d := range b[:n] {
+			length = length<<8 | int(d)
+		}
+		b = b[n:]
+	}
+	if length > len(b) {
+		return 0, nil, nil, errBER
+	}
+	return tag, b[:length], b[length:], nil
+}
+
+// berParseInt decodes a two's complement integer
+func berParseInt(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, errBER
+	}
+	v := int64(int8(content[0]))
+	for _, d := range content[1:] {
+		v = v<<8 | int64(d)
+	}
+	return v, nil
+}
+
+// berParseOID decodes OID content octets
+func berParseOID(content []byte) (snmpOID, error) {
+	if len(content) == 0 {
+		return nil, errBER
+	}
+	var arcs []uint32
+	var n uint64
+	for i, d := range content {
+		n = n<<7 | uint64(d&0x7f)
+		if n > math.MaxUint32 {
+			return nil, errBER
+		}
+		if d&0x80 != 0 {
+			if i == len(content)-1 {
+				return nil, errBER
+			}
+			continue
+		}
+		if len(arcs) == 0 {
+			first := uint32(min(n/40, 2))
+			arcs = append(arcs, first, uint32(n)-first*40)
+		} else {
+			arcs = append(arcs, uint32(n))
+		}
+		n = 0
+	}
+	return arcs, nil
+}
+
+// berReadInt reads an INTEGER TLV
+func berReadInt(b []byte) (int64, []byte, error) {
+	tag, content, rest, err := berReadTLV(b)
+	if err != nil || tag != berInteger {
+		return 0, nil, errBER
+	}
+	v, err := berParseInt(content)
+	return v, rest, err
+}
+
+// decodeSNMPMessage parses a v2c message
+func decodeSNMPMessage(packet []byte) (*snmpMessage, error) {
+	tag, body, _, err := berReadTLV(packet)
+	if err != nil || tag != berSequence {
+		return nil, errBER
+	}
+	msg := &snmpMessage{}
+	if msg.version, body, err = berReadInt(body); err != nil {
+		return nil, err
+	}
+	tag, community, body, err := berReadTLV(body)
+	if err != nil || tag != berOctetString {
+		return nil, errBER
+	}
+	msg.community = string(community)
+
+	tag, pdu, _, err := berReadTLV(body)
+	if err != nil {
+		return nil, err
+	}
+	msg.pdu.typ = tag
+	if msg.pdu.requestID, pdu, err = berReadInt(pdu); err != nil {
+		return nil, err
+	}
+	if msg.pdu.errorStatus, pdu, err = berReadInt(pdu); err != nil {
+		return nil, err
+	}
+	if msg.pdu.errorIndex, pdu, err = berReadInt(pdu); err != nil {
+		return nil, err
+	}
+	tag, list, _, err := berReadTLV(pdu)
+	if err != nil || tag != berSequence {
+		return nil, errBER
+	}
+	for len(list) > 0 {
+		if len(msg.pdu.varBinds) >= snmpMaxRequestVarBind {
+			return nil, errBER
+		}
+		var vb []byte
+		tag, vb, list, err = berReadTLV(list)
+		if err != nil || tag != berSequence {
+			return nil, errBER
+		}
+		tag, oidContent, vb, err := berReadTLV(vb)
+		if err != nil || tag != berOID {
+			return nil, errBER
+		}
+		name, err := berParseOID(oidContent)
+		if err != nil {
+			return nil, err
+		}
+		tag, value, _, err := berReadTLV(vb)
+		if err != nil {
+			return nil, err
+		}
+		msg.pdu.varBinds = append(msg.pdu.varBinds, snmpVarBind{name: name, value: snmpValue{tag: tag, content: value}})
+	}
+	return msg, nil
+}
+
+// encode serializes a v2c message
+func (m *snmpMessage) encode() []byte {
+	var list []byte
+	for _, vb := range m.pdu.varBinds {
+		list = append(list, berTLV(berSequence, append(berTLV(berOID, berOIDContent(vb.name)), berTLV(vb.value.tag, vb.value.content)...))...)
+	}
+	var pdu []byte
+	pdu = append(pdu, berTLV(berInteger, berIntContent(m.pdu.requestID))...)
+	pdu = append(pdu, berTLV(berInteger, berIntContent(m.pdu.errorStatus))...)
+	pdu = append(pdu, berTLV(berInteger, berIntContent(m.pdu.errorIndex))...)
+	pdu = append(pdu, berTLV(berSequence, list)...)
+
+	var body []byte
+	body = append(body, berTLV(berInteger, berIntContent(m.version))...)
+	body = append(body, berTLV(berOctetString, []byte(m.community))...)
+	body = append(body, berTLV(m.pdu.typ, pdu)...)
+	return berTLV(berSequence, body)
+}
+
+// snmpMIB is a sorted snapshot of the exported objects
+type snmpMIB []snmpVarBind
+
+// get returns the value of an exact instance
+func (m snmpMIB) get(name snmpOID) snmpValue {
+	i := sort.Search(len(m), func(i int) bool { return m[i].name.compare(name) >= 0 })
+	if i < len(m) && m[i].name.compare(name) == 0 {
+		return m[i].value
+	}
+	// An object column without this instance is noSuchInstance
+	for _, vb := range m {
+		if len(name) > 0 && snmpOID(vb.name[:len(vb.name)-1]).compare(name[:len(name)-1]) == 0 {
+			return snmpValue{tag: snmpNoSuchInstance}
+		}
+	}
+	return snmpValue{tag: snmpNoSuchObject}
+}
+
+// next returns the first instance after name, or endOfMibView
+func (m snmpMIB) next(name snmpOID) snmpVarBind {
+	i := sort.Search(len(m), func(i int) bool { return m[i].name.compare(name) > 0 })
+	if i < len(m) {
+		return m[i]
+	}
+	return snmpVarBind{name: name, value: snmpValue{tag: snmpEndOfMibView}}
+}
+
+// snmpMIBSnapshot builds the exported objects from the monitor state:
+//
+//	base.1.0           monitorUptime     TimeTicks
+//	base.2.1.1.<i>     targetName        OCTET STRING
+//	base.2.1.2.<i>     targetLastRTT     Gauge32 (microseconds)
+//	base.2.1.3.<i>     targetSuccessRate Gauge32 (hundredths of a percent, today)
+//	base.2.1.4.<i>     targetOutages     Counter32 (since start)
+//	base.2.1.5.<i>     targetUp          INTEGER (1 up, 2 down)
+func (pm *PingMonitor) snmpMIBSnapshot() snmpMIB {
+	s := pm.status()
+	pm.metrics.mutex.Lock()
+	lastRTT, outages := pm.metrics.lastRTT, pm.metrics.outages
+	pm.metrics.mutex.Unlock()
+
+	uptime := uint32(time.Since(pm.startedAt) / (10 * time.Millisecond))
+	rate := uint32(0)
+	if total := s.SuccessCount + s.UnreachableCount; total > 0 {
+		rate = uint32(math.Round(float64(s.SuccessCount) / float64(total) * 10000))
+	}
+	up := int64(1)
+	if s.Internet == "down" {
+		up = 2
+	}
+
+	table := snmpBaseOID.child(2, 1)
+	index := uint32(1)
+	mib := snmpMIB{
+		{snmpBaseOID.child(1, 0), snmpValue{berTimeTicks, berUintContent(uptime)}},
+		{table.child(1, index), snmpValue{berOctetString, []byte(s.TargetIP)}},
+		{table.child(2, index), snmpValue{berGauge32, berUintContent(uint32(math.Round(lastRTT * 1000)))}},
+		{table.child(3, index), snmpValue{berGauge32, berUintContent(rate)}},
+		{table.child(4, index), snmpValue{berCounter32, berUintContent(uint32(outages))}},
+		{table.child(5, index), snmpValue{berInteger, berIntContent(up)}},
+	}
+	sort.Slice(mib, func(i, j int) bool { return mib[i].name.compare(mib[j].name) < 0 })
+	return mib
+}
+
+// snmpRespond computes the response to a request, or nil to drop it
+func snmpRespond(req *snmpMessage, community string, mib snmpMIB) *snmpMessage {
+	// Wrong communities and other versions are dropped silently (RFC 3584)
+	if req.version != snmpVersion2c || req.community != community {
+		return nil
+	}
+	resp := &snmpMessage{version: req.version, community: req.community}
+	resp.pdu = snmpPDU{typ: pduResponse, requestID: req.pdu.requestID}
+
+	switch req.pdu.typ {
+	case pduGetRequest:
+		for _, vb := range req.pdu.varBinds {
+			resp.pdu.varBinds = append(resp.pdu.varBinds, snmpVarBind{name: vb.name, value: mib.get(vb.name)})
+		}
+	case pduGetNextRequest:
+		for _, vb := range req.pdu.varBinds {
+			resp.pdu.varBinds = append(resp.pdu.varBinds, mib.next(vb.name))
+		}
+	case pduGetBulkRequest:
+		resp.pdu.varBinds = snmpBulk(req.pdu, mib)
+	case pduSetRequest:
+		resp.pdu.varBinds = req.pdu.varBinds
+		resp.pdu.errorStatus, resp.pdu.errorIndex = snmpErrNotWritable, 1
+		return resp
+	default:
+		return nil
+	}
+
+	// GetBulk responses may be shortened; others report tooBig
+	for len(resp.encode()) > snmpMaxResponse {
+		if req.pdu.typ != pduGetBulkRequest || len(resp.pdu.varBinds) <= 1 {
+			resp.pdu.varBinds = req.pdu.varBinds
+			resp.pdu.errorStatus, resp.pdu.errorIndex = snmpErrTooBig, 0
+			if len(resp.encode()) > snmpMaxResponse {
+				resp.pdu.varBinds = nil
+			}
+			break
+		}
+		resp.pdu.varBinds = resp.pdu.varBinds[:len(resp.pdu.varBinds)-1]
+	}
+	return resp
+}
+
+// snmpBulk answers GetBulk: the first non-repeaters bindings get one
+// successor each, the rest up to max-repetitions successors (RFC 3416 4.2.3)
+func snmpBulk(pdu snmpPDU, mib snmpMIB) []snmpVarBind {
+	nonRepeaters := int(max(pdu.errorStatus, 0))
+	repetitions := int(min(max(pdu.errorIndex, 0), snmpMaxRepetitions))
+	nonRepeaters = min(nonRepeaters, len(pdu.varBinds))
+
+	var out []snmpVarBind
+	for _, vb := range pdu.varBinds[:nonRepeaters] {
+		out = append(out, mib.next(vb.name))
+	}
+	cursors := make([]snmpOID, 0, len(pdu.varBinds)-nonRepeaters)
+	for _, vb := range pdu.varBinds[nonRepeaters:] {
+		cursors = append(cursors, vb.name)
+	}
+	for r := 0; r < repetitions && len(cursors) > 0; r++ {
+		allEnd := true
+		for i, cursor := range cursors {
+			if len(out) >= snmpMaxBulkVarBinds {
+				return out
+			}
+			next := mib.next(cursor)
+			out = append(out, next)
+			cursors[i] = next.name
+			allEnd = allEnd && next.value.tag == snmpEndOfMibView
+		}
+		if allEnd {
+			break
+		}
+	}
+	return out
+}
+
+// snmpAgent serves the MIB over UDP
+type snmpAgent struct {
+	pm   *PingMonitor
+	cfg  SNMPConfig
+	conn net.PacketConn
+}
+
+// newSNMPAgent creates the agent; config values are validated by
+// Config.validate
+func newSNMPAgent(pm *PingMonitor, cfg SNMPConfig) *snmpAgent {
+	return &snmpAgent{pm: pm, cfg: cfg}
+}
+
+// start opens the UDP socket and serves requests in the background
+func (a *snmpAgent) start() error {
+	conn, err := net.ListenPacket("udp", a.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	a.conn = conn
+	fmt.Printf("SNMPエージェントを %s で待ち受けます（読み取り専用、v2c）\n", a.cfg.Listen)
+	go a.serve()
+	return nil
+}
+
+// serve handles datagrams until the socket is closed
+func (a *snmpAgent) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fmt.Printf("❌ SNMPエラー: %v\n", err)
+			}
+			return
+		}
+		req, err := decodeSNMPMessage(bytes.Clone(buf[:n]))
+		if err != nil {
+			continue
+		}
+		resp := snmpRespond(req, a.cfg.Community, a.pm.snmpMIBSnapshot())
+		if resp == nil {
+			continue
+		}
+		a.conn.WriteTo(resp.encode(), addr)
+	}
+}
+
+// shutdown closes the socket
+func (a *snmpAgent) shutdown() {
+	if a.conn != nil {
+		a.conn.Close()
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"slices"
+	"testing"
+	"time"
+)
+
+// sysDescrGet is what net-snmp's snmpget -v2c -c public sends for
+// sysDescr.0 with request-id 1
+const sysDescrGet = "302602010104067075626c6963a019020101020100020100300e300c06082b060102010101000500"
+
+func TestBERInteger(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want string
+	}{
+		{0, "00"},
+		{127, "7f"},
+		{128, "0080"},
+		{255, "00ff"},
+		{256, "0100"},
+		{-1, "ff"},
+		{-128, "80"},
+		{-129, "ff7f"},
+		{1<<31 - 1, "7fffffff"},
+		{-1 << 31, "80000000"},
+	}
+	for _, tt := range tests {
+		got := berIntContent(tt.v)
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("%d: %x, want %s", tt.v, got, tt.want)
+		}
+		if v, err := berParseInt(got); err != nil || v != tt.v {
+			t.Errorf("%d: parsed back as %d, %v", tt.v, v, err)
+		}
+	}
+	// Unsigned values with the top bit set need a leading zero octet
+	if got := hex.EncodeToString(berUintContent(0xffffffff)); got != "00ffffffff" {
+		t.Errorf("max Counter32: %s", got)
+	}
+	for _, content := range [][]byte{nil, make([]byte, 9)} {
+		if _, err := berParseInt(content); err == nil {
+			t.Errorf("%x parsed", content)
+		}
+	}
+}
+
+func TestBEROID(t *testing.T) {
+	tests := []struct {
+		oid  snmpOID
+		want string
+	}{
+		{snmpOID{1, 3, 6, 1, 2, 1, 1, 1, 0}, "2b06010201010100"},
+		// Arcs of 128 and more take several octets
+		{snmpOID{1, 3, 6, 1, 4, 1, 8072, 9999}, "2b06010401bf08ce0f"},
+		{snmpOID{1, 3, 4294967295}, "2b8fffffff7f"},
+		// The first octet joins the first two arcs, which may exceed 80
+		{snmpOID{2, 999, 3}, "883703"},
+	}
+	for _, tt := range tests {
+		got := berOIDContent(tt.oid)
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("%s: %x, want %s", tt.oid, got, tt.want)
+		}
+		if back, err := berParseOID(got); err != nil || back.compare(tt.oid) != 0 {
+			t.Errorf("%s: parsed back as %s, %v", tt.oid, back, err)
+		}
+	}
+	for _, content := range []string{"", "2b86", "2b9080808000"} {
+		b, _ := hex.DecodeString(content)
+		if o, err := berParseOID(b); err == nil {
+			t.Errorf("%q parsed as %s", content, o)
+		}
+	}
+}
+
+func TestBERLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 255, 256, 1400, 70000} {
+		tlv := berTLV(berOctetString, make([]byte, n))
+		tag, content, rest, err := berReadTLV(append(tlv, 0xff))
+		if err != nil || tag != berOctetString || len(content) != n || !bytes.Equal(rest, []byte{0xff}) {
+			t.Errorf("length %d: tag %x, %d octets, rest %x, %v", n, tag, len(content), rest, err)
+		}
+	}
+	if got := hex.EncodeToString(berLength(300)); got != "82012c" {
+		t.Errorf("length 300: %s", got)
+	}
+	// Indefinite lengths, lengths over 3 octets and lengths past the end
+	for _, b := range []string{"", "04", "0480", "04840000000100", "040501"} {
+		raw, _ := hex.DecodeString(b)
+		if _, _, _, err := berReadTLV(raw); err == nil {
+			t.Errorf("%q read", b)
+		}
+	}
+}
+
+func TestDecodeSNMPMessage(t *testing.T) {
+	packet, err := hex.DecodeString(sysDescrGet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := decodeSNMPMessage(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.version != snmpVersion2c || msg.community != "public" || msg.pdu.typ != pduGetRequest || msg.pdu.requestID != 1 {
+		t.Errorf("decoded %+v", msg)
+	}
+	if len(msg.pdu.varBinds) != 1 || msg.pdu.varBinds[0].name.String() != "1.3.6.1.2.1.1.1.0" || msg.pdu.varBinds[0].value.tag != berNull {
+		t.Errorf("var binds %+v", msg.pdu.varBinds)
+	}
+	// Encoding gives back the same octets
+	if got := msg.encode(); !bytes.Equal(got, packet) {
+		t.Errorf("encoded %x, want %x", got, packet)
+	}
+	for n := 0; n < len(packet); n++ {
+		if _, err := decodeSNMPMessage(packet[:n]); err == nil {
+			t.Errorf("%d of %d octets decoded", n, len(packet))
+		}
+	}
+
+	// More bindings than a request may carry
+	req := &snmpMessage{version: snmpVersion2c, community: "public", pdu: snmpPDU{typ: pduGetRequest}}
+	for i := 0; i <= snmpMaxRequestVarBind; i++ {
+		req.pdu.varBinds = append(req.pdu.varBinds, snmpVarBind{name: snmpOID{1, 3, uint32(i)}, value: snmpValue{tag: berNull}})
+	}
+	if _, err := decodeSNMPMessage(req.encode()); err == nil {
+		t.Errorf("%d var binds decoded", len(req.pdu.varBinds))
+	}
+}
+
+// testMIB is a table of two rows and a scalar
+func testMIB() snmpMIB {
+	table := snmpBaseOID.child(2, 1)
+	return snmpMIB{
+		{snmpBaseOID.child(1, 0), snmpValue{berTimeTicks, berUintContent(100)}},
+		{table.child(1, 1), snmpValue{berOctetString, []byte("8.8.8.8")}},
+		{table.child(1, 2), snmpValue{berOctetString, []byte("1.1.1.1")}},
+		{table.child(5, 1), snmpValue{berInteger, berIntContent(1)}},
+		{table.child(5, 2), snmpValue{berInteger, berIntContent(2)}},
+	}
+}
+
+// request builds a request of typ for names
+func request(typ byte, community string, names ...snmpOID) *snmpMessage {
+	req := &snmpMessage{version: snmpVersion2c, community: community, pdu: snmpPDU{typ: typ, requestID: 42}}
+	for _, name := range names {
+		req.pdu.varBinds = append(req.pdu.varBinds, snmpVarBind{name: name, value: snmpValue{tag: berNull}})
+	}
+	return req
+}
+
+// names lists the names of the bindings
+func names(vbs []snmpVarBind) []string {
+	var out []string
+	for _, vb := range vbs {
+		out = append(out, vb.name.String()+"="+hex.EncodeToString([]byte{vb.value.tag}))
+	}
+	return out
+}
+
+func TestSNMPRespond(t *testing.T) {
+	mib := testMIB()
+	base := snmpBaseOID.String()
+	table := snmpBaseOID.child(2, 1)
+
+	resp := snmpRespond(request(pduGetRequest, "c", snmpBaseOID.child(1, 0), table.child(1, 3), snmpBaseOID.child(7, 0)), "c", mib)
+	want := []string{base + ".1.0=43", base + ".2.1.1.3=81", base + ".7.0=80"}
+	if resp == nil || resp.pdu.typ != pduResponse || resp.pdu.requestID != 42 || !slices.Equal(names(resp.pdu.varBinds), want) {
+		t.Fatalf("get: %+v", resp)
+	}
+
+	// Walking with GETNEXT visits every object in order, then ends
+	var walked []string
+	cursor := snmpBaseOID
+	for i := 0; i < 10; i++ {
+		resp := snmpRespond(request(pduGetNextRequest, "c", cursor), "c", mib)
+		vb := resp.pdu.varBinds[0]
+		if vb.value.tag == snmpEndOfMibView {
+			break
+		}
+		walked = append(walked, vb.name.String())
+		cursor = vb.name
+	}
+	want = []string{base + ".1.0", base + ".2.1.1.1", base + ".2.1.1.2", base + ".2.1.5.1", base + ".2.1.5.2"}
+	if !slices.Equal(walked, want) {
+		t.Errorf("walk %v, want %v", walked, want)
+	}
+
+	// One non-repeater, then two repetitions of two columns, the last
+	// past the end of the MIB
+	bulk := request(pduGetBulkRequest, "c", snmpBaseOID, table.child(1), table.child(5, 1))
+	bulk.pdu.errorStatus, bulk.pdu.errorIndex = 1, 2
+	resp = snmpRespond(bulk, "c", mib)
+	want = []string{
+		base + ".1.0=43",
+		base + ".2.1.1.1=04", base + ".2.1.5.2=02",
+		base + ".2.1.1.2=04", base + ".2.1.5.2=82",
+	}
+	if !slices.Equal(names(resp.pdu.varBinds), want) {
+		t.Errorf("bulk %v, want %v", names(resp.pdu.varBinds), want)
+	}
+
+	set := snmpRespond(request(pduSetRequest, "c", snmpBaseOID.child(1, 0)), "c", mib)
+	if set.pdu.errorStatus != snmpErrNotWritable || set.pdu.errorIndex != 1 {
+		t.Errorf("set: %+v", set.pdu)
+	}
+	if snmpRespond(request(pduGetRequest, "wrong", snmpBaseOID), "c", mib) != nil {
+		t.Error("answered a wrong community")
+	}
+	v1 := request(pduGetRequest, "c", snmpBaseOID)
+	v1.version = 0
+	if snmpRespond(v1, "c", mib) != nil {
+		t.Error("answered SNMPv1")
+	}
+	if snmpRespond(request(pduResponse, "c", snmpBaseOID), "c", mib) != nil {
+		t.Error("answered a response")
+	}
+}
+
+// TestSNMPRespondSize checks that responses fit in one datagram
+func TestSNMPRespondSize(t *testing.T) {
+	var mib snmpMIB
+	for i := uint32(1); i <= 200; i++ {
+		mib = append(mib, snmpVarBind{snmpBaseOID.child(2, 1, 1, i), snmpValue{berOctetString, bytes.Repeat([]byte{'x'}, 100)}})
+	}
+
+	// GetBulk is cut short, keeping what fits
+	bulk := request(pduGetBulkRequest, "c", snmpBaseOID)
+	bulk.pdu.errorIndex = 1000
+	resp := snmpRespond(bulk, "c", mib)
+	if n := len(resp.encode()); n > snmpMaxResponse || resp.pdu.errorStatus != 0 || len(resp.pdu.varBinds) < 10 {
+		t.Errorf("bulk: %d octets, %d var binds, error-status %d", n, len(resp.pdu.varBinds), resp.pdu.errorStatus)
+	}
+
+	// A GET too large for one datagram is tooBig with the request's bindings
+	var oids []snmpOID
+	for i := uint32(1); i <= 20; i++ {
+		oids = append(oids, snmpBaseOID.child(2, 1, 1, i))
+	}
+	resp = snmpRespond(request(pduGetRequest, "c", oids...), "c", mib)
+	if resp.pdu.errorStatus != snmpErrTooBig || len(resp.pdu.varBinds) != 20 || resp.pdu.varBinds[0].value.tag != berNull {
+		t.Errorf("get: error-status %d, %d var binds", resp.pdu.errorStatus, len(resp.pdu.varBinds))
+	}
+}
+
+// TestSNMPAgent queries the agent over loopback with the router poller's
+// client
+func TestSNMPAgent(t *testing.T) {
+	quietStdout(t)
+	pm, _ := newTestMonitor(t, nil, time.Now())
+	a := newSNMPAgent(pm, SNMPConfig{Listen: "127.0.0.1:0", Community: "secret"})
+	if err := a.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.shutdown()
+	address := a.conn.LocalAddr().String()
+
+	name := snmpBaseOID.child(2, 1, 1, 1)
+	values, err := snmpGet(address, "secret", []snmpOID{name, snmpBaseOID.child(2, 1, 5, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(values[0].content) != pm.config.Target {
+		t.Errorf("target name %q, want %q", values[0].content, pm.config.Target)
+	}
+	if up, err := berParseInt(values[1].content); values[1].tag != berInteger || err != nil || up != 1 {
+		t.Errorf("targetUp %x %x", values[1].tag, values[1].content)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// outageSnooze holds the acknowledge link of the current outage. The token
+// is an HMAC of the outage start under a key drawn at startup, so it
+// cannot be guessed, and it stops working once the outage recovers or the
+// monitor restarts.
+type outageSnooze struct {
+	mutex   sync.Mutex
+	key     []byte
+	start   time.Time
+	token   string
+	acked   bool
+	ackedAt time.Time
+}
+
+// newOutageSnooze creates the tracker with a fresh random key
+func newOutageSnooze() *outageSnooze {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &outageSnooze{key: key}
+}
+
+// arm issues the token of the outage that started at start
+func (s *outageSnooze) arm(start time.Time) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strconv.FormatInt(start.UnixNano(), 10)))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.start, s.token, s.acked = start, hex.EncodeToString(mac.Sum(nil))[:32], false
+	return s.token
+}
+
+// valid reports whether token belongs to the current outage
+func (s *outageSnooze) valid(token string) bool {
+	return s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// state returns whether token is valid and whether it was already used
+func (s *outageSnooze) state(token string) (valid, acked bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.valid(token), s.acked
+}
+
+// ack acknowledges the current outage; it reports false for an invalid
+// or expired token and used for one acknowledged before
+func (s *outageSnooze) ack(token string, now time.Time) (ok, used bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.valid(token) {
+		return false, false
+	}
+	if s.acked {
+		return true, true
+	}
+	s.acked, s.ackedAt = true, now
+	return true, false
+}
+
+// release expires the token of the outage that started at start and
+// reports whether it had been acknowledged
+func (s *outageSnooze) release(start time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.start.Equal(start) {
+		return false
+	}
+	acked := s.acked
+	s.start, s.token, s.acked = time.Time{}, "", false
+	return acked
+}
+
+// publicBaseURL returns the URL phones on the LAN reach the API at:
+// public_url, or http_listen with an unspecified host replaced by the local
+// IP. A loopback-only listener yields "" since no other device can use it.
+func (pm *PingMonitor) publicBaseURL() string {
+	if pm.config.PublicURL != "" {
+		return pm.config.PublicURL
+	}
+	host, port, err := net.SplitHostPort(pm.config.HTTPListen)
+	if err != nil {
+		return ""
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = pm.localIP
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsLoopback() {
+		return ""
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// snoozeURL returns the acknowledge link for token, or "" when the API is
+// not reachable from other devices
+func (pm *PingMonitor) snoozeURL(token string) string {
+	base := pm.publicBaseURL()
+	if base == "" {
+		return ""
+	}
+	path := "/snooze"
+	if pm.profile != "" {
+		path = profilePathPrefix(pm.profile) + path
+	}
+	return base + path + "?t=" + url.QueryEscape(token)
+}
+
+// snoozePage writes a minimal page readable on a phone
+func snoozePage(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<!DOCTYPE html><html lang="ja"><head><meta charset="utf-8">`+
+		`<meta name="viewport" content="width=device-width,initial-scale=1"><title>Ping Monitor</title></head>`+
+		`<body style="font-family:sans-serif;padding:1em">%s</body></html>`, body)
+}
+
+// handleSnoozePage asks for confirmation; acknowledging takes a POST so
+// that link previews and prefetching cannot trigger it
+func (s *apiServer) handleSnoozePage(w http.ResponseWriter, r *http.Request) {
+	valid, acked := s.pm.outageSnooze.state(r.URL.Query().Get("t"))
+	switch {
+	case !valid:
+		snoozePage(w, http.StatusNotFound, "<p>このリンクは無効です。障害が既に復旧したか、監視が再起動されました。</p>")
+	case acked:
+		snoozePage(w, http.StatusOK, "<p>🔕 この障害は確認済みです。復旧までの通知は止まっています。</p>")
+	default:
+		snoozePage(w, http.StatusOK, `<p>🚨 現在の障害を確認済みにして、復旧までの通知を止めますか？</p>`+
+			`<form method="post"><button type="submit" style="font-size:1.2em;padding:.5em 1em">通知を止める</button></form>`)
+	}
+}
+
+// handleSnooze acknowledges the current outage
+func (s *apiServer) handleSnooze(w http.ResponseWriter, r *http.Request) {
+	ok, used := s.pm.outageSnooze.ack(r.FormValue("t"), s.pm.now())
+	switch {
+	case !ok:
+		snoozePage(w, http.StatusNotFound, "<p>このリンクは無効です。障害が既に復旧したか、監視が再起動されました。</p>")
+	case used:
+		snoozePage(w, http.StatusOK, "<p>🔕 この障害は既に確認済みです。</p>")
+	default:
+		fmt.Println("🔕 障害が確認済みになりました。復旧通知は送りません")
+		start, _ := s.pm.outages.inProgress()
+		s.pm.audit.record(AuditEntry{Action: "snooze", Actor: "snooze-link", Source: r.RemoteAddr,
+			Detail: map[string]string{"outage_start": start.Format(time.RFC3339)}, Old: "未確認", New: "確認済み"})
+		snoozePage(w, http.StatusOK, "<p>🔕 確認済みにしました。この障害の以降の通知は送りません。</p>")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutageSnooze(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	s := newOutageSnooze()
+	if valid, _ := s.state(""); valid {
+		t.Error("empty token valid before any outage")
+	}
+	token := s.arm(start)
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(token) {
+		t.Fatalf("token %q", token)
+	}
+	if s.arm(start) != token {
+		t.Error("re-arming the same outage changed its token")
+	}
+	if other := newOutageSnooze(); other.arm(start) == token {
+		t.Error("another key gave the same token")
+	}
+
+	flipped := []byte(token)
+	flipped[7] ^= 1
+	tampered := map[string]string{
+		"one character changed": string(flipped),
+		"truncated":             token[:31],
+		"extended":              token + "0",
+		"upper case":            strings.ToUpper(token),
+		"empty":                 "",
+	}
+	for name, bad := range tampered {
+		if valid, _ := s.state(bad); valid {
+			t.Errorf("%s: %q valid", name, bad)
+		}
+		if ok, _ := s.ack(bad, start); ok {
+			t.Errorf("%s: %q acknowledged the outage", name, bad)
+		}
+	}
+
+	if valid, acked := s.state(token); !valid || acked {
+		t.Fatalf("state %v/%v before the ack", valid, acked)
+	}
+	if ok, used := s.ack(token, start.Add(time.Minute)); !ok || used {
+		t.Errorf("first ack %v/%v", ok, used)
+	}
+	if ok, used := s.ack(token, start.Add(2*time.Minute)); !ok || !used {
+		t.Errorf("second ack %v/%v, want used", ok, used)
+	}
+	if !s.ackedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("acked at %s, want the first ack", s.ackedAt)
+	}
+
+	// Only the recovery of the outage the token was issued for expires it
+	if s.release(start.Add(time.Second)) {
+		t.Error("another outage's recovery reported the ack")
+	}
+	if valid, _ := s.state(token); !valid {
+		t.Error("another outage's recovery expired the token")
+	}
+	if !s.release(start) {
+		t.Error("recovery lost the ack")
+	}
+	if valid, _ := s.state(token); valid {
+		t.Error("token valid after the recovery")
+	}
+	if ok, _ := s.ack(token, start.Add(time.Hour)); ok {
+		t.Error("expired token acknowledged")
+	}
+
+	// The next outage gets a new token and starts unacknowledged
+	next := s.arm(start.Add(time.Hour))
+	if next == token {
+		t.Error("next outage reused the token")
+	}
+	if valid, _ := s.state(token); valid {
+		t.Error("previous token valid for the next outage")
+	}
+	if valid, acked := s.state(next); !valid || acked {
+		t.Errorf("next outage %v/%v", valid, acked)
+	}
+}
+
+func TestSnoozeURL(t *testing.T) {
+	quietStdout(t)
+	tests := []struct {
+		publicURL, listen, profile string
+		want                       string
+	}{
+		{"", "", "", ""},
+		{"", "127.0.0.1:8080", "", ""},
+		{"", "[::1]:8080", "", ""},
+		{"", "0.0.0.0:8080", "", "http://192.0.2.2:8080/snooze?t=abc"},
+		{"", ":8080", "", "http://192.0.2.2:8080/snooze?t=abc"},
+		{"", "[::]:8080", "", "http://192.0.2.2:8080/snooze?t=abc"},
+		{"", "192.0.2.9:8080", "", "http://192.0.2.9:8080/snooze?t=abc"},
+		{"", "0.0.0.0:8080", "home", "http://192.0.2.2:8080/p/home/snooze?t=abc"},
+		{"https://monitor.example.com", "127.0.0.1:8080", "", "https://monitor.example.com/snooze?t=abc"},
+	}
+	pm, _ := newTestMonitor(t, nil, time.Now())
+	for _, tt := range tests {
+		pm.config.PublicURL, pm.config.HTTPListen, pm.profile = tt.publicURL, tt.listen, tt.profile
+		if got := pm.snoozeURL("abc"); got != tt.want {
+			t.Errorf("%q %q %q: %q, want %q", tt.publicURL, tt.listen, tt.profile, got, tt.want)
+		}
+	}
+}
+
+// TestSnoozeHandlers walks the link through the alert: the page only
+// asks, the POST acknowledges once, and the recovery expires the link
+func TestSnoozeHandlers(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	pm, _ := newTestMonitor(t, nil, start)
+	h := newAPIServer(pm).server.Handler
+	request := func(method, token string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if method == http.MethodGet {
+			req = httptest.NewRequest(method, "/snooze?t="+url.QueryEscape(token), nil)
+		} else {
+			req = httptest.NewRequest(method, "/snooze", strings.NewReader(url.Values{"t": {token}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	token := pm.outageSnooze.arm(start)
+	if rec := request(http.MethodGet, token); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<form method="post">`) {
+		t.Errorf("page: %d %s", rec.Code, rec.Body)
+	}
+	if valid, acked := pm.outageSnooze.state(token); !valid || acked {
+		t.Error("showing the page acknowledged the outage")
+	}
+	for _, bad := range []string{"", token[:31], strings.Repeat("0", 32)} {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			if rec := request(method, bad); rec.Code != http.StatusNotFound {
+				t.Errorf("%s %q: %d", method, bad, rec.Code)
+			}
+		}
+	}
+	if len(pm.audit.entries()) != 0 {
+		t.Errorf("audit %+v before the ack", pm.audit.entries())
+	}
+
+	if rec := request(http.MethodPost, token); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "確認済みにしました") {
+		t.Errorf("ack: %d %s", rec.Code, rec.Body)
+	}
+	entries := pm.audit.entries()
+	if len(entries) != 1 || entries[0].Action != "snooze" || entries[0].Actor != "snooze-link" {
+		t.Errorf("audit %+v", entries)
+	}
+	if rec := request(http.MethodPost, token); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "既に確認済み") {
+		t.Errorf("second ack: %d %s", rec.Code, rec.Body)
+	}
+	if rec := request(http.MethodGet, token); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "<form") {
+		t.Errorf("page after the ack: %d %s", rec.Code, rec.Body)
+	}
+	if len(pm.audit.entries()) != 1 {
+		t.Errorf("second ack audited: %+v", pm.audit.entries())
+	}
+
+	pm.outageSnooze.release(start)
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if rec := request(method, token); rec.Code != http.StatusNotFound {
+			t.Errorf("%s after the recovery: %d", method, rec.Code)
+		}
+	}
+}
+
+// TestSnoozeOutage follows an outage whose alert link is used: the alert
+// carries the link and the recovery is recorded but not notified
+func TestSnoozeOutage(t *testing.T) {
+	quietStdout(t)
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	for _, acknowledge := range []bool{false, true} {
+		pm, clock := newTestMonitor(t, map[string]interface{}{"ping_interval": "1s", "http_listen": "0.0.0.0:8080"}, start)
+		n := &fakeNotifier{name: "test"}
+		notifyTo(pm, n)
+		pm.prober = probeFunc(func(string) (float64, error) {
+			if s := clock.now().Sub(start) / time.Second; s >= 10 && s < 20 {
+				return 0, &probeError{reason: reasonTimeout, err: errors.New("timeout")}
+			}
+			return 10, nil
+		})
+		var link string
+		for s := 0; s < 40; s++ {
+			at := start.Add(time.Duration(s) * time.Second)
+			clock.set(at)
+			pm.tick(at)
+			if link == "" {
+				if start, _ := pm.outages.inProgress(); !start.IsZero() {
+					pm.dispatcher.flush()
+					if got := n.events(); len(got) > 0 {
+						link, _ = got[0].Data["snooze_url"].(string)
+					}
+					if acknowledge && link != "" {
+						u, err := url.Parse(link)
+						if err != nil || u.Host != "192.0.2.2:8080" {
+							t.Fatalf("link %q: %v", link, err)
+						}
+						if ok, _ := pm.outageSnooze.ack(u.Query().Get("t"), at); !ok {
+							t.Fatalf("link %q not accepted", link)
+						}
+					}
+				}
+			}
+		}
+		pm.dispatcher.flush()
+		if link == "" {
+			t.Fatalf("ack %v: no link in the alert", acknowledge)
+		}
+		var kinds []EventKind
+		for _, ev := range n.events() {
+			kinds = append(kinds, ev.Kind)
+		}
+		wantKinds := 2
+		if acknowledge {
+			wantKinds = 1
+		}
+		if len(kinds) != wantKinds || kinds[0] != EventOutage {
+			t.Errorf("ack %v: notified %v", acknowledge, kinds)
+		}
+		pm.mutex.Lock()
+		outages := pm.periodOutages
+		pm.mutex.Unlock()
+		if len(outages) != 1 || outages[0].Acknowledged != acknowledge {
+			t.Errorf("ack %v: recorded %+v", acknowledge, outages)
+		}
+	}
+}
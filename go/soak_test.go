@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// The default soak covers two days, enough to compare day two with day
+// one; -soak.duration=168h runs the full week
+var (
+	soakDuration = flag.Duration("soak.duration", 48*time.Hour, "soak test: simulated period")
+	soakBudgetMB = flag.Float64("soak.budget-mb", 16, "soak test: most heap growth after day one (MB)")
+)
+
+// soakProber is a deterministic fake prober for soak runs: random loss at
+// a fixed rate plus a short outage every outageEvery
+type soakProber struct {
+	target      string
+	rand        *rand.Rand
+	loss        float64
+	outageEvery time.Duration
+	outageFor   time.Duration
+	clock       *testClock
+	start       time.Time
+}
+
+// Probe answers in 10–30ms, failing at the configured rate and during the
+// periodic outages; gateways always answer
+func (p *soakProber) Probe(host string) (float64, error) {
+	if host != p.target {
+		return 1, nil
+	}
+	if p.outageEvery > 0 && p.clock.now().Sub(p.start)%p.outageEvery < p.outageFor {
+		return 0, &probeError{reason: reasonTimeout, err: errScriptedFailure}
+	}
+	if p.rand.Float64() < p.loss {
+		return 0, &probeError{reason: reasonTimeout, err: errScriptedFailure}
+	}
+	return 10 + p.rand.Float64()*20, nil
+}
+
+// soakCheckpoint is the heap after a forced GC at one point of a soak run
+type soakCheckpoint struct {
+	At        time.Time
+	HeapAlloc uint64
+	HeapObjs  uint64
+}
+
+// readHeap forces a collection and returns the live heap
+func readHeap(at time.Time) soakCheckpoint {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return soakCheckpoint{At: at, HeapAlloc: m.HeapAlloc, HeapObjs: m.HeapObjects}
+}
+
+// executeSoak drives the monitor through duration of samples the way
+// pingLoop does, with the schedule on the virtual clock, and returns the
+// heap at the end of every day, when the day's samples are all held
+func executeSoak(duration, interval time.Duration, loss float64, outageEvery time.Duration) ([]soakCheckpoint, time.Duration, error) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+
+	dir, err := os.MkdirTemp("", "ping-monitor-soak")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(dir)
+	cfgPath := filepath.Join(dir, "config.json")
+	cfgData, _ := json.Marshal(map[string]interface{}{
+		"warmup":              "0s",
+		"discord_webhook_url": webhook.URL,
+		"gateway":             scenarioGateway,
+		"state_dir":           filepath.Join(dir, "state"),
+		"results_file":        filepath.Join(dir, "results.jsonl"),
+	})
+	if err := os.WriteFile(cfgPath, cfgData, 0600); err != nil {
+		return nil, 0, err
+	}
+
+	pm, err := NewPingMonitor(cfgPath, ConfigOverrides{})
+	if err != nil {
+		return nil, 0, err
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	// The dispatcher's lanes read the clock while the loop below moves it
+	clock := &testClock{t: start}
+	pm.prober = &soakProber{
+		target:      pm.targetIP,
+		rand:        rand.New(rand.NewSource(1)),
+		loss:        loss,
+		outageEvery: outageEvery,
+		outageFor:   90 * time.Second,
+		clock:       clock,
+		start:       start,
+	}
+	pm.now = clock.now
+	pm.pingInterval = interval
+	pm.samples = newPeriodSamples(interval)
+	pm.iface = nil
+	pm.localIP = "192.0.2.2"
+	pm.reports = newReportCoordinator(pm, start)
+	pm.periodStart = start
+	pm.outages = newOutageTracker(pm.config.FailureThreshold, pm.config.RecoveryThreshold, start)
+	pm.warmupUntil = start
+
+	var checkpoints []soakCheckpoint
+	schedule := newProbeSchedule(start, interval)
+	began := time.Now()
+	for at := schedule.deadline(); at.Before(start.Add(duration)); at = schedule.deadline() {
+		clock.set(at)
+		step := schedule.take(at)
+		pm.recordScheduleStep(step)
+		if step.Slot.Format(reportDateLayout) != pm.reports.day {
+			pm.dispatcher.flush()
+			checkpoints = append(checkpoints, readHeap(step.Slot.Add(-interval)))
+		}
+		pm.tick(step.Slot)
+		pm.reports.flush()
+	}
+	elapsed := time.Since(began)
+	pm.reports.shutdown()
+	pm.dispatcher.close()
+	return checkpoints, elapsed, nil
+}
+
+// TestSoak runs the full pipeline on a virtual clock for days and checks
+// that the heap does not grow from day to day. Skipped with -short.
+func TestSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak run skipped with -short")
+	}
+	if *soakDuration < 48*time.Hour {
+		t.Fatalf("-soak.duration must be at least 48h: %v", *soakDuration)
+	}
+	// The monitor logs every sample, which would dominate the run time
+	quietStdout(t)
+	checkpoints, elapsed, err := executeSoak(*soakDuration, time.Second, 0.005, 6*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%v of pings in %v (%.0fx)", *soakDuration, elapsed.Round(time.Millisecond), soakDuration.Seconds()/elapsed.Seconds())
+	for _, c := range checkpoints {
+		t.Logf("%s  heap %7.2fMB  objects %d", c.At.Format("01-02 15:04"), float64(c.HeapAlloc)/(1<<20), c.HeapObjs)
+	}
+	// Day one also fills caches and rings that stay allocated; growth is
+	// measured from its end, comparing the same point of the daily cycle
+	base, last := checkpoints[0], checkpoints[len(checkpoints)-1]
+	growth := (float64(last.HeapAlloc) - float64(base.HeapAlloc)) / (1 << 20)
+	if growth > *soakBudgetMB {
+		t.Errorf("heap grew %+.2fMB after day one, budget %.0fMB", growth, *soakBudgetMB)
+	}
+}
+
+// quietStdout sends the monitor's console output to the null device for
+// the rest of the test
+func quietStdout(t testing.TB) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+// BenchmarkTick measures one monitoring cycle with a prober that answers
+// at once
+func BenchmarkTick(b *testing.B) {
+	quietStdout(b)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	pm, clock := newTestMonitor(b, nil, start)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Wrapping inside the day keeps rollovers out of the measurement
+		at := start.Add(time.Duration(i%80000) * time.Second)
+		clock.set(at)
+		pm.tick(at)
+	}
+}
+
+func BenchmarkLatencyAccumulator(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, 4096)
+	for i := range values {
+		values[i] = 5 + rng.ExpFloat64()*20
+	}
+	var a latencyAccumulator
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.add(values[i%len(values)])
+	}
+}
+
+func BenchmarkLatencyPercentile(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	var a latencyAccumulator
+	for i := 0; i < 86400; i++ {
+		a.add(5 + rng.ExpFloat64()*20)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.stats()
+	}
+}
+
+// BenchmarkPeriodSnapshot measures the copy of a full day that /status
+// and the interim reports take
+func BenchmarkPeriodSnapshot(b *testing.B) {
+	quietStdout(b)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	pm, clock := newTestMonitor(b, nil, start)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 86399; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		if i%200 == 0 {
+			pm.unreachableTimes = append(pm.unreachableTimes, at)
+			pm.samples.addFailure(at)
+			continue
+		}
+		pm.samples.add(PingResult{Timestamp: at, Completed: at, ResponseTime: 5 + rng.ExpFloat64()*20, Success: true})
+	}
+	clock.set(start.Add(86399 * time.Second))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pm.currentPeriod().probeStats()
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// sparkLevels are the bar heights of a sparkline, lowest first. Plain
+// output maps them to ASCII through plainReplacer.
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// sparkAxis marks the hours under a 24-hour sparkline
+const sparkAxis = "0     6     12    18"
+
+// sparkline draws one bar per value, scaled to the range of the present
+// values; missing values leave a gap. It returns the lowest and highest
+// value drawn, both 0 when none is present.
+func sparkline(values []float64, present []bool) (string, float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, v := range values {
+		if present[i] {
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	if lo > hi {
+		lo, hi = 0, 0
+	}
+	var b strings.Builder
+	for i, v := range values {
+		if !present[i] {
+			b.WriteByte(' ')
+			continue
+		}
+		level := 0
+		if hi > lo {
+			level = int(math.Round((v - lo) / (hi - lo) * float64(len(sparkLevels)-1)))
+		}
+		b.WriteRune(sparkLevels[level])
+	}
+	return b.String(), lo, hi
+}
+
+// sparklines draws the hourly average latency and loss of the period, or
+// "" when fewer than two hours have samples. The lines are a code block so
+// Discord keeps them aligned and leaves the bars alone.
+func (p *reportPeriod) sparklines() string {
+	latency, loss := make([]float64, 24), make([]float64, 24)
+	hasLatency, hasLoss := make([]bool, 24), make([]bool, 24)
+	hours := 0
+	for _, h := range hourlyAggregates(p) {
+		hours++
+		loss[h.Hour], hasLoss[h.Hour] = h.LossRate(), true
+		if h.Count > h.Failures {
+			latency[h.Hour], hasLatency[h.Hour] = h.Avg, true
+		}
+	}
+	if hours < 2 {
+		return ""
+	}
+	latencyLine, latencyLo, latencyHi := sparkline(latency, hasLatency)
+	lossLine, lossLo, lossHi := sparkline(loss, hasLoss)
+	return fmt.Sprintf("```\n%s 応答 %.0f–%.0fms\n%s 損失 %.1f–%.1f%%\n%-24s\n```",
+		latencyLine, latencyLo, latencyHi, lossLine, lossLo, lossHi, sparkAxis)
+}
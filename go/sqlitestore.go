@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStoreFile is the database of the sqlite store in state_dir
+const sqliteStoreFile = "store.db"
+
+// sqliteSchema creates the tables on first use. Each record is kept as
+// its JSON next to its time, so it reads back exactly as the other stores
+// return it and new fields need no migration.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS results (ts INTEGER NOT NULL, record TEXT NOT NULL);
+CREATE INDEX IF NOT EXISTS results_ts ON results (ts);
+CREATE TABLE IF NOT EXISTS outages (start INTEGER NOT NULL, record TEXT NOT NULL);
+CREATE INDEX IF NOT EXISTS outages_start ON outages (start);
+`
+
+// sqliteStore keeps results and outages in an SQLite database, queried by
+// time without reading the whole history like the file store
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// openSQLiteStore opens the database at path, creating it when writable.
+// A missing database opened read-only is an empty store, so subcommands
+// only reading it never create files.
+func openSQLiteStore(path string, writable bool) (Store, error) {
+	if !writable {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return newMemoryStore(0), nil
+		}
+	}
+	if writable {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+	}
+	dsn := "file:" + filepath.ToSlash(path) + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	if !writable {
+		dsn += "&mode=ro"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("データベース %s を開けません: %v", path, err)
+	}
+	// One connection serializes the writes, which SQLite does anyway
+	db.SetMaxOpenConns(1)
+	if writable {
+		if _, err := db.Exec(sqliteSchema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("データベース %s を初期化できません: %v", path, err)
+		}
+	} else if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("データベース %s を開けません: %v", path, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// AppendResult inserts rec
+func (s *sqliteStore) AppendResult(rec ResultRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO results (ts, record) VALUES (?, ?)`, rec.Timestamp.UnixNano(), string(data))
+	return err
+}
+
+// AppendEvent inserts rec
+func (s *sqliteStore) AppendEvent(rec OutageRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO outages (start, record) VALUES (?, ?)`, rec.Start.UnixNano(), string(data))
+	return err
+}
+
+// QueryRange returns the results in [from, to) in time order, those of
+// the same time in the order they were appended
+func (s *sqliteStore) QueryRange(from, to time.Time) ([]ResultRecord, error) {
+	var out []ResultRecord
+	err := s.query(`SELECT record FROM results WHERE ts >= ? AND ts < ? ORDER BY ts, rowid`, from, to, func(data []byte) error {
+		var rec ResultRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return err
+		}
+		out = append(out, rec)
+		return nil
+	})
+	return out, err
+}
+
+// Events returns the outages that started in [from, to)
+func (s *sqliteStore) Events(from, to time.Time) ([]OutageRecord, error) {
+	var out []OutageRecord
+	err := s.query(`SELECT record FROM outages WHERE start >= ? AND start < ? ORDER BY start, rowid`, from, to, func(data []byte) error {
+		var rec OutageRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return err
+		}
+		out = append(out, rec)
+		return nil
+	})
+	return out, err
+}
+
+// query calls fn with the record of every row of a [from, to) query
+func (s *sqliteStore) query(q string, from, to time.Time, fn func(data []byte) error) error {
+	rows, err := s.db.Query(q, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DailyAggregate groups the stored results of date by hour
+func (s *sqliteStore) DailyAggregate(date string) ([]HourlyAggregate, error) {
+	return dailyAggregate(s, date)
+}
+
+// Prune deletes the results and outages before before
+func (s *sqliteStore) Prune(before time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM results WHERE ts < ?`, before.UnixNano()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM outages WHERE start < ?`, before.UnixNano())
+	return err
+}
+
+// Close closes the database
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version is the release, set at build time with
+// -ldflags "-X main.version=v1.2.3"
+var version = ""
+
+// buildVersion returns version, or the VCS revision embedded by go build
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	rev, dirty := "", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value[:min(12, len(s.Value))]
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if rev == "" {
+		return "dev"
+	}
+	if dirty {
+		rev += "-dirty"
+	}
+	return "dev-" + rev
+}
+
+// StartupState is the "startup" section of state_dir/startup.json
+type StartupState struct {
+	LastNotified time.Time `json:"last_notified"`
+}
+
+// restoredSummary describes what a previous run left in state_dir for this
+// one to pick up
+func (pm *PingMonitor) restoredSummary() string {
+	if pm.config.StateDir == "" {
+		return "なし（state_dir 未設定）"
+	}
+	var parts []string
+	if pm.history != nil {
+		if day, err := pm.history.loadDay(pm.now().Format(reportDateLayout)); err == nil {
+			count := 0
+			for _, h := range day.Hours {
+				count += h.Count
+			}
+			parts = append(parts, fmt.Sprintf("今日の履歴 %d回分", count))
+		}
+	}
+	if pm.outbox != nil {
+		if paths, err := pm.outbox.pending(); err == nil && len(paths) > 0 {
+			parts = append(parts, fmt.Sprintf("未送信メッセージ %d件", len(paths)))
+		}
+	}
+	if n := pm.dispatcher.replayCount(); n > 0 {
+		parts = append(parts, fmt.Sprintf("再送する通知 %d件", n))
+	}
+	if len(parts) == 0 {
+		return "復元するものはありません"
+	}
+	return "復元しました（" + strings.Join(parts, "、") + "）"
+}
+
+// notifyStartup sends the startup summary when notify_on_start is set,
+// unless one was sent less than notify_on_start_interval ago, which keeps a
+// crash loop from flooding the channel. Values come from the redacted
+// config, so no secret reaches the message.
+func (pm *PingMonitor) notifyStartup() {
+	if !pm.config.NotifyOnStart {
+		return
+	}
+	now := pm.now()
+	var path string
+	var state StartupState
+	if pm.config.StateDir != "" {
+		path = filepath.Join(pm.config.StateDir, "startup.json")
+		if err := readStateFile(path, "startup", &state); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("❌ 起動記録の読み込みエラー: %v\n", err)
+		}
+		interval := pm.config.NotifyOnStartInterval.Duration()
+		if !state.LastNotified.IsZero() && now.Sub(state.LastNotified) < interval {
+			fmt.Printf("起動通知は前回（%s）から %v 経っていないため送りません\n", state.LastNotified.Format("15:04:05"), interval)
+			return
+		}
+	}
+
+	cfg := redactConfig(pm.config)
+	var notifiers []string
+	for _, n := range pm.dispatcher.notifiers {
+		notifiers = append(notifiers, n.Name())
+	}
+	if len(notifiers) == 0 {
+		notifiers = []string{"なし"}
+	}
+	monitor := cfg.SiteName
+	if pm.profile != "" {
+		monitor += " / " + pm.profile
+	}
+	routeInterval := cfg.RouteProbeInterval.String()
+	if cfg.RouteProbeInterval.Duration() <= 0 {
+		routeInterval = "無効"
+	}
+	message := fmt.Sprintf("**モニター**: %s\n**バージョン**: %s\n**対象**: %s（間隔 %v、経路確認 %s）\n**障害の判定**: %d回連続の失敗 / 復旧は%d回連続の成功\n**ゲートウェイ**: %s\n**送信元**: %s\n**通知先**: %s\n**前回の状態**: %s",
+		monitor, buildVersion(), pm.targetLabel(), pm.pingInterval, routeInterval,
+		cfg.FailureThreshold, cfg.RecoveryThreshold, pm.gatewayLabel(), pm.localIP,
+		strings.Join(notifiers, ", "), pm.restoredSummary())
+	pm.dispatcher.dispatch(Event{
+		Kind:     EventStartup,
+		Severity: SeverityInfo,
+		Time:     now,
+		Title:    "▶️ 監視を開始しました",
+		Message:  message,
+		Data: map[string]interface{}{
+			"monitor": monitor,
+			"version": buildVersion(),
+			"target":  cfg.Target,
+		},
+	})
+
+	if path == "" {
+		return
+	}
+	state.LastNotified = now
+	if err := writeStateFile(path, "startup", state); err != nil {
+		fmt.Printf("❌ 起動記録の保存エラー: %v\n", err)
+	}
+}
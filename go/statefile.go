@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateSchemaVersion is the version of the state file envelope written by
+// this binary. Version 1 is the bare JSON document written before the
+// envelope existed.
+const stateSchemaVersion = 2
+
+// stateMonitorName is recorded in every envelope so files copied between
+// hosts can be told apart; it is set from site_name at startup
+var stateMonitorName string
+
+// stateEnvelope wraps every file under state_dir. Sections are keyed by
+// component ("history", "collector", "outbox"), and the checksum covers
+// them so a torn or edited file is detected.
+type stateEnvelope struct {
+	SchemaVersion int                        `json:"schema_version"`
+	Monitor       string                     `json:"monitor,omitempty"`
+	SavedAt       time.Time                  `json:"saved_at"`
+	Checksum      string                     `json:"checksum"`
+	Sections      map[string]json.RawMessage `json:"sections"`
+}
+
+// stateMigrations upgrade an envelope from version n to n+1. The raw
+// document is passed for version 1, which has no envelope yet.
+var stateMigrations = map[int]func(env *stateEnvelope, component string, raw []byte) error{
+	1: func(env *stateEnvelope, component string, raw []byte) error {
+		env.Sections = map[string]json.RawMessage{component: raw}
+		return nil
+	},
+}
+
+// errStateChecksum reports a state file whose content does not match its
+// checksum
+var errStateChecksum = errors.New("チェックサムが一致しません")
+
+// sectionsChecksum returns the hex SHA-256 of the compact sections
+func sectionsChecksum(sections map[string]json.RawMessage) (string, error) {
+	data, err := json.Marshal(sections)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// writeStateFile atomically replaces path with an envelope holding v as
+// the component's section. The data is synced before the rename, and the
+// previous file is kept as path.bak for recovery.
+func writeStateFile(path, component string, v interface{}) error {
+	section, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	env := stateEnvelope{
+		SchemaVersion: stateSchemaVersion,
+		Monitor:       stateMonitorName,
+		SavedAt:       time.Now(),
+		Sections:      map[string]json.RawMessage{component: section},
+	}
+	if env.Checksum, err = sectionsChecksum(env.Sections); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path, path+".bak"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes a directory so a rename survives a power loss; not every
+// platform supports it, so errors are ignored
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// readStateFile decodes the component's section of path into v, upgrading
+// older versions. A corrupt file falls back to path.bak; a missing file
+// returns an error satisfying os.IsNotExist.
+func readStateFile(path, component string, v interface{}) error {
+	err := decodeStateFile(path, component, v)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+	if bakErr := decodeStateFile(path+".bak", component, v); bakErr == nil {
+		fmt.Printf("⚠️ 状態ファイル %s が壊れているためバックアップから復元しました: %v\n", path, err)
+		return nil
+	}
+	return fmt.Errorf("%s: %v", path, err)
+}
+
+// decodeStateFile reads and verifies one file
+func decodeStateFile(path, component string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	env, err := parseStateEnvelope(data, component)
+	if err != nil {
+		return err
+	}
+	section, ok := env.Sections[component]
+	if !ok {
+		return fmt.Errorf("%s のデータがありません", component)
+	}
+	return json.Unmarshal(section, v)
+}
+
+// parseStateEnvelope decodes data as an envelope of the current version,
+// migrating older versions step by step
+func parseStateEnvelope(data []byte, component string) (*stateEnvelope, error) {
+	var probe struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	env := &stateEnvelope{SchemaVersion: 1}
+	if probe.SchemaVersion != nil {
+		if err := json.Unmarshal(data, env); err != nil {
+			return nil, err
+		}
+		if env.SchemaVersion > stateSchemaVersion {
+			return nil, fmt.Errorf("新しいバージョン(%d)で保存された状態ファイルです（このバイナリは%dまで）", env.SchemaVersion, stateSchemaVersion)
+		}
+		sum, err := sectionsChecksum(env.Sections)
+		if err != nil {
+			return nil, err
+		}
+		if sum != env.Checksum {
+			return nil, errStateChecksum
+		}
+	}
+	for env.SchemaVersion < stateSchemaVersion {
+		migrate, ok := stateMigrations[env.SchemaVersion]
+		if !ok {
+			return nil, fmt.Errorf("バージョン%dの状態ファイルは移行できません", env.SchemaVersion)
+		}
+		if err := migrate(env, component, data); err != nil {
+			return nil, err
+		}
+		env.SchemaVersion++
+	}
+	return env, nil
+}
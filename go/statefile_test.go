@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// stateDoc stands in for a component's section
+type stateDoc struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+func TestStateFileRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	path := filepath.Join(dir, "2026-03-10.json")
+	if err := writeStateFile(path, "history", stateDoc{"2026-03-10", 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStateFile(path, "history", stateDoc{"2026-03-10", 2}); err != nil {
+		t.Fatal(err)
+	}
+	var got stateDoc
+	if err := readStateFile(path, "history", &got); err != nil || got.Count != 2 {
+		t.Fatalf("read %+v, %v", got, err)
+	}
+	// The previous file is kept, and no temporary file is left
+	var bak stateDoc
+	if err := decodeStateFile(path+".bak", "history", &bak); err != nil || bak.Count != 1 {
+		t.Errorf("backup %+v, %v", bak, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("files %v, want the file and its backup", entries)
+	}
+	if info, err := os.Stat(path); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0644) {
+		t.Errorf("mode %v, %v", info.Mode(), err)
+	}
+
+	data, _ := os.ReadFile(path)
+	var env stateEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	var section stateDoc
+	json.Unmarshal(env.Sections["history"], &section)
+	if env.SchemaVersion != stateSchemaVersion || len(env.Checksum) != 64 || section.Count != 2 {
+		t.Errorf("envelope %+v", env)
+	}
+}
+
+// TestStateFileMigration reads a bare document written before the
+// envelope, as version 1
+func TestStateFileMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2026-03-10.json")
+	if err := os.WriteFile(path, []byte(`{"date": "2026-03-10", "count": 7}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got stateDoc
+	if err := readStateFile(path, "history", &got); err != nil || got != (stateDoc{"2026-03-10", 7}) {
+		t.Errorf("read %+v, %v", got, err)
+	}
+	env, err := parseStateEnvelope([]byte(`{"count": 7}`), "outbox")
+	if err != nil || env.SchemaVersion != stateSchemaVersion || string(env.Sections["outbox"]) != `{"count": 7}` {
+		t.Errorf("migrated %+v, %v", env, err)
+	}
+}
+
+func TestStateFileCorruption(t *testing.T) {
+	quietStdout(t)
+	dir := t.TempDir()
+	write := func(name string, count int) string {
+		path := filepath.Join(dir, name)
+		if err := writeStateFile(path, "history", stateDoc{"2026-03-10", count}); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{"edited section", func(data []byte) []byte {
+			return []byte(strings.Replace(string(data), `"count": 2`, `"count": 9`, 1))
+		}},
+		{"torn write", func(data []byte) []byte { return data[:len(data)/2] }},
+		{"empty", func([]byte) []byte { return nil }},
+		{"no sections", func([]byte) []byte {
+			return []byte(`{"schema_version": 2, "checksum": "00", "sections": null}`)
+		}},
+	}
+	for _, tt := range tests {
+		path := write(tt.name+".json", 1)
+		write(tt.name+".json", 2)
+		data, _ := os.ReadFile(path)
+		if err := os.WriteFile(path, tt.corrupt(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := decodeStateFile(path, "history", &stateDoc{}); err == nil {
+			t.Errorf("%s: decoded", tt.name)
+		}
+		// The backup is used instead
+		var got stateDoc
+		if err := readStateFile(path, "history", &got); err != nil || got.Count != 1 {
+			t.Errorf("%s: read %+v, %v", tt.name, got, err)
+		}
+		// Without a good backup the error names the file
+		os.WriteFile(path+".bak", []byte("{"), 0644)
+		if err := readStateFile(path, "history", &got); err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: %v without a backup", tt.name, err)
+		}
+	}
+
+	// A missing file is not corruption
+	if err := readStateFile(filepath.Join(dir, "missing.json"), "history", &stateDoc{}); !os.IsNotExist(err) {
+		t.Errorf("missing file: %v", err)
+	}
+	// A file of another component has no section to read
+	path := write("history.json", 1)
+	if err := readStateFile(path, "collector", &stateDoc{}); err == nil {
+		t.Error("read a section that is not there")
+	}
+}
+
+// TestStateFileNewerVersion refuses a file written by a newer binary
+// rather than misreading it
+func TestStateFileNewerVersion(t *testing.T) {
+	sections := map[string]json.RawMessage{"history": json.RawMessage(`{"count":1}`)}
+	sum, _ := sectionsChecksum(sections)
+	data, _ := json.Marshal(stateEnvelope{SchemaVersion: stateSchemaVersion + 1, Checksum: sum, Sections: sections})
+	if _, err := parseStateEnvelope(data, "history"); err == nil || !strings.Contains(err.Error(), "新しいバージョン") {
+		t.Errorf("newer version: %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// computeProbeStats builds ProbeStats from successful response times and a
+// failure count
+func computeProbeStats(responseTimes []float64, failures int) ProbeStats {
+	s := ProbeStats{
+		Success: len(responseTimes),
+		Failure: failures,
+		Total:   len(responseTimes) + failures,
+		Latency: computeLatencyStats(responseTimes),
+	}
+	if s.Total > 0 {
+		s.SuccessRate = float64(s.Success) / float64(s.Total) * 100
+	}
+	return s
+}
+
+// computeLatencyStats computes summary statistics; all fields are zero
+// for an empty input
+func computeLatencyStats(samples []float64) LatencyStats {
+	if len(samples) == 0 {
+		return LatencyStats{}
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+
+	median := percentile(sorted, 50)
+	deviations := make([]float64, len(sorted))
+	for i, v := range sorted {
+		deviations[i] = math.Abs(v - median)
+	}
+	sort.Float64s(deviations)
+
+	return LatencyStats{
+		Count:  len(sorted),
+		Avg:    sum / float64(len(sorted)),
+		Min:    sorted[0],
+		Max:    sorted[len(sorted)-1],
+		Median: median,
+		P95:    percentile(sorted, 95),
+		MAD:    percentile(deviations, 50),
+	}
+}
+
+// percentile returns the p-th percentile of sorted using linear
+// interpolation between closest ranks
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	if lo == hi {
+		return sorted[lo]
+	}
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// QualityWeights are the points the quality score loses per percent of
+// loss, per 100% of p95 above the baseline, and per jitter equal to the
+// baseline
+type QualityWeights struct {
+	Loss    float64 `json:"loss"`
+	Latency float64 `json:"latency"`
+	Jitter  float64 `json:"jitter"`
+}
+
+// Caps on the latency and jitter penalties, so a slow but loss-free day
+// still scores above a day of heavy loss
+const (
+	qualityLatencyCap = 40
+	qualityJitterCap  = 30
+)
+
+// qualityInput is what a quality score is computed from
+type qualityInput struct {
+	LossPercent float64
+	P95         float64
+	JitterMs    float64
+	BaselineP95 float64
+}
+
+// QualityScore is a connection quality score and what it lost to each
+// factor
+type QualityScore struct {
+	Score          float64 `json:"score"`
+	LossPenalty    float64 `json:"loss_penalty"`
+	LatencyPenalty float64 `json:"latency_penalty"`
+	JitterPenalty  float64 `json:"jitter_penalty"`
+	BaselineP95    float64 `json:"baseline_p95_ms"`
+	JitterMs       float64 `json:"jitter_ms"`
+}
+
+// qualityScore rates a connection from 0 to 100:
+//
+//	100 − loss × loss% − latency × max(0, p95/baseline − 1) − jitter × jitter/baseline
+//
+// with the latency and jitter terms capped, rounded and clamped to 0–100.
+// Without a baseline only the loss counts.
+func qualityScore(in qualityInput, w QualityWeights) QualityScore {
+	q := QualityScore{BaselineP95: in.BaselineP95, JitterMs: in.JitterMs}
+	q.LossPenalty = in.LossPercent * w.Loss
+	if in.BaselineP95 > 0 {
+		q.LatencyPenalty = min(max(in.P95/in.BaselineP95-1, 0)*w.Latency, qualityLatencyCap)
+		q.JitterPenalty = min(in.JitterMs/in.BaselineP95*w.Jitter, qualityJitterCap)
+	}
+	q.Score = math.Round(min(max(100-q.LossPenalty-q.LatencyPenalty-q.JitterPenalty, 0), 100))
+	return q
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// handleReportToday returns the console report of the current period as
+// plain text, so the status subcommand prints exactly what the monitor does
+func (s *apiServer) handleReportToday(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	s.pm.writeDailyReport(&b, s.pm.currentPeriod())
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, s.pm.style.text(b.String()))
+}
+
+// text sends a GET with the bearer token and returns the body as text
+func (c *apiClient) text(path string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("API error: %d - %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return string(body), nil
+}
+
+// runStatus prints today's statistics of a running instance, or the saved
+// history of today when none is running
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "設定ファイルのパス")
+	baseURL := fs.String("url", "", "稼働中インスタンスのURL（省略時はconfigのhttp_listen）")
+	token := fs.String("token", "", "APIトークン（省略時はconfigのapi_token）")
+	profile := fs.String("profile", "", "対象のプロファイル（プロファイルを定義している場合）")
+	fs.Parse(args)
+
+	cfg, err := statusConfig(*configPath, *profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+	client, err := newAPIClient(*configPath, *baseURL, *token)
+	switch {
+	case errors.Is(err, errAPIDisabled):
+		fmt.Println("⚠️ HTTP APIと制御用ソケットが無効のため、稼働中のインスタンスには問い合わせません")
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	default:
+		path := "/report/today"
+		if *profile != "" {
+			path = profilePathPrefix(*profile) + path
+		}
+		report, err := client.text(path)
+		if err == nil {
+			fmt.Print(report)
+			return 0
+		}
+		// Only a missing instance falls back; a wrong token is an error
+		var netErr net.Error
+		if !errors.As(err, &netErr) {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			return 1
+		}
+		fmt.Printf("⚠️ 稼働中のインスタンスに接続できません（%s）\n", client.where)
+	}
+	return printSavedStatus(cfg)
+}
+
+// statusConfig returns the config of profile, or the top-level config
+func statusConfig(configPath, profile string) (Config, error) {
+	if profile == "" {
+		return readConfig(configPath, ConfigOverrides{})
+	}
+	profiles, err := readProfiles(configPath, ConfigOverrides{})
+	if err != nil {
+		return Config{}, err
+	}
+	for _, p := range profiles {
+		if p.Name == profile {
+			return p.Config, nil
+		}
+	}
+	return Config{}, fmt.Errorf("プロファイル %s がありません", profile)
+}
+
+// printSavedStatus prints today's hourly history from state_dir
+func printSavedStatus(cfg Config) int {
+	if cfg.StateDir == "" {
+		fmt.Fprintln(os.Stderr, "エラー: state_dirが未設定のため、保存済みの統計もありません")
+		return 1
+	}
+	date := time.Now().Format(reportDateLayout)
+	day, err := newHistoryStore(cfg.StateDir).loadDay(date)
+	if os.IsNotExist(err) {
+		fmt.Printf("保存済みの %s の統計はありません\n", date)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("保存済みの状態（%s）から表示します。前回の停止または日付の切り替えまでの統計です。\n", cfg.StateDir)
+	fmt.Printf("\n%s\n", strings.Repeat("=", 50))
+	fmt.Printf("📊 Ping Monitor 保存済みの統計 - %s\n", date)
+	fmt.Printf("%s\n", strings.Repeat("=", 50))
+	count, failures, weighted, p95 := 0, 0, 0.0, 0.0
+	for _, h := range day.Hours {
+		count += h.Count
+		failures += h.Failures
+		weighted += h.Avg * float64(h.Count-h.Failures)
+		p95 = max(p95, h.P95)
+	}
+	if count > 0 {
+		fmt.Printf("  成功率: %.2f%%\n", float64(count-failures)/float64(count)*100)
+	}
+	fmt.Printf("  総ping回数: %d\n", count)
+	fmt.Printf("  失敗回数: %d\n", failures)
+	if day.FirstFailure != nil {
+		fmt.Printf("  失敗の時刻: 初回失敗 %s / 最終失敗 %s\n", day.FirstFailure.Format("15:04:05"), day.LastFailure.Format("15:04:05"))
+	} else {
+		fmt.Printf("  失敗の時刻: なし\n")
+	}
+	if count > failures {
+		fmt.Printf("  平均応答時間: %.1fms（時間帯別p95の最大: %.1fms）\n", weighted/float64(count-failures), p95)
+	}
+	fmt.Printf("\n🕐 時間帯別:\n")
+	for _, h := range day.Hours {
+		fmt.Printf("  %02d時 %4d回 失敗 %3d回 (%.1f%%) 平均 %.1fms\n", h.Hour, h.Count, h.Failures, h.LossRate(), h.Avg)
+	}
+	fmt.Printf("%s\n\n", strings.Repeat("=", 50))
+	return 0
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"ping-monitor/api"
+)
+
+// Store persists probe results and confirmed outages. The monitor writes
+// through it, and the series of raw results, the daily aggregates, the
+// monthly report and the incidents subcommand read through it.
+type Store interface {
+	AppendResult(rec ResultRecord) error
+	AppendEvent(rec OutageRecord) error
+	// QueryRange returns the results with from <= timestamp < to, oldest
+	// first
+	QueryRange(from, to time.Time) ([]ResultRecord, error)
+	// Events returns the outages that started in [from, to), oldest first
+	Events(from, to time.Time) ([]OutageRecord, error)
+	// DailyAggregate groups the results of date (YYYY-MM-DD, local time)
+	// by hour
+	DailyAggregate(date string) ([]HourlyAggregate, error)
+	// Prune drops results and outages older than before
+	Prune(before time.Time) error
+	Close() error
+}
+
+// Store backends selectable with the store key
+const (
+	storeMemory = "memory"
+	storeFile   = "file"
+	storeSQLite = "sqlite"
+)
+
+// defaultMemoryRetention is how long the memory store keeps results when
+// store_retention is not set
+const defaultMemoryRetention = time.Hour
+
+// storeKind returns the configured backend; by default results and
+// outages go to files when results_file or state_dir is set, as they
+// always have
+func (c Config) storeKind() string {
+	switch {
+	case c.Store != "":
+		return c.Store
+	case c.ResultsFile != "" || c.StateDir != "":
+		return storeFile
+	}
+	return storeMemory
+}
+
+// storeRetention returns how long data is kept; 0 keeps it forever
+func (c Config) storeRetention() time.Duration {
+	d := c.StoreRetention.Duration()
+	if d == 0 && c.storeKind() == storeMemory {
+		return defaultMemoryRetention
+	}
+	return d
+}
+
+// openStore creates the backend selected by cfg. The monitor opens it
+// writable, so a results file that cannot be created fails at startup;
+// subcommands only reading it never create files.
+func openStore(cfg Config, writable bool) (Store, error) {
+	switch cfg.storeKind() {
+	case storeMemory:
+		return newMemoryStore(cfg.storeRetention()), nil
+	case storeSQLite:
+		return openSQLiteStore(filepath.Join(cfg.StateDir, sqliteStoreFile), writable)
+	}
+	s := newFileStore(cfg.ResultsFile, cfg.StateDir)
+	if writable && s.resultsPath != "" {
+		w, err := openResultsWriter(s.resultsPath)
+		if err != nil {
+			return nil, err
+		}
+		s.writer = w
+	}
+	return s, nil
+}
+
+// monthRange returns the bounds of month in its location
+func monthRange(month time.Time) (time.Time, time.Time) {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	return from, from.AddDate(0, 1, 0)
+}
+
+// aggregateRecords groups records by hour, skipping simulated ones and
+// measurement errors the way the daily statistics do
+func aggregateRecords(date string, records []ResultRecord) []HourlyAggregate {
+	p := &reportPeriod{Date: date, Samples: newPeriodSamples(0)}
+	for _, rec := range records {
+		switch {
+		case rec.Simulated, rec.Reason == string(reasonMeasurement):
+		case rec.Success:
+			p.Samples.add(PingResult{Timestamp: rec.Timestamp, ResponseTime: rec.ResponseTime, Success: true, Warmup: rec.Warmup, LoadTest: rec.LoadTest})
+		case !rec.excluded():
+			p.UnreachableTimes = append(p.UnreachableTimes, rec.Timestamp)
+			p.FailureGateways = append(p.FailureGateways, gatewayState(rec.Gateway))
+			p.Samples.addFailure(rec.Timestamp)
+		}
+	}
+	return hourlyAggregates(p)
+}
+
+// dailyAggregate implements DailyAggregate on top of QueryRange
+func dailyAggregate(s Store, date string) ([]HourlyAggregate, error) {
+	day, err := time.ParseInLocation(reportDateLayout, date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("日付の形式が正しくありません: %q (YYYY-MM-DD)", date)
+	}
+	records, err := s.QueryRange(day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	return aggregateRecords(date, records), nil
+}
+
+// memoryStore keeps results for a retention window and outages since
+// start; nothing survives a restart
+type memoryStore struct {
+	mutex     sync.Mutex
+	retention time.Duration
+	results   []ResultRecord
+	events    []OutageRecord
+}
+
+// newMemoryStore creates a store keeping results for retention
+func newMemoryStore(retention time.Duration) *memoryStore {
+	return &memoryStore{retention: retention}
+}
+
+// AppendResult stores rec and drops results past the retention window
+func (s *memoryStore) AppendResult(rec ResultRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results = append(s.results, rec)
+	if s.retention > 0 {
+		s.pruneResultsLocked(rec.Timestamp.Add(-s.retention))
+	}
+	return nil
+}
+
+// pruneResultsLocked drops results before cutoff; results arrive in time
+// order, so they are cut from the front
+func (s *memoryStore) pruneResultsLocked(cutoff time.Time) {
+	i := sort.Search(len(s.results), func(i int) bool { return !s.results[i].Timestamp.Before(cutoff) })
+	if i == 0 {
+		return
+	}
+	// Copying keeps the backing array from pinning the dropped records
+	s.results = append(s.results[:0:0], s.results[i:]...)
+}
+
+// AppendEvent stores rec
+func (s *memoryStore) AppendEvent(rec OutageRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, rec)
+	return nil
+}
+
+// QueryRange returns the retained results in [from, to)
+func (s *memoryStore) QueryRange(from, to time.Time) ([]ResultRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var out []ResultRecord
+	for _, rec := range s.results {
+		if !rec.Timestamp.Before(from) && rec.Timestamp.Before(to) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// Events returns the outages that started in [from, to)
+func (s *memoryStore) Events(from, to time.Time) ([]OutageRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var out []OutageRecord
+	for _, rec := range s.events {
+		if !rec.Start.Before(from) && rec.Start.Before(to) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// DailyAggregate groups the retained results of date by hour
+func (s *memoryStore) DailyAggregate(date string) ([]HourlyAggregate, error) {
+	return dailyAggregate(s, date)
+}
+
+// Prune drops results and outages before before
+func (s *memoryStore) Prune(before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pruneResultsLocked(before)
+	var kept []OutageRecord
+	for _, rec := range s.events {
+		if !rec.Start.Before(before) {
+			kept = append(kept, rec)
+		}
+	}
+	s.events = kept
+	return nil
+}
+
+// Close does nothing for the memory store
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// fileStore appends results to the JSONL results_file and outages to
+// state_dir/outages.jsonl, the formats compare and incidents have always
+// read. Either part is disabled when its path is not configured.
+type fileStore struct {
+	mutex       sync.Mutex
+	resultsPath string
+	writer      *resultsWriter
+	incidents   *incidentLog
+}
+
+// newFileStore creates a store on resultsPath and stateDir; files are only
+// created on the first write
+func newFileStore(resultsPath, stateDir string) *fileStore {
+	s := &fileStore{resultsPath: resultsPath}
+	if stateDir != "" {
+		s.incidents = newIncidentLog(stateDir)
+	}
+	return s
+}
+
+// AppendResult appends rec to the results file
+func (s *fileStore) AppendResult(rec ResultRecord) error {
+	if s.resultsPath == "" {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.writer == nil {
+		w, err := openResultsWriter(s.resultsPath)
+		if err != nil {
+			return err
+		}
+		s.writer = w
+	}
+	return s.writer.write(rec)
+}
+
+// AppendEvent appends rec to the outage log
+func (s *fileStore) AppendEvent(rec OutageRecord) error {
+	if s.incidents == nil {
+		return nil
+	}
+	return s.incidents.append(rec)
+}
+
+// QueryRange scans the results file for [from, to)
+func (s *fileStore) QueryRange(from, to time.Time) ([]ResultRecord, error) {
+	if s.resultsPath == "" {
+		return nil, fmt.Errorf("results_file が未設定のため、結果は保存されていません")
+	}
+	var out []ResultRecord
+	err := scanResults(s.resultsPath, func(rec ResultRecord) {
+		if !rec.Timestamp.Before(from) && rec.Timestamp.Before(to) {
+			out = append(out, rec)
+		}
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return out, err
+}
+
+// Events reads the outage log for [from, to)
+func (s *fileStore) Events(from, to time.Time) ([]OutageRecord, error) {
+	if s.incidents == nil {
+		return nil, fmt.Errorf("障害記録にはstate_dirの設定が必要です")
+	}
+	return s.incidents.load(from, to)
+}
+
+// DailyAggregate groups the stored results of date by hour
+func (s *fileStore) DailyAggregate(date string) ([]HourlyAggregate, error) {
+	return dailyAggregate(s, date)
+}
+
+// Prune rewrites both files without the records before before
+func (s *fileStore) Prune(before time.Time) error {
+	if s.resultsPath != "" {
+		s.mutex.Lock()
+		err := s.pruneResultsLocked(before)
+		s.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if s.incidents != nil {
+		return s.incidents.prune(before)
+	}
+	return nil
+}
+
+// pruneResultsLocked rewrites the results file; the writer is reopened on
+// the next append
+func (s *fileStore) pruneResultsLocked(before time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.resultsPath), filepath.Base(s.resultsPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	enc := json.NewEncoder(tmp)
+	err = scanResults(s.resultsPath, func(rec ResultRecord) {
+		if !rec.Timestamp.Before(before) {
+			enc.Encode(rec)
+		}
+	})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.writer != nil {
+		s.writer.file.Close()
+		s.writer = nil
+	}
+	return os.Rename(tmp.Name(), s.resultsPath)
+}
+
+// Close closes the results file
+func (s *fileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.file.Close()
+	s.writer = nil
+	return err
+}
+
+// scanResults calls fn for every record of a JSONL results file
+func scanResults(path string, fn func(rec ResultRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec ResultRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		fn(rec)
+	}
+	return scanner.Err()
+}
+
+// pruneStore applies store_retention; called once a day at the rollover
+func (pm *PingMonitor) pruneStore(now time.Time) {
+	retention := pm.config.storeRetention()
+	if retention == 0 {
+		return
+	}
+	if err := pm.store.Prune(now.Add(-retention)); err != nil {
+		fmt.Printf("❌ 保存データの整理エラー: %v\n", err)
+	}
+}
+
+// storedTarget rejects a ?target= other than target: the Store holds no
+// samples of the additional targets
+func (s *apiServer) storedTarget(w http.ResponseWriter, r *http.Request) bool {
+	target := r.URL.Query().Get("target")
+	if target == "" || target == s.pm.config.Target || target == s.pm.targetIP {
+		return true
+	}
+	if t, ok := s.pm.config.additionalTarget(target); ok {
+		http.Error(w, fmt.Sprintf("samples of %s are not stored; see /api/v1/series?target=%s", t.Name, t.Name), http.StatusNotFound)
+		return false
+	}
+	http.Error(w, "unknown target: "+target, http.StatusNotFound)
+	return false
+}
+
+// handleResults returns the stored results of ?from= to ?to= (RFC3339),
+// the last hour by default
+func (s *apiServer) handleResults(w http.ResponseWriter, r *http.Request) {
+	if !s.storedTarget(w, r) {
+		return
+	}
+	to := s.pm.now()
+	from := to.Add(-time.Hour)
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s: %q (RFC3339)", p.name, v), http.StatusBadRequest)
+				return
+			}
+			*p.dst = t
+		}
+	}
+	results, err := s.pm.store.QueryRange(from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, ResultsResponse{Schema: api.SchemaResults, From: from, To: to, Results: toAPIResults(results)})
+}
+
+// handleDaily returns the hourly aggregates of ?date= (today by default)
+// computed from the stored results
+func (s *apiServer) handleDaily(w http.ResponseWriter, r *http.Request) {
+	if !s.storedTarget(w, r) {
+		return
+	}
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = s.pm.now().Format(reportDateLayout)
+	}
+	hours, err := s.pm.store.DailyAggregate(date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, DailyResponse{Schema: api.SchemaDaily, Date: date, Hours: toAPIHours(hours)})
+}
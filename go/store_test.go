@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storeBackend opens one Store implementation in dir
+type storeBackend struct {
+	name string
+	// persistent backends keep their data when reopened on the same dir
+	persistent bool
+	open       func(t *testing.T, dir string) Store
+}
+
+var storeBackends = []storeBackend{
+	{
+		name: storeMemory,
+		open: func(t *testing.T, dir string) Store { return newMemoryStore(0) },
+	},
+	{
+		name:       storeFile,
+		persistent: true,
+		open: func(t *testing.T, dir string) Store {
+			return mustOpenStore(t, Config{Store: storeFile, ResultsFile: filepath.Join(dir, "results.jsonl"), StateDir: dir}, true)
+		},
+	},
+	{
+		name:       storeSQLite,
+		persistent: true,
+		open: func(t *testing.T, dir string) Store {
+			return mustOpenStore(t, Config{Store: storeSQLite, StateDir: dir}, true)
+		},
+	},
+}
+
+func mustOpenStore(t *testing.T, cfg Config, writable bool) Store {
+	t.Helper()
+	s, err := openStore(cfg, writable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// asJSON renders v for comparing records after a round trip, which keeps
+// the instant and offset of times but not their location
+func asJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// storeDay is the day the conformance records fall on
+var storeDay = time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+
+// storeResults are the conformance results: a full record, failures with
+// gateway diagnostics, samples the statistics skip, and two results at
+// the same time whose order must be kept
+func storeResults(loc *time.Location) []ResultRecord {
+	day := time.Date(storeDay.Year(), storeDay.Month(), storeDay.Day(), 0, 0, 0, 0, loc)
+	at := func(h, m, s int) time.Time {
+		return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second)
+	}
+	return []ResultRecord{
+		{Timestamp: at(9, 59, 59), Target: "8.8.8.8", Success: true, ResponseTime: 9},
+		{Timestamp: at(10, 0, 0), CompletedAt: at(10, 0, 0).Add(12 * time.Millisecond), Target: "8.8.8.8", Success: true, ResponseTime: 12.5,
+			Address: "8.8.4.4", GatewayResponseTime: 1.5},
+		{Timestamp: at(10, 0, 1), Target: "8.8.8.8", Reason: "timeout", Gateway: string(gatewayReachable)},
+		{Timestamp: at(10, 0, 1), Target: "8.8.8.8", Success: true, ResponseTime: 14},
+		{Timestamp: at(10, 0, 2), Target: "8.8.8.8", Success: true, ResponseTime: 800, Warmup: true},
+		{Timestamp: at(10, 0, 3), Target: "8.8.8.8", Reason: "timeout", Simulated: true},
+		{Timestamp: at(10, 0, 4), Target: "8.8.8.8", Success: true, ResponseTime: 900, LoadTest: true},
+		{Timestamp: at(10, 0, 5), Target: "8.8.8.8", Reason: string(reasonMeasurement)},
+		{Timestamp: at(11, 30, 0), Target: "8.8.8.8", Reason: "unreachable", Gateway: string(gatewayUnreachable)},
+		{Timestamp: at(11, 30, 1), Target: "8.8.8.8", Success: true, ResponseTime: 20},
+		{Timestamp: at(23, 59, 59), Target: "8.8.8.8", Success: true, ResponseTime: 10},
+		{Timestamp: at(24, 0, 0), Target: "8.8.8.8", Success: true, ResponseTime: 11},
+	}
+}
+
+func storeEvents(loc *time.Location) []OutageRecord {
+	day := time.Date(storeDay.Year(), storeDay.Month(), storeDay.Day(), 0, 0, 0, 0, loc)
+	return []OutageRecord{
+		{Target: "8.8.8.8", Start: day.Add(-time.Minute), End: day.Add(time.Minute), DurationSeconds: 120, Reason: "timeout"},
+		{Target: "8.8.8.8", Start: day.Add(11*time.Hour + 30*time.Minute), End: day.Add(11*time.Hour + 31*time.Minute), DurationSeconds: 60,
+			Reason: "unreachable", Cause: "gateway", Classes: &OutageClasses{LANSeconds: 60}, EventID: 7, Connection: connEthernet},
+		{Target: "8.8.8.8", Start: day.Add(24 * time.Hour), End: day.Add(24*time.Hour + time.Second), DurationSeconds: 1, Simulated: true},
+	}
+}
+
+// TestStoreConformance runs the same checks against every backend
+func TestStoreConformance(t *testing.T) {
+	loc := setLocal(t, "Asia/Tokyo")
+	results, events := storeResults(loc), storeEvents(loc)
+	day := time.Date(storeDay.Year(), storeDay.Month(), storeDay.Day(), 0, 0, 0, 0, loc)
+	date := day.Format(reportDateLayout)
+	aggregates := map[string]string{}
+
+	for _, b := range storeBackends {
+		t.Run(b.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := b.open(t, dir)
+			defer func() { s.Close() }()
+			for _, rec := range results {
+				if err := s.AppendResult(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, rec := range events {
+				if err := s.AppendEvent(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// [from, to): the result at from is in, the one at to is out,
+			// and equal times keep the order they were appended in
+			got, err := s.QueryRange(results[1].Timestamp, results[8].Timestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if asJSON(t, got) != asJSON(t, results[1:8]) {
+				t.Errorf("QueryRange:\n got  %s\n want %s", asJSON(t, got), asJSON(t, results[1:8]))
+			}
+			if got, err := s.QueryRange(results[1].Timestamp, results[1].Timestamp); err != nil || len(got) != 0 {
+				t.Errorf("empty range: %v, %v", got, err)
+			}
+			all, _ := s.QueryRange(day, day.AddDate(0, 0, 2))
+			if len(all) != len(results) {
+				t.Errorf("%d results in all, want %d", len(all), len(results))
+			}
+
+			gotEvents, err := s.Events(day, day.AddDate(0, 0, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if asJSON(t, gotEvents) != asJSON(t, events[1:2]) {
+				t.Errorf("Events:\n got  %s\n want %s", asJSON(t, gotEvents), asJSON(t, events[1:2]))
+			}
+
+			hours, err := s.DailyAggregate(date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			aggregates[b.name] = asJSON(t, hours)
+			counts := map[int][2]int{}
+			for _, h := range hours {
+				counts[h.Hour] = [2]int{h.Count, h.Failures}
+			}
+			// Simulated results, measurement errors, the warm-up and the
+			// load test are left out, and the result at midnight belongs
+			// to the next day
+			want := map[int][2]int{9: {1, 0}, 10: {3, 1}, 11: {2, 1}, 23: {1, 0}}
+			for h, w := range want {
+				if counts[h] != w {
+					t.Errorf("hour %d: count/failures %v, want %v", h, counts[h], w)
+				}
+			}
+			if len(counts) != len(want) {
+				t.Errorf("hours %v, want %v", counts, want)
+			}
+			if _, err := s.DailyAggregate("2026/03/10"); err == nil {
+				t.Error("DailyAggregate accepted a malformed date")
+			}
+
+			if err := s.Prune(results[3].Timestamp); err != nil {
+				t.Fatal(err)
+			}
+			kept, _ := s.QueryRange(day, day.AddDate(0, 0, 2))
+			if asJSON(t, kept) != asJSON(t, results[2:]) {
+				t.Errorf("after Prune:\n got  %s\n want %s", asJSON(t, kept), asJSON(t, results[2:]))
+			}
+			keptEvents, _ := s.Events(day.AddDate(0, 0, -1), day.AddDate(0, 0, 2))
+			if asJSON(t, keptEvents) != asJSON(t, events[1:]) {
+				t.Errorf("events after Prune:\n got  %s\n want %s", asJSON(t, keptEvents), asJSON(t, events[1:]))
+			}
+
+			if !b.persistent {
+				return
+			}
+			// Appends after a prune, then a restart
+			extra := ResultRecord{Timestamp: day.Add(25 * time.Hour), Target: "8.8.8.8", Success: true, ResponseTime: 30}
+			if err := s.AppendResult(extra); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			s = b.open(t, dir)
+			reopened, err := s.QueryRange(day, day.AddDate(0, 0, 2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if asJSON(t, reopened) != asJSON(t, append(results[2:len(results):len(results)], extra)) {
+				t.Errorf("after reopening:\n got  %s", asJSON(t, reopened))
+			}
+		})
+	}
+
+	for name, got := range aggregates {
+		if got != aggregates[storeMemory] {
+			t.Errorf("%s aggregates differ from memory:\n %s\n %s", name, got, aggregates[storeMemory])
+		}
+	}
+}
+
+// TestReadOnlyStoreCreatesNothing opens every persistent backend the way
+// the subcommands do, on a directory without data
+func TestReadOnlyStoreCreatesNothing(t *testing.T) {
+	for _, cfg := range []Config{
+		{Store: storeFile, ResultsFile: "results.jsonl", StateDir: "state"},
+		{Store: storeSQLite, StateDir: "state"},
+	} {
+		dir := t.TempDir()
+		cfg.ResultsFile = filepath.Join(dir, cfg.ResultsFile)
+		cfg.StateDir = filepath.Join(dir, cfg.StateDir)
+		s := mustOpenStore(t, cfg, false)
+		if got, err := s.QueryRange(storeDay, storeDay.AddDate(0, 0, 1)); len(got) != 0 {
+			t.Errorf("%s: %v, %v from an empty store", cfg.Store, got, err)
+		}
+		s.Close()
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s: read-only open created %v", cfg.Store, entries)
+		}
+	}
+}
+
+func TestSQLiteStoreNeedsStateDir(t *testing.T) {
+	if _, err := readTestConfig(t, `{"store": "sqlite"}`, ConfigOverrides{}); err == nil {
+		t.Error("store sqlite accepted without state_dir")
+	}
+	if _, err := readTestConfig(t, `{"store": "sqlite", "state_dir": "`+filepath.ToSlash(t.TempDir())+`"}`, ConfigOverrides{}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// targetNameRe restricts names to what is safe in series keys and URLs
+var targetNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// TargetConfig is an additional target pinged alongside target, e.g. the
+// gateway and the ISP's DNS, so the report shows which leg of the path
+// fails. Alerts and outages stay with target, and so does the Store: the
+// legs' samples only reach the report, /status and /api/v1/series.
+type TargetConfig struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// validateTargets checks the targets section against target
+func validateTargets(targets []TargetConfig, target string) error {
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if !targetNameRe.MatchString(t.Name) {
+			return fmt.Errorf("targets の name の値が正しくありません: %q (英数字・_・-)", t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("targets の name が重複しています: %s", t.Name)
+		}
+		seen[t.Name] = true
+		if !validTarget(t.Address) {
+			return fmt.Errorf("targets[%s].address の値が正しくありません: %q（IPアドレスかホスト名で指定してください）", t.Name, t.Address)
+		}
+		if t.Address == target {
+			return fmt.Errorf("targets[%s].address は target と同じです: %s", t.Name, t.Address)
+		}
+	}
+	return nil
+}
+
+// additionalTarget returns the entry of targets named name or at address
+// name, for rejecting requests for samples the Store does not hold
+func (c Config) additionalTarget(name string) (TargetConfig, bool) {
+	for _, t := range c.Targets {
+		if t.Name == name || t.Address == name {
+			return t, true
+		}
+	}
+	return TargetConfig{}, false
+}
+
+// targetSeriesKey is the series of an additional target
+func targetSeriesKey(name string) string {
+	return "target:" + name
+}
+
+// TargetReport is one additional target in the report file
+type TargetReport struct {
+	Name    string     `json:"name"`
+	Address string     `json:"address"`
+	Stats   ProbeStats `json:"stats"`
+}
+
+// targetPeriod is an additional target's share of a reporting period
+type targetPeriod struct {
+	Name             string
+	Address          string
+	Latency          latencyAccumulator
+	UnreachableTimes []time.Time
+}
+
+// stats computes the period's statistics of the target
+func (t targetPeriod) stats() ProbeStats {
+	return t.Latency.probeStats(len(t.UnreachableTimes))
+}
+
+// legDay accumulates a leg's successes of one day
+type legDay struct {
+	date    string
+	latency latencyAccumulator
+}
+
+// targetLeg pings one additional target from its own goroutine, so a slow
+// or dead target delays neither target nor the other legs
+type targetLeg struct {
+	cfg      TargetConfig
+	resolver *targetResolver
+
+	mutex sync.Mutex
+	// days are the days not yet taken, oldest first; warm-up successes
+	// are not counted
+	days             []*legDay
+	unreachableTimes []time.Time
+	status           TargetStatus
+}
+
+// newTargetLeg prepares a leg; hostnames re-resolve like target
+func newTargetLeg(cfg TargetConfig, resolveInterval time.Duration) *targetLeg {
+	return &targetLeg{
+		cfg:      cfg,
+		resolver: newTargetResolver(cfg.Address, resolveInterval),
+		status:   TargetStatus{Name: cfg.Name, Address: cfg.Address},
+	}
+}
+
+// loop pings the leg on the grid of the main loop until the monitor
+// stops. As there, a probe longer than the interval skips the slots it
+// overran, a suspension or a clock set forward resumes at the latest slot,
+// and a clock set back restarts the grid; the main loop reports them.
+func (l *targetLeg) loop(pm *PingMonitor) {
+	defer pm.legs.Done()
+	schedule := newProbeSchedule(time.Now(), pm.pingInterval)
+	timer := time.NewTimer(time.Until(schedule.deadline()))
+	defer timer.Stop()
+	for {
+		select {
+		case <-pm.stopChan:
+			return
+		case <-timer.C:
+		}
+		l.probe(pm, schedule.take(time.Now()).Slot)
+		timer.Reset(time.Until(schedule.deadline()))
+	}
+}
+
+// probe pings the leg once for slot and records the result
+func (l *targetLeg) probe(pm *PingMonitor, slot time.Time) {
+	addr, _, resolveErr := l.resolver.resolve(slot)
+	sent := pm.now()
+	var ms float64
+	var err error
+	if addr == "" {
+		err = errNoAddress
+	} else {
+		ms, err = pm.gatewayProbe().Probe(addr)
+	}
+	var reason failureReason
+	if err != nil {
+		reason = classifyFailure(err)
+	}
+	inWarmup := sent.Before(pm.warmupUntil)
+
+	switch {
+	case resolveErr != nil && addr == "":
+		fmt.Printf("%s - [%s] 名前解決エラー（%s）: %v\n", sent.Format("15:04:05"), l.cfg.Name, l.cfg.Address, resolveErr)
+	case err == nil:
+		fmt.Printf("%s - [%s] %s ping: %.1fms\n", sent.Format("15:04:05"), l.cfg.Name, addr, ms)
+	case reason == reasonMeasurement:
+		fmt.Printf("%s - [%s] 計測エラー（%s）\n", sent.Format("15:04:05"), l.cfg.Name, describeMeasurementError(err))
+	default:
+		fmt.Printf("%s - [%s] %s 到達不能（%s）\n", sent.Format("15:04:05"), l.cfg.Name, addr, reason.label())
+	}
+
+	l.mutex.Lock()
+	switch {
+	case err == nil:
+		if !inWarmup {
+			l.day(sent).latency.add(ms)
+		}
+	case !inWarmup && reason != reasonMeasurement:
+		l.unreachableTimes = append(l.unreachableTimes, sent)
+	}
+	l.status.LastProbe, l.status.LastRTTMs, l.status.LastReason = sent, ms, string(reason)
+	l.mutex.Unlock()
+
+	if !inWarmup && reason != reasonMeasurement {
+		pm.series.add(targetSeriesKey(l.cfg.Name), sent, reason, ms)
+	}
+}
+
+// day returns the accumulator of the day of t; the caller holds the mutex
+func (l *targetLeg) day(t time.Time) *legDay {
+	date := t.Format(reportDateLayout)
+	if n := len(l.days); n > 0 && l.days[n-1].date == date {
+		return l.days[n-1]
+	}
+	d := &legDay{date: date}
+	l.days = append(l.days, d)
+	return d
+}
+
+// period returns the leg's samples up to the end of date; with take they
+// are detached, leaving those already stamped with the next day
+func (l *targetLeg) period(date string, take bool) targetPeriod {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	inPeriod := func(t time.Time) bool { return t.Format(reportDateLayout) <= date }
+	p := targetPeriod{Name: l.cfg.Name, Address: l.cfg.Address}
+	n := 0
+	for n < len(l.days) && l.days[n].date <= date {
+		p.Latency.merge(&l.days[n].latency)
+		n++
+	}
+	m := 0
+	for m < len(l.unreachableTimes) && inPeriod(l.unreachableTimes[m]) {
+		m++
+	}
+	p.UnreachableTimes = l.unreachableTimes[:m:m]
+	if take {
+		l.days = append([]*legDay{}, l.days[n:]...)
+		l.unreachableTimes = append([]time.Time{}, l.unreachableTimes[m:]...)
+	}
+	return p
+}
+
+// snapshot returns the leg for /status
+func (l *targetLeg) snapshot() TargetStatus {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	s := l.status
+	var latency latencyAccumulator
+	for _, d := range l.days {
+		latency.merge(&d.latency)
+	}
+	s.Stats = latency.probeStats(len(l.unreachableTimes))
+	return s
+}
+
+// targetPeriods returns every leg's share of the period of date
+func (pm *PingMonitor) targetPeriods(date string, take bool) []targetPeriod {
+	var out []targetPeriod
+	for _, l := range pm.targetLegs {
+		out = append(out, l.period(date, take))
+	}
+	return out
+}
+
+// targetLeg returns the leg named name, or nil
+func (pm *PingMonitor) targetLeg(name string) *targetLeg {
+	for _, l := range pm.targetLegs {
+		if l.cfg.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// targetStatus returns every leg for /status
+func (pm *PingMonitor) targetStatus() []TargetStatus {
+	var out []TargetStatus
+	for _, l := range pm.targetLegs {
+		out = append(out, l.snapshot())
+	}
+	return out
+}
+
+// legLines describe the legs' last results, printed when target fails to
+// tell which leg of the path is down
+func (pm *PingMonitor) legLines() []string {
+	var lines []string
+	for _, l := range pm.targetLegs {
+		l.mutex.Lock()
+		s := l.status
+		l.mutex.Unlock()
+		switch {
+		case s.LastProbe.IsZero():
+			lines = append(lines, fmt.Sprintf("[%s] 未計測", s.Name))
+		case s.LastReason == "":
+			lines = append(lines, fmt.Sprintf("[%s] %.1fms（%s）", s.Name, s.LastRTTMs, s.LastProbe.Format("15:04:05")))
+		default:
+			lines = append(lines, fmt.Sprintf("[%s] 到達不能（%s）", s.Name, s.LastProbe.Format("15:04:05")))
+		}
+	}
+	return lines
+}
+
+// formatTargetPeriod is the report field value of one leg
+func formatTargetPeriod(t targetPeriod, f numberFormat) string {
+	s := t.stats()
+	if s.Total == 0 {
+		return "データなし"
+	}
+	value := fmt.Sprintf("**成功率**: %s\n**失敗回数**: %s", f.percent(s.SuccessRate, 2), f.count(s.Failure))
+	if s.Latency.Count > 0 {
+		value += fmt.Sprintf("\n**平均**: %s\n**最大**: %s\n**最小**: %s", f.ms(s.Latency.Avg), f.ms(s.Latency.Max), f.ms(s.Latency.Min))
+	}
+	return value
+}
+
+// targetReports returns the legs of p for the report file
+func targetReports(p *reportPeriod) []TargetReport {
+	var out []TargetReport
+	for _, t := range p.Targets {
+		out = append(out, TargetReport{Name: t.Name, Address: t.Address, Stats: t.stats()})
+	}
+	return out
+}
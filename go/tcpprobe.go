@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// tcpProbeMaxTimeout caps tcp_probe.timeout like probe_command's
+const tcpProbeMaxTimeout = probeCommandMaxTimeout
+
+// tcpResetWait is how long an accepted connection is watched for a reset
+// before it counts as open
+const tcpResetWait = 200 * time.Millisecond
+
+// dialTCP opens the connections of tcp_probe; tests replace it
+var dialTCP = net.DialTimeout
+
+// tcpOutcome is what a TCP connection attempt ran into
+type tcpOutcome string
+
+const (
+	// tcpOpen: the handshake completed and the connection stayed up
+	tcpOpen tcpOutcome = "open"
+	// tcpRefused: the host answered the SYN with a reset, so it is alive
+	// but nothing listens on the port
+	tcpRefused tcpOutcome = "refused"
+	// tcpReset: the handshake completed, then the connection was reset,
+	// as by a service that is overloaded or a middlebox cutting sessions
+	tcpReset tcpOutcome = "reset"
+	// tcpTimeout: no answer at all, the host or the path is dead or the
+	// port is filtered
+	tcpTimeout tcpOutcome = "timeout"
+	// tcpUnreachable: a router answered that the host cannot be reached
+	tcpUnreachable tcpOutcome = "unreachable"
+	// tcpLocal: the connection never left this host (local firewall, out
+	// of sockets), a failure of the monitor rather than the network
+	tcpLocal tcpOutcome = "local"
+	tcpOther tcpOutcome = "error"
+)
+
+// tcpSuccessOutcomes are the outcomes tcp_probe.success may list; the
+// others never tell that the target is fine
+var tcpSuccessOutcomes = []tcpOutcome{tcpOpen, tcpRefused, tcpReset}
+
+// reason returns the failure reason of an outcome not counted as success
+func (o tcpOutcome) reason() failureReason {
+	switch o {
+	case tcpOpen:
+		return reasonOpen
+	case tcpRefused:
+		return reasonRefused
+	case tcpReset:
+		return reasonReset
+	case tcpTimeout:
+		return reasonTimeout
+	case tcpUnreachable:
+		return reasonUnreachable
+	case tcpLocal:
+		return reasonMeasurement
+	}
+	return reasonError
+}
+
+// Winsock error codes, which Windows reports instead of the POSIX ones
+const (
+	wsaeaccess       syscall.Errno = 10013
+	wsaenobufs       syscall.Errno = 10055
+	wsaenetunreach   syscall.Errno = 10051
+	wsaeconnaborted  syscall.Errno = 10053
+	wsaeconnreset    syscall.Errno = 10054
+	wsaetimedout     syscall.Errno = 10060
+	wsaeconnrefused  syscall.Errno = 10061
+	wsaehostunreach  syscall.Errno = 10065
+	wsaemfile        syscall.Errno = 10024
+	wsaeaddrnotavail syscall.Errno = 10049
+)
+
+// tcpErrnoOutcome classifies the error number of a connect or read
+func tcpErrnoOutcome(errno syscall.Errno) tcpOutcome {
+	if runtime.GOOS == "windows" {
+		switch errno {
+		case wsaeconnrefused:
+			return tcpRefused
+		case wsaeconnreset, wsaeconnaborted:
+			return tcpReset
+		case wsaetimedout:
+			return tcpTimeout
+		case wsaehostunreach, wsaenetunreach:
+			return tcpUnreachable
+		case wsaeaccess, wsaenobufs, wsaemfile, wsaeaddrnotavail:
+			return tcpLocal
+		}
+		return tcpOther
+	}
+	switch errno {
+	case syscall.ECONNREFUSED:
+		return tcpRefused
+	case syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE:
+		return tcpReset
+	case syscall.ETIMEDOUT:
+		return tcpTimeout
+	case syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+		return tcpUnreachable
+	// EPERM is what a local firewall rule rejecting the packet returns
+	case syscall.EPERM, syscall.EACCES, syscall.ENOBUFS, syscall.EMFILE, syscall.EADDRNOTAVAIL:
+		return tcpLocal
+	}
+	return tcpOther
+}
+
+// classifyTCPError classifies a dial or read error
+func classifyTCPError(err error) tcpOutcome {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return tcpErrnoOutcome(errno)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return tcpTimeout
+	}
+	return tcpOther
+}
+
+// TCPProbeConfig replaces ping for the target with a TCP connection to a
+// port, for hosts and paths that drop ICMP or where a service matters
+// more than the host.
+type TCPProbeConfig struct {
+	Port int `json:"port"`
+	// Success lists the outcomes counted as success: open, refused and
+	// reset (default ["open"]). ["refused"] watches a port expected closed,
+	// which tells the host is up without anything listening.
+	Success []string `json:"success"`
+	// Timeout is how long the handshake may take (default 3s)
+	Timeout Duration `json:"timeout"`
+}
+
+// validate checks the port, the outcomes and the timeout
+func (c TCPProbeConfig) validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("tcp_probe.port は1〜65535で指定してください: %d", c.Port)
+	}
+	for _, s := range c.Success {
+		if !slices.Contains(tcpSuccessOutcomes, tcpOutcome(s)) {
+			return fmt.Errorf("tcp_probe.success の値が正しくありません: %q (open / refused / reset)", s)
+		}
+	}
+	if c.Timeout.given() {
+		return c.Timeout.check("tcp_probe.timeout", positiveDuration, tcpProbeMaxTimeout, "3s")
+	}
+	return nil
+}
+
+// tcpProber probes the target by connecting to a port
+type tcpProber struct {
+	port    string
+	success []tcpOutcome
+	timeout time.Duration
+}
+
+// newTCPProber prepares a validated tcp_probe
+func newTCPProber(c TCPProbeConfig) *tcpProber {
+	p := &tcpProber{port: strconv.Itoa(c.Port), timeout: c.Timeout.or(pingTimeout)}
+	for _, s := range c.Success {
+		p.success = append(p.success, tcpOutcome(s))
+	}
+	if len(p.success) == 0 {
+		p.success = []tcpOutcome{tcpOpen}
+	}
+	return p
+}
+
+// connect attempts one connection to host and returns the outcome, the
+// handshake time, and the error behind an outcome other than open
+func (p *tcpProber) connect(host string) (tcpOutcome, time.Duration, error) {
+	start := time.Now()
+	conn, err := dialTCP("tcp", net.JoinHostPort(host, p.port), p.timeout)
+	rtt := time.Since(start)
+	if err != nil {
+		return classifyTCPError(err), rtt, err
+	}
+	defer conn.Close()
+	// A reset follows the handshake within a round trip; data, EOF or
+	// silence all mean the service kept the connection
+	conn.SetReadDeadline(time.Now().Add(tcpResetWait))
+	if _, err := conn.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		if classifyTCPError(err) == tcpReset {
+			return tcpReset, rtt, err
+		}
+	}
+	return tcpOpen, rtt, nil
+}
+
+// Probe connects to the port of host. The response time is the handshake,
+// or the time to the refusal when refused counts as success.
+func (p *tcpProber) Probe(host string) (float64, error) {
+	outcome, rtt, err := p.connect(host)
+	if slices.Contains(p.success, outcome) {
+		return float64(rtt.Nanoseconds()) / 1000000, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("port %s accepted the connection", p.port)
+	}
+	return 0, &probeError{reason: outcome.reason(), err: err, output: fmt.Sprintf("tcp %s: %s (%v)", net.JoinHostPort(host, p.port), outcome, err)}
+}
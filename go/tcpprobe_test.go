@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// tcpListener listens on a free loopback port and handles each accepted
+// connection with handle, nil holding it open
+func tcpListener(t *testing.T, handle func(*net.TCPConn)) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if handle == nil {
+				t.Cleanup(func() { conn.Close() })
+				continue
+			}
+			handle(conn.(*net.TCPConn))
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a loopback port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+// TestTCPProber connects to local listeners that keep, close, reset or
+// refuse the connection, under each choice of tcp_probe.success
+func TestTCPProber(t *testing.T) {
+	open := tcpListener(t, nil)
+	greeting := tcpListener(t, func(c *net.TCPConn) {
+		c.Write([]byte("220 ready\r\n"))
+		time.Sleep(50 * time.Millisecond)
+		c.Close()
+	})
+	closing := tcpListener(t, func(c *net.TCPConn) { c.Close() })
+	reset := tcpListener(t, func(c *net.TCPConn) {
+		c.SetLinger(0)
+		c.Close()
+	})
+	refused := closedPort(t)
+
+	tests := []struct {
+		name    string
+		port    int
+		success []string
+		outcome tcpOutcome
+		reason  failureReason // "" for a success
+	}{
+		{"open", open, nil, tcpOpen, ""},
+		{"banner then close", greeting, nil, tcpOpen, ""},
+		{"closed gracefully", closing, nil, tcpOpen, ""},
+		{"reset after the handshake", reset, nil, tcpReset, reasonReset},
+		{"refused", refused, nil, tcpRefused, reasonRefused},
+		{"refused expected", refused, []string{"refused"}, tcpRefused, ""},
+		{"reset accepted", reset, []string{"open", "reset"}, tcpReset, ""},
+		{"open where refused is expected", open, []string{"refused"}, tcpOpen, reasonOpen},
+	}
+	for _, tt := range tests {
+		cfg := TCPProbeConfig{Port: tt.port, Success: tt.success}
+		if err := cfg.validate(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		p := newTCPProber(cfg)
+		if outcome, _, _ := p.connect("127.0.0.1"); outcome != tt.outcome {
+			t.Errorf("%s: outcome %s, want %s", tt.name, outcome, tt.outcome)
+			continue
+		}
+		ms, err := p.Probe("127.0.0.1")
+		if tt.reason == "" {
+			if err != nil || ms <= 0 || ms > 3000 {
+				t.Errorf("%s: %v, %v", tt.name, ms, err)
+			}
+			continue
+		}
+		var pe *probeError
+		if !errors.As(err, &pe) || pe.reason != tt.reason {
+			t.Errorf("%s: %v, want reason %s", tt.name, err, tt.reason)
+			continue
+		}
+		want := fmt.Sprintf("tcp 127.0.0.1:%d: %s", tt.port, tt.outcome)
+		if !strings.HasPrefix(pe.output, want) {
+			t.Errorf("%s: output %q, want %q...", tt.name, pe.output, want)
+		}
+	}
+}
+
+// TestTCPProberFiltered probes ports behind a firewall, which the
+// loopback cannot reproduce, with the errors the dial gets from each kind
+// of rule
+func TestTCPProberFiltered(t *testing.T) {
+	errno := func(unix, windows syscall.Errno) error {
+		if runtime.GOOS == "windows" {
+			return os.NewSyscallError("connectex", windows)
+		}
+		return os.NewSyscallError("connect", unix)
+	}
+	tests := []struct {
+		name   string
+		err    error
+		reason failureReason
+	}{
+		{"SYN dropped", timeoutError{}, reasonTimeout},
+		{"SYN dropped, kernel gave up", errno(syscall.ETIMEDOUT, wsaetimedout), reasonTimeout},
+		// A REJECT with a reset cannot be told from a closed port
+		{"rejected with a reset", errno(syscall.ECONNREFUSED, wsaeconnrefused), reasonRefused},
+		{"rejected with ICMP prohibited", errno(syscall.EHOSTUNREACH, wsaehostunreach), reasonUnreachable},
+		{"blocked on this host", errno(syscall.EPERM, wsaeaccess), reasonMeasurement},
+	}
+	defer func(orig func(string, string, time.Duration) (net.Conn, error)) { dialTCP = orig }(dialTCP)
+	for _, tt := range tests {
+		var timeout time.Duration
+		dialTCP = func(network, address string, d time.Duration) (net.Conn, error) {
+			timeout = d
+			return nil, &net.OpError{Op: "dial", Net: network, Err: tt.err}
+		}
+		_, err := newTCPProber(TCPProbeConfig{Port: 443, Timeout: makeDuration(250 * time.Millisecond)}).Probe("192.0.2.1")
+		var pe *probeError
+		if !errors.As(err, &pe) || pe.reason != tt.reason {
+			t.Errorf("%s: %v, want reason %s", tt.name, err, tt.reason)
+		}
+		if timeout != 250*time.Millisecond {
+			t.Errorf("%s: dialed with timeout %v", tt.name, timeout)
+		}
+	}
+}
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyTCPError(t *testing.T) {
+	errnos := map[syscall.Errno]tcpOutcome{
+		syscall.ECONNREFUSED: tcpRefused,
+		syscall.ECONNRESET:   tcpReset,
+		syscall.ECONNABORTED: tcpReset,
+		syscall.ETIMEDOUT:    tcpTimeout,
+		syscall.EHOSTUNREACH: tcpUnreachable,
+		syscall.ENETUNREACH:  tcpUnreachable,
+		// A local firewall rejecting the SYN
+		syscall.EPERM:  tcpLocal,
+		syscall.EACCES: tcpLocal,
+		syscall.EMFILE: tcpLocal,
+	}
+	if runtime.GOOS == "windows" {
+		errnos = map[syscall.Errno]tcpOutcome{
+			wsaeconnrefused: tcpRefused,
+			wsaeconnreset:   tcpReset,
+			wsaeconnaborted: tcpReset,
+			wsaetimedout:    tcpTimeout,
+			wsaehostunreach: tcpUnreachable,
+			wsaenetunreach:  tcpUnreachable,
+			wsaeaccess:      tcpLocal,
+			wsaenobufs:      tcpLocal,
+		}
+	}
+	for errno, want := range errnos {
+		// as net.Dial wraps it
+		err := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+		if got := classifyTCPError(err); got != want {
+			t.Errorf("%v: %s, want %s", errno, got, want)
+		}
+	}
+	if got := classifyTCPError(&net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}); got != tcpTimeout {
+		t.Errorf("timeout: %s", got)
+	}
+	if got := classifyTCPError(errors.New("something else")); got != tcpOther {
+		t.Errorf("other: %s", got)
+	}
+	if tcpLocal.reason() != reasonMeasurement || tcpOther.reason() != reasonError {
+		t.Error("a local failure must count as a measurement error")
+	}
+}
+
+func TestTCPProbeValidate(t *testing.T) {
+	tests := []struct {
+		cfg  TCPProbeConfig
+		want string
+	}{
+		{TCPProbeConfig{}, "tcp_probe.port"},
+		{TCPProbeConfig{Port: 65536}, "tcp_probe.port"},
+		{TCPProbeConfig{Port: 443, Success: []string{"timeout"}}, `"timeout"`},
+		{TCPProbeConfig{Port: 443, Timeout: makeDuration(time.Minute)}, "tcp_probe.timeout"},
+		{TCPProbeConfig{Port: 443, Success: []string{"open", "refused", "reset"}, Timeout: makeDuration(time.Second)}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", tt.cfg, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: %v, want %q", tt.cfg, err, tt.want)
+		}
+	}
+	if p := newTCPProber(TCPProbeConfig{Port: 443}); p.port != strconv.Itoa(443) || len(p.success) != 1 || p.success[0] != tcpOpen || p.timeout != pingTimeout {
+		t.Errorf("defaults %+v", p)
+	}
+}
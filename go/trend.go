@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The loss trend is a least-squares fit over the last 30 minutes in
+// 5-minute buckets
+const (
+	trendBuckets      = 6
+	trendBucketLength = 5 * time.Minute
+	// trendMinFit is the minimum R² of the fit, so a single spike does not
+	// pass as a steady rise
+	trendMinFit = 0.7
+)
+
+// lossTrend is a detected steady rise of the loss percentage
+type lossTrend struct {
+	At      time.Time
+	Buckets []float64
+	Slope   float64
+	Context AlertContext
+}
+
+// lossBuckets returns the loss percentage of each 5-minute bucket of the
+// 30 minutes before now, oldest first; ok is false when a bucket has no
+// samples
+func lossBuckets(aggs []MinuteAggregate, now time.Time) (buckets []float64, ok bool) {
+	start := now.Truncate(time.Minute).Add(-trendBuckets * trendBucketLength)
+	counts := make([]int, trendBuckets)
+	failures := make([]int, trendBuckets)
+	for _, a := range aggs {
+		if a.Minute.Before(start) || !a.Minute.Before(now.Truncate(time.Minute)) {
+			continue
+		}
+		i := int(a.Minute.Sub(start) / trendBucketLength)
+		counts[i] += a.Count
+		failures[i] += a.Count - a.Success
+	}
+	buckets = make([]float64, trendBuckets)
+	for i := range buckets {
+		if counts[i] == 0 {
+			return nil, false
+		}
+		buckets[i] = float64(failures[i]) / float64(counts[i]) * 100
+	}
+	return buckets, true
+}
+
+// linearFit returns the slope per step and R² of a least-squares line
+// through ys at x = 0, 1, 2, ...
+func linearFit(ys []float64) (slope, r2 float64) {
+	n := float64(len(ys))
+	var sx, sy, sxx, sxy, syy float64
+	for i, y := range ys {
+		x := float64(i)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+		syy += y * y
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, 0
+	}
+	slope = (n*sxy - sx*sy) / den
+	vy := n*syy - sy*sy
+	if vy == 0 {
+		return slope, 0
+	}
+	cov := n*sxy - sx*sy
+	return slope, cov * cov / (den * vy)
+}
+
+// trendDetector reports a steady loss increase at most once per day.
+// It is evaluated once per minute, on completed minutes only.
+type trendDetector struct {
+	// slope is the threshold in percentage points per bucket; 0 disables
+	slope     float64
+	lastCheck int64
+	lastDay   string
+}
+
+// newTrendDetector creates a detector; slope == 0 disables it
+func newTrendDetector(slope float64) *trendDetector {
+	return &trendDetector{slope: slope}
+}
+
+// check evaluates the trend at now on the minutes returned by aggs, which
+// is only called when the detector is due. down suppresses the hint, since
+// an outage is already being reported.
+func (d *trendDetector) check(now time.Time, aggs func() []MinuteAggregate, down bool) *lossTrend {
+	minute := now.Unix() / 60
+	if d.slope == 0 || minute == d.lastCheck {
+		return nil
+	}
+	d.lastCheck = minute
+	day := now.Format(reportDateLayout)
+	if down || day == d.lastDay {
+		return nil
+	}
+	buckets, ok := lossBuckets(aggs(), now)
+	if !ok {
+		return nil
+	}
+	slope, r2 := linearFit(buckets)
+	if slope < d.slope || r2 < trendMinFit || buckets[len(buckets)-1] <= buckets[0] {
+		return nil
+	}
+	d.lastDay = day
+	ctx := AlertContext{Rule: "loss_trend", Metric: "傾き", Observed: slope, Op: ">=", Threshold: d.slope, Unit: "ポイント/5分",
+		Window: fmt.Sprintf("直近%d分、R² %.2f ≥ %.1f", int((trendBuckets * trendBucketLength).Minutes()), r2, trendMinFit)}
+	return &lossTrend{At: now, Buckets: buckets, Slope: slope, Context: ctx}
+}
+
+// checkLossTrend runs the detector on the target's recent minutes
+func (pm *PingMonitor) checkLossTrend(now time.Time) {
+	down, _ := pm.outages.state()
+	// The 24h snapshot is only built once the detector is due
+	tr := pm.trend.check(now, func() []MinuteAggregate { return pm.series.snapshot(pm.targetIP, now) }, down)
+	if tr == nil {
+		return
+	}
+	steps := make([]string, len(tr.Buckets))
+	for i, b := range tr.Buckets {
+		steps[i] = fmt.Sprintf("%.1f%%", b)
+	}
+	fmt.Printf("📉 パケットロスが増加傾向です（%+.1fポイント/5分）\n", tr.Slope)
+	pm.dispatcher.dispatch(Event{
+		Kind:     EventLossTrend,
+		Severity: SeverityInfo,
+		Time:     tr.At,
+		Title:    "📉 パケットロス 増加傾向",
+		Message: fmt.Sprintf("**対象**: %s\n**直近30分（5分ごと）**: %s\n**傾き**: %+.1fポイント/5分\n障害の前兆の可能性があります（この通知は1日1回までです）",
+			pm.targetLabel(), strings.Join(steps, " → "), tr.Slope) + tr.Context.messageLine(),
+		Data: map[string]interface{}{
+			"target":         pm.targetIP,
+			"loss_buckets":   tr.Buckets,
+			"slope_per_5min": tr.Slope,
+			"rule":           tr.Context,
+		},
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// lossCurve returns the minute aggregates of the 30 minutes before now,
+// with 100 probes a minute and loss[i] percent in the minutes of bucket i
+func lossCurve(now time.Time, loss [trendBuckets]int) []MinuteAggregate {
+	var aggs []MinuteAggregate
+	for m := trendBuckets * 5; m > 0; m-- {
+		bucket := (trendBuckets*5 - m) / 5
+		aggs = append(aggs, MinuteAggregate{Minute: now.Add(-time.Duration(m) * time.Minute), Count: 100, Success: 100 - loss[bucket]})
+	}
+	return aggs
+}
+
+func TestLinearFit(t *testing.T) {
+	tests := []struct {
+		ys        []float64
+		slope, r2 float64
+	}{
+		{[]float64{0, 1, 2, 3, 4, 5}, 1, 1},
+		{[]float64{10, 8, 6, 4, 2, 0}, -2, 1},
+		{[]float64{3, 3, 3, 3, 3, 3}, 0, 0},
+		// Least squares through (0,0) (1,0) (2,3): slope 1.5, R² 0.75
+		{[]float64{0, 0, 3}, 1.5, 0.75},
+		{[]float64{7}, 0, 0},
+	}
+	for _, tt := range tests {
+		slope, r2 := linearFit(tt.ys)
+		if math.Abs(slope-tt.slope) > 1e-9 || math.Abs(r2-tt.r2) > 1e-9 {
+			t.Errorf("linearFit(%v) = %v, %v; want %v, %v", tt.ys, slope, r2, tt.slope, tt.r2)
+		}
+	}
+}
+
+// TestTrendCurves runs synthetic degradation curves through the detector
+// with a threshold of 1 point per bucket
+func TestTrendCurves(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		loss [trendBuckets]int
+		down bool
+		want bool
+	}{
+		{"steady rise", [trendBuckets]int{0, 1, 2, 3, 4, 5}, false, true},
+		{"steep rise", [trendBuckets]int{1, 4, 8, 12, 15, 20}, false, true},
+		{"noisy rise", [trendBuckets]int{0, 2, 1, 4, 3, 6}, false, true},
+		{"rise below the threshold", [trendBuckets]int{0, 0, 1, 1, 2, 2}, false, false},
+		{"flat", [trendBuckets]int{2, 2, 2, 2, 2, 2}, false, false},
+		{"no loss", [trendBuckets]int{}, false, false},
+		{"falling", [trendBuckets]int{5, 4, 3, 2, 1, 0}, false, false},
+		// One bad bucket is a spike, not a trend: R² is 0.43
+		{"single spike", [trendBuckets]int{0, 0, 0, 0, 0, 10}, false, false},
+		{"rise and recovery", [trendBuckets]int{0, 6, 7, 8, 9, 0}, false, false},
+		{"steady rise in an outage", [trendBuckets]int{0, 1, 2, 3, 4, 5}, true, false},
+	}
+	for _, tt := range tests {
+		tr := newTrendDetector(1).check(now, func() []MinuteAggregate { return lossCurve(now, tt.loss) }, tt.down)
+		if (tr != nil) != tt.want {
+			t.Errorf("%s: %+v, want a trend %v", tt.name, tr, tt.want)
+			continue
+		}
+		if tr == nil {
+			continue
+		}
+		for i, b := range tr.Buckets {
+			if b != float64(tt.loss[i]) {
+				t.Errorf("%s: buckets %v", tt.name, tr.Buckets)
+				break
+			}
+		}
+		if slope, _ := linearFit(tr.Buckets); tr.Slope != slope || tr.Context.Observed != slope || tr.Context.Threshold != 1 {
+			t.Errorf("%s: slope %v context %+v", tt.name, tr.Slope, tr.Context)
+		}
+	}
+
+	// A minute without samples leaves its bucket out of the fit
+	aggs := lossCurve(now, [trendBuckets]int{0, 1, 2, 3, 4, 5})
+	gap := append(append([]MinuteAggregate(nil), aggs[:10]...), aggs[15:]...)
+	if tr := newTrendDetector(1).check(now, func() []MinuteAggregate { return gap }, false); tr != nil {
+		t.Errorf("empty bucket: %+v", tr)
+	}
+	if tr := newTrendDetector(0).check(now, func() []MinuteAggregate { return aggs }, false); tr != nil {
+		t.Errorf("disabled: %+v", tr)
+	}
+}
+
+// TestTrendRateLimit keeps a rise going for two days: one hint a day,
+// the detector evaluated at most once a minute
+func TestTrendRateLimit(t *testing.T) {
+	start := time.Date(2026, 3, 10, 23, 0, 0, 0, time.UTC)
+	d := newTrendDetector(1)
+	calls, hints := 0, map[string]int{}
+	for m := 0; m < 24*60; m++ {
+		for _, s := range []int{0, 20, 40} {
+			now := start.Add(time.Duration(m)*time.Minute + time.Duration(s)*time.Second)
+			tr := d.check(now, func() []MinuteAggregate {
+				calls++
+				return lossCurve(now, [trendBuckets]int{0, 1, 2, 3, 4, 5})
+			}, false)
+			if tr != nil {
+				hints[now.Format(reportDateLayout)]++
+			}
+		}
+	}
+	if hints["2026-03-10"] != 1 || hints["2026-03-11"] != 1 || len(hints) != 2 {
+		t.Errorf("hints per day %v, want one each", hints)
+	}
+	// The aggregates are only read on the first check of a minute, until
+	// the day's hint was sent
+	if calls != 2 {
+		t.Errorf("aggregates read %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smsMaxChars is the hard length limit of a single SMS body
+const smsMaxChars = 160
+
+// TwilioConfig configures SMS alerting through Twilio
+type TwilioConfig struct {
+	AccountSID string   `json:"account_sid"`
+	AuthToken  string   `json:"auth_token" secret:"true"`
+	From       string   `json:"from"`
+	To         []string `json:"to"`
+	DailyCap   int      `json:"daily_cap"`
+	// NotifierRouting holds min_severity and events (see routing.go)
+	NotifierRouting
+}
+
+// twilioNotifier sends concise SMS alerts for severe events within a
+// strict per-day message budget
+type twilioNotifier struct {
+	cfg     TwilioConfig
+	client  *http.Client
+	apiBase string
+
+	mutex     sync.Mutex
+	day       string
+	sentToday int
+	lastError string
+	lastErrAt time.Time
+	lastSent  time.Time
+}
+
+// TwilioStatus is the notifier's entry in /status
+type TwilioStatus struct {
+	SentToday      int       `json:"sent_today"`
+	DailyCap       int       `json:"daily_cap"`
+	RemainingToday int       `json:"remaining_today"`
+	LastSent       time.Time `json:"last_sent,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+	LastErrorAt    time.Time `json:"last_error_at,omitempty"`
+}
+
+// newTwilioNotifier creates the notifier; config values are validated by
+// Config.validate
+func newTwilioNotifier(cfg TwilioConfig) *twilioNotifier {
+	return &twilioNotifier{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		apiBase: "https://api.twilio.com",
+	}
+}
+
+// Name returns the notifier name used in logs
+func (n *twilioNotifier) Name() string {
+	return "Twilio"
+}
+
+// accepts passes only alerts; the severity is left to the routing
+func (n *twilioNotifier) accepts(ev Event) bool {
+	return ev.Kind != EventReport
+}
+
+// routing returns min_severity and events
+func (n *twilioNotifier) routing() NotifierRouting {
+	return n.cfg.NotifierRouting
+}
+
+// smsBody renders ev in the short form used for SMS, e.g.
+// "ネット停止 14:03開始 / GWは応答あり" or "ネット復旧 14:20 (17分)"
+func smsBody(ev Event) string {
+	var body string
+	switch ev.Kind {
+	case EventOutage:
+		gw, _ := ev.Data["gateway"].(string)
+		gwText := "GW不明"
+		switch gatewayState(gw) {
+		case gatewayReachable:
+			gwText = "GWは応答あり"
+		case gatewayUnreachable:
+			gwText = "GWも応答なし"
+		case gatewayIsTarget:
+			gwText = "GW(監視対象)応答なし"
+		}
+		body = fmt.Sprintf("ネット停止 %s開始 / %s", ev.Time.Format("15:04"), gwText)
+	case EventRecovery:
+		body = fmt.Sprintf("ネット復旧 %s", ev.Time.Format("15:04"))
+		if secs, ok := ev.Data["duration_seconds"].(float64); ok {
+			body += fmt.Sprintf(" (%d分)", int(math.Round(secs/60)))
+		}
+	default:
+		body = ev.Title
+	}
+	if ev.Simulated {
+		body = "[SIMULATED] " + body
+	}
+	return truncateRunes(body, smsMaxChars)
+}
+
+// truncateRunes shortens s to at most n runes without splitting a rune
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// reserve takes one message from today's budget
+func (n *twilioNotifier) reserve(now time.Time) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if today := now.Format(reportDateLayout); today != n.day {
+		n.day = today
+		n.sentToday = 0
+	}
+	if n.sentToday >= n.cfg.DailyCap {
+		return false
+	}
+	n.sentToday++
+	return true
+}
+
+// Notify sends the SMS to every recipient while the daily cap allows
+func (n *twilioNotifier) Notify(ev Event) error {
+	body := smsBody(ev)
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", n.apiBase, url.PathEscape(n.cfg.AccountSID))
+
+	for _, to := range n.cfg.To {
+		if !n.reserve(time.Now()) {
+			err := fmt.Errorf("本日のSMS上限(%d通)に達したため送信しません", n.cfg.DailyCap)
+			n.recordResult(err)
+			return err
+		}
+		form := url.Values{}
+		form.Set("To", to)
+		form.Set("From", n.cfg.From)
+		form.Set("Body", body)
+
+		err := retryTransient(func() error {
+			req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth(n.cfg.AccountSID, n.cfg.AuthToken)
+			return doNotifierRequest(n.client, req)
+		})
+		n.recordResult(err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordResult keeps the latest delivery outcome for /status
+func (n *twilioNotifier) recordResult(err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if err != nil {
+		n.lastError = err.Error()
+		n.lastErrAt = time.Now()
+		return
+	}
+	n.lastSent = time.Now()
+}
+
+// status reports today's quota and the last error
+func (n *twilioNotifier) status() interface{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	sent := n.sentToday
+	if n.day != time.Now().Format(reportDateLayout) {
+		sent = 0
+	}
+	return TwilioStatus{
+		SentToday:      sent,
+		DailyCap:       n.cfg.DailyCap,
+		RemainingToday: n.cfg.DailyCap - sent,
+		LastSent:       n.lastSent,
+		LastError:      n.lastError,
+		LastErrorAt:    n.lastErrAt,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// blackoutKeep is how long finished blackouts are kept for outages that
+	// began before them
+	blackoutKeep = 7 * 24 * time.Hour
+	// blackoutMaxSpans bounds the finished blackouts kept
+	blackoutMaxSpans = 1000
+)
+
+// blackoutSpan is a time during which no notification could be delivered
+type blackoutSpan struct {
+	Start time.Time
+	End   time.Time
+}
+
+// deliveryBlackout tracks the times during which every delivery attempt
+// failed. A blackout starts with a failed attempt and lasts until any
+// notifier delivers something, so a local notifier that still works
+// during an internet outage keeps it from being counted.
+type deliveryBlackout struct {
+	mutex sync.Mutex
+	now   func() time.Time
+	// since is the start of the current blackout, nil when the last
+	// attempt got through
+	since *time.Time
+	spans []blackoutSpan
+}
+
+// newDeliveryBlackout creates a tracker reading the time from now
+func newDeliveryBlackout(now func() time.Time) *deliveryBlackout {
+	return &deliveryBlackout{now: now}
+}
+
+// record notes the outcome of a delivery attempt started at start
+func (b *deliveryBlackout) record(start time.Time, delivered bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !delivered {
+		if b.since == nil {
+			b.since = &start
+		}
+		return
+	}
+	if b.since == nil {
+		return
+	}
+	now := b.now()
+	b.spans = append(b.spans, blackoutSpan{Start: *b.since, End: now})
+	b.since = nil
+	for len(b.spans) > 0 && (len(b.spans) > blackoutMaxSpans || now.Sub(b.spans[0].End) > blackoutKeep) {
+		b.spans = b.spans[1:]
+	}
+}
+
+// during returns how much of from–to fell in blackouts, the current one
+// included
+func (b *deliveryBlackout) during(from, to time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	spans := b.spans
+	if b.since != nil {
+		spans = append(spans[:len(spans):len(spans)], blackoutSpan{Start: *b.since, End: b.now()})
+	}
+	var total time.Duration
+	for _, s := range spans {
+		start, end := s.Start, s.End
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// RouterSNMPConfig configures polling of the router's WAN interface
+// counters over SNMP v2c
+type RouterSNMPConfig struct {
+	// Address is host or host:port of the router's agent (port 161)
+	Address   string `json:"address"`
+	Community string `json:"community" secret:"true"`
+	IfIndex   int    `json:"if_index"`
+	// Interval is the polling interval (default 1m)
+	Interval Duration `json:"interval"`
+}
+
+// defaultRouterSNMPInterval is the polling interval used when none is set
+const defaultRouterSNMPInterval = time.Minute
+
+// routerSNMPTimeout bounds one request, so a silent router never delays
+// anything but the next poll
+const routerSNMPTimeout = 2 * time.Second
+
+// IF-MIB ifTable columns (RFC 2863)
+var (
+	oidIfInErrors    = snmpOID{1, 3, 6, 1, 2, 1, 2, 2, 1, 14}
+	oidIfOutDiscards = snmpOID{1, 3, 6, 1, 2, 1, 2, 2, 1, 19}
+)
+
+// interval returns the polling interval, applying the default
+func (c RouterSNMPConfig) interval() (time.Duration, error) {
+	if !c.Interval.given() {
+		return defaultRouterSNMPInterval, nil
+	}
+	if err := c.Interval.check("router_snmp.interval", 10*time.Second, 0, "1m"); err != nil {
+		return 0, err
+	}
+	return c.Interval.Duration(), nil
+}
+
+// validate checks the required keys
+func (c RouterSNMPConfig) validate() error {
+	if c.Address == "" || c.Community == "" || c.IfIndex <= 0 {
+		return fmt.Errorf("router_snmp.address、router_snmp.community、router_snmp.if_index（1以上）を指定してください")
+	}
+	_, err := c.interval()
+	return err
+}
+
+// snmpGet sends a v2c GET for names and returns the values in order
+func snmpGet(address, community string, names []snmpOID) ([]snmpValue, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "161")
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(routerSNMPTimeout))
+
+	req := &snmpMessage{version: snmpVersion2c, community: community, pdu: snmpPDU{typ: pduGetRequest, requestID: rand.Int63n(1 << 31)}}
+	for _, name := range names {
+		req.pdu.varBinds = append(req.pdu.varBinds, snmpVarBind{name: name, value: snmpValue{tag: berNull}})
+	}
+	if _, err := conn.Write(req.encode()); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := decodeSNMPMessage(buf[:n])
+		// Late answers to an earlier request are skipped
+		if err != nil || resp.pdu.typ != pduResponse || resp.pdu.requestID != req.pdu.requestID {
+			continue
+		}
+		if resp.pdu.errorStatus != 0 {
+			return nil, fmt.Errorf("SNMPエラー (error-status %d)", resp.pdu.errorStatus)
+		}
+		if len(resp.pdu.varBinds) != len(names) {
+			return nil, errBER
+		}
+		values := make([]snmpValue, len(names))
+		for i, vb := range resp.pdu.varBinds {
+			values[i] = vb.value
+		}
+		return values, nil
+	}
+}
+
+// counterValue decodes a Counter32 value. Some agents omit the leading
+// zero octet of values with the top bit set, so the content is read as
+// unsigned.
+func counterValue(v snmpValue) (uint32, error) {
+	switch v.tag {
+	case berCounter32, berGauge32, berInteger:
+	case snmpNoSuchObject, snmpNoSuchInstance, snmpEndOfMibView:
+		return 0, errors.New("オブジェクトがありません（if_indexを確認してください）")
+	default:
+		return 0, errBER
+	}
+	content := bytes.TrimLeft(v.content, "\x00")
+	if len(v.content) == 0 || len(content) > 4 {
+		return 0, errBER
+	}
+	var n uint32
+	for _, d := range content {
+		n = n<<8 | uint32(d)
+	}
+	return n, nil
+}
+
+// wanErrorSample is the counter increase over one polling interval
+type wanErrorSample struct {
+	From        time.Time
+	To          time.Time
+	InErrors    uint32
+	OutDiscards uint32
+}
+
+// routerPoller polls the router's counters and turns them into deltas
+type routerPoller struct {
+	cfg      RouterSNMPConfig
+	get      func(address, community string, names []snmpOID) ([]snmpValue, error)
+	have     bool
+	last     time.Time
+	inErr    uint32
+	outDisc  uint32
+	failing  bool
+	interval time.Duration
+}
+
+// newRouterPoller creates a poller; the config is validated by
+// Config.validate
+func newRouterPoller(cfg RouterSNMPConfig) *routerPoller {
+	interval, _ := cfg.interval()
+	return &routerPoller{cfg: cfg, get: snmpGet, interval: interval}
+}
+
+// poll reads the counters and returns the delta since the previous read.
+// Counter32 wraps, which unsigned subtraction already accounts for.
+func (r *routerPoller) poll(now time.Time) (*wanErrorSample, error) {
+	values, err := r.get(r.cfg.Address, r.cfg.Community, []snmpOID{
+		oidIfInErrors.child(uint32(r.cfg.IfIndex)),
+		oidIfOutDiscards.child(uint32(r.cfg.IfIndex)),
+	})
+	if err != nil {
+		r.have = false
+		return nil, err
+	}
+	inErr, err := counterValue(values[0])
+	if err != nil {
+		r.have = false
+		return nil, err
+	}
+	outDisc, err := counterValue(values[1])
+	if err != nil {
+		r.have = false
+		return nil, err
+	}
+	var sample *wanErrorSample
+	// A gap longer than two intervals (router reboot, lost polls) gives no
+	// usable delta
+	if r.have && now.Sub(r.last) <= 2*r.interval {
+		sample = &wanErrorSample{From: r.last, To: now, InErrors: inErr - r.inErr, OutDiscards: outDisc - r.outDisc}
+	}
+	r.have, r.last, r.inErr, r.outDisc = true, now, inErr, outDisc
+	return sample, nil
+}
+
+// routerLoop polls the router until the monitor stops. Errors are logged
+// only when the state changes, and never touch the ping loop.
+func (pm *PingMonitor) routerLoop(r *routerPoller) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		sample, err := r.poll(time.Now())
+		switch {
+		case err != nil && !r.failing:
+			fmt.Printf("❌ ルーターのSNMP取得エラー: %v\n", err)
+			r.failing = true
+		case err == nil && r.failing:
+			fmt.Println("✅ ルーターのSNMP取得が再開しました")
+			r.failing = false
+		}
+		if sample != nil {
+			pm.mutex.Lock()
+			pm.wanErrors = append(pm.wanErrors, *sample)
+			pm.mutex.Unlock()
+		}
+		select {
+		case <-pm.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wanErrorSummary correlates the WAN counters with measured loss
+type wanErrorSummary struct {
+	Samples     int
+	InErrors    uint64
+	OutDiscards uint64
+	// LossIntervals is the number of intervals with failed probes, and
+	// Correlated those among them in which the counters also increased
+	LossIntervals int
+	Correlated    int
+}
+
+// summarizeWANErrors totals the samples and checks, interval by interval,
+// whether probe failures coincided with counter increases
+func summarizeWANErrors(samples []wanErrorSample, failures []time.Time) wanErrorSummary {
+	s := wanErrorSummary{Samples: len(samples)}
+	for _, w := range samples {
+		s.InErrors += uint64(w.InErrors)
+		s.OutDiscards += uint64(w.OutDiscards)
+		lost := false
+		for _, t := range failures {
+			if t.After(w.From) && !t.After(w.To) {
+				lost = true
+				break
+			}
+		}
+		if !lost {
+			continue
+		}
+		s.LossIntervals++
+		if w.InErrors > 0 || w.OutDiscards > 0 {
+			s.Correlated++
+		}
+	}
+	return s
+}
+
+// String formats the summary for reports
+func (s wanErrorSummary) String() string {
+	lines := []string{fmt.Sprintf("受信エラー +%d / 送信破棄 +%d（%d区間）", s.InErrors, s.OutDiscards, s.Samples)}
+	switch {
+	case s.Correlated > 0:
+		lines = append(lines, fmt.Sprintf("損失発生時にWAN側エラー増加を確認（%d/%d区間）", s.Correlated, s.LossIntervals))
+	case s.LossIntervals > 0:
+		lines = append(lines, "損失発生時のWAN側エラー増加はありません")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"ping-monitor/api"
+)
+
+// watchdogMinStall is the shortest stall the watchdog acts on, so a short
+// ping interval does not mistake one slow probe for a stuck loop
+const watchdogMinStall = 30 * time.Second
+
+// Actions the watchdog takes on a stalled loop
+const (
+	watchdogAlert   = "alert"
+	watchdogRestart = "restart"
+	watchdogExit    = "exit"
+)
+
+// WatchdogConfig controls the check that the ping loop is still completing
+// cycles
+type WatchdogConfig struct {
+	// StallIntervals is how many ping intervals without a completed cycle
+	// count as a stall
+	StallIntervals int `json:"stall_intervals"`
+	// Action is what is done about a stall besides the alert: "alert"
+	// only, "restart" the loop or "exit" the process
+	Action string `json:"action"`
+}
+
+// validate checks the interval count and the action
+func (c WatchdogConfig) validate() error {
+	if c.StallIntervals < 1 {
+		return fmt.Errorf("watchdog.stall_intervals は1以上で指定してください")
+	}
+	switch c.Action {
+	case watchdogAlert, watchdogRestart, watchdogExit:
+		return nil
+	}
+	return fmt.Errorf("watchdog.action の値が正しくありません: %q (alert / restart / exit)", c.Action)
+}
+
+// stallLimit returns how long the loop may go without completing a cycle
+func (pm *PingMonitor) stallLimit() time.Duration {
+	return max(time.Duration(pm.config.Watchdog.StallIntervals)*pm.pingInterval, watchdogMinStall)
+}
+
+// markCycle restarts the stall clock. The time keeps its monotonic
+// reading, so a suspension of the host is not a stall.
+func (pm *PingMonitor) markCycle() {
+	now := time.Now()
+	pm.lastCycle.Store(&now)
+}
+
+// completeCycle records that the loop completed a cycle
+func (pm *PingMonitor) completeCycle() {
+	pm.cycles.Add(1)
+	pm.markCycle()
+}
+
+// sinceCycle returns how long ago the loop last completed a cycle
+func (pm *PingMonitor) sinceCycle() time.Duration {
+	if last := pm.lastCycle.Load(); last != nil {
+		return time.Since(*last)
+	}
+	return 0
+}
+
+// startPingLoop runs a new ping loop with its own schedule. The stop
+// channel of the previous loop is closed, so a loop that was abandoned as
+// stuck returns as soon as its cycle finishes.
+func (pm *PingMonitor) startPingLoop() {
+	stop := make(chan struct{})
+	if pm.loopStop != nil {
+		close(pm.loopStop)
+	}
+	pm.loopStop = stop
+	pm.markCycle()
+	go pm.pingLoop(stop)
+}
+
+// watchdogLoop checks the ping loop until the monitor stops. The state it
+// reads is atomic, so a loop stuck holding the monitor's lock does not
+// stop the check.
+func (pm *PingMonitor) watchdogLoop() {
+	ticker := time.NewTicker(max(pm.pingInterval, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.stopChan:
+			return
+		case <-ticker.C:
+		}
+		pm.checkWatchdog()
+	}
+}
+
+// checkWatchdog raises a stall once per stall and acts on it. The stall
+// lasts until the loop completes a cycle; a restarted loop that stalls
+// again before that is not restarted twice, the process exits instead.
+func (pm *PingMonitor) checkWatchdog() {
+	cycles := pm.cycles.Load()
+	if pm.stalled.Load() && cycles != pm.stallCycles {
+		pm.stalled.Store(false)
+		pm.watchdogRestarted = false
+		fmt.Printf("✅ 計測ループが再開しました（%s）\n", time.Now().Format("15:04:05"))
+	}
+	since := pm.sinceCycle()
+	if since < pm.stallLimit() || (pm.stalled.Load() && !pm.watchdogRestarted) {
+		return
+	}
+	action := pm.config.Watchdog.Action
+	if pm.watchdogRestarted {
+		action = watchdogExit
+	}
+	pm.stalled.Store(true)
+	pm.stallCycles = cycles
+	pm.raiseStall(since, action)
+
+	switch action {
+	case watchdogRestart:
+		pm.audit.record(AuditEntry{Action: "watchdog_restart", Actor: "watchdog", Detail: map[string]string{"stalled": since.Round(time.Second).String()}})
+		pm.watchdogRestarted = true
+		pm.startPingLoop()
+	case watchdogExit:
+		pm.audit.record(AuditEntry{Action: "watchdog_exit", Actor: "watchdog", Detail: map[string]string{"stalled": since.Round(time.Second).String()}})
+		// Give the alert a moment to go out before systemd restarts us
+		pm.dispatcher.closeWithin(5 * time.Second)
+		exit(1)
+	}
+}
+
+// raiseStall logs the stall and sends the operational warning
+func (pm *PingMonitor) raiseStall(since time.Duration, action string) {
+	outcome := map[string]string{
+		watchdogAlert:   "計測ループはそのままです。プロセスの再起動が必要です。",
+		watchdogRestart: "計測ループを作り直して再開します。",
+		watchdogExit:    "プロセスを終了します（systemdなどによる再起動を想定）。",
+	}[action]
+	lines := []string{
+		"",
+		"🚨🚨🚨 計測ループが停止しています 🚨🚨🚨",
+		fmt.Sprintf("最後の計測完了から %s 経過しました（上限 %s）", pm.format.duration(since), pm.format.duration(pm.stallLimit())),
+		outcome,
+		"",
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	pm.notifyOps(Event{
+		Time:  time.Now(),
+		Title: "🚨 計測ループの停止",
+		Message: fmt.Sprintf("**最後の計測完了から**: %s（上限 %s）\n%s\nこの間の計測は行われていません。",
+			pm.format.duration(since), pm.format.duration(pm.stallLimit()), outcome),
+		Data: map[string]interface{}{
+			"check":       "watchdog",
+			"stalled_sec": since.Seconds(),
+			"action":      action,
+		},
+	})
+}
+
+// health reports whether the ping loop is completing cycles
+func (pm *PingMonitor) health() (int, HealthResponse) {
+	resp := HealthResponse{Status: "ok", LastCycle: pm.lastCycle.Load()}
+	if pm.stalled.Load() || pm.sinceCycle() >= pm.stallLimit() {
+		resp.Status = "stalled"
+		return http.StatusServiceUnavailable, resp
+	}
+	return http.StatusOK, resp
+}
+
+// handleHealthz reports the health of the monitor itself, without taking
+// the monitor's lock
+func (s *apiServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	code, resp := s.pm.health()
+	resp.Schema = api.SchemaHealth
+	writeJSON(w, code, resp)
+}
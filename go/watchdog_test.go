@@ -0,0 +1,250 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestSlowReportSendDoesNotTripWatchdog runs the real ping loop across a
+// midnight whose report send never finishes: the loop must keep completing
+// cycles, so the watchdog neither restarts it nor exits
+func TestSlowReportSendDoesNotTripWatchdog(t *testing.T) {
+	pm, _ := newTestMonitor(t, map[string]interface{}{
+		"ping_interval": "100ms",
+		"ping_timeout":  "100ms",
+		"watchdog":      map[string]interface{}{"stall_intervals": 1, "action": watchdogExit},
+	}, lateEvening)
+	// Half a second before midnight, on the loop's own clock
+	offset := lateEvening.Add(9500 * time.Millisecond).Sub(time.Now())
+	pm.now = func() time.Time { return time.Now().Add(offset) }
+
+	exited := make(chan int, 1)
+	saved := exit
+	exit = func(code int) { exited <- code }
+	defer func() { exit = saved }()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pm.reports.emit = func(p *reportPeriod) {
+		close(started)
+		<-release
+	}
+	defer close(release)
+
+	pm.startPingLoop()
+	defer close(pm.stopChan)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the day was never closed")
+	}
+	cycles := pm.cycles.Load()
+	deadline := time.Now().Add(5 * time.Second)
+	for pm.cycles.Load() < cycles+5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the loop stopped during the send: %d cycles", pm.cycles.Load()-cycles)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	pm.checkWatchdog()
+	select {
+	case code := <-exited:
+		t.Fatalf("watchdog exited with %d during the send", code)
+	default:
+	}
+	if code, resp := pm.health(); code != 200 {
+		t.Errorf("health %d %s during the send", code, resp.Status)
+	}
+	if pm.stalled.Load() {
+		t.Error("loop marked as stalled")
+	}
+}
+
+// newStalledMonitor runs the real ping loop with a prober that never
+// answers until release is closed and returns once the first probe hangs.
+// probes receives every call of the prober.
+func newStalledMonitor(t *testing.T, action string) (pm *PingMonitor, n *fakeNotifier, probes chan struct{}, release chan struct{}) {
+	t.Helper()
+	quietStdout(t)
+	pm, _ = newTestMonitor(t, map[string]interface{}{
+		"ping_interval": "100ms",
+		"ping_timeout":  "100ms",
+		"watchdog":      map[string]interface{}{"stall_intervals": 1, "action": action},
+	}, time.Now())
+	n = &fakeNotifier{name: "ops"}
+	notifyTo(pm, n)
+	probes, release = make(chan struct{}, 10), make(chan struct{})
+	pm.prober = probeFunc(func(string) (float64, error) {
+		probes <- struct{}{}
+		<-release
+		return 10, nil
+	})
+	pm.startPingLoop()
+	t.Cleanup(func() { close(pm.stopChan) })
+	waitProbe(t, probes)
+	return pm, n, probes, release
+}
+
+// waitProbe waits for the next call of the prober
+func waitProbe(t *testing.T, probes chan struct{}) {
+	t.Helper()
+	select {
+	case <-probes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the loop never probed")
+	}
+}
+
+// backdateCycle makes the last completed cycle older than the stall limit,
+// as if the hung probe had lasted that long
+func backdateCycle(pm *PingMonitor) {
+	last := time.Now().Add(-2 * watchdogMinStall)
+	pm.lastCycle.Store(&last)
+}
+
+// stallAlerts returns the actions of the watchdog alerts delivered
+func stallAlerts(pm *PingMonitor, n *fakeNotifier) []string {
+	pm.dispatcher.flush()
+	var actions []string
+	for _, ev := range n.events() {
+		if ev.Kind == EventOps && ev.Data["check"] == "watchdog" {
+			actions = append(actions, ev.Data["action"].(string))
+		}
+	}
+	return actions
+}
+
+// auditActions returns the actions of the audit log, newest first
+func auditActions(pm *PingMonitor) []string {
+	var actions []string
+	for _, e := range pm.audit.entries() {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+// TestWatchdogAlert raises one alert for a hung probe, reports unhealthy
+// until the loop completes a cycle again, and then clears the stall
+func TestWatchdogAlert(t *testing.T) {
+	pm, n, _, release := newStalledMonitor(t, watchdogAlert)
+	pm.checkWatchdog()
+	if code, _ := pm.health(); code != http.StatusOK {
+		t.Errorf("health %d before the stall limit", code)
+	}
+
+	backdateCycle(pm)
+	stop := pm.loopStop
+	pm.checkWatchdog()
+	pm.checkWatchdog()
+	if got := stallAlerts(pm, n); !slices.Equal(got, []string{watchdogAlert}) {
+		t.Errorf("alerts %v, want one alert", got)
+	}
+	if code, resp := pm.health(); code != http.StatusServiceUnavailable || resp.Status != "stalled" {
+		t.Errorf("health %d %s while stalled", code, resp.Status)
+	}
+	if pm.loopStop != stop || len(auditActions(pm)) != 0 {
+		t.Errorf("alert only acted: %v", auditActions(pm))
+	}
+
+	// The probe returns at last and the loop completes its cycle
+	cycles := pm.cycles.Load()
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for pm.cycles.Load() == cycles {
+		if time.Now().After(deadline) {
+			t.Fatal("the loop did not resume")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pm.checkWatchdog()
+	if code, _ := pm.health(); pm.stalled.Load() || code != http.StatusOK {
+		t.Errorf("still stalled after the loop resumed: health %d", code)
+	}
+}
+
+// TestWatchdogRestart restarts a hung loop once; when the new loop hangs
+// too, the restart budget is used up and the process exits
+func TestWatchdogRestart(t *testing.T) {
+	pm, n, probes, _ := newStalledMonitor(t, watchdogRestart)
+	exited := make(chan int, 1)
+	saved := exit
+	exit = func(code int) { exited <- code }
+	t.Cleanup(func() { exit = saved })
+
+	backdateCycle(pm)
+	stop := pm.loopStop
+	pm.checkWatchdog()
+	if pm.loopStop == stop {
+		t.Fatal("loop not restarted")
+	}
+	select {
+	case <-stop:
+	default:
+		t.Error("the stuck loop was not told to stop")
+	}
+	// The new loop probes on its own schedule, and hangs as well
+	waitProbe(t, probes)
+	if got := auditActions(pm); !slices.Equal(got, []string{"watchdog_restart"}) {
+		t.Errorf("audit %v", got)
+	}
+	// Within the stall limit of the restart the new loop is given time
+	pm.checkWatchdog()
+	select {
+	case code := <-exited:
+		t.Fatalf("exited with %d right after the restart", code)
+	default:
+	}
+
+	backdateCycle(pm)
+	pm.checkWatchdog()
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("exit code %d, want 1", code)
+		}
+	default:
+		t.Fatal("no exit once the restart was used up")
+	}
+	if got := auditActions(pm); !slices.Equal(got, []string{"watchdog_exit", "watchdog_restart"}) {
+		t.Errorf("audit %v", got)
+	}
+	// The exit closed the dispatcher after delivering the alerts
+	if got := n.events(); len(got) != 2 || got[0].Data["action"] != watchdogRestart || got[1].Data["action"] != watchdogExit {
+		t.Errorf("alerts %+v", got)
+	}
+	pm.dispatcher = newDispatcher(nil, nil, nil, textStyle{}, pm.now)
+}
+
+// TestWatchdogExit exits at the first stall with action exit
+func TestWatchdogExit(t *testing.T) {
+	pm, n, _, _ := newStalledMonitor(t, watchdogExit)
+	exited := make(chan int, 1)
+	saved := exit
+	exit = func(code int) { exited <- code }
+	t.Cleanup(func() { exit = saved })
+
+	backdateCycle(pm)
+	stop := pm.loopStop
+	pm.checkWatchdog()
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("exit code %d, want 1", code)
+		}
+	default:
+		t.Fatal("no exit")
+	}
+	if pm.loopStop != stop {
+		t.Error("loop restarted with action exit")
+	}
+	if got := n.events(); len(got) != 1 || got[0].Data["action"] != watchdogExit {
+		t.Errorf("alerts %+v", got)
+	}
+	if code, _ := pm.health(); code != http.StatusServiceUnavailable {
+		t.Errorf("health %d", code)
+	}
+	pm.dispatcher = newDispatcher(nil, nil, nil, textStyle{}, pm.now)
+}
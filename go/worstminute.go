@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// worstWindow is the length of the window slid over each hour; short
+// glitches such as a DSL resync vanish in hourly averages but not here
+const worstWindow = time.Minute
+
+// worstMinute is the worst one-minute windows starting in an hour
+type worstMinute struct {
+	Hour int
+	// LossAt starts the window with the highest loss; zero without loss
+	LossAt time.Time
+	Loss   float64
+	// P95At starts the window with the highest p95; zero without successes
+	P95At time.Time
+	P95   float64
+}
+
+// windowSample is one non-warm-up sample of a period
+type windowSample struct {
+	at time.Time
+	ok bool
+	ms float64
+}
+
+// worstMinutes slides a one-minute window over the period, starting at
+// every sample, and keeps each hour's worst loss and worst p95. Windows
+// holding less than half the expected samples, such as the last one before
+// a restart, are skipped.
+func (p *reportPeriod) worstMinutes() []worstMinute {
+	return p.Samples.worst.result()
+}
+
+// worstTracker slides the one-minute window of worstMinutes over samples
+// arriving in time order. A window is closed when a sample arrives a
+// minute after its start, so only the last minute is kept.
+type worstTracker struct {
+	need int
+	// pending are the samples whose windows are still open; all of them
+	// fall in the window of the first
+	pending []windowSample
+	hours   [24]worstMinute
+	seen    [24]bool
+	times   []float64
+}
+
+// add closes the windows s ends and keeps s
+func (w *worstTracker) add(s windowSample) {
+	for len(w.pending) > 0 && !s.at.Before(w.pending[0].at.Add(worstWindow)) {
+		w.close()
+	}
+	w.pending = append(w.pending, s)
+}
+
+// close evaluates the window starting at the first pending sample
+func (w *worstTracker) close() {
+	s := w.pending[0]
+	window := w.pending
+	w.pending = w.pending[1:]
+	if len(window) < w.need {
+		return
+	}
+	h := s.at.Hour()
+	wm := &w.hours[h]
+	if !w.seen[h] {
+		*wm, w.seen[h] = worstMinute{Hour: h}, true
+	}
+	failures := 0
+	w.times = w.times[:0]
+	for _, t := range window {
+		if t.ok {
+			w.times = append(w.times, t.ms)
+		} else {
+			failures++
+		}
+	}
+	// A worst window can always start at a failure, which makes its start
+	// the start of the glitch
+	if loss := float64(failures) / float64(len(window)) * 100; !s.ok && loss > wm.Loss {
+		wm.Loss, wm.LossAt = loss, s.at
+	}
+	if len(w.times) > 0 {
+		sort.Float64s(w.times)
+		if p95 := percentile(w.times, 95); p95 > wm.P95 {
+			wm.P95, wm.P95At = p95, s.at
+		}
+	}
+}
+
+// result closes the windows still open on a copy, as if no sample
+// followed, and returns each hour's worst minutes
+func (w *worstTracker) result() []worstMinute {
+	c := *w
+	c.pending = slices.Clone(w.pending)
+	c.times = nil
+	for len(c.pending) > 0 {
+		c.close()
+	}
+	var worst []worstMinute
+	for h, ok := range c.seen {
+		if ok {
+			worst = append(worst, c.hours[h])
+		}
+	}
+	return worst
+}
+
+// worstMinuteTable renders each hour's worst minutes as a compact table.
+// Minutes with at least worst_minute_loss are marked "◀", and "↻" when the
+// same hour was marked the day before, so a nightly blip stands out across
+// reports. Empty with fewer than two hours, like the sparklines.
+func (pm *PingMonitor) worstMinuteTable(p *reportPeriod) string {
+	worst := p.worstMinutes()
+	if len(worst) < 2 {
+		return ""
+	}
+	threshold := pm.config.WorstMinuteLoss
+	previous := map[int]bool{}
+	if day, err := time.ParseInLocation(reportDateLayout, p.Date, time.Local); err == nil && pm.history != nil {
+		if h, err := pm.history.loadDay(day.AddDate(0, 0, -1).Format(reportDateLayout)); err == nil {
+			for _, hour := range h.Hours {
+				previous[hour.Hour] = hour.WorstMinuteLoss >= threshold
+			}
+		}
+	}
+
+	// The connection is only worth a column on a day that switched
+	switched := len(p.ConnectionChanges) > 0
+	lines := []string{"損失最大の1分     p95最大の1分"}
+	if switched {
+		lines[0] += "       接続"
+	}
+	for _, w := range worst {
+		loss := fmt.Sprintf("%02d:--:--    -   ", w.Hour)
+		if !w.LossAt.IsZero() {
+			mark := "  "
+			if w.Loss >= threshold {
+				mark = "◀ "
+				if previous[w.Hour] {
+					mark = "◀↻"
+				}
+			}
+			loss = fmt.Sprintf("%s %4.0f%%%s", w.LossAt.Format("15:04:05"), w.Loss, mark)
+		}
+		latency := "-"
+		if !w.P95At.IsZero() {
+			latency = fmt.Sprintf("%s %s", w.P95At.Format("15:04:05"), p.Format.ms(w.P95))
+		}
+		line := loss + "  " + latency
+		if switched {
+			line = fmt.Sprintf("%s  %-16s  %s", loss, latency, p.hourConnection(w.Hour).label())
+		}
+		lines = append(lines, line)
+	}
+	return "```\n" + strings.Join(lines, "\n") + "\n```"
+}